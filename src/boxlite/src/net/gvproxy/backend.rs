@@ -0,0 +1,73 @@
+//! Routes gvproxy calls to the linked library or the helper process
+//!
+//! With the `gvproxy` feature, calls go to the linked library ([`super::ffi`])
+//! unless a helper binary is configured; with only `gvproxy-helper`, they
+//! always go to the helper process ([`super::helper`]). Helper mode is Unix
+//! only; elsewhere every call goes to the linked library.
+
+#[cfg(unix)]
+use boxlite_shared::errors::BoxliteResult;
+
+#[cfg(unix)]
+use super::config::GvproxyConfig;
+#[cfg(all(unix, feature = "gvproxy"))]
+use super::ffi;
+#[cfg(unix)]
+use super::helper;
+
+#[cfg(not(unix))]
+pub(crate) use super::ffi::{create_instance, destroy_instance, get_stats_json, get_version};
+
+/// Whether calls go to a helper process although the library is linked.
+#[cfg(feature = "gvproxy")]
+pub(crate) fn helper_enabled() -> bool {
+    #[cfg(unix)]
+    let enabled = helper::enabled();
+    #[cfg(not(unix))]
+    let enabled = false;
+    enabled
+}
+
+#[cfg(unix)]
+pub(crate) fn create_instance(config: &GvproxyConfig) -> BoxliteResult<i64> {
+    #[cfg(feature = "gvproxy")]
+    {
+        if !helper_enabled() {
+            return ffi::create_instance(config);
+        }
+    }
+    helper::create_instance(config)
+}
+
+#[cfg(unix)]
+pub(crate) fn destroy_instance(id: i64) -> BoxliteResult<()> {
+    #[cfg(feature = "gvproxy")]
+    {
+        if !helper_enabled() {
+            return ffi::destroy_instance(id);
+        }
+    }
+    helper::destroy_instance(id)
+}
+
+#[cfg(unix)]
+pub(crate) fn get_version() -> BoxliteResult<String> {
+    #[cfg(feature = "gvproxy")]
+    {
+        if !helper_enabled() {
+            return ffi::get_version();
+        }
+    }
+    helper::get_version()
+}
+
+#[cfg(unix)]
+pub(crate) fn get_stats_json(id: i64) -> BoxliteResult<String> {
+    #[cfg(feature = "gvproxy")]
+    {
+        if !helper_enabled() {
+            return ffi::get_stats_json(id);
+        }
+    }
+    helper::get_stats_json(id)
+}
@@ -11,20 +11,52 @@ use std::path::PathBuf;
 pub struct DnsZone {
     /// Zone name (e.g., "myapp.local.", "." for root)
     pub name: String,
-    /// Exact A records within this zone.
+    /// Records within this zone (A, AAAA, CNAME, TXT and SRV).
     #[serde(default, skip_serializing_if = "Vec::is_empty")]
     pub records: Vec<DnsRecord>,
     /// Default IP for unmatched queries in this zone
     pub default_ip: String,
 }
 
-/// Exact A record within a local DNS zone.
-#[derive(Debug, Clone, Serialize, Deserialize, PartialEq, Eq)]
+/// Record within a local DNS zone.
+///
+/// `record_type` selects the fields used: "A" (the default) and "AAAA" use
+/// `ip`, "CNAME" uses `target`, "TXT" uses `text`, and "SRV" uses `target`,
+/// `port`, `priority` and `weight`. An A record may set `regexp` instead of
+/// `name` to answer every matching name in the zone.
+#[derive(Debug, Clone, Default, Serialize, Deserialize, PartialEq, Eq)]
 pub struct DnsRecord {
     /// Record label within the zone (e.g., "host" in "boxlite.internal.")
+    #[serde(default)]
     pub name: String,
-    /// IPv4 address returned for this record
+    /// Regular expression matched against labels within the zone (A only)
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub regexp: String,
+    /// Record type; empty means "A"
+    #[serde(rename = "type", default, skip_serializing_if = "String::is_empty")]
+    pub record_type: String,
+    /// IPv4 (A) or IPv6 (AAAA) address returned for this record
+    #[serde(default, skip_serializing_if = "String::is_empty")]
     pub ip: String,
+    /// CNAME or SRV target
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub target: String,
+    /// SRV port
+    #[serde(default, skip_serializing_if = "is_zero")]
+    pub port: u16,
+    /// SRV priority
+    #[serde(default, skip_serializing_if = "is_zero")]
+    pub priority: u16,
+    /// SRV weight
+    #[serde(default, skip_serializing_if = "is_zero")]
+    pub weight: u16,
+    /// TXT strings
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub text: Vec<String>,
+}
+
+fn is_zero(v: &u16) -> bool {
+    *v == 0
 }
 
 /// Port mapping configuration
@@ -212,6 +244,7 @@ fn boxlite_internal_dns_zone() -> DnsZone {
         records: vec![DnsRecord {
             name: HOST_ALIAS_LABEL.to_string(),
             ip: HOST_IP.to_string(),
+            ..Default::default()
         }],
         // Empty default_ip means this zone only serves the exact `host` record.
         default_ip: String::new(),
@@ -351,6 +384,7 @@ mod tests {
             vec![DnsRecord {
                 name: HOST_ALIAS_LABEL.to_string(),
                 ip: HOST_IP.to_string(),
+                ..Default::default()
             }]
         );
         assert!(config.dns_zones[0].default_ip.is_empty());
@@ -551,6 +585,30 @@ mod tests {
         assert_ne!(config_a.socket_path, config_b.socket_path);
     }
 
+    #[test]
+    fn test_dns_record_serializes_only_set_fields() {
+        let srv = DnsRecord {
+            name: "_http._tcp".to_string(),
+            record_type: "SRV".to_string(),
+            target: "web.example.internal.".to_string(),
+            port: 8080,
+            priority: 10,
+            ..Default::default()
+        };
+        let json = serde_json::to_value(&srv).unwrap();
+        assert_eq!(
+            json,
+            serde_json::json!({
+                "name": "_http._tcp",
+                "type": "SRV",
+                "target": "web.example.internal.",
+                "port": 8080,
+                "priority": 10,
+            })
+        );
+        assert_eq!(serde_json::from_value::<DnsRecord>(json).unwrap(), srv);
+    }
+
     #[test]
     fn test_with_dns_zones_preserves_builtin_host_alias() {
         let config = GvproxyConfig::new(test_socket_path(), vec![]).with_dns_zones(vec![DnsZone {
@@ -558,6 +616,7 @@ mod tests {
             records: vec![DnsRecord {
                 name: "api".to_string(),
                 ip: "192.168.127.10".to_string(),
+                ..Default::default()
             }],
             default_ip: String::new(),
         }]);
@@ -9,7 +9,9 @@ use boxlite_shared::errors::{BoxliteError, BoxliteResult};
 
 use super::config::GvproxyConfig;
 use libgvproxy_sys::{
-    gvproxy_create, gvproxy_destroy, gvproxy_free_string, gvproxy_get_stats, gvproxy_get_version,
+    GVPROXY_ERR_CONFIG, GVPROXY_ERR_LIMIT, GVPROXY_ERR_PORT_CONFLICT, GVPROXY_ERR_SOCKET,
+    GVPROXY_ERR_UNSUPPORTED, gvproxy_create, gvproxy_destroy, gvproxy_free_string,
+    gvproxy_get_stats, gvproxy_get_version,
 };
 
 /// Create a new gvproxy instance with full configuration
@@ -46,9 +48,17 @@ pub fn create_instance(config: &GvproxyConfig) -> BoxliteResult<i64> {
             unsafe { gvproxy_free_string(err_ptr) };
             s
         };
+        let class = match id {
+            GVPROXY_ERR_CONFIG => "invalid config",
+            GVPROXY_ERR_SOCKET => "VM socket unavailable",
+            GVPROXY_ERR_PORT_CONFLICT => "host port conflict",
+            GVPROXY_ERR_UNSUPPORTED => "unsupported on this platform",
+            GVPROXY_ERR_LIMIT => "limit reached",
+            _ => "error",
+        };
         return Err(BoxliteError::Network(format!(
-            "gvproxy_create failed: {}",
-            detail
+            "gvproxy_create failed ({}): {}",
+            class, detail
         )));
     }
 
@@ -0,0 +1,267 @@
+//! Out-of-process gvproxy: the bridge as a supervised helper process
+//!
+//! Linking the Go runtime into this process means both runtimes share signals
+//! and threads, which some embedders cannot afford. When a helper binary is
+//! configured, [`super::ffi`] routes every call to a `gvproxy-bridge`
+//! executable (built with `go build -o gvproxy-bridge .` in
+//! `libgvproxy-sys/gvproxy-bridge`) instead of the linked library, over the
+//! helper's control socket. See `helper.go` there for the protocol.
+//!
+//! The helper binary is selected by the `BOXLITE_GVPROXY_HELPER` environment
+//! variable at run time, falling back to the same variable at build time;
+//! unset or empty keeps gvproxy in-process. The Go runtime of a linked
+//! library starts when the process loads, though, so full isolation needs a
+//! build with the `gvproxy-helper` feature instead of `gvproxy`: then nothing
+//! is linked, and the helper defaults to `gvproxy-bridge` next to the
+//! current executable.
+//!
+//! The helper is spawned on first use and supervised through its stdin: it
+//! tears down every instance and exits when this process exits. If it dies
+//! first, calls fail with an error; it is not restarted.
+//!
+//! The helper pushes events on the control socket between responses. A
+//! reader thread drains them as they arrive, calls or not, so the helper never
+//! blocks on a full socket; they are logged under the `gvproxy` target, like
+//! the helper's log lines.
+
+use std::io::{BufRead, BufReader, Write};
+use std::os::unix::net::UnixStream;
+use std::path::PathBuf;
+use std::process::{Child, ChildStdin, Command, Stdio};
+use std::sync::mpsc;
+use std::sync::{Mutex, OnceLock};
+use std::time::Duration;
+
+use boxlite_shared::errors::{BoxliteError, BoxliteResult};
+use serde_json::{Value, json};
+use tempfile::TempDir;
+
+use super::config::GvproxyConfig;
+
+/// Environment variable naming the helper binary.
+const HELPER_ENV: &str = "BOXLITE_GVPROXY_HELPER";
+
+/// How long the helper may take to bind its control socket.
+const READY_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// A running helper process and a connection to its control socket.
+struct Helper {
+    _child: Child,
+    // Held open for supervision: the helper exits when it is closed.
+    _stdin: ChildStdin,
+    stream: UnixStream,
+    // Responses from the reader thread; disconnected once the helper exits.
+    responses: mpsc::Receiver<Result<Value, String>>,
+    next_id: u64,
+    // Private (0700) directory holding the control socket; removed on drop.
+    _socket_dir: TempDir,
+}
+
+/// The helper binary to use, if helper mode is selected.
+fn helper_binary() -> Option<PathBuf> {
+    let configured = std::env::var(HELPER_ENV)
+        .ok()
+        .or_else(|| option_env!("BOXLITE_GVPROXY_HELPER").map(str::to_string))
+        .filter(|path| !path.is_empty())
+        .map(PathBuf::from);
+    if configured.is_some() || cfg!(feature = "gvproxy") {
+        return configured;
+    }
+    // Nothing linked in-process: default to the helper shipped alongside.
+    std::env::current_exe()
+        .ok()
+        .map(|exe| exe.with_file_name("gvproxy-bridge"))
+}
+
+/// Whether gvproxy calls go to a helper process.
+#[cfg(feature = "gvproxy")]
+pub(crate) fn enabled() -> bool {
+    helper_binary().is_some()
+}
+
+impl Helper {
+    fn spawn(binary: PathBuf) -> BoxliteResult<Self> {
+        // A fresh private directory per spawn: no stale socket from an
+        // earlier process can be in the way, and no other user can bind or
+        // replace the socket before the helper does.
+        let socket_dir = tempfile::Builder::new()
+            .prefix("boxlite-gvproxy-")
+            .tempdir()
+            .map_err(|e| {
+                BoxliteError::Network(format!("Failed to create gvproxy helper socket dir: {}", e))
+            })?;
+        let socket_path = socket_dir.path().join("ctl.sock");
+
+        let mut child = Command::new(&binary)
+            .arg("-socket")
+            .arg(&socket_path)
+            .stdin(Stdio::piped())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .spawn()
+            .map_err(|e| {
+                BoxliteError::Network(format!(
+                    "Failed to spawn gvproxy helper {}: {}",
+                    binary.display(),
+                    e
+                ))
+            })?;
+        let stdin = child.stdin.take().expect("stdin is piped");
+        let stdout = child.stdout.take().expect("stdout is piped");
+        let stderr = child.stderr.take().expect("stderr is piped");
+
+        // Helper logs arrive on stderr; forward them like the in-process
+        // log callback does.
+        std::thread::spawn(move || {
+            for line in BufReader::new(stderr).lines().map_while(Result::ok) {
+                tracing::info!(target: "gvproxy", "{}", line);
+            }
+        });
+
+        let (ready_tx, ready_rx) = mpsc::channel();
+        std::thread::spawn(move || {
+            let mut line = String::new();
+            let _ = BufReader::new(stdout).read_line(&mut line);
+            let _ = ready_tx.send(line);
+        });
+        match ready_rx.recv_timeout(READY_TIMEOUT) {
+            Ok(line) if line.trim() == "ready" => {}
+            _ => {
+                let _ = child.kill();
+                let _ = child.wait();
+                return Err(BoxliteError::Network(format!(
+                    "gvproxy helper {} did not become ready",
+                    binary.display()
+                )));
+            }
+        }
+
+        let stream = UnixStream::connect(&socket_path).map_err(|e| {
+            BoxliteError::Network(format!("Failed to connect to gvproxy helper: {}", e))
+        })?;
+        let reader = BufReader::new(stream.try_clone().map_err(|e| {
+            BoxliteError::Network(format!("Failed to connect to gvproxy helper: {}", e))
+        })?);
+        let (responses_tx, responses) = mpsc::channel();
+        std::thread::spawn(move || read_helper(reader, responses_tx));
+
+        tracing::info!(binary = %binary.display(), pid = child.id(), "Started gvproxy helper process");
+
+        Ok(Self {
+            _child: child,
+            _stdin: stdin,
+            stream,
+            responses,
+            next_id: 1,
+            _socket_dir: socket_dir,
+        })
+    }
+
+    /// Send one request and wait for its response.
+    fn call(&mut self, method: &str, params: Value) -> BoxliteResult<Value> {
+        let id = self.next_id;
+        self.next_id += 1;
+
+        let mut line = json!({ "id": id, "method": method, "params": params }).to_string();
+        line.push('\n');
+        self.stream
+            .write_all(line.as_bytes())
+            .map_err(|e| BoxliteError::Network(format!("gvproxy helper write failed: {}", e)))?;
+
+        loop {
+            let resp = self
+                .responses
+                .recv()
+                .map_err(|_| BoxliteError::Network("gvproxy helper exited".to_string()))?
+                .map_err(BoxliteError::Network)?;
+            if resp.get("id").and_then(Value::as_u64) != Some(id) {
+                continue; // the response to an earlier, failed call
+            }
+            if let Some(err) = resp.get("error").and_then(Value::as_str) {
+                return Err(BoxliteError::Network(err.to_string()));
+            }
+            return Ok(resp.get("result").cloned().unwrap_or(Value::Null));
+        }
+    }
+}
+
+/// Read the helper's control socket until it closes: pushed events are
+/// logged here, responses handed to the waiting call.
+fn read_helper(reader: BufReader<UnixStream>, responses: mpsc::Sender<Result<Value, String>>) {
+    for line in reader.lines().map_while(Result::ok) {
+        let msg: Value = match serde_json::from_str(&line) {
+            Ok(msg) => msg,
+            Err(e) => {
+                let _ = responses.send(Err(format!("Invalid gvproxy helper response: {}", e)));
+                continue;
+            }
+        };
+        if let Some(event) = msg.get("event") {
+            tracing::debug!(target: "gvproxy", event = %event, "gvproxy event");
+        } else if let Some(dropped) = msg.get("dropped").and_then(Value::as_u64) {
+            tracing::warn!(target: "gvproxy", dropped, "gvproxy helper dropped events");
+        } else if responses.send(Ok(msg)).is_err() {
+            return; // the helper was dropped
+        }
+    }
+}
+
+/// Run `method` on the process-wide helper, spawning it on first use.
+fn call(method: &str, params: Value) -> BoxliteResult<Value> {
+    static HELPER: OnceLock<Mutex<Option<Helper>>> = OnceLock::new();
+
+    let binary = helper_binary()
+        .ok_or_else(|| BoxliteError::Network(format!("{} is not set", HELPER_ENV)))?;
+    let mut helper = HELPER
+        .get_or_init(|| Mutex::new(None))
+        .lock()
+        .map_err(|_| BoxliteError::Network("gvproxy helper lock poisoned".to_string()))?;
+    if helper.is_none() {
+        *helper = Some(Helper::spawn(binary)?);
+    }
+    helper
+        .as_mut()
+        .expect("helper was just spawned")
+        .call(method, params)
+}
+
+/// Create a gvproxy instance in the helper (see `ffi::create_instance`).
+pub(crate) fn create_instance(config: &GvproxyConfig) -> BoxliteResult<i64> {
+    let params = serde_json::to_value(config)
+        .map_err(|e| BoxliteError::Network(format!("Failed to serialize config: {}", e)))?;
+    let id = call("create", params)
+        .map_err(|e| BoxliteError::Network(format!("gvproxy_create failed: {}", e)))?
+        .as_i64()
+        .ok_or_else(|| BoxliteError::Network("gvproxy helper returned no id".to_string()))?;
+    tracing::info!(id, "Created gvproxy instance via helper process");
+    Ok(id)
+}
+
+/// Destroy a gvproxy instance in the helper.
+pub(crate) fn destroy_instance(id: i64) -> BoxliteResult<()> {
+    call("destroy", json!({ "id": id })).map_err(|e| {
+        BoxliteError::Network(format!("gvproxy_destroy failed for instance {}: {}", id, e))
+    })?;
+    tracing::info!(id, "Destroyed gvproxy instance via helper process");
+    Ok(())
+}
+
+/// The helper's gvproxy version string.
+pub(crate) fn get_version() -> BoxliteResult<String> {
+    call_string("version", Value::Null)
+}
+
+/// Stats JSON of a gvproxy instance in the helper.
+pub(crate) fn get_stats_json(id: i64) -> BoxliteResult<String> {
+    call_string("stats", json!({ "id": id }))
+}
+
+/// Run a method whose result is a string.
+fn call_string(method: &str, params: Value) -> BoxliteResult<String> {
+    call(method, params)?
+        .as_str()
+        .map(str::to_string)
+        .ok_or_else(|| {
+            BoxliteError::Network(format!("gvproxy helper {} returned no string", method))
+        })
+}
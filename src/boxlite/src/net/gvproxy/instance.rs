@@ -7,7 +7,8 @@ use std::path::{Path, PathBuf};
 
 use boxlite_shared::errors::{BoxliteError, BoxliteResult};
 
-use super::ffi;
+use super::backend;
+#[cfg(feature = "gvproxy")]
 use super::logging;
 use super::stats::NetworkStats;
 
@@ -64,8 +65,14 @@ impl GvproxyInstance {
         ca_cert_pem: Option<&str>,
         ca_key_pem: Option<&str>,
     ) -> BoxliteResult<Self> {
-        // Initialize logging callback (one-time setup)
-        logging::init_logging();
+        // Initialize logging callback (one-time setup). A helper process
+        // logs to stderr instead, forwarded by the helper module.
+        #[cfg(feature = "gvproxy")]
+        {
+            if !backend::helper_enabled() {
+                logging::init_logging();
+            }
+        }
 
         // Derive gvproxy's control socket as a sibling of the data socket, so the
         // path is never plumbed through neutral config/layout/socket types.
@@ -80,7 +87,7 @@ impl GvproxyInstance {
             config = config.with_ca(cert.to_string(), key.to_string());
         }
 
-        let id = ffi::create_instance(&config)?;
+        let id = backend::create_instance(&config)?;
 
         tracing::info!(id, ?socket_path, "Created GvproxyInstance");
 
@@ -145,8 +152,8 @@ impl GvproxyInstance {
     /// Call this on an existing gvproxy instance to inspect bandwidth counters
     /// and debugging metrics such as `forward_max_inflight_drop`.
     pub fn get_stats(&self) -> BoxliteResult<NetworkStats> {
-        // Get JSON from FFI layer
-        let json_str = ffi::get_stats_json(self.id)?;
+        // Get JSON from the library or helper
+        let json_str = backend::get_stats_json(self.id)?;
 
         tracing::debug!("Received stats JSON: {}", json_str);
 
@@ -177,7 +184,7 @@ impl GvproxyInstance {
     /// # Ok::<(), boxlite_shared::errors::BoxliteError>(())
     /// ```
     pub fn version() -> BoxliteResult<String> {
-        ffi::get_version()
+        backend::get_version()
     }
 
     /// Get the instance ID
@@ -192,7 +199,7 @@ impl Drop for GvproxyInstance {
     fn drop(&mut self) {
         tracing::debug!(id = self.id, "Dropping GvproxyInstance");
 
-        match ffi::destroy_instance(self.id) {
+        match backend::destroy_instance(self.id) {
             Ok(()) => tracing::debug!(id = self.id, "Successfully destroyed gvproxy instance"),
             Err(e) => tracing::error!(
                 id = self.id,
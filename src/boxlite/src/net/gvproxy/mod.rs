@@ -11,6 +11,10 @@
 //!   gvproxy through the FFI and produces the VM's
 //!   [`NetworkBackendEndpoint`](super::NetworkBackendEndpoint)
 //! - `logging` — Go `slog` → Rust `tracing` bridge (target `"gvproxy"`)
+//! - `helper` — runs gvproxy in a supervised helper process instead, selected
+//!   with `BOXLITE_GVPROXY_HELPER` or the `gvproxy-helper` feature (Unix
+//!   only: it talks to the helper over a Unix socket); `backend` routes each
+//!   call to `ffi` or `helper`
 //! - `config` / `stats` — the JSON config sent to Go and the stats read back
 //!
 //! ## Logging integration
@@ -27,10 +31,17 @@
 //! - **macOS**: VFKit protocol with `UnixDgram` sockets (`SOCK_DGRAM`)
 //! - **Linux**: Qemu protocol with `UnixStream` sockets (`SOCK_STREAM`)
 
+#[cfg(all(not(unix), feature = "gvproxy-helper", not(feature = "gvproxy")))]
+compile_error!("the gvproxy-helper feature is only supported on Unix");
+
+#[cfg(any(feature = "gvproxy", all(unix, feature = "gvproxy-helper")))]
+mod backend;
 mod config;
 #[cfg(feature = "gvproxy")]
 mod ffi;
-#[cfg(feature = "gvproxy")]
+#[cfg(all(unix, any(feature = "gvproxy", feature = "gvproxy-helper")))]
+mod helper;
+#[cfg(any(feature = "gvproxy", all(unix, feature = "gvproxy-helper")))]
 mod instance;
 #[cfg(feature = "gvproxy")]
 mod logging;
@@ -39,7 +50,7 @@ mod stats;
 
 // Re-export public API
 pub use config::{DnsRecord, DnsZone, GvproxyConfig, GvproxySecretConfig, PortMapping};
-#[cfg(feature = "gvproxy")]
+#[cfg(any(feature = "gvproxy", all(unix, feature = "gvproxy-helper")))]
 pub use instance::GvproxyInstance;
 #[cfg(feature = "gvproxy")]
 pub use logging::init_logging;
@@ -31,6 +31,12 @@ fn build_gvproxy(source_dir: &Path, output_path: &Path) {
         build_cmd.args(["-mod=vendor"]);
     }
 
+    // Debug profiles track C strings returned to Rust so gvproxy_free_string
+    // can report double frees and foreign pointers instead of crashing.
+    if env::var("PROFILE").as_deref() == Ok("debug") {
+        build_cmd.args(["-tags", "gvproxy_debug"]);
+    }
+
     build_cmd.args([
         "-o",
         output_path.to_str().expect("Invalid output path"),
@@ -136,7 +142,12 @@ fn main() {
         println!("cargo:rustc-link-search=native=/usr/lib64");
         println!("cargo:rustc-link-lib=static=resolv");
     }
-    if target_os != "linux" {
+    if target_os == "windows" {
+        // Windows has no libresolv; the Go runtime links against these instead.
+        for lib in ["ws2_32", "userenv", "bcrypt", "ntdll", "winmm"] {
+            println!("cargo:rustc-link-lib={}", lib);
+        }
+    } else if target_os != "linux" {
         println!("cargo:rustc-link-lib=resolv");
     }
 }
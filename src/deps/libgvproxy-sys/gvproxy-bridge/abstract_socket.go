@@ -0,0 +1,51 @@
+package main
+
+// abstract_socket.go — Serve the qemu link on a Linux abstract socket.
+//
+// A filesystem socket leaves an entry behind when the process dies, which
+// the next instance has to remove, and in nested containers the directory
+// it lives in must be shared with the hypervisor's mount namespace. A
+// socket_path starting with "@" (e.g. "@boxlite-vm-1") is bound in the
+// abstract namespace instead: it has no filesystem entry, vanishes with its
+// last descriptor and is reachable from anywhere in the same network
+// namespace. QEMU connects to it with
+// -netdev stream,addr.type=unix,addr.path=boxlite-vm-1,addr.abstract=on.
+//
+// Only the qemu (stream) link supports it, and only on Linux. Abstract
+// sockets have no owner or mode, so socket_permissions does not apply and
+// runtime_dir leaves the name as is.
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+// isAbstractSocket reports whether path names a socket in the Linux
+// abstract namespace.
+func isAbstractSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// checkAbstractSocket validates an abstract socket_path against config.
+func checkAbstractSocket(config GvproxyConfig) error {
+	if !isAbstractSocket(config.SocketPath) {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return classify(createErrUnsupported, fmt.Errorf("abstract socket_path %q is only supported on linux", config.SocketPath))
+	}
+	if len(config.SocketPath) == 1 {
+		return errors.New("abstract socket_path needs a name after \"@\"")
+	}
+	if protocol := types.Protocol(config.Protocol); protocol != "" && protocol != types.QemuProtocol || config.VsockListen != nil || config.LinkFD != nil {
+		return fmt.Errorf("abstract socket_path %q needs the qemu protocol", config.SocketPath)
+	}
+	if config.SocketPermissions != nil {
+		return errors.New("socket_permissions does not apply to an abstract socket_path")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCheckAbstractSocket(t *testing.T) {
+	config := testGvproxyConfig()
+	config.SocketPath = "@boxlite-test"
+	config.Protocol = "qemu"
+	if err := checkAbstractSocket(config); err != nil {
+		t.Fatalf("qemu on an abstract socket: %v", err)
+	}
+	config.Protocol = "vfkit"
+	if err := checkAbstractSocket(config); err == nil {
+		t.Error("vfkit on an abstract socket must be rejected")
+	}
+	config.Protocol = "qemu"
+	config.SocketPermissions = &SocketPermissions{Mode: "0600"}
+	if err := checkAbstractSocket(config); err == nil {
+		t.Error("socket_permissions on an abstract socket must be rejected")
+	}
+	config = testGvproxyConfig()
+	config.SocketPath = "@"
+	if err := checkAbstractSocket(config); err == nil {
+		t.Error("an empty abstract name must be rejected")
+	}
+}
+
+func TestStartInstance_AbstractSocket(t *testing.T) {
+	config := testGvproxyConfig()
+	config.Protocol = "qemu"
+	config.RuntimeDir = t.TempDir()
+	config.SocketPath = fmt.Sprintf("@boxlite-test-%d", os.Getpid())
+	const id = 5923
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+
+	vm, err := net.Dial("unix", config.SocketPath)
+	if err != nil {
+		stopTestInstance(id)
+		t.Fatalf("dial abstract VM socket: %v", err)
+	}
+	resolveGatewayQemu(t, vm, config)
+	vm.Close()
+	if rc := destroyInstanceSync(id, 5*time.Second); rc != 0 {
+		t.Fatalf("destroyInstanceSync = %d", rc)
+	}
+
+	// Nothing is left to clean up: the name is free for the next instance.
+	l, err := net.Listen("unix", config.SocketPath)
+	if err != nil {
+		t.Fatalf("abstract name still bound after destroy: %v", err)
+	}
+	l.Close()
+}
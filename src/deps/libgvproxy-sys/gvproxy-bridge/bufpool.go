@@ -0,0 +1,165 @@
+package main
+
+// bufpool.go — Sized buffer pool for frame handling.
+//
+// Link connections need frame-sized scratch buffers for reassembly and
+// rewriting. Allocating them per connection (and regrowing them per frame)
+// shows up as GC churn under load, so they come from a process-wide pool
+// with a few size classes instead. Each class is a sync.Pool: it grows with
+// demand and is trimmed by the GC when demand drops, so an idle bridge holds
+// no buffers. Hit and miss counters make the churn visible in stats.
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// frameBufferClasses are the pool's buffer sizes: a standard-MTU frame, a
+// jumbo frame, and the largest length-prefixed stream frame.
+var frameBufferClasses = []int{2 << 10, 16 << 10, maxStreamFrameSize + 4}
+
+// framePool serves every link connection in the process.
+var framePool = newBufferPool(frameBufferClasses...)
+
+// bufferClass is one size class of a bufferPool.
+type bufferClass struct {
+	size int
+	pool sync.Pool // of *[]byte with cap == size
+
+	gets    atomic.Uint64
+	misses  atomic.Uint64
+	puts    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// bufferPool hands out byte slices rounded up to the nearest size class.
+// Requests larger than the largest class are allocated directly and counted
+// as oversize.
+type bufferPool struct {
+	classes  []*bufferClass
+	oversize atomic.Uint64
+}
+
+func newBufferPool(sizes ...int) *bufferPool {
+	sorted := append([]int(nil), sizes...)
+	sort.Ints(sorted)
+	p := &bufferPool{}
+	for _, size := range sorted {
+		p.classes = append(p.classes, &bufferClass{size: size})
+	}
+	return p
+}
+
+// class returns the smallest class that fits size, or nil if none does.
+func (p *bufferPool) class(size int) *bufferClass {
+	for _, c := range p.classes {
+		if size <= c.size {
+			return c
+		}
+	}
+	return nil
+}
+
+// get returns a buffer of length size. Its contents are unspecified.
+func (p *bufferPool) get(size int) []byte {
+	c := p.class(size)
+	if c == nil {
+		p.oversize.Add(1)
+		return make([]byte, size)
+	}
+	c.gets.Add(1)
+	if bp, ok := c.pool.Get().(*[]byte); ok {
+		return (*bp)[:size]
+	}
+	c.misses.Add(1)
+	return make([]byte, size, c.size)
+}
+
+// put returns a buffer obtained from get. Buffers whose capacity does not
+// match a class exactly (oversize, or not from this pool) are left to the GC.
+func (p *bufferPool) put(b []byte) {
+	if b == nil {
+		return
+	}
+	c := p.class(cap(b))
+	if c == nil || c.size != cap(b) {
+		if c != nil {
+			c.dropped.Add(1)
+		}
+		return
+	}
+	c.puts.Add(1)
+	b = b[:0]
+	c.pool.Put(&b)
+}
+
+// BufferClassStats describes one size class of the frame buffer pool.
+type BufferClassStats struct {
+	Size    int    `json:"Size"`
+	Gets    uint64 `json:"Gets"`
+	Hits    uint64 `json:"Hits"`
+	Misses  uint64 `json:"Misses"`
+	Puts    uint64 `json:"Puts"`
+	Dropped uint64 `json:"Dropped"`
+}
+
+// BufferPoolStats is the "BufferPool" stats section.
+type BufferPoolStats struct {
+	Classes        []BufferClassStats `json:"Classes"`
+	HitRate        float64            `json:"HitRate"`        // hits / gets across classes; 0 before any get
+	AllocatedBytes uint64             `json:"AllocatedBytes"` // bytes allocated on misses (churn)
+	Oversize       uint64             `json:"Oversize"`       // requests larger than every class
+}
+
+// Stats returns a snapshot of the pool counters.
+func (p *bufferPool) Stats() BufferPoolStats {
+	stats := BufferPoolStats{Oversize: p.oversize.Load()}
+	var gets, hits uint64
+	for _, c := range p.classes {
+		cs := BufferClassStats{
+			Size:    c.size,
+			Gets:    c.gets.Load(),
+			Misses:  c.misses.Load(),
+			Puts:    c.puts.Load(),
+			Dropped: c.dropped.Load(),
+		}
+		if cs.Gets > cs.Misses {
+			cs.Hits = cs.Gets - cs.Misses
+		}
+		gets += cs.Gets
+		hits += cs.Hits
+		stats.AllocatedBytes += cs.Misses * uint64(c.size)
+		stats.Classes = append(stats.Classes, cs)
+	}
+	if gets > 0 {
+		stats.HitRate = float64(hits) / float64(gets)
+	}
+	return stats
+}
+
+// bufferPoolCollector publishes the frame buffer pool as the "BufferPool"
+// section. The pool is process-wide: every instance reports the same values.
+type bufferPoolCollector struct{ p *bufferPool }
+
+func (bufferPoolCollector) Name() string { return "BufferPool" }
+
+func (bufferPoolCollector) Description() string {
+	return "Frame buffer pool per size class: gets, hits, misses (allocations) and hit rate (process-wide)."
+}
+
+func (c bufferPoolCollector) Collect() any { return c.p.Stats() }
+
+func (c bufferPoolCollector) collectOpenMetrics(emit metricEmitter) {
+	stats := c.p.Stats()
+	for _, cs := range stats.Classes {
+		labels := fmt.Sprintf(`size="%d"`, cs.Size)
+		emit(openMetricsPrefix+"buffer_pool_gets", "counter", "Buffers requested from the frame pool.", labels, fmt.Sprint(cs.Gets))
+		emit(openMetricsPrefix+"buffer_pool_misses", "counter", "Frame pool requests that allocated a new buffer.", labels, fmt.Sprint(cs.Misses))
+		emit(openMetricsPrefix+"buffer_pool_dropped", "counter", "Buffers returned to the frame pool but not kept.", labels, fmt.Sprint(cs.Dropped))
+	}
+	emit(openMetricsPrefix+"buffer_pool_hit_ratio", "gauge", "Fraction of frame pool requests served without allocating.", "", formatFloat(stats.HitRate))
+	emit(openMetricsPrefix+"buffer_pool_allocated_bytes", "counter", "Bytes allocated by frame pool misses.", "", fmt.Sprint(stats.AllocatedBytes))
+	emit(openMetricsPrefix+"buffer_pool_oversize", "counter", "Frame pool requests larger than every size class.", "", fmt.Sprint(stats.Oversize))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+func TestBufferPool_RoundsUpToClass(t *testing.T) {
+	p := newBufferPool(4096, 1024)
+
+	b := p.get(1500)
+	if len(b) != 1500 || cap(b) != 4096 {
+		t.Fatalf("expected len 1500 cap 4096, got len %d cap %d", len(b), cap(b))
+	}
+	if big := p.get(8192); len(big) != 8192 {
+		t.Fatalf("oversize request must still be served, got len %d", len(big))
+	}
+	stats := p.Stats()
+	if stats.Oversize != 1 || stats.Classes[0].Size != 1024 || stats.Classes[1].Gets != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestBufferPool_CountsHitsAndChurn(t *testing.T) {
+	p := newBufferPool(1024)
+
+	b := p.get(100)
+	p.put(b)
+	stats := p.Stats()
+	if stats.Classes[0].Misses != 1 || stats.AllocatedBytes != 1024 || stats.Classes[0].Puts != 1 {
+		t.Fatalf("first get should allocate one class buffer: %+v", stats)
+	}
+
+	// sync.Pool may drop buffers at any GC, so only check the invariants.
+	p.put(p.get(200))
+	stats = p.Stats()
+	cs := stats.Classes[0]
+	if cs.Gets != 2 || cs.Hits+cs.Misses != cs.Gets {
+		t.Fatalf("hits and misses must add up to gets: %+v", cs)
+	}
+	if stats.HitRate < 0 || stats.HitRate > 0.5 {
+		t.Fatalf("hit rate out of range: %v", stats.HitRate)
+	}
+}
+
+func TestBufferPool_DropsForeignBuffers(t *testing.T) {
+	p := newBufferPool(1024)
+	p.put(make([]byte, 10))
+	p.put(make([]byte, 4096))
+	p.put(nil)
+
+	cs := p.Stats().Classes[0]
+	if cs.Puts != 0 || cs.Dropped != 1 {
+		t.Fatalf("foreign buffers must not be pooled: %+v", cs)
+	}
+}
+
+// TestLinkConn_ReleasesRxBufferOnFailure checks the reassembly buffer goes
+// back to the pool once the stream ends and its data has been returned.
+func TestLinkConn_ReleasesRxBufferOnFailure(t *testing.T) {
+	vm, gw := net.Pipe()
+	conn := newLinkConn(gw, types.QemuProtocol, nil)
+
+	go func() {
+		vm.Write(qemuFrame("frame")) //nolint:errcheck
+		vm.Close()
+	}()
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if conn.rxStore != nil {
+		t.Fatal("reassembly buffer should be released after EOF")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+// capture_policy.go — Decide what happens when capture_file cannot be
+// written.
+//
+// capture_file is checked before the instance is created, by opening it for
+// writing. capture_policy picks what a failure does:
+//
+//   - "fail-create" (default): gvproxy_create fails with a config error.
+//   - "warn": the instance runs without capture; the error is logged and the
+//     "Capture" stats section reports Status "failed".
+//   - "retry": the instance runs and opening capture_file is retried every
+//     few seconds; capture starts (a capture_started event) once it works.
+//     Meanwhile the sniffer writes to a scratch file that is parked on
+//     /dev/null, so packets before the start are not recorded. Not
+//     available on Windows, where a running capture cannot be moved.
+//
+// The "Capture" stats section reports Status "active", "failed",
+// "retrying" or "stopped" (at max_capture_bytes), with the last error, so a
+// caller that must have a capture can check that one is being written.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+const (
+	capturePolicyFailCreate = "fail-create"
+	capturePolicyWarn       = "warn"
+	capturePolicyRetry      = "retry"
+)
+
+// Capture statuses reported in the "Capture" stats section.
+const (
+	captureStatusActive   = "active"
+	captureStatusFailed   = "failed"
+	captureStatusRetrying = "retrying"
+	captureStatusStopped  = "stopped"
+)
+
+// EventCaptureStarted fires when a capture_policy "retry" capture starts
+// after capture_file became writable. Fields: file, attempts.
+const EventCaptureStarted = "capture_started"
+
+// captureRetryInterval is how often "retry" tries to open capture_file.
+var captureRetryInterval = 5 * time.Second
+
+// checkCapturePolicy validates capture_policy.
+func checkCapturePolicy(config GvproxyConfig) error {
+	switch config.CapturePolicy {
+	case "", capturePolicyFailCreate, capturePolicyWarn:
+		return nil
+	case capturePolicyRetry:
+		if runtime.GOOS == "windows" {
+			return classify(createErrUnsupported, errors.New(`capture_policy "retry" is not supported on windows`))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown capture_policy %q (want fail-create, warn or retry)", config.CapturePolicy)
+}
+
+// captureState is an instance's capture: where it goes and whether it is
+// being written.
+type captureState struct {
+	path    string // capture_file
+	scratch string // where the sniffer starts writing while retrying
+
+	mu     sync.Mutex
+	status string
+	err    error
+}
+
+// probeCaptureFile checks that path can be opened for writing.
+func probeCaptureFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// resolveCapture applies capture_policy to capture_file. It returns nil
+// without a capture_file, and an error only under "fail-create".
+func resolveCapture(config GvproxyConfig) (*captureState, error) {
+	if config.CaptureFile == nil || *config.CaptureFile == "" {
+		return nil, nil
+	}
+	c := &captureState{path: *config.CaptureFile, status: captureStatusActive}
+	err := probeCaptureFile(c.path)
+	if err == nil {
+		return c, nil
+	}
+	switch config.CapturePolicy {
+	case capturePolicyWarn:
+		c.status, c.err = captureStatusFailed, err
+	case capturePolicyRetry:
+		scratch, scratchErr := os.CreateTemp("", "gvproxy-capture-*.pcap")
+		if scratchErr != nil {
+			return nil, fmt.Errorf("capture_file: %w; no scratch file to retry with: %v", err, scratchErr)
+		}
+		scratch.Close()
+		c.scratch = scratch.Name()
+		c.status, c.err = captureStatusRetrying, err
+	default:
+		return nil, fmt.Errorf("capture_file: %w", err)
+	}
+	return c, nil
+}
+
+// sniffPath is the file the sniffer is created on: capture_file, the
+// scratch file while retrying, or "" for no capture.
+func (c *captureState) sniffPath() string {
+	if c == nil || c.status == captureStatusFailed {
+		return ""
+	}
+	if c.scratch != "" {
+		return c.scratch
+	}
+	return c.path
+}
+
+func (c *captureState) set(status string, err error) {
+	c.mu.Lock()
+	c.status, c.err = status, err
+	c.mu.Unlock()
+}
+
+func (c *captureState) get() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status, c.err
+}
+
+// retry parks the sniffer's scratch file on /dev/null and moves it to
+// capture_file once that can be opened.
+func (c *captureState) retry(ctx context.Context, id int64) {
+	captureMu.Lock()
+	fds, header, err := parkCapture(c.scratch)
+	captureMu.Unlock()
+	os.Remove(c.scratch)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id, "file": c.path}).Error("Failed to park capture; capture_file will not be retried")
+		c.set(captureStatusFailed, err)
+		return
+	}
+
+	ticker := time.NewTicker(captureRetryInterval)
+	defer ticker.Stop()
+	for attempts := 1; ; attempts++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		captureMu.Lock()
+		err := resumeCapture(c.path, fds, header)
+		captureMu.Unlock()
+		if err != nil {
+			c.set(captureStatusRetrying, err)
+			logrus.WithFields(logrus.Fields{"error": err, "id": id, "file": c.path, "attempts": attempts}).Debug("capture_file still not writable")
+			continue
+		}
+		c.set(captureStatusActive, nil)
+		logrus.WithFields(logrus.Fields{"id": id, "file": c.path, "attempts": attempts}).Info("Capture started")
+		emitEvent(id, EventCaptureStarted, map[string]any{
+			"file":     c.path,
+			"attempts": attempts,
+		})
+		return
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckCapturePolicy(t *testing.T) {
+	config := testGvproxyConfig()
+	for _, policy := range []string{"", capturePolicyFailCreate, capturePolicyWarn, capturePolicyRetry} {
+		config.CapturePolicy = policy
+		if err := checkCapturePolicy(config); err != nil {
+			t.Errorf("%q: %v", policy, err)
+		}
+	}
+	config.CapturePolicy = "ignore"
+	if err := checkCapturePolicy(config); err == nil {
+		t.Error("expected error for an unknown policy")
+	}
+}
+
+func TestResolveCapture(t *testing.T) {
+	config := testGvproxyConfig()
+	if c, err := resolveCapture(config); c != nil || err != nil {
+		t.Fatalf("no capture_file: %v, %v", c, err)
+	}
+
+	writable := filepath.Join(t.TempDir(), "net.pcap")
+	config.CaptureFile = &writable
+	if c, err := resolveCapture(config); err != nil || c.sniffPath() != writable {
+		t.Fatalf("writable capture_file: %+v, %v", c, err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing", "net.pcap")
+	config.CaptureFile = &missing
+	if _, err := resolveCapture(config); err == nil {
+		t.Fatal("fail-create: expected error")
+	}
+	config.CapturePolicy = capturePolicyWarn
+	c, err := resolveCapture(config)
+	if err != nil {
+		t.Fatalf("warn: %v", err)
+	}
+	if status, _ := c.get(); status != captureStatusFailed || c.sniffPath() != "" {
+		t.Fatalf("warn: status %q, sniffing %q", status, c.sniffPath())
+	}
+}
+
+// captureStatsOf returns instance id's "Capture" stats section.
+func captureStatsOf(t *testing.T, id int64) CaptureStats {
+	t.Helper()
+	instancesMu.RLock()
+	collectors := instances[id].stats.Collectors()
+	instancesMu.RUnlock()
+	for _, c := range collectors {
+		if stats, ok := c.Collect().(CaptureStats); ok {
+			return stats
+		}
+	}
+	t.Fatal("no Capture stats section")
+	return CaptureStats{}
+}
+
+func TestStartInstance_CaptureRetryStartsOnceWritable(t *testing.T) {
+	defer func(interval time.Duration) { captureRetryInterval = interval }(captureRetryInterval)
+	captureRetryInterval = 20 * time.Millisecond
+
+	dir := filepath.Join(t.TempDir(), "captures")
+	path := filepath.Join(dir, "net.pcap")
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	config.Protocol = "qemu"
+	config.CaptureFile = &path
+	config.CapturePolicy = capturePolicyRetry
+	const id = 5930
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	if stats := captureStatsOf(t, id); stats.Status != captureStatusRetrying || stats.Error == "" {
+		t.Fatalf("before the directory exists: %+v", stats)
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitForEvent(t, id, EventCaptureStarted); e.Fields["file"] != path {
+		t.Fatalf("capture_started = %v", e.Fields)
+	}
+	if stats := captureStatsOf(t, id); stats.Status != captureStatusActive || stats.SizeBytes < pcapHeaderSize {
+		t.Fatalf("after the directory exists: %+v", stats)
+	}
+}
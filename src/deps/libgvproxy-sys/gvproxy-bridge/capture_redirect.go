@@ -0,0 +1,109 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// redirectCapture dups to over every open descriptor of the file at path.
+// Called with captureMu held.
+func redirectCapture(path string, to *os.File) error {
+	fds, err := captureFDs(path, int(to.Fd()))
+	if err != nil {
+		return err
+	}
+	for _, fd := range fds {
+		if err := dupOnto(int(to.Fd()), fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureFDs returns every open descriptor of the file at path but skip.
+func captureFDs(path string, skip int) ([]int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	want, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("cannot identify %s", path)
+	}
+
+	entries, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []int
+	for _, entry := range entries {
+		var fd int
+		if _, err := fmt.Sscan(entry.Name(), &fd); err != nil || fd == skip {
+			continue
+		}
+		var st syscall.Stat_t
+		if syscall.Fstat(fd, &st) != nil || st.Dev != want.Dev || st.Ino != want.Ino {
+			continue
+		}
+		fds = append(fds, fd)
+	}
+	if len(fds) == 0 {
+		return nil, fmt.Errorf("no open descriptor for %s", path)
+	}
+	return fds, nil
+}
+
+// parkCapture points the descriptors of the capture at path at /dev/null,
+// returning them and the pcap header written so far. Called with captureMu
+// held.
+func parkCapture(path string) ([]int, []byte, error) {
+	header := make([]byte, pcapHeaderSize)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = io.ReadFull(f, header)
+	f.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read pcap header of %s: %w", path, err)
+	}
+	fds, err := captureFDs(path, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer devNull.Close()
+	for _, fd := range fds {
+		if err := dupOnto(int(devNull.Fd()), fd); err != nil {
+			return nil, nil, err
+		}
+	}
+	return fds, header, nil
+}
+
+// resumeCapture starts a capture at path, beginning with header, on the
+// descriptors parkCapture parked. Called with captureMu held.
+func resumeCapture(path string, fds []int, header []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	for _, fd := range fds {
+		if err := dupOnto(int(f.Fd()), fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
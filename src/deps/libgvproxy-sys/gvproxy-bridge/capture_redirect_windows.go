@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errCaptureRedirect: Windows has no /dev/fd to find the sniffer's handle
+// by, so captures cannot be stopped, rotated or moved there.
+var errCaptureRedirect = errors.New("stopping or rotating a capture is not supported on windows")
+
+// redirectCapture is unavailable; see errCaptureRedirect.
+func redirectCapture(string, *os.File) error {
+	return errCaptureRedirect
+}
+
+// parkCapture is unavailable; see errCaptureRedirect.
+func parkCapture(string) ([]int, []byte, error) {
+	return nil, nil, errCaptureRedirect
+}
+
+// resumeCapture is unavailable; see errCaptureRedirect.
+func resumeCapture(string, []int, []byte) error {
+	return errCaptureRedirect
+}
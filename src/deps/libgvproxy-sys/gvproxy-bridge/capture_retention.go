@@ -0,0 +1,265 @@
+package main
+
+// capture_retention.go — Keep rotated captures from filling the disk.
+//
+// gvproxy_capture_rotate leaves every finished capture next to capture_file,
+// and a diagnostic capture left running for weeks accumulates them without
+// bound. With capture_retention set, each finished capture is compressed
+// as it is rotated ("gzip", or "zstd" through the zstd command, which must
+// be on PATH), and finished captures are deleted, oldest first, once they
+// are older than max_age_ms or together exceed max_total_bytes. The live
+// capture at capture_file is never touched; max_capture_bytes (limits.go)
+// bounds that one.
+//
+// Retention is applied after every rotation and once a minute, so max_age_ms
+// holds even when rotation stops. A capture's age is its rotation time, read
+// from its name, so copying or touching the files does not change it.
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventCaptureExpired fires when a finished capture is deleted by
+// capture_retention. Fields: file (the capture file), expired (the deleted
+// capture's path), reason ("max_age" or "max_total_bytes").
+const EventCaptureExpired = "capture_expired"
+
+// captureRetentionInterval is how often retention runs between rotations.
+const captureRetentionInterval = time.Minute
+
+// captureCompressions maps each compress value to its file suffix.
+var captureCompressions = map[string]string{"gzip": ".gz", "zstd": ".zst"}
+
+// CaptureRetention is the compression and retention policy for finished
+// captures.
+type CaptureRetention struct {
+	// Compress compresses each finished capture: "gzip" or "zstd".
+	// Empty => kept as is.
+	Compress string `json:"compress,omitempty"`
+	// MaxAgeMs deletes finished captures rotated longer ago. 0 => no limit.
+	MaxAgeMs int64 `json:"max_age_ms,omitempty"`
+	// MaxTotalBytes deletes the oldest finished captures while they
+	// together take more. 0 => no limit.
+	MaxTotalBytes int64 `json:"max_total_bytes,omitempty"`
+}
+
+// checkCaptureRetention validates capture_retention against the config.
+func checkCaptureRetention(config GvproxyConfig) error {
+	r := config.CaptureRetention
+	if r == nil {
+		return nil
+	}
+	if config.CaptureFile == nil || *config.CaptureFile == "" {
+		return fmt.Errorf("capture_retention needs capture_file")
+	}
+	if r.MaxAgeMs < 0 || r.MaxTotalBytes < 0 {
+		return fmt.Errorf("capture_retention: max_age_ms and max_total_bytes must not be negative")
+	}
+	switch r.Compress {
+	case "", "gzip":
+	case "zstd":
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return fmt.Errorf("capture_retention: compress zstd needs the zstd command: %w", err)
+		}
+	default:
+		return fmt.Errorf("capture_retention: unknown compress %q (want gzip or zstd)", r.Compress)
+	}
+	return nil
+}
+
+// captureRetentionMu serializes compression and pruning, so a capture being
+// compressed is not deleted under it.
+var captureRetentionMu sync.Mutex
+
+// compressCapture compresses the finished capture at rotated with method
+// and removes it, returning the compressed file's path.
+func compressCapture(rotated, method string) (string, error) {
+	captureRetentionMu.Lock()
+	defer captureRetentionMu.Unlock()
+
+	out := rotated + captureCompressions[method]
+	// The temporary name does not parse as a finished capture, so a
+	// half-written file is never pruned or counted.
+	tmp := out + ".tmp"
+	var err error
+	if method == "zstd" {
+		err = exec.Command("zstd", "-q", "-f", "-o", tmp, rotated).Run()
+	} else {
+		err = gzipFile(rotated, tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, out)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("compress %s: %w", rotated, err)
+	}
+	if err := os.Remove(rotated); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "file": rotated}).Warn("Failed to remove capture after compressing it")
+	}
+	return out, nil
+}
+
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// finishedCapture is a rotated capture of a capture file.
+type finishedCapture struct {
+	path    string
+	rotated time.Time
+	size    int64
+}
+
+// finishedCaptures lists the rotated captures of path, compressed or not,
+// oldest first.
+func finishedCaptures(path string) ([]finishedCapture, error) {
+	ext := filepath.Ext(path)
+	prefix := filepath.Base(strings.TrimSuffix(path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var captures []finishedCapture
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(name, prefix)
+		for _, suffix := range captureCompressions {
+			stamp = strings.TrimSuffix(stamp, suffix)
+		}
+		if !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		rotated, err := time.Parse("20060102T150405.000000000Z", strings.TrimSuffix(stamp, ext))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		captures = append(captures, finishedCapture{
+			path:    filepath.Join(filepath.Dir(path), name),
+			rotated: rotated,
+			size:    info.Size(),
+		})
+	}
+	sort.Slice(captures, func(i, j int) bool { return captures[i].rotated.Before(captures[j].rotated) })
+	return captures, nil
+}
+
+// applyCaptureRetention deletes the finished captures of instance id's
+// capture file that r no longer keeps.
+func applyCaptureRetention(id int64, path string, r CaptureRetention, now time.Time) error {
+	if r.MaxAgeMs == 0 && r.MaxTotalBytes == 0 {
+		return nil
+	}
+	captureRetentionMu.Lock()
+	defer captureRetentionMu.Unlock()
+
+	captures, err := finishedCaptures(path)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, c := range captures {
+		total += c.size
+	}
+	maxAge := time.Duration(r.MaxAgeMs) * time.Millisecond
+	for _, c := range captures {
+		reason := ""
+		switch {
+		case maxAge > 0 && now.Sub(c.rotated) > maxAge:
+			reason = "max_age"
+		case r.MaxTotalBytes > 0 && total > r.MaxTotalBytes:
+			reason = "max_total_bytes"
+		default:
+			// Oldest first: every later capture is younger and fits.
+			return nil
+		}
+		if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= c.size
+		logrus.WithFields(logrus.Fields{"id": id, "file": path, "expired": c.path, "reason": reason}).Info("Capture expired")
+		emitEvent(id, EventCaptureExpired, map[string]any{
+			"file":    path,
+			"expired": c.path,
+			"reason":  reason,
+		})
+	}
+	return nil
+}
+
+// finishRotatedCapture applies instance id's retention policy to a capture
+// just rotated out of path, returning the finished capture's final path.
+func finishRotatedCapture(id int64, path, rotated string, r *CaptureRetention) string {
+	if r == nil {
+		return rotated
+	}
+	if r.Compress != "" {
+		compressed, err := compressCapture(rotated, r.Compress)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": id, "file": rotated}).Error("Failed to compress rotated capture")
+		} else {
+			rotated = compressed
+		}
+	}
+	if err := applyCaptureRetention(id, path, *r, time.Now()); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id, "file": path}).Error("Failed to apply capture retention")
+	}
+	return rotated
+}
+
+// watchCaptureRetention applies the retention policy periodically until ctx
+// is done.
+func watchCaptureRetention(ctx context.Context, id int64, path string, r CaptureRetention) {
+	if r.MaxAgeMs == 0 && r.MaxTotalBytes == 0 {
+		return
+	}
+	ticker := time.NewTicker(captureRetentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := applyCaptureRetention(id, path, r, time.Now()); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": id, "file": path}).Error("Failed to apply capture retention")
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckCaptureRetention(t *testing.T) {
+	capture := "/tmp/net.pcap"
+	for name, tc := range map[string]struct {
+		config GvproxyConfig
+		want   string
+	}{
+		"unset":        {GvproxyConfig{}, ""},
+		"gzip":         {GvproxyConfig{CaptureFile: &capture, CaptureRetention: &CaptureRetention{Compress: "gzip"}}, ""},
+		"no capture":   {GvproxyConfig{CaptureRetention: &CaptureRetention{MaxAgeMs: 1}}, "needs capture_file"},
+		"negative age": {GvproxyConfig{CaptureFile: &capture, CaptureRetention: &CaptureRetention{MaxAgeMs: -1}}, "must not be negative"},
+		"unknown":      {GvproxyConfig{CaptureFile: &capture, CaptureRetention: &CaptureRetention{Compress: "xz"}}, "unknown compress"},
+	} {
+		err := checkCaptureRetention(tc.config)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%s: error = %v, want %q", name, err, tc.want)
+		}
+	}
+}
+
+func TestCompressCapture_Gzip(t *testing.T) {
+	rotated := rotatedCapturePath(filepath.Join(t.TempDir(), "net.pcap"), time.Now())
+	data := bytes.Repeat([]byte("packet"), 1000)
+	if err := os.WriteFile(rotated, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := compressCapture(rotated, "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != rotated+".gz" {
+		t.Errorf("compressed to %q, want %q", out, rotated+".gz")
+	}
+	if _, err := os.Stat(rotated); !os.IsNotExist(err) {
+		t.Errorf("uncompressed capture kept: %v", err)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("decompressed %d bytes (%v), want %d", len(got), err, len(data))
+	}
+}
+
+func TestCompressCapture_Zstd(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd command not available")
+	}
+	rotated := rotatedCapturePath(filepath.Join(t.TempDir(), "net.pcap"), time.Now())
+	if err := os.WriteFile(rotated, bytes.Repeat([]byte("packet"), 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := compressCapture(rotated, "zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != rotated+".zst" {
+		t.Errorf("compressed to %q, want %q", out, rotated+".zst")
+	}
+}
+
+func TestApplyCaptureRetention(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "net.pcap")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	write := func(name string, size int) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Oldest to newest; the live capture and other files are not finished
+	// captures of net.pcap.
+	old := filepath.Base(rotatedCapturePath(path, now.Add(-48*time.Hour))) + ".gz"
+	mid := filepath.Base(rotatedCapturePath(path, now.Add(-2*time.Hour))) + ".zst"
+	recent := filepath.Base(rotatedCapturePath(path, now.Add(-time.Hour)))
+	write(old, 10)
+	write(mid, 100)
+	write(recent, 100)
+	write("net.pcap", 1000)
+	write("net-notes.txt", 1000)
+	write(recent+".gz.tmp", 1000)
+
+	r := CaptureRetention{MaxAgeMs: (24 * time.Hour).Milliseconds(), MaxTotalBytes: 150}
+	if err := applyCaptureRetention(5904, path, r, now); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	want := []string{recent, recent + ".gz.tmp", "net-notes.txt", "net.pcap"}
+	if strings.Join(left, ",") != strings.Join(want, ",") {
+		t.Fatalf("left %v, want %v", left, want)
+	}
+	var reasons []string
+	for _, e := range recentEventsFor(5904) {
+		if e.Type == EventCaptureExpired {
+			reasons = append(reasons, e.Fields["reason"].(string))
+		}
+	}
+	if strings.Join(reasons, ",") != "max_age,max_total_bytes" {
+		t.Errorf("expired reasons = %v", reasons)
+	}
+}
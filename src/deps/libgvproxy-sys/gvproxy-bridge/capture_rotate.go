@@ -0,0 +1,117 @@
+package main
+
+// capture_rotate.go — Rotate a running packet capture.
+//
+// Log shippers want finished files, but capture_file is written for the
+// lifetime of the instance. gvproxy_capture_rotate finalizes the current
+// capture under a timestamped name next to it and starts a new one at
+// capture_file, without stopping capture:
+//
+//  1. the capture is renamed; the sniffer keeps writing to it;
+//  2. a new file is created at capture_file, starting with the same pcap
+//     header;
+//  3. the new file is dup'ed over the sniffer's descriptor, closing the old
+//     file.
+//
+// The sniffer writes each packet with a single write, and the dup is atomic
+// with respect to writes, so every packet lands whole in exactly one file.
+
+import "C"
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventCaptureRotated fires when a capture is rotated. Fields: file (the
+// capture file, now new), rotated (the finished capture's path).
+const EventCaptureRotated = "capture_rotated"
+
+// pcapHeaderSize is the size of the pcap global header the sniffer writes.
+const pcapHeaderSize = 24
+
+// rotatedCapturePath names a finished capture after its rotation time, e.g.
+// "net.pcap" → "net-20260102T150405.000000000Z.pcap".
+func rotatedCapturePath(path string, now time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + now.UTC().Format("20060102T150405.000000000Z") + ext
+}
+
+// rotateCapture finalizes the capture at path and starts a new one there,
+// returning the finished capture's path.
+func rotateCapture(path string) (string, error) {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+
+	header := make([]byte, pcapHeaderSize)
+	current, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.ReadFull(current, header)
+	current.Close()
+	if err != nil {
+		return "", fmt.Errorf("read pcap header of %s: %w", path, err)
+	}
+
+	rotated := rotatedCapturePath(path, time.Now())
+	if err := os.Rename(path, rotated); err != nil {
+		return "", err
+	}
+	next, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err == nil {
+		defer next.Close()
+		_, err = next.Write(header)
+	}
+	if err == nil {
+		err = redirectCapture(rotated, next)
+	}
+	if err != nil {
+		// Put the capture back so it continues where it was.
+		os.Remove(path)
+		if renameErr := os.Rename(rotated, path); renameErr != nil {
+			logrus.WithFields(logrus.Fields{"error": renameErr, "file": rotated}).Error("Failed to restore capture after a failed rotation")
+		}
+		return "", err
+	}
+	return rotated, nil
+}
+
+// gvproxy_capture_rotate finalizes instance id's packet capture and starts a
+// new one at its capture_file, without stopping capture. The finished
+// capture is renamed to capture_file with a UTC timestamp before the
+// extension, and that path is returned; with capture_retention it is then
+// compressed (see capture_retention.go) and the compressed path returned.
+//
+// Returns NULL if the instance does not exist, has no capture (or it was
+// stopped at max_capture_bytes), or rotation failed (logged). The string
+// must be freed with gvproxy_free_string.
+//
+//export gvproxy_capture_rotate
+func gvproxy_capture_rotate(id C.longlong) *C.char {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok || instance.config.CaptureFile == nil || *instance.config.CaptureFile == "" {
+		return nil
+	}
+	path := *instance.config.CaptureFile
+
+	rotated, err := rotateCapture(path)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id), "file": path}).Error("Failed to rotate capture")
+		return nil
+	}
+	rotated = finishRotatedCapture(int64(id), path, rotated, instance.config.CaptureRetention)
+	logrus.WithFields(logrus.Fields{"id": int64(id), "file": path, "rotated": rotated}).Info("Capture rotated")
+	emitEvent(int64(id), EventCaptureRotated, map[string]any{
+		"file":    path,
+		"rotated": rotated,
+	})
+	return returnCString(rotated)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatedCapturePath(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 6, time.UTC)
+	if got, want := rotatedCapturePath("/var/log/net.pcap", now), "/var/log/net-20260102T150405.000000006Z.pcap"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := rotatedCapturePath("/tmp/capture", now), "/tmp/capture-20260102T150405.000000006Z"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRotateCapture_SplitsWritesBetweenFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	header := bytes.Repeat([]byte{0xa1}, pcapHeaderSize)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(header, "pkt1"...)); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := rotateCapture(path)
+	if err != nil {
+		t.Fatalf("rotateCapture: %v", err)
+	}
+	// f stands in for the sniffer's descriptor, now the new file.
+	if _, err := f.Write([]byte("pkt2")); err != nil {
+		t.Fatalf("write after rotate: %v", err)
+	}
+
+	for file, want := range map[string]string{
+		rotated: string(header) + "pkt1",
+		path:    string(header) + "pkt2",
+	} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", file, data, want)
+		}
+	}
+}
+
+func TestRotateCapture_RestoresOnFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	// Nothing has the capture open, so there is no descriptor to move.
+	if err := os.WriteFile(path, bytes.Repeat([]byte{0xa1}, pcapHeaderSize), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotateCapture(path); err == nil {
+		t.Fatal("expected error")
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "capture.pcap" {
+		t.Fatalf("directory = %v, want only the original capture", entries)
+	}
+}
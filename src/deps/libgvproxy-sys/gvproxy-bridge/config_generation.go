@@ -0,0 +1,82 @@
+package main
+
+// config_generation.go — Let reconcilers tell which runtime change applied.
+//
+// An embedder reconciling an instance against desired state ("these ports
+// are published") could not tell from the outside whether a change it sent
+// had been applied, or whether something else changed the instance since.
+// Each instance now carries a config generation: 1 at creation, bumped by
+// every change to its running configuration. Today those are
+// gvproxy_expose and gvproxy_unexpose (and their helper methods),
+// gvproxy_dns_add_record and gvproxy_dns_remove_record, and gvproxy_set_nat;
+// a dry run changes nothing and bumps nothing. The generation is reported in
+// instance_created, in the "Config" stats section and by
+// gvproxy_get_generation, and each bump fires config_changed naming the
+// change.
+
+import "C"
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// EventConfigChanged fires when an instance's running configuration
+// changes. Fields: generation (after the change), change ("expose",
+// "unexpose", "dns_add_record", "dns_remove_record", "set_nat") and what
+// changed: host (the forward's host address or socket), zone and name, or
+// guest_ip and enabled.
+const EventConfigChanged = "config_changed"
+
+// configGeneration counts the changes to an instance's configuration.
+type configGeneration struct{ n atomic.Uint64 }
+
+func newConfigGeneration() *configGeneration {
+	g := &configGeneration{}
+	g.n.Store(1)
+	return g
+}
+
+func (g *configGeneration) current() uint64 { return g.n.Load() }
+
+// bump records a change to instance id and announces it with fields.
+func (g *configGeneration) bump(id int64, change string, fields map[string]any) uint64 {
+	n := g.n.Add(1)
+	fields["generation"] = n
+	fields["change"] = change
+	emitEvent(id, EventConfigChanged, fields)
+	return n
+}
+
+// ConfigStats is the "Config" stats section.
+type ConfigStats struct {
+	Generation uint64 `json:"Generation"`
+}
+
+// configCollector publishes the config generation as the "Config" section.
+type configCollector struct{ g *configGeneration }
+
+func (configCollector) Name() string { return "Config" }
+
+func (configCollector) Description() string {
+	return "Config generation: 1 at creation, bumped by every runtime configuration change."
+}
+
+func (c configCollector) Collect() any { return ConfigStats{Generation: c.g.current()} }
+
+func (c configCollector) collectOpenMetrics(emit metricEmitter) {
+	emit(openMetricsPrefix+"config_generation", "gauge", "Runtime configuration changes applied, plus one.", "", fmt.Sprint(c.g.current()))
+}
+
+// gvproxy_get_generation returns instance id's config generation (see
+// config_generation.go), or -1 if the instance does not exist.
+//
+//export gvproxy_get_generation
+func gvproxy_get_generation(id C.longlong) C.longlong {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok {
+		return -1
+	}
+	return C.longlong(instance.generation.current())
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestConfigGeneration_BumpedByExposeAndUnexpose(t *testing.T) {
+	config := testGvproxyConfig()
+	f, err := NewPortForwarder(5931, config.GuestIP, nil, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	addFakeInstance(t, 5931, config)
+	instancesMu.Lock()
+	instances[5931].forwarder = f
+	instancesMu.Unlock()
+
+	if got := gvproxy_get_generation(5931); got != 1 {
+		t.Fatalf("generation at creation = %d, want 1", got)
+	}
+	pm := PortMapping{HostIP: "127.0.0.1", HostPort: freeTCPPort(t), GuestPort: 80}
+	if err := exposePort(5931, pm, true); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if got := gvproxy_get_generation(5931); got != 1 {
+		t.Fatalf("generation after a dry run = %d, want 1", got)
+	}
+	if err := exposePort(5931, pm, false); err != nil {
+		t.Fatalf("exposePort: %v", err)
+	}
+	if err := exposePort(5931, pm, false); err == nil {
+		t.Fatal("expected error publishing the same port twice")
+	}
+	if err := unexposePort(5931, pm); err != nil {
+		t.Fatalf("unexposePort: %v", err)
+	}
+	if got := gvproxy_get_generation(5931); got != 3 {
+		t.Fatalf("generation = %d, want 3 (expose, unexpose)", got)
+	}
+	var changes []map[string]any
+	for _, e := range recentEventsFor(5931) {
+		if e.Type == EventConfigChanged {
+			changes = append(changes, e.Fields)
+		}
+	}
+	if len(changes) != 2 {
+		t.Fatalf("config_changed events = %v, want expose and unexpose", changes)
+	}
+	for i, want := range []string{"expose", "unexpose"} {
+		if changes[i]["change"] != want || changes[i]["generation"] != uint64(i+2) || changes[i]["host"] != pm.hostAddr() {
+			t.Fatalf("config_changed %d = %v, want change %s generation %d", i, changes[i], want, i+2)
+		}
+	}
+	if s := (configCollector{instances[5931].generation}).Collect().(ConfigStats); s.Generation != 3 {
+		t.Fatalf("Config stats = %+v, want Generation 3", s)
+	}
+	if got := gvproxy_get_generation(5999); got != -1 {
+		t.Fatalf("generation of an unknown instance = %d, want -1", got)
+	}
+}
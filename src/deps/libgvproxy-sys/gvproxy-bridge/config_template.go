@@ -0,0 +1,132 @@
+package main
+
+// config_template.go — Create instances from a shared template.
+//
+// Fleets of similar VMs share most of their config (DNS zones, allow_net,
+// secrets) and differ in a few fields (socket paths, MACs, port mappings).
+// gvproxy_create_from_template takes the shared part and the per-VM part as
+// separate documents and merges them here, so the Rust side does not have to
+// rebuild the whole config for every VM.
+//
+// The merge follows JSON Merge Patch (RFC 7386), applied to the template:
+//
+//   - an object in the overrides is merged key by key into the template's;
+//   - any other value (string, number, bool, array) replaces the template's;
+//   - null removes the key, restoring the field's default.
+//
+// Arrays are never merged element-wise: overriding port_mappings replaces
+// the whole list. Unlike gvproxy_create, keys that are not config fields are
+// rejected, so a misspelled override fails instead of being ignored.
+
+import "C"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// mergePatch applies patch to target as in RFC 7386 and returns the result.
+// target is not modified.
+func mergePatch(target any, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	merged := make(map[string]any, len(targetObj)+len(patchObj))
+	if ok {
+		for k, v := range targetObj {
+			merged[k] = v
+		}
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = mergePatch(merged[k], v)
+	}
+	return merged
+}
+
+// decodeObject decodes a JSON object, keeping numbers exact.
+func decodeObject(doc []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+	return obj, nil
+}
+
+// templateConfig merges overrides into template and decodes the result.
+// Both documents must be JSON objects; an empty overrides document means
+// none.
+func templateConfig(template, overrides []byte) (GvproxyConfig, error) {
+	var config GvproxyConfig
+
+	base, err := decodeObject(template)
+	if err != nil {
+		return config, fmt.Errorf("template: %w", err)
+	}
+	var patch any = map[string]any{}
+	if len(bytes.TrimSpace(overrides)) > 0 {
+		if patch, err = decodeObject(overrides); err != nil {
+			return config, fmt.Errorf("overrides: %w", err)
+		}
+	}
+
+	merged, err := json.Marshal(mergePatch(base, patch))
+	if err != nil {
+		return config, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return config, fmt.Errorf("merged config: %w", err)
+	}
+	return config, nil
+}
+
+// gvproxy_create_from_template creates an instance from a template config
+// with per-instance overrides merged in (JSON Merge Patch, see
+// config_template.go). overridesJSON may be NULL.
+//
+// Returns the instance id, or a negative GVPROXY_ERR_* code with the error
+// written to *errOut and kept for gvproxy_last_error as in gvproxy_create.
+//
+//export gvproxy_create_from_template
+func gvproxy_create_from_template(templateJSON, overridesJSON *C.char, errOut **C.char) C.longlong {
+	setLastError(nil)
+	setErr := func(err error) { reportCreateError(errOut, err) }
+	if templateJSON == nil {
+		err := classify(createErrConfig, fmt.Errorf("template is required"))
+		setErr(err)
+		return createErrorCode(err)
+	}
+	var overrides []byte
+	if overridesJSON != nil {
+		overrides = []byte(C.GoString(overridesJSON))
+	}
+
+	config, err := templateConfig([]byte(C.GoString(templateJSON)), overrides)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to build gvproxy config from template")
+		err = classify(createErrConfig, err)
+		setErr(err)
+		return createErrorCode(err)
+	}
+
+	id, err := createInstance(config)
+	if err != nil {
+		setErr(err)
+		return createErrorCode(err)
+	}
+	return C.longlong(id)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	target := map[string]any{
+		"a": "keep",
+		"b": map[string]any{"x": 1, "y": 2},
+		"c": []any{1, 2},
+		"d": "drop",
+	}
+	patch := map[string]any{
+		"b": map[string]any{"y": 3, "z": 4},
+		"c": []any{9},
+		"d": nil,
+		"e": "new",
+	}
+	want := map[string]any{
+		"a": "keep",
+		"b": map[string]any{"x": 1, "y": 3, "z": 4},
+		"c": []any{9},
+		"e": "new",
+	}
+	if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergePatch = %v, want %v", got, want)
+	}
+	if _, ok := target["e"]; ok {
+		t.Error("mergePatch modified the target")
+	}
+}
+
+func TestTemplateConfig_AppliesOverrides(t *testing.T) {
+	template := `{
+		"subnet": "192.168.127.0/24",
+		"gateway_ip": "192.168.127.1",
+		"guest_ip": "192.168.127.2",
+		"socket_path": "/run/boxlite/template.sock",
+		"port_mappings": [{"host_port": 8080, "guest_port": 80}],
+		"capture_file": "/tmp/template.pcap"
+	}`
+	overrides := `{
+		"socket_path": "/run/boxlite/vm1.sock",
+		"port_mappings": [{"host_port": 9090, "guest_port": 90}],
+		"capture_file": null
+	}`
+
+	config, err := templateConfig([]byte(template), []byte(overrides))
+	if err != nil {
+		t.Fatalf("templateConfig: %v", err)
+	}
+	if config.SocketPath != "/run/boxlite/vm1.sock" {
+		t.Errorf("SocketPath = %q, want the override", config.SocketPath)
+	}
+	if config.GatewayIP != "192.168.127.1" {
+		t.Errorf("GatewayIP = %q, want the template's", config.GatewayIP)
+	}
+	if len(config.PortMappings) != 1 || config.PortMappings[0].HostPort != 9090 {
+		t.Errorf("PortMappings = %+v, want the override list only", config.PortMappings)
+	}
+	if config.CaptureFile != nil {
+		t.Errorf("CaptureFile = %q, want removed by null", *config.CaptureFile)
+	}
+}
+
+func TestTemplateConfig_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		name, template, overrides, want string
+	}{
+		{"template not object", `[1]`, ``, "template"},
+		{"template null", `null`, ``, "template"},
+		{"overrides not object", `{}`, `"x"`, "overrides"},
+		{"unknown key", `{}`, `{"socket_pth": "/x"}`, "socket_pth"},
+		{"wrong type", `{}`, `{"mtu": "big"}`, "merged config"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := templateConfig([]byte(tc.template), []byte(tc.overrides))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want mention of %q", err, tc.want)
+			}
+		})
+	}
+}
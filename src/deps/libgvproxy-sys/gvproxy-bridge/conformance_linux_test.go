@@ -0,0 +1,60 @@
+//go:build conformance && linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/transport"
+)
+
+func init() {
+	conformanceBackends = append(conformanceBackends,
+		conformanceBackend{name: "bess", id: 5928, start: startBessConformance},
+		conformanceBackend{name: "vsock", id: 5929, start: startVsockConformance},
+	)
+}
+
+func startBessConformance(t *testing.T, id int64, config *GvproxyConfig) guestLink {
+	config.SocketPath = filepath.Join(conformanceSocketDir(t), "vm.sock")
+	config.Protocol = "bess"
+	startConformanceInstance(t, id, *config)
+	conn, err := net.Dial("unixpacket", config.SocketPath)
+	if err != nil {
+		t.Fatalf("dial VM socket: %v", err)
+	}
+	return &packetGuestLink{conn}
+}
+
+// conformanceVsockPort is the vsock port the vsock backend listens on.
+const conformanceVsockPort = 5929
+
+// startVsockConformance connects as the guest forwarder does, over the
+// vsock loopback (CID 1). Without vsock_loopback it skips.
+func startVsockConformance(t *testing.T, id int64, config *GvproxyConfig) guestLink {
+	config.SocketPath = ""
+	config.VsockListen = &VsockListen{Port: conformanceVsockPort}
+	if err := startInstance(id, *config); err != nil {
+		t.Skipf("vsock unavailable: %v", err)
+	}
+	conn, _, err := transport.Dial(fmt.Sprintf("vsock://1:%d", conformanceVsockPort))
+	if err != nil {
+		destroyInstanceSync(id, 0)
+		t.Skipf("vsock loopback unavailable: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, "/connect", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	req.Write(&buf) //nolint:errcheck
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatalf("write connect request: %v", err)
+	}
+	return &streamGuestLink{r: conn, w: conn, c: conn, prefixLen: 2}
+}
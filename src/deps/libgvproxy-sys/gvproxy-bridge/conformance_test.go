@@ -0,0 +1,491 @@
+//go:build conformance
+
+package main
+
+// conformance_test.go — The same network checks against every link backend.
+//
+// Each backend starts an instance with its protocol and hands back the VM's
+// end of the link. A guest network stack (gVisor's netstack on a channel
+// endpoint) boots over it and runs the battery: DHCP, DNS through the
+// gateway, NAT to the host and a port forward into the guest. Subtests are
+// named <backend>/<check>, so
+//
+//	go test -tags conformance -run TestConformance -v .
+//
+// prints a pass/fail matrix. A new backend registers a conformanceBackend
+// (from an init in its own file when it needs more build tags) and gets the
+// whole battery. Backends the host cannot run, such as vsock without the
+// vsock_loopback module, skip.
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+// guestLink is the VM's end of a link, one Ethernet frame at a time.
+type guestLink interface {
+	readFrame() ([]byte, error)
+	writeFrame(frame []byte) error
+	Close() error
+}
+
+// conformanceBackend starts instance id over one link protocol.
+type conformanceBackend struct {
+	name string
+	id   int64
+	// start configures config for the backend, starts the instance and
+	// returns the VM's end of the link. It skips t if the host cannot run
+	// the backend.
+	start func(t *testing.T, id int64, config *GvproxyConfig) guestLink
+}
+
+var conformanceBackends = []conformanceBackend{
+	{name: "qemu", id: 5925, start: startQemuConformance},
+	{name: "vfkit", id: 5926, start: startVfkitConformance},
+	{name: "stdio", id: 5927, start: startStdioConformance},
+}
+
+// conformanceCheck is one entry of the battery.
+type conformanceCheck struct {
+	name string
+	run  func(t *testing.T, g *conformanceGuest, config GvproxyConfig)
+}
+
+var conformanceChecks = []conformanceCheck{
+	{"dhcp", checkConformanceDHCP},
+	{"dns", checkConformanceDNS},
+	{"nat", checkConformanceNAT},
+	{"forward", checkConformanceForward},
+}
+
+// conformanceGuestPort is the guest port the forward check publishes.
+const conformanceGuestPort = 8080
+
+func TestConformance(t *testing.T) {
+	for _, backend := range conformanceBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			config := testGvproxyConfig()
+			config.PortMappings = []PortMapping{{HostPort: freeTCPPort(t), GuestPort: conformanceGuestPort}}
+			link := backend.start(t, backend.id, &config)
+			defer destroyInstanceSync(backend.id, 5*time.Second)
+			defer link.Close()
+
+			g := bootConformanceGuest(t, link, config)
+			defer g.close()
+			for _, check := range conformanceChecks {
+				t.Run(check.name, func(t *testing.T) { check.run(t, g, config) })
+			}
+		})
+	}
+}
+
+// startConformanceInstance starts the instance or fails t.
+func startConformanceInstance(t *testing.T, id int64, config GvproxyConfig) {
+	t.Helper()
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+}
+
+// conformanceSocketDir returns a directory short enough for socket paths.
+func conformanceSocketDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "gvp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func startQemuConformance(t *testing.T, id int64, config *GvproxyConfig) guestLink {
+	config.SocketPath = filepath.Join(conformanceSocketDir(t), "vm.sock")
+	config.Protocol = "qemu"
+	startConformanceInstance(t, id, *config)
+	conn, err := net.Dial("unix", config.SocketPath)
+	if err != nil {
+		t.Fatalf("dial VM socket: %v", err)
+	}
+	return &streamGuestLink{r: conn, w: conn, c: conn, prefixLen: 4}
+}
+
+func startVfkitConformance(t *testing.T, id int64, config *GvproxyConfig) guestLink {
+	dir := conformanceSocketDir(t)
+	config.SocketPath = filepath.Join(dir, "vm.sock")
+	config.Protocol = "vfkit"
+	startConformanceInstance(t, id, *config)
+	conn, err := net.DialUnix("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "vfkit.sock"), Net: "unixgram"}, &net.UnixAddr{Name: config.SocketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("dial VM socket: %v", err)
+	}
+	if _, err := conn.Write([]byte("VFKT")); err != nil {
+		t.Fatalf("write magic: %v", err)
+	}
+	return &packetGuestLink{conn}
+}
+
+func startStdioConformance(t *testing.T, id int64, config *GvproxyConfig) guestLink {
+	var fromVM, toVM [2]int
+	if err := syscall.Pipe(fromVM[:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Pipe(toVM[:]); err != nil {
+		t.Fatal(err)
+	}
+	vmOut := os.NewFile(uintptr(fromVM[1]), "vm-out")
+	vmIn := os.NewFile(uintptr(toVM[0]), "vm-in")
+	config.Protocol = "stdio"
+	config.StdioFDs = &StdioFDs{ReadFD: fromVM[0], WriteFD: toVM[1]}
+	startConformanceInstance(t, id, *config)
+	return &streamGuestLink{r: vmIn, w: vmOut, c: multiCloser{vmIn, vmOut}, prefixLen: 2}
+}
+
+// streamGuestLink frames a byte stream with a length prefix: 4 bytes big
+// endian (qemu) or 2 bytes little endian (stdio, vsock).
+type streamGuestLink struct {
+	r         io.Reader
+	w         io.Writer
+	c         io.Closer
+	prefixLen int
+}
+
+func (l *streamGuestLink) readFrame() ([]byte, error) {
+	prefix := make([]byte, l.prefixLen)
+	if _, err := io.ReadFull(l.r, prefix); err != nil {
+		return nil, err
+	}
+	size := int(binary.LittleEndian.Uint16(prefix))
+	if l.prefixLen == 4 {
+		size = int(binary.BigEndian.Uint32(prefix))
+	}
+	frame := make([]byte, size)
+	_, err := io.ReadFull(l.r, frame)
+	return frame, err
+}
+
+func (l *streamGuestLink) writeFrame(frame []byte) error {
+	var framed []byte
+	if l.prefixLen == 4 {
+		framed = binary.BigEndian.AppendUint32(nil, uint32(len(frame)))
+	} else {
+		framed = binary.LittleEndian.AppendUint16(nil, uint16(len(frame)))
+	}
+	_, err := l.w.Write(append(framed, frame...))
+	return err
+}
+
+func (l *streamGuestLink) Close() error { return l.c.Close() }
+
+// packetGuestLink carries one frame per datagram or packet (vfkit, bess).
+type packetGuestLink struct{ conn net.Conn }
+
+func (l *packetGuestLink) readFrame() ([]byte, error) {
+	buf := make([]byte, 65536)
+	n, err := l.conn.Read(buf)
+	return buf[:n], err
+}
+
+func (l *packetGuestLink) writeFrame(frame []byte) error {
+	_, err := l.conn.Write(frame)
+	return err
+}
+
+func (l *packetGuestLink) Close() error { return l.conn.Close() }
+
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	for _, c := range m {
+		c.Close()
+	}
+	return nil
+}
+
+// conformanceLease is what the guest learnt from DHCP.
+type conformanceLease struct {
+	IP, Router, DNS net.IP
+}
+
+// conformanceGuest is a guest network stack running over a guestLink.
+type conformanceGuest struct {
+	stack    *stack.Stack
+	lease    conformanceLease
+	leaseErr error
+	cancel   context.CancelFunc
+}
+
+const conformanceNIC = 1
+
+// bootConformanceGuest runs DHCP over link, then brings up a guest stack on
+// the leased address (config.GuestIP if DHCP failed, so the other checks
+// still run).
+func bootConformanceGuest(t *testing.T, link guestLink, config GvproxyConfig) *conformanceGuest {
+	t.Helper()
+	g := &conformanceGuest{}
+	g.lease, g.leaseErr = conformanceDHCP(link, mustMAC(config.GuestMac))
+	ip := g.lease.IP
+	if g.leaseErr != nil {
+		ip = net.ParseIP(config.GuestIP)
+	}
+
+	g.stack = stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, arp.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	ep := channel.New(256, uint32(config.MTU), tcpip.LinkAddress(mustMAC(config.GuestMac)))
+	if err := g.stack.CreateNIC(conformanceNIC, ethernet.New(ep)); err != nil {
+		t.Fatalf("CreateNIC: %v", err)
+	}
+	addr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddressWithPrefix{Address: tcpip.AddrFrom4Slice(ip.To4()), PrefixLen: 24},
+	}
+	if err := g.stack.AddProtocolAddress(conformanceNIC, addr, stack.AddressProperties{}); err != nil {
+		t.Fatalf("AddProtocolAddress: %v", err)
+	}
+	g.stack.SetRouteTable([]tcpip.Route{
+		{Destination: addr.AddressWithPrefix.Subnet(), NIC: conformanceNIC},
+		{Destination: header.IPv4EmptySubnet, Gateway: tcpip.AddrFrom4Slice(net.ParseIP(config.GatewayIP).To4()), NIC: conformanceNIC},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancel = func() {
+		cancel()
+		ep.Close()
+	}
+	go func() {
+		for {
+			frame, err := link.readFrame()
+			if err != nil {
+				return
+			}
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(frame)})
+			ep.InjectInbound(0, pkt)
+			pkt.DecRef()
+		}
+	}()
+	go func() {
+		for {
+			pkt := ep.ReadContext(ctx)
+			if pkt == nil {
+				return
+			}
+			view := pkt.ToView()
+			pkt.DecRef()
+			err := link.writeFrame(view.AsSlice())
+			view.Release()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return g
+}
+
+func (g *conformanceGuest) close() {
+	g.cancel()
+	g.stack.Close()
+}
+
+// address is ip:port as a netstack address on the guest NIC.
+func (g *conformanceGuest) address(ip string, port uint16) tcpip.FullAddress {
+	return tcpip.FullAddress{NIC: conformanceNIC, Addr: tcpip.AddrFrom4Slice(net.ParseIP(ip).To4()), Port: port}
+}
+
+// conformanceDHCP runs DISCOVER/OFFER/REQUEST/ACK over link.
+func conformanceDHCP(link guestLink, mac net.HardwareAddr) (conformanceLease, error) {
+	const xid = 0x5925
+	if err := link.writeFrame(dhcpClientFrame(mac, xid, dhcpMsgDiscover, nil, nil)); err != nil {
+		return conformanceLease{}, err
+	}
+	offer, err := readDHCPReply(link, xid, 2)
+	if err != nil {
+		return conformanceLease{}, fmt.Errorf("waiting for DHCPOFFER: %w", err)
+	}
+	yiaddr := net.IP(offer[16:20])
+	if err := link.writeFrame(dhcpClientFrame(mac, xid, dhcpMsgRequest, yiaddr, dhcpOption(offer, dhcpOptServerID))); err != nil {
+		return conformanceLease{}, err
+	}
+	ack, err := readDHCPReply(link, xid, dhcpMsgAck)
+	if err != nil {
+		return conformanceLease{}, fmt.Errorf("waiting for DHCPACK: %w", err)
+	}
+	return conformanceLease{
+		IP:     net.IP(append([]byte(nil), ack[16:20]...)),
+		Router: net.IP(dhcpOption(ack, 3)),
+		DNS:    net.IP(dhcpOption(ack, 6)),
+	}, nil
+}
+
+// dhcpClientFrame builds a broadcast DHCP message from the guest.
+func dhcpClientFrame(mac net.HardwareAddr, xid uint32, msgType byte, requested net.IP, serverID []byte) []byte {
+	msg := make([]byte, dhcpOptionsOffset)
+	msg[0], msg[1], msg[2] = 1, 1, 6 // BOOTREQUEST, Ethernet, 6-byte address
+	binary.BigEndian.PutUint32(msg[4:], xid)
+	binary.BigEndian.PutUint16(msg[10:], 0x8000) // broadcast replies
+	copy(msg[dhcpChaddrOffset:], mac)
+	copy(msg[236:], []byte{99, 130, 83, 99})
+	msg = append(msg, dhcpOptMessageType, 1, msgType)
+	if requested != nil {
+		msg = append(msg, 50, 4)
+		msg = append(msg, requested.To4()...)
+	}
+	if serverID != nil {
+		msg = append(msg, dhcpOptServerID, byte(len(serverID)))
+		msg = append(msg, serverID...)
+	}
+	msg = append(msg, dhcpOptEnd)
+
+	dgram := make([]byte, header.UDPMinimumSize+len(msg))
+	header.UDP(dgram).Encode(&header.UDPFields{SrcPort: 68, DstPort: dhcpServerPort, Length: uint16(len(dgram))})
+	copy(dgram[header.UDPMinimumSize:], msg)
+
+	frame := make([]byte, header.EthernetMinimumSize+header.IPv4MinimumSize+len(dgram))
+	header.Ethernet(frame).Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress(mac),
+		DstAddr: header.EthernetBroadcastAddress,
+		Type:    header.IPv4ProtocolNumber,
+	})
+	ip := header.IPv4(frame[header.EthernetMinimumSize:])
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(header.IPv4MinimumSize + len(dgram)),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     header.IPv4Any,
+		DstAddr:     header.IPv4Broadcast,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	copy(ip[header.IPv4MinimumSize:], dgram)
+	return frame
+}
+
+// readDHCPReply returns the BOOTP body of the next server reply for xid of
+// type msgType.
+func readDHCPReply(link guestLink, xid uint32, msgType byte) ([]byte, error) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		frame, err := link.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		ip := frameIPv4(frame)
+		if ip == nil || ip.TransportProtocol() != header.UDPProtocolNumber {
+			continue
+		}
+		udp := header.UDP(ip.Payload())
+		if len(udp) < header.UDPMinimumSize || udp.SourcePort() != dhcpServerPort {
+			continue
+		}
+		msg := udp.Payload()
+		if len(msg) < dhcpOptionsOffset || binary.BigEndian.Uint32(msg[4:]) != xid {
+			continue
+		}
+		if dhcpMessageType(msg) == msgType {
+			return msg, nil
+		}
+	}
+	return nil, fmt.Errorf("no DHCP message type %d", msgType)
+}
+
+func checkConformanceDHCP(t *testing.T, g *conformanceGuest, config GvproxyConfig) {
+	if g.leaseErr != nil {
+		t.Fatal(g.leaseErr)
+	}
+	if !g.lease.IP.Equal(net.ParseIP(config.GuestIP)) {
+		t.Errorf("leased %v, want %s", g.lease.IP, config.GuestIP)
+	}
+	if !g.lease.Router.Equal(net.ParseIP(config.GatewayIP)) {
+		t.Errorf("router %v, want %s", g.lease.Router, config.GatewayIP)
+	}
+	if !g.lease.DNS.Equal(net.ParseIP(config.GatewayIP)) {
+		t.Errorf("DNS server %v, want %s", g.lease.DNS, config.GatewayIP)
+	}
+}
+
+func checkConformanceDNS(t *testing.T, g *conformanceGuest, config GvproxyConfig) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			raddr := g.address(config.GatewayIP, 53)
+			return gonet.DialUDP(g.stack, nil, &raddr, ipv4.ProtocolNumber)
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := resolver.LookupHost(ctx, "host.boxlite.internal")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if len(addrs) != 1 || addrs[0] != config.HostIP {
+		t.Fatalf("host.boxlite.internal = %v, want %s", addrs, config.HostIP)
+	}
+}
+
+func checkConformanceNAT(t *testing.T, g *conformanceGuest, config GvproxyConfig) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn) //nolint:errcheck
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	conn, err := gonet.DialContextTCP(ctx, g.stack, g.address(config.HostIP, port), ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("guest dial %s:%d: %v", config.HostIP, port, err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	expectEcho(t, conn)
+}
+
+func checkConformanceForward(t *testing.T, g *conformanceGuest, config GvproxyConfig) {
+	l, err := gonet.ListenTCP(g.stack, tcpip.FullAddress{NIC: conformanceNIC, Port: conformanceGuestPort}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("guest listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn) //nolint:errcheck
+	}()
+
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", config.PortMappings[0].HostPort), 5*time.Second)
+	if err != nil {
+		t.Fatalf("host dial: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	expectEcho(t, conn)
+}
@@ -0,0 +1,162 @@
+package main
+
+// connect_proxy.go — Host-side debug tunnel into the guest network.
+//
+// With connect_proxy_socket_path set, the bridge serves an HTTP proxy on that
+// Unix socket whose connections are dialed from inside the netstack, so any
+// guest-network address (the guest IP, its aliases, the gateway's virtual
+// IPs) is reachable from the host without defining a forward:
+//
+//	curl --unix-socket /path/to/connect.sock http://192.168.127.2:8080/
+//
+// Plain HTTP requests are relayed to their Host; CONNECT tunnels arbitrary
+// TCP (e.g. socat, or an HTTPS client pointed at the socket as its proxy).
+// Targets must be IP literals: the netstack has no resolver.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// connectProxyDialTimeout bounds a tunnel's dial into the guest network.
+const connectProxyDialTimeout = 10 * time.Second
+
+// connectProxy dials every request's target in the guest network.
+type connectProxy struct {
+	instanceID int64
+	dialer     guestDialer
+	relay      *httputil.ReverseProxy
+}
+
+func newConnectProxy(instanceID int64, dialer guestDialer) *connectProxy {
+	p := &connectProxy{instanceID: instanceID, dialer: dialer}
+	p.relay = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = "http"
+			r.Out.URL.Host = r.In.Host
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return p.dial(ctx, addr)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	return p
+}
+
+// startConnectProxy serves the tunnel on a Unix socket at path until ctx is
+// done, on goroutines of goroutines. The path is left in place if detached
+// reports the instance was handed over (its replacement serves it).
+func startConnectProxy(ctx context.Context, instanceID int64, dialer guestDialer, path string, detached func() bool, goroutines *tracker) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logrus.WithFields(logrus.Fields{"error": err, "path": path}).Warn("Failed to remove existing CONNECT proxy socket")
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("CONNECT proxy: %w", err)
+	}
+	goroutines.spawn(func() {
+		<-ctx.Done()
+		if detached() {
+			keepSocketPath(l)
+		}
+		l.Close()
+	})
+	goroutines.spawn(func() {
+		if sErr := http.Serve(l, newConnectProxy(instanceID, dialer)); sErr != nil && ctx.Err() == nil {
+			logrus.WithFields(logrus.Fields{"error": sErr, "id": instanceID}).Error("CONNECT proxy exited")
+		}
+	})
+	logrus.WithFields(logrus.Fields{"id": instanceID, "path": path}).Info("Serving guest network CONNECT proxy")
+	return nil
+}
+
+// connectTarget returns host:port for an authority, defaulting the port to
+// 80. The host must be an IP literal.
+func connectTarget(authority string) (string, error) {
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		host, port = authority, "80"
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("target %q is not an IP address", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func (p *connectProxy) dial(ctx context.Context, authority string) (net.Conn, error) {
+	addr, err := connectTarget(authority)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, connectProxyDialTimeout)
+	defer cancel()
+	return p.dialer.DialContextTCP(ctx, addr)
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if _, err := connectTarget(req.Host); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Method != http.MethodConnect {
+		p.relay.ServeHTTP(w, req)
+		return
+	}
+
+	guest, err := p.dial(req.Context(), req.Host)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": p.instanceID, "target": req.Host}).Debug("CONNECT proxy dial failed")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		guest.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		guest.Close()
+		return
+	}
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		client.Close()
+		guest.Close()
+		return
+	}
+	logrus.WithFields(logrus.Fields{"id": p.instanceID, "target": req.Host}).Debug("CONNECT proxy tunnel opened")
+	spliceTunnel(client, buf.Reader, guest)
+}
+
+// spliceTunnel copies both ways until either side ends, then closes both.
+// fromClient drains bytes the client sent after its CONNECT request.
+func spliceTunnel(client net.Conn, fromClient io.Reader, guest net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		_, err := io.Copy(guest, fromClient)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			logrus.WithError(err).Trace("CONNECT proxy: client→guest copy ended")
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, guest) //nolint:errcheck
+		done <- struct{}{}
+	}()
+	<-done
+	client.Close()
+	guest.Close()
+	<-done
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// loopbackDialer dials addr on the host whatever guest address is asked for,
+// recording the requested one.
+type loopbackDialer struct {
+	addr   string
+	dialed chan string
+}
+
+func (d *loopbackDialer) DialContextTCP(ctx context.Context, addr string) (net.Conn, error) {
+	d.dialed <- addr
+	var nd net.Dialer
+	return nd.DialContext(ctx, "tcp", d.addr)
+}
+
+func startTestConnectProxy(t *testing.T, dialer guestDialer) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "connect.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := startConnectProxy(ctx, 4900, dialer, path, func() bool { return false }, nil); err != nil {
+		t.Fatalf("startConnectProxy: %v", err)
+	}
+	return path
+}
+
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var nd net.Dialer
+			return nd.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestConnectProxy_RelaysPlainHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+	defer srv.Close()
+	dialer := &loopbackDialer{addr: srv.Listener.Addr().String(), dialed: make(chan string, 1)}
+	path := startTestConnectProxy(t, dialer)
+
+	// Same request curl --unix-socket sends.
+	resp, err := unixClient(path).Get("http://192.168.127.2:8080/status")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello /status" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if got := <-dialer.dialed; got != "192.168.127.2:8080" {
+		t.Fatalf("expected a dial to the guest address, got %q", got)
+	}
+}
+
+func TestConnectProxy_Tunnel(t *testing.T) {
+	dialer := &echoDialer{dialed: make(chan string, 1)}
+	path := startTestConnectProxy(t, dialer)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "CONNECT 192.168.127.2:22 HTTP/1.1\r\nHost: 192.168.127.2:22\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 to CONNECT, got %v (%v)", resp, err)
+	}
+	if got := <-dialer.dialed; got != "192.168.127.2:22" {
+		t.Fatalf("expected a dial to the guest address, got %q", got)
+	}
+
+	conn.Write([]byte("ping")) //nolint:errcheck
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("expected the tunnel to relay, got %q (%v)", buf, err)
+	}
+}
+
+func TestConnectProxy_RejectsHostnames(t *testing.T) {
+	path := startTestConnectProxy(t, &echoDialer{})
+	resp, err := unixClient(path).Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a hostname target, got %d", resp.StatusCode)
+	}
+}
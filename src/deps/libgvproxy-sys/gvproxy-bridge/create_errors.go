@@ -0,0 +1,79 @@
+package main
+
+// create_errors.go — Failure classes of gvproxy_create.
+//
+// gvproxy_create and gvproxy_create_from_template return a negative code
+// per failure class (the GVPROXY_ERR_* defines in the exported header, see
+// main.go), so callers can tell a port another process holds from a typo in
+// the config without parsing messages. The message itself still goes to
+// errOut and gvproxy_last_error.
+//
+// Errors are classified where they arise with classify; the innermost class
+// wins, so a generic wrapper does not hide the specific reason. Failures
+// nobody classified are GVPROXY_ERR_OTHER (-1), the code every failure
+// returned before.
+
+import (
+	"encoding/json"
+	"errors"
+	"syscall"
+)
+
+// createErrorClass is the failure class of a create.
+type createErrorClass int
+
+const (
+	createErrOther        createErrorClass = iota
+	createErrConfig                        // the config does not parse or is invalid
+	createErrSocket                        // the VM link socket cannot be created
+	createErrPortConflict                  // a mapped host port is in use or reserved
+	createErrUnsupported                   // a requested feature is unavailable on this platform
+	createErrLimit                         // a limit (limits.go) would be exceeded
+)
+
+// classifiedError carries the failure class of a create error.
+type classifiedError struct {
+	class createErrorClass
+	err   error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+func (e *classifiedError) Unwrap() error { return e.err }
+
+// classify marks err as a failure of class, unless it already has one.
+func classify(class createErrorClass, err error) error {
+	var classified *classifiedError
+	if err == nil || errors.As(err, &classified) {
+		return err
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// createErrorClassOf returns the failure class of a create error.
+func createErrorClassOf(err error) createErrorClass {
+	var (
+		classified *classifiedError
+		limit      *limitError
+		syntax     *json.SyntaxError
+		typ        *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &classified):
+		return classified.class
+	case errors.As(err, &limit):
+		return createErrLimit
+	case errors.As(err, &syntax), errors.As(err, &typ):
+		return createErrConfig
+	case errors.Is(err, syscall.EADDRINUSE):
+		return createErrPortConflict
+	}
+	return createErrOther
+}
+
+// portBindError classifies a failure to bind mapped host ports.
+func portBindError(err error) error {
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return classify(createErrPortConflict, err)
+	}
+	return classify(createErrSocket, err)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassify_InnermostClassWins(t *testing.T) {
+	inner := classify(createErrUnsupported, errors.New("no vsock here"))
+	err := classify(createErrSocket, fmt.Errorf("listen: %w", inner))
+	if got := createErrorClassOf(err); got != createErrUnsupported {
+		t.Fatalf("class = %d, want unsupported", got)
+	}
+	if err.Error() != "listen: no vsock here" {
+		t.Fatalf("message changed: %q", err)
+	}
+	if classify(createErrConfig, nil) != nil {
+		t.Fatal("classify(nil) != nil")
+	}
+}
+
+func TestCreateErrorClassOf_Unclassified(t *testing.T) {
+	var config GvproxyConfig
+	parseErr := json.Unmarshal([]byte(`{"mtu": "big"}`), &config)
+	for name, tc := range map[string]struct {
+		err  error
+		want createErrorClass
+	}{
+		"json":   {parseErr, createErrConfig},
+		"limit":  {&limitError{Limit: "max_instances", Max: 1, Have: 1}, createErrLimit},
+		"other":  {errors.New("boom"), createErrOther},
+		"in use": {portBindError(errAddrInUse()), createErrPortConflict},
+	} {
+		if got := createErrorClassOf(tc.err); got != tc.want {
+			t.Errorf("%s: class = %d, want %d", name, got, tc.want)
+		}
+	}
+}
+
+// errAddrInUse returns the error binding a host port that is taken.
+func errAddrInUse() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer l.Close()
+	_, err = net.Listen("tcp", l.Addr().String())
+	return err
+}
+
+func TestCreateErrorCode(t *testing.T) {
+	for class, want := range map[createErrorClass]int64{
+		createErrOther:        -1,
+		createErrConfig:       -2,
+		createErrSocket:       -3,
+		createErrPortConflict: -4,
+		createErrUnsupported:  -5,
+		createErrLimit:        -6,
+	} {
+		if got := int64(createErrorCode(classify(class, errors.New("x")))); got != want {
+			t.Errorf("class %d: code %d, want %d", class, got, want)
+		}
+	}
+}
+
+func TestStartInstance_ClassifiesFailures(t *testing.T) {
+	taken, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	takenPort := uint16(taken.Addr().(*net.TCPAddr).Port)
+
+	for name, tc := range map[string]struct {
+		mutate func(*GvproxyConfig)
+		want   createErrorClass
+	}{
+		"bad protocol":  {func(c *GvproxyConfig) { c.Protocol = "QEMU" }, createErrConfig},
+		"unsupported":   {func(c *GvproxyConfig) { c.Protocol = "hyperkit" }, createErrUnsupported},
+		"reserved port": {func(c *GvproxyConfig) { c.PortMappings = []PortMapping{{HostPort: 22, GuestPort: 22}} }, createErrPortConflict},
+		"port in use":   {func(c *GvproxyConfig) { c.PortMappings = []PortMapping{{HostPort: takenPort, GuestPort: 80}} }, createErrPortConflict},
+		"socket":        {func(c *GvproxyConfig) { c.SocketPath = filepath.Join(t.TempDir(), "missing", "vm.sock") }, createErrSocket},
+	} {
+		config := testGvproxyConfig()
+		config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+		tc.mutate(&config)
+		err := startInstance(5907, config)
+		if err == nil {
+			stopTestInstance(5907)
+		}
+		if got := createErrorClassOf(err); got != tc.want {
+			t.Errorf("%s: class %d (%v), want %d", name, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+// cstring_owner.go — Ownership of C strings returned across the FFI.
+//
+// Every string an export hands to the caller is allocated with C.CString and
+// must come back exactly once through gvproxy_free_string. Debug builds
+// (build tag gvproxy_debug, set by build.rs for debug profiles) record each
+// returned pointer, so a double free or a pointer the bridge never returned
+// is reported as an EventFFIMisuse diagnostic instead of corrupting the C
+// heap. Release builds compile the tracking away.
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventFFIMisuse fires (debug builds only) when gvproxy_free_string receives
+// a pointer it must not free. Fields: kind ("double_free" or
+// "foreign_pointer"), pointer. It is not tied to an instance: instance_id
+// is 0.
+const EventFFIMisuse = "ffi_misuse"
+
+// cstringRelease classifies a pointer passed to gvproxy_free_string.
+type cstringRelease int
+
+const (
+	cstringOwned   cstringRelease = iota // returned by the bridge and not yet freed
+	cstringFreed                         // already freed: double free
+	cstringForeign                       // never returned by the bridge
+)
+
+func (r cstringRelease) String() string {
+	switch r {
+	case cstringFreed:
+		return "double_free"
+	case cstringForeign:
+		return "foreign_pointer"
+	default:
+		return "owned"
+	}
+}
+
+// returnCString allocates s as a C string owned by the caller, to be freed
+// with gvproxy_free_string.
+func returnCString(s string) *C.char {
+	p := C.CString(s)
+	cstrings.track(unsafe.Pointer(p))
+	return p
+}
+
+//export gvproxy_free_string
+func gvproxy_free_string(str *C.char) {
+	if str == nil {
+		return
+	}
+	if r := cstrings.release(unsafe.Pointer(str)); r != cstringOwned {
+		// Freeing it anyway would corrupt the heap; leak it and report.
+		pointer := fmt.Sprintf("%p", unsafe.Pointer(str))
+		logrus.WithFields(logrus.Fields{"kind": r.String(), "pointer": pointer}).Error("gvproxy_free_string called with a pointer the bridge does not own")
+		emitEvent(0, EventFFIMisuse, map[string]any{"kind": r.String(), "pointer": pointer})
+		return
+	}
+	C.free(unsafe.Pointer(str))
+}
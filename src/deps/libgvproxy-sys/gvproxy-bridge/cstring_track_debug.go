@@ -0,0 +1,60 @@
+//go:build gvproxy_debug
+
+package main
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// cstringTracking reports whether returned C strings are tracked.
+const cstringTracking = true
+
+// maxFreedCStrings bounds the freed-pointer history used to tell double
+// frees from foreign pointers. Past it, the history restarts and an old
+// double free is reported as a foreign pointer.
+const maxFreedCStrings = 4096
+
+// cstringTracker records C strings handed to the caller.
+type cstringTracker struct {
+	mu    sync.Mutex
+	live  map[uintptr]struct{}
+	freed map[uintptr]struct{}
+}
+
+var cstrings = &cstringTracker{
+	live:  make(map[uintptr]struct{}),
+	freed: make(map[uintptr]struct{}),
+}
+
+func (t *cstringTracker) track(p unsafe.Pointer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.live[uintptr(p)] = struct{}{}
+	delete(t.freed, uintptr(p)) // malloc reused the address
+}
+
+func (t *cstringTracker) release(p unsafe.Pointer) cstringRelease {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	addr := uintptr(p)
+	if _, ok := t.live[addr]; ok {
+		delete(t.live, addr)
+		if len(t.freed) >= maxFreedCStrings {
+			t.freed = make(map[uintptr]struct{})
+		}
+		t.freed[addr] = struct{}{}
+		return cstringOwned
+	}
+	if _, ok := t.freed[addr]; ok {
+		return cstringFreed
+	}
+	return cstringForeign
+}
+
+// outstanding is the number of returned strings not yet freed.
+func (t *cstringTracker) outstanding() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.live)
+}
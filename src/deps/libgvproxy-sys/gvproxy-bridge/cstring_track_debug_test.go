@@ -0,0 +1,33 @@
+//go:build gvproxy_debug
+
+package main
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestCStringTracker_DetectsDoubleFreeAndForeignPointers(t *testing.T) {
+	tr := &cstringTracker{live: make(map[uintptr]struct{}), freed: make(map[uintptr]struct{})}
+	owned, foreign := new(byte), new(byte)
+
+	tr.track(unsafe.Pointer(owned))
+	if tr.outstanding() != 1 {
+		t.Fatalf("expected 1 outstanding string, got %d", tr.outstanding())
+	}
+	if r := tr.release(unsafe.Pointer(owned)); r != cstringOwned {
+		t.Fatalf("first free: got %v", r)
+	}
+	if r := tr.release(unsafe.Pointer(owned)); r != cstringFreed {
+		t.Fatalf("second free: got %v, want double_free", r)
+	}
+	if r := tr.release(unsafe.Pointer(foreign)); r != cstringForeign {
+		t.Fatalf("foreign free: got %v, want foreign_pointer", r)
+	}
+
+	// malloc may hand the same address out again.
+	tr.track(unsafe.Pointer(owned))
+	if r := tr.release(unsafe.Pointer(owned)); r != cstringOwned {
+		t.Fatalf("reused address: got %v", r)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !gvproxy_debug
+
+package main
+
+import "unsafe"
+
+// cstringTracking reports whether returned C strings are tracked.
+const cstringTracking = false
+
+// cstringTracker is a no-op outside debug builds: every pointer is trusted.
+type cstringTracker struct{}
+
+var cstrings cstringTracker
+
+func (cstringTracker) track(unsafe.Pointer) {}
+
+func (cstringTracker) release(unsafe.Pointer) cstringRelease { return cstringOwned }
+
+func (cstringTracker) outstanding() int { return 0 }
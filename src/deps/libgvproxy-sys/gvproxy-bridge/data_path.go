@@ -0,0 +1,129 @@
+package main
+
+// data_path.go — The middleware chain frames cross on the hypervisor link.
+//
+// Every feature that looks at or edits link frames used to be one more
+// entry in a flat list of rewriters and observers handed to linkConn, and
+// the order they ran in was the order main.go happened to append them.
+// They are now grouped into named stages, each of which can be built and
+// tested on its own:
+//
+//   - "policy": rewriters that change what crosses the link
+//     (strip_tcp_timestamps).
+//   - "capture": frame traces and the frame ring of support bundles.
+//   - "accounting": milestones, guest info and restarts, DHCP lease
+//     events, MTU advice, send stalls and guest liveness.
+//
+// A frame goes through the stages in order in both directions, ingress
+// frames before the switch sees them and egress frames before they are
+// written. Within a stage, rewriters run before observers. data_path_order
+// changes the order, e.g. ["capture", "policy", "accounting"] captures
+// frames as the guest sent them rather than as they were delivered.
+// Stages it leaves out run after the listed ones, in the default order. A
+// new cross-cutting feature adds a stage name here and fills it in
+// buildDataPath's caller.
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Data path stage names (data_path_order values).
+const (
+	dataPathPolicy     = "policy"
+	dataPathCapture    = "capture"
+	dataPathAccounting = "accounting"
+)
+
+// defaultDataPathOrder keeps frames rewritten before anything observes
+// them.
+var defaultDataPathOrder = []string{dataPathPolicy, dataPathCapture, dataPathAccounting}
+
+// dataPathStage is one middleware of the data path.
+type dataPathStage struct {
+	name      string
+	rewriters []frameRewriter
+	observers []frameObserver
+}
+
+// dataPath is the ordered chain of stages.
+type dataPath []dataPathStage
+
+// checkDataPathOrder validates data_path_order.
+func checkDataPathOrder(order []string) error {
+	seen := make(map[string]bool, len(order))
+	for _, name := range order {
+		if !slices.Contains(defaultDataPathOrder, name) {
+			return fmt.Errorf("data_path_order: unknown stage %q (want one of %v)", name, defaultDataPathOrder)
+		}
+		if seen[name] {
+			return fmt.Errorf("data_path_order: stage %q is listed twice", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// buildDataPath orders stages by order (already checked), followed by the
+// stages it leaves out in the default order. Empty stages are skipped.
+func buildDataPath(order []string, stages map[string]dataPathStage) dataPath {
+	names := append([]string(nil), order...)
+	for _, name := range defaultDataPathOrder {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	var path dataPath
+	for _, name := range names {
+		stage := stages[name]
+		if len(stage.rewriters) == 0 && len(stage.observers) == 0 {
+			continue
+		}
+		stage.name = name
+		path = append(path, stage)
+	}
+	return path
+}
+
+// rewrites reports whether any stage edits frames.
+func (p dataPath) rewrites() bool {
+	for _, stage := range p {
+		if len(stage.rewriters) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns the stage names in order, for stats and logs.
+func (p dataPath) names() []string {
+	names := make([]string, 0, len(p))
+	for _, stage := range p {
+		names = append(names, stage.name)
+	}
+	return names
+}
+
+// ingress runs a guest → gateway frame through the chain.
+func (p dataPath) ingress(frame []byte) {
+	for _, stage := range p {
+		for _, r := range stage.rewriters {
+			r.rewriteFrame(frame)
+		}
+		for _, o := range stage.observers {
+			o.ingressFrame(frame)
+		}
+	}
+}
+
+// egress runs a gateway → guest frame through the chain.
+func (p dataPath) egress(frame []byte) {
+	for _, stage := range p {
+		for _, r := range stage.rewriters {
+			r.rewriteFrame(frame)
+		}
+		for _, o := range stage.observers {
+			o.egressFrame(frame)
+		}
+	}
+}
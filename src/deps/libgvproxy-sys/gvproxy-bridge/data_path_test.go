@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+// upperRewriter upper-cases frames, standing in for a policy rewriter.
+type upperRewriter struct{}
+
+func (upperRewriter) rewriteFrame(frame []byte) {
+	copy(frame, bytes.ToUpper(frame))
+}
+
+func TestCheckDataPathOrder(t *testing.T) {
+	if err := checkDataPathOrder([]string{dataPathCapture, dataPathPolicy}); err != nil {
+		t.Fatalf("checkDataPathOrder: %v", err)
+	}
+	for _, bad := range [][]string{{"impairment"}, {dataPathCapture, dataPathCapture}} {
+		if err := checkDataPathOrder(bad); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+}
+
+func TestBuildDataPath(t *testing.T) {
+	stages := map[string]dataPathStage{
+		dataPathPolicy:     {rewriters: []frameRewriter{upperRewriter{}}},
+		dataPathCapture:    {observers: []frameObserver{&recordingObserver{}}},
+		dataPathAccounting: {observers: []frameObserver{&recordingObserver{}}},
+	}
+	if got := strings.Join(buildDataPath(nil, stages).names(), ","); got != "policy,capture,accounting" {
+		t.Errorf("default order = %s", got)
+	}
+	if got := strings.Join(buildDataPath([]string{dataPathAccounting}, stages).names(), ","); got != "accounting,policy,capture" {
+		t.Errorf("order = %s, want unlisted stages after accounting", got)
+	}
+	delete(stages, dataPathAccounting)
+	if got := strings.Join(buildDataPath(nil, stages).names(), ","); got != "policy,capture" {
+		t.Errorf("order = %s, want the empty stage skipped", got)
+	}
+}
+
+// TestLinkConn_DataPathOrder checks that a capture stage ahead of the policy
+// stage sees frames as sent, in both directions, while the peer gets them
+// rewritten.
+func TestLinkConn_DataPathOrder(t *testing.T) {
+	for _, tc := range []struct {
+		order []string
+		seen  string
+	}{
+		{nil, "HELLO"},
+		{[]string{dataPathCapture, dataPathPolicy}, "hello"},
+	} {
+		vm, gw := net.Pipe()
+		captured := &recordingObserver{}
+		link := newDataPathLinkConn(gw, types.QemuProtocol, buildDataPath(tc.order, map[string]dataPathStage{
+			dataPathPolicy:  {rewriters: []frameRewriter{upperRewriter{}}},
+			dataPathCapture: {observers: []frameObserver{captured}},
+		}))
+
+		go func() {
+			vm.Write(qemuFrame("hello")) //nolint:errcheck
+		}()
+		buf := make([]byte, 64)
+		n, err := link.Read(buf)
+		if err != nil || string(buf[4:n]) != "HELLO" {
+			t.Fatalf("%v: read %q, %v; want the rewritten frame", tc.order, buf[:n], err)
+		}
+
+		go func() {
+			link.Write(qemuFrame("hello")) //nolint:errcheck
+		}()
+		n, err = vm.Read(buf)
+		if err != nil || string(buf[4:n]) != "HELLO" {
+			t.Fatalf("%v: peer got %q, %v; want the rewritten frame", tc.order, buf[:n], err)
+		}
+		vm.Close()
+		link.Close()
+
+		if len(captured.ingress) != 1 || string(captured.ingress[0]) != tc.seen {
+			t.Errorf("%v: captured ingress %q, want %q", tc.order, captured.ingress, tc.seen)
+		}
+		if len(captured.egress) != 1 || string(captured.egress[0]) != tc.seen {
+			t.Errorf("%v: captured egress %q, want %q", tc.order, captured.egress, tc.seen)
+		}
+	}
+}
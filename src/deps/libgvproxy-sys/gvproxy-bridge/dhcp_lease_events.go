@@ -0,0 +1,99 @@
+package main
+
+// dhcp_lease_events.go — Tell the embedder when a guest has its lease.
+//
+// Port-dependent workflows (health checks, SSH, published ports) only make
+// sense once the guest's network is up, and the DHCPACK is the moment it
+// is: the guest now has its address. Boxlite used to poll the lease table
+// or just wait. The gateway's DHCPACKs are observed on the link and each
+// one is reported to the event callback as a dhcp_lease event, with the
+// hostname the client asked for in its preceding DHCPREQUEST. Renewals are
+// reported too, flagged, so a consumer only interested in the guest coming
+// up can skip them.
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventDHCPLease fires when the gateway acknowledges a DHCP lease. Fields:
+// ip, mac, hostname ("" if the client sent none), lease_seconds, renewal
+// (the client was extending a lease it held: its request had ciaddr set).
+const EventDHCPLease = "dhcp_lease"
+
+// dhcpLeases is a frameObserver reporting DHCPACKs as dhcp_lease events.
+type dhcpLeases struct {
+	instanceID int64
+
+	mu       sync.Mutex
+	requests map[string]dhcpLeaseRequest // by client MAC, the last request
+}
+
+// dhcpLeaseRequest is what a DHCPACK's event takes from the request it
+// answers.
+type dhcpLeaseRequest struct {
+	hostname string
+	renewal  bool
+}
+
+func newDHCPLeases(instanceID int64) *dhcpLeases {
+	return &dhcpLeases{instanceID: instanceID, requests: make(map[string]dhcpLeaseRequest)}
+}
+
+func (l *dhcpLeases) ingressFrame(frame []byte) {
+	udp := frameUDP(frame)
+	if udp == nil || udp.DestinationPort() != dhcpServerPort {
+		return
+	}
+	msg := udp.Payload()
+	if dhcpMessageType(msg) != dhcpMsgRequest || len(msg) < dhcpChaddrOffset+6 {
+		return
+	}
+	mac := net.HardwareAddr(msg[dhcpChaddrOffset : dhcpChaddrOffset+6]).String()
+	ciaddr := net.IP(msg[dhcpCiaddrOffset : dhcpCiaddrOffset+4])
+	l.mu.Lock()
+	l.requests[mac] = dhcpLeaseRequest{
+		hostname: string(dhcpOption(msg, dhcpOptHostname)),
+		renewal:  !ciaddr.Equal(net.IPv4zero),
+	}
+	l.mu.Unlock()
+}
+
+func (l *dhcpLeases) egressFrame(frame []byte) {
+	if !isDHCPAck(frame) {
+		return
+	}
+	msg := frameUDP(frame).Payload()
+	if len(msg) < dhcpChaddrOffset+6 {
+		return
+	}
+	ip := net.IP(msg[dhcpYiaddrOffset : dhcpYiaddrOffset+4]).String()
+	mac := net.HardwareAddr(msg[dhcpChaddrOffset : dhcpChaddrOffset+6]).String()
+	var leaseSeconds uint32
+	if opt := dhcpOption(msg, dhcpOptLeaseTime); len(opt) == 4 {
+		leaseSeconds = binary.BigEndian.Uint32(opt)
+	}
+
+	l.mu.Lock()
+	req := l.requests[mac]
+	l.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"id":            l.instanceID,
+		"ip":            ip,
+		"mac":           mac,
+		"hostname":      req.hostname,
+		"lease_seconds": leaseSeconds,
+		"renewal":       req.renewal,
+	}).Info("Guest DHCP lease acknowledged")
+	emitEvent(l.instanceID, EventDHCPLease, map[string]any{
+		"ip":            ip,
+		"mac":           mac,
+		"hostname":      req.hostname,
+		"lease_seconds": leaseSeconds,
+		"renewal":       req.renewal,
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// testDHCPLeaseFrames returns a DHCPREQUEST from mac (renewing ciaddr when
+// set, with hostname) and the gateway's DHCPACK leasing ip for an hour.
+func testDHCPLeaseFrames(mac, ciaddr, hostname, ip string) (request, ack []byte) {
+	hw, _ := net.ParseMAC(mac)
+
+	req := testDHCPMessage(dhcpMsgRequest)
+	copy(req[dhcpChaddrOffset:], hw)
+	if ciaddr != "" {
+		copy(req[dhcpCiaddrOffset:], net.ParseIP(ciaddr).To4())
+	}
+	req = req[:len(req)-1] // drop END
+	req = append(req, dhcpOptHostname, byte(len(hostname)))
+	req = append(req, hostname...)
+	req = append(req, dhcpOptEnd)
+
+	reply := testDHCPMessage(dhcpMsgAck)
+	copy(reply[dhcpChaddrOffset:], hw)
+	copy(reply[dhcpYiaddrOffset:], net.ParseIP(ip).To4())
+	reply = reply[:len(reply)-1]
+	reply = append(reply, dhcpOptLeaseTime, 4, 0, 0, 0x0e, 0x10, dhcpOptEnd)
+
+	return testIPv4Frame(header.UDPProtocolNumber, "0.0.0.0", "255.255.255.255", testUDPDatagram(68, 67, req)),
+		testIPv4Frame(header.UDPProtocolNumber, "192.168.127.1", ip, testUDPDatagram(67, 68, reply))
+}
+
+func TestDHCPLeases_ReportsAcks(t *testing.T) {
+	const id = 5939
+	l := newDHCPLeases(id)
+
+	request, ack := testDHCPLeaseFrames("5a:94:ef:e4:0c:ee", "", "box-1", "192.168.127.2")
+	l.ingressFrame(request)
+	l.egressFrame(request) // not from the server
+	l.egressFrame(ack)
+
+	events := recentEventsFor(id)
+	if len(events) != 1 || events[0].Type != EventDHCPLease {
+		t.Fatalf("events = %+v, want one dhcp_lease", events)
+	}
+	f := events[0].Fields
+	if f["ip"] != "192.168.127.2" || f["mac"] != "5a:94:ef:e4:0c:ee" || f["hostname"] != "box-1" ||
+		f["lease_seconds"] != uint32(3600) || f["renewal"] != false {
+		t.Fatalf("fields = %v", f)
+	}
+
+	request, ack = testDHCPLeaseFrames("5a:94:ef:e4:0c:ee", "192.168.127.2", "box-1", "192.168.127.2")
+	l.ingressFrame(request)
+	l.egressFrame(ack)
+	if events := recentEventsFor(id); len(events) != 2 || events[1].Fields["renewal"] != true {
+		t.Fatalf("events = %+v, want the renewal flagged", events)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+// dhcp_options.go — Extra options in the gateway's DHCP replies.
+//
+// The DHCP server only offers what it derives from the subnet and gateway:
+// mask, router, nameservers, search domains and the link MTU. dhcp_options
+// pushes more to the guest: NTP servers (option 42), an interface MTU
+// lower than the link's (26, e.g. for a tunnel inside the guest), a domain
+// name (15) and vendor-specific information (43, as hex). Setting any of
+// them makes the bridge's DHCP server (forked_dhcp.go) take over.
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/miekg/dns"
+)
+
+// minDHCPInterfaceMTU is the smallest MTU option 26 allows (RFC 2132).
+const minDHCPInterfaceMTU = 68
+
+// DHCPOptions is the dhcp_options config.
+type DHCPOptions struct {
+	NTPServers []string `json:"ntp_servers,omitempty"`
+	// MTU overrides the interface MTU advertised; it may not exceed mtu.
+	MTU        uint16 `json:"mtu,omitempty"`
+	DomainName string `json:"domain_name,omitempty"`
+	// VendorSpecific is the option 43 payload, hex encoded.
+	VendorSpecific string `json:"vendor_specific,omitempty"`
+}
+
+// buildDHCPOptions validates dhcp_options and returns the options to add to
+// every reply; nil when unset.
+func buildDHCPOptions(config GvproxyConfig) ([]dhcpv4.Option, error) {
+	o := config.DHCPOptions
+	if o == nil {
+		return nil, nil
+	}
+	var options []dhcpv4.Option
+	if len(o.NTPServers) > 0 {
+		servers := make([]net.IP, 0, len(o.NTPServers))
+		for _, server := range o.NTPServers {
+			ip := net.ParseIP(server).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("dhcp_options: ntp server %q is not an IPv4 address", server)
+			}
+			servers = append(servers, ip)
+		}
+		options = append(options, dhcpv4.OptNTPServers(servers...))
+	}
+	if o.MTU != 0 {
+		if o.MTU < minDHCPInterfaceMTU || config.MTU != 0 && o.MTU > config.MTU {
+			return nil, fmt.Errorf("dhcp_options: mtu %d is not between %d and the link mtu %d", o.MTU, minDHCPInterfaceMTU, config.MTU)
+		}
+		options = append(options, dhcpv4.Option{Code: dhcpv4.OptionInterfaceMTU, Value: dhcpv4.Uint16(o.MTU)})
+	}
+	if o.DomainName != "" {
+		if _, ok := dns.IsDomainName(o.DomainName); !ok {
+			return nil, fmt.Errorf("dhcp_options: invalid domain_name %q", o.DomainName)
+		}
+		options = append(options, dhcpv4.OptDomainName(o.DomainName))
+	}
+	if o.VendorSpecific != "" {
+		value, err := hex.DecodeString(o.VendorSpecific)
+		if err != nil || len(value) > 255 {
+			return nil, fmt.Errorf("dhcp_options: vendor_specific must be at most 255 hex-encoded bytes")
+		}
+		options = append(options, dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, value))
+	}
+	return options, nil
+}
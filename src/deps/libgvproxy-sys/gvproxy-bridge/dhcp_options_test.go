@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/tap"
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestBuildDHCPOptions(t *testing.T) {
+	config := testGvproxyConfig()
+	if options, err := buildDHCPOptions(config); options != nil || err != nil {
+		t.Fatalf("unset options = %v, %v; want none", options, err)
+	}
+	for name, o := range map[string]DHCPOptions{
+		"ntp not an IP":  {NTPServers: []string{"ntp.corp"}},
+		"mtu too small":  {MTU: 60},
+		"mtu above link": {MTU: 9000},
+		"domain name":    {DomainName: "corp..example"},
+		"vendor not hex": {VendorSpecific: "xyz"},
+	} {
+		config.DHCPOptions = &o
+		if _, err := buildDHCPOptions(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDHCPReply_AddsConfiguredOptions(t *testing.T) {
+	config := testGvproxyConfig()
+	config.DHCPOptions = &DHCPOptions{
+		NTPServers:     []string{"10.0.0.123", "10.0.1.123"},
+		MTU:            1400,
+		DomainName:     "corp.example",
+		VendorSpecific: "0104c0a80001",
+	}
+	options, err := buildDHCPOptions(config)
+	if err != nil {
+		t.Fatalf("buildDHCPOptions: %v", err)
+	}
+	tapConfig := buildTapConfig(config, types.QemuProtocol)
+	_, subnet, _ := net.ParseCIDR(config.Subnet)
+	pool := tap.NewIPPool(subnet)
+	pool.Reserve(net.ParseIP(config.GuestIP), config.GuestMac)
+
+	mac, _ := net.ParseMAC(config.GuestMac)
+	discover, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatalf("NewDiscovery: %v", err)
+	}
+	reply := dhcpReply(tapConfig, pool, nil, dhcpNameservers(config), options, discover)
+	if reply == nil {
+		t.Fatal("no reply to DISCOVER")
+	}
+	if ntp := reply.NTPServers(); len(ntp) != 2 || ntp[0].String() != "10.0.0.123" || ntp[1].String() != "10.0.1.123" {
+		t.Errorf("NTP servers = %v", ntp)
+	}
+	if mtu, err := dhcpv4.GetUint16(dhcpv4.OptionInterfaceMTU, reply.Options); err != nil || mtu != 1400 {
+		t.Errorf("interface MTU = %d, %v; want the configured one over the link's", mtu, err)
+	}
+	if name := reply.DomainName(); name != "corp.example" {
+		t.Errorf("domain name = %q", name)
+	}
+	if vendor := reply.Options.Get(dhcpv4.OptionVendorSpecificInformation); !bytes.Equal(vendor, []byte{1, 4, 192, 168, 0, 1}) {
+		t.Errorf("vendor specific = %x", vendor)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+// dhcp_range.go — Where DHCP clients without a static lease get addresses.
+//
+// Static leases (guest_mac, guests) cover the interfaces boxlite knows
+// about. Anything else that asks, a second NIC in the guest or a VM nested
+// inside it, gets the lowest free address of the whole subnet from
+// upstream's pool, which can be one the caller meant to configure by hand
+// later. dhcp_range confines those dynamic leases to [start, end]: the
+// bridge's DHCP server (forked_dhcp.go) takes over and assigns the first
+// address of the range nobody holds. Static leases are answered as before,
+// wherever they are. Leases are not reclaimed, so the range bounds how many
+// distinct MACs can get one.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/tap"
+)
+
+// DHCPRange is the dhcp_range config: the first and last address, inclusive.
+type DHCPRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// dhcpRange assigns dynamic leases from a range. A nil *dhcpRange leaves
+// assignment to the pool.
+type dhcpRange struct {
+	start, end net.IP // 4-byte form
+
+	mu sync.Mutex // serializes lookups and reservations in the pool
+}
+
+// newDHCPRange validates dhcp_range; nil when unset.
+func newDHCPRange(config GvproxyConfig) (*dhcpRange, error) {
+	r := config.DHCPRange
+	if r == nil {
+		return nil, nil
+	}
+	start, end := net.ParseIP(r.Start).To4(), net.ParseIP(r.End).To4()
+	if start == nil || end == nil {
+		return nil, fmt.Errorf("dhcp_range %s-%s: start and end must be IPv4 addresses", r.Start, r.End)
+	}
+	if bytes.Compare(start, end) > 0 {
+		return nil, fmt.Errorf("dhcp_range %s-%s: start is after end", r.Start, r.End)
+	}
+	_, subnet, err := net.ParseCIDR(config.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet %q: %w", config.Subnet, err)
+	}
+	network := subnet.IP.To4()
+	broadcast := make(net.IP, 4)
+	for i := range broadcast {
+		broadcast[i] = network[i] | ^subnet.Mask[i]
+	}
+	if !subnet.Contains(start) || !subnet.Contains(end) || start.Equal(network) || end.Equal(broadcast) {
+		return nil, fmt.Errorf("dhcp_range %s-%s: not within the host addresses of subnet %s", r.Start, r.End, config.Subnet)
+	}
+	d := &dhcpRange{start: start, end: end}
+	for field, ip := range map[string]string{"gateway_ip": config.GatewayIP, "host_ip": config.HostIP} {
+		if addr := net.ParseIP(ip).To4(); addr != nil && d.contains(addr) {
+			return nil, fmt.Errorf("dhcp_range %s-%s contains %s %s", r.Start, r.End, field, ip)
+		}
+	}
+	return d, nil
+}
+
+func (d *dhcpRange) contains(ip net.IP) bool {
+	return bytes.Compare(ip, d.start) >= 0 && bytes.Compare(ip, d.end) <= 0
+}
+
+// assign returns the address leased to mac, leasing it the first free
+// address of the range if it holds none.
+func (d *dhcpRange) assign(pool *tap.IPPool, mac string) (net.IP, error) {
+	if d == nil {
+		return pool.GetOrAssign(mac)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	leases := pool.Leases()
+	for ip, owner := range leases {
+		if owner == mac {
+			return net.ParseIP(ip), nil
+		}
+	}
+	for ip := append(net.IP(nil), d.start...); d.contains(ip); ip = nextIPv4(ip) {
+		if _, ok := leases[ip.String()]; !ok {
+			pool.Reserve(ip, mac)
+			return ip, nil
+		}
+	}
+	return nil, errors.New("dhcp_range exhausted")
+}
+
+// nextIPv4 returns the address after ip.
+func nextIPv4(ip net.IP) net.IP {
+	next := append(net.IP(nil), ip...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/tap"
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestNewDHCPRange(t *testing.T) {
+	config := testGvproxyConfig()
+	if d, err := newDHCPRange(config); d != nil || err != nil {
+		t.Fatalf("unset range = %v, %v; want nil", d, err)
+	}
+	config.DHCPRange = &DHCPRange{Start: "192.168.127.100", End: "192.168.127.101"}
+	if _, err := newDHCPRange(config); err != nil {
+		t.Fatalf("newDHCPRange: %v", err)
+	}
+
+	for name, r := range map[string]DHCPRange{
+		"not an IP":       {Start: "guest", End: "192.168.127.101"},
+		"reversed":        {Start: "192.168.127.101", End: "192.168.127.100"},
+		"outside subnet":  {Start: "192.168.127.100", End: "192.168.128.1"},
+		"network address": {Start: "192.168.127.0", End: "192.168.127.10"},
+		"broadcast":       {Start: "192.168.127.200", End: "192.168.127.255"},
+		"gateway":         {Start: "192.168.127.1", End: "192.168.127.10"},
+		"host":            {Start: "192.168.127.200", End: "192.168.127.254"},
+	} {
+		config.DHCPRange = &r
+		if _, err := newDHCPRange(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDHCPReply_AssignsFromRange(t *testing.T) {
+	config := testGvproxyConfig()
+	config.DHCPRange = &DHCPRange{Start: "192.168.127.100", End: "192.168.127.101"}
+	dynamic, err := newDHCPRange(config)
+	if err != nil {
+		t.Fatalf("newDHCPRange: %v", err)
+	}
+	tapConfig := buildTapConfig(config, types.QemuProtocol)
+	_, subnet, _ := net.ParseCIDR(config.Subnet)
+	pool := tap.NewIPPool(subnet)
+	pool.Reserve(net.ParseIP(config.GuestIP), config.GuestMac)
+	pool.Reserve(net.ParseIP("192.168.127.100"), "5a:94:ef:00:00:aa") // held by another client
+
+	offer := func(mac string) net.IP {
+		hw, _ := net.ParseMAC(mac)
+		discover, err := dhcpv4.NewDiscovery(hw)
+		if err != nil {
+			t.Fatalf("NewDiscovery: %v", err)
+		}
+		reply := dhcpReply(tapConfig, pool, dynamic, dhcpNameservers(config), nil, discover)
+		if reply == nil {
+			return nil
+		}
+		if dns := reply.DNS(); len(dns) != 1 || dns[0].String() != config.GatewayIP {
+			t.Errorf("DNS option = %v, want the gateway", dns)
+		}
+		return reply.YourIPAddr
+	}
+
+	if ip := offer(config.GuestMac); !ip.Equal(net.ParseIP(config.GuestIP)) {
+		t.Fatalf("static lease offered %v, want %s", ip, config.GuestIP)
+	}
+	if ip := offer("5a:94:ef:00:00:01"); !ip.Equal(net.ParseIP("192.168.127.101")) {
+		t.Fatalf("dynamic lease offered %v, want the first free address of the range", ip)
+	}
+	if ip := offer("5a:94:ef:00:00:01"); !ip.Equal(net.ParseIP("192.168.127.101")) {
+		t.Fatalf("repeated request offered %v, want the same lease", ip)
+	}
+	if ip := offer("5a:94:ef:00:00:02"); ip != nil {
+		t.Fatalf("exhausted range offered %v, want no reply", ip)
+	}
+}
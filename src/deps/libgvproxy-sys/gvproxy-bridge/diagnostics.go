@@ -0,0 +1,236 @@
+package main
+
+// diagnostics.go — Support bundles for bug reports.
+//
+// gvproxy_collect_diagnostics writes everything useful about one instance
+// into a directory the user can zip and attach to an issue:
+//
+//	bundle.json      what was collected, versions, and any per-file errors
+//	config.json      the creation config, secrets and CA key redacted
+//	tap_config.json  the effective gvisor-tap-vsock configuration
+//	stats.json       the gvproxy_get_stats document
+//	connections.json host forwards, their relayed connections and the
+//	                 netstack's transport endpoints
+//	events.json      recent events for the instance
+//	goroutines.txt   a full goroutine dump of the bridge
+//	frames.pcap      the last link frames (only with frame_ring_size)
+
+import "C"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+)
+
+const redacted = "[REDACTED]"
+
+// DiagnosticsBundle is bundle.json.
+type DiagnosticsBundle struct {
+	InstanceID            int64             `json:"instance_id"`
+	CreatedAt             time.Time         `json:"created_at"`
+	GvisorTapVsockVersion string            `json:"gvisor_tap_vsock_version"`
+	GoVersion             string            `json:"go_version"`
+	Files                 []string          `json:"files"`
+	Errors                map[string]string `json:"errors,omitempty"` // file → why it is missing
+}
+
+// NetstackEndpoint is one transport endpoint registered in the netstack.
+type NetstackEndpoint struct {
+	Protocol string `json:"protocol"`
+	Local    string `json:"local"`
+	Remote   string `json:"remote,omitempty"`
+	State    string `json:"state,omitempty"`
+}
+
+// diagnosticsConnections is connections.json.
+type diagnosticsConnections struct {
+	Forwards []ForwardStats      `json:"forwards"`
+	Relays   []ForwardConnection `json:"relays"` // forward_connections.go
+	Netstack []NetstackEndpoint  `json:"netstack"`
+}
+
+// redactedConfig returns config with secret values and the CA key removed.
+func redactedConfig(config GvproxyConfig) GvproxyConfig {
+	if config.Secrets != nil {
+		secrets := make([]SecretConfig, len(config.Secrets))
+		for i, secret := range config.Secrets {
+			secret.Value = redacted
+			secrets[i] = secret
+		}
+		config.Secrets = secrets
+	}
+	if config.CAKeyPEM != "" {
+		config.CAKeyPEM = redacted
+	}
+	return config
+}
+
+// netstackEndpoints lists the transport endpoints of the instance's netstack.
+func netstackEndpoints(vn *virtualnetwork.VirtualNetwork) ([]NetstackEndpoint, error) {
+	s, err := vnStack(vn)
+	if err != nil {
+		return nil, err
+	}
+	out := []NetstackEndpoint{}
+	for _, ep := range s.RegisteredEndpoints() {
+		e, ok := ep.(tcpip.Endpoint)
+		if !ok {
+			continue
+		}
+		info, ok := e.Info().(*stack.TransportEndpointInfo)
+		if !ok {
+			continue
+		}
+		entry := NetstackEndpoint{Local: endpointAddr(info.ID.LocalAddress, info.ID.LocalPort)}
+		if info.ID.RemotePort != 0 {
+			entry.Remote = endpointAddr(info.ID.RemoteAddress, info.ID.RemotePort)
+		}
+		switch info.TransProto {
+		case header.TCPProtocolNumber:
+			entry.Protocol = "tcp"
+			entry.State = tcp.EndpointState(e.State()).String()
+		case header.UDPProtocolNumber:
+			entry.Protocol = "udp"
+		default:
+			entry.Protocol = fmt.Sprint(info.TransProto)
+		}
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Protocol != out[j].Protocol {
+			return out[i].Protocol < out[j].Protocol
+		}
+		return out[i].Local+out[i].Remote < out[j].Local+out[j].Remote
+	})
+	return out, nil
+}
+
+func endpointAddr(addr tcpip.Address, port uint16) string {
+	return fmt.Sprintf("%s:%d", addr, port)
+}
+
+// collectDiagnostics writes the support bundle for instance into dir. Files
+// that cannot be produced are listed under errors in bundle.json; only
+// failing to create dir or bundle.json is an error.
+func collectDiagnostics(instance *GvproxyInstance, dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	bundle := DiagnosticsBundle{
+		InstanceID:            instance.ID,
+		CreatedAt:             time.Now().UTC(),
+		GvisorTapVsockVersion: gvisorTapVsockVersion(),
+		GoVersion:             runtime.Version(),
+		Errors:                make(map[string]string),
+	}
+	write := func(name string, produce func() ([]byte, error)) {
+		data, err := produce()
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, name), data, 0o600)
+		}
+		if err != nil {
+			bundle.Errors[name] = err.Error()
+			return
+		}
+		bundle.Files = append(bundle.Files, name)
+	}
+	writeJSON := func(name string, v func() (any, error)) {
+		write(name, func() ([]byte, error) {
+			doc, err := v()
+			if err != nil {
+				return nil, err
+			}
+			return json.MarshalIndent(doc, "", "  ")
+		})
+	}
+
+	instance.vnMu.RLock()
+	vn := instance.vn
+	instance.vnMu.RUnlock()
+
+	writeJSON("config.json", func() (any, error) { return redactedConfig(instance.config), nil })
+	writeJSON("tap_config.json", func() (any, error) { return instance.Config, nil })
+	write("stats.json", func() ([]byte, error) {
+		if vn == nil {
+			return nil, fmt.Errorf("virtual network not ready")
+		}
+		return []byte(collectInstanceStats(vn, instance, StatsFormatJSON)), nil
+	})
+	writeJSON("connections.json", func() (any, error) {
+		conns := diagnosticsConnections{Forwards: []ForwardStats{}, Relays: []ForwardConnection{}, Netstack: []NetstackEndpoint{}}
+		if instance.forwarder != nil {
+			conns.Forwards = instance.forwarder.Stats()
+			conns.Relays = instance.forwarder.Connections()
+		}
+		if vn != nil {
+			endpoints, err := netstackEndpoints(vn)
+			if err != nil {
+				return nil, err
+			}
+			conns.Netstack = endpoints
+		}
+		return conns, nil
+	})
+	writeJSON("events.json", func() (any, error) { return recentEventsFor(instance.ID), nil })
+	write("goroutines.txt", func() ([]byte, error) {
+		var buf bytes.Buffer
+		err := pprof.Lookup("goroutine").WriteTo(&buf, 2)
+		return buf.Bytes(), err
+	})
+	if instance.frames != nil {
+		write("frames.pcap", func() ([]byte, error) {
+			var buf bytes.Buffer
+			err := instance.frames.writePcap(&buf)
+			return buf.Bytes(), err
+		})
+	}
+
+	if len(bundle.Errors) == 0 {
+		bundle.Errors = nil
+	}
+	bundle.Files = append(bundle.Files, "bundle.json")
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "bundle.json"), data, 0o600)
+}
+
+// gvproxy_collect_diagnostics writes a support bundle for an instance into
+// dir (created if missing): redacted config, stats, connection table, recent
+// events, a goroutine dump and, with frame_ring_size, the last link frames as
+// a pcap. bundle.json lists what was written and why anything is missing.
+//
+// Returns 0 on success, -1 if the instance does not exist or dir cannot be
+// written.
+//
+//export gvproxy_collect_diagnostics
+func gvproxy_collect_diagnostics(id C.longlong, dir *C.char) C.int {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok || dir == nil {
+		return -1
+	}
+
+	path := C.GoString(dir)
+	if err := collectDiagnostics(instance, path); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id), "dir": path}).Error("Failed to write support bundle")
+		return -1
+	}
+	logrus.WithFields(logrus.Fields{"id": int64(id), "dir": path}).Info("Wrote support bundle")
+	return 0
+}
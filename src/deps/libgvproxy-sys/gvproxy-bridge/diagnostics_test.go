@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollectDiagnostics_WritesRedactedBundle(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gvd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(dir, "vm.sock")
+	config.FrameRingSize = 4
+	config.Secrets = []SecretConfig{{Name: "api", Hosts: []string{"api.example.com"}, Placeholder: "<KEY>", Value: "s3cr3t-value"}}
+	const id = 4300
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+	emitEvent(id, "test_event", nil)
+
+	instancesMu.RLock()
+	instance := instances[id]
+	instancesMu.RUnlock()
+	out := filepath.Join(dir, "bundle")
+	if err := collectDiagnostics(instance, out); err != nil {
+		t.Fatalf("collectDiagnostics: %v", err)
+	}
+
+	var bundle DiagnosticsBundle
+	data, err := os.ReadFile(filepath.Join(out, "bundle.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"config.json", "tap_config.json", "stats.json", "connections.json", "events.json", "goroutines.txt", "frames.pcap"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Errorf("%s missing (bundle errors: %v)", name, bundle.Errors)
+		}
+	}
+
+	cfg, _ := os.ReadFile(filepath.Join(out, "config.json"))
+	if strings.Contains(string(cfg), "s3cr3t-value") || !strings.Contains(string(cfg), redacted) {
+		t.Fatalf("secret values must be redacted: %s", cfg)
+	}
+	events, _ := os.ReadFile(filepath.Join(out, "events.json"))
+	if !strings.Contains(string(events), "test_event") {
+		t.Fatalf("recent events missing from bundle: %s", events)
+	}
+}
+
+func TestFrameRing_KeepsNewestFramesInOrder(t *testing.T) {
+	r := newFrameRing(2)
+	r.ingressFrame([]byte("one"))
+	r.egressFrame([]byte("two"))
+	r.ingressFrame([]byte("three"))
+
+	var buf strings.Builder
+	if err := r.writePcap(&buf); err != nil {
+		t.Fatal(err)
+	}
+	pcap := buf.String()
+	if len(pcap) != 24+16+3+16+5 {
+		t.Fatalf("expected header plus two records, got %d bytes", len(pcap))
+	}
+	if !strings.HasSuffix(pcap, "three") || strings.Contains(pcap, "one") {
+		t.Fatalf("ring must keep the newest frames oldest-first: %q", pcap[24:])
+	}
+}
+
+func TestRecentEventsFor_FiltersByInstance(t *testing.T) {
+	emitEvent(9001, "mine", nil)
+	emitEvent(9002, "theirs", nil)
+	for _, e := range recentEventsFor(9001) {
+		if e.InstanceID == 9002 {
+			t.Fatalf("events of other instances must be filtered: %+v", e)
+		}
+	}
+	events := recentEventsFor(9001)
+	if len(events) == 0 || events[len(events)-1].Type != "mine" {
+		t.Fatalf("expected the instance's latest event last, got %+v", events)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+// dial_guest.go — Ad-hoc connections from the embedder into the guest.
+//
+// gvproxy_dial_guest opens a TCP connection (or a connected UDP socket) from
+// the gateway to a guest port and hands the embedder one end of a socketpair
+// bridged to it. Health checks and exec channels can then talk to guest
+// services without a published host port or a forward: the connection exists
+// only inside the netstack and the returned FD. The bridge belongs to the
+// instance and is cut when it is destroyed.
+
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+)
+
+// dialGuestTimeout bounds the TCP handshake with the guest.
+const dialGuestTimeout = 10 * time.Second
+
+// dialGuest connects to ip:port inside vn over proto ("tcp" or "udp") and
+// returns the embedder's end of a socketpair bridged to the connection (see
+// bridgeToFD).
+func dialGuest(ctx context.Context, vn *virtualnetwork.VirtualNetwork, ip, proto string, port uint16, goroutines *tracker) (int, error) {
+	if vn == nil {
+		return -1, errors.New("virtual network not ready")
+	}
+	addr := net.JoinHostPort(ip, fmt.Sprint(port))
+
+	var guest net.Conn
+	sockType := syscall.SOCK_STREAM
+	switch proto {
+	case "tcp":
+		dialCtx, cancel := context.WithTimeout(ctx, dialGuestTimeout)
+		defer cancel()
+		conn, err := vn.DialContextTCP(dialCtx, addr)
+		if err != nil {
+			return -1, err
+		}
+		guest = conn
+	case "udp":
+		s, err := vnStack(vn)
+		if err != nil {
+			return -1, err
+		}
+		target := net.ParseIP(ip).To4()
+		if target == nil {
+			return -1, fmt.Errorf("guest address %q is not IPv4", ip)
+		}
+		conn, err := gonet.DialUDP(s, nil, &tcpip.FullAddress{NIC: 1, Addr: tcpip.AddrFrom4Slice(target), Port: port}, ipv4.ProtocolNumber)
+		if err != nil {
+			return -1, err
+		}
+		guest = conn
+		sockType = syscall.SOCK_DGRAM
+	default:
+		return -1, fmt.Errorf("unsupported protocol %q", proto)
+	}
+
+	return bridgeToFD(ctx, guest, sockType, "dial-guest-"+addr, goroutines)
+}
+
+// errInstanceClosing is returned for bridges requested during teardown.
+var errInstanceClosing = errors.New("instance is being destroyed")
+
+// bridgeToFD bridges conn to one end of a new socketpair of sockType and
+// returns the other end. conn is closed on failure. The bridge runs on a
+// goroutine of goroutines and is cut when ctx is done, so teardown does not
+// wait for the embedder to close its end.
+func bridgeToFD(ctx context.Context, conn net.Conn, sockType int, name string, goroutines *tracker) (int, error) {
+	fds, err := socketpair(sockType)
+	if err != nil {
+		conn.Close()
+		return -1, err
+	}
+	f := os.NewFile(uintptr(fds[1]), name)
+	local, err := net.FileConn(f)
+	f.Close() // FileConn dups; drop the original
+	if err != nil {
+		conn.Close()
+		closeFD(fds[0])
+		return -1, err
+	}
+
+	if !goroutines.trySpawn(func() {
+		stop := context.AfterFunc(ctx, func() {
+			local.Close()
+			conn.Close()
+		})
+		defer stop()
+		if sockType == syscall.SOCK_STREAM {
+			bridgeStream(local, conn)
+		} else {
+			bridgeDatagrams(local, conn)
+		}
+	}) {
+		local.Close()
+		conn.Close()
+		closeFD(fds[0])
+		return -1, errInstanceClosing
+	}
+	return fds[0], nil
+}
+
+// halfCloser is a connection whose write side can be shut down alone.
+type halfCloser interface {
+	CloseWrite() error
+}
+
+// bridgeStream copies both ways, passing each side's EOF on as a half-close,
+// and closes both once both directions are done.
+func bridgeStream(local, guest net.Conn) {
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src) //nolint:errcheck
+		if hc, ok := dst.(halfCloser); ok {
+			hc.CloseWrite() //nolint:errcheck
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go pipe(guest, local)
+	go pipe(local, guest)
+	<-done
+	<-done
+	local.Close()
+	guest.Close()
+}
+
+// bridgeDatagrams relays datagrams both ways until the embedder closes its
+// end.
+func bridgeDatagrams(local, guest net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 65535)
+		for {
+			n, err := guest.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err := local.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, 65535)
+	for {
+		n, err := local.Read(buf)
+		if err != nil {
+			break
+		}
+		guest.Write(buf[:n]) //nolint:errcheck // best effort, like any datagram
+	}
+	local.Close()
+	guest.Close()
+	<-done
+}
+
+// gvproxy_dial_guest connects to port on the guest over proto ("tcp" or
+// "udp"; NULL means "tcp") from inside the virtual network, and returns a
+// connected socket FD bridged to it: a stream socket for TCP, a datagram
+// socket for UDP. The caller owns the FD and closes it when done. No host
+// port or forward is involved.
+//
+// Returns -1 if the instance does not exist, its network is not ready, the
+// protocol is unknown, or the guest refused the connection.
+//
+//export gvproxy_dial_guest
+func gvproxy_dial_guest(id C.longlong, proto *C.char, port C.int) C.int {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok || port <= 0 || port > 65535 {
+		return -1
+	}
+	network := "tcp"
+	if proto != nil {
+		network = C.GoString(proto)
+	}
+
+	instance.vnMu.RLock()
+	vn := instance.vn
+	instance.vnMu.RUnlock()
+
+	fd, err := dialGuest(instance.ctx, vn, instance.config.GuestIP, network, uint16(port), &instance.goroutines)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id), "proto": network, "port": int(port)}).Warn("Failed to dial guest")
+		return -1
+	}
+	logrus.WithFields(logrus.Fields{"id": int64(id), "proto": network, "port": int(port), "fd": fd}).Debug("Dialed guest for embedder")
+	return C.int(fd)
+}
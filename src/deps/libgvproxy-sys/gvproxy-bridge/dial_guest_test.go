@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+)
+
+func fdConn(t *testing.T, fd int) net.Conn {
+	t.Helper()
+	f := os.NewFile(uintptr(fd), "test")
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	if err != nil {
+		t.Fatalf("FileConn: %v", err)
+	}
+	return conn
+}
+
+func TestBridgeToFD_Stream(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c) //nolint:errcheck
+	}()
+	guest, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	fd, err := bridgeToFD(context.Background(), guest, syscall.SOCK_STREAM, "test", nil)
+	if err != nil {
+		t.Fatalf("bridgeToFD: %v", err)
+	}
+	conn := fdConn(t, fd)
+	defer conn.Close()
+	conn.Write([]byte("ping")) //nolint:errcheck
+	// Our EOF reaches the guest as a half-close, so its echo loop ends and
+	// the reply is followed by EOF.
+	conn.(*net.UnixConn).CloseWrite() //nolint:errcheck
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "ping" {
+		t.Fatalf("expected echo then EOF over the FD, got %q (%v)", got, err)
+	}
+}
+
+// TestBridgeToFD_EndsWithInstance checks that a bridge runs on the
+// instance's tracker and ends when the instance is destroyed, while the
+// embedder still holds its FD, and that no bridge starts once teardown
+// waits.
+func TestBridgeToFD_EndsWithInstance(t *testing.T) {
+	for _, sockType := range []int{syscall.SOCK_STREAM, syscall.SOCK_DGRAM} {
+		guest, peer := net.Pipe()
+		defer peer.Close()
+		var goroutines tracker
+		ctx, cancel := context.WithCancel(context.Background())
+		fd, err := bridgeToFD(ctx, guest, sockType, "test", &goroutines)
+		if err != nil {
+			t.Fatalf("bridgeToFD: %v", err)
+		}
+		conn := fdConn(t, fd)
+		defer conn.Close()
+
+		cancel()
+		waited := make(chan struct{})
+		go func() {
+			goroutines.wait()
+			close(waited)
+		}()
+		select {
+		case <-waited:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("socket type %d: teardown still waits for the bridge", sockType)
+		}
+
+		guest, peer = net.Pipe()
+		defer peer.Close()
+		if _, err := bridgeToFD(ctx, guest, sockType, "test", &goroutines); !errors.Is(err, errInstanceClosing) {
+			t.Fatalf("socket type %d: bridge after teardown: err = %v", sockType, err)
+		}
+	}
+}
+
+func TestBridgeToFD_Datagrams(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], from) //nolint:errcheck
+		}
+	}()
+	guest, err := net.Dial("udp", echo.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	fd, err := bridgeToFD(context.Background(), guest, syscall.SOCK_DGRAM, "test", nil)
+	if err != nil {
+		t.Fatalf("bridgeToFD: %v", err)
+	}
+	conn := fdConn(t, fd)
+	defer conn.Close()
+	for _, msg := range []string{"one", "two"} {
+		conn.Write([]byte(msg)) //nolint:errcheck
+		buf := make([]byte, 16)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != msg {
+			t.Fatalf("expected datagram %q back, got %q (%v)", msg, buf[:n], err)
+		}
+	}
+}
+
+func TestDialGuest_Errors(t *testing.T) {
+	if _, err := dialGuest(context.Background(), nil, "192.168.127.2", "tcp", 22, nil); err == nil {
+		t.Fatal("expected an error before the network is ready")
+	}
+	vn, err := virtualnetwork.New(buildTapConfig(testGvproxyConfig(), types.QemuProtocol))
+	if err != nil {
+		t.Fatalf("virtualnetwork.New() failed: %v", err)
+	}
+	if _, err := dialGuest(context.Background(), vn, "192.168.127.2", "sctp", 22, nil); err == nil {
+		t.Fatal("expected an error for an unknown protocol")
+	}
+}
+
+func TestGvproxyDialGuest_UnknownInstance(t *testing.T) {
+	if fd := gvproxy_dial_guest(987654, nil, 80); fd != -1 {
+		t.Fatalf("expected -1 for unknown instance, got %d", fd)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+// dns_encrypted.go — DNS-over-TLS and DNS-over-HTTPS upstreams.
+//
+// Plain DNS to a dns_upstream_servers entry crosses the host network in the
+// clear, where an untrusted network can read or rewrite it. A tls:// entry
+// sends the guest's forwarded questions over DNS-over-TLS (RFC 7858, port
+// 853 by default) and an https:// entry over DNS-over-HTTPS (RFC 8484,
+// POST of application/dns-message). Certificates are verified against the
+// host's roots for the name in the URL; a name there is itself resolved by
+// the host resolver, so use an IP (with a matching certificate) to avoid
+// that bootstrap lookup.
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// dohMaxReply bounds a DoH reply body; a DNS message is at most 64 KiB.
+const dohMaxReply = 65535
+
+// parseEncryptedURL parses a tls:// or https:// entry into its URL and
+// host:port, defaulting the port to defaultPort.
+func parseEncryptedURL(entry, defaultPort string) (*url.URL, string, error) {
+	u, err := url.Parse(entry)
+	if err != nil || u.Hostname() == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, "", fmt.Errorf("%q is not a valid DNS server URL", entry)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	} else if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return nil, "", fmt.Errorf("%q has an invalid port", entry)
+	}
+	return u, net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// parseDoTServer parses "tls://host[:port]".
+func parseDoTServer(entry string) (*dnsServer, error) {
+	u, address, err := parseEncryptedURL(entry, "853")
+	if err != nil {
+		return nil, err
+	}
+	if u.Path != "" {
+		return nil, fmt.Errorf("%q: tls:// takes no path", entry)
+	}
+	return &dnsServer{
+		name:    entry,
+		address: address,
+		tls:     &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12},
+	}, nil
+}
+
+// parseDoHServer parses "https://host[:port][/path]"; the path defaults to
+// /dns-query.
+func parseDoHServer(entry string) (*dnsServer, error) {
+	u, address, err := parseEncryptedURL(entry, "443")
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		u.Path = "/dns-query"
+	}
+	return &dnsServer{
+		name:    entry,
+		address: address,
+		url:     u.String(),
+		http: &http.Client{Transport: &http.Transport{
+			// Never through a proxy from the environment: the query must
+			// reach the configured server only.
+			Proxy:             nil,
+			TLSClientConfig:   &tls.Config{MinVersion: tls.VersionTLS12},
+			ForceAttemptHTTP2: true,
+		}},
+	}, nil
+}
+
+// exchangeTLS sends query over DNS-over-TLS.
+func (s *dnsServer) exchangeTLS(ctx context.Context, query *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{Net: "tcp-tls", Timeout: dnsServerTimeout, TLSConfig: s.tls}
+	reply, _, err := client.ExchangeContext(ctx, query, s.address)
+	return reply, err
+}
+
+// exchangeHTTPS sends query over DNS-over-HTTPS.
+func (s *dnsServer) exchangeHTTPS(ctx context.Context, query *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsServerTimeout)
+	defer cancel()
+
+	// RFC 8484 4.1: a zero ID keeps the request cacheable.
+	query = query.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %s", s.name, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxReply))
+	if err != nil {
+		return nil, err
+	}
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.name, err)
+	}
+	return reply, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// answerCorp answers corp.example. with 10.1.2.3 and anything else with
+// NXDOMAIN.
+func answerCorp(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	if r.Question[0].Name != "corp.example." {
+		return m.SetRcode(r, dns.RcodeNameError)
+	}
+	m.SetReply(r)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+		A:   net.IPv4(10, 1, 2, 3),
+	})
+	return m
+}
+
+// testTLSCertificate returns httptest's certificate, valid for 127.0.0.1,
+// and a pool trusting it.
+func testTLSCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	return srv.TLS.Certificates[0], srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+}
+
+func TestParseDNSServers_Encrypted(t *testing.T) {
+	got, err := parseDNSServers([]string{"tls://dns.corp", "tls://10.0.0.53:8853", "https://dns.corp", "https://10.0.0.53:8443/resolve"})
+	if err != nil {
+		t.Fatalf("parseDNSServers: %v", err)
+	}
+	if got[0].address != "dns.corp:853" || got[0].tls.ServerName != "dns.corp" {
+		t.Errorf("DoT server = %+v, want dns.corp:853 verified as dns.corp", got[0])
+	}
+	if got[1].address != "10.0.0.53:8853" || got[1].tls.ServerName != "10.0.0.53" {
+		t.Errorf("DoT server = %+v, want 10.0.0.53:8853", got[1])
+	}
+	if got[2].url != "https://dns.corp/dns-query" || got[2].http == nil {
+		t.Errorf("DoH server = %+v, want the default /dns-query path", got[2])
+	}
+	if got[3].url != "https://10.0.0.53:8443/resolve" || got[3].name != "https://10.0.0.53:8443/resolve" {
+		t.Errorf("DoH server = %+v", got[3])
+	}
+	for _, bad := range []string{"tls://", "tls://dns.corp/path", "tls://dns.corp:0", "https://user@dns.corp", "https://dns.corp/?q=1", "https://dns.corp:x"} {
+		if _, err := parseDNSServers([]string{bad}); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestGatewayDNS_ForwardsOverTLS(t *testing.T) {
+	cert, roots := testTLSCertificate(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{Listener: ln, Net: "tcp-tls", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		w.WriteMsg(answerCorp(r)) //nolint:errcheck
+	})}
+	go srv.ActivateAndServe()            //nolint:errcheck
+	t.Cleanup(func() { srv.Shutdown() }) //nolint:errcheck
+
+	config := testGvproxyConfig()
+	config.DNSUpstreamServers = []string{"tls://" + ln.Addr().String()}
+	d, err := newGatewayDNS(config)
+	if err != nil {
+		t.Fatalf("newGatewayDNS: %v", err)
+	}
+
+	// Untrusted certificate: the lookup fails rather than falling back.
+	if m := query(d, "corp.example.", dns.TypeA); len(m.Answer) != 0 {
+		t.Fatalf("expected an unverified server to be refused, got %v", m.Answer)
+	}
+
+	d.servers[0].tls.RootCAs = roots
+	m := query(d, "corp.example.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.1.2.3" {
+		t.Fatalf("expected the DoT server's answer, got rcode=%d answers=%v", m.Rcode, m.Answer)
+	}
+	if m := query(d, "missing.example.", dns.TypeA); m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got rcode=%d", m.Rcode)
+	}
+}
+
+func TestGatewayDNS_ForwardsOverHTTPS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/dns-query" || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		q := new(dns.Msg)
+		if err := q.Unpack(body); err != nil || q.Id != 0 {
+			http.Error(w, "bad message", http.StatusBadRequest)
+			return
+		}
+		packed, _ := answerCorp(q).Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	config := testGvproxyConfig()
+	config.DNSUpstreamServers = []string{srv.URL, srv.URL + "/missing"}
+	d, err := newGatewayDNS(config)
+	if err != nil {
+		t.Fatalf("newGatewayDNS: %v", err)
+	}
+	d.servers[0].http = srv.Client()
+	d.servers[1].http = srv.Client()
+
+	m := query(d, "corp.example.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.1.2.3" {
+		t.Fatalf("expected the DoH server's answer, got rcode=%d answers=%v", m.Rcode, m.Answer)
+	}
+	if m := query(d, "missing.example.", dns.TypeA); m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got rcode=%d", m.Rcode)
+	}
+
+	// An HTTP error moves on to the next server, like SERVFAIL.
+	d.servers[0], d.servers[1] = d.servers[1], d.servers[0]
+	if m := query(d, "corp.example.", dns.TypeA); len(m.Answer) != 1 {
+		t.Fatalf("expected the second server's answer, got rcode=%d", m.Rcode)
+	}
+	stats := map[string]DNSUpstreamStats{}
+	for _, s := range d.upstreams.Stats() {
+		stats[s.Upstream] = s
+	}
+	if s := stats[srv.URL+"/missing"]; s.FailedAttempts != 1 {
+		t.Errorf("failing DoH server stats = %+v, want 1 failed attempt", s)
+	}
+}
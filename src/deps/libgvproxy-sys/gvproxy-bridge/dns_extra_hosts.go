@@ -0,0 +1,71 @@
+package main
+
+// dns_extra_hosts.go — Static host entries, like /etc/hosts or Docker's
+// --add-host.
+//
+// A zone needs a zone name and a record per host; pinning a few names is
+// simpler as extra_hosts: {"db.internal": "10.0.0.5", "api.example.com":
+// "gateway"}. The gateway DNS server (forked_dns.go) answers these names
+// before any zone or CNAME, with hosts-file semantics: an A or AAAA query
+// gets the entry's address if it has that family, and any other query for
+// the name gets an empty NOERROR answer instead of being forwarded. The
+// value is an IPv4 or IPv6 address, or "gateway" or "guest" for the
+// instance's gateway_ip or guest_ip.
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Symbolic extra_hosts values.
+const (
+	extraHostGateway = "gateway"
+	extraHostGuest   = "guest"
+)
+
+// buildExtraHosts validates extra_hosts and returns its addresses by
+// lower-case FQDN.
+func buildExtraHosts(config GvproxyConfig) (map[string]net.IP, error) {
+	hosts := make(map[string]net.IP, len(config.ExtraHosts))
+	for name, value := range config.ExtraHosts {
+		if _, ok := dns.IsDomainName(name); !ok || strings.Trim(name, ".") == "" {
+			return nil, fmt.Errorf("extra_hosts: %q is not a host name", name)
+		}
+		switch value {
+		case extraHostGateway:
+			value = config.GatewayIP
+		case extraHostGuest:
+			value = config.GuestIP
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("extra_hosts: %q maps to %q, not an IP, gateway or guest", name, config.ExtraHosts[name])
+		}
+		key := strings.ToLower(dns.Fqdn(name))
+		if _, dup := hosts[key]; dup {
+			return nil, fmt.Errorf("extra_hosts: %q is listed twice", name)
+		}
+		hosts[key] = ip
+	}
+	return hosts, nil
+}
+
+// addExtraHostAnswer answers q from extra_hosts and reports whether q's name
+// is one of them. Callers hold d.mu.
+func (d *gatewayDNS) addExtraHostAnswer(m *dns.Msg, q dns.Question) bool {
+	ip, ok := d.hosts[strings.ToLower(q.Name)]
+	if !ok {
+		return false
+	}
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 0}
+	switch ip4 := ip.To4(); {
+	case q.Qtype == dns.TypeA && ip4 != nil:
+		m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: ip4})
+	case q.Qtype == dns.TypeAAAA && ip4 == nil:
+		m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestGatewayDNS_ExtraHosts(t *testing.T) {
+	config := testGvproxyConfig()
+	config.ExtraHosts = map[string]string{
+		"db.internal":           "10.0.0.5",
+		"v6.internal.":          "fd00::5",
+		"Gateway.Test":          "gateway",
+		"guest.test":            "guest",
+		"host.boxlite.internal": "10.9.9.9", // shadows the zone record
+	}
+	d, err := newGatewayDNS(config)
+	if err != nil {
+		t.Fatalf("newGatewayDNS: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"db.internal.":           "10.0.0.5",
+		"gateway.test.":          config.GatewayIP,
+		"GUEST.test.":            config.GuestIP,
+		"host.boxlite.internal.": "10.9.9.9",
+	} {
+		m := query(d, name, dns.TypeA)
+		if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != want {
+			t.Errorf("%s A = %v, want %s", name, m.Answer, want)
+		}
+	}
+	if m := query(d, "v6.internal.", dns.TypeAAAA); len(m.Answer) != 1 || m.Answer[0].(*dns.AAAA).AAAA.String() != "fd00::5" {
+		t.Errorf("v6.internal. AAAA = %v", m.Answer)
+	}
+	// The other family, or another type, is an empty answer, not forwarded.
+	for _, q := range []struct {
+		name  string
+		qtype uint16
+	}{{"v6.internal.", dns.TypeA}, {"db.internal.", dns.TypeAAAA}, {"db.internal.", dns.TypeMX}} {
+		if m := query(d, q.name, q.qtype); m.Rcode != dns.RcodeSuccess || len(m.Answer) != 0 {
+			t.Errorf("%s %s: rcode=%d answers=%v, want an empty NOERROR", q.name, dns.TypeToString[q.qtype], m.Rcode, m.Answer)
+		}
+	}
+	if s := d.upstreams.Stats(); len(s) != 0 {
+		t.Errorf("extra_hosts names were forwarded: %+v", s)
+	}
+}
+
+func TestBuildExtraHosts_Rejects(t *testing.T) {
+	for want, hosts := range map[string]map[string]string{
+		"not an IP":    {"db.internal": "db"},
+		"host name":    {"bad..name": "10.0.0.5"},
+		"listed twice": {"db.internal": "10.0.0.5", "DB.internal.": "10.0.0.6"},
+	} {
+		config := testGvproxyConfig()
+		config.ExtraHosts = hosts
+		if _, err := buildExtraHosts(config); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%v: err = %v, want %q", hosts, err, want)
+		}
+	}
+}
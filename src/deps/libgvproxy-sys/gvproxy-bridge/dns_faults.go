@@ -0,0 +1,167 @@
+package main
+
+// dns_faults.go — Deterministic DNS misbehaviour, for testing guests.
+//
+// Applications are supposed to survive slow, failing or silent resolvers,
+// but that is hard to check against a real one. A DNSFault makes the
+// gateway's DNS server misbehave for one name and the names under it: delay
+// the answer, replace it with an error rcode, or never answer. Faults are a
+// testing knob: they come from dns_faults in the config and can be replaced
+// at run time with POST /dns/faults on the control socket (GET lists them).
+// When several faults match a query, the one with the longest name wins.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// DNSFault injects a fault into the gateway DNS server's answers for Name
+// and the names under it.
+type DNSFault struct {
+	Name    string `json:"name"`               // e.g. "db.example.com" or "example.com."
+	DelayMs int    `json:"delay_ms,omitempty"` // answer this much later
+	Rcode   string `json:"rcode,omitempty"`    // "SERVFAIL", "NXDOMAIN" or "REFUSED" instead of the answer
+	Drop    bool   `json:"drop,omitempty"`     // never answer, so the guest times out
+}
+
+// dnsFaultRcodes are the rcodes a DNSFault may return.
+var dnsFaultRcodes = map[string]int{
+	"SERVFAIL": dns.RcodeServerFailure,
+	"NXDOMAIN": dns.RcodeNameError,
+	"REFUSED":  dns.RcodeRefused,
+}
+
+// dnsFault is a validated DNSFault.
+type dnsFault struct {
+	name  string // lower-case FQDN
+	delay time.Duration
+	rcode int // dns.RcodeSuccess => answer normally
+	drop  bool
+	spec  DNSFault
+}
+
+func compileDNSFaults(faults []DNSFault) ([]dnsFault, error) {
+	out := make([]dnsFault, 0, len(faults))
+	for _, f := range faults {
+		if f.Name == "" {
+			return nil, fmt.Errorf("dns fault: name is required")
+		}
+		if f.DelayMs < 0 {
+			return nil, fmt.Errorf("dns fault %q: negative delay_ms", f.Name)
+		}
+		rcode := dns.RcodeSuccess
+		if f.Rcode != "" {
+			var ok bool
+			if rcode, ok = dnsFaultRcodes[strings.ToUpper(f.Rcode)]; !ok {
+				return nil, fmt.Errorf("dns fault %q: unsupported rcode %q", f.Name, f.Rcode)
+			}
+		}
+		out = append(out, dnsFault{
+			name:  strings.ToLower(dns.Fqdn(f.Name)),
+			delay: time.Duration(f.DelayMs) * time.Millisecond,
+			rcode: rcode,
+			drop:  f.Drop,
+			spec:  f,
+		})
+	}
+	return out, nil
+}
+
+// dnsFaultSet is the gateway DNS server's current faults.
+type dnsFaultSet struct {
+	faults   atomic.Pointer[[]dnsFault]
+	injected atomic.Uint64
+}
+
+func (s *dnsFaultSet) set(faults []dnsFault) {
+	s.faults.Store(&faults)
+}
+
+// match returns the fault for the query name, if any.
+func (s *dnsFaultSet) match(name string) (dnsFault, bool) {
+	faults := s.faults.Load()
+	if faults == nil {
+		return dnsFault{}, false
+	}
+	name = strings.ToLower(name)
+	var best dnsFault
+	found := false
+	for _, f := range *faults {
+		if (name == f.name || strings.HasSuffix(name, "."+f.name)) && (!found || len(f.name) > len(best.name)) {
+			best, found = f, true
+		}
+	}
+	return best, found
+}
+
+func (s *dnsFaultSet) specs() []DNSFault {
+	faults := s.faults.Load()
+	if faults == nil {
+		return []DNSFault{}
+	}
+	out := make([]DNSFault, len(*faults))
+	for i, f := range *faults {
+		out[i] = f.spec
+	}
+	return out
+}
+
+// inject applies the fault matching r's question, if any. It returns true
+// when the fault has handled the query (answered it with an error or
+// dropped it).
+func (s *dnsFaultSet) inject(w dns.ResponseWriter, r *dns.Msg) bool {
+	if len(r.Question) == 0 {
+		return false
+	}
+	f, ok := s.match(r.Question[0].Name)
+	if !ok {
+		return false
+	}
+	s.injected.Add(1)
+	logrus.WithFields(logrus.Fields{"name": r.Question[0].Name, "fault": f.name}).Debug("Gateway DNS: injecting fault")
+	time.Sleep(f.delay)
+	switch {
+	case f.drop:
+		return true
+	case f.rcode != dns.RcodeSuccess:
+		m := new(dns.Msg)
+		m.SetRcode(r, f.rcode)
+		m.RecursionAvailable = true
+		if err := w.WriteMsg(m); err != nil {
+			logrus.Error(err)
+		}
+		return true
+	}
+	return false
+}
+
+// serveFaults serves GET and POST /faults.
+func (s *dnsFaultSet) serveFaults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(s.specs())
+	case http.MethodPost:
+		var req []DNSFault
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		faults, err := compileDNSFaults(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.set(faults)
+		logrus.WithField("faults", len(faults)).Info("Gateway DNS: faults replaced")
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "get or post only", http.StatusBadRequest)
+	}
+}
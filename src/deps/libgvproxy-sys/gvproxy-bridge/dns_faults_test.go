@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// recordingWriter is a dns.ResponseWriter keeping the messages written.
+type recordingWriter struct {
+	dns.ResponseWriter
+	written []*dns.Msg
+}
+
+func (w *recordingWriter) WriteMsg(m *dns.Msg) error {
+	w.written = append(w.written, m)
+	return nil
+}
+
+func (w *recordingWriter) RemoteAddr() net.Addr { return &net.UDPAddr{} }
+
+func serveQuery(d *gatewayDNS, name string) *recordingWriter {
+	r := new(dns.Msg)
+	r.SetQuestion(name, dns.TypeA)
+	w := &recordingWriter{}
+	d.handleUDP(w, r)
+	return w
+}
+
+func TestGatewayDNS_FaultsRcodeDropAndDelay(t *testing.T) {
+	d, err := newGatewayDNS(GvproxyConfig{
+		DNSZones: []DNSZone{{Name: "svc.local.", Records: []DNSRecord{{Name: "db", IP: "10.0.0.5"}, {Name: "web", IP: "10.0.0.6"}, {Name: "cache", IP: "10.0.0.7"}}}},
+		DNSFaults: []DNSFault{
+			{Name: "svc.local", Rcode: "servfail"},
+			{Name: "web.svc.local.", Drop: true},
+			{Name: "cache.svc.local", DelayMs: 50},
+		},
+	})
+	if err != nil {
+		t.Fatalf("newGatewayDNS: %v", err)
+	}
+
+	if w := serveQuery(d, "db.svc.local."); len(w.written) != 1 || w.written[0].Rcode != dns.RcodeServerFailure {
+		t.Fatalf("db: got %v, want SERVFAIL from the zone-wide fault", w.written)
+	}
+	if w := serveQuery(d, "web.svc.local."); len(w.written) != 0 {
+		t.Fatalf("web: got %v, want the query dropped", w.written)
+	}
+	start := time.Now()
+	w := serveQuery(d, "cache.svc.local.")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("cache: answered after %s, want at least 50ms", elapsed)
+	}
+	if len(w.written) != 1 || w.written[0].Rcode != dns.RcodeSuccess || len(w.written[0].Answer) != 1 {
+		t.Fatalf("cache: got %v, want the normal answer", w.written)
+	}
+	if got := d.faults.injected.Load(); got != 3 {
+		t.Fatalf("injected = %d, want 3", got)
+	}
+}
+
+func TestNewGatewayDNS_RejectsInvalidFaults(t *testing.T) {
+	for _, fault := range []DNSFault{
+		{Name: ""},
+		{Name: "a.local", DelayMs: -1},
+		{Name: "a.local", Rcode: "NOTAUTH"},
+	} {
+		if _, err := newGatewayDNS(GvproxyConfig{DNSFaults: []DNSFault{fault}}); err == nil {
+			t.Errorf("%+v: expected error", fault)
+		}
+	}
+}
+
+func TestGatewayDNS_MuxReplacesFaults(t *testing.T) {
+	d := testGatewayDNS(t)
+	srv := httptest.NewServer(d.Mux())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/faults", "application/json",
+		strings.NewReader(`[{"name":"db.svc.local","rcode":"NXDOMAIN"}]`))
+	if err != nil {
+		t.Fatalf("POST /faults: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /faults: status %d", resp.StatusCode)
+	}
+	if w := serveQuery(d, "db.svc.local."); len(w.written) != 1 || w.written[0].Rcode != dns.RcodeNameError {
+		t.Fatalf("got %v, want NXDOMAIN", w.written)
+	}
+
+	resp, err = http.Post(srv.URL+"/faults", "application/json", strings.NewReader(`[]`))
+	if err != nil {
+		t.Fatalf("POST /faults: %v", err)
+	}
+	resp.Body.Close()
+	if w := serveQuery(d, "db.svc.local."); len(w.written) != 1 || w.written[0].Rcode != dns.RcodeSuccess {
+		t.Fatalf("got %v, want the fault cleared", w.written)
+	}
+}
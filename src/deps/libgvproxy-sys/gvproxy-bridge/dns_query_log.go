@@ -0,0 +1,71 @@
+package main
+
+// dns_query_log.go — Report each guest DNS query as an event.
+//
+// Which hostnames a workload resolves says a lot about what it does, and
+// the gateway answers every one of its queries. With dns_query_log set, the
+// gateway's DNS server (forked_dns.go) reports each query it handles as a
+// dns_query event: the name and type asked, the rcode and answers returned,
+// and how long answering took, including any forwarding. Queries a test
+// fault (dns_faults.go) intercepted are reported with "fault": true and no
+// answer. Nothing is reported with disable_gateway_dns, since no query
+// reaches the gateway.
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// EventDNSQuery fires for each DNS query the gateway handles when
+// dns_query_log is set. Fields: client, protocol ("udp" or "tcp"), name,
+// type, rcode, answers (e.g. "A 10.1.2.3"), latency_ms; fault instead of
+// rcode and answers for a query a dns_faults entry intercepted.
+const EventDNSQuery = "dns_query"
+
+// dnsQueryLog reports the queries of one instance. A nil log reports
+// nothing.
+type dnsQueryLog struct {
+	instanceID int64
+}
+
+func newDNSQueryLog(instanceID int64, enabled bool) *dnsQueryLog {
+	if !enabled {
+		return nil
+	}
+	return &dnsQueryLog{instanceID: instanceID}
+}
+
+// report emits the event for query r from w's client, started at start and
+// answered with m (nil when a fault intercepted it).
+func (l *dnsQueryLog) report(w dns.ResponseWriter, r, m *dns.Msg, start time.Time) {
+	if l == nil || len(r.Question) == 0 {
+		return
+	}
+	q := r.Question[0]
+	fields := map[string]any{
+		"client":     w.RemoteAddr().String(),
+		"protocol":   w.RemoteAddr().Network(),
+		"name":       q.Name,
+		"type":       dns.TypeToString[q.Qtype],
+		"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if m == nil {
+		fields["fault"] = true
+	} else {
+		fields["rcode"] = dns.RcodeToString[m.Rcode]
+		fields["answers"] = dnsAnswerStrings(m.Answer)
+	}
+	emitEvent(l.instanceID, EventDNSQuery, fields)
+}
+
+// dnsAnswerStrings renders answers as "<type> <data>", e.g. "A 10.1.2.3".
+func dnsAnswerStrings(answers []dns.RR) []string {
+	out := make([]string, 0, len(answers))
+	for _, rr := range answers {
+		data := strings.TrimPrefix(rr.String(), rr.Header().String())
+		out = append(out, dns.TypeToString[rr.Header().Rrtype]+" "+data)
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestGatewayDNS_ReportsQueries(t *testing.T) {
+	const id = 5937
+	d := testGatewayDNS(t)
+	d.queryLog = newDNSQueryLog(id, true)
+	d.faults.set([]dnsFault{{name: "flaky.svc.local.", rcode: dns.RcodeServerFailure}})
+	server := testNameserver(t, d.handleUDP)
+
+	client := &dns.Client{}
+	for _, name := range []string{"db.svc.local.", "flaky.svc.local."} {
+		r := new(dns.Msg)
+		r.SetQuestion(name, dns.TypeA)
+		if _, _, err := client.Exchange(r, server); err != nil {
+			t.Fatalf("query %s: %v", name, err)
+		}
+	}
+
+	var answered, faulted *Event
+	for _, e := range recentEventsFor(id) {
+		if e.Type != EventDNSQuery {
+			continue
+		}
+		switch e.Fields["name"] {
+		case "db.svc.local.":
+			answered = &e
+		case "flaky.svc.local.":
+			faulted = &e
+		}
+	}
+	if answered == nil || faulted == nil {
+		t.Fatalf("expected a dns_query event per query, got %+v and %+v", answered, faulted)
+	}
+	f := answered.Fields
+	if f["type"] != "A" || f["rcode"] != "NOERROR" || f["protocol"] != "udp" || f["client"] == "" {
+		t.Errorf("answered query fields = %v", f)
+	}
+	if answers, _ := f["answers"].([]string); len(answers) != 1 || answers[0] != "A 10.0.0.5" {
+		t.Errorf("answers = %v, want [A 10.0.0.5]", f["answers"])
+	}
+	if _, ok := f["latency_ms"].(float64); !ok {
+		t.Errorf("latency_ms = %v", f["latency_ms"])
+	}
+	if faulted.Fields["fault"] != true || faulted.Fields["rcode"] != nil {
+		t.Errorf("faulted query fields = %v", faulted.Fields)
+	}
+}
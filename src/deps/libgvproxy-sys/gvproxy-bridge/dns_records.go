@@ -0,0 +1,250 @@
+package main
+
+// dns_records.go — Add and remove DNS records on a running instance.
+//
+// dns_zones is fixed at create time, so a container started later had no
+// name the guest could resolve until the instance was re-created.
+// gvproxy_dns_add_record adds a record as in dns_zones[].records to a zone,
+// creating the zone if needed, and gvproxy_dns_remove_record removes a
+// name's records again; both take effect for the next query. An A record
+// replaces the name's A record, other types are added next to the name's
+// records of their type; the CNAME rules of create apply. Each change bumps
+// the config generation (config_generation.go), and the records are carried
+// over by a hot upgrade.
+
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/miekg/dns"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// dnsRecordRequest is the gvproxy_dns_add_record and
+// gvproxy_dns_remove_record argument: a record of zone.
+type dnsRecordRequest struct {
+	Zone string `json:"zone"`
+	DNSRecord
+}
+
+// aNames returns the lower-case FQDNs of the zones' A records. Callers hold
+// d.mu.
+func (d *gatewayDNS) aNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, zone := range d.zones {
+		for _, record := range zone.Records {
+			if record.Name != "" {
+				names[strings.ToLower(record.Name+"."+zone.Name)] = true
+			}
+		}
+	}
+	return names
+}
+
+// addRecord adds record to zoneName, creating the zone if needed.
+func (d *gatewayDNS) addRecord(zoneName string, record DNSRecord) error {
+	if zoneName == "" || record.Name == "" {
+		return errors.New("zone and name are required")
+	}
+	rr, err := extraDNSRecord(zoneName, record)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(record.IP).To4()
+	if rr == nil && record.Regexp != "" {
+		return errors.New("regexp records are only supported in dns_zones")
+	}
+	if rr == nil && ip == nil {
+		return fmt.Errorf("A record needs an IPv4 ip, not %q", record.IP)
+	}
+	fqdn := strings.ToLower(record.Name + "." + zoneName)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if rr == nil {
+		if cnameOf(d.extra[fqdn]) != nil {
+			return fmt.Errorf("dns name %q: a CNAME record cannot have other records", fqdn)
+		}
+		d.setARecord(zoneName, types.Record{Name: record.Name, IP: ip})
+	} else {
+		_, isCNAME := rr.(*dns.CNAME)
+		if isCNAME && (d.aNames()[fqdn] || (len(d.extra[fqdn]) > 0 && cnameOf(d.extra[fqdn]) == nil)) ||
+			!isCNAME && cnameOf(d.extra[fqdn]) != nil {
+			return fmt.Errorf("dns name %q: a CNAME record cannot have other records", fqdn)
+		}
+		prev := d.extra[fqdn]
+		if isCNAME {
+			d.extra[fqdn] = []dns.RR{rr}
+			if _, _, err := d.chaseCNAMEs(nil, fqdn); err != nil {
+				d.restoreExtra(fqdn, prev)
+				return err
+			}
+		} else if !slices.ContainsFunc(prev, func(have dns.RR) bool { return dns.IsDuplicate(have, rr) }) {
+			d.extra[fqdn] = append(slices.Clip(prev), rr)
+		}
+	}
+	d.configRecord(zoneName, record)
+	return nil
+}
+
+// setARecord sets name's A record in zoneName, adding the zone in front of
+// the others (allow_net sinkhole zones must not shadow it). Callers hold
+// d.mu.
+func (d *gatewayDNS) setARecord(zoneName string, record types.Record) {
+	for i := range d.zones {
+		zone := &d.zones[i]
+		if zone.Name != zoneName {
+			continue
+		}
+		zone.Records = slices.DeleteFunc(slices.Clone(zone.Records), func(r types.Record) bool { return r.Name == record.Name })
+		zone.Records = append(zone.Records, record)
+		return
+	}
+	d.zones = append([]types.Zone{{Name: zoneName, Records: []types.Record{record}}}, d.zones...)
+}
+
+func (d *gatewayDNS) restoreExtra(fqdn string, rrs []dns.RR) {
+	if len(rrs) == 0 {
+		delete(d.extra, fqdn)
+		return
+	}
+	d.extra[fqdn] = rrs
+}
+
+// configRecord mirrors an added record into the zones a hot upgrade hands
+// over. Callers hold d.mu.
+func (d *gatewayDNS) configRecord(zoneName string, record DNSRecord) {
+	i := slices.IndexFunc(d.config, func(z DNSZone) bool { return z.Name == zoneName })
+	if i < 0 {
+		d.config = append(d.config, DNSZone{Name: zoneName})
+		i = len(d.config) - 1
+	}
+	records := slices.Clone(d.config[i].Records)
+	if isARecord(record) {
+		records = slices.DeleteFunc(records, func(r DNSRecord) bool { return r.Name == record.Name && isARecord(r) })
+	} else if strings.EqualFold(record.Type, DNSRecordCNAME) {
+		records = slices.DeleteFunc(records, func(r DNSRecord) bool { return r.Name == record.Name && strings.EqualFold(r.Type, DNSRecordCNAME) })
+	}
+	d.config[i].Records = append(records, record)
+}
+
+// removeRecord removes name's records from zoneName: all of them, or with
+// recordType only those of that type.
+func (d *gatewayDNS) removeRecord(zoneName, name, recordType string) error {
+	if zoneName == "" || name == "" {
+		return errors.New("zone and name are required")
+	}
+	matchesType := func(t string) bool { return recordType == "" || strings.EqualFold(t, recordType) }
+	fqdn := strings.ToLower(name + "." + zoneName)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	removed := false
+	if matchesType(DNSRecordA) {
+		for i := range d.zones {
+			if d.zones[i].Name != zoneName {
+				continue
+			}
+			kept := slices.DeleteFunc(slices.Clone(d.zones[i].Records), func(r types.Record) bool { return r.Name == name })
+			removed = removed || len(kept) != len(d.zones[i].Records)
+			d.zones[i].Records = kept
+		}
+	}
+	kept := slices.DeleteFunc(slices.Clone(d.extra[fqdn]), func(rr dns.RR) bool {
+		return matchesType(dns.TypeToString[rr.Header().Rrtype])
+	})
+	removed = removed || len(kept) != len(d.extra[fqdn])
+	d.restoreExtra(fqdn, kept)
+	if !removed {
+		return fmt.Errorf("no matching record for %q in zone %q", name, zoneName)
+	}
+
+	for i := range d.config {
+		if d.config[i].Name == zoneName {
+			d.config[i].Records = slices.DeleteFunc(slices.Clone(d.config[i].Records), func(r DNSRecord) bool {
+				t := r.Type
+				if isARecord(r) {
+					t = DNSRecordA
+				}
+				return r.Name == name && matchesType(t)
+			})
+		}
+	}
+	return nil
+}
+
+// configZones returns dns_zones as changed at run time.
+func (d *gatewayDNS) configZones() []DNSZone {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.Clone(d.config)
+}
+
+// changeDNSRecord applies a record change to instance id and bumps its
+// config generation.
+func changeDNSRecord(id int64, change string, req dnsRecordRequest) error {
+	instancesMu.RLock()
+	instance, ok := instances[id]
+	instancesMu.RUnlock()
+	if !ok || instance.dns == nil {
+		return fmt.Errorf("instance %d not found", id)
+	}
+	if instance.dns.disabled {
+		return errors.New("gateway DNS is disabled (disable_gateway_dns)")
+	}
+	var err error
+	if change == "dns_add_record" {
+		err = instance.dns.addRecord(req.Zone, req.DNSRecord)
+	} else {
+		err = instance.dns.removeRecord(req.Zone, req.Name, req.Type)
+	}
+	if err != nil {
+		return err
+	}
+	instance.generation.bump(id, change, map[string]any{"zone": req.Zone, "name": req.Name})
+	return nil
+}
+
+// gvproxy_dns_add_record adds a DNS record to a running instance.
+// recordJSON is a record as in dns_zones[].records plus its zone:
+// {"zone": "myapp.local.", "name": "web", "ip": "192.168.127.2"} or
+// {"zone": ..., "name": ..., "type": "SRV", "target": ..., "port": ...}.
+//
+// Returns 0 on success, -1 on failure (logged): unknown instance, invalid
+// JSON or record, or a CNAME conflict or loop.
+//
+//export gvproxy_dns_add_record
+func gvproxy_dns_add_record(id C.longlong, recordJSON *C.char) C.int {
+	return dnsRecordCall(int64(id), "dns_add_record", recordJSON)
+}
+
+// gvproxy_dns_remove_record removes DNS records from a running instance.
+// recordJSON names them: {"zone": "myapp.local.", "name": "web"} removes
+// every record of the name, adding "type" only those of that type.
+//
+// Returns 0 on success, -1 on failure (logged): unknown instance, invalid
+// JSON, or no matching record.
+//
+//export gvproxy_dns_remove_record
+func gvproxy_dns_remove_record(id C.longlong, recordJSON *C.char) C.int {
+	return dnsRecordCall(int64(id), "dns_remove_record", recordJSON)
+}
+
+func dnsRecordCall(id int64, change string, recordJSON *C.char) C.int {
+	var req dnsRecordRequest
+	if err := json.Unmarshal([]byte(C.GoString(recordJSON)), &req); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid DNS record JSON")
+		return -1
+	}
+	if err := changeDNSRecord(id, change, req); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id, "zone": req.Zone, "name": req.Name}).Error("Failed to change DNS record")
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestGatewayDNS_AddAndRemoveRecords(t *testing.T) {
+	d := testGatewayDNS(t)
+
+	if err := d.addRecord("apps.local.", DNSRecord{Name: "web", IP: "192.168.127.2"}); err != nil {
+		t.Fatalf("add A in a new zone: %v", err)
+	}
+	if m := query(d, "web.apps.local.", dns.TypeA); len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "192.168.127.2" {
+		t.Fatalf("A answer = %v", m.Answer)
+	}
+	// An A record replaces the name's A record.
+	if err := d.addRecord("apps.local.", DNSRecord{Name: "web", IP: "192.168.127.3"}); err != nil {
+		t.Fatalf("replace A: %v", err)
+	}
+	if m := query(d, "web.apps.local.", dns.TypeA); len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "192.168.127.3" {
+		t.Fatalf("A answer after replace = %v", m.Answer)
+	}
+	if err := d.addRecord("svc.local.", DNSRecord{Name: "_http._tcp", Type: "SRV", Target: "web.apps.local", Port: 80}); err != nil {
+		t.Fatalf("add SRV: %v", err)
+	}
+	if m := query(d, "_http._tcp.svc.local.", dns.TypeSRV); len(m.Answer) != 1 {
+		t.Fatalf("SRV answer = %v", m.Answer)
+	}
+	if err := d.addRecord("svc.local.", DNSRecord{Name: "www", Type: "CNAME", Target: "web.apps.local."}); err != nil {
+		t.Fatalf("add CNAME: %v", err)
+	}
+	if m := query(d, "www.svc.local.", dns.TypeA); len(m.Answer) != 2 {
+		t.Fatalf("CNAME chase = %v", m.Answer)
+	}
+
+	zones := d.configZones()
+	if len(zones) != 2 || zones[1].Name != "apps.local." || len(zones[1].Records) != 1 || zones[1].Records[0].IP != "192.168.127.3" {
+		t.Fatalf("configZones = %+v", zones)
+	}
+
+	if err := d.removeRecord("apps.local.", "web", ""); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if m := query(d, "web.apps.local.", dns.TypeA); m.Rcode != dns.RcodeNameError {
+		t.Fatalf("removed name answered %v (rcode %d)", m.Answer, m.Rcode)
+	}
+	if err := d.removeRecord("svc.local.", "db", "TXT"); err == nil {
+		t.Fatal("removing a type the name has none of succeeded")
+	}
+	if err := d.removeRecord("svc.local.", "_http._tcp", "srv"); err != nil {
+		t.Fatalf("remove SRV: %v", err)
+	}
+	if zones := d.configZones(); len(zones[1].Records) != 0 || len(zones[0].Records) != 5 {
+		t.Fatalf("configZones after remove = %+v", zones)
+	}
+}
+
+func TestGatewayDNS_AddRecordRejects(t *testing.T) {
+	d := testGatewayDNS(t)
+	for _, tc := range []struct {
+		zone   string
+		record DNSRecord
+		want   string
+	}{
+		{"", DNSRecord{Name: "web", IP: "10.0.0.1"}, "required"},
+		{"svc.local.", DNSRecord{Name: "web", IP: "fd00::1"}, "IPv4"},
+		{"svc.local.", DNSRecord{Name: "web", Type: "MX"}, "unsupported"},
+		{"svc.local.", DNSRecord{Name: "web", Type: "AAAA", IP: "10.0.0.1"}, "IPv6"},
+		{"svc.local.", DNSRecord{Name: "web", Regexp: "^web", IP: "10.0.0.1"}, "regexp"},
+		{"svc.local.", DNSRecord{Name: "db", Type: "CNAME", Target: "x.svc.local."}, "CNAME"},
+	} {
+		if err := d.addRecord(tc.zone, tc.record); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: err = %v, want %q", tc.record, err, tc.want)
+		}
+	}
+
+	// A loop is refused and leaves the records as they were.
+	if err := d.addRecord("svc.local.", DNSRecord{Name: "a", Type: "CNAME", Target: "b.svc.local."}); err != nil {
+		t.Fatalf("add CNAME: %v", err)
+	}
+	if err := d.addRecord("svc.local.", DNSRecord{Name: "b", Type: "CNAME", Target: "a.svc.local."}); err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Fatalf("err = %v, want a CNAME loop", err)
+	}
+	if _, ok := d.extra["b.svc.local."]; ok {
+		t.Fatal("refused CNAME was kept")
+	}
+}
+
+func TestChangeDNSRecord_BumpsGeneration(t *testing.T) {
+	addFakeInstance(t, 5933, testGvproxyConfig())
+	instancesMu.Lock()
+	instances[5933].dns = testGatewayDNS(t)
+	instancesMu.Unlock()
+
+	if err := changeDNSRecord(5933, "dns_add_record", dnsRecordRequest{Zone: "svc.local.", DNSRecord: DNSRecord{Name: "web", IP: "10.0.0.6"}}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := changeDNSRecord(5933, "dns_remove_record", dnsRecordRequest{Zone: "svc.local.", DNSRecord: DNSRecord{Name: "web"}}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if got := gvproxy_get_generation(5933); got != 3 {
+		t.Fatalf("generation = %d, want 3", got)
+	}
+	if err := changeDNSRecord(5999, "dns_add_record", dnsRecordRequest{}); err == nil {
+		t.Fatal("expected error for an unknown instance")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+// dns_servers.go — Forward guest DNS queries to configured nameservers.
+//
+// Names outside the local zones go to the host resolver, so a sandbox
+// resolves with whatever the host happens to use. dns_upstream_servers pins
+// an instance to an explicit list instead, e.g. a corporate or test
+// resolver: each forwarded question is sent to the servers in order, over
+// UDP with a TCP retry when the reply is truncated (or encrypted, see
+// dns_encrypted.go), and the first reply that is not SERVFAIL or REFUSED is
+// relayed as is. The per-upstream stats (dns_upstreams.go) name the servers
+// by address.
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsServerTimeout bounds one exchange with a configured nameserver.
+var dnsServerTimeout = 2 * time.Second
+
+// dnsServer is one entry of dns_upstream_servers.
+type dnsServer struct {
+	name    string       // how stats name it: host:port, or the entry's URL
+	address string       // host:port dialed (plain DNS, DoT)
+	tls     *tls.Config  // DoT (dns_encrypted.go); nil otherwise
+	url     string       // DoH endpoint (dns_encrypted.go)
+	http    *http.Client // DoH; nil otherwise
+}
+
+// parseDNSServers parses dns_upstream_servers. An entry is an IP, which
+// uses port 53, an IP and port ("10.0.0.53:5353", "[fd00::53]:53"), or a
+// tls:// or https:// URL (dns_encrypted.go).
+func parseDNSServers(entries []string) ([]*dnsServer, error) {
+	servers := make([]*dnsServer, 0, len(entries))
+	for _, entry := range entries {
+		var server *dnsServer
+		var err error
+		switch {
+		case strings.HasPrefix(entry, "tls://"):
+			server, err = parseDoTServer(entry)
+		case strings.HasPrefix(entry, "https://"):
+			server, err = parseDoHServer(entry)
+		default:
+			server, err = parsePlainDNSServer(entry)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("dns_upstream_servers: %w", err)
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+func parsePlainDNSServer(entry string) (*dnsServer, error) {
+	if net.ParseIP(entry) != nil {
+		address := net.JoinHostPort(entry, "53")
+		return &dnsServer{name: address, address: address}, nil
+	}
+	host, port, err := net.SplitHostPort(entry)
+	if err != nil || net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("%q is not an IP, IP:port or tls:// or https:// URL", entry)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return nil, fmt.Errorf("%q has an invalid port", entry)
+	}
+	return &dnsServer{name: entry, address: entry}, nil
+}
+
+// exchange answers q from the first of servers that replies, recording the
+// servers tried and the one that answered. NXDOMAIN is returned as a
+// not-found *net.DNSError, like the host resolver does.
+func (l *dnsLookup) exchange(ctx context.Context, servers []*dnsServer, m *dns.Msg, q dns.Question) error {
+	query := new(dns.Msg)
+	query.SetQuestion(q.Name, q.Qtype)
+	query.Question[0].Qclass = q.Qclass
+	query.RecursionDesired = true
+
+	err := fmt.Errorf("no dns_upstream_servers")
+	for _, server := range servers {
+		l.mu.Lock()
+		l.tried = append(l.tried, server.name)
+		l.mu.Unlock()
+
+		var reply *dns.Msg
+		reply, err = server.exchange(ctx, query)
+		if err != nil {
+			continue
+		}
+		if reply.Rcode == dns.RcodeServerFailure || reply.Rcode == dns.RcodeRefused {
+			err = fmt.Errorf("%s: %s", server.name, dns.RcodeToString[reply.Rcode])
+			continue
+		}
+		l.mu.Lock()
+		l.answered = server.name
+		l.mu.Unlock()
+		if reply.Rcode == dns.RcodeNameError {
+			return &net.DNSError{Err: "no such host", Name: q.Name, Server: server.name, IsNotFound: true}
+		}
+		if reply.Rcode != dns.RcodeSuccess {
+			return fmt.Errorf("%s: %s", server.name, dns.RcodeToString[reply.Rcode])
+		}
+		m.Answer = append(m.Answer, reply.Answer...)
+		return nil
+	}
+	return err
+}
+
+// exchange sends query to the server. Plain DNS goes over UDP, retrying
+// over TCP when the reply is truncated.
+func (s *dnsServer) exchange(ctx context.Context, query *dns.Msg) (*dns.Msg, error) {
+	switch {
+	case s.http != nil:
+		return s.exchangeHTTPS(ctx, query)
+	case s.tls != nil:
+		return s.exchangeTLS(ctx, query)
+	}
+	client := &dns.Client{Net: "udp", Timeout: dnsServerTimeout}
+	reply, _, err := client.ExchangeContext(ctx, query, s.address)
+	if err == nil && reply.Truncated {
+		client.Net = "tcp"
+		reply, _, err = client.ExchangeContext(ctx, query, s.address)
+	}
+	return reply, err
+}
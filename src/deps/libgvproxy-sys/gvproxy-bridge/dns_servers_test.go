@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testNameserver serves handler on a loopback UDP port and returns its
+// address.
+func testNameserver(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: handler}
+	go srv.ActivateAndServe()            //nolint:errcheck
+	t.Cleanup(func() { srv.Shutdown() }) //nolint:errcheck
+	return pc.LocalAddr().String()
+}
+
+func TestParseDNSServers(t *testing.T) {
+	got, err := parseDNSServers([]string{"10.0.0.53", "10.0.0.54:5353", "fd00::53", "[fd00::54]:53"})
+	if err != nil {
+		t.Fatalf("parseDNSServers: %v", err)
+	}
+	var names []string
+	for _, s := range got {
+		names = append(names, s.name)
+	}
+	want := "10.0.0.53:53,10.0.0.54:5353,[fd00::53]:53,[fd00::54]:53"
+	if strings.Join(names, ",") != want {
+		t.Fatalf("parseDNSServers = %v, want %s", names, want)
+	}
+	for _, bad := range []string{"dns.corp", "dns.corp:53", "10.0.0.53:0", "10.0.0.53:dns"} {
+		if _, err := parseDNSServers([]string{bad}); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestGatewayDNS_ForwardsToConfiguredServers(t *testing.T) {
+	failing := testNameserver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m) //nolint:errcheck
+	})
+	answering := testNameserver(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		if r.Question[0].Name != "corp.example." {
+			m.SetRcode(r, dns.RcodeNameError)
+		} else {
+			m.SetReply(r)
+			m.Answer = append(m.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+				A:   net.IPv4(10, 1, 2, 3),
+			})
+		}
+		w.WriteMsg(m) //nolint:errcheck
+	})
+
+	config := testGvproxyConfig()
+	config.DNSUpstreamServers = []string{failing, answering}
+	d, err := newGatewayDNS(config)
+	if err != nil {
+		t.Fatalf("newGatewayDNS: %v", err)
+	}
+
+	m := query(d, "corp.example.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.1.2.3" {
+		t.Fatalf("expected the configured server's answer, got rcode=%d answers=%v", m.Rcode, m.Answer)
+	}
+	if m := query(d, "missing.example.", dns.TypeA); m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got rcode=%d", m.Rcode)
+	}
+	// Local zones still come first.
+	if m := query(d, "host.boxlite.internal.", dns.TypeA); len(m.Answer) != 1 {
+		t.Fatalf("expected the local record, got %v", m.Answer)
+	}
+
+	stats := map[string]DNSUpstreamStats{}
+	for _, s := range d.upstreams.Stats() {
+		stats[s.Upstream] = s
+	}
+	if s := stats[answering]; s.Queries != 2 || s.Errors != 0 {
+		t.Errorf("answering server stats = %+v, want 2 queries without errors", s)
+	}
+	if s := stats[failing]; s.Queries != 0 || s.FailedAttempts != 2 {
+		t.Errorf("failing server stats = %+v, want 2 failed attempts", s)
+	}
+	if _, ok := stats[dnsUpstreamSystem]; ok {
+		t.Error("the host resolver must not be used")
+	}
+}
+
+func TestGatewayDNS_ConfiguredServersUnreachable(t *testing.T) {
+	defer func(old time.Duration) { dnsServerTimeout = old }(dnsServerTimeout)
+	dnsServerTimeout = 100 * time.Millisecond
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	silent := pc.LocalAddr().String() // reads nothing, answers nothing
+	defer pc.Close()
+
+	config := testGvproxyConfig()
+	config.DNSUpstreamServers = []string{silent}
+	d, err := newGatewayDNS(config)
+	if err != nil {
+		t.Fatalf("newGatewayDNS: %v", err)
+	}
+	if m := query(d, "corp.example.", dns.TypeA); m.Rcode != dns.RcodeNameError || len(m.Answer) != 0 {
+		t.Fatalf("expected a failed lookup, got rcode=%d answers=%v", m.Rcode, m.Answer)
+	}
+	if s := d.upstreams.Stats(); len(s) != 1 || s[0].Upstream != silent || s[0].Errors != 1 {
+		t.Fatalf("stats = %+v, want one error for the silent server", s)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+// dns_upstreams.go — Account forwarded DNS queries by upstream.
+//
+// Names outside the local zones are forwarded to the host resolver. Whether
+// split DNS and nameserver fallback behave as intended depends on which
+// nameserver ends up answering, which the guest cannot see. When the host
+// resolver is Go's own (Linux), it dials each nameserver through the
+// gateway; the dial and reads are observed, and each forwarded query is
+// attributed to the nameserver that replied last (the one whose answer was
+// used). Queries answered without dialing anyone — by the platform resolver
+// library (macOS, or nsswitch on Linux), /etc/hosts or a cache — count
+// under "system". With dns_upstream_servers (dns_servers.go) the bridge
+// sends each query itself, so attribution is exact.
+//
+// Per upstream: queries attributed to it, how many of those failed, attempts
+// made to it that did not produce the answer (the resolver moved on to the
+// next nameserver, or gave up), and a latency histogram of its answered
+// queries. NXDOMAIN is an answer, not a failure.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dnsUpstreamSystem names queries the platform resolver answered without a
+// nameserver dial the bridge could see.
+const dnsUpstreamSystem = "system"
+
+// dnsUpstreams accumulates per-upstream counters for a gateway DNS server.
+type dnsUpstreams struct {
+	mu     sync.Mutex
+	byName map[string]*dnsUpstream
+}
+
+type dnsUpstream struct {
+	queries, errors, failedAttempts uint64
+	latencyMs                       *histogram
+}
+
+func newDNSUpstreams() *dnsUpstreams {
+	return &dnsUpstreams{byName: make(map[string]*dnsUpstream)}
+}
+
+// dnsLookupKey carries the *dnsLookup of a forwarded query in its context.
+type dnsLookupKey struct{}
+
+// dnsLookup records the nameservers a forwarded query reached.
+type dnsLookup struct {
+	u     *dnsUpstreams
+	start time.Time
+
+	mu       sync.Mutex
+	tried    []string // dialed nameservers, in order, without repeats
+	answered string   // the nameserver that replied last
+}
+
+// resolver returns the host resolver, observing its nameserver dials.
+func (u *dnsUpstreams) resolver() *net.Resolver {
+	return &net.Resolver{PreferGo: false, Dial: u.dial}
+}
+
+// begin starts accounting a forwarded query; the lookups must use the
+// returned context.
+func (u *dnsUpstreams) begin(ctx context.Context) (context.Context, *dnsLookup) {
+	l := &dnsLookup{u: u, start: time.Now()}
+	return context.WithValue(ctx, dnsLookupKey{}, l), l
+}
+
+func (u *dnsUpstreams) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	l, _ := ctx.Value(dnsLookupKey{}).(*dnsLookup)
+	if l != nil {
+		l.mu.Lock()
+		if len(l.tried) == 0 || l.tried[len(l.tried)-1] != address {
+			l.tried = append(l.tried, address)
+		}
+		l.mu.Unlock()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil || l == nil {
+		return conn, err
+	}
+	return &upstreamConn{Conn: conn, lookup: l, address: address}, nil
+}
+
+// upstreamConn notes replies from a nameserver.
+type upstreamConn struct {
+	net.Conn
+	lookup  *dnsLookup
+	address string
+}
+
+func (c *upstreamConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.lookup.mu.Lock()
+		c.lookup.answered = c.address
+		c.lookup.mu.Unlock()
+	}
+	return n, err
+}
+
+// end attributes the query to an upstream, given the lookup's result.
+func (l *dnsLookup) end(err error) {
+	l.mu.Lock()
+	upstream := l.answered
+	if upstream == "" && len(l.tried) > 0 {
+		upstream = l.tried[len(l.tried)-1]
+	}
+	if upstream == "" {
+		upstream = dnsUpstreamSystem
+	}
+	var missed []string
+	for _, address := range l.tried {
+		if address != upstream && !slices.Contains(missed, address) {
+			missed = append(missed, address)
+		}
+	}
+	l.mu.Unlock()
+
+	var dnsErr *net.DNSError
+	failed := err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+	elapsed := time.Since(l.start)
+
+	u := l.u
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s := u.get(upstream)
+	s.queries++
+	if failed {
+		s.errors++
+	} else {
+		s.latencyMs.observe(float64(elapsed.Microseconds()) / 1e3)
+	}
+	for _, address := range missed {
+		u.get(address).failedAttempts++
+	}
+}
+
+// get returns the counters of upstream, creating them. Callers hold u.mu.
+func (u *dnsUpstreams) get(upstream string) *dnsUpstream {
+	s := u.byName[upstream]
+	if s == nil {
+		s = &dnsUpstream{latencyMs: newHistogram(defaultLatencyBucketsMs)}
+		u.byName[upstream] = s
+	}
+	return s
+}
+
+// DNSUpstreamStats is one upstream in the "DNS" stats section.
+type DNSUpstreamStats struct {
+	Upstream       string         `json:"Upstream"` // nameserver address, or "system"
+	Queries        uint64         `json:"Queries"`
+	Errors         uint64         `json:"Errors"`
+	FailedAttempts uint64         `json:"FailedAttempts"`
+	LatencyMs      HistogramStats `json:"LatencyMs"`
+}
+
+func (u *dnsUpstreams) Stats() []DNSUpstreamStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make([]DNSUpstreamStats, 0, len(u.byName))
+	for name, s := range u.byName {
+		out = append(out, DNSUpstreamStats{
+			Upstream:       name,
+			Queries:        s.queries,
+			Errors:         s.errors,
+			FailedAttempts: s.failedAttempts,
+			LatencyMs:      s.latencyMs.snapshot(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Upstream < out[j].Upstream })
+	return out
+}
+
+func collectDNSUpstreamsOpenMetrics(emit metricEmitter, upstreams []DNSUpstreamStats) {
+	for _, s := range upstreams {
+		labels := fmt.Sprintf(`upstream="%s"`, escapeLabelValue(s.Upstream))
+		emit(openMetricsPrefix+"dns_upstream_queries", "counter", "Forwarded DNS queries attributed to an upstream.", labels, fmt.Sprint(s.Queries))
+		emit(openMetricsPrefix+"dns_upstream_errors", "counter", "Forwarded DNS queries attributed to an upstream that failed.", labels, fmt.Sprint(s.Errors))
+		emit(openMetricsPrefix+"dns_upstream_failed_attempts", "counter", "Attempts to an upstream that did not produce the answer.", labels, fmt.Sprint(s.FailedAttempts))
+		collectHistogramOpenMetrics(emit, openMetricsPrefix+"dns_upstream_latency_seconds",
+			"Time to answer a forwarded DNS query, by the upstream that answered.", labels, s.LatencyMs, 1e-3)
+	}
+}
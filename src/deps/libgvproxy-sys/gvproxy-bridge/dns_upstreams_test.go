@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// udpEcho is a nameserver stand-in that echoes each datagram back.
+func udpEcho(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr) //nolint:errcheck
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// exchange dials address for the lookup and waits for one reply.
+func exchange(t *testing.T, u *dnsUpstreams, ctx context.Context, address string) {
+	t.Helper()
+	conn, err := u.dial(ctx, "udp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("query")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 512)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func upstreamStats(u *dnsUpstreams, name string) DNSUpstreamStats {
+	for _, s := range u.Stats() {
+		if s.Upstream == name {
+			return s
+		}
+	}
+	return DNSUpstreamStats{}
+}
+
+func TestDNSUpstreams_AttributesToAnsweringNameserver(t *testing.T) {
+	u := newDNSUpstreams()
+	silent, answering := "127.0.0.1:9", udpEcho(t)
+
+	// The first nameserver never replies; the resolver falls back.
+	ctx, lookup := u.begin(context.Background())
+	conn, err := u.dial(ctx, "udp", silent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	exchange(t, u, ctx, answering)
+	lookup.end(nil)
+
+	if s := upstreamStats(u, answering); s.Queries != 1 || s.Errors != 0 || s.FailedAttempts != 0 || s.LatencyMs.Count != 1 {
+		t.Fatalf("unexpected stats for the answering upstream: %+v", s)
+	}
+	if s := upstreamStats(u, silent); s.Queries != 0 || s.FailedAttempts != 1 {
+		t.Fatalf("unexpected stats for the silent upstream: %+v", s)
+	}
+}
+
+func TestDNSUpstreams_NotFoundIsAnAnswer(t *testing.T) {
+	u := newDNSUpstreams()
+	answering := udpEcho(t)
+
+	ctx, lookup := u.begin(context.Background())
+	exchange(t, u, ctx, answering)
+	lookup.end(&net.DNSError{Err: "no such host", Name: "missing.example.", IsNotFound: true})
+
+	ctx, lookup = u.begin(context.Background())
+	exchange(t, u, ctx, answering)
+	lookup.end(&net.DNSError{Err: "server misbehaving", Name: "broken.example."})
+
+	if s := upstreamStats(u, answering); s.Queries != 2 || s.Errors != 1 || s.LatencyMs.Count != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestDNSUpstreams_SystemWithoutDial(t *testing.T) {
+	u := newDNSUpstreams()
+	_, lookup := u.begin(context.Background())
+	lookup.end(nil)
+	_, lookup = u.begin(context.Background())
+	lookup.end(errors.New("lookup failed"))
+
+	stats := u.Stats()
+	if len(stats) != 1 || stats[0].Upstream != dnsUpstreamSystem || stats[0].Queries != 2 || stats[0].Errors != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestDNSUpstreams_OpenMetrics(t *testing.T) {
+	u := newDNSUpstreams()
+	_, lookup := u.begin(context.Background())
+	lookup.end(nil)
+
+	var families []string
+	collectDNSUpstreamsOpenMetrics(func(family, typ, help, labels, value string) {
+		if labels != "" && !strings.HasPrefix(labels, `upstream="system"`) {
+			t.Errorf("%s: unexpected labels %q", family, labels)
+		}
+		families = append(families, family)
+	}, u.Stats())
+	want := openMetricsPrefix + "dns_upstream_queries"
+	if len(families) == 0 || families[0] != want {
+		t.Fatalf("families = %v, want %s first", families, want)
+	}
+}
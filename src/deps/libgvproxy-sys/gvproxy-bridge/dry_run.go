@@ -0,0 +1,40 @@
+package main
+
+// dry_run.go — Check a create config without creating anything.
+//
+// UIs let users type port mappings and want to flag mistakes before the box
+// starts. With dry_run set, gvproxy_create runs the checks of a real create
+// (limits, reserved ports, socket paths, MACs, guest aliases, histogram
+// buckets, link protocol, DNS records) and then checks the port mappings: no
+// host port may be mapped twice, and each must be bindable now (it is bound
+// and released at once). It fails exactly like a real create would, error
+// message included, and returns 0 on success. No instance is registered, no
+// socket is created and no stale socket is removed.
+//
+// A dry run cannot vouch for handed-over FDs without adopting them, so
+// import_listeners, import_vm_socket, stdio_fds and the FD of
+// gvproxy_create_with_fd are refused (and, as on any failed create, closed). gvproxy_expose has its own dry_run (forward_expose.go).
+
+import "fmt"
+
+// dryRunCreate runs the checks left once startInstance has validated config.
+func dryRunCreate(config GvproxyConfig) error {
+	if len(config.ImportListeners) > 0 || config.ImportVMSocket != nil || config.StdioFDs != nil || config.LinkFD != nil {
+		return classify(createErrConfig, fmt.Errorf("dry_run cannot check handed-over FDs (import_listeners, import_vm_socket, stdio_fds, link fd)"))
+	}
+	seen := make(map[string]bool, len(config.PortMappings))
+	for _, pm := range config.PortMappings {
+		if pm.HostPort != 0 || pm.HostSocket != "" || pm.HostVsock != nil {
+			if seen[pm.hostAddr()] {
+				return classify(createErrPortConflict, fmt.Errorf("host address %s is mapped twice", pm.hostAddr()))
+			}
+			seen[pm.hostAddr()] = true
+		}
+		l, err := listenForward(pm)
+		if err != nil {
+			return err
+		}
+		l.Close()
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func dryRunConfig(t *testing.T, mappings ...PortMapping) GvproxyConfig {
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	config.PortMappings = mappings
+	config.DryRun = true
+	return config
+}
+
+func TestCreateInstance_DryRunCreatesNothing(t *testing.T) {
+	port := freeTCPPort(t)
+	config := dryRunConfig(t, PortMapping{HostPort: port, GuestPort: 80})
+	instancesMu.RLock()
+	before, next := len(instances), nextID
+	instancesMu.RUnlock()
+
+	id, err := createInstance(config)
+	if err != nil || id != 0 {
+		t.Fatalf("createInstance = %d, %v; want 0, nil", id, err)
+	}
+	instancesMu.RLock()
+	after, nextAfter := len(instances), nextID
+	instancesMu.RUnlock()
+	if after != before || nextAfter != next {
+		t.Fatalf("instances %d -> %d, next id %d -> %d; want unchanged", before, after, next, nextAfter)
+	}
+	if _, err := os.Stat(config.SocketPath); !os.IsNotExist(err) {
+		t.Fatalf("dry run created the socket: %v", err)
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		t.Fatalf("dry run left the host port bound: %v", err)
+	}
+	l.Close()
+}
+
+func TestCreateInstance_DryRunReportsCreateErrors(t *testing.T) {
+	busy, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := uint16(busy.Addr().(*net.TCPAddr).Port)
+	port := freeTCPPort(t)
+
+	if _, err := createInstance(dryRunConfig(t, PortMapping{HostPort: busyPort, GuestPort: 80})); err == nil || !strings.Contains(err.Error(), "address already in use") {
+		t.Errorf("busy port: err = %v, want address already in use", err)
+	}
+	if _, err := createInstance(dryRunConfig(t, PortMapping{HostPort: port, GuestPort: 80}, PortMapping{HostPort: port, GuestPort: 81})); err == nil || !strings.Contains(err.Error(), "mapped twice") {
+		t.Errorf("duplicate port: err = %v, want mapped twice", err)
+	}
+	if _, err := createInstance(dryRunConfig(t, PortMapping{HostPort: 22, GuestPort: 22})); !errors.Is(err, ErrReservedHostPort) {
+		t.Errorf("reserved port: err = %v, want ErrReservedHostPort", err)
+	}
+	config := dryRunConfig(t)
+	config.Protocol = "hyperkit"
+	if _, err := createInstance(config); err == nil {
+		t.Error("expected an error for an unsupported protocol")
+	}
+}
@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// dupOnto makes newfd refer to the file open on oldfd.
+func dupOnto(oldfd, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, syscall.O_CLOEXEC)
+}
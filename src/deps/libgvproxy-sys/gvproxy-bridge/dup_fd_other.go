@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+
+package main
+
+import "syscall"
+
+// dupOnto makes newfd refer to the file open on oldfd.
+func dupOnto(oldfd, newfd int) error {
+	return syscall.Dup2(oldfd, newfd)
+}
@@ -0,0 +1,89 @@
+package main
+
+// egress_ttl.go — Fixed TTL on the host side of NATed guest traffic.
+//
+// Guest flows leave the host through sockets the bridge opens, so the host
+// kernel builds every egress packet and stamps its own default TTL. Some
+// upstream middleboxes (carrier tethering detection, mostly) fingerprint
+// virtualized or tethered traffic by that TTL and throttle it. With
+// egress_ttl set, every socket opened for guest TCP and UDP flows gets that
+// TTL (and IPv6 hop limit) instead, so all of its packets carry it.
+//
+// Because the kernel emits the packets, the rewrite is per socket: the
+// counter reports flows opened with the configured TTL.
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// egressDialer opens host sockets for guest flows. A nil *egressDialer dials
+// with OS defaults.
+type egressDialer struct {
+	ttl   int
+	flows atomic.Uint64
+}
+
+// newEgressDialer returns nil when ttl is 0 (OS default).
+func newEgressDialer(ttl uint8) *egressDialer {
+	if ttl == 0 {
+		return nil
+	}
+	return &egressDialer{ttl: int(ttl)}
+}
+
+// netDialer returns a dialer applying the egress TTL to every socket.
+func (e *egressDialer) netDialer(timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout}
+	if e != nil {
+		d.Control = e.control
+	}
+	return d
+}
+
+// DialContext dials addr with the egress TTL applied.
+func (e *egressDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return e.netDialer(0).DialContext(ctx, network, addr)
+}
+
+func (e *egressDialer) control(network, _ string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if network == "tcp6" || network == "udp6" {
+			sockErr = setsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, e.ttl)
+		} else {
+			sockErr = setsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TTL, e.ttl)
+		}
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		// Never fail the flow over it; it just leaves with the OS default.
+		logrus.WithFields(logrus.Fields{"error": err, "network": network, "ttl": e.ttl}).Debug("Egress TTL: setsockopt failed")
+		return nil
+	}
+	e.flows.Add(1)
+	return nil
+}
+
+// Flows returns how many guest flows were opened with the egress TTL.
+func (e *egressDialer) Flows() uint64 {
+	return e.flows.Load()
+}
+
+// egressTTLCollector publishes the flow count as "EgressTTLFlows".
+type egressTTLCollector struct{ e *egressDialer }
+
+func (egressTTLCollector) Name() string { return "EgressTTLFlows" }
+
+func (egressTTLCollector) Description() string {
+	return "Guest TCP and UDP flows whose host sockets were opened with egress_ttl."
+}
+
+func (c egressTTLCollector) Collect() any { return c.e.Flows() }
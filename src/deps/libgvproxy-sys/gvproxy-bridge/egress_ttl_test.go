@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func socketTTL(t *testing.T, conn net.Conn) int {
+	t.Helper()
+	raw, err := conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn: %v", err)
+	}
+	var ttl int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		ttl, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL)
+	}); err != nil || sockErr != nil {
+		t.Fatalf("getsockopt IP_TTL: %v %v", err, sockErr)
+	}
+	return ttl
+}
+
+func TestEgressDialer_SetsTTL(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	udp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	defer udp.Close()
+
+	e := newEgressDialer(42)
+	for network, addr := range map[string]string{"tcp": ln.Addr().String(), "udp": udp.LocalAddr().String()} {
+		conn, err := e.DialContext(context.Background(), network, addr)
+		if err != nil {
+			t.Fatalf("dial %s: %v", network, err)
+		}
+		if ttl := socketTTL(t, conn); ttl != 42 {
+			t.Errorf("%s: expected TTL 42, got %d", network, ttl)
+		}
+		conn.Close()
+	}
+	if got := (egressTTLCollector{e}).Collect(); got != uint64(2) {
+		t.Fatalf("expected 2 flows, got %v", got)
+	}
+}
+
+func TestEgressDialer_DisabledUsesDefaults(t *testing.T) {
+	if e := newEgressDialer(0); e != nil {
+		t.Fatalf("egress_ttl 0 must disable the dialer, got %+v", e)
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	var e *egressDialer
+	conn, err := e.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("nil dialer must dial with OS defaults: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,148 @@
+package main
+
+// events.go — Structured lifecycle events delivered to the embedder.
+//
+// Logs are free-form text for humans; events are JSON documents with a stable
+// "type" the Rust side can branch on (e.g. open a browser on the first inbound
+// connection). Delivery mirrors the log callback: one process-wide function
+// pointer registered via gvproxy_set_event_callback, invoked synchronously
+// from whichever goroutine produced the event.
+
+/*
+#include <stdlib.h>
+
+typedef void (*event_callback_fn)(long long id, const char* event_json);
+
+static void call_rust_event_callback(void* callback, long long id, const char* event_json) {
+	if (callback != NULL) {
+		((event_callback_fn)callback)(id, event_json);
+	}
+}
+*/
+import "C"
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// Event types (stable wire values, match Rust).
+const (
+	// EventInboundConnection fires when a host-side client connects to a
+	// published port. Fields: peer, host_port, guest_port, guest.
+	EventInboundConnection = "inbound_connection"
+)
+
+// Event is the JSON document passed to the event callback.
+type Event struct {
+	Type       string         `json:"type"`
+	InstanceID int64          `json:"instance_id"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Fields     map[string]any `json:"fields,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"` // active trace of the instance (trace_ids.go)
+}
+
+var (
+	rustEventCallback unsafe.Pointer
+	eventCallbackMu   sync.RWMutex
+
+	// eventSink also receives every event when set (helper mode, helper.go).
+	eventSink atomic.Pointer[func(Event)]
+)
+
+// maxRecentEvents bounds the process-wide history kept for support bundles.
+const maxRecentEvents = 256
+
+var (
+	recentEvents   []Event // ring buffer, oldest at recentEventsAt once full
+	recentEventsAt int
+	recentEventsMu sync.Mutex
+)
+
+// gvproxy_set_event_callback registers the process-wide event callback. The
+// callback receives the instance id and a JSON-encoded Event that is only
+// valid for the duration of the call. Pass NULL to stop event delivery.
+//
+//export gvproxy_set_event_callback
+func gvproxy_set_event_callback(callback unsafe.Pointer) {
+	eventCallbackMu.Lock()
+	rustEventCallback = callback
+	eventCallbackMu.Unlock()
+}
+
+// emitEvent delivers an event to the registered callback (if any).
+// Events are also logged at debug level and kept in a short history so they
+// remain visible without a callback.
+func emitEvent(instanceID int64, eventType string, fields map[string]any) {
+	// Nothing is delivered after gvproxy_destroy_sync (teardown.go).
+	leave, ok := enterCallback(instanceID)
+	if !ok {
+		return
+	}
+	defer leave()
+	logrus.WithFields(logrus.Fields{"id": instanceID, "event": eventType}).Debug("gvproxy event")
+
+	event := Event{
+		Type:       eventType,
+		InstanceID: instanceID,
+		Timestamp:  time.Now().UTC(),
+		Fields:     fields,
+		TraceID:    traceFor(instanceID),
+	}
+	rememberEvent(event)
+	if sink := eventSink.Load(); sink != nil {
+		(*sink)(event)
+	}
+	streamSinkEvent(event)
+
+	eventCallbackMu.RLock()
+	callback := rustEventCallback
+	eventCallbackMu.RUnlock()
+
+	if callback == nil {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "event": eventType}).Warn("Failed to encode gvproxy event")
+		return
+	}
+
+	if f, ok := ffiFaults.take(ffiFaultSlowCallback); ok {
+		time.Sleep(time.Duration(f.DelayMs) * time.Millisecond)
+	}
+	cPayload := C.CString(string(payload))
+	C.call_rust_event_callback(callback, C.longlong(instanceID), cPayload)
+	C.free(unsafe.Pointer(cPayload))
+}
+
+func rememberEvent(event Event) {
+	recentEventsMu.Lock()
+	defer recentEventsMu.Unlock()
+	if len(recentEvents) < maxRecentEvents {
+		recentEvents = append(recentEvents, event)
+		return
+	}
+	recentEvents[recentEventsAt] = event
+	recentEventsAt = (recentEventsAt + 1) % maxRecentEvents
+}
+
+// recentEventsFor returns the remembered events of an instance, oldest
+// first. Process-wide events (instance 0) are included.
+func recentEventsFor(instanceID int64) []Event {
+	recentEventsMu.Lock()
+	defer recentEventsMu.Unlock()
+	out := []Event{}
+	for i := range recentEvents {
+		e := recentEvents[(recentEventsAt+i)%len(recentEvents)]
+		if e.InstanceID == instanceID || e.InstanceID == 0 {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// closeFD closes a raw descriptor the bridge owns.
+func closeFD(fd int) {
+	syscall.Close(fd)
+}
+
+// dupFD dups a socket descriptor, close-on-exec.
+func dupFD(fd uintptr) (int, error) {
+	dup, err := syscall.Dup(int(fd))
+	if err == nil {
+		syscall.CloseOnExec(dup)
+	}
+	return dup, err
+}
+
+// setsockoptInt sets an integer socket option on a raw socket.
+func setsockoptInt(fd uintptr, level, opt, value int) error {
+	return syscall.SetsockoptInt(int(fd), level, opt, value)
+}
+
+// setNonblock puts a descriptor in non-blocking mode, so files made from it
+// use the runtime poller.
+func setNonblock(fd int) error {
+	return syscall.SetNonblock(fd, true)
+}
+
+func socketpair(sockType int) ([2]int, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, sockType, 0)
+	if err != nil {
+		return fds, err
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	return fds, nil
+}
+
+// sendFD passes fd over uc (SCM_RIGHTS) with one byte of payload.
+func sendFD(uc *net.UnixConn, fd int) error {
+	_, _, err := uc.WriteMsgUnix([]byte{0}, syscall.UnixRights(fd), nil)
+	return err
+}
+
+// socketInfo reports a socket's type (SOCK_STREAM, ...), whether it is
+// listening, and whether it has a peer.
+func socketInfo(fd int) (sockType int, listening, connected bool, err error) {
+	if sockType, err = syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE); err != nil {
+		return 0, false, false, err
+	}
+	if sockType != syscall.SOCK_DGRAM {
+		accepting, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN)
+		if err != nil {
+			return 0, false, false, err
+		}
+		listening = accepting != 0
+	}
+	// Only ENOTCONN means no peer: an unnamed peer (a socketpair) may fail
+	// to convert on some systems.
+	_, peerErr := syscall.Getpeername(fd)
+	return sockType, listening, peerErr != syscall.ENOTCONN, nil
+}
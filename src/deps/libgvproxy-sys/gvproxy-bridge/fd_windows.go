@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// errNoFDPassing is returned by exports that hand Unix descriptors to the
+// embedder or take them from it; Windows has no equivalent the bridge
+// supports.
+var errNoFDPassing = classify(createErrUnsupported, errors.New("passing file descriptors is not supported on windows"))
+
+// closeFD closes a raw handle the bridge owns.
+func closeFD(fd int) {
+	syscall.CloseHandle(syscall.Handle(fd))
+}
+
+func dupFD(uintptr) (int, error) {
+	return -1, errNoFDPassing
+}
+
+// setsockoptInt sets an integer socket option on a raw socket.
+func setsockoptInt(fd uintptr, level, opt, value int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), level, opt, value)
+}
+
+func setNonblock(int) error {
+	return errNoFDPassing
+}
+
+func socketpair(int) ([2]int, error) {
+	return [2]int{-1, -1}, errNoFDPassing
+}
+
+func sendFD(*net.UnixConn, int) error {
+	return errNoFDPassing
+}
+
+func socketInfo(int) (int, bool, bool, error) {
+	return 0, false, false, errNoFDPassing
+}
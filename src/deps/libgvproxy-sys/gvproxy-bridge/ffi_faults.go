@@ -0,0 +1,114 @@
+package main
+
+// ffi_faults.go — Forced failures for testing the Rust side of the FFI.
+//
+// The Rust wrappers handle a failed socket bind, a failed accept, missing
+// stats and a callback that blocks, but none of these can be provoked on
+// demand from a CI job. gvproxy_inject_fault arms one of them:
+//
+//   - socket_bind: gvproxy_create fails to create the VM link socket
+//     (GVPROXY_ERR_SOCKET);
+//   - accept: accepting the VM's connection on the link socket (qemu, bess)
+//     fails, which ends the link as a real accept error does;
+//   - stats_unavailable: gvproxy_get_stats and its variants return NULL (or
+//     -1) as for an instance whose network is not ready;
+//   - slow_callback: the event callback is invoked delay_ms late, holding
+//     up the goroutine that emitted the event.
+//
+// A fault fires count times (0: until cleared), then disarms. Injection is
+// only compiled into debug builds (build tag gvproxy_debug, set by build.rs
+// for debug profiles; ffi_faults_debug.go); release builds keep the export
+// but refuse to arm anything.
+
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// Fault kinds (stable wire values, match Rust).
+const (
+	ffiFaultSocketBind       = "socket_bind"
+	ffiFaultAccept           = "accept"
+	ffiFaultStatsUnavailable = "stats_unavailable"
+	ffiFaultSlowCallback     = "slow_callback"
+)
+
+// errInjectedFault is the error of an injected socket_bind or accept fault.
+var errInjectedFault = errors.New("injected fault (gvproxy_inject_fault)")
+
+// FFIFault is the JSON accepted by gvproxy_inject_fault.
+type FFIFault struct {
+	Kind    string `json:"kind"`
+	Count   int    `json:"count,omitempty"`    // times it fires; 0 => until cleared
+	DelayMs int    `json:"delay_ms,omitempty"` // slow_callback only
+}
+
+func checkFFIFault(f FFIFault) error {
+	switch f.Kind {
+	case ffiFaultSocketBind, ffiFaultAccept, ffiFaultStatsUnavailable:
+		if f.DelayMs != 0 {
+			return fmt.Errorf("fault %q: delay_ms is only for %s", f.Kind, ffiFaultSlowCallback)
+		}
+	case ffiFaultSlowCallback:
+		if f.DelayMs <= 0 {
+			return fmt.Errorf("fault %q needs a positive delay_ms", f.Kind)
+		}
+	default:
+		return fmt.Errorf("unknown fault kind %q", f.Kind)
+	}
+	if f.Count < 0 {
+		return fmt.Errorf("fault %q: negative count", f.Kind)
+	}
+	return nil
+}
+
+// injectFFIFault arms f, replacing any armed fault of the same kind.
+func injectFFIFault(f FFIFault) error {
+	if !ffiFaultInjection {
+		return fmt.Errorf("fault injection needs a debug build (build tag gvproxy_debug)")
+	}
+	if err := checkFFIFault(f); err != nil {
+		return err
+	}
+	ffiFaults.arm(f)
+	return nil
+}
+
+// failFFIFault returns errInjectedFault if a fault of kind fires.
+func failFFIFault(kind string) error {
+	if _, ok := ffiFaults.take(kind); ok {
+		logrus.WithField("kind", kind).Warn("Injected fault fired")
+		return fmt.Errorf("%s: %w", kind, errInjectedFault)
+	}
+	return nil
+}
+
+// gvproxy_inject_fault arms a forced failure: {"kind": "socket_bind" |
+// "accept" | "stats_unavailable" | "slow_callback", "count": 1,
+// "delay_ms": 500}. NULL disarms every fault. Debug builds only.
+//
+// Returns 0 on success, -1 on failure (logged): a release build, invalid
+// JSON, an unknown kind, or slow_callback without a positive delay_ms.
+//
+//export gvproxy_inject_fault
+func gvproxy_inject_fault(faultJSON *C.char) C.int {
+	if faultJSON == nil {
+		ffiFaults.clear()
+		return 0
+	}
+	var f FFIFault
+	if err := json.Unmarshal([]byte(C.GoString(faultJSON)), &f); err != nil {
+		logrus.WithError(err).Error("Failed to parse fault")
+		return -1
+	}
+	if err := injectFFIFault(f); err != nil {
+		logrus.WithError(err).Error("Failed to inject fault")
+		return -1
+	}
+	logrus.WithFields(logrus.Fields{"kind": f.Kind, "count": f.Count, "delay_ms": f.DelayMs}).Warn("Fault injection armed")
+	return 0
+}
@@ -0,0 +1,45 @@
+//go:build gvproxy_debug
+
+package main
+
+import "sync"
+
+// ffiFaultInjection reports whether gvproxy_inject_fault can arm faults.
+const ffiFaultInjection = true
+
+// ffiFaultSet holds the armed faults by kind.
+type ffiFaultSet struct {
+	mu    sync.Mutex
+	armed map[string]*FFIFault
+}
+
+var ffiFaults = &ffiFaultSet{armed: make(map[string]*FFIFault)}
+
+func (s *ffiFaultSet) arm(f FFIFault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.armed[f.Kind] = &f
+}
+
+func (s *ffiFaultSet) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.armed)
+}
+
+// take fires the fault of kind if one is armed, disarming it once its
+// count is used up.
+func (s *ffiFaultSet) take(kind string) (FFIFault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.armed[kind]
+	if !ok {
+		return FFIFault{}, false
+	}
+	if f.Count > 0 {
+		if f.Count--; f.Count == 0 {
+			delete(s.armed, kind)
+		}
+	}
+	return *f, true
+}
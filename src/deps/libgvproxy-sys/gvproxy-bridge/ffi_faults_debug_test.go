@@ -0,0 +1,71 @@
+//go:build gvproxy_debug
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFFIFaults_FireCountTimes(t *testing.T) {
+	t.Cleanup(ffiFaults.clear)
+	if err := injectFFIFault(FFIFault{Kind: ffiFaultAccept, Count: 2}); err != nil {
+		t.Fatalf("injectFFIFault: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := failFFIFault(ffiFaultAccept); !errors.Is(err, errInjectedFault) {
+			t.Fatalf("attempt %d: err = %v, want the injected fault", i, err)
+		}
+	}
+	if err := failFFIFault(ffiFaultAccept); err != nil {
+		t.Fatalf("fault still armed after its count: %v", err)
+	}
+
+	// Count 0 fires until cleared.
+	if err := injectFFIFault(FFIFault{Kind: ffiFaultSlowCallback, DelayMs: 10}); err != nil {
+		t.Fatalf("injectFFIFault: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if f, ok := ffiFaults.take(ffiFaultSlowCallback); !ok || f.DelayMs != 10 {
+			t.Fatalf("attempt %d: fault = %+v, %v", i, f, ok)
+		}
+	}
+	ffiFaults.clear()
+	if _, ok := ffiFaults.take(ffiFaultSlowCallback); ok {
+		t.Fatal("fault still armed after clear")
+	}
+}
+
+func TestFFIFaults_SocketBindAndStats(t *testing.T) {
+	t.Cleanup(ffiFaults.clear)
+	const id = 5936
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+
+	if err := injectFFIFault(FFIFault{Kind: ffiFaultSocketBind, Count: 1}); err != nil {
+		t.Fatalf("injectFFIFault: %v", err)
+	}
+	err := startInstance(id, config)
+	if err == nil {
+		stopTestInstance(id)
+		t.Fatal("startInstance succeeded with socket_bind armed")
+	}
+	if createErrorClassOf(err) != createErrSocket || !errors.Is(err, errInjectedFault) {
+		t.Fatalf("err = %v, want an injected socket error", err)
+	}
+
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance after the fault fired: %v", err)
+	}
+	defer stopTestInstance(id)
+	if err := injectFFIFault(FFIFault{Kind: ffiFaultStatsUnavailable, Count: 1}); err != nil {
+		t.Fatalf("injectFFIFault: %v", err)
+	}
+	if stats := instanceStats(id, StatsFormatJSON); stats != "" {
+		t.Fatal("stats returned with stats_unavailable armed")
+	}
+	if stats := instanceStats(id, StatsFormatJSON); stats == "" {
+		t.Fatal("stats still unavailable after the fault fired")
+	}
+}
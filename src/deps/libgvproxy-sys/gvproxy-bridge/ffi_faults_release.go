@@ -0,0 +1,17 @@
+//go:build !gvproxy_debug
+
+package main
+
+// ffiFaultInjection reports whether gvproxy_inject_fault can arm faults.
+const ffiFaultInjection = false
+
+// ffiFaultSet is a no-op outside debug builds: no fault ever fires.
+type ffiFaultSet struct{}
+
+var ffiFaults ffiFaultSet
+
+func (ffiFaultSet) arm(FFIFault) {}
+
+func (ffiFaultSet) clear() {}
+
+func (ffiFaultSet) take(string) (FFIFault, bool) { return FFIFault{}, false }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckFFIFault(t *testing.T) {
+	for _, tc := range []struct {
+		fault FFIFault
+		want  string
+	}{
+		{FFIFault{Kind: ffiFaultSocketBind}, ""},
+		{FFIFault{Kind: ffiFaultAccept, Count: 2}, ""},
+		{FFIFault{Kind: ffiFaultSlowCallback, DelayMs: 50}, ""},
+		{FFIFault{Kind: ffiFaultSlowCallback}, "positive delay_ms"},
+		{FFIFault{Kind: ffiFaultStatsUnavailable, DelayMs: 50}, "only for slow_callback"},
+		{FFIFault{Kind: ffiFaultAccept, Count: -1}, "negative count"},
+		{FFIFault{Kind: "disk_full"}, "unknown fault kind"},
+	} {
+		err := checkFFIFault(tc.fault)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%+v: err = %v, want %q", tc.fault, err, tc.want)
+		}
+	}
+}
+
+func TestInjectFFIFault_RefusedInReleaseBuilds(t *testing.T) {
+	if ffiFaultInjection {
+		t.Skip("debug build")
+	}
+	if err := injectFFIFault(FFIFault{Kind: ffiFaultSocketBind}); err == nil || !strings.Contains(err.Error(), "debug build") {
+		t.Fatalf("err = %v, want fault injection refused", err)
+	}
+	if err := failFFIFault(ffiFaultSocketBind); err != nil {
+		t.Fatalf("fault fired in a release build: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+// flush_switch.go — Forget what the virtual network has learned about the
+// guest's addresses.
+//
+// The switch learns which link each MAC sits behind from the frames the VM
+// sends, and the gateway's netstack caches the IP→MAC mappings it resolved
+// by ARP. Once the guest's network is reconfigured inside the VM (a new MAC
+// on its interface, a bridge or bond in front of it, an address moved to
+// another interface), stale entries send frames to a MAC nothing answers on
+// until the guest happens to speak again. gvproxy_flush_switch drops both
+// tables. They refill on their own: the next packet to the guest is
+// preceded by a broadcast ARP request, and the guest's reply teaches the
+// switch its MAC again.
+//
+// NAT in this bridge is configuration (the host alias), not learned, so
+// there is none to flush; open TCP and UDP flows are left alone too.
+
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/tap"
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+)
+
+// SwitchFlush is the document returned by gvproxy_flush_switch.
+type SwitchFlush struct {
+	// MACEntries counts the MACs the switch had learned.
+	MACEntries int `json:"mac_entries"`
+	// NeighborEntries counts the IP→MAC mappings the netstack had cached.
+	NeighborEntries int `json:"neighbor_entries"`
+}
+
+// vnSwitch returns the switch of a VirtualNetwork.
+func vnSwitch(vn *virtualnetwork.VirtualNetwork) (*tap.Switch, error) {
+	// Access private networkSwitch field via reflect, like vnStack
+	v := reflect.ValueOf(vn).Elem()
+	field := v.FieldByName("networkSwitch")
+	if !field.IsValid() || field.Type() != reflect.TypeOf((*tap.Switch)(nil)) {
+		return nil, fmt.Errorf("VirtualNetwork has no 'networkSwitch' field (gvisor-tap-vsock API changed?)")
+	}
+	// #nosec G103 — accessing private field to reach the switch
+	return (*tap.Switch)(unsafe.Pointer(field.Pointer())), nil
+}
+
+// flushCAM empties the switch's MAC table and returns how many entries it
+// held.
+func flushCAM(sw *tap.Switch) (int, error) {
+	v := reflect.ValueOf(sw).Elem()
+	cam := v.FieldByName("cam")
+	lock := v.FieldByName("camLock")
+	if !cam.IsValid() || cam.Kind() != reflect.Map || !lock.IsValid() || lock.Type() != reflect.TypeOf(sync.RWMutex{}) {
+		return 0, fmt.Errorf("Switch has no 'cam'/'camLock' fields (gvisor-tap-vsock API changed?)")
+	}
+	// #nosec G103 — the MAC table has no exported way to clear it
+	mu := (*sync.RWMutex)(unsafe.Pointer(lock.UnsafeAddr()))
+	table := reflect.NewAt(cam.Type(), unsafe.Pointer(cam.UnsafeAddr())).Elem()
+	mu.Lock()
+	defer mu.Unlock()
+	n := table.Len()
+	table.Clear()
+	return n, nil
+}
+
+// flushSwitch drops the learned MACs and cached neighbors of vn.
+func flushSwitch(vn *virtualnetwork.VirtualNetwork) (SwitchFlush, error) {
+	var flushed SwitchFlush
+	sw, err := vnSwitch(vn)
+	if err != nil {
+		return flushed, err
+	}
+	s, err := vnStack(vn)
+	if err != nil {
+		return flushed, err
+	}
+	if flushed.MACEntries, err = flushCAM(sw); err != nil {
+		return flushed, err
+	}
+	for nicID := range s.NICInfo() {
+		if entries, tcpErr := s.Neighbors(nicID, ipv4.ProtocolNumber); tcpErr == nil {
+			flushed.NeighborEntries += len(entries)
+		}
+		if tcpErr := s.ClearNeighbors(nicID, ipv4.ProtocolNumber); tcpErr != nil {
+			return flushed, fmt.Errorf("clear neighbors of NIC %d: %s", nicID, tcpErr)
+		}
+	}
+	return flushed, nil
+}
+
+// gvproxy_flush_switch forgets the MACs the instance's switch has learned
+// and the neighbors its netstack has resolved, e.g. after the guest's
+// network was reconfigured inside the VM (see flush_switch.go). Both are
+// learned again from the guest's next frames.
+//
+// Returns {"mac_entries": N, "neighbor_entries": N}, the entries flushed, or
+// NULL if the instance does not exist or its network is not up yet. The
+// string must be freed with gvproxy_free_string.
+//
+//export gvproxy_flush_switch
+func gvproxy_flush_switch(id C.longlong) *C.char {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	instance.vnMu.RLock()
+	vn := instance.vn
+	instance.vnMu.RUnlock()
+	if vn == nil {
+		return nil
+	}
+
+	flushed, err := flushSwitch(vn)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id)}).Error("Failed to flush switch")
+		return nil
+	}
+	logrus.WithFields(logrus.Fields{"id": int64(id), "mac_entries": flushed.MACEntries, "neighbor_entries": flushed.NeighborEntries}).Info("Flushed switch")
+	out, err := json.Marshal(flushed)
+	if err != nil {
+		return nil
+	}
+	return returnCString(string(out))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlushSwitch(t *testing.T) {
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	config.Protocol = "qemu"
+	const id = 5913
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	vm, err := net.Dial("unix", config.SocketPath)
+	if err != nil {
+		t.Fatalf("dial VM socket: %v", err)
+	}
+	defer vm.Close()
+	// The switch learns the guest's MAC from its ARP request, the gateway
+	// the guest's address.
+	resolveGatewayQemu(t, vm, config)
+
+	instancesMu.RLock()
+	instance := instances[id]
+	instancesMu.RUnlock()
+	instance.vnMu.RLock()
+	vn := instance.vn
+	instance.vnMu.RUnlock()
+
+	flushed, err := flushSwitch(vn)
+	if err != nil {
+		t.Fatalf("flushSwitch: %v", err)
+	}
+	if flushed.MACEntries != 1 || flushed.NeighborEntries != 1 {
+		t.Fatalf("first flush = %+v, want one MAC and one neighbor", flushed)
+	}
+	if flushed, err = flushSwitch(vn); err != nil || flushed != (SwitchFlush{}) {
+		t.Fatalf("second flush = %+v, %v; want nothing left", flushed, err)
+	}
+
+	// Both tables refill from the guest's next frames.
+	resolveGatewayQemu(t, vm, config)
+	if flushed, err = flushSwitch(vn); err != nil || flushed.MACEntries != 1 {
+		t.Fatalf("flush after relearning = %+v, %v", flushed, err)
+	}
+}
+
+func TestFlushSwitch_UnknownInstance(t *testing.T) {
+	if out := gvproxy_flush_switch(987654); out != nil {
+		t.Fatal("unknown instance must return NULL")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+// forked_dhcp.go — Bridge-owned DHCP server advertising caller resolvers.
+//
+// Upstream's DHCP server (pkg/services/dhcp) always names the gateway as the
+// guest's only nameserver, so every lookup goes through the gateway DNS
+// server (forked_dns.go). Guests that must use external DNS appliances, with
+// no query intercepted on the way, get guest_dns_servers instead: after
+// virtualnetwork.New(), upstream's DHCP endpoint is closed and a copy of its
+// handler that advertises those servers binds in its place. Leases still
+// come from upstream's IP pool, so static leases, /leases and lease
+// following are unchanged. disable_gateway_dns additionally keeps the
+// gateway from answering on port 53 at all. dhcp_range (dhcp_range.go) and
+// dhcp_options (dhcp_options.go) also need this server.
+//
+// The handler is forked from gvisor-tap-vsock v0.8.7; only the Domain Name
+// Server option, dynamic lease assignment and the extra options differ.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"time"
+	"unsafe"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/tap"
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// checkGuestDNSServers validates guest_dns_servers and disable_gateway_dns.
+func checkGuestDNSServers(config GvproxyConfig) error {
+	for _, server := range config.GuestDNSServers {
+		if ip := net.ParseIP(server); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("guest_dns_servers: %q is not an IPv4 address", server)
+		}
+	}
+	if config.DisableGatewayDNS && len(config.GuestDNSServers) == 0 {
+		return errors.New("disable_gateway_dns needs guest_dns_servers, or the guest is left without a nameserver")
+	}
+	return nil
+}
+
+// guestDNSServers returns guest_dns_servers as addresses.
+func guestDNSServers(config GvproxyConfig) []net.IP {
+	servers := make([]net.IP, 0, len(config.GuestDNSServers))
+	for _, server := range config.GuestDNSServers {
+		servers = append(servers, net.ParseIP(server).To4())
+	}
+	return servers
+}
+
+// dhcpNameservers returns the nameservers DHCP advertises: guest_dns_servers,
+// or the gateway like upstream's server.
+func dhcpNameservers(config GvproxyConfig) []net.IP {
+	if len(config.GuestDNSServers) == 0 {
+		return []net.IP{net.ParseIP(config.GatewayIP).To4()}
+	}
+	return guestDNSServers(config)
+}
+
+// vnIPPool returns the DHCP address pool of vn.
+func vnIPPool(vn *virtualnetwork.VirtualNetwork) (*tap.IPPool, error) {
+	field := reflect.ValueOf(vn).Elem().FieldByName("ipPool")
+	if !field.IsValid() {
+		return nil, fmt.Errorf("VirtualNetwork has no 'ipPool' field (gvisor-tap-vsock API changed?)")
+	}
+	// #nosec G103 — accessing private field to share upstream's leases
+	return (*tap.IPPool)(unsafe.Pointer(field.Pointer())), nil
+}
+
+// takeOverDHCP replaces upstream's DHCP server with one advertising
+// servers as the guest's nameservers, assigning dynamic leases from dynamic
+// (nil: anywhere in the subnet) and adding options to every reply. It stops
+// when ctx is done.
+func takeOverDHCP(ctx context.Context, vn *virtualnetwork.VirtualNetwork, configuration *types.Configuration, dynamic *dhcpRange, servers []net.IP, options []dhcpv4.Option) error {
+	s, err := vnStack(vn)
+	if err != nil {
+		return err
+	}
+	pool, err := vnIPPool(vn)
+	if err != nil {
+		return err
+	}
+	closeEndpointsOn(s, tcpip.Address{}, dhcpServerPort)
+
+	var wq waiter.Queue
+	ep, tcpipErr := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if tcpipErr != nil {
+		return fmt.Errorf("bind DHCP: %s", tcpipErr)
+	}
+	ep.SocketOptions().SetBroadcast(true)
+	if tcpipErr := ep.Bind(tcpip.FullAddress{NIC: 1, Port: dhcpServerPort}); tcpipErr != nil {
+		ep.Close()
+		return fmt.Errorf("bind DHCP: %s", tcpipErr)
+	}
+
+	handler := func(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+		reply := dhcpReply(configuration, pool, dynamic, servers, options, m)
+		if reply == nil {
+			return
+		}
+		if _, err := conn.WriteTo(reply.ToBytes(), peer); err != nil {
+			logrus.Errorf("dhcp: cannot reply to client: %v", err)
+		}
+	}
+	server, err := server4.NewServer("", nil, handler, server4.WithConn(gonet.NewUDPConn(&wq, ep)))
+	if err != nil {
+		ep.Close()
+		return fmt.Errorf("start DHCP: %v", err)
+	}
+	go func() {
+		if err := server.Serve(); err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Error("Gateway DHCP server exited")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close() //nolint:errcheck
+	}()
+	return nil
+}
+
+// dhcpReply builds the reply to m, or returns nil (logged) when there is
+// none to send.
+func dhcpReply(configuration *types.Configuration, pool *tap.IPPool, dynamic *dhcpRange, servers []net.IP, options []dhcpv4.Option, m *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+	reply, err := dhcpv4.NewReplyFromRequest(m)
+	if err != nil {
+		logrus.Errorf("dhcp: cannot build reply from request: %v", err)
+		return nil
+	}
+
+	ip, err := dynamic.assign(pool, m.ClientHWAddr.String())
+	if err != nil {
+		logrus.Errorf("dhcp: cannot assign ip: %v", err)
+		return nil
+	}
+
+	_, parsedSubnet, err := net.ParseCIDR(configuration.Subnet)
+	if err != nil {
+		logrus.Errorf("dhcp: invalid subnet %v", err)
+		return nil
+	}
+
+	reply.YourIPAddr = ip
+	reply.UpdateOption(dhcpv4.OptServerIdentifier(net.ParseIP(configuration.GatewayIP)))
+	reply.UpdateOption(dhcpv4.OptIPAddressLeaseTime(time.Hour))
+
+	reply.UpdateOption(dhcpv4.Option{Code: dhcpv4.OptionSubnetMask, Value: dhcpv4.IP(parsedSubnet.Mask)})
+	reply.UpdateOption(dhcpv4.Option{Code: dhcpv4.OptionRouter, Value: dhcpv4.IP(net.ParseIP(configuration.GatewayIP))})
+	reply.UpdateOption(dhcpv4.Option{Code: dhcpv4.OptionDomainNameServer, Value: dhcpv4.IPs(servers)})
+
+	mtu := configuration.MTU
+	if mtu < 0 || mtu > math.MaxUint16 {
+		logrus.Errorf("dhcp: invalid MTU %d", mtu)
+	} else {
+		reply.UpdateOption(dhcpv4.Option{Code: dhcpv4.OptionInterfaceMTU, Value: dhcpv4.Uint16(mtu)})
+	}
+	reply.UpdateOption(dhcpv4.Option{Code: dhcpv4.OptionDNSDomainSearchList, Value: &rfc1035label.Labels{
+		Labels: configuration.DNSSearchDomains,
+	}})
+	for _, option := range options {
+		reply.UpdateOption(option)
+	}
+
+	switch mt := m.MessageType(); mt {
+	case dhcpv4.MessageTypeDiscover:
+		reply.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
+	case dhcpv4.MessageTypeRequest:
+		reply.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+	case dhcpv4.MessageTypeRelease:
+		logrus.Debugf("dhcp: unhandled message type: %v", mt)
+		return nil
+	default:
+		logrus.Errorf("dhcp: unhandled message type: %v", mt)
+		return nil
+	}
+	return reply
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/tap"
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+)
+
+func TestCheckGuestDNSServers(t *testing.T) {
+	for _, tc := range []struct {
+		servers []string
+		disable bool
+		want    string
+	}{
+		{nil, false, ""},
+		{[]string{"10.0.0.53", "10.0.1.53"}, true, ""},
+		{[]string{"10.0.0.53"}, false, ""},
+		{nil, true, "needs guest_dns_servers"},
+		{[]string{"fd00::53"}, true, "IPv4"},
+		{[]string{"dns.corp"}, false, "IPv4"},
+	} {
+		config := testGvproxyConfig()
+		config.GuestDNSServers = tc.servers
+		config.DisableGatewayDNS = tc.disable
+		err := checkGuestDNSServers(config)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%v disable=%v: err = %v, want %q", tc.servers, tc.disable, err, tc.want)
+		}
+	}
+}
+
+func TestDHCPReply_AdvertisesGuestDNSServers(t *testing.T) {
+	config := testGvproxyConfig()
+	config.GuestDNSServers = []string{"10.0.0.53", "10.0.1.53"}
+	tapConfig := buildTapConfig(config, types.QemuProtocol)
+	_, subnet, _ := net.ParseCIDR(config.Subnet)
+	pool := tap.NewIPPool(subnet)
+	pool.Reserve(net.ParseIP(config.GuestIP), config.GuestMac)
+
+	mac, _ := net.ParseMAC(config.GuestMac)
+	discover, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatalf("NewDiscovery: %v", err)
+	}
+	reply := dhcpReply(tapConfig, pool, nil, guestDNSServers(config), nil, discover)
+	if reply == nil {
+		t.Fatal("no reply to DISCOVER")
+	}
+	if reply.MessageType() != dhcpv4.MessageTypeOffer || !reply.YourIPAddr.Equal(net.ParseIP(config.GuestIP)) {
+		t.Fatalf("reply = %s offering %v, want an offer of the static lease", reply.MessageType(), reply.YourIPAddr)
+	}
+	dns := reply.DNS()
+	if len(dns) != 2 || dns[0].String() != "10.0.0.53" || dns[1].String() != "10.0.1.53" {
+		t.Fatalf("DNS option = %v, want the guest DNS servers", dns)
+	}
+	if router := reply.Router(); len(router) != 1 || router[0].String() != config.GatewayIP {
+		t.Fatalf("router = %v, want the gateway", router)
+	}
+}
+
+func TestTakeOverDHCP_ReplacesUpstreamServer(t *testing.T) {
+	config := testGvproxyConfig()
+	tapConfig := buildTapConfig(config, types.QemuProtocol)
+	vn, err := virtualnetwork.New(tapConfig)
+	if err != nil {
+		t.Fatalf("virtualnetwork.New() failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Binding port 67 only succeeds once upstream's server is gone.
+	if err := takeOverDHCP(ctx, vn, tapConfig, nil, []net.IP{net.ParseIP("10.0.0.53")}, nil); err != nil {
+		t.Fatalf("takeOverDHCP: %v", err)
+	}
+}
+
+func TestGatewayDNS_Disabled(t *testing.T) {
+	config := testGvproxyConfig()
+	config.DisableGatewayDNS = true
+	config.GuestDNSServers = []string{"10.0.0.53"}
+	vn, err := virtualnetwork.New(buildTapConfig(config, types.QemuProtocol))
+	if err != nil {
+		t.Fatalf("virtualnetwork.New() failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, err := newGatewayDNS(config)
+	if err != nil {
+		t.Fatalf("newGatewayDNS: %v", err)
+	}
+	if err := d.takeOver(ctx, vn, config.GatewayIP); err != nil {
+		t.Fatalf("takeOver: %v", err)
+	}
+	// Nothing serves gateway:53, so it can be bound again.
+	s, _ := vnStack(vn)
+	gateway := tcpip.AddrFrom4Slice(net.ParseIP(config.GatewayIP).To4())
+	conn, err := gonet.DialUDP(s, &tcpip.FullAddress{NIC: 1, Addr: gateway, Port: 53}, nil, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("gateway:53 still bound: %v", err)
+	}
+	conn.Close()
+
+	const id = 5934
+	addFakeInstance(t, id, config)
+	instances[id].dns = d
+	err = changeDNSRecord(id, "dns_add_record", dnsRecordRequest{Zone: "svc.local.", DNSRecord: DNSRecord{Name: "web", IP: "10.0.0.1"}})
+	if err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Fatalf("err = %v, want gateway DNS disabled", err)
+	}
+}
@@ -0,0 +1,625 @@
+package main
+
+// forked_dns.go — Bridge-owned DNS server on the gateway.
+//
+// Upstream's embedded DNS server (pkg/services/dns) only answers A queries
+// from local zones; any other query type for a zone name goes to the host
+// resolver. To serve AAAA, SRV, TXT and CNAME records from zones, the bridge
+// runs its own copy of that server instead: after virtualnetwork.New(), the upstream
+// server's endpoints on gateway:53 are closed (upstream logs one error per
+// endpoint as its serve loops exit) and this one binds in their place.
+//
+// Query handling is forked from gvisor-tap-vsock v0.8.7 with two changes:
+// non-A questions for a zone name are answered from the zone's extra records
+// when it has any of that type, and names with a CNAME record are chased to
+// their target first (locally, or forwarded when the target is not a local
+// name). Everything else, including forwarding non-local names to the host
+// (or to dns_upstream_servers, dns_servers.go), behaves as upstream. Names
+// in extra_hosts (dns_extra_hosts.go) are answered before zones and CNAMEs.
+// The control socket's /dns/ endpoints are served by this server so runtime
+// zone changes apply. Test faults (dns_faults.go) are injected before a
+// query is answered, and each query is reported when dns_query_log is set
+// (dns_query_log.go). With disable_gateway_dns, upstream's server is closed
+// and nothing takes its place (forked_dhcp.go).
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	"github.com/miekg/dns"
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// DNS record types accepted in DNSRecord.Type.
+const (
+	DNSRecordA     = "A"
+	DNSRecordAAAA  = "AAAA"
+	DNSRecordSRV   = "SRV"
+	DNSRecordTXT   = "TXT"
+	DNSRecordCNAME = "CNAME"
+)
+
+// maxCNAMEChain bounds how many CNAME records one answer follows.
+const maxCNAMEChain = 8
+
+// gatewayDNS answers the guest's DNS queries on gateway:53.
+type gatewayDNS struct {
+	mu    sync.RWMutex
+	zones []types.Zone        // A records and default IPs, upstream semantics
+	extra map[string][]dns.RR // other record types by lower-case FQDN
+	hosts map[string]net.IP   // extra_hosts by lower-case FQDN (dns_extra_hosts.go)
+	// config is dns_zones with the records changed at run time
+	// (dns_records.go), for a hot upgrade.
+	config []DNSZone
+
+	faults    dnsFaultSet   // dns_faults.go
+	upstreams *dnsUpstreams // dns_upstreams.go
+	disabled  bool          // disable_gateway_dns: nothing listens on port 53
+	servers   []*dnsServer  // dns_upstream_servers (dns_servers.go)
+	queryLog  *dnsQueryLog  // dns_query_log (dns_query_log.go); nil when off
+}
+
+// newGatewayDNS builds the server's zones from config. Records with an
+// unknown type or missing fields are rejected.
+func newGatewayDNS(config GvproxyConfig) (*gatewayDNS, error) {
+	d := &gatewayDNS{zones: buildDNSZones(config), extra: make(map[string][]dns.RR), config: slices.Clone(config.DNSZones), upstreams: newDNSUpstreams(), disabled: config.DisableGatewayDNS}
+	servers, err := parseDNSServers(config.DNSUpstreamServers)
+	if err != nil {
+		return nil, err
+	}
+	d.servers = servers
+	if d.hosts, err = buildExtraHosts(config); err != nil {
+		return nil, err
+	}
+	aNames := make(map[string]bool)
+	for _, zone := range config.DNSZones {
+		for _, record := range zone.Records {
+			if isARecord(record) {
+				if err := checkARecord(record); err != nil {
+					return nil, fmt.Errorf("dns zone %q record %q: %w", zone.Name, record.Name, err)
+				}
+				if record.Name != "" {
+					aNames[strings.ToLower(record.Name+"."+zone.Name)] = true
+				}
+			}
+			rr, err := extraDNSRecord(zone.Name, record)
+			if err != nil {
+				return nil, fmt.Errorf("dns zone %q record %q: %w", zone.Name, record.Name, err)
+			}
+			if rr != nil {
+				key := strings.ToLower(rr.Header().Name)
+				d.extra[key] = append(d.extra[key], rr)
+			}
+		}
+	}
+	if err := d.checkCNAMEs(aNames); err != nil {
+		return nil, err
+	}
+	faults, err := compileDNSFaults(config.DNSFaults)
+	if err != nil {
+		return nil, err
+	}
+	d.faults.set(faults)
+	return d, nil
+}
+
+// checkCNAMEs rejects CNAME records that share their name with other records
+// (aNames holds the names of A records) or that form a loop.
+func (d *gatewayDNS) checkCNAMEs(aNames map[string]bool) error {
+	for name, rrs := range d.extra {
+		if cnameOf(rrs) == nil {
+			continue
+		}
+		if len(rrs) > 1 || aNames[name] {
+			return fmt.Errorf("dns name %q: a CNAME record cannot have other records", name)
+		}
+		if _, _, err := d.chaseCNAMEs(nil, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cnameOf returns the CNAME record among rrs, if any.
+func cnameOf(rrs []dns.RR) *dns.CNAME {
+	for _, rr := range rrs {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			return cname
+		}
+	}
+	return nil
+}
+
+// chaseCNAMEs follows CNAME records from name, appending each one to m (when
+// non-nil) under the name it was reached by. It returns the final target and
+// whether any record was followed. Callers hold d.mu.
+func (d *gatewayDNS) chaseCNAMEs(m *dns.Msg, name string) (string, bool, error) {
+	path := []string{name}
+	for {
+		cname := cnameOf(d.extra[strings.ToLower(name)])
+		if cname == nil {
+			return name, len(path) > 1, nil
+		}
+		if len(path) > maxCNAMEChain {
+			return "", false, fmt.Errorf("CNAME chain from %q is longer than %d", path[0], maxCNAMEChain)
+		}
+		for _, seen := range path {
+			if strings.EqualFold(seen, cname.Target) {
+				return "", false, fmt.Errorf("CNAME loop: %s -> %s", strings.Join(path, " -> "), cname.Target)
+			}
+		}
+		if m != nil {
+			answer := dns.Copy(cname)
+			answer.Header().Name = name
+			m.Answer = append(m.Answer, answer)
+		}
+		name = cname.Target
+		path = append(path, name)
+	}
+}
+
+// isARecord reports whether a config record is a plain A record, the only
+// kind upstream zones can hold.
+func isARecord(record DNSRecord) bool {
+	return record.Type == "" || strings.EqualFold(record.Type, DNSRecordA)
+}
+
+// checkARecord validates an A record: a name or a valid regexp.
+func checkARecord(record DNSRecord) error {
+	if record.Regexp == "" {
+		if record.Name == "" {
+			return fmt.Errorf("A record needs name or regexp")
+		}
+		return nil
+	}
+	if record.Name != "" {
+		return fmt.Errorf("A record takes name or regexp, not both")
+	}
+	if _, err := regexp.Compile(record.Regexp); err != nil {
+		return fmt.Errorf("A record regexp: %w", err)
+	}
+	return nil
+}
+
+// upstreamARecord maps an A record onto upstream's types.Record. An invalid
+// regexp (rejected by checkARecord) matches nothing.
+func upstreamARecord(record DNSRecord) types.Record {
+	r := types.Record{Name: record.Name, IP: net.ParseIP(record.IP)}
+	if record.Regexp != "" {
+		r.Regexp, _ = regexp.Compile(record.Regexp)
+	}
+	return r
+}
+
+// extraDNSRecord converts a non-A config record to a resource record. It
+// returns nil for A records.
+func extraDNSRecord(zoneName string, record DNSRecord) (dns.RR, error) {
+	if isARecord(record) {
+		return nil, nil
+	}
+	if record.Regexp != "" {
+		return nil, fmt.Errorf("regexp is only supported for A records")
+	}
+	hdr := dns.RR_Header{Name: record.Name + "." + zoneName, Class: dns.ClassINET, Ttl: 0}
+	switch strings.ToUpper(record.Type) {
+	case DNSRecordAAAA:
+		ip := net.ParseIP(record.IP)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("AAAA record needs an IPv6 ip, not %q", record.IP)
+		}
+		hdr.Rrtype = dns.TypeAAAA
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+	case DNSRecordSRV:
+		if record.Target == "" || record.Port == 0 {
+			return nil, fmt.Errorf("SRV record needs target and port")
+		}
+		hdr.Rrtype = dns.TypeSRV
+		return &dns.SRV{
+			Hdr:      hdr,
+			Priority: record.Priority,
+			Weight:   record.Weight,
+			Port:     record.Port,
+			Target:   dns.Fqdn(record.Target),
+		}, nil
+	case DNSRecordTXT:
+		if len(record.Text) == 0 {
+			return nil, fmt.Errorf("TXT record needs text")
+		}
+		hdr.Rrtype = dns.TypeTXT
+		return &dns.TXT{Hdr: hdr, Txt: record.Text}, nil
+	case DNSRecordCNAME:
+		if record.Target == "" {
+			return nil, fmt.Errorf("CNAME record needs target")
+		}
+		hdr.Rrtype = dns.TypeCNAME
+		return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(record.Target)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported record type %q", record.Type)
+	}
+}
+
+// counts returns the number of zones and records served.
+func (d *gatewayDNS) counts() (zones, records int) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, zone := range d.zones {
+		records += len(zone.Records)
+	}
+	for _, rrs := range d.extra {
+		records += len(rrs)
+	}
+	return len(d.zones), records
+}
+
+// takeOver replaces upstream's DNS server on gatewayIP:53 with this one, or
+// only closes it when the gateway DNS is disabled. The listeners close when
+// ctx is done.
+func (d *gatewayDNS) takeOver(ctx context.Context, vn *virtualnetwork.VirtualNetwork, gatewayIP string) error {
+	s, err := vnStack(vn)
+	if err != nil {
+		return err
+	}
+	gateway := tcpip.AddrFrom4Slice(net.ParseIP(gatewayIP).To4())
+	closeEndpointsOn(s, gateway, 53)
+	if d.disabled {
+		return nil
+	}
+
+	udpConn, err := gonet.DialUDP(s, &tcpip.FullAddress{NIC: 1, Addr: gateway, Port: 53}, nil, ipv4.ProtocolNumber)
+	if err != nil {
+		return fmt.Errorf("bind DNS udp: %v", err)
+	}
+	tcpLn, err := gonet.ListenTCP(s, tcpip.FullAddress{NIC: 1, Addr: gateway, Port: 53}, ipv4.ProtocolNumber)
+	if err != nil {
+		udpConn.Close()
+		return fmt.Errorf("bind DNS tcp: %v", err)
+	}
+
+	udpServer := &dns.Server{PacketConn: udpConn, Handler: dns.HandlerFunc(d.handleUDP)}
+	tcpServer := &dns.Server{Listener: tcpLn, Handler: dns.HandlerFunc(d.handleTCP)}
+	for _, srv := range []*dns.Server{udpServer, tcpServer} {
+		go func() {
+			if err := srv.ActivateAndServe(); err != nil && ctx.Err() == nil {
+				logrus.WithError(err).Error("Gateway DNS server exited")
+			}
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		udpServer.Shutdown() //nolint:errcheck
+		tcpServer.Shutdown() //nolint:errcheck
+	}()
+	return nil
+}
+
+// closeEndpointsOn closes every transport endpoint bound to addr:port.
+func closeEndpointsOn(s *stack.Stack, addr tcpip.Address, port uint16) {
+	for _, ep := range s.RegisteredEndpoints() {
+		e, ok := ep.(tcpip.Endpoint)
+		if !ok {
+			continue
+		}
+		info, ok := e.Info().(*stack.TransportEndpointInfo)
+		if !ok || info.ID.LocalPort != port || info.ID.LocalAddress != addr {
+			continue
+		}
+		if info.TransProto == header.TCPProtocolNumber || info.TransProto == header.UDPProtocolNumber {
+			e.Close()
+		}
+	}
+}
+
+func (d *gatewayDNS) handleTCP(w dns.ResponseWriter, r *dns.Msg) {
+	d.handle(w, r, dns.MaxMsgSize)
+}
+
+func (d *gatewayDNS) handleUDP(w dns.ResponseWriter, r *dns.Msg) {
+	d.handle(w, r, dns.MinMsgSize)
+}
+
+func (d *gatewayDNS) handle(w dns.ResponseWriter, r *dns.Msg, responseMessageSize int) {
+	start := time.Now()
+	if d.faults.inject(w, r) {
+		d.queryLog.report(w, r, nil, start)
+		return
+	}
+	m := d.answer(r, responseMessageSize)
+	if err := w.WriteMsg(m); err != nil {
+		logrus.Error(err)
+	}
+	d.queryLog.report(w, r, m, start)
+}
+
+// answer builds the reply to r.
+func (d *gatewayDNS) answer(r *dns.Msg, responseMessageSize int) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.RecursionAvailable = true
+	d.addAnswers(m)
+	if edns0 := r.IsEdns0(); edns0 != nil {
+		responseMessageSize = int(edns0.UDPSize())
+	}
+	m.Truncate(responseMessageSize)
+	return m
+}
+
+// addLocalAnswers answers q from extra_hosts or the local zones. When q's name is a CNAME
+// for a non-local name, it returns the question to forward to the host
+// resolver instead.
+func (d *gatewayDNS) addLocalAnswers(m *dns.Msg, q dns.Question) (dns.Question, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.addExtraHostAnswer(m, q) {
+		return q, true
+	}
+	if q.Qtype != dns.TypeCNAME {
+		target, chased, err := d.chaseCNAMEs(m, q.Name)
+		if err != nil {
+			logrus.WithError(err).Warn("Gateway DNS: cannot resolve CNAME")
+			m.Answer = nil
+			m.Rcode = dns.RcodeServerFailure
+			return q, true
+		}
+		if chased {
+			q.Name = target
+		}
+	}
+	return q, d.addZoneAnswers(m, q)
+}
+
+// addZoneAnswers answers q from the zones and extra records, ignoring
+// CNAMEs. Callers hold d.mu.
+func (d *gatewayDNS) addZoneAnswers(m *dns.Msg, q dns.Question) bool {
+	for _, zone := range d.zones {
+		zoneSuffix := fmt.Sprintf(".%s", zone.Name)
+		if strings.HasSuffix(q.Name, zoneSuffix) {
+			if q.Qtype != dns.TypeA {
+				return d.addExtraAnswers(m, q)
+			}
+			for _, record := range zone.Records {
+				withoutZone := strings.TrimSuffix(q.Name, zoneSuffix)
+				if (record.Name != "" && record.Name == withoutZone) ||
+					(record.Regexp != nil && record.Regexp.MatchString(withoutZone)) {
+					m.Answer = append(m.Answer, &dns.A{
+						Hdr: dns.RR_Header{
+							Name:   q.Name,
+							Rrtype: dns.TypeA,
+							Class:  dns.ClassINET,
+							Ttl:    0,
+						},
+						A: record.IP,
+					})
+					return true
+				}
+			}
+			if !zone.DefaultIP.Equal(net.IP("")) {
+				m.Answer = append(m.Answer, &dns.A{
+					Hdr: dns.RR_Header{
+						Name:   q.Name,
+						Rrtype: dns.TypeA,
+						Class:  dns.ClassINET,
+						Ttl:    0,
+					},
+					A: zone.DefaultIP,
+				})
+				return true
+			}
+			m.Rcode = dns.RcodeNameError
+			return true
+		}
+	}
+	// Extra records may live in a zone the A-record zones do not cover.
+	return d.addExtraAnswers(m, q)
+}
+
+// addExtraAnswers answers q from the extra records, if any match its name
+// and type. Callers hold d.mu.
+func (d *gatewayDNS) addExtraAnswers(m *dns.Msg, q dns.Question) bool {
+	found := false
+	for _, rr := range d.extra[strings.ToLower(q.Name)] {
+		if rr.Header().Rrtype != q.Qtype {
+			continue
+		}
+		answer := dns.Copy(rr)
+		answer.Header().Name = q.Name
+		m.Answer = append(m.Answer, answer)
+		found = true
+	}
+	return found
+}
+
+func (d *gatewayDNS) addAnswers(m *dns.Msg) {
+	for _, q := range m.Question {
+		q, done := d.addLocalAnswers(m, q)
+		if done {
+			return
+		}
+
+		ctx, lookup := d.upstreams.begin(context.TODO())
+		var err error
+		if len(d.servers) > 0 {
+			err = lookup.exchange(ctx, d.servers, m, q)
+		} else {
+			err = forwardQuestion(ctx, d.upstreams.resolver(), m, q)
+		}
+		lookup.end(err)
+		if err != nil {
+			m.Rcode = dns.RcodeNameError
+			return
+		}
+	}
+}
+
+// forwardQuestion answers q through the host resolver.
+func forwardQuestion(ctx context.Context, resolver *net.Resolver, m *dns.Msg, q dns.Question) error {
+	switch q.Qtype {
+	case dns.TypeA:
+		ips, err := resolver.LookupIPAddr(ctx, q.Name)
+		if err != nil {
+			return err
+		}
+		for _, ip := range ips {
+			if len(ip.IP.To4()) != net.IPv4len {
+				continue
+			}
+			m.Answer = append(m.Answer, &dns.A{
+				Hdr: dns.RR_Header{
+					Name:   q.Name,
+					Rrtype: dns.TypeA,
+					Class:  dns.ClassINET,
+					Ttl:    0,
+				},
+				A: ip.IP.To4(),
+			})
+		}
+	case dns.TypeCNAME:
+		cname, err := resolver.LookupCNAME(ctx, q.Name)
+		if err != nil {
+			return err
+		}
+		m.Answer = append(m.Answer, &dns.CNAME{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeCNAME,
+				Class:  dns.ClassINET,
+				Ttl:    0,
+			},
+			Target: cname,
+		})
+	case dns.TypeMX:
+		records, err := resolver.LookupMX(ctx, q.Name)
+		if err != nil {
+			return err
+		}
+		for _, mx := range records {
+			m.Answer = append(m.Answer, &dns.MX{
+				Hdr: dns.RR_Header{
+					Name:   q.Name,
+					Rrtype: dns.TypeMX,
+					Class:  dns.ClassINET,
+					Ttl:    0,
+				},
+				Mx:         mx.Host,
+				Preference: mx.Pref,
+			})
+		}
+	case dns.TypeNS:
+		records, err := resolver.LookupNS(ctx, q.Name)
+		if err != nil {
+			return err
+		}
+		for _, ns := range records {
+			m.Answer = append(m.Answer, &dns.NS{
+				Hdr: dns.RR_Header{
+					Name:   q.Name,
+					Rrtype: dns.TypeNS,
+					Class:  dns.ClassINET,
+					Ttl:    0,
+				},
+				Ns: ns.Host,
+			})
+		}
+	case dns.TypeSRV:
+		_, records, err := resolver.LookupSRV(ctx, "", "", q.Name)
+		if err != nil {
+			return err
+		}
+		for _, srv := range records {
+			m.Answer = append(m.Answer, &dns.SRV{
+				Hdr: dns.RR_Header{
+					Name:   q.Name,
+					Rrtype: dns.TypeSRV,
+					Class:  dns.ClassINET,
+					Ttl:    0,
+				},
+				Port:     srv.Port,
+				Priority: srv.Priority,
+				Target:   srv.Target,
+				Weight:   srv.Weight,
+			})
+		}
+	case dns.TypeTXT:
+		records, err := resolver.LookupTXT(ctx, q.Name)
+		if err != nil {
+			return err
+		}
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    0,
+			},
+			Txt: records,
+		})
+	}
+	return nil
+}
+
+// Mux serves upstream's DNS services API (/all, /add) against this server,
+// plus /faults (dns_faults.go).
+func (d *gatewayDNS) Mux() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/all", func(w http.ResponseWriter, _ *http.Request) {
+		d.mu.RLock()
+		_ = json.NewEncoder(w).Encode(d.zones)
+		d.mu.RUnlock()
+	})
+
+	mux.HandleFunc("/add", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "post only", http.StatusBadRequest)
+			return
+		}
+		var req types.Zone
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		d.addZone(req)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("/faults", d.faults.serveFaults)
+	return mux
+}
+
+func (d *gatewayDNS) addZone(req types.Zone) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, zone := range d.zones {
+		if zone.Name == req.Name {
+			req.Records = append(req.Records, zone.Records...)
+			d.zones[i] = req
+			return
+		}
+	}
+	// No existing zone for req.Name, add new one
+	d.zones = append(d.zones, req)
+}
+
+// servicesMux routes the control socket's /dns/ API to the gateway DNS
+// server and everything else to upstream's services.
+func servicesMux(vn *virtualnetwork.VirtualNetwork, d *gatewayDNS) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/dns/", http.StripPrefix("/dns", d.Mux()))
+	mux.Handle("/", vn.ServicesMux())
+	return mux
+}
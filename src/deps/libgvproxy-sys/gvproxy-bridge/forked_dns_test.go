@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	"github.com/miekg/dns"
+)
+
+func testGatewayDNS(t *testing.T) *gatewayDNS {
+	t.Helper()
+	d, err := newGatewayDNS(GvproxyConfig{DNSZones: []DNSZone{{
+		Name: "svc.local.",
+		Records: []DNSRecord{
+			{Name: "db", IP: "10.0.0.5"},
+			{Name: "_postgres._tcp", Type: "SRV", Target: "db.svc.local", Port: 5432, Priority: 10, Weight: 5},
+			{Name: "_postgres._tcp", Type: "SRV", Target: "db2.svc.local.", Port: 5433, Priority: 20},
+			{Name: "_acme-challenge", Type: "txt", Text: []string{"token-1", "token-2"}},
+		},
+	}}})
+	if err != nil {
+		t.Fatalf("newGatewayDNS: %v", err)
+	}
+	return d
+}
+
+func query(d *gatewayDNS, name string, qtype uint16) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetQuestion(name, qtype)
+	return d.answer(r, dns.MaxMsgSize)
+}
+
+func TestGatewayDNS_SRV(t *testing.T) {
+	m := query(testGatewayDNS(t), "_postgres._tcp.svc.local.", dns.TypeSRV)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 2 {
+		t.Fatalf("expected two SRV answers, got rcode=%d answers=%v", m.Rcode, m.Answer)
+	}
+	srv := m.Answer[0].(*dns.SRV)
+	if srv.Target != "db.svc.local." || srv.Port != 5432 || srv.Priority != 10 || srv.Weight != 5 {
+		t.Fatalf("unexpected SRV answer: %v", srv)
+	}
+	if srv.Hdr.Name != "_postgres._tcp.svc.local." {
+		t.Fatalf("answer must carry the question name, got %q", srv.Hdr.Name)
+	}
+}
+
+func TestGatewayDNS_TXT(t *testing.T) {
+	// Names match case-insensitively and keep the question's spelling.
+	m := query(testGatewayDNS(t), "_ACME-challenge.svc.local.", dns.TypeTXT)
+	if len(m.Answer) != 1 {
+		t.Fatalf("expected one TXT answer, got %v", m.Answer)
+	}
+	txt := m.Answer[0].(*dns.TXT)
+	if strings.Join(txt.Txt, ",") != "token-1,token-2" || txt.Hdr.Name != "_ACME-challenge.svc.local." {
+		t.Fatalf("unexpected TXT answer: %v", txt)
+	}
+}
+
+func TestGatewayDNS_ARecordsUnchanged(t *testing.T) {
+	d := testGatewayDNS(t)
+
+	m := query(d, "db.svc.local.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.0.0.5" {
+		t.Fatalf("expected the A record, got %v", m.Answer)
+	}
+	if m := query(d, "missing.svc.local.", dns.TypeA); m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN for an unknown name without default_ip, got rcode=%d", m.Rcode)
+	}
+	// SRV/TXT records are not A records.
+	if m := query(d, "_postgres._tcp.svc.local.", dns.TypeA); m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN for an A query on an SRV name, got rcode=%d answers=%v", m.Rcode, m.Answer)
+	}
+	if zones := buildDNSZones(GvproxyConfig{DNSZones: []DNSZone{{Name: "svc.local.", Records: []DNSRecord{
+		{Name: "db", IP: "10.0.0.5"},
+		{Name: "t", Type: "TXT", Text: []string{"x"}},
+	}}}}); len(zones[0].Records) != 1 {
+		t.Fatalf("upstream zones must only hold A records, got %v", zones[0].Records)
+	}
+}
+
+func TestGatewayDNS_AAAAAndRegexp(t *testing.T) {
+	d, err := newGatewayDNS(GvproxyConfig{DNSZones: []DNSZone{{
+		Name: "svc.local.",
+		Records: []DNSRecord{
+			{Name: "db", IP: "10.0.0.5"},
+			{Name: "db", Type: "AAAA", IP: "fd00::5"},
+			{Regexp: "^web-[0-9]+$", IP: "10.0.0.8"},
+		},
+	}}})
+	if err != nil {
+		t.Fatalf("newGatewayDNS: %v", err)
+	}
+
+	m := query(d, "db.svc.local.", dns.TypeAAAA)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.AAAA).AAAA.String() != "fd00::5" {
+		t.Fatalf("expected the AAAA record, got %v", m.Answer)
+	}
+	if m := query(d, "db.svc.local.", dns.TypeA); len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.0.0.5" {
+		t.Fatalf("the AAAA record must not shadow the A record, got %v", m.Answer)
+	}
+	if m := query(d, "web-12.svc.local.", dns.TypeA); len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.0.0.8" {
+		t.Fatalf("expected the regexp A record, got %v", m.Answer)
+	}
+	if m := query(d, "web-x.svc.local.", dns.TypeA); m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN for a name the regexp does not match, got rcode=%d", m.Rcode)
+	}
+}
+
+func TestNewGatewayDNS_RejectsInvalidRecords(t *testing.T) {
+	for _, record := range []DNSRecord{
+		{Name: "_svc._tcp", Type: "SRV", Port: 80},
+		{Name: "_svc._tcp", Type: "SRV", Target: "a.local."},
+		{Name: "t", Type: "TXT"},
+		{Name: "m", Type: "MX"},
+		{Name: "v6", Type: "AAAA", IP: "10.0.0.1"},
+		{Regexp: "^web", Type: "TXT", Text: []string{"x"}},
+		{Name: "web", Regexp: "^web", IP: "10.0.0.1"},
+		{Regexp: "(", IP: "10.0.0.1"},
+		{IP: "10.0.0.1"},
+	} {
+		_, err := newGatewayDNS(GvproxyConfig{DNSZones: []DNSZone{{Name: "local.", Records: []DNSRecord{record}}}})
+		if err == nil {
+			t.Errorf("expected %+v to be rejected", record)
+		}
+	}
+}
+
+func TestGatewayDNS_MuxAddsZones(t *testing.T) {
+	d := testGatewayDNS(t)
+	srv := httptest.NewServer(d.Mux())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/add", "application/json",
+		strings.NewReader(`{"Name":"extra.local.","Records":[{"Name":"web","IP":"10.0.0.9"}]}`))
+	if err != nil {
+		t.Fatalf("POST /add: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /add: status %d", resp.StatusCode)
+	}
+	if m := query(d, "web.extra.local.", dns.TypeA); len(m.Answer) != 1 {
+		t.Fatalf("expected the added record to resolve, got %v", m.Answer)
+	}
+}
+
+func TestGatewayDNS_TakeOver(t *testing.T) {
+	config := testGvproxyConfig()
+	vn, err := virtualnetwork.New(buildTapConfig(config, types.QemuProtocol))
+	if err != nil {
+		t.Fatalf("virtualnetwork.New() failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := testGatewayDNS(t)
+	// Binding gateway:53 only succeeds once upstream's server is gone.
+	if err := d.takeOver(ctx, vn, config.GatewayIP); err != nil {
+		t.Fatalf("takeOver: %v", err)
+	}
+}
+
+func TestGatewayDNS_CNAMEChain(t *testing.T) {
+	// Zones match in order, so the more specific one comes first.
+	d, err := newGatewayDNS(GvproxyConfig{DNSZones: []DNSZone{
+		{Name: "service.local.", Records: []DNSRecord{{Name: "canonical", IP: "10.0.0.7"}}},
+		{Name: "local.", Records: []DNSRecord{
+			{Name: "app", Type: "CNAME", Target: "web.local"},
+			{Name: "web", Type: "CNAME", Target: "canonical.service.local."},
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("newGatewayDNS: %v", err)
+	}
+
+	m := query(d, "app.local.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 3 {
+		t.Fatalf("expected two CNAMEs and an A record, got rcode=%d answers=%v", m.Rcode, m.Answer)
+	}
+	first, second := m.Answer[0].(*dns.CNAME), m.Answer[1].(*dns.CNAME)
+	if first.Hdr.Name != "app.local." || first.Target != "web.local." ||
+		second.Hdr.Name != "web.local." || second.Target != "canonical.service.local." {
+		t.Fatalf("unexpected CNAME chain: %v, %v", first, second)
+	}
+	if a := m.Answer[2].(*dns.A); a.Hdr.Name != "canonical.service.local." || a.A.String() != "10.0.0.7" {
+		t.Fatalf("unexpected final answer: %v", a)
+	}
+
+	// A CNAME query returns the record itself without chasing it.
+	if m := query(d, "app.local.", dns.TypeCNAME); len(m.Answer) != 1 || m.Answer[0].(*dns.CNAME).Target != "web.local." {
+		t.Fatalf("expected the CNAME record only, got %v", m.Answer)
+	}
+}
+
+func TestNewGatewayDNS_RejectsCNAMELoops(t *testing.T) {
+	for name, records := range map[string][]DNSRecord{
+		"self": {{Name: "a", Type: "CNAME", Target: "a.local."}},
+		"cycle": {
+			{Name: "a", Type: "CNAME", Target: "b.local."},
+			{Name: "b", Type: "CNAME", Target: "c.local."},
+			{Name: "c", Type: "CNAME", Target: "A.local."},
+		},
+		"shared name": {{Name: "a", IP: "10.0.0.1"}, {Name: "a", Type: "CNAME", Target: "b.local."}},
+		"no target":   {{Name: "a", Type: "CNAME"}},
+	} {
+		_, err := newGatewayDNS(GvproxyConfig{DNSZones: []DNSZone{{Name: "local.", Records: records}}})
+		if err == nil {
+			t.Errorf("%s: expected the records to be rejected", name)
+		}
+	}
+}
+
+func TestGatewayDNS_CNAMELoopAtQueryTime(t *testing.T) {
+	d := testGatewayDNS(t)
+	// Loops cannot come from config; guard the query path anyway.
+	d.extra["x.local."] = []dns.RR{&dns.CNAME{Hdr: dns.RR_Header{Name: "x.local.", Rrtype: dns.TypeCNAME}, Target: "y.local."}}
+	d.extra["y.local."] = []dns.RR{&dns.CNAME{Hdr: dns.RR_Header{Name: "y.local.", Rrtype: dns.TypeCNAME}, Target: "x.local."}}
+
+	if m := query(d, "x.local.", dns.TypeA); m.Rcode != dns.RcodeServerFailure || len(m.Answer) != 0 {
+		t.Fatalf("expected SERVFAIL for a CNAME loop, got rcode=%d answers=%v", m.Rcode, m.Answer)
+	}
+}
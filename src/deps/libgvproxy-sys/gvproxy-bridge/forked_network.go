@@ -1,13 +1,16 @@
 package main
 
-// forked_network.go — Override gvproxy's TCP handler after creation.
+// forked_network.go — Override gvproxy's TCP and UDP handlers after creation.
 //
 // After virtualnetwork.New() creates the network stack with the default
-// TCP forwarder, we replace it with our filtered version.
+// forwarders, we replace TCP with our filtered version and UDP with one
+// dialing through the egress dialer (forked_udp.go). Both are gated by the
+// guests' NAT state (guest_nat.go).
 //
 // stack.SetTransportProtocolHandler() is a public gVisor API.
 // The only use of reflect+unsafe is to access VirtualNetwork's private
-// `stack` field. This is guarded by forked_network_test.go.
+// `stack` field (vnStack, also used by notify.go). This is guarded by
+// forked_network_test.go.
 
 import (
 	"fmt"
@@ -22,6 +25,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 )
 
 // OverrideTCPHandler replaces the default TCP protocol handler on an
@@ -33,29 +37,60 @@ func OverrideTCPHandler(
 	filter *TCPFilter,
 	ca *BoxCA,
 	secretMatcher *SecretHostMatcher,
+	egress *egressDialer,
+	flows *flowLimiter,
+	proxies *upstreamProxies,
+	nat *guestNAT,
+	linkLocal *linkLocalPolicy,
 ) error {
-	// Access private stack field via reflect
-	v := reflect.ValueOf(vn).Elem()
-	stackField := v.FieldByName("stack")
-	if !stackField.IsValid() {
-		return fmt.Errorf("VirtualNetwork has no 'stack' field (gvisor-tap-vsock API changed?)")
+	s, err := vnStack(vn)
+	if err != nil {
+		return err
+	}
+
+	// Replace TCP handler with our filtered version
+	var natLock sync.Mutex
+	tcpFwd := TCPWithFilter(s, natTable(config), &natLock, ec2MetadataAccess, filter, ca, secretMatcher, egress, flows, proxies)
+	s.SetTransportProtocolHandler(tcp.ProtocolNumber, linkLocal.gate(nat.gate(tcpFwd.HandlePacket)))
+
+	logrus.Debug("TCP: handler overridden with SNI-inspecting forwarder")
+	return nil
+}
+
+// OverrideUDPHandler replaces the default UDP protocol handler on an
+// existing VirtualNetwork with one dialing through egress.
+func OverrideUDPHandler(vn *virtualnetwork.VirtualNetwork, config *types.Configuration, egress *egressDialer, nat *guestNAT, linkLocal *linkLocalPolicy) error {
+	s, err := vnStack(vn)
+	if err != nil {
+		return err
 	}
 
-	// #nosec G103 — accessing private field to override TCP handler
-	s := (*stack.Stack)(unsafe.Pointer(stackField.Pointer()))
+	var natLock sync.Mutex
+	udpFwd := UDPWithDialer(s, natTable(config), &natLock, egress)
+	s.SetTransportProtocolHandler(udp.ProtocolNumber, linkLocal.gate(nat.gate(udpFwd.HandlePacket)))
+	return nil
+}
 
-	// Rebuild NAT table (same logic as upstream parseNATTable in services.go)
+// natTable rebuilds the NAT table (same logic as upstream parseNATTable in
+// services.go).
+func natTable(config *types.Configuration) map[tcpip.Address]tcpip.Address {
 	nat := make(map[tcpip.Address]tcpip.Address)
 	for source, destination := range config.NAT {
 		nat[tcpip.AddrFrom4Slice(net.ParseIP(source).To4())] =
 			tcpip.AddrFrom4Slice(net.ParseIP(destination).To4())
 	}
+	return nat
+}
 
-	// Replace TCP handler with our filtered version
-	var natLock sync.Mutex
-	tcpFwd := TCPWithFilter(s, nat, &natLock, ec2MetadataAccess, filter, ca, secretMatcher)
-	s.SetTransportProtocolHandler(tcp.ProtocolNumber, tcpFwd.HandlePacket)
+// vnStack returns the gVisor stack behind a VirtualNetwork.
+func vnStack(vn *virtualnetwork.VirtualNetwork) (*stack.Stack, error) {
+	// Access private stack field via reflect
+	v := reflect.ValueOf(vn).Elem()
+	stackField := v.FieldByName("stack")
+	if !stackField.IsValid() {
+		return nil, fmt.Errorf("VirtualNetwork has no 'stack' field (gvisor-tap-vsock API changed?)")
+	}
 
-	logrus.Info("allowNet TCP: handler overridden with SNI-inspecting forwarder")
-	return nil
+	// #nosec G103 — accessing private field to reach the netstack
+	return (*stack.Stack)(unsafe.Pointer(stackField.Pointer())), nil
 }
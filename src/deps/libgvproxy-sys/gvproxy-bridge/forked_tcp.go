@@ -7,6 +7,9 @@ package main
 //   - Standard: IP/CIDR match or no filter → upstream flow (Dial → Accept → relay)
 //   - Inspect:  port 443/80 with hostname rules → Accept → Peek SNI/Host → check → Dial → relay
 //
+// Either path dials through the upstream proxy of the upstream_proxies rule
+// the flow matches, if any (upstream_proxy.go).
+//
 // When filter is nil: identical to upstream (zero overhead).
 
 import (
@@ -97,9 +100,18 @@ func resolveTCPDestination(localAddress tcpip.Address, nat map[tcpip.Address]tcp
 
 func TCPWithFilter(s *stack.Stack, nat map[tcpip.Address]tcpip.Address,
 	natLock *sync.Mutex, ec2MetadataAccess bool, filter *TCPFilter,
-	ca *BoxCA, secretMatcher *SecretHostMatcher) *tcp.Forwarder {
+	ca *BoxCA, secretMatcher *SecretHostMatcher, egress *egressDialer, flows *flowLimiter,
+	proxies *upstreamProxies) *tcp.Forwarder {
 
 	return tcp.NewForwarder(s, 0, 10, func(r *tcp.ForwarderRequest) {
+		// Refuse the SYN rather than park it when every flow slot is in use
+		// (planes.go): the forwarder holds only 10 pending requests.
+		if !flows.tryAcquire() {
+			r.Complete(true)
+			return
+		}
+		defer flows.release()
+
 		localAddress := r.ID().LocalAddress
 
 		if !ec2MetadataAccess && linkLocalSubnet.Contains(localAddress) {
@@ -113,12 +125,18 @@ func TCPWithFilter(s *stack.Stack, nat map[tcpip.Address]tcpip.Address,
 		destPort := r.ID().LocalPort
 		destAddr := fmt.Sprintf("%s:%d", dialAddress, destPort)
 
-		switch decideTCPRoute(destIP, destPort, filter, secretMatcher) {
+		route := decideTCPRoute(destIP, destPort, filter, secretMatcher)
+		inspectFilter := filter
+		if route == tcpRouteStandardForward && proxies.needsHostname(destPort) {
+			// Allowed by IP, but its proxy may depend on the hostname.
+			route, inspectFilter = tcpRouteInspect, nil
+		}
+		switch route {
 		case tcpRouteStandardForward:
-			standardForward(r, destAddr)
+			standardForward(r, destAddr, proxies.route(egress, destIP, ""))
 			return
 		case tcpRouteInspect:
-			inspectAndForward(r, destAddr, destPort, filter, ca, secretMatcher)
+			inspectAndForward(r, destIP, destAddr, destPort, inspectFilter, ca, secretMatcher, egress, proxies)
 			return
 		default:
 			// No matching rule: block
@@ -132,8 +150,8 @@ func TCPWithFilter(s *stack.Stack, nat map[tcpip.Address]tcpip.Address,
 }
 
 // standardForward is the upstream flow: Dial → CreateEndpoint → relay.
-func standardForward(r *tcp.ForwarderRequest, destAddr string) {
-	outbound, err := net.Dial("tcp", destAddr)
+func standardForward(r *tcp.ForwarderRequest, destAddr string, dialer *upstreamDial) {
+	outbound, err := dialer.DialContext(context.Background(), "tcp", destAddr)
 	if err != nil {
 		logrus.Tracef("net.Dial() = %v", err)
 		r.Complete(true)
@@ -164,7 +182,7 @@ func standardForward(r *tcp.ForwarderRequest, destAddr string) {
 // inspectAndForward: Accept → Peek SNI/Host → check allowlist → Dial → relay.
 // The flow is reversed from upstream because we need to read from the guest
 // before deciding whether to connect to the upstream server.
-func inspectAndForward(r *tcp.ForwarderRequest, destAddr string, destPort uint16, filter *TCPFilter, ca *BoxCA, secretMatcher *SecretHostMatcher) {
+func inspectAndForward(r *tcp.ForwarderRequest, destIP net.IP, destAddr string, destPort uint16, filter *TCPFilter, ca *BoxCA, secretMatcher *SecretHostMatcher, egress *egressDialer, proxies *upstreamProxies) {
 	// Step 1: Accept TCP from guest first (reversed from upstream)
 	var wq waiter.Queue
 	ep, tcpErr := r.CreateEndpoint(&wq)
@@ -196,7 +214,7 @@ func inspectAndForward(r *tcp.ForwarderRequest, destAddr string, destPort uint16
 			"num_secrets": len(secrets),
 		}).Debug("MITM: intercepting for secret substitution")
 		bufferedGuest := &bufferedConn{Conn: guestConn, reader: br}
-		mitmAndForward(bufferedGuest, hostname, destAddr, ca, secrets)
+		mitmAndForward(bufferedGuest, hostname, destAddr, proxies.route(egress, destIP, hostname), ca, secrets)
 		return
 	}
 
@@ -216,7 +234,7 @@ func inspectAndForward(r *tcp.ForwarderRequest, destAddr string, destPort uint16
 	}).Debug("allowNet TCP: allowed by hostname")
 
 	// Step 5: Dial upstream
-	outbound, err := net.Dial("tcp", destAddr)
+	outbound, err := proxies.route(egress, destIP, hostname).DialContext(context.Background(), "tcp", destAddr)
 	if err != nil {
 		logrus.WithField("error", err).Trace("allowNet TCP: upstream dial failed")
 		guestConn.Close()
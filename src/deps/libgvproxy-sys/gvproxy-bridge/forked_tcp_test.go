@@ -38,7 +38,7 @@ func TestMitmRouting_SecretHostGetsMitmd(t *testing.T) {
 
 	// Simulate: guest TLS → mitmAndForward → upstream
 	guestConn, proxyConn := net.Pipe()
-	go mitmAndForward(proxyConn, "api.openai.com", upstreamAddr, ca, secrets, &tls.Config{InsecureSkipVerify: true})
+	go mitmAndForward(proxyConn, "api.openai.com", upstreamAddr, nil, ca, secrets, &tls.Config{InsecureSkipVerify: true})
 
 	// Client does TLS handshake with the MITM proxy
 	caPool, _ := ca.CACertPool()
@@ -150,7 +150,7 @@ func TestMitmRouting_AllowlistAndSecrets_MitmPriority(t *testing.T) {
 	defer cleanup()
 
 	guestConn, proxyConn := net.Pipe()
-	go mitmAndForward(proxyConn, "api.example.com", upstreamAddr, ca, secrets, &tls.Config{InsecureSkipVerify: true})
+	go mitmAndForward(proxyConn, "api.example.com", upstreamAddr, nil, ca, secrets, &tls.Config{InsecureSkipVerify: true})
 
 	caPool, _ := ca.CACertPool()
 	tlsConn := tls.Client(guestConn, &tls.Config{
@@ -0,0 +1,90 @@
+package main
+
+// forked_udp.go — UDP forwarder dialing through the egress dialer.
+//
+// Fork of gvisor-tap-vsock@v0.8.7/pkg/services/forwarder/udp.go. The only
+// change is that host sockets are opened with egressDialer (egress_ttl)
+// instead of net.Dial; the proxy itself is upstream's forwarder.UDPProxy.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/services/forwarder"
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// UDPWithDialer creates a UDP forwarder opening host sockets with egress.
+func UDPWithDialer(s *stack.Stack, nat map[tcpip.Address]tcpip.Address, natLock *sync.Mutex, egress *egressDialer) *udp.Forwarder {
+	return udp.NewForwarder(s, func(r *udp.ForwarderRequest) {
+		localAddress := r.ID().LocalAddress
+
+		if linkLocalSubnet.Contains(localAddress) || localAddress == header.IPv4Broadcast {
+			return
+		}
+
+		natLock.Lock()
+		if replaced, ok := nat[localAddress]; ok {
+			localAddress = replaced
+		}
+		natLock.Unlock()
+
+		var wq waiter.Queue
+		ep, tcpErr := r.CreateEndpoint(&wq)
+		if tcpErr != nil {
+			if _, ok := tcpErr.(*tcpip.ErrConnectionRefused); ok {
+				// transient error
+				logrus.Debugf("r.CreateEndpoint() = %v", tcpErr)
+			} else {
+				logrus.Errorf("r.CreateEndpoint() = %v", tcpErr)
+			}
+			return
+		}
+
+		destAddr := fmt.Sprintf("%s:%d", localAddress, r.ID().LocalPort)
+		p, _ := forwarder.NewUDPProxy(&autoStoppingListener{underlying: gonet.NewUDPConn(&wq, ep)}, func() (net.Conn, error) {
+			return egress.DialContext(context.Background(), "udp", destAddr)
+		})
+		go func() {
+			p.Run()
+
+			// note that at this point packets that are sent to the current forwarder session
+			// will be dropped. We will start processing the packets again when we get a new
+			// forwarder request.
+			ep.Close()
+		}()
+	})
+}
+
+// autoStoppingListener ends the proxy once the guest side has been idle for
+// forwarder.UDPConnTrackTimeout (upstream's type is unexported).
+type autoStoppingListener struct {
+	underlying *gonet.UDPConn
+}
+
+func (l *autoStoppingListener) ReadFrom(b []byte) (int, net.Addr, error) {
+	_ = l.underlying.SetReadDeadline(time.Now().Add(forwarder.UDPConnTrackTimeout))
+	return l.underlying.ReadFrom(b)
+}
+
+func (l *autoStoppingListener) WriteTo(b []byte, addr net.Addr) (int, error) {
+	_ = l.underlying.SetReadDeadline(time.Now().Add(forwarder.UDPConnTrackTimeout))
+	return l.underlying.WriteTo(b, addr)
+}
+
+func (l *autoStoppingListener) SetReadDeadline(t time.Time) error {
+	return l.underlying.SetReadDeadline(t)
+}
+
+func (l *autoStoppingListener) Close() error {
+	return l.underlying.Close()
+}
@@ -0,0 +1,288 @@
+package main
+
+// forward_connections.go — The table of relayed connections, and pausing one.
+//
+// Forward stats are per mapping; debugging backpressure, or stopping one
+// runaway transfer without killing it, needs the individual connections.
+// Each connection a published port relays gets an ID in the forwarder's
+// table, listed by gvproxy_list_connections (and in a support bundle's
+// connections.json). gvproxy_pause_connection stops reading one side of a
+// connection: "host" stops reading from the host client, so the guest
+// receives nothing more; "guest" stops reading from the guest; "both" does
+// both. Nothing is dropped: the unread data stays in socket buffers and TCP
+// flow control pushes back on the sender, as a slow reader would.
+// gvproxy_resume_connection lets data flow again. A paused connection
+// still ends when either side closes it or the instance is destroyed.
+
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// Pause directions accepted by gvproxy_pause_connection.
+const (
+	pauseHost  = "host"
+	pauseGuest = "guest"
+	pauseBoth  = "both"
+)
+
+// ForwardConnection is one entry of the connection table.
+type ForwardConnection struct {
+	ID         uint64    `json:"id"`
+	Peer       string    `json:"peer"`
+	HostPort   uint16    `json:"host_port,omitempty"`
+	HostSocket string    `json:"host_socket,omitempty"`
+	GuestPort  uint16    `json:"guest_port"`
+	Guest      string    `json:"guest"`
+	StartedAt  time.Time `json:"started_at"`
+	Bytes      uint64    `json:"bytes"`            // relayed to and from the guest so far
+	Paused     string    `json:"paused,omitempty"` // "host", "guest" or "both"
+}
+
+// forwardRelay is a connection accepted on a published port and relayed to
+// the guest.
+type forwardRelay struct {
+	id      uint64
+	fwd     *portForward
+	host    net.Conn
+	guest   string
+	started time.Time
+	bytes   atomic.Uint64
+
+	fromHost  relayGate // closed: writes to the guest wait
+	fromGuest relayGate // closed: reads from the guest wait
+	done      chan struct{}
+	endOnce   sync.Once
+}
+
+func newForwardRelay(id uint64, fwd *portForward, host net.Conn, guest string) *forwardRelay {
+	return &forwardRelay{id: id, fwd: fwd, host: host, guest: guest, started: time.Now(), done: make(chan struct{})}
+}
+
+// end releases anything waiting on a paused gate.
+func (r *forwardRelay) end() {
+	r.endOnce.Do(func() { close(r.done) })
+}
+
+// paused reports the directions paused.
+func (r *forwardRelay) paused() string {
+	host, guest := r.fromHost.isPaused(), r.fromGuest.isPaused()
+	switch {
+	case host && guest:
+		return pauseBoth
+	case host:
+		return pauseHost
+	case guest:
+		return pauseGuest
+	}
+	return ""
+}
+
+func (r *forwardRelay) connection() ForwardConnection {
+	return ForwardConnection{
+		ID:         r.id,
+		Peer:       r.host.RemoteAddr().String(),
+		HostPort:   r.fwd.mapping.HostPort,
+		HostSocket: r.fwd.mapping.HostSocket,
+		GuestPort:  r.fwd.mapping.GuestPort,
+		Guest:      r.guest,
+		StartedAt:  r.started.UTC(),
+		Bytes:      r.bytes.Load(),
+		Paused:     r.paused(),
+	}
+}
+
+// relayGate holds up one direction of a relay while paused.
+type relayGate struct {
+	mu     sync.Mutex
+	resume chan struct{} // closed on resume; nil while flowing
+}
+
+func (g *relayGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume == nil {
+		g.resume = make(chan struct{})
+	}
+}
+
+func (g *relayGate) unpause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume != nil {
+		close(g.resume)
+		g.resume = nil
+	}
+}
+
+func (g *relayGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resume != nil
+}
+
+// wait returns once the gate is open or done is closed.
+func (g *relayGate) wait(done <-chan struct{}) {
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+	if resume == nil {
+		return
+	}
+	select {
+	case <-resume:
+	case <-done:
+	}
+}
+
+// pausableConn is the guest side of a relay, gated by the relay's pauses.
+type pausableConn struct {
+	net.Conn
+	relay *forwardRelay
+}
+
+func (c pausableConn) Read(p []byte) (int, error) {
+	c.relay.fromGuest.wait(c.relay.done)
+	return c.Conn.Read(p)
+}
+
+func (c pausableConn) Write(p []byte) (int, error) {
+	c.relay.fromHost.wait(c.relay.done)
+	return c.Conn.Write(p)
+}
+
+func (c pausableConn) Close() error {
+	c.relay.end()
+	return c.Conn.Close()
+}
+
+// Connections returns the connection table, by ID.
+func (f *PortForwarder) Connections() []ForwardConnection {
+	f.mu.Lock()
+	out := make([]ForwardConnection, 0, len(f.relays))
+	for _, r := range f.relays {
+		out = append(out, r.connection())
+	}
+	f.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// pauseConnection pauses (or with paused false, resumes) direction of the
+// connection connID.
+func (f *PortForwarder) pauseConnection(connID uint64, direction string, paused bool) error {
+	f.mu.Lock()
+	var relay *forwardRelay
+	for _, r := range f.relays {
+		if r.id == connID {
+			relay = r
+			break
+		}
+	}
+	f.mu.Unlock()
+	if relay == nil {
+		return fmt.Errorf("connection %d not found", connID)
+	}
+	var gates []*relayGate
+	switch direction {
+	case pauseHost:
+		gates = []*relayGate{&relay.fromHost}
+	case pauseGuest:
+		gates = []*relayGate{&relay.fromGuest}
+	case "", pauseBoth:
+		gates = []*relayGate{&relay.fromHost, &relay.fromGuest}
+	default:
+		return fmt.Errorf("unknown direction %q (want host, guest or both)", direction)
+	}
+	for _, g := range gates {
+		if paused {
+			g.pause()
+		} else {
+			g.unpause()
+		}
+	}
+	return nil
+}
+
+// setConnectionPaused pauses or resumes a connection of instance id.
+func setConnectionPaused(id int64, connID uint64, direction string, paused bool) error {
+	instancesMu.RLock()
+	instance, ok := instances[id]
+	instancesMu.RUnlock()
+	if !ok || instance.forwarder == nil {
+		return fmt.Errorf("instance %d not found", id)
+	}
+	if err := instance.forwarder.pauseConnection(connID, direction, paused); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{"id": id, "connection": connID, "direction": direction, "paused": paused}).Info("Forwarded connection flow changed")
+	return nil
+}
+
+// gvproxy_list_connections returns the connections the instance's published
+// ports are relaying: [{"id": 1, "peer": "127.0.0.1:50312", "host_port":
+// 8080, "guest_port": 80, "guest": "192.168.127.2:80", "started_at": ...,
+// "bytes": 4096, "paused": "host"}, ...].
+//
+// The table is returned whole; gvproxy_get_stats_page pages it as its
+// "Connections" section (stats_page.go).
+//
+// Returns NULL if the instance does not exist. The string must be freed
+// with gvproxy_free_string.
+//
+//export gvproxy_list_connections
+func gvproxy_list_connections(id C.longlong) *C.char {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok || instance.forwarder == nil {
+		return nil
+	}
+	out, err := json.Marshal(instance.forwarder.Connections())
+	if err != nil {
+		return nil
+	}
+	return returnCString(string(out))
+}
+
+// gvproxy_pause_connection stops reading one side of a relayed connection:
+// direction "host" (the guest receives nothing more), "guest" (the host
+// client receives nothing more), or "both" (also NULL or "").
+//
+// Returns 0 on success (also when already paused), -1 on failure (logged):
+// unknown instance or connection, or an invalid direction.
+//
+//export gvproxy_pause_connection
+func gvproxy_pause_connection(id C.longlong, connID C.longlong, direction *C.char) C.int {
+	return changeConnectionFlow(id, connID, direction, true)
+}
+
+// gvproxy_resume_connection resumes direction ("host", "guest", or "both",
+// also NULL or "") of a connection paused with gvproxy_pause_connection.
+//
+// Returns 0 on success (also when not paused), -1 on failure (logged).
+//
+//export gvproxy_resume_connection
+func gvproxy_resume_connection(id C.longlong, connID C.longlong, direction *C.char) C.int {
+	return changeConnectionFlow(id, connID, direction, false)
+}
+
+func changeConnectionFlow(id C.longlong, connID C.longlong, direction *C.char, paused bool) C.int {
+	dir := ""
+	if direction != nil {
+		dir = C.GoString(direction)
+	}
+	if err := setConnectionPaused(int64(id), uint64(connID), dir, paused); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id), "connection": int64(connID), "direction": dir, "paused": paused}).Error("Failed to change forwarded connection flow")
+		return -1
+	}
+	return 0
+}
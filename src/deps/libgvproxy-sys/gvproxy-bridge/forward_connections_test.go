@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPortForwarder_PausesAndResumesConnection(t *testing.T) {
+	f, err := NewPortForwarder(1, "192.168.127.2", []PortMapping{{HostPort: 0, GuestPort: 80}}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Serve(ctx, &echoDialer{})
+
+	conn, err := net.Dial("tcp", f.forwards[0].listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial forward: %v", err)
+	}
+	defer conn.Close()
+	echo := func(msg string) error {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return err
+		}
+		buf := make([]byte, len(msg))
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond)) //nolint:errcheck
+		_, err := io.ReadFull(conn, buf)
+		return err
+	}
+	if err := echo("ping"); err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+
+	conns := f.Connections()
+	if len(conns) != 1 || conns[0].Guest != "192.168.127.2:80" || conns[0].Bytes != 8 || conns[0].Paused != "" {
+		t.Fatalf("connections = %+v, want one unpaused relay of 8 bytes", conns)
+	}
+	id := conns[0].ID
+
+	if err := f.pauseConnection(id, pauseHost, true); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	if got := f.Connections()[0].Paused; got != pauseHost {
+		t.Fatalf("paused = %q, want host", got)
+	}
+	var netErr net.Error
+	if err := echo("held"); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("echo while paused: err = %v, want a timeout", err)
+	}
+	if err := f.pauseConnection(id, pauseBoth, false); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	buf := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "held" {
+		t.Fatalf("after resume: read %q, %v", buf, err)
+	}
+
+	if err := f.pauseConnection(id, "sideways", true); err == nil {
+		t.Error("expected an invalid direction to be rejected")
+	}
+	if err := f.pauseConnection(id+1, pauseBoth, true); err == nil {
+		t.Error("expected an unknown connection to be rejected")
+	}
+
+	// A paused connection still ends when the client closes it.
+	if err := f.pauseConnection(id, pauseBoth, true); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.Connections()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("paused relay did not end: %+v", f.Connections())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
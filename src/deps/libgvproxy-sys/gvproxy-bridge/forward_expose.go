@@ -0,0 +1,222 @@
+package main
+
+// forward_expose.go — Publish and unpublish ports on a running instance.
+//
+// Containers in the guest start and stop while the network stays up, so
+// port_mappings from the create config cannot be the whole story.
+// gvproxy_expose binds a new host port and relays it like a configured
+// mapping (inbound events, stats, flow bounds, lease retargeting);
+// gvproxy_unexpose closes its listener. Relays already in flight on an
+// unpublished port finish on their own. Published mappings count against
+// max_total_forwards and are carried over by a hot upgrade.
+//
+// With "dry_run" set, gvproxy_expose runs every check (instance, target,
+// reserved ports, max_total_forwards, publication conflicts, and whether the
+// host port can be bound) and reports the result as usual, but publishes
+// nothing. See dry_run.go for gvproxy_create.
+
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// Expose binds pm's host port and starts relaying it once the forwarder is
+// serving. The host port must be explicit and not already published.
+func (f *PortForwarder) Expose(pm PortMapping) error {
+	return f.expose(pm, false)
+}
+
+// CheckExpose runs Expose's checks without publishing anything. The host
+// port is bound and released at once to prove it is free.
+func (f *PortForwarder) CheckExpose(pm PortMapping) error {
+	return f.expose(pm, true)
+}
+
+func (f *PortForwarder) expose(pm PortMapping, dryRun bool) error {
+	if (pm.HostPort == 0 && pm.HostSocket == "" && pm.HostVsock == nil) || (pm.GuestPort == 0 && pm.GatewayPort == 0) {
+		return fmt.Errorf("host_port (or host_socket or host_vsock) and guest_port (or gateway_port) are required")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return fmt.Errorf("port forwarder is closed")
+	}
+	for _, fwd := range f.forwards {
+		if fwd.hostAddr == pm.hostAddr() {
+			return fmt.Errorf("host address %s is already published", pm.hostAddr())
+		}
+	}
+	l, err := listenForward(pm)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return l.Close()
+	}
+	fwd := f.newForward(pm, l)
+	if f.serveCtx != nil {
+		if err := f.bindGateway(fwd, f.dialer); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	f.forwards = append(f.forwards, fwd)
+	if f.serveCtx != nil {
+		ctx, dialer := f.serveCtx, f.dialer
+		f.goroutines.spawn(func() { f.acceptLoop(ctx, fwd, dialer) })
+	}
+	return nil
+}
+
+// Unexpose closes the listener of the forward on pm's host address.
+func (f *PortForwarder) Unexpose(pm PortMapping) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, fwd := range f.forwards {
+		if fwd.hostAddr != pm.hostAddr() {
+			continue
+		}
+		fwd.removed = true
+		fwd.listener.Close()
+		f.forwards = append(f.forwards[:i], f.forwards[i+1:]...)
+		logrus.WithFields(logrus.Fields{"host": fwd.hostAddr, "guest": fwd.guestAddr}).Info("Removed TCP port forward")
+		return nil
+	}
+	return fmt.Errorf("host address %s is not published", pm.hostAddr())
+}
+
+// Mappings returns the currently published mappings.
+func (f *PortForwarder) Mappings() []PortMapping {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]PortMapping, len(f.forwards))
+	for i, fwd := range f.forwards {
+		out[i] = fwd.mapping
+	}
+	return out
+}
+
+// portMappings returns the instance's published mappings: the configured
+// ones plus or minus those changed at run time.
+func (instance *GvproxyInstance) portMappings() []PortMapping {
+	if instance.forwarder == nil {
+		return instance.config.PortMappings
+	}
+	return instance.forwarder.Mappings()
+}
+
+// exposeRequest is the gvproxy_expose argument: a port mapping, optionally
+// only checked.
+type exposeRequest struct {
+	PortMapping
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// exposePort publishes pm on instance id, within max_total_forwards. With
+// dryRun it only reports whether that would succeed.
+func exposePort(id int64, pm PortMapping, dryRun bool) error {
+	instancesMu.RLock()
+	instance, ok := instances[id]
+	instancesMu.RUnlock()
+	if !ok || instance.forwarder == nil {
+		return fmt.Errorf("instance %d not found", id)
+	}
+	if pm.GuestIP != "" && !guestAddresses(instance.config)[pm.GuestIP] {
+		return fmt.Errorf("guest_ip %s is not guest_ip, a guest alias or one of guests", pm.GuestIP)
+	}
+	if err := checkForwardType(pm); err != nil {
+		return err
+	}
+	if err := checkForwardHostIP(pm); err != nil {
+		return err
+	}
+	if err := checkForwardHostSocket(pm); err != nil {
+		return err
+	}
+	if err := checkForwardHostVsock(pm); err != nil {
+		return err
+	}
+	if err := instance.reserved.check(pm.HostPort); err != nil {
+		return err
+	}
+
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	if err := admitForward(); err != nil {
+		return err
+	}
+	if dryRun {
+		return instance.forwarder.CheckExpose(pm)
+	}
+	if err := instance.forwarder.Expose(pm); err != nil {
+		return err
+	}
+	instance.generation.bump(id, "expose", map[string]any{"host": pm.hostAddr()})
+	return nil
+}
+
+// unexposePort unpublishes pm's host address on instance id.
+func unexposePort(id int64, pm PortMapping) error {
+	instancesMu.RLock()
+	instance, ok := instances[id]
+	instancesMu.RUnlock()
+	if !ok || instance.forwarder == nil {
+		return fmt.Errorf("instance %d not found", id)
+	}
+	if err := instance.forwarder.Unexpose(pm); err != nil {
+		return err
+	}
+	instance.generation.bump(id, "unexpose", map[string]any{"host": pm.hostAddr()})
+	return nil
+}
+
+// gvproxy_expose publishes a port on a running instance. mappingJSON is a
+// port mapping as in port_mappings: {"host_port": 8080, "guest_port": 80,
+// "guest_ip": ..., "host_ip": ..., "type": ...}; host_port must be explicit,
+// or host_socket or host_vsock (forward_vsock.go) given instead. With
+// "dry_run": true the mapping is only checked.
+//
+// Returns 0 on success, -1 on failure (logged): unknown instance, invalid
+// JSON, reserved host port, port already published or in use, or
+// max_total_forwards reached.
+//
+//export gvproxy_expose
+func gvproxy_expose(id C.longlong, mappingJSON *C.char) C.int {
+	var req exposeRequest
+	if err := json.Unmarshal([]byte(C.GoString(mappingJSON)), &req); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id)}).Error("Invalid port mapping JSON")
+		return -1
+	}
+	if err := exposePort(int64(id), req.PortMapping, req.DryRun); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id), "host": req.hostAddr(), "dry_run": req.DryRun}).Error("Failed to publish port")
+		return -1
+	}
+	return 0
+}
+
+// gvproxy_unexpose unpublishes a port of a running instance. mappingJSON
+// names it by host port and, if it was published with one, host_ip:
+// {"host_port": 8080} or {"host_ip": "127.0.0.1", "host_port": 8080}; or
+// by host_socket: {"host_socket": "/run/app.sock"}; or by host_vsock or
+// gateway_port: {"host_vsock": {"port": 1024}}, {"gateway_port": 2375}
+// (other fields are ignored).
+//
+// Returns 0 on success, -1 on failure (logged): unknown instance, invalid
+// JSON, or the port is not published.
+//
+//export gvproxy_unexpose
+func gvproxy_unexpose(id C.longlong, mappingJSON *C.char) C.int {
+	var pm PortMapping
+	if err := json.Unmarshal([]byte(C.GoString(mappingJSON)), &pm); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id)}).Error("Invalid port mapping JSON")
+		return -1
+	}
+	if err := unexposePort(int64(id), pm); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id), "host": pm.hostAddr()}).Error("Failed to unpublish port")
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func freeTCPPort(t *testing.T) uint16 {
+	t.Helper()
+	l, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestPortForwarder_ExposeAndUnexpose(t *testing.T) {
+	defer func(interval time.Duration) { portRebindInterval = interval }(portRebindInterval)
+	portRebindInterval = 20 * time.Millisecond
+
+	f, err := NewPortForwarder(1, "192.168.127.2", nil, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dialer := &echoDialer{dialed: make(chan string, 1)}
+	f.Serve(ctx, dialer)
+
+	port := freeTCPPort(t)
+	if err := f.Expose(PortMapping{HostPort: port, GuestPort: 80}); err != nil {
+		t.Fatalf("Expose: %v", err)
+	}
+	if err := f.Expose(PortMapping{HostPort: port, GuestPort: 81}); err == nil {
+		t.Fatal("expected error exposing an already published port")
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial exposed port: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	conn.Close()
+	if got := <-dialer.dialed; got != "192.168.127.2:80" {
+		t.Fatalf("guest dial = %q, want 192.168.127.2:80", got)
+	}
+	if m := f.Mappings(); len(m) != 1 || m[0].HostPort != port {
+		t.Fatalf("mappings = %+v, want the exposed port", m)
+	}
+
+	if err := f.Unexpose(PortMapping{HostPort: port}); err != nil {
+		t.Fatalf("Unexpose: %v", err)
+	}
+	if err := f.Unexpose(PortMapping{HostPort: port}); err == nil {
+		t.Fatal("expected error unexposing an unpublished port")
+	}
+	// The port must stay released rather than be reclaimed.
+	time.Sleep(5 * portRebindInterval)
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Fatal("unexposed port still accepts connections")
+	}
+	if m := f.Mappings(); len(m) != 0 {
+		t.Fatalf("mappings = %+v, want none", m)
+	}
+}
+
+func TestExposePort_ChecksLimitsAndTargets(t *testing.T) {
+	config := testGvproxyConfig()
+	f, err := NewPortForwarder(5500, config.GuestIP, nil, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	addFakeInstance(t, 5500, config)
+	instancesMu.Lock()
+	instances[5500].forwarder = f
+	instances[5500].reserved = newReservedPorts(nil)
+	instancesMu.Unlock()
+
+	if err := exposePort(5500, PortMapping{HostPort: freeTCPPort(t), GuestPort: 80, GuestIP: "192.168.127.99"}, false); err == nil {
+		t.Fatal("expected error for a guest_ip that is not a guest address")
+	}
+
+	if err := exposePort(5500, PortMapping{HostPort: 22, GuestPort: 22}, false); !errors.Is(err, ErrReservedHostPort) {
+		t.Fatalf("err = %v, want ErrReservedHostPort", err)
+	}
+
+	withLimits(t, Limits{MaxTotalForwards: runningForwards() + 1})
+	if err := exposePort(5500, PortMapping{HostPort: freeTCPPort(t), GuestPort: 80}, false); err != nil {
+		t.Fatalf("exposePort within the limit: %v", err)
+	}
+	var limitErr *limitError
+	if err := exposePort(5500, PortMapping{HostPort: freeTCPPort(t), GuestPort: 81}, false); !errors.As(err, &limitErr) || limitErr.Limit != "max_total_forwards" {
+		t.Fatalf("err = %v, want max_total_forwards limit", err)
+	}
+
+	if err := unexposePort(5500, f.Mappings()[0]); err != nil {
+		t.Fatalf("unexposePort: %v", err)
+	}
+	if err := unexposePort(5599, PortMapping{HostPort: 80}); err == nil {
+		t.Fatal("expected error for an unknown instance")
+	}
+}
+
+func TestExposePort_DryRunPublishesNothing(t *testing.T) {
+	config := testGvproxyConfig()
+	f, err := NewPortForwarder(5501, config.GuestIP, nil, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	addFakeInstance(t, 5501, config)
+	instancesMu.Lock()
+	instances[5501].forwarder = f
+	instancesMu.Unlock()
+
+	port := freeTCPPort(t)
+	if err := exposePort(5501, PortMapping{HostPort: port, GuestPort: 80}, true); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if m := f.Mappings(); len(m) != 0 {
+		t.Fatalf("dry run published %+v", m)
+	}
+	if err := exposePort(5501, PortMapping{HostPort: port, GuestPort: 80}, false); err != nil {
+		t.Fatalf("expose after dry run: %v", err)
+	}
+	if err := exposePort(5501, PortMapping{HostPort: port, GuestPort: 81}, true); err == nil || !strings.Contains(err.Error(), "already published") {
+		t.Fatalf("dry run of a published port: err = %v, want already published", err)
+	}
+}
+
+func TestPortForwarder_HostIP(t *testing.T) {
+	f, err := NewPortForwarder(1, "192.168.127.2", nil, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+
+	port := freeTCPPort(t)
+	loopback := PortMapping{HostIP: "127.0.0.1", HostPort: port, GuestPort: 80}
+	if err := f.Expose(loopback); err != nil {
+		t.Fatalf("Expose: %v", err)
+	}
+	if got := f.forwards[0].listener.Addr().String(); got != fmt.Sprintf("127.0.0.1:%d", port) {
+		t.Fatalf("listener bound %s, want 127.0.0.1:%d", got, port)
+	}
+	// The same port on another address is a separate forward.
+	other := PortMapping{HostIP: "127.0.0.2", HostPort: port, GuestPort: 81}
+	if err := f.Expose(other); err != nil {
+		t.Skipf("127.0.0.2 not bindable here: %v", err)
+	}
+	if s := f.Stats(); len(s) != 2 || s[0].HostIP != "127.0.0.1" || s[1].HostIP != "127.0.0.2" {
+		t.Fatalf("stats = %+v, want both addresses", s)
+	}
+
+	if err := f.Unexpose(PortMapping{HostPort: port}); err == nil {
+		t.Fatal("expected error unexposing the port on 0.0.0.0")
+	}
+	if err := f.Unexpose(loopback); err != nil {
+		t.Fatalf("Unexpose: %v", err)
+	}
+	if m := f.Mappings(); len(m) != 1 || m[0].HostIP != "127.0.0.2" {
+		t.Fatalf("mappings = %+v, want the 127.0.0.2 forward", m)
+	}
+}
+
+func TestCheckForwardHostIP(t *testing.T) {
+	for _, ip := range []string{"", "127.0.0.1", "::1", "::"} {
+		if err := checkForwardHostIP(PortMapping{HostIP: ip, HostPort: 8080, GuestPort: 80}); err != nil {
+			t.Errorf("host_ip %q: %v", ip, err)
+		}
+	}
+	if err := checkForwardHostIP(PortMapping{HostIP: "localhost", HostPort: 8080, GuestPort: 80}); err == nil {
+		t.Error("expected error for a host name")
+	}
+}
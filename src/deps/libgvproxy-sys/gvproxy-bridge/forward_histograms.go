@@ -0,0 +1,200 @@
+package main
+
+// forward_histograms.go — Latency and throughput distributions per published
+// port.
+//
+// Counters only give averages; a published service whose slowest 1% of
+// connections stall looks healthy in them. Each forward therefore keeps two
+// histograms:
+//
+//   - dial latency: how long reaching the guest took, per connection that
+//     reached it (failed dials are in ForwardErrorStats instead);
+//   - throughput: bytes relayed in both directions over the connection's
+//     lifetime, per relayed connection.
+//
+// Bucket bounds default to defaultLatencyBucketsMs and
+// defaultThroughputBuckets and can be set per instance with
+// forward_histogram_buckets.
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ForwardHistogramBuckets sets the upper bounds of the per-forward histogram
+// buckets. Bounds must be positive and strictly increasing; an +Inf bucket is
+// always added. Empty keeps the default for that histogram.
+type ForwardHistogramBuckets struct {
+	LatencyMs             []float64 `json:"latency_ms,omitempty"`
+	ThroughputBytesPerSec []float64 `json:"throughput_bytes_per_sec,omitempty"`
+}
+
+var (
+	defaultLatencyBucketsMs  = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+	defaultThroughputBuckets = []float64{1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9}
+)
+
+// resolve returns the latency and throughput bounds, defaults filled in.
+func (b *ForwardHistogramBuckets) resolve() (latencyMs, throughput []float64, err error) {
+	latencyMs, throughput = defaultLatencyBucketsMs, defaultThroughputBuckets
+	if b == nil {
+		return latencyMs, throughput, nil
+	}
+	if len(b.LatencyMs) > 0 {
+		if err := checkBucketBounds(b.LatencyMs); err != nil {
+			return nil, nil, fmt.Errorf("forward_histogram_buckets.latency_ms: %w", err)
+		}
+		latencyMs = b.LatencyMs
+	}
+	if len(b.ThroughputBytesPerSec) > 0 {
+		if err := checkBucketBounds(b.ThroughputBytesPerSec); err != nil {
+			return nil, nil, fmt.Errorf("forward_histogram_buckets.throughput_bytes_per_sec: %w", err)
+		}
+		throughput = b.ThroughputBytesPerSec
+	}
+	return latencyMs, throughput, nil
+}
+
+func checkBucketBounds(bounds []float64) error {
+	for i, b := range bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) || b <= 0 {
+			return fmt.Errorf("bound %v is not a positive number", b)
+		}
+		if i > 0 && b <= bounds[i-1] {
+			return fmt.Errorf("bounds are not strictly increasing at %v", b)
+		}
+	}
+	return nil
+}
+
+// histogram counts observations into fixed buckets.
+type histogram struct {
+	bounds []float64 // upper bounds, excluding +Inf
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: append([]float64(nil), bounds...),
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// HistogramStats is a histogram in stats: cumulative bucket counts, as in
+// Prometheus, plus the sum and count of all observations.
+type HistogramStats struct {
+	Buckets []HistogramBucket `json:"Buckets"`
+	Sum     float64           `json:"Sum"`
+	Count   uint64            `json:"Count"`
+}
+
+// HistogramBucket counts the observations less than or equal to Le. The last
+// bucket has Le "+Inf".
+type HistogramBucket struct {
+	Le    string `json:"Le"`
+	Count uint64 `json:"Count"`
+}
+
+func (h *histogram) snapshot() HistogramStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := HistogramStats{Buckets: make([]HistogramBucket, len(h.counts)), Sum: h.sum, Count: h.count}
+	var cumulative uint64
+	for i, n := range h.counts {
+		cumulative += n
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+		out.Buckets[i] = HistogramBucket{Le: le, Count: cumulative}
+	}
+	return out
+}
+
+// forwardHistograms are a forward's distributions.
+type forwardHistograms struct {
+	latencyMs  *histogram
+	throughput *histogram
+}
+
+func newForwardHistograms(latencyMs, throughput []float64) forwardHistograms {
+	return forwardHistograms{latencyMs: newHistogram(latencyMs), throughput: newHistogram(throughput)}
+}
+
+// setHistogramBuckets replaces every forward's histograms with empty ones
+// using the given bounds (as returned by resolve), which forwards added
+// later use too. Call before Serve.
+func (f *PortForwarder) setHistogramBuckets(latencyMs, throughput []float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latencyBuckets, f.throughputBuckets = latencyMs, throughput
+	for _, fwd := range f.forwards {
+		fwd.histograms = newForwardHistograms(latencyMs, throughput)
+	}
+}
+
+// countingConn counts the bytes read from and written to a relayed conn.
+type countingConn struct {
+	net.Conn
+	bytes *atomic.Uint64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.bytes.Add(uint64(n))
+	return n, err
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.bytes.Add(uint64(n))
+	return n, err
+}
+
+// observeThroughput records a finished relay that moved n bytes since start.
+func (h forwardHistograms) observeThroughput(n uint64, start time.Time) {
+	elapsed := time.Since(start).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	h.throughput.observe(float64(n) / elapsed)
+}
+
+// collectHistogramOpenMetrics emits one forward histogram as an OpenMetrics
+// histogram family. scale converts observations to the family's unit.
+func collectHistogramOpenMetrics(emit metricEmitter, family, help, labels string, h HistogramStats, scale float64) {
+	for _, b := range h.Buckets {
+		le := b.Le
+		if le != "+Inf" {
+			bound, err := strconv.ParseFloat(le, 64)
+			if err != nil {
+				continue
+			}
+			le = formatFloat(bound * scale)
+		}
+		emit(family+"_bucket", "histogram", help, labels+`,le="`+le+`"`, fmt.Sprint(b.Count))
+	}
+	emit(family+"_count", "histogram", help, labels, fmt.Sprint(h.Count))
+	emit(family+"_sum", "histogram", help, labels, formatFloat(h.Sum*scale))
+}
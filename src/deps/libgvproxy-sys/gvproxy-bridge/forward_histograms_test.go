@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogram_CumulativeBuckets(t *testing.T) {
+	h := newHistogram([]float64{1, 10})
+	for _, v := range []float64{0.5, 1, 5, 50} {
+		h.observe(v)
+	}
+	got := h.snapshot()
+	want := []HistogramBucket{{Le: "1", Count: 2}, {Le: "10", Count: 3}, {Le: "+Inf", Count: 4}}
+	if len(got.Buckets) != len(want) {
+		t.Fatalf("buckets = %+v, want %+v", got.Buckets, want)
+	}
+	for i := range want {
+		if got.Buckets[i] != want[i] {
+			t.Fatalf("buckets = %+v, want %+v", got.Buckets, want)
+		}
+	}
+	if got.Count != 4 || got.Sum != 56.5 {
+		t.Fatalf("count/sum = %d/%v, want 4/56.5", got.Count, got.Sum)
+	}
+}
+
+func TestForwardHistogramBuckets_Resolve(t *testing.T) {
+	latency, throughput, err := (*ForwardHistogramBuckets)(nil).resolve()
+	if err != nil || len(latency) != len(defaultLatencyBucketsMs) || len(throughput) != len(defaultThroughputBuckets) {
+		t.Fatalf("nil buckets: %v %v %v, want defaults", latency, throughput, err)
+	}
+
+	latency, throughput, err = (&ForwardHistogramBuckets{LatencyMs: []float64{2, 20}}).resolve()
+	if err != nil || len(latency) != 2 || len(throughput) != len(defaultThroughputBuckets) {
+		t.Fatalf("latency override: %v %v %v", latency, throughput, err)
+	}
+
+	for _, bad := range []ForwardHistogramBuckets{
+		{LatencyMs: []float64{5, 5}},
+		{LatencyMs: []float64{10, 1}},
+		{ThroughputBytesPerSec: []float64{0}},
+		{ThroughputBytesPerSec: []float64{-1, 1}},
+	} {
+		if _, _, err := bad.resolve(); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+}
+
+func TestPortForwarder_RecordsLatencyAndThroughput(t *testing.T) {
+	f, err := NewPortForwarder(1, "192.168.127.2", []PortMapping{{HostPort: 0, GuestPort: 80}}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	f.setHistogramBuckets([]float64{1000}, []float64{1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Serve(ctx, &echoDialer{})
+
+	conn, err := net.Dial("tcp", f.forwards[0].listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial forward: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := f.Stats()[0]
+		if stats.ThroughputBytesPerSec.Count == 1 {
+			if stats.LatencyMs.Count != 1 || stats.LatencyMs.Buckets[0].Le != "1000" {
+				t.Fatalf("latency = %+v, want one observation in custom buckets", stats.LatencyMs)
+			}
+			if stats.ThroughputBytesPerSec.Sum <= 0 {
+				t.Fatalf("throughput = %+v, want bytes relayed", stats.ThroughputBytesPerSec)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("throughput never recorded: %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestForwardsCollector_OpenMetricsHistograms(t *testing.T) {
+	f, err := NewPortForwarder(1, "192.168.127.2", []PortMapping{{HostPort: 0, GuestPort: 80}}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	f.setHistogramBuckets([]float64{5, 50}, []float64{1000})
+	f.forwards[0].histograms.latencyMs.observe(20)
+
+	out := renderOpenMetrics(7, map[string]any{}, []StatsCollector{forwardsCollector{f}})
+	port := f.Stats()[0].HostPort
+	labels := fmt.Sprintf(`instance="7",host_port="%d",guest_port="80"`, port)
+	want := strings.Join([]string{
+		"# TYPE gvproxy_forward_dial_latency_seconds histogram",
+		"# HELP gvproxy_forward_dial_latency_seconds Time to reach the guest for a connection on a published host port.",
+		"gvproxy_forward_dial_latency_seconds_bucket{" + labels + `,le="0.005"} 0`,
+		"gvproxy_forward_dial_latency_seconds_bucket{" + labels + `,le="0.05"} 1`,
+		"gvproxy_forward_dial_latency_seconds_bucket{" + labels + `,le="+Inf"} 1`,
+		"gvproxy_forward_dial_latency_seconds_count{" + labels + "} 1",
+		"gvproxy_forward_dial_latency_seconds_sum{" + labels + "} 0.02",
+	}, "\n")
+	if !strings.Contains(out, want) {
+		t.Fatalf("missing latency histogram:\n%s\nin:\n%s", want, out)
+	}
+}
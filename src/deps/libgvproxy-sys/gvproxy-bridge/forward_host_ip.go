@@ -0,0 +1,75 @@
+package main
+
+// forward_host_ip.go — Publish a port on one host address.
+//
+// A forward used to bind 0.0.0.0:<host_port>, exposing the guest service on
+// every host interface. host_ip binds it to one address instead, like
+// docker's -p ip:host_port:guest_port: 127.0.0.1 keeps it on loopback, an
+// interface address on that interface, and an IPv6 address ("::1", or "::"
+// for every IPv6 and, where the host allows it, IPv4 interface) on IPv6.
+// The same host port may be published on several addresses, by different
+// mappings; gvproxy_unexpose then names the address too. Reserved host
+// ports stay reserved on every address.
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// defaultForwardHostIP is the address a mapping without host_ip binds.
+const defaultForwardHostIP = "0.0.0.0"
+
+// hostAddr is the host address pm binds, its host_socket
+// (forward_unix_socket.go) or its vsock address (forward_vsock.go).
+func (pm PortMapping) hostAddr() string {
+	if pm.HostSocket != "" {
+		return pm.HostSocket
+	}
+	if addr, ok := pm.vsockAddr(); ok {
+		return addr
+	}
+	ip := pm.HostIP
+	if ip == "" {
+		ip = defaultForwardHostIP
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(int(pm.HostPort)))
+}
+
+// checkForwardHostIP validates pm's host_ip.
+func checkForwardHostIP(pm PortMapping) error {
+	if pm.HostIP != "" && net.ParseIP(pm.HostIP) == nil {
+		return fmt.Errorf("port mapping %d -> %d: host_ip %q is not an IP address", pm.HostPort, pm.GuestPort, pm.HostIP)
+	}
+	return nil
+}
+
+// checkForwardHostIPs validates the host_ip of every port mapping.
+func checkForwardHostIPs(config GvproxyConfig) error {
+	for _, pm := range config.PortMappings {
+		if err := checkForwardHostIP(pm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forwardLabels are the OpenMetrics labels of a forward; host_ip only
+// appears for forwards bound to one address, host_socket for those on a
+// Unix socket, host_vsock and gateway_port for vsock ones.
+func forwardLabels(fwd ForwardStats) string {
+	labels := fmt.Sprintf(`host_port="%d",guest_port="%d"`, fwd.HostPort, fwd.GuestPort)
+	if fwd.HostIP != "" {
+		labels = fmt.Sprintf(`host_ip="%s",`, fwd.HostIP) + labels
+	}
+	if fwd.HostSocket != "" {
+		labels = fmt.Sprintf(`host_socket="%s",`, escapeLabelValue(fwd.HostSocket)) + labels
+	}
+	if fwd.HostVsock != "" {
+		labels = fmt.Sprintf(`host_vsock="%s",`, fwd.HostVsock) + labels
+	}
+	if fwd.GatewayPort != 0 {
+		labels += fmt.Sprintf(`,gateway_port="%d"`, fwd.GatewayPort)
+	}
+	return labels
+}
@@ -0,0 +1,99 @@
+package main
+
+// forward_retarget.go — Keep published ports pointed at the guest's lease.
+//
+// Forwards target the configured guest_ip. If the guest ends up with another
+// DHCP address (several guests on one network, a MAC that does not match
+// its static lease, lease churn), host clients are relayed to an address
+// nobody answers on. With forward_lease_mac set, the bridge watches the
+// gateway's lease table and moves every forward without an explicit
+// guest_ip to the address leased to that MAC. New connections use the new
+// target; established relays are left alone.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventForwardRetargeted fires when forwards follow the lease of
+// forward_lease_mac to a new guest address. Fields: mac, from, to.
+const EventForwardRetargeted = "forward_retargeted"
+
+// leasePollInterval is how often the lease table is checked.
+var leasePollInterval = 2 * time.Second
+
+// vnLeases returns the gateway's DHCP leases (IP → MAC) through the official
+// /leases handler.
+func vnLeases(vn *virtualnetwork.VirtualNetwork) map[string]string {
+	rec := httptest.NewRecorder()
+	vn.ServicesMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leases", nil))
+	var leases map[string]string
+	_ = json.Unmarshal(rec.Body.Bytes(), &leases)
+	return leases
+}
+
+// leasedIP returns the address leased to mac, preferring current when mac
+// holds several. It returns "" if mac holds no lease.
+func leasedIP(leases map[string]string, mac, current string) string {
+	var ips []string
+	for ip, owner := range leases {
+		if strings.EqualFold(owner, mac) {
+			if ip == current {
+				return current
+			}
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return ""
+	}
+	sort.Strings(ips)
+	return ips[0]
+}
+
+// followLease retargets forwards to the address leased to mac until ctx is
+// done, starting from guestIP.
+func (f *PortForwarder) followLease(ctx context.Context, mac, guestIP string, leases func() map[string]string) {
+	ticker := time.NewTicker(leasePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ip := leasedIP(leases(), mac, guestIP)
+		if ip == "" || ip == guestIP {
+			continue
+		}
+		f.retarget(ip)
+		logrus.WithFields(logrus.Fields{"id": f.instanceID, "mac": mac, "from": guestIP, "to": ip}).Info("Guest lease moved; forwards retargeted")
+		emitEvent(f.instanceID, EventForwardRetargeted, map[string]any{
+			"mac":  mac,
+			"from": guestIP,
+			"to":   ip,
+		})
+		guestIP = ip
+	}
+}
+
+// retarget points every forward without an explicit guest_ip at guestIP.
+func (f *PortForwarder) retarget(guestIP string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.guestIP = guestIP
+	for _, fwd := range f.forwards {
+		if fwd.mapping.GuestIP == "" && fwd.mapping.GatewayPort == 0 {
+			fwd.guestAddr = fmt.Sprintf("%s:%d", guestIP, fwd.mapping.GuestPort)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLeasedIP(t *testing.T) {
+	leases := map[string]string{
+		"192.168.127.2": "5a:94:ef:e4:0c:ee",
+		"192.168.127.7": "5A:94:EF:E4:0C:EE",
+		"192.168.127.3": "5a:94:ef:e4:0c:ef",
+	}
+	if got := leasedIP(leases, "5a:94:ef:e4:0c:ee", "192.168.127.7"); got != "192.168.127.7" {
+		t.Fatalf("expected the current address to be kept, got %q", got)
+	}
+	if got := leasedIP(leases, "5a:94:ef:e4:0c:ee", "192.168.127.9"); got != "192.168.127.2" {
+		t.Fatalf("expected the lowest leased address, got %q", got)
+	}
+	if got := leasedIP(leases, "5a:94:ef:e4:0c:ff", "192.168.127.2"); got != "" {
+		t.Fatalf("expected no address for an unknown MAC, got %q", got)
+	}
+}
+
+func TestPortForwarder_FollowsLease(t *testing.T) {
+	defer func(interval time.Duration) { leasePollInterval = interval }(leasePollInterval)
+	leasePollInterval = 10 * time.Millisecond
+
+	const id = 4800
+	const mac = "5a:94:ef:e4:0c:ee"
+	f, err := NewPortForwarder(id, "192.168.127.2", []PortMapping{
+		{HostPort: 0, GuestPort: 80},
+		{HostPort: 0, GuestPort: 22, GuestIP: "192.168.127.10"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+
+	var mu sync.Mutex
+	leases := map[string]string{"192.168.127.2": mac}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.followLease(ctx, mac, "192.168.127.2", func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return leases
+	})
+
+	mu.Lock()
+	leases = map[string]string{"192.168.127.5": mac}
+	mu.Unlock()
+
+	e := waitForEvent(t, id, EventForwardRetargeted)
+	if e.Fields["from"] != "192.168.127.2" || e.Fields["to"] != "192.168.127.5" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if got := f.forwards[0].guestAddr; got != "192.168.127.5:80" {
+		t.Fatalf("expected the forward to follow the lease, got %q", got)
+	}
+	if got := f.forwards[1].guestAddr; got != "192.168.127.10:22" {
+		t.Fatalf("a mapping with guest_ip must stay pinned, got %q", got)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+// forward_unix_socket.go — Publish a guest port on a host Unix socket.
+//
+// A port mapping with host_socket listens on that Unix socket path instead
+// of a host TCP port and relays each connection to guest_port like any
+// forward, so host tooling can reach a guest service without taking a host
+// port or exposing it on the network. host_port and host_ip must be unset,
+// and reserved host ports do not apply. A stale socket file left behind by
+// a crash is replaced; one something still accepts on fails the bind like a
+// taken port. The file is removed when the forward is unpublished or the
+// instance destroyed, unless its listener was handed over
+// (listener_handoff.go). gvproxy_unexpose names it by host_socket.
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// staleSocketDialTimeout bounds the probe for a live listener on a
+// host_socket left in place.
+const staleSocketDialTimeout = 100 * time.Millisecond
+
+// checkForwardHostSocket validates pm's host_socket.
+func checkForwardHostSocket(pm PortMapping) error {
+	if pm.HostSocket == "" {
+		return nil
+	}
+	if pm.HostPort != 0 || pm.HostIP != "" {
+		return fmt.Errorf("port mapping %s -> %d: host_socket excludes host_port and host_ip", pm.HostSocket, pm.GuestPort)
+	}
+	if len(pm.HostSocket) > maxSocketPathLen {
+		return fmt.Errorf("port mapping %s -> %d: host_socket is %d bytes, longer than the %d a Unix socket path allows", pm.HostSocket, pm.GuestPort, len(pm.HostSocket), maxSocketPathLen)
+	}
+	return nil
+}
+
+// checkForwardHostSockets validates the host_socket of every port mapping.
+func checkForwardHostSockets(config GvproxyConfig) error {
+	for _, pm := range config.PortMappings {
+		if err := checkForwardHostSocket(pm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listenForward binds pm's host listener: its host_socket, its host_vsock
+// or gateway_port (forward_vsock.go), or its host address.
+func listenForward(pm PortMapping) (net.Listener, error) {
+	switch {
+	case pm.GatewayPort != 0:
+		return newGatewayListener(pm.GatewayPort), nil
+	case pm.HostVsock != nil:
+		return listenVsock(VsockListen(*pm.HostVsock))
+	case pm.HostSocket == "":
+		return net.Listen("tcp", pm.hostAddr())
+	}
+	removeStaleForwardSocket(pm.HostSocket)
+	return net.Listen("unix", pm.HostSocket)
+}
+
+// removeStaleForwardSocket removes the socket file at path if nothing
+// accepts on it. Other files are left for the bind to fail on.
+func removeStaleForwardSocket(path string) {
+	if isAbstractSocket(path) {
+		return
+	}
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.DialTimeout("unix", path, staleSocketDialTimeout); err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// withHostSocket adds host_socket to the event fields of a forward
+// published on one.
+func withHostSocket(fields map[string]any, pm PortMapping) map[string]any {
+	if pm.HostSocket != "" {
+		fields["host_socket"] = pm.HostSocket
+	}
+	return fields
+}
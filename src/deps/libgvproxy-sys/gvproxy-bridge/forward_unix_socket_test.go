@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckForwardHostSocket(t *testing.T) {
+	if err := checkForwardHostSocket(PortMapping{HostSocket: "/run/app.sock", GuestPort: 80}); err != nil {
+		t.Errorf("valid host_socket: %v", err)
+	}
+	if err := checkForwardHostSocket(PortMapping{HostSocket: "/run/app.sock", HostPort: 8080, GuestPort: 80}); err == nil {
+		t.Error("expected error combining host_socket with host_port")
+	}
+	if err := checkForwardHostSocket(PortMapping{HostSocket: "/" + string(make([]byte, maxSocketPathLen)), GuestPort: 80}); err == nil {
+		t.Error("expected error for a host_socket too long for a Unix socket")
+	}
+}
+
+func TestPortForwarder_HostSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gvp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.sock")
+
+	// A socket file left behind by a crash.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	pm := PortMapping{HostSocket: path, GuestPort: 80}
+	f, err := NewPortForwarder(1, "192.168.127.2", []PortMapping{pm}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dialer := &echoDialer{dialed: make(chan string, 1)}
+	f.Serve(ctx, dialer)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial host_socket: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	conn.Close()
+	if got := <-dialer.dialed; got != "192.168.127.2:80" {
+		t.Fatalf("guest dial = %q, want 192.168.127.2:80", got)
+	}
+	if s := f.Stats(); len(s) != 1 || s[0].HostSocket != path || s[0].InboundConnections != 1 {
+		t.Fatalf("stats = %+v", s)
+	}
+
+	// A live socket is not taken over.
+	if err := f.Expose(PortMapping{HostSocket: path, GuestPort: 81}); err == nil {
+		t.Fatal("expected error publishing the socket twice")
+	}
+
+	if err := f.Unexpose(PortMapping{HostSocket: path}); err != nil {
+		t.Fatalf("Unexpose: %v", err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file left after unexpose: %v", err)
+	}
+}
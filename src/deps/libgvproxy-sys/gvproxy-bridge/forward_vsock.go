@@ -0,0 +1,204 @@
+package main
+
+// forward_vsock.go — Forwards between the guest and host AF_VSOCK services.
+//
+// Some host agents only listen on vsock, and some host clients only speak
+// it. A port mapping with host_vsock makes a vsock address the host end of
+// the forward, in one forwarding table with the TCP and Unix socket ones:
+//
+//   - with guest_port, the bridge listens on host_vsock (cid 0 => the
+//     host's) and relays each connection to the guest port, like host_port;
+//   - with gateway_port instead, the bridge listens on that port of the
+//     gateway inside the netstack, and each connection the guest opens to it
+//     is relayed to host_vsock (cid required), so guests reach vsock-only
+//     host agents over plain TCP.
+//
+// host_port, host_ip and host_socket must be unset; a gateway_port forward
+// takes no guest_ip and is of type tcp. Such forwards are named by
+// gateway_port in gvproxy_unexpose and are rebound by a replacement instance
+// rather than handed over; a host_vsock listener cannot be handed over at
+// all, so gvproxy_export_listeners fails for an instance that has one.
+// Needs vsock: Linux for both directions, Windows (cid 0) for listening.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+)
+
+// VsockAddr is a host AF_VSOCK address.
+type VsockAddr struct {
+	CID  uint32 `json:"cid,omitempty"`
+	Port uint32 `json:"port"`
+}
+
+func (v VsockAddr) String() string { return fmt.Sprintf("vsock://%d:%d", v.CID, v.Port) }
+
+// vsockAddr is the address naming a vsock forward in place of its host
+// address, if pm is one.
+func (pm PortMapping) vsockAddr() (string, bool) {
+	switch {
+	case pm.GatewayPort != 0:
+		return fmt.Sprintf("gateway:%d", pm.GatewayPort), true
+	case pm.HostVsock != nil:
+		return pm.HostVsock.String(), true
+	}
+	return "", false
+}
+
+// checkForwardHostVsock validates pm's host_vsock and gateway_port.
+func checkForwardHostVsock(pm PortMapping) error {
+	if pm.HostVsock == nil {
+		if pm.GatewayPort != 0 {
+			return fmt.Errorf("port mapping gateway:%d: gateway_port needs host_vsock", pm.GatewayPort)
+		}
+		return nil
+	}
+	name := pm.hostAddr()
+	if !vsockSupported {
+		return classify(createErrUnsupported, fmt.Errorf("port mapping %s: host_vsock is not supported on %s", name, runtime.GOOS))
+	}
+	if pm.HostVsock.Port == 0 {
+		return fmt.Errorf("port mapping %s: host_vsock needs a port", name)
+	}
+	if pm.HostPort != 0 || pm.HostIP != "" || pm.HostSocket != "" {
+		return fmt.Errorf("port mapping %s: host_vsock excludes host_port, host_ip and host_socket", name)
+	}
+	if (pm.GuestPort == 0) == (pm.GatewayPort == 0) {
+		return fmt.Errorf("port mapping %s: host_vsock needs exactly one of guest_port and gateway_port", name)
+	}
+	if pm.GatewayPort == 0 {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		return classify(createErrUnsupported, fmt.Errorf("port mapping %s: gateway_port is not supported on windows", name))
+	}
+	if pm.HostVsock.CID == 0 {
+		return fmt.Errorf("port mapping %s: host_vsock needs the cid to connect to", name)
+	}
+	if pm.GuestIP != "" || pm.Type == forwardTypeHTTP {
+		return fmt.Errorf("port mapping %s: gateway_port takes no guest_ip and no type http", name)
+	}
+	return nil
+}
+
+// checkForwardHostVsocks validates the host_vsock of every port mapping.
+// A gateway_port is only bound once the netstack runs, so one used twice is
+// caught here rather than by the bind.
+func checkForwardHostVsocks(config GvproxyConfig) error {
+	gatewayPorts := make(map[uint16]bool)
+	for _, pm := range config.PortMappings {
+		if err := checkForwardHostVsock(pm); err != nil {
+			return err
+		}
+		if pm.GatewayPort != 0 {
+			if gatewayPorts[pm.GatewayPort] {
+				return fmt.Errorf("port mapping gateway:%d: gateway_port is mapped twice", pm.GatewayPort)
+			}
+			gatewayPorts[pm.GatewayPort] = true
+		}
+	}
+	return nil
+}
+
+// withVsock adds host_vsock and gateway_port to the event fields of a vsock
+// forward.
+func withVsock(fields map[string]any, pm PortMapping) map[string]any {
+	if pm.HostVsock != nil {
+		fields["host_vsock"] = pm.HostVsock.String()
+	}
+	if pm.GatewayPort != 0 {
+		fields["gateway_port"] = pm.GatewayPort
+	}
+	return fields
+}
+
+// hostVsockDialer connects gateway_port forwards to their host_vsock. addr
+// is the forward's target, a VsockAddr string.
+type hostVsockDialer struct{}
+
+func (hostVsockDialer) DialContextTCP(ctx context.Context, addr string) (net.Conn, error) {
+	return dialVsock(ctx, addr)
+}
+
+// netstackListener binds listeners inside the virtual network.
+// *virtualnetwork.VirtualNetwork satisfies it.
+type netstackListener interface {
+	Listen(network, addr string) (net.Listener, error)
+}
+
+// gatewayListener is the listener of a gateway_port forward. The netstack
+// does not exist yet when forwards are bound, so it is bound once the
+// forwarder serves; Accept waits until then.
+type gatewayListener struct {
+	port  uint16
+	bound chan struct{} // closed once bound or closed
+
+	mu     sync.Mutex
+	l      net.Listener
+	closed bool
+}
+
+func newGatewayListener(port uint16) *gatewayListener {
+	return &gatewayListener{port: port, bound: make(chan struct{})}
+}
+
+// bind listens on gatewayIP:port in ns.
+func (g *gatewayListener) bind(ns netstackListener, gatewayIP string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed || g.l != nil {
+		return nil
+	}
+	l, err := ns.Listen("tcp", net.JoinHostPort(gatewayIP, fmt.Sprint(g.port)))
+	if err != nil {
+		return err
+	}
+	g.l = l
+	close(g.bound)
+	return nil
+}
+
+func (g *gatewayListener) Accept() (net.Conn, error) {
+	<-g.bound
+	g.mu.Lock()
+	l := g.l
+	g.mu.Unlock()
+	if l == nil {
+		return nil, net.ErrClosed
+	}
+	return l.Accept()
+}
+
+func (g *gatewayListener) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+	if g.l != nil {
+		return g.l.Close()
+	}
+	close(g.bound)
+	return nil
+}
+
+func (g *gatewayListener) Addr() net.Addr { return &net.TCPAddr{Port: int(g.port)} }
+
+// bindGateway binds fwd's listener in the netstack behind dialer if fwd is
+// a gateway_port forward. Callers hold mu.
+func (f *PortForwarder) bindGateway(fwd *portForward, dialer guestDialer) error {
+	g, ok := fwd.listener.(*gatewayListener)
+	if !ok {
+		return nil
+	}
+	ns, ok := dialer.(netstackListener)
+	if !ok {
+		return errors.New("gateway_port needs the netstack")
+	}
+	return g.bind(ns, f.gatewayIP)
+}
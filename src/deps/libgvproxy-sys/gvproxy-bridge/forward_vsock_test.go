@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopbackNetstack stands in for the netstack of gateway_port forwards: it
+// binds loopback listeners, reporting the address asked for on listened.
+type loopbackNetstack struct {
+	echoDialer
+	listened  chan string
+	listeners chan net.Listener
+}
+
+func (n *loopbackNetstack) Listen(_, addr string) (net.Listener, error) {
+	n.listened <- addr
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err == nil {
+		n.listeners <- l
+	}
+	return l, err
+}
+
+func TestPortForwarder_GatewayPortToHostVsock(t *testing.T) {
+	pm := PortMapping{GatewayPort: 2375, HostVsock: &VsockAddr{CID: 2, Port: 1024}}
+	f, err := NewPortForwarder(1, "192.168.127.2", []PortMapping{pm}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	f.gatewayIP = "192.168.127.1"
+	vsock := &echoDialer{dialed: make(chan string, 2)}
+	f.vsockDialer = vsock
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ns := &loopbackNetstack{listened: make(chan string, 2), listeners: make(chan net.Listener, 2)}
+	f.Serve(ctx, ns)
+	if addr := <-ns.listened; addr != "192.168.127.1:2375" {
+		t.Fatalf("listened on %s, want the gateway's 192.168.127.1:2375", addr)
+	}
+
+	conn, err := net.Dial("tcp4", (<-ns.listeners).Addr().String())
+	if err != nil {
+		t.Fatalf("dial gateway port: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	expectEcho(t, conn)
+	if target := <-vsock.dialed; target != "vsock://2:1024" {
+		t.Fatalf("relayed to %s, want vsock://2:1024", target)
+	}
+	if s := f.Stats(); len(s) != 1 || s[0].GatewayPort != 2375 || s[0].HostVsock != "vsock://2:1024" || s[0].InboundConnections != 1 {
+		t.Fatalf("Stats = %+v", s)
+	}
+	if fds, err := f.ExportListeners(); err != nil || len(fds) != 0 {
+		t.Fatalf("ExportListeners = %v, %v; want the gateway listener skipped", fds, err)
+	}
+
+	// Published at run time, it is bound at once.
+	if err := f.Expose(PortMapping{GatewayPort: 2376, HostVsock: &VsockAddr{CID: 2, Port: 1025}}); err != nil {
+		t.Fatalf("Expose: %v", err)
+	}
+	if addr := <-ns.listened; addr != "192.168.127.1:2376" {
+		t.Fatalf("listened on %s, want 192.168.127.1:2376", addr)
+	}
+	if err := f.Unexpose(PortMapping{GatewayPort: 2375}); err != nil {
+		t.Fatalf("Unexpose: %v", err)
+	}
+	if m := f.Mappings(); len(m) != 1 || m[0].GatewayPort != 2376 {
+		t.Fatalf("Mappings = %+v", m)
+	}
+}
+
+func TestGatewayListener_CloseBeforeBind(t *testing.T) {
+	g := newGatewayListener(2375)
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := g.Accept()
+		accepted <- err
+	}()
+	g.Close()
+	select {
+	case err := <-accepted:
+		if err == nil {
+			t.Fatal("Accept succeeded on a closed listener")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+	ns := &loopbackNetstack{listened: make(chan string, 1), listeners: make(chan net.Listener, 1)}
+	if err := g.bind(ns, "192.168.127.1"); err != nil || len(ns.listened) != 0 {
+		t.Fatalf("bind after Close = %v, listened %d; want a no-op", err, len(ns.listened))
+	}
+}
+
+func TestCheckForwardHostVsock(t *testing.T) {
+	vsock := &VsockAddr{CID: 2, Port: 1024}
+	for _, pm := range []PortMapping{
+		{HostVsock: &VsockAddr{Port: 1024}, GuestPort: 80},
+		{HostVsock: vsock, GatewayPort: 2375},
+		{HostPort: 8080, GuestPort: 80},
+	} {
+		if err := checkForwardHostVsock(pm); err != nil {
+			t.Errorf("%+v rejected: %v", pm, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		pm   PortMapping
+		want string
+	}{
+		{PortMapping{GatewayPort: 2375}, "needs host_vsock"},
+		{PortMapping{HostVsock: &VsockAddr{CID: 2}, GuestPort: 80}, "needs a port"},
+		{PortMapping{HostVsock: vsock, HostPort: 8080, GuestPort: 80}, "excludes"},
+		{PortMapping{HostVsock: vsock}, "exactly one"},
+		{PortMapping{HostVsock: vsock, GuestPort: 80, GatewayPort: 2375}, "exactly one"},
+		{PortMapping{HostVsock: &VsockAddr{Port: 1024}, GatewayPort: 2375}, "cid"},
+		{PortMapping{HostVsock: vsock, GatewayPort: 2375, Type: forwardTypeHTTP}, "type http"},
+	} {
+		if err := checkForwardHostVsock(tc.pm); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: err = %v, want %q", tc.pm, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+// frame_ring.go — The last N link frames, kept in memory for support bundles.
+//
+// A full packet capture has to be enabled before the problem happens and
+// grows without bound. With frame_ring_size set, the link keeps only its most
+// recent frames (both directions) in fixed, reused slots, and
+// gvproxy_collect_diagnostics writes them out as a pcap.
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// frameRingSnapLen caps how much of each frame is kept.
+const frameRingSnapLen = 2048
+
+type ringFrame struct {
+	at      time.Time
+	origLen int
+	data    []byte // reused across wraps; cap frameRingSnapLen
+}
+
+// frameRing is a frameObserver recording the most recent frames.
+type frameRing struct {
+	mu    sync.Mutex
+	slots []ringFrame
+	next  int
+	full  bool
+}
+
+func newFrameRing(size int) *frameRing {
+	return &frameRing{slots: make([]ringFrame, size)}
+}
+
+func (r *frameRing) ingressFrame(frame []byte) { r.record(frame) }
+func (r *frameRing) egressFrame(frame []byte)  { r.record(frame) }
+
+func (r *frameRing) record(frame []byte) {
+	n := min(len(frame), frameRingSnapLen)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	slot := &r.slots[r.next]
+	if slot.data == nil {
+		slot.data = make([]byte, 0, frameRingSnapLen)
+	}
+	slot.at = time.Now()
+	slot.origLen = len(frame)
+	slot.data = append(slot.data[:0], frame[:n]...)
+
+	r.next++
+	if r.next == len(r.slots) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// writePcap writes the recorded frames, oldest first, as a pcap file with
+// Ethernet link type.
+func (r *frameRing) writePcap(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4) // microsecond timestamps
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], frameRingSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], 1) // LINKTYPE_ETHERNET
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	start, count := 0, r.next
+	if r.full {
+		start, count = r.next, len(r.slots)
+	}
+	rec := make([]byte, 16)
+	for i := 0; i < count; i++ {
+		f := &r.slots[(start+i)%len(r.slots)]
+		binary.LittleEndian.PutUint32(rec[0:], uint32(f.at.Unix()))
+		binary.LittleEndian.PutUint32(rec[4:], uint32(f.at.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(f.data)))
+		binary.LittleEndian.PutUint32(rec[12:], uint32(f.origLen))
+		if _, err := w.Write(rec); err != nil {
+			return err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+// frame_trace.go — Per-frame trace mode for the hypervisor link.
+//
+// When frames go missing between the VM's socket and the netstack, a pcap is
+// often too heavy (or too late) to get. Trace mode logs one line per frame —
+// direction, sequence id, length and a protocol summary — and can be switched
+// on and off at runtime with gvproxy_set_frame_trace. Sequence ids are
+// assigned to every frame while tracing is on, so frames skipped by the rate
+// limit still show up as gaps.
+
+import "C"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// defaultFrameTraceRate applies when gvproxy_set_frame_trace gets rate <= 0.
+const defaultFrameTraceRate = 100
+
+// frameTracer logs link frames while enabled, at most rate lines per second.
+type frameTracer struct {
+	instanceID int64
+	enabled    atomic.Bool
+	seq        atomic.Uint64
+
+	mu          sync.Mutex
+	rate        int
+	windowStart time.Time
+	windowCount int
+	suppressed  uint64
+}
+
+func newFrameTracer(instanceID int64) *frameTracer {
+	return &frameTracer{instanceID: instanceID, rate: defaultFrameTraceRate}
+}
+
+// configure switches tracing on or off and sets the per-second line budget.
+func (t *frameTracer) configure(enabled bool, rate int) {
+	if rate <= 0 {
+		rate = defaultFrameTraceRate
+	}
+	t.mu.Lock()
+	t.rate = rate
+	t.windowStart = time.Time{}
+	t.windowCount = 0
+	t.suppressed = 0
+	t.mu.Unlock()
+	t.enabled.Store(enabled)
+	logrus.WithFields(logrus.Fields{"id": t.instanceID, "enabled": enabled, "rate": rate}).Info("Frame trace configured")
+}
+
+func (t *frameTracer) ingressFrame(frame []byte) { t.trace("rx", frame) }
+func (t *frameTracer) egressFrame(frame []byte)  { t.trace("tx", frame) }
+
+func (t *frameTracer) trace(dir string, frame []byte) {
+	if !t.enabled.Load() {
+		return
+	}
+	seq := t.seq.Add(1)
+
+	allowed, suppressed := t.admit(time.Now())
+	if !allowed {
+		return
+	}
+	entry := logrus.WithFields(logrus.Fields{
+		"id":  t.instanceID,
+		"dir": dir,
+		"seq": seq,
+		"len": len(frame),
+	})
+	if suppressed > 0 {
+		entry = entry.WithField("suppressed", suppressed)
+	}
+	entry.Debug("frame " + summarizeFrame(frame))
+}
+
+// admit applies the rate limit. When a new one-second window opens it also
+// returns how many lines the previous window dropped.
+func (t *frameTracer) admit(now time.Time) (bool, uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var suppressed uint64
+	if now.Sub(t.windowStart) >= time.Second {
+		suppressed = t.suppressed
+		t.windowStart = now
+		t.windowCount = 0
+		t.suppressed = 0
+	}
+	if t.windowCount >= t.rate {
+		t.suppressed++
+		return false, 0
+	}
+	t.windowCount++
+	return true, suppressed
+}
+
+// gvproxy_set_frame_trace turns per-frame tracing on (enabled != 0) or off
+// for an instance. Lines are logged at debug level, at most rate per second
+// (rate <= 0 => 100); each carries a sequence id, so rate-limited frames show
+// as gaps and the first line of each window reports how many were dropped.
+//
+// Returns 0 on success, -1 if the instance does not exist.
+//
+//export gvproxy_set_frame_trace
+func gvproxy_set_frame_trace(id C.longlong, enabled C.int, rate C.int) C.int {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok {
+		return -1
+	}
+	instance.tracer.configure(enabled != 0, int(rate))
+	return 0
+}
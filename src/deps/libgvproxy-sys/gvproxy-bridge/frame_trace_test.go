@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestFrameTracer_RateLimitReportsSuppressed(t *testing.T) {
+	tr := newFrameTracer(1)
+	tr.configure(true, 2)
+
+	start := time.Now()
+	for i, want := range []bool{true, true, false, false} {
+		if ok, _ := tr.admit(start); ok != want {
+			t.Fatalf("frame %d: admitted=%v, want %v", i, ok, want)
+		}
+	}
+	ok, suppressed := tr.admit(start.Add(time.Second))
+	if !ok || suppressed != 2 {
+		t.Fatalf("new window: admitted=%v suppressed=%d, want true/2", ok, suppressed)
+	}
+}
+
+func TestFrameTracer_SequenceOnlyWhileEnabled(t *testing.T) {
+	tr := newFrameTracer(1)
+	frame := testIPv4Frame(header.UDPProtocolNumber, "192.168.127.2", "8.8.8.8", testUDPDatagram(5353, 53, nil))
+
+	tr.ingressFrame(frame)
+	if tr.seq.Load() != 0 {
+		t.Fatal("disabled tracer must not number frames")
+	}
+	tr.configure(true, 1)
+	tr.ingressFrame(frame)
+	tr.egressFrame(frame)
+	if tr.seq.Load() != 2 {
+		t.Fatalf("expected 2 sequenced frames, got %d", tr.seq.Load())
+	}
+}
+
+func TestSummarizeFrame(t *testing.T) {
+	syn := testIPv4Frame(header.TCPProtocolNumber, "192.168.127.2", "93.184.216.34", testTCPSegment(40000, 443, header.TCPFlagSyn))
+	if got, want := summarizeFrame(syn), "IPv4 TCP 192.168.127.2:40000→93.184.216.34:443 [S] len=0"; got != want {
+		t.Fatalf("summarizeFrame(SYN) = %q, want %q", got, want)
+	}
+	udp := testIPv4Frame(header.UDPProtocolNumber, "192.168.127.2", "8.8.8.8", testUDPDatagram(5353, 53, []byte("q")))
+	if got, want := summarizeFrame(udp), "IPv4 UDP 192.168.127.2:5353→8.8.8.8:53 len=1"; got != want {
+		t.Fatalf("summarizeFrame(UDP) = %q, want %q", got, want)
+	}
+	if got := summarizeFrame([]byte{1, 2}); got != "runt" {
+		t.Fatalf("summarizeFrame(short) = %q", got)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+// frames.go — Minimal L2/L3 parsing for frames seen on the hypervisor link.
+//
+// Only what the bridge's observers need; anything unexpected is treated as
+// "not interesting" rather than an error.
+
+import (
+	"fmt"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	dhcpServerPort     = 67
+	dnsPort            = 53
+	dhcpCiaddrOffset   = 12  // client IP address in the BOOTP header
+	dhcpYiaddrOffset   = 16  // address offered to the client
+	dhcpChaddrOffset   = 28  // client hardware address in the BOOTP header
+	dhcpOptionsOffset  = 240 // fixed BOOTP header (236) + magic cookie (4)
+	dhcpOptPad         = 0
+	dhcpOptHostname    = 12
+	dhcpOptLeaseTime   = 51
+	dhcpOptMessageType = 53
+	dhcpOptServerID    = 54
+	dhcpOptVendorClass = 60
+	dhcpOptEnd         = 255
+	dhcpMsgDiscover    = 1
+	dhcpMsgRequest     = 3
+	dhcpMsgAck         = 5
+)
+
+// frameIPv4 returns the IPv4 packet carried by an Ethernet frame, or nil.
+func frameIPv4(frame []byte) header.IPv4 {
+	if len(frame) < header.EthernetMinimumSize {
+		return nil
+	}
+	if header.Ethernet(frame).Type() != header.IPv4ProtocolNumber {
+		return nil
+	}
+	ip := header.IPv4(frame[header.EthernetMinimumSize:])
+	if !ip.IsValid(len(ip)) {
+		return nil
+	}
+	return ip
+}
+
+// frameARP returns the IPv4-over-Ethernet ARP packet carried by a frame, or
+// nil.
+func frameARP(frame []byte) header.ARP {
+	if len(frame) < header.EthernetMinimumSize+header.ARPSize {
+		return nil
+	}
+	if header.Ethernet(frame).Type() != header.ARPProtocolNumber {
+		return nil
+	}
+	arp := header.ARP(frame[header.EthernetMinimumSize:])
+	if !arp.IsValid() {
+		return nil
+	}
+	return arp
+}
+
+// frameUDP returns the UDP datagram carried by an IPv4 frame, or nil.
+func frameUDP(frame []byte) header.UDP {
+	ip := frameIPv4(frame)
+	if ip == nil || ip.TransportProtocol() != header.UDPProtocolNumber {
+		return nil
+	}
+	udp := header.UDP(ip.Payload())
+	if len(udp) < header.UDPMinimumSize {
+		return nil
+	}
+	return udp
+}
+
+// isDHCPAck reports whether frame carries a DHCPACK from the gateway.
+func isDHCPAck(frame []byte) bool {
+	udp := frameUDP(frame)
+	if udp == nil || udp.SourcePort() != dhcpServerPort {
+		return false
+	}
+	return dhcpMessageType(udp.Payload()) == dhcpMsgAck
+}
+
+// dhcpMessageType returns the DHCP message type option (53), or 0.
+func dhcpMessageType(msg []byte) byte {
+	if opt := dhcpOption(msg, dhcpOptMessageType); len(opt) >= 1 {
+		return opt[0]
+	}
+	return 0
+}
+
+// dhcpOption returns the value of the first DHCP option with the given code,
+// or nil if it is absent or truncated.
+func dhcpOption(msg []byte, code byte) []byte {
+	for i := dhcpOptionsOffset; i < len(msg); {
+		c := msg[i]
+		if c == dhcpOptPad {
+			i++
+			continue
+		}
+		if c == dhcpOptEnd || i+1 >= len(msg) {
+			return nil
+		}
+		optLen := int(msg[i+1])
+		if i+2+optLen > len(msg) {
+			return nil
+		}
+		if c == code {
+			return msg[i+2 : i+2+optLen]
+		}
+		i += 2 + optLen
+	}
+	return nil
+}
+
+// isOutboundFlowStart reports whether a guest frame opens a flow the gateway
+// must NAT to the host network: a TCP SYN or any UDP datagram addressed to
+// something other than the gateway itself or a broadcast/multicast group.
+func isOutboundFlowStart(frame []byte, gateway tcpip.Address) bool {
+	ip := frameIPv4(frame)
+	if ip == nil {
+		return false
+	}
+	dst := ip.DestinationAddress()
+	if dst == gateway || dst == header.IPv4Broadcast || header.IsV4MulticastAddress(dst) {
+		return false
+	}
+	switch ip.TransportProtocol() {
+	case header.TCPProtocolNumber:
+		tcp := header.TCP(ip.Payload())
+		if len(tcp) < header.TCPMinimumSize {
+			return false
+		}
+		flags := tcp.Flags()
+		return flags.Contains(header.TCPFlagSyn) && !flags.Contains(header.TCPFlagAck)
+	case header.UDPProtocolNumber:
+		return true
+	}
+	return false
+}
+
+// summarizeFrame returns a one-line protocol summary for traces, e.g.
+// "IPv4 TCP 192.168.127.2:40000→93.184.216.34:443 [S] len=0".
+func summarizeFrame(frame []byte) string {
+	if len(frame) < header.EthernetMinimumSize {
+		return "runt"
+	}
+	switch ethType := header.Ethernet(frame).Type(); ethType {
+	case header.ARPProtocolNumber:
+		return "ARP"
+	case header.IPv6ProtocolNumber:
+		return "IPv6"
+	case header.IPv4ProtocolNumber:
+	default:
+		return fmt.Sprintf("ethertype 0x%04x", uint16(ethType))
+	}
+
+	ip := frameIPv4(frame)
+	if ip == nil {
+		return "IPv4 (malformed)"
+	}
+	src, dst := ip.SourceAddress(), ip.DestinationAddress()
+	switch ip.TransportProtocol() {
+	case header.TCPProtocolNumber:
+		tcp := header.TCP(ip.Payload())
+		if len(tcp) < header.TCPMinimumSize {
+			return fmt.Sprintf("IPv4 TCP %s→%s (truncated)", src, dst)
+		}
+		return fmt.Sprintf("IPv4 TCP %s:%d→%s:%d [%s] len=%d", src, tcp.SourcePort(), dst, tcp.DestinationPort(),
+			strings.ReplaceAll(tcp.Flags().String(), " ", ""), len(tcp)-int(tcp.DataOffset()))
+	case header.UDPProtocolNumber:
+		udp := header.UDP(ip.Payload())
+		if len(udp) < header.UDPMinimumSize {
+			return fmt.Sprintf("IPv4 UDP %s→%s (truncated)", src, dst)
+		}
+		return fmt.Sprintf("IPv4 UDP %s:%d→%s:%d len=%d", src, udp.SourcePort(), dst, udp.DestinationPort(), len(udp)-header.UDPMinimumSize)
+	case header.ICMPv4ProtocolNumber:
+		return fmt.Sprintf("IPv4 ICMP %s→%s", src, dst)
+	default:
+		return fmt.Sprintf("IPv4 proto=%d %s→%s", ip.Protocol(), src, dst)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// testIPv4Frame builds an Ethernet+IPv4 frame around a transport payload.
+func testIPv4Frame(proto tcpip.TransportProtocolNumber, src, dst string, transport []byte) []byte {
+	frame := make([]byte, header.EthernetMinimumSize+header.IPv4MinimumSize+len(transport))
+	header.Ethernet(frame).Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress("\x5a\x94\xef\xe4\x0c\xee"),
+		DstAddr: tcpip.LinkAddress("\x5a\x94\xef\xe4\x0c\xdd"),
+		Type:    header.IPv4ProtocolNumber,
+	})
+	ip := header.IPv4(frame[header.EthernetMinimumSize:])
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(header.IPv4MinimumSize + len(transport)),
+		TTL:         64,
+		Protocol:    uint8(proto),
+		SrcAddr:     tcpip.AddrFrom4Slice(net.ParseIP(src).To4()),
+		DstAddr:     tcpip.AddrFrom4Slice(net.ParseIP(dst).To4()),
+	})
+	copy(ip[header.IPv4MinimumSize:], transport)
+	return frame
+}
+
+func testTCPSegment(srcPort, dstPort uint16, flags header.TCPFlags) []byte {
+	seg := make([]byte, header.TCPMinimumSize)
+	header.TCP(seg).Encode(&header.TCPFields{
+		SrcPort:    srcPort,
+		DstPort:    dstPort,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      flags,
+	})
+	return seg
+}
+
+func testUDPDatagram(srcPort, dstPort uint16, payload []byte) []byte {
+	dgram := make([]byte, header.UDPMinimumSize+len(payload))
+	header.UDP(dgram).Encode(&header.UDPFields{
+		SrcPort: srcPort,
+		DstPort: dstPort,
+		Length:  uint16(len(dgram)),
+	})
+	copy(dgram[header.UDPMinimumSize:], payload)
+	return dgram
+}
+
+// testDHCPMessage returns a BOOTP body carrying only the message type option.
+func testDHCPMessage(msgType byte) []byte {
+	msg := make([]byte, dhcpOptionsOffset, dhcpOptionsOffset+4)
+	return append(msg, dhcpOptMessageType, 1, msgType, dhcpOptEnd)
+}
+
+func TestIsDHCPAck(t *testing.T) {
+	ack := testIPv4Frame(header.UDPProtocolNumber, "192.168.127.1", "192.168.127.2",
+		testUDPDatagram(67, 68, testDHCPMessage(dhcpMsgAck)))
+	assertTrue(t, isDHCPAck(ack), "DHCPACK from server port detected")
+
+	offer := testIPv4Frame(header.UDPProtocolNumber, "192.168.127.1", "192.168.127.2",
+		testUDPDatagram(67, 68, testDHCPMessage(2)))
+	assertFalse(t, isDHCPAck(offer), "DHCPOFFER is not an ACK")
+
+	request := testIPv4Frame(header.UDPProtocolNumber, "0.0.0.0", "255.255.255.255",
+		testUDPDatagram(68, 67, testDHCPMessage(dhcpMsgAck)))
+	assertFalse(t, isDHCPAck(request), "client port is not the server")
+}
+
+func TestIsOutboundFlowStart(t *testing.T) {
+	gateway := tcpip.AddrFrom4Slice(net.ParseIP("192.168.127.1").To4())
+
+	syn := testIPv4Frame(header.TCPProtocolNumber, "192.168.127.2", "93.184.216.34",
+		testTCPSegment(40000, 443, header.TCPFlagSyn))
+	assertTrue(t, isOutboundFlowStart(syn, gateway), "SYN to the internet starts a NAT flow")
+
+	synAck := testIPv4Frame(header.TCPProtocolNumber, "192.168.127.2", "93.184.216.34",
+		testTCPSegment(80, 40000, header.TCPFlagSyn|header.TCPFlagAck))
+	assertFalse(t, isOutboundFlowStart(synAck, gateway), "SYN-ACK answers an inbound flow")
+
+	dns := testIPv4Frame(header.UDPProtocolNumber, "192.168.127.2", "192.168.127.1",
+		testUDPDatagram(5353, 53, nil))
+	assertFalse(t, isOutboundFlowStart(dns, gateway), "gateway DNS is served locally")
+
+	udp := testIPv4Frame(header.UDPProtocolNumber, "192.168.127.2", "8.8.8.8",
+		testUDPDatagram(5353, 53, nil))
+	assertTrue(t, isOutboundFlowStart(udp, gateway), "UDP to upstream is NATed")
+}
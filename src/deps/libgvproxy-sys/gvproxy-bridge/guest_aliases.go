@@ -0,0 +1,77 @@
+package main
+
+// guest_aliases.go — Secondary addresses on the guest's NIC.
+//
+// The guest gets guest_ip over DHCP (or statically); guest_aliases lists
+// further addresses it configures statically on the same interface, e.g. to
+// bind services to dedicated IPs. Nothing in the virtual network is keyed to
+// the primary address: the gateway resolves aliases over ARP like any other
+// address, and traffic the guest sources from an alias is NATed to the host
+// as usual. The bridge only has to
+//
+//   - keep the DHCP pool from offering an alias to another client (a
+//     container bridged onto the guest's NIC, say), and
+//   - let port forwards target an alias (PortMapping.GuestIP).
+
+import (
+	"fmt"
+	"net"
+)
+
+// aliasReservationMAC holds alias addresses in the DHCP pool. It is not the
+// guest's MAC: the pool answers a client with whichever of its leases it
+// finds first, and that must stay guest_ip.
+const aliasReservationMAC = "00:00:00:00:00:00"
+
+// checkGuestAliases validates guest_aliases and the guest_ip of every port
+// mapping: aliases must be distinct IPv4 addresses in the subnet, other than
+// the gateway, host and primary guest addresses, and mappings may only
+// target the guests' addresses.
+func checkGuestAliases(config GvproxyConfig) error {
+	var subnet *net.IPNet
+	if config.Subnet != "" {
+		var err error
+		if _, subnet, err = net.ParseCIDR(config.Subnet); err != nil {
+			return fmt.Errorf("invalid subnet %q: %w", config.Subnet, err)
+		}
+	}
+	taken := map[string]string{
+		config.GatewayIP: "the gateway",
+		config.HostIP:    "the host",
+		config.GuestIP:   "the primary guest address",
+	}
+	for _, alias := range config.GuestAliases {
+		ip := net.ParseIP(alias).To4()
+		if ip == nil {
+			return fmt.Errorf("guest alias %q is not an IPv4 address", alias)
+		}
+		if subnet != nil && !subnet.Contains(ip) {
+			return fmt.Errorf("guest alias %s is outside subnet %s", alias, config.Subnet)
+		}
+		if owner, ok := taken[ip.String()]; ok {
+			return fmt.Errorf("guest alias %s is already used by %s", alias, owner)
+		}
+		taken[ip.String()] = "another guest alias"
+	}
+
+	targets := guestAddresses(config)
+	for _, pm := range config.PortMappings {
+		if pm.GuestIP != "" && !targets[pm.GuestIP] {
+			return fmt.Errorf("port mapping %d -> %s:%d: guest_ip is not guest_ip, a guest alias or one of guests", pm.HostPort, pm.GuestIP, pm.GuestPort)
+		}
+	}
+	return nil
+}
+
+// guestAddresses returns the primary guest address, its aliases and the
+// addresses of guests.
+func guestAddresses(config GvproxyConfig) map[string]bool {
+	addrs := map[string]bool{config.GuestIP: true}
+	for _, alias := range config.GuestAliases {
+		addrs[alias] = true
+	}
+	for _, g := range config.Guests {
+		addrs[g.IP] = true
+	}
+	return addrs
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+func TestCheckGuestAliases(t *testing.T) {
+	base := testGvproxyConfig()
+	base.GuestAliases = []string{"192.168.127.10", "192.168.127.11"}
+	base.PortMappings = []PortMapping{{HostPort: 8080, GuestPort: 80, GuestIP: "192.168.127.11"}}
+	if err := checkGuestAliases(base); err != nil {
+		t.Fatalf("expected a valid config, got %v", err)
+	}
+
+	for name, mutate := range map[string]func(*GvproxyConfig){
+		"not an IP":        func(c *GvproxyConfig) { c.GuestAliases = []string{"guest"} },
+		"outside subnet":   func(c *GvproxyConfig) { c.GuestAliases = []string{"10.0.0.10"} },
+		"primary address":  func(c *GvproxyConfig) { c.GuestAliases = []string{c.GuestIP} },
+		"gateway address":  func(c *GvproxyConfig) { c.GuestAliases = []string{c.GatewayIP} },
+		"duplicate alias":  func(c *GvproxyConfig) { c.GuestAliases = []string{"192.168.127.10", "192.168.127.10"} },
+		"unknown fwd addr": func(c *GvproxyConfig) { c.PortMappings[0].GuestIP = "192.168.127.12" },
+	} {
+		config := base
+		config.GuestAliases = append([]string(nil), base.GuestAliases...)
+		config.PortMappings = append([]PortMapping(nil), base.PortMappings...)
+		mutate(&config)
+		if err := checkGuestAliases(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestBuildTapConfig_ReservesGuestAliases(t *testing.T) {
+	config := testGvproxyConfig()
+	config.GuestAliases = []string{"192.168.127.10"}
+	leases := buildTapConfig(config, types.QemuProtocol).DHCPStaticLeases
+	if leases[config.GuestIP] != config.GuestMac {
+		t.Fatalf("primary lease must stay with the guest MAC, got %v", leases)
+	}
+	// Reserved under a placeholder so DHCP keeps offering the guest guest_ip.
+	if leases["192.168.127.10"] != aliasReservationMAC {
+		t.Fatalf("expected the alias to be reserved, got %v", leases)
+	}
+}
+
+func TestPortForwarder_TargetsGuestAlias(t *testing.T) {
+	f, err := NewPortForwarder(1, "192.168.127.2", []PortMapping{{HostPort: 0, GuestPort: 80, GuestIP: "192.168.127.10"}}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	if got := f.forwards[0].guestAddr; got != "192.168.127.10:80" {
+		t.Fatalf("expected the forward to target the alias, got %q", got)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+// guest_info.go — What the gateway can learn about the guest on its own.
+//
+// The guest's DHCP client announces a hostname and vendor class, and its
+// first DNS lookups (package mirrors, mostly) say a lot about the distro.
+// Collecting these from link frames lets boxlite show "guest appears to be
+// Alpine, hostname box-1" without running an agent inside the guest.
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	logrus "github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// EventGuestInfo fires when the guest's hostname, vendor class or OS guess is
+// first learned or changes. Fields: hostname, vendor_class, os_guess.
+const EventGuestInfo = "guest_info"
+
+// maxGuestDNSQueries bounds how many distinct early DNS names are kept.
+const maxGuestDNSQueries = 16
+
+// guestOSHints maps DNS suffixes looked up by package managers to the distro
+// they imply. Checked in order; the first match wins.
+var guestOSHints = []struct{ suffix, os string }{
+	{"alpinelinux.org", "Alpine Linux"},
+	{"ubuntu.com", "Ubuntu"},
+	{"debian.org", "Debian"},
+	{"fedoraproject.org", "Fedora"},
+	{"rockylinux.org", "Rocky Linux"},
+	{"almalinux.org", "AlmaLinux"},
+	{"centos.org", "CentOS"},
+	{"archlinux.org", "Arch Linux"},
+	{"opensuse.org", "openSUSE"},
+	{"amazonlinux.com", "Amazon Linux"},
+}
+
+// GuestInfo is the "Guest" stats section.
+type GuestInfo struct {
+	Hostname    string   `json:"Hostname"`
+	VendorClass string   `json:"VendorClass"`
+	OSGuess     string   `json:"OSGuess"`
+	DNSQueries  []string `json:"DNSQueries"`
+}
+
+// guestInfo observes guest→gateway frames for DHCP client options and DNS
+// questions.
+type guestInfo struct {
+	instanceID int64
+
+	mu      sync.Mutex
+	info    GuestInfo
+	queried map[string]bool
+}
+
+func newGuestInfo(instanceID int64) *guestInfo {
+	return &guestInfo{instanceID: instanceID, queried: make(map[string]bool)}
+}
+
+func (g *guestInfo) ingressFrame(frame []byte) {
+	udp := frameUDP(frame)
+	if udp == nil {
+		return
+	}
+	switch udp.DestinationPort() {
+	case dhcpServerPort:
+		g.observeDHCP(udp.Payload())
+	case dnsPort:
+		g.observeDNS(udp.Payload())
+	}
+}
+
+func (g *guestInfo) egressFrame([]byte) {}
+
+func (g *guestInfo) observeDHCP(msg []byte) {
+	hostname := string(dhcpOption(msg, dhcpOptHostname))
+	vendorClass := string(dhcpOption(msg, dhcpOptVendorClass))
+	if hostname == "" && vendorClass == "" {
+		return
+	}
+
+	g.mu.Lock()
+	changed := false
+	if hostname != "" && hostname != g.info.Hostname {
+		g.info.Hostname = hostname
+		changed = true
+	}
+	if vendorClass != "" && vendorClass != g.info.VendorClass {
+		g.info.VendorClass = vendorClass
+		changed = true
+	}
+	if changed {
+		g.info.OSGuess = guessGuestOS(g.info.VendorClass, g.info.DNSQueries)
+	}
+	info := g.info
+	g.mu.Unlock()
+
+	if changed {
+		g.announce(info)
+	}
+}
+
+func (g *guestInfo) observeDNS(msg []byte) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+	name := strings.TrimSuffix(strings.ToLower(q.Name.String()), ".")
+	if name == "" {
+		return
+	}
+
+	g.mu.Lock()
+	if g.queried[name] || len(g.info.DNSQueries) >= maxGuestDNSQueries {
+		g.mu.Unlock()
+		return
+	}
+	g.queried[name] = true
+	g.info.DNSQueries = append(g.info.DNSQueries, name)
+	prevGuess := g.info.OSGuess
+	g.info.OSGuess = guessGuestOS(g.info.VendorClass, g.info.DNSQueries)
+	info := g.info
+	g.mu.Unlock()
+
+	if info.OSGuess != prevGuess {
+		g.announce(info)
+	}
+}
+
+func (g *guestInfo) announce(info GuestInfo) {
+	logrus.WithFields(logrus.Fields{
+		"id":           g.instanceID,
+		"hostname":     info.Hostname,
+		"vendor_class": info.VendorClass,
+		"os_guess":     info.OSGuess,
+	}).Info("Guest info updated")
+	emitEvent(g.instanceID, EventGuestInfo, map[string]any{
+		"hostname":     info.Hostname,
+		"vendor_class": info.VendorClass,
+		"os_guess":     info.OSGuess,
+	})
+}
+
+// reset forgets everything learned about the guest, for a new guest boot.
+func (g *guestInfo) reset() {
+	g.mu.Lock()
+	g.info = GuestInfo{}
+	g.queried = make(map[string]bool)
+	g.mu.Unlock()
+}
+
+// Snapshot returns a copy of what is known about the guest.
+func (g *guestInfo) Snapshot() GuestInfo {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	info := g.info
+	info.DNSQueries = append([]string(nil), g.info.DNSQueries...)
+	return info
+}
+
+// guessGuestOS prefers distro-specific DNS lookups over the DHCP vendor
+// class, which only identifies the DHCP client.
+func guessGuestOS(vendorClass string, queries []string) string {
+	for _, hint := range guestOSHints {
+		for _, q := range queries {
+			if q == hint.suffix || strings.HasSuffix(q, "."+hint.suffix) {
+				return hint.os
+			}
+		}
+	}
+	switch vc := strings.ToLower(vendorClass); {
+	case strings.HasPrefix(vc, "udhcp"):
+		return "BusyBox Linux"
+	case strings.HasPrefix(vc, "dhcpcd"), strings.HasPrefix(vc, "linux"):
+		return "Linux"
+	case strings.HasPrefix(vc, "msft"):
+		return "Windows"
+	case strings.HasPrefix(vc, "android-dhcp"):
+		return "Android"
+	}
+	return ""
+}
+
+// guestInfoCollector publishes guest info as the "Guest" section.
+type guestInfoCollector struct{ g *guestInfo }
+
+func (guestInfoCollector) Name() string { return "Guest" }
+
+func (guestInfoCollector) Description() string {
+	return "Guest hostname, DHCP vendor class, OS guess and first DNS lookups, as seen by the gateway."
+}
+
+func (c guestInfoCollector) Collect() any { return c.g.Snapshot() }
+
+func (c guestInfoCollector) collectOpenMetrics(emit metricEmitter) {
+	info := c.g.Snapshot()
+	emit(openMetricsPrefix+"guest_info", "gauge", "Guest identity as seen by the gateway (value is always 1).",
+		fmt.Sprintf(`hostname="%s",os_guess="%s"`, escapeLabelValue(info.Hostname), escapeLabelValue(info.OSGuess)), "1")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// testDHCPClientFrame builds a DHCPREQUEST carrying hostname and vendor class.
+func testDHCPClientFrame(hostname, vendorClass string) []byte {
+	msg := testDHCPMessage(3)
+	msg = msg[:len(msg)-1] // drop END
+	msg = append(msg, dhcpOptHostname, byte(len(hostname)))
+	msg = append(msg, hostname...)
+	msg = append(msg, dhcpOptVendorClass, byte(len(vendorClass)))
+	msg = append(msg, vendorClass...)
+	msg = append(msg, dhcpOptEnd)
+	return testIPv4Frame(header.UDPProtocolNumber, "0.0.0.0", "255.255.255.255", testUDPDatagram(68, 67, msg))
+}
+
+func testDNSQueryFrame(t *testing.T, name string) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+	if err := b.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testIPv4Frame(header.UDPProtocolNumber, "192.168.127.2", "192.168.127.1", testUDPDatagram(40000, 53, msg))
+}
+
+func TestGuestInfo_LearnsFromDHCPAndDNS(t *testing.T) {
+	g := newGuestInfo(1)
+	g.ingressFrame(testDHCPClientFrame("box-1", "udhcp 1.36.1"))
+
+	info := g.Snapshot()
+	if info.Hostname != "box-1" || info.VendorClass != "udhcp 1.36.1" {
+		t.Fatalf("DHCP options not captured: %+v", info)
+	}
+	if info.OSGuess != "BusyBox Linux" {
+		t.Fatalf("expected vendor-class guess, got %q", info.OSGuess)
+	}
+
+	g.ingressFrame(testDNSQueryFrame(t, "dl-cdn.alpinelinux.org."))
+	g.ingressFrame(testDNSQueryFrame(t, "dl-cdn.alpinelinux.org."))
+	info = g.Snapshot()
+	if info.OSGuess != "Alpine Linux" {
+		t.Fatalf("DNS hint should refine the guess, got %q", info.OSGuess)
+	}
+	if len(info.DNSQueries) != 1 || info.DNSQueries[0] != "dl-cdn.alpinelinux.org" {
+		t.Fatalf("expected one deduplicated query, got %v", info.DNSQueries)
+	}
+}
+
+func TestGuestInfo_CapsDNSQueries(t *testing.T) {
+	g := newGuestInfo(1)
+	for i := 0; i < maxGuestDNSQueries+4; i++ {
+		g.ingressFrame(testDNSQueryFrame(t, string(rune('a'+i))+".example.com."))
+	}
+	if got := len(g.Snapshot().DNSQueries); got != maxGuestDNSQueries {
+		t.Fatalf("expected %d queries kept, got %d", maxGuestDNSQueries, got)
+	}
+}
+
+func TestGuessGuestOS(t *testing.T) {
+	cases := []struct {
+		vendor  string
+		queries []string
+		want    string
+	}{
+		{"", nil, ""},
+		{"MSFT 5.0", nil, "Windows"},
+		{"dhcpcd-10.0.1:Linux-6.6", nil, "Linux"},
+		{"udhcp 1.36.1", []string{"archive.ubuntu.com"}, "Ubuntu"},
+		{"", []string{"deb.debian.org"}, "Debian"},
+		{"", []string{"notdebian.org"}, ""},
+	}
+	for _, c := range cases {
+		if got := guessGuestOS(c.vendor, c.queries); got != c.want {
+			t.Errorf("guessGuestOS(%q, %v) = %q, want %q", c.vendor, c.queries, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+// guest_liveness.go — Probe the guest at L2 with ARP.
+//
+// A guest whose kernel is frozen (paused vCPUs, a hung VMM, a panic without
+// reboot) still has open TCP flows and a connected hypervisor socket, so
+// nothing on the host notices until some request times out. With
+// guest_probe_interval_ms set, the gateway sends the guest an ARP request
+// for guest_ip every interval, straight onto the link (the gateway's
+// netstack is not involved, so its neighbor cache is left alone). Any
+// running kernel answers ARP without help from userspace, which makes the
+// reply a cheap "guest responding at L2" signal.
+//
+// The guest counts as responding from its first reply. guestProbeMisses
+// probes in a row without a reply make it unresponsive
+// (EventGuestUnresponsive); the next reply makes it responding again
+// (EventGuestResponsive). Until the first reply, e.g. while the guest boots,
+// it is neither. Only IPv4 is probed: the guest link has no IPv6 addresses
+// to probe with ND.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// EventGuestUnresponsive fires when the guest stops answering ARP probes.
+// Fields: missed (probes in a row without a reply), last_reply_ms_ago.
+const EventGuestUnresponsive = "guest_unresponsive"
+
+// EventGuestResponsive fires when an unresponsive guest answers a probe
+// again. Fields: unresponsive_ms.
+const EventGuestResponsive = "guest_responsive"
+
+// guestProbeMisses is how many probes in a row may go unanswered before the
+// guest counts as unresponsive.
+const guestProbeMisses = 3
+
+// Guest L2 states, as reported in the "GuestProbe" stats section.
+const (
+	guestStateUnknown      = "unknown"
+	guestStateResponding   = "responding"
+	guestStateUnresponsive = "unresponsive"
+)
+
+// guestProber sends ARP probes to the guest and watches for its replies. It
+// is a frameObserver for the guest's frames.
+type guestProber struct {
+	instanceID int64
+	interval   time.Duration
+	probe      []byte // the ARP request frame
+	guestIP    []byte
+
+	mu                 sync.Mutex
+	state              string
+	sentAt             time.Time // of the outstanding probe; zero if answered
+	missed             int
+	lastReply          time.Time
+	lastRTT            time.Duration
+	unresponsiveAt     time.Time
+	sent, replies      uint64
+	unresponsiveEvents uint64
+}
+
+// newGuestProber returns a prober for the config, or nil when probing is
+// off or the addresses needed for the ARP request are missing.
+func newGuestProber(instanceID int64, config GvproxyConfig) *guestProber {
+	if config.GuestProbeIntervalMs <= 0 {
+		return nil
+	}
+	gatewayMAC, err := net.ParseMAC(config.GatewayMac)
+	if err != nil {
+		return nil
+	}
+	gatewayIP := net.ParseIP(config.GatewayIP).To4()
+	guestIP := net.ParseIP(config.GuestIP).To4()
+	if gatewayIP == nil || guestIP == nil {
+		return nil
+	}
+	// Unicast to the guest's MAC when known, like a kernel reconfirming a
+	// neighbor; broadcast otherwise.
+	guestMAC, err := net.ParseMAC(config.GuestMac)
+	if err != nil {
+		guestMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	}
+	return &guestProber{
+		instanceID: instanceID,
+		interval:   time.Duration(config.GuestProbeIntervalMs) * time.Millisecond,
+		probe:      arpRequestFrame(gatewayMAC, guestMAC, gatewayIP, guestIP),
+		guestIP:    guestIP,
+		state:      guestStateUnknown,
+	}
+}
+
+// arpRequestFrame builds an Ethernet frame asking who has targetIP, padded
+// to the Ethernet minimum.
+func arpRequestFrame(srcMAC, dstMAC net.HardwareAddr, srcIP, targetIP net.IP) []byte {
+	frame := make([]byte, 60)
+	header.Ethernet(frame).Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress(srcMAC),
+		DstAddr: tcpip.LinkAddress(dstMAC),
+		Type:    header.ARPProtocolNumber,
+	})
+	arp := header.ARP(frame[header.EthernetMinimumSize:])
+	arp.SetIPv4OverEthernet()
+	arp.SetOp(header.ARPRequest)
+	copy(arp.HardwareAddressSender(), srcMAC)
+	copy(arp.ProtocolAddressSender(), srcIP)
+	copy(arp.ProtocolAddressTarget(), targetIP)
+	return frame
+}
+
+func (p *guestProber) ingressFrame(frame []byte) {
+	arp := frameARP(frame)
+	if arp == nil || arp.Op() != header.ARPReply || !net.IP(arp.ProtocolAddressSender()).Equal(p.guestIP) {
+		return
+	}
+	now := time.Now()
+	p.mu.Lock()
+	p.replies++
+	p.lastReply = now
+	if !p.sentAt.IsZero() {
+		p.lastRTT = now.Sub(p.sentAt)
+		p.sentAt = time.Time{}
+	}
+	p.missed = 0
+	var recovered map[string]any
+	if p.state == guestStateUnresponsive {
+		recovered = map[string]any{"unresponsive_ms": now.Sub(p.unresponsiveAt).Milliseconds()}
+	}
+	p.state = guestStateResponding
+	p.mu.Unlock()
+	if recovered != nil {
+		emitEvent(p.instanceID, EventGuestResponsive, recovered)
+	}
+}
+
+func (p *guestProber) egressFrame([]byte) {}
+
+// tick accounts for the previous probe and sends the next one over link
+// (nil while the VM is not connected).
+func (p *guestProber) tick(now time.Time, link *linkConn) {
+	p.mu.Lock()
+	var unresponsive map[string]any
+	if !p.sentAt.IsZero() {
+		p.missed++
+		if p.missed >= guestProbeMisses && p.state == guestStateResponding {
+			p.state = guestStateUnresponsive
+			p.unresponsiveAt = now
+			p.unresponsiveEvents++
+			unresponsive = map[string]any{
+				"missed":            p.missed,
+				"last_reply_ms_ago": now.Sub(p.lastReply).Milliseconds(),
+			}
+		}
+	}
+	p.sentAt = time.Time{}
+	if link != nil {
+		p.sentAt = now
+		p.sent++
+	}
+	p.mu.Unlock()
+	if unresponsive != nil {
+		emitEvent(p.instanceID, EventGuestUnresponsive, unresponsive)
+	}
+	if link != nil {
+		// A failed write is a missed probe; the link reports its own errors.
+		link.writeFrame(p.probe) //nolint:errcheck
+	}
+}
+
+// watch probes the guest every interval until ctx is done. link returns the
+// current VM link.
+func (p *guestProber) watch(ctx context.Context, link func() *linkConn) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.tick(now, link())
+		}
+	}
+}
+
+// GuestProbeStats is the "GuestProbe" stats section.
+type GuestProbeStats struct {
+	State              string `json:"State"` // unknown, responding or unresponsive
+	ProbesSent         uint64 `json:"ProbesSent"`
+	Replies            uint64 `json:"Replies"`
+	LastReplyMsAgo     int64  `json:"LastReplyMsAgo"` // -1 before the first reply
+	LastRTTUs          int64  `json:"LastRTTUs"`
+	UnresponsiveEvents uint64 `json:"UnresponsiveEvents"`
+}
+
+func (p *guestProber) Stats() GuestProbeStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ago := int64(-1)
+	if !p.lastReply.IsZero() {
+		ago = time.Since(p.lastReply).Milliseconds()
+	}
+	return GuestProbeStats{
+		State:              p.state,
+		ProbesSent:         p.sent,
+		Replies:            p.replies,
+		LastReplyMsAgo:     ago,
+		LastRTTUs:          p.lastRTT.Microseconds(),
+		UnresponsiveEvents: p.unresponsiveEvents,
+	}
+}
+
+// guestProbeCollector publishes the prober's view as the "GuestProbe"
+// section.
+type guestProbeCollector struct{ p *guestProber }
+
+func (guestProbeCollector) Name() string { return "GuestProbe" }
+
+func (guestProbeCollector) Description() string {
+	return "ARP liveness probes to the guest: whether it answers at L2, probes, replies and the last round-trip time."
+}
+
+func (c guestProbeCollector) Collect() any { return c.p.Stats() }
+
+func (c guestProbeCollector) collectOpenMetrics(emit metricEmitter) {
+	stats := c.p.Stats()
+	responding := 0
+	if stats.State == guestStateResponding {
+		responding = 1
+	}
+	emit(openMetricsPrefix+"guest_l2_responding", "gauge", "Whether the guest answers ARP probes.", "", fmt.Sprint(responding))
+	emit(openMetricsPrefix+"guest_probes_sent", "counter", "ARP probes sent to the guest.", "", fmt.Sprint(stats.ProbesSent))
+	emit(openMetricsPrefix+"guest_probe_replies", "counter", "ARP replies received from the guest.", "", fmt.Sprint(stats.Replies))
+	emit(openMetricsPrefix+"guest_probe_rtt_seconds", "gauge", "Round-trip time of the last answered ARP probe.", "", fmt.Sprint(float64(stats.LastRTTUs)/1e6))
+	emit(openMetricsPrefix+"guest_unresponsive_events", "counter", "Times the guest stopped answering ARP probes.", "", fmt.Sprint(stats.UnresponsiveEvents))
+}
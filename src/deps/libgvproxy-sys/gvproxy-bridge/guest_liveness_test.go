@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func testGuestProber(t *testing.T, id int64) *guestProber {
+	t.Helper()
+	config := testGvproxyConfig()
+	config.GuestProbeIntervalMs = 1000
+	p := newGuestProber(id, config)
+	if p == nil {
+		t.Fatal("expected a prober")
+	}
+	return p
+}
+
+// arpReplyFrame is the guest's answer to arpRequestFrame.
+func arpReplyFrame(guestMAC, gatewayMAC string, guestIP, gatewayIP string) []byte {
+	frame := arpRequestFrame(mustMAC(guestMAC), mustMAC(gatewayMAC), net.ParseIP(guestIP).To4(), net.ParseIP(gatewayIP).To4())
+	header.ARP(frame[header.EthernetMinimumSize:]).SetOp(header.ARPReply)
+	return frame
+}
+
+func mustMAC(s string) net.HardwareAddr {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		panic(err)
+	}
+	return mac
+}
+
+func TestGuestProber_SendsARPRequestOnLink(t *testing.T) {
+	p := testGuestProber(t, 5800)
+	vm, gw := net.Pipe()
+	defer vm.Close()
+	link := newLinkConn(gw, types.QemuProtocol, nil)
+
+	go p.tick(time.Now(), link)
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(vm, prefix); err != nil {
+		t.Fatalf("read prefix: %v", err)
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(prefix))
+	if _, err := io.ReadFull(vm, frame); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	if dst := header.Ethernet(frame).DestinationAddress(); dst != tcpip.LinkAddress(mustMAC("5a:94:ef:e4:0c:ee")) {
+		t.Fatalf("probe sent to %v, want the guest MAC", dst)
+	}
+	arp := frameARP(frame)
+	if arp == nil || arp.Op() != header.ARPRequest ||
+		!net.IP(arp.ProtocolAddressTarget()).Equal(net.ParseIP("192.168.127.2")) ||
+		!net.IP(arp.ProtocolAddressSender()).Equal(net.ParseIP("192.168.127.1")) {
+		t.Fatalf("not an ARP request from the gateway for the guest IP: %x", frame)
+	}
+	if stats := p.Stats(); stats.ProbesSent != 1 || stats.State != guestStateUnknown || stats.LastReplyMsAgo != -1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestGuestProber_UnresponsiveAndBack(t *testing.T) {
+	const id = 5801
+	p := testGuestProber(t, id)
+	vm, gw := net.Pipe()
+	defer vm.Close()
+	go io.Copy(io.Discard, vm) //nolint:errcheck
+	link := newLinkConn(gw, types.QemuProtocol, nil)
+	reply := arpReplyFrame("5a:94:ef:e4:0c:ee", "5a:94:ef:e4:0c:dd", "192.168.127.2", "192.168.127.1")
+
+	now := time.Now()
+	p.tick(now, link)
+	p.ingressFrame(reply)
+	if stats := p.Stats(); stats.State != guestStateResponding || stats.Replies != 1 {
+		t.Fatalf("expected the guest responding after a reply, got %+v", stats)
+	}
+
+	// An ARP reply from another address says nothing about the guest.
+	p.ingressFrame(arpReplyFrame("5a:94:ef:e4:0c:ff", "5a:94:ef:e4:0c:dd", "192.168.127.9", "192.168.127.1"))
+	for i := 1; i <= guestProbeMisses; i++ {
+		p.tick(now.Add(time.Duration(i)*time.Second), link)
+		if i < guestProbeMisses && p.Stats().State != guestStateResponding {
+			t.Fatalf("unresponsive after %d misses", i)
+		}
+	}
+	p.tick(now.Add(time.Duration(guestProbeMisses+1)*time.Second), link)
+	if stats := p.Stats(); stats.State != guestStateUnresponsive || stats.UnresponsiveEvents != 1 {
+		t.Fatalf("expected the guest unresponsive, got %+v", stats)
+	}
+	if e := waitForEvent(t, id, EventGuestUnresponsive); e.Fields["missed"] != guestProbeMisses {
+		t.Fatalf("unexpected event fields: %v", e.Fields)
+	}
+
+	p.ingressFrame(reply)
+	waitForEvent(t, id, EventGuestResponsive)
+	if stats := p.Stats(); stats.State != guestStateResponding || stats.UnresponsiveEvents != 1 {
+		t.Fatalf("expected the guest responding again, got %+v", stats)
+	}
+}
+
+func TestGuestProber_NoneWhenOff(t *testing.T) {
+	if newGuestProber(1, testGvproxyConfig()) != nil {
+		t.Fatal("expected no prober without guest_probe_interval_ms")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+// guest_nat.go — Take one guest off the network at run time.
+//
+// Several guests can share a virtual network (guest aliases, leases of
+// other MACs). Taking one of them offline for investigation meant tearing
+// down the instance, and its siblings' connectivity with it.
+// gvproxy_set_nat(id, guest_ip, enabled) turns NAT off for one guest
+// address instead: the TCP and UDP forwarders drop its new flows to
+// anything beyond the gateway, and its established forwarded flows are
+// aborted. The gateway's own services (DNS, DHCP, test services) and
+// published ports into the guest keep working, so it can still be
+// inspected. nat_disabled_guests sets the state at creation; a hot upgrade
+// carries it over.
+
+import "C"
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// guestNAT holds the guest addresses whose NAT is off.
+type guestNAT struct {
+	mu  sync.RWMutex
+	off map[tcpip.Address]bool
+
+	dropped atomic.Uint64 // new flows dropped
+	aborted atomic.Uint64 // established flows aborted
+}
+
+func newGuestNAT(disabled []string) *guestNAT {
+	g := &guestNAT{off: make(map[tcpip.Address]bool)}
+	for _, ip := range disabled {
+		g.off[tcpip.AddrFrom4Slice(net.ParseIP(ip).To4())] = true
+	}
+	return g
+}
+
+// checkNATDisabledGuests validates nat_disabled_guests.
+func checkNATDisabledGuests(config GvproxyConfig) error {
+	for _, ip := range config.NATDisabledGuests {
+		if err := checkNATGuest(config, ip); err != nil {
+			return fmt.Errorf("nat_disabled_guests: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkNATGuest checks that ip can be a guest of config's network.
+func checkNATGuest(config GvproxyConfig, ip string) error {
+	addr := net.ParseIP(ip).To4()
+	if addr == nil {
+		return fmt.Errorf("guest %q is not an IPv4 address", ip)
+	}
+	if _, subnet, err := net.ParseCIDR(config.Subnet); err == nil && !subnet.Contains(addr) {
+		return fmt.Errorf("guest %s is outside subnet %s", ip, config.Subnet)
+	}
+	if ip == config.GatewayIP || ip == config.HostIP {
+		return fmt.Errorf("%s is not a guest address", ip)
+	}
+	return nil
+}
+
+// enabled reports whether guest has NAT.
+func (g *guestNAT) enabled(guest tcpip.Address) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return !g.off[guest]
+}
+
+// set turns NAT on or off for guest and reports whether that changed it.
+func (g *guestNAT) set(guest tcpip.Address, enabled bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.off[guest] == !enabled {
+		return false
+	}
+	if enabled {
+		delete(g.off, guest)
+	} else {
+		g.off[guest] = true
+	}
+	return true
+}
+
+// disabledGuests returns the guests without NAT, sorted, for a hot upgrade.
+func (g *guestNAT) disabledGuests() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	guests := make([]string, 0, len(g.off))
+	for addr := range g.off {
+		guests = append(guests, addr.String())
+	}
+	slices.Sort(guests)
+	return guests
+}
+
+// gate wraps a transport protocol handler so that new flows from guests
+// without NAT are dropped. The handler only sees packets no endpoint
+// claimed, so the gateway's own services are unaffected. A nil g gates
+// nothing.
+func (g *guestNAT) gate(handler func(stack.TransportEndpointID, *stack.PacketBuffer) bool) func(stack.TransportEndpointID, *stack.PacketBuffer) bool {
+	if g == nil {
+		return handler
+	}
+	return func(id stack.TransportEndpointID, pkt *stack.PacketBuffer) bool {
+		if !g.enabled(id.RemoteAddress) {
+			g.dropped.Add(1)
+			return true
+		}
+		return handler(id, pkt)
+	}
+}
+
+// abortFlows aborts the forwarded flows of guest: endpoints it reaches that
+// are not on the gateway address. It returns how many were aborted.
+func (g *guestNAT) abortFlows(s *stack.Stack, guest, gateway tcpip.Address) int {
+	n := 0
+	for _, ep := range s.RegisteredEndpoints() {
+		e, ok := ep.(tcpip.Endpoint)
+		if !ok {
+			continue
+		}
+		info, ok := e.Info().(*stack.TransportEndpointInfo)
+		if !ok || info.ID.RemoteAddress != guest || info.ID.LocalAddress == gateway {
+			continue
+		}
+		e.Abort()
+		n++
+	}
+	g.aborted.Add(uint64(n))
+	return n
+}
+
+// GuestNATStats is the "GuestNAT" stats section.
+type GuestNATStats struct {
+	Disabled []string `json:"Disabled"` // guests without NAT
+	Dropped  uint64   `json:"Dropped"`  // new flows dropped
+	Aborted  uint64   `json:"Aborted"`  // established flows aborted
+}
+
+type guestNATCollector struct{ g *guestNAT }
+
+func (guestNATCollector) Name() string { return "GuestNAT" }
+
+func (guestNATCollector) Description() string {
+	return "Guests whose NAT is off (gvproxy_set_nat), with the new flows dropped and established flows aborted for them."
+}
+
+func (c guestNATCollector) Collect() any {
+	return GuestNATStats{Disabled: c.g.disabledGuests(), Dropped: c.g.dropped.Load(), Aborted: c.g.aborted.Load()}
+}
+
+// setGuestNAT turns NAT on or off for guestIP on instance id and bumps its
+// config generation when that changed anything.
+func setGuestNAT(id int64, guestIP string, enabled bool) error {
+	instancesMu.RLock()
+	instance, ok := instances[id]
+	instancesMu.RUnlock()
+	if !ok || instance.nat == nil {
+		return fmt.Errorf("instance %d not found", id)
+	}
+	if err := checkNATGuest(instance.config, guestIP); err != nil {
+		return err
+	}
+	guest := tcpip.AddrFrom4Slice(net.ParseIP(guestIP).To4())
+	if !instance.nat.set(guest, enabled) {
+		return nil
+	}
+	aborted := 0
+	if !enabled {
+		instance.vnMu.RLock()
+		vn := instance.vn
+		instance.vnMu.RUnlock()
+		if vn != nil {
+			if s, err := vnStack(vn); err == nil {
+				gateway := tcpip.AddrFrom4Slice(net.ParseIP(instance.config.GatewayIP).To4())
+				aborted = instance.nat.abortFlows(s, guest, gateway)
+			}
+		}
+	}
+	logrus.WithFields(logrus.Fields{"id": id, "guest_ip": guestIP, "enabled": enabled, "aborted_flows": aborted}).Info("Guest NAT changed")
+	instance.generation.bump(id, "set_nat", map[string]any{"guest_ip": guestIP, "enabled": enabled})
+	return nil
+}
+
+// gvproxy_set_nat turns NAT on (enabled != 0) or off for one guest address
+// of a running instance. Off drops the guest's new flows beyond the gateway
+// and aborts its established ones; published ports and the gateway's own
+// services keep working.
+//
+// Returns 0 on success (also when nothing changed), -1 on failure (logged):
+// unknown instance, or guest_ip not a guest address of the subnet.
+//
+//export gvproxy_set_nat
+func gvproxy_set_nat(id C.longlong, guestIP *C.char, enabled C.int) C.int {
+	ip := C.GoString(guestIP)
+	if err := setGuestNAT(int64(id), ip, enabled != 0); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id), "guest_ip": ip}).Error("Failed to set guest NAT")
+		return -1
+	}
+	return 0
+}
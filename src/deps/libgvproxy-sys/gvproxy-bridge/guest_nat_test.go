@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestGuestNAT_GateDropsNewFlows(t *testing.T) {
+	g := newGuestNAT([]string{"192.168.127.3"})
+	handled := 0
+	handler := g.gate(func(stack.TransportEndpointID, *stack.PacketBuffer) bool {
+		handled++
+		return true
+	})
+	from := func(ip string) stack.TransportEndpointID {
+		return stack.TransportEndpointID{
+			RemoteAddress: tcpip.AddrFrom4Slice(net.ParseIP(ip).To4()),
+			LocalAddress:  tcpip.AddrFrom4Slice(net.ParseIP("1.1.1.1").To4()),
+			LocalPort:     443,
+		}
+	}
+
+	handler(from("192.168.127.2"), nil)
+	handler(from("192.168.127.3"), nil)
+	if handled != 1 || g.dropped.Load() != 1 {
+		t.Fatalf("handled=%d dropped=%d, want the offline guest's flow dropped", handled, g.dropped.Load())
+	}
+
+	if !g.set(tcpip.AddrFrom4Slice(net.ParseIP("192.168.127.3").To4()), true) {
+		t.Fatal("enabling NAT did not change anything")
+	}
+	handler(from("192.168.127.3"), nil)
+	if handled != 2 {
+		t.Fatal("flow of a guest with NAT back on was not handled")
+	}
+	if (*guestNAT)(nil).gate(nil) != nil {
+		t.Fatal("a nil guestNAT must not wrap the handler")
+	}
+}
+
+func TestCheckNATDisabledGuests(t *testing.T) {
+	for _, tc := range []struct {
+		ip   string
+		want string
+	}{
+		{"192.168.127.2", ""},
+		{"192.168.127.9", ""},
+		{"10.0.0.2", "outside subnet"},
+		{"fd00::2", "IPv4"},
+		{"192.168.127.1", "not a guest"},
+		{"192.168.127.254", "not a guest"},
+	} {
+		config := testGvproxyConfig()
+		config.NATDisabledGuests = []string{tc.ip}
+		err := checkNATDisabledGuests(config)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%s: err = %v, want %q", tc.ip, err, tc.want)
+		}
+	}
+}
+
+func TestSetGuestNAT_BumpsGeneration(t *testing.T) {
+	const id = 5935
+	config := testGvproxyConfig()
+	addFakeInstance(t, id, config)
+	instances[id].nat = newGuestNAT(nil)
+
+	if err := setGuestNAT(id, "192.168.127.3", false); err != nil {
+		t.Fatalf("setGuestNAT: %v", err)
+	}
+	if got := instances[id].generation.current(); got != 2 {
+		t.Fatalf("generation = %d, want 2", got)
+	}
+	e := waitForEvent(t, id, EventConfigChanged)
+	if e.Fields["change"] != "set_nat" || e.Fields["guest_ip"] != "192.168.127.3" || e.Fields["enabled"] != false {
+		t.Fatalf("config_changed fields = %v", e.Fields)
+	}
+	// Setting the same state again changes nothing.
+	if err := setGuestNAT(id, "192.168.127.3", false); err != nil || instances[id].generation.current() != 2 {
+		t.Fatalf("repeated set: err=%v generation=%d", err, instances[id].generation.current())
+	}
+	if got := instances[id].nat.disabledGuests(); len(got) != 1 || got[0] != "192.168.127.3" {
+		t.Fatalf("disabledGuests = %v", got)
+	}
+	stats := guestNATCollector{instances[id].nat}.Collect().(GuestNATStats)
+	if len(stats.Disabled) != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	if err := setGuestNAT(id, "192.168.127.1", false); err == nil {
+		t.Fatal("the gateway address was accepted as a guest")
+	}
+	if err := setGuestNAT(5999, "192.168.127.3", false); err == nil {
+		t.Fatal("an unknown instance was accepted")
+	}
+}
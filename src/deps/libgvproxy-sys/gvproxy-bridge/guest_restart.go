@@ -0,0 +1,162 @@
+package main
+
+// guest_restart.go — Notice the guest's network coming back up on a live link.
+//
+// When the guest reboots without the VMM restarting, or the vm-side tap
+// driver (gvforwarder) is restarted, the hypervisor link never drops: the
+// bridge just sees a guest that starts over. Left alone, everything learned
+// about the previous boot (hostname, OS guess) stays in stats, and nothing
+// tells the embedder that flows it had open through the guest are gone.
+//
+// A guest holding a lease only renews it, with its address in ciaddr. So once
+// the gateway has ACKed the guest's MAC, a DHCPDISCOVER from that MAC, or a
+// DHCPREQUEST in INIT-REBOOT state (no ciaddr, no server id), means its
+// network stack started from scratch. The bridge then forgets the previous
+// boot's guest info, so the new boot is re-announced as it is learned, and
+// fires EventGuestNetworkRestarted. The DHCP server answers the new
+// exchange as usual, re-arming detection.
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventGuestNetworkRestarted fires when the guest's network stack restarts
+// on a live link. Fields: reason ("dhcp_discover" or "dhcp_init_reboot"),
+// restarts (count for this instance).
+const EventGuestNetworkRestarted = "guest_network_restarted"
+
+// guestRestarts detects guest network restarts from DHCP frames on the
+// link.
+type guestRestarts struct {
+	instanceID int64
+	mac        net.HardwareAddr
+	onRestart  func()
+
+	bound atomic.Bool // gateway has ACKed mac since the last restart
+
+	mu         sync.Mutex
+	count      uint64
+	lastReason string
+	last       time.Time
+}
+
+func newGuestRestarts(instanceID int64, guestMAC string, onRestart func()) *guestRestarts {
+	mac, _ := net.ParseMAC(guestMAC)
+	return &guestRestarts{instanceID: instanceID, mac: mac, onRestart: onRestart}
+}
+
+// guestDHCP returns the DHCP message in frame if it is sent to (or, with
+// fromServer, by) the DHCP server on behalf of the guest's MAC.
+func (g *guestRestarts) guestDHCP(frame []byte, fromServer bool) []byte {
+	udp := frameUDP(frame)
+	if udp == nil {
+		return nil
+	}
+	port := udp.DestinationPort()
+	if fromServer {
+		port = udp.SourcePort()
+	}
+	if port != dhcpServerPort {
+		return nil
+	}
+	msg := udp.Payload()
+	if len(msg) < dhcpOptionsOffset || len(g.mac) == 0 ||
+		!bytes.Equal(msg[dhcpChaddrOffset:dhcpChaddrOffset+len(g.mac)], g.mac) {
+		return nil
+	}
+	return msg
+}
+
+func (g *guestRestarts) egressFrame(frame []byte) {
+	if g.bound.Load() {
+		return
+	}
+	if msg := g.guestDHCP(frame, true); msg != nil && dhcpMessageType(msg) == dhcpMsgAck {
+		g.bound.Store(true)
+	}
+}
+
+func (g *guestRestarts) ingressFrame(frame []byte) {
+	if !g.bound.Load() {
+		return
+	}
+	msg := g.guestDHCP(frame, false)
+	if msg == nil {
+		return
+	}
+	var reason string
+	switch dhcpMessageType(msg) {
+	case dhcpMsgDiscover:
+		reason = "dhcp_discover"
+	case dhcpMsgRequest:
+		ciaddr := msg[dhcpCiaddrOffset : dhcpCiaddrOffset+4]
+		if !bytes.Equal(ciaddr, []byte{0, 0, 0, 0}) || dhcpOption(msg, dhcpOptServerID) != nil {
+			return // renewal, or selecting an offer
+		}
+		reason = "dhcp_init_reboot"
+	default:
+		return
+	}
+	if g.bound.CompareAndSwap(true, false) {
+		g.restarted(reason)
+	}
+}
+
+func (g *guestRestarts) restarted(reason string) {
+	g.mu.Lock()
+	g.count++
+	g.lastReason = reason
+	g.last = time.Now()
+	count := g.count
+	g.mu.Unlock()
+
+	if g.onRestart != nil {
+		g.onRestart()
+	}
+	logrus.WithFields(logrus.Fields{"id": g.instanceID, "reason": reason, "restarts": count}).Warn("Guest network restarted")
+	emitEvent(g.instanceID, EventGuestNetworkRestarted, map[string]any{
+		"reason":   reason,
+		"restarts": count,
+	})
+}
+
+// GuestRestartStats is the "GuestRestarts" stats section.
+type GuestRestartStats struct {
+	Restarts    uint64     `json:"Restarts"`
+	LastReason  string     `json:"LastReason,omitempty"`
+	LastRestart *time.Time `json:"LastRestart,omitempty"`
+}
+
+func (g *guestRestarts) Stats() GuestRestartStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stats := GuestRestartStats{Restarts: g.count, LastReason: g.lastReason}
+	if g.count > 0 {
+		last := g.last
+		stats.LastRestart = &last
+	}
+	return stats
+}
+
+// guestRestartsCollector publishes restart detection as "GuestRestarts".
+type guestRestartsCollector struct{ g *guestRestarts }
+
+func (guestRestartsCollector) Name() string { return "GuestRestarts" }
+
+func (guestRestartsCollector) Description() string {
+	return "Guest network restarts seen on a live link (guest reboot or tap driver restart)."
+}
+
+func (c guestRestartsCollector) Collect() any { return c.g.Stats() }
+
+func (c guestRestartsCollector) collectOpenMetrics(emit metricEmitter) {
+	emit(openMetricsPrefix+"guest_network_restarts", "counter",
+		"Guest network restarts seen on a live link.", "", fmt.Sprint(c.g.Stats().Restarts))
+}
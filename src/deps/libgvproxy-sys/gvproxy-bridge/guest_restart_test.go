@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+var testGuestMAC = []byte{0x5a, 0x94, 0xef, 0xe4, 0x0c, 0xee}
+
+// testGuestDHCPFrame builds a DHCP frame for the test guest MAC; toServer
+// selects the client→server direction.
+func testGuestDHCPFrame(msgType byte, toServer bool, ciaddr []byte, opts ...byte) []byte {
+	msg := testDHCPMessage(msgType)
+	copy(msg[dhcpChaddrOffset:], testGuestMAC)
+	copy(msg[dhcpCiaddrOffset:], ciaddr)
+	msg = append(msg[:len(msg)-1], opts...)
+	msg = append(msg, dhcpOptEnd)
+	if toServer {
+		return testIPv4Frame(header.UDPProtocolNumber, "0.0.0.0", "255.255.255.255", testUDPDatagram(68, 67, msg))
+	}
+	return testIPv4Frame(header.UDPProtocolNumber, "192.168.127.1", "192.168.127.2", testUDPDatagram(67, 68, msg))
+}
+
+func TestGuestRestarts_DetectsRestartAfterLease(t *testing.T) {
+	resets := 0
+	g := newGuestRestarts(4243, "5a:94:ef:e4:0c:ee", func() { resets++ })
+
+	// First boot: the initial exchange is not a restart.
+	g.ingressFrame(testGuestDHCPFrame(dhcpMsgDiscover, true, nil))
+	g.ingressFrame(testGuestDHCPFrame(dhcpMsgRequest, true, nil, dhcpOptServerID, 4, 192, 168, 127, 1))
+	g.egressFrame(testGuestDHCPFrame(dhcpMsgAck, false, nil))
+	// Renewal carries ciaddr.
+	g.ingressFrame(testGuestDHCPFrame(dhcpMsgRequest, true, []byte{192, 168, 127, 2}))
+	if got := g.Stats().Restarts; got != 0 {
+		t.Fatalf("Restarts = %d before any restart, want 0", got)
+	}
+
+	// INIT-REBOOT after a guest reboot.
+	g.ingressFrame(testGuestDHCPFrame(dhcpMsgRequest, true, nil, 50, 4, 192, 168, 127, 2))
+	stats := g.Stats()
+	if stats.Restarts != 1 || stats.LastReason != "dhcp_init_reboot" || stats.LastRestart == nil {
+		t.Fatalf("stats = %+v, want one dhcp_init_reboot restart", stats)
+	}
+	// Retransmits before the new ACK count once.
+	g.ingressFrame(testGuestDHCPFrame(dhcpMsgDiscover, true, nil))
+	if got := g.Stats().Restarts; got != 1 {
+		t.Fatalf("Restarts = %d before the new lease, want 1", got)
+	}
+
+	g.egressFrame(testGuestDHCPFrame(dhcpMsgAck, false, nil))
+	g.ingressFrame(testGuestDHCPFrame(dhcpMsgDiscover, true, nil))
+	if stats := g.Stats(); stats.Restarts != 2 || stats.LastReason != "dhcp_discover" {
+		t.Fatalf("stats = %+v, want a second dhcp_discover restart", stats)
+	}
+	if resets != 2 {
+		t.Errorf("onRestart ran %d times, want 2", resets)
+	}
+}
+
+func TestGuestRestarts_IgnoresOtherMACs(t *testing.T) {
+	g := newGuestRestarts(4243, "5a:94:ef:e4:0c:ee", nil)
+	g.egressFrame(testGuestDHCPFrame(dhcpMsgAck, false, nil))
+
+	other := testDHCPMessage(dhcpMsgDiscover)
+	copy(other[dhcpChaddrOffset:], []byte{0x02, 0x42, 0xac, 0x11, 0x00, 0x02})
+	g.ingressFrame(testIPv4Frame(header.UDPProtocolNumber, "0.0.0.0", "255.255.255.255", testUDPDatagram(68, 67, other)))
+
+	if got := g.Stats().Restarts; got != 0 {
+		t.Fatalf("Restarts = %d for another client's DISCOVER, want 0", got)
+	}
+}
+
+func TestGuestInfo_ResetForgetsPreviousBoot(t *testing.T) {
+	g := newGuestInfo(4243)
+	g.ingressFrame(testDHCPClientFrame("box-1", "udhcp 1.36.1"))
+	g.reset()
+	if info := g.Snapshot(); info.Hostname != "" || info.OSGuess != "" {
+		t.Fatalf("info after reset = %+v, want empty", info)
+	}
+	g.ingressFrame(testDHCPClientFrame("box-1", "udhcp 1.36.1"))
+	if info := g.Snapshot(); info.Hostname != "box-1" {
+		t.Fatalf("Hostname = %q after re-learning, want box-1", info.Hostname)
+	}
+}
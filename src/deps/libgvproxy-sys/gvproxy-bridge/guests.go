@@ -0,0 +1,122 @@
+package main
+
+// guests.go — Further microVMs on the instance's virtual network.
+//
+// guest_ip and guest_mac describe one guest. guests adds more, each with
+// its own static DHCP lease (ip and mac; the mac is generated from
+// mac_prefix if unset), its own port mappings (guest_ip filled in) and
+// whether it starts without NAT (nat_disabled, see guest_nat.go). The
+// switch connects them all, so several microVMs attached to the same
+// socket share the gateway, DNS and host reachability.
+//
+// gvproxy_create folds each guest's port mappings into port_mappings and
+// its nat_disabled into nat_disabled_guests, so the forwarder, the expose
+// API and a hot upgrade treat them like any other; the guests entries keep
+// only their addresses.
+
+import (
+	"fmt"
+	"net"
+	"slices"
+)
+
+// Guest is one entry of guests.
+type Guest struct {
+	IP  string `json:"ip"`
+	MAC string `json:"mac,omitempty"`
+	// NATDisabled creates the guest without NAT, as if listed in
+	// nat_disabled_guests.
+	NATDisabled bool `json:"nat_disabled,omitempty"`
+	// PortMappings publish ports of this guest. guest_ip may be left empty
+	// or set to ip.
+	PortMappings []PortMapping `json:"port_mappings,omitempty"`
+}
+
+// resolveGuests validates guests, generates missing MACs and moves each
+// guest's port mappings and NAT setting into the instance-wide lists.
+func resolveGuests(config *GvproxyConfig) error {
+	if len(config.Guests) == 0 {
+		return nil
+	}
+	var subnet *net.IPNet
+	if config.Subnet != "" {
+		var err error
+		if _, subnet, err = net.ParseCIDR(config.Subnet); err != nil {
+			return fmt.Errorf("invalid subnet %q: %w", config.Subnet, err)
+		}
+	}
+	prefix, err := parseMACPrefix(config.MACPrefix)
+	if err != nil {
+		return err
+	}
+	takenIPs := map[string]string{
+		config.GatewayIP: "the gateway",
+		config.HostIP:    "the host",
+		config.GuestIP:   "guest_ip",
+	}
+	for _, alias := range config.GuestAliases {
+		takenIPs[alias] = "a guest alias"
+	}
+	takenMACs := map[string]string{}
+	for field, value := range map[string]string{"gateway_mac": config.GatewayMac, "guest_mac": config.GuestMac} {
+		if mac, err := net.ParseMAC(value); err == nil {
+			takenMACs[mac.String()] = field
+		}
+	}
+
+	guests := make([]Guest, 0, len(config.Guests))
+	for i, g := range config.Guests {
+		ip := net.ParseIP(g.IP).To4()
+		if ip == nil {
+			return fmt.Errorf("guests[%d]: ip %q is not an IPv4 address", i, g.IP)
+		}
+		if subnet != nil && !subnet.Contains(ip) {
+			return fmt.Errorf("guests[%d]: ip %s is outside subnet %s", i, g.IP, config.Subnet)
+		}
+		if owner, ok := takenIPs[ip.String()]; ok {
+			return fmt.Errorf("guests[%d]: ip %s is already used by %s", i, g.IP, owner)
+		}
+		takenIPs[ip.String()] = fmt.Sprintf("guests[%d]", i)
+
+		var mac net.HardwareAddr
+		if g.MAC == "" {
+			if mac, err = generateMAC(prefix); err != nil {
+				return fmt.Errorf("guests[%d]: generate mac: %w", i, err)
+			}
+		} else {
+			if mac, err = net.ParseMAC(g.MAC); err != nil || len(mac) != 6 {
+				return fmt.Errorf("guests[%d]: invalid mac %q: want a 48-bit MAC", i, g.MAC)
+			}
+			if err := checkUnicastLocal(mac); err != nil {
+				return fmt.Errorf("guests[%d]: mac %q %v", i, g.MAC, err)
+			}
+		}
+		if owner, ok := takenMACs[mac.String()]; ok {
+			return fmt.Errorf("guests[%d]: mac %s is already used by %s", i, mac, owner)
+		}
+		takenMACs[mac.String()] = fmt.Sprintf("guests[%d]", i)
+
+		for _, pm := range g.PortMappings {
+			if pm.GuestIP != "" && pm.GuestIP != ip.String() {
+				return fmt.Errorf("guests[%d]: port mapping %d -> %s:%d targets another guest", i, pm.HostPort, pm.GuestIP, pm.GuestPort)
+			}
+			pm.GuestIP = ip.String()
+			config.PortMappings = append(config.PortMappings, pm)
+		}
+		if g.NATDisabled && !slices.Contains(config.NATDisabledGuests, ip.String()) {
+			config.NATDisabledGuests = append(config.NATDisabledGuests, ip.String())
+		}
+		guests = append(guests, Guest{IP: ip.String(), MAC: mac.String()})
+	}
+	config.Guests = guests
+	return nil
+}
+
+// guestIPs returns the addresses of guests.
+func guestIPs(config GvproxyConfig) []string {
+	ips := make([]string, 0, len(config.Guests))
+	for _, g := range config.Guests {
+		ips = append(ips, g.IP)
+	}
+	return ips
+}
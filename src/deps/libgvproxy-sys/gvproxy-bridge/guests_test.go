@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/tap"
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestResolveGuests(t *testing.T) {
+	config := testGvproxyConfig()
+	config.PortMappings = []PortMapping{{HostPort: 8080, GuestPort: 80}}
+	config.Guests = []Guest{
+		{IP: "192.168.127.3", MAC: "5A:94:EF:00:00:03", PortMappings: []PortMapping{{HostPort: 8081, GuestPort: 80}}},
+		{IP: "192.168.127.4", NATDisabled: true, PortMappings: []PortMapping{{HostPort: 8082, GuestPort: 80, GuestIP: "192.168.127.4"}}},
+	}
+	if err := resolveGuests(&config); err != nil {
+		t.Fatalf("resolveGuests: %v", err)
+	}
+
+	if config.Guests[0].MAC != "5a:94:ef:00:00:03" {
+		t.Errorf("mac = %q, want it normalized", config.Guests[0].MAC)
+	}
+	if mac, err := net.ParseMAC(config.Guests[1].MAC); err != nil || !strings.HasPrefix(mac.String(), defaultMACPrefix) {
+		t.Errorf("mac = %q, want one generated from the default prefix", config.Guests[1].MAC)
+	}
+	var targets []string
+	for _, pm := range config.PortMappings {
+		targets = append(targets, pm.GuestIP)
+	}
+	if got := strings.Join(targets, ","); got != ",192.168.127.3,192.168.127.4" {
+		t.Errorf("port mapping targets = %q, want the guests' mappings appended", got)
+	}
+	if len(config.NATDisabledGuests) != 1 || config.NATDisabledGuests[0] != "192.168.127.4" {
+		t.Errorf("nat_disabled_guests = %v, want the second guest", config.NATDisabledGuests)
+	}
+	for _, g := range config.Guests {
+		if g.NATDisabled || g.PortMappings != nil {
+			t.Errorf("guest %s keeps %+v, want only its addresses", g.IP, g)
+		}
+	}
+	if err := checkGuestAliases(config); err != nil {
+		t.Errorf("checkGuestAliases: %v", err)
+	}
+
+	for name, guest := range map[string]Guest{
+		"not an IP":      {IP: "guest"},
+		"outside subnet": {IP: "10.0.0.3"},
+		"gateway":        {IP: "192.168.127.1"},
+		"guest_ip":       {IP: "192.168.127.2"},
+		"guest_mac":      {IP: "192.168.127.3", MAC: "5a:94:ef:e4:0c:ee"},
+		"bad mac":        {IP: "192.168.127.3", MAC: "01:00:5e:00:00:03"},
+		"other target":   {IP: "192.168.127.3", PortMappings: []PortMapping{{HostPort: 8081, GuestPort: 80, GuestIP: "192.168.127.2"}}},
+	} {
+		config := testGvproxyConfig()
+		config.Guests = []Guest{guest}
+		if err := resolveGuests(&config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	config = testGvproxyConfig()
+	config.Guests = []Guest{{IP: "192.168.127.3"}, {IP: "192.168.127.3"}}
+	if err := resolveGuests(&config); err == nil {
+		t.Error("duplicate guest ip: expected an error")
+	}
+}
+
+func TestDHCPReply_OffersGuestLeases(t *testing.T) {
+	config := testGvproxyConfig()
+	config.Guests = []Guest{{IP: "192.168.127.3", MAC: "5a:94:ef:00:00:03"}}
+	if err := resolveGuests(&config); err != nil {
+		t.Fatalf("resolveGuests: %v", err)
+	}
+	tapConfig := buildTapConfig(config, types.QemuProtocol)
+	if tapConfig.DHCPStaticLeases["192.168.127.3"] != "5a:94:ef:00:00:03" {
+		t.Fatalf("static leases = %v, want the guest's", tapConfig.DHCPStaticLeases)
+	}
+	_, subnet, _ := net.ParseCIDR(config.Subnet)
+	pool := tap.NewIPPool(subnet)
+	for ip, mac := range tapConfig.DHCPStaticLeases {
+		pool.Reserve(net.ParseIP(ip), mac)
+	}
+
+	for mac, want := range map[string]string{config.GuestMac: config.GuestIP, "5a:94:ef:00:00:03": "192.168.127.3"} {
+		hw, _ := net.ParseMAC(mac)
+		discover, err := dhcpv4.NewDiscovery(hw)
+		if err != nil {
+			t.Fatalf("NewDiscovery: %v", err)
+		}
+		reply := dhcpReply(tapConfig, pool, nil, nil, nil, discover)
+		if reply == nil || !reply.YourIPAddr.Equal(net.ParseIP(want)) {
+			t.Errorf("%s: reply = %v, want an offer of %s", mac, reply, want)
+		}
+	}
+}
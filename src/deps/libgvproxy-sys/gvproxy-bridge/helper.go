@@ -0,0 +1,332 @@
+package main
+
+// helper.go — Run the bridge as a separate helper process.
+//
+// Linked into the Rust process, the Go runtime shares signals and threads
+// with it, and some embedders hit conflicts between the two runtimes (signal
+// handlers installed after Go's, seccomp filters, fork without exec). Built
+// as an executable (go build -o gvproxy-bridge .) instead of a c-archive,
+// the bridge runs as a helper process serving the same operations over a
+// Unix control socket:
+//
+//	gvproxy-bridge -socket /run/boxlite/gvproxy-helper.sock
+//
+// The helper binds the socket, prints "ready" on stdout, and serves one JSON
+// request per line on each connection: {"id": 1, "method": "create",
+// "params": {...}}, answered by {"id": 1, "result": ...} or {"id": 1,
+// "error": "..."}. Events are pushed to every connection as {"event": {...}}
+// (the gvproxy_set_event_callback document) from a bounded queue per
+// connection, so a client that does not read them never blocks the
+// network; when its queue is full, events are dropped and the count sent
+// ahead of the next one as {"dropped": 12}. Logs go to stderr.
+//
+// Methods: create (params: the gvproxy_create config; result: id),
+// create_from_template ({"template", "overrides"}), destroy ({"id"}), stats
+// ({"id", "format"}; result: the document as a string), expose and
+// unexpose ({"id", "mapping"}, mapping as for gvproxy_expose),
+// dns_add_record and dns_remove_record ({"id", "record"}, record as for
+// gvproxy_dns_add_record), version, set_limits (the gvproxy_set_limits
+// document), set_stream_sink (the gvproxy_set_stream_sink document, e.g. to
+// send logs to the parent's named pipe instead of stderr) and selftest. Exports that hand FDs to the
+// caller (gvproxy_dial_guest, gvproxy_listen_guest,
+// gvproxy_export_listeners) have no helper equivalent.
+//
+// The helper is supervised through its stdin: when the parent closes it (or
+// dies), every instance is destroyed and the helper exits.
+
+import "C"
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// helperEventQueueSize bounds the events waiting for one helper connection.
+const helperEventQueueSize = 1024
+
+// helperRequest is one request line on the helper socket.
+type helperRequest struct {
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// helperResponse answers a helperRequest, or pushes an event or a count of
+// dropped ones (ID 0).
+type helperResponse struct {
+	ID      uint64 `json:"id,omitempty"`
+	Result  any    `json:"result,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Event   *Event `json:"event,omitempty"`
+	Dropped uint64 `json:"dropped,omitempty"`
+}
+
+// helperConn is a client connection; writes are serialized because events
+// are written by the connection's event goroutine.
+type helperConn struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+
+	events  chan Event
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+func newHelperConn(w io.Writer) *helperConn {
+	return &helperConn{
+		enc:    json.NewEncoder(w),
+		events: make(chan Event, helperEventQueueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+func (c *helperConn) send(resp helperResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(resp)
+}
+
+// pushEvent queues event, dropping it if the queue is full.
+func (c *helperConn) pushEvent(event Event) {
+	select {
+	case c.events <- event:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// runEvents writes queued events until the connection ends, each preceded
+// by the count of events dropped since the last one written.
+func (c *helperConn) runEvents() {
+	for {
+		var event Event
+		select {
+		case <-c.done:
+			return
+		case event = <-c.events:
+		}
+		if n := c.dropped.Swap(0); n > 0 {
+			if c.send(helperResponse{Dropped: n}) != nil {
+				return
+			}
+		}
+		if c.send(helperResponse{Event: &event}) != nil {
+			return
+		}
+	}
+}
+
+// helperServer tracks connections for event fan-out.
+type helperServer struct {
+	mu    sync.Mutex
+	conns map[*helperConn]bool
+}
+
+func (s *helperServer) broadcast(event Event) {
+	s.mu.Lock()
+	conns := make([]*helperConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.pushEvent(event)
+	}
+}
+
+func (s *helperServer) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *helperServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	c := newHelperConn(conn)
+	go c.runEvents()
+	defer close(c.done)
+	s.mu.Lock()
+	s.conns[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var req helperRequest
+		if err := dec.Decode(&req); err != nil {
+			if !errors.Is(err, io.EOF) {
+				logrus.WithError(err).Warn("Helper: bad request, closing connection")
+			}
+			return
+		}
+		resp := helperResponse{ID: req.ID}
+		if result, err := handleHelperRequest(req); err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Result = result
+		}
+		if err := c.send(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handleHelperRequest runs one helper method.
+func handleHelperRequest(req helperRequest) (any, error) {
+	switch req.Method {
+	case "create":
+		var config GvproxyConfig
+		if err := json.Unmarshal(req.Params, &config); err != nil {
+			return nil, err
+		}
+		return createInstance(config)
+	case "create_from_template":
+		var params struct {
+			Template  json.RawMessage `json:"template"`
+			Overrides json.RawMessage `json:"overrides"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		config, err := templateConfig(params.Template, params.Overrides)
+		if err != nil {
+			return nil, err
+		}
+		return createInstance(config)
+	case "destroy":
+		var params struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if destroyInstance(params.ID) != 0 {
+			return nil, fmt.Errorf("instance %d not found", params.ID)
+		}
+		return true, nil
+	case "stats":
+		var params struct {
+			ID     int64  `json:"id"`
+			Format string `json:"format"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if params.Format == "" {
+			params.Format = StatsFormatJSON
+		}
+		stats := instanceStats(C.longlong(params.ID), params.Format)
+		if stats == "" {
+			return nil, fmt.Errorf("instance %d not found or not initialized", params.ID)
+		}
+		return stats, nil
+	case "expose", "unexpose":
+		var params struct {
+			ID      int64         `json:"id"`
+			Mapping exposeRequest `json:"mapping"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if req.Method == "expose" {
+			return true, exposePort(params.ID, params.Mapping.PortMapping, params.Mapping.DryRun)
+		}
+		return true, unexposePort(params.ID, params.Mapping.PortMapping)
+	case "dns_add_record", "dns_remove_record":
+		var params struct {
+			ID     int64            `json:"id"`
+			Record dnsRecordRequest `json:"record"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return true, changeDNSRecord(params.ID, req.Method, params.Record)
+	case "version":
+		return gvisorTapVsockVersion(), nil
+	case "set_limits":
+		var l Limits
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &l); err != nil {
+				return nil, err
+			}
+		}
+		return true, setLimits(l)
+	case "set_stream_sink":
+		var cfg StreamSinkConfig
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &cfg); err != nil {
+				return nil, err
+			}
+		}
+		return true, setStreamSink(cfg)
+	case "selftest":
+		return runSelftest(), nil
+	}
+	return nil, fmt.Errorf("unknown method %q", req.Method)
+}
+
+// destroyAllInstances tears down every instance, for helper shutdown.
+func destroyAllInstances() {
+	instancesMu.RLock()
+	ids := make([]int64, 0, len(instances))
+	for id := range instances {
+		ids = append(ids, id)
+	}
+	instancesMu.RUnlock()
+	for _, id := range ids {
+		destroyInstance(id)
+	}
+}
+
+// runHelper runs helper mode until supervisor (the parent's end of stdin)
+// closes, and returns the process exit code.
+func runHelper(args []string, supervisor io.Reader, ready io.Writer) int {
+	fs := flag.NewFlagSet("gvproxy-bridge", flag.ContinueOnError)
+	socketPath := fs.String("socket", "", "Unix socket to serve helper requests on")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *socketPath == "" {
+		fmt.Fprintln(os.Stderr, "gvproxy-bridge: -socket is required (this binary runs as a boxlite helper process)")
+		return 2
+	}
+
+	os.Remove(*socketPath)
+	l, err := net.Listen("unix", *socketPath)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "path": *socketPath}).Error("Helper: failed to bind control socket")
+		return 1
+	}
+	defer l.Close()
+
+	s := &helperServer{conns: make(map[*helperConn]bool)}
+	sink := s.broadcast
+	eventSink.Store(&sink)
+	defer eventSink.Store(nil)
+	go s.serve(l)
+
+	logrus.WithField("path", *socketPath).Info("Helper: serving")
+	fmt.Fprintln(ready, "ready")
+
+	io.Copy(io.Discard, supervisor)
+	logrus.Info("Helper: supervisor gone, shutting down")
+	destroyAllInstances()
+	return 0
+}
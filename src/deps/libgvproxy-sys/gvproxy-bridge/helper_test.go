@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandleHelperRequest_Errors(t *testing.T) {
+	if _, err := handleHelperRequest(helperRequest{Method: "bogus"}); err == nil {
+		t.Error("unknown method succeeded")
+	}
+	if _, err := handleHelperRequest(helperRequest{Method: "destroy", Params: json.RawMessage(`{"id": 987654}`)}); err == nil {
+		t.Error("destroying a missing instance succeeded")
+	}
+	if _, err := handleHelperRequest(helperRequest{Method: "create", Params: json.RawMessage(`[]`)}); err == nil {
+		t.Error("create with a non-object config succeeded")
+	}
+}
+
+// TestHelperServer_DropsEventsForSlowClient broadcasts to a client that
+// reads nothing: broadcasting must not block, and once the client reads,
+// every event is either delivered or counted as dropped.
+func TestHelperServer_DropsEventsForSlowClient(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := newHelperConn(server)
+	go c.runEvents()
+	defer close(c.done)
+	s := &helperServer{conns: map[*helperConn]bool{c: true}}
+
+	const total = 3 * helperEventQueueSize
+	broadcasted := make(chan struct{})
+	go func() {
+		for i := 0; i < total; i++ {
+			s.broadcast(Event{Type: "test", InstanceID: int64(i)})
+		}
+		close(broadcasted)
+	}()
+	select {
+	case <-broadcasted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("broadcast blocked on a client that does not read")
+	}
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	dec := json.NewDecoder(client)
+	var events, dropped uint64
+	for events+dropped < total {
+		var resp helperResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("after %d events and %d dropped: %v", events, dropped, err)
+		}
+		if resp.Event != nil {
+			events++
+		}
+		dropped += resp.Dropped
+	}
+	if dropped == 0 || events > helperEventQueueSize+1 {
+		t.Fatalf("%d events delivered, %d dropped", events, dropped)
+	}
+}
+
+func TestRunHelper_ServesUntilSupervisorCloses(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "helper.sock")
+	supervisor, stdin := io.Pipe()
+	readyR, readyW := io.Pipe()
+
+	exited := make(chan int, 1)
+	go func() { exited <- runHelper([]string{"-socket", socketPath}, supervisor, readyW) }()
+
+	line, err := bufio.NewReader(readyR).ReadString('\n')
+	if err != nil || line != "ready\n" {
+		t.Fatalf("ready line = %q, %v", line, err)
+	}
+	go io.Copy(io.Discard, readyR)
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(`{"id": 7, "method": "version"}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	var resp helperResponse
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != 7 || resp.Error != "" || resp.Result == "" {
+		t.Fatalf("version response = %+v", resp)
+	}
+
+	stdin.Close()
+	select {
+	case code := <-exited:
+		if code != 0 {
+			t.Fatalf("runHelper exited %d, want 0", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runHelper did not exit after its supervisor closed stdin")
+	}
+}
+
+func TestRunHelper_RequiresSocket(t *testing.T) {
+	if code := runHelper(nil, nil, io.Discard); code != 2 {
+		t.Fatalf("runHelper without -socket = %d, want 2", code)
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+// host_route.go — Reach the guest from the host by IP, without forwards.
+//
+// Port mappings publish one guest port at a time. For development it is
+// often simpler to talk to the guest directly: ssh to its address, hit any
+// port a dev server happened to pick. With host_route set, the bridge plugs
+// a tun device on the host into the virtual switch as a second station, and
+// a route for the virtual subnet through that tun carries host traffic
+// straight to the guest.
+//
+// Creating the tun and the route needs privileges the bridge does not have,
+// so a privileged component does it and hands the result over: either the
+// name of a persistent tun device the bridge's user may attach to (Linux,
+// tun_name), or an open tun fd (tun_fd; a utun fd on macOS), owned by the
+// instance from gvproxy_create on. gvproxy_host_route_plan returns the
+// commands that set this up for a given config.
+//
+// On the switch the tun is a station with its own MAC and address (host
+// route address, reserved in the DHCP pool): it answers ARP for the address,
+// resolves guest addresses over ARP, wraps IP packets from the tun into
+// frames and unwraps frames sent to its MAC. IPv4 only.
+
+import "C"
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// hostRouteARPInterval limits ARP requests for one unresolved address.
+const hostRouteARPInterval = time.Second
+
+// hostTunFamilyIPv4 is AF_INET in the family header of utun packets.
+const hostTunFamilyIPv4 = 2
+
+// HostRoute plugs a host tun device into the virtual network.
+type HostRoute struct {
+	// Address is the host's address on the virtual subnet, the source of
+	// its traffic to the guest. Required.
+	Address string `json:"address"`
+	// TunName attaches to this persistent tun device (Linux).
+	TunName string `json:"tun_name,omitempty"`
+	// TunFD is an open tun device, owned by the instance from
+	// gvproxy_create on.
+	TunFD *int `json:"tun_fd,omitempty"`
+	// MAC of the tun's station on the switch. Empty => generated.
+	MAC string `json:"mac,omitempty"`
+}
+
+// checkHostRouteAddress validates host_route.address against the subnet
+// and the addresses already in use.
+func checkHostRouteAddress(config GvproxyConfig) error {
+	hr := config.HostRoute
+	ip := net.ParseIP(hr.Address).To4()
+	if ip == nil {
+		return fmt.Errorf("host_route address %q is not an IPv4 address", hr.Address)
+	}
+	_, subnet, err := net.ParseCIDR(config.Subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet %q: %w", config.Subnet, err)
+	}
+	if !subnet.Contains(ip) {
+		return fmt.Errorf("host_route address %s is outside subnet %s", hr.Address, config.Subnet)
+	}
+	taken := append([]string{config.GatewayIP, config.HostIP, config.GuestIP}, config.GuestAliases...)
+	taken = append(taken, guestIPs(config)...)
+	for _, addr := range taken {
+		if ip.Equal(net.ParseIP(addr)) {
+			return fmt.Errorf("host_route address %s is already in use", hr.Address)
+		}
+	}
+	return nil
+}
+
+// resolveHostRoute validates host_route and generates its MAC if unset.
+func resolveHostRoute(config *GvproxyConfig) error {
+	hr := config.HostRoute
+	if hr == nil {
+		return nil
+	}
+	if err := checkHostRouteAddress(*config); err != nil {
+		return err
+	}
+	switch {
+	case hr.TunFD == nil && hr.TunName == "":
+		return errors.New("host_route needs tun_name or tun_fd (see gvproxy_host_route_plan)")
+	case hr.TunFD != nil && *hr.TunFD < 0:
+		return fmt.Errorf("invalid host_route tun_fd %d", *hr.TunFD)
+	}
+	if err := checkHostTun(*hr); err != nil {
+		return err
+	}
+
+	if hr.MAC == "" {
+		prefix, err := parseMACPrefix(config.MACPrefix)
+		if err != nil {
+			return err
+		}
+		mac, err := generateMAC(prefix)
+		if err != nil {
+			return fmt.Errorf("generate host_route mac: %w", err)
+		}
+		hr.MAC = mac.String()
+	} else {
+		mac, err := net.ParseMAC(hr.MAC)
+		if err != nil || len(mac) != 6 {
+			return fmt.Errorf("invalid host_route mac %q: want a 48-bit MAC", hr.MAC)
+		}
+		if err := checkUnicastLocal(mac); err != nil {
+			return fmt.Errorf("host_route mac %q %v", hr.MAC, err)
+		}
+		hr.MAC = mac.String()
+	}
+	if hr.MAC == config.GatewayMac || hr.MAC == config.GuestMac {
+		return fmt.Errorf("host_route mac %s is already in use", hr.MAC)
+	}
+	for _, g := range config.Guests {
+		if hr.MAC == g.MAC {
+			return fmt.Errorf("host_route mac %s is already in use", hr.MAC)
+		}
+	}
+	return nil
+}
+
+// hostRoutePort is the tun's station on the virtual switch. It is the
+// net.Conn the switch reads frames from and writes frames to, one frame per
+// call (the bess framing).
+type hostRoutePort struct {
+	tun    io.ReadWriteCloser
+	prefix int // bytes ahead of each packet on the tun (the utun family)
+	mac    net.HardwareAddr
+	ip     net.IP
+	subnet *net.IPNet
+
+	frames    chan []byte // to the switch
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu        sync.Mutex
+	neighbors map[[4]byte]net.HardwareAddr
+	asked     map[[4]byte]time.Time // last ARP request per unresolved address
+
+	toGuest    atomic.Uint64
+	fromGuest  atomic.Uint64
+	unresolved atomic.Uint64
+}
+
+// openHostRoute opens the tun of config's host_route, or returns nil if
+// there is none.
+func openHostRoute(config GvproxyConfig) (*hostRoutePort, error) {
+	hr := config.HostRoute
+	if hr == nil {
+		return nil, nil
+	}
+	tun, prefix, err := openHostTun(*hr)
+	if err != nil {
+		return nil, err
+	}
+	_, subnet, _ := net.ParseCIDR(config.Subnet)
+	mac, _ := net.ParseMAC(hr.MAC)
+	p := &hostRoutePort{
+		tun:       tun,
+		prefix:    prefix,
+		mac:       mac,
+		ip:        net.ParseIP(hr.Address).To4(),
+		subnet:    subnet,
+		frames:    make(chan []byte, 64),
+		closed:    make(chan struct{}),
+		neighbors: make(map[[4]byte]net.HardwareAddr),
+		asked:     make(map[[4]byte]time.Time),
+	}
+	if guest := net.ParseIP(config.GuestIP).To4(); guest != nil {
+		if guestMAC, err := net.ParseMAC(config.GuestMac); err == nil {
+			p.neighbors[[4]byte(guest)] = guestMAC
+		}
+	}
+	return p, nil
+}
+
+// serve reads packets from the tun until the port is closed.
+func (p *hostRoutePort) serve() {
+	buf := make([]byte, p.prefix+maxStreamFrameSize)
+	for {
+		n, err := p.tun.Read(buf)
+		if err != nil {
+			p.Close()
+			return
+		}
+		p.fromTun(buf[:n])
+	}
+}
+
+// fromTun frames an IPv4 packet read from the tun for the guest.
+func (p *hostRoutePort) fromTun(packet []byte) {
+	if len(packet) < p.prefix+header.IPv4MinimumSize {
+		return
+	}
+	ip := header.IPv4(packet[p.prefix:])
+	if !ip.IsValid(len(ip)) {
+		return
+	}
+	dst := ip.DestinationAddress().As4()
+	if !p.subnet.Contains(dst[:]) {
+		return
+	}
+	p.mu.Lock()
+	mac, ok := p.neighbors[dst]
+	p.mu.Unlock()
+	if !ok {
+		p.unresolved.Add(1)
+		p.resolve(dst)
+		return
+	}
+	ip = ip[:ip.TotalLength()]
+	frame := make([]byte, header.EthernetMinimumSize+len(ip))
+	header.Ethernet(frame).Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress(p.mac),
+		DstAddr: tcpip.LinkAddress(mac),
+		Type:    header.IPv4ProtocolNumber,
+	})
+	copy(frame[header.EthernetMinimumSize:], ip)
+	if p.send(frame) {
+		p.toGuest.Add(1)
+	}
+}
+
+// resolve asks who has dst, at most once per hostRouteARPInterval. The
+// packet that needed it is dropped; its sender retransmits.
+func (p *hostRoutePort) resolve(dst [4]byte) {
+	now := time.Now()
+	p.mu.Lock()
+	if now.Sub(p.asked[dst]) < hostRouteARPInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.asked[dst] = now
+	p.mu.Unlock()
+	p.send(arpRequestFrame(p.mac, net.HardwareAddr(header.EthernetBroadcastAddress), p.ip, dst[:]))
+}
+
+// send queues a frame for the switch. It reports false once the port is
+// closed.
+func (p *hostRoutePort) send(frame []byte) bool {
+	select {
+	case p.frames <- frame:
+		return true
+	case <-p.closed:
+		return false
+	}
+}
+
+// learn records the MAC of a station on the subnet.
+func (p *hostRoutePort) learn(ip net.IP, mac net.HardwareAddr) {
+	if len(ip) != 4 || !p.subnet.Contains(ip) || ip.Equal(p.ip) {
+		return
+	}
+	p.mu.Lock()
+	p.neighbors[[4]byte(ip)] = append(net.HardwareAddr(nil), mac...)
+	delete(p.asked, [4]byte(ip))
+	p.mu.Unlock()
+}
+
+// Read returns the next frame for the switch.
+func (p *hostRoutePort) Read(b []byte) (int, error) {
+	select {
+	case frame := <-p.frames:
+		return copy(b, frame), nil
+	case <-p.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+// Write takes a frame from the switch: ARP is answered and learned from,
+// IPv4 sent to the port's MAC goes to the tun, anything else is ignored.
+func (p *hostRoutePort) Write(frame []byte) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if arp := frameARP(frame); arp != nil {
+		sender := net.IP(arp.ProtocolAddressSender())
+		p.learn(sender, arp.HardwareAddressSender())
+		if arp.Op() == header.ARPRequest && net.IP(arp.ProtocolAddressTarget()).Equal(p.ip) {
+			p.send(p.arpReply(arp))
+		}
+		return len(frame), nil
+	}
+	eth := header.Ethernet(frame)
+	ip := frameIPv4(frame)
+	if ip == nil || string(eth.DestinationAddress()) != string(p.mac) {
+		return len(frame), nil
+	}
+	src := ip.SourceAddress().As4()
+	p.learn(src[:], net.HardwareAddr(eth.SourceAddress()))
+	packet := make([]byte, p.prefix+int(ip.TotalLength()))
+	if p.prefix == 4 {
+		binary.BigEndian.PutUint32(packet, hostTunFamilyIPv4)
+	}
+	copy(packet[p.prefix:], ip[:ip.TotalLength()])
+	if _, err := p.tun.Write(packet); err != nil {
+		logrus.WithError(err).Debug("host_route: write to tun failed")
+		return len(frame), nil
+	}
+	p.fromGuest.Add(1)
+	return len(frame), nil
+}
+
+// arpReply answers an ARP request for the port's address.
+func (p *hostRoutePort) arpReply(req header.ARP) []byte {
+	frame := make([]byte, 60)
+	header.Ethernet(frame).Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress(p.mac),
+		DstAddr: tcpip.LinkAddress(req.HardwareAddressSender()),
+		Type:    header.ARPProtocolNumber,
+	})
+	arp := header.ARP(frame[header.EthernetMinimumSize:])
+	arp.SetIPv4OverEthernet()
+	arp.SetOp(header.ARPReply)
+	copy(arp.HardwareAddressSender(), p.mac)
+	copy(arp.ProtocolAddressSender(), p.ip)
+	copy(arp.HardwareAddressTarget(), req.HardwareAddressSender())
+	copy(arp.ProtocolAddressTarget(), req.ProtocolAddressSender())
+	return frame
+}
+
+// Close closes the tun and ends the port. Safe to call more than once.
+func (p *hostRoutePort) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		err = p.tun.Close()
+	})
+	return err
+}
+
+func (p *hostRoutePort) LocalAddr() net.Addr              { return hostRouteAddr{p.ip} }
+func (p *hostRoutePort) RemoteAddr() net.Addr             { return hostRouteAddr{p.ip} }
+func (p *hostRoutePort) SetDeadline(time.Time) error      { return nil }
+func (p *hostRoutePort) SetReadDeadline(time.Time) error  { return nil }
+func (p *hostRoutePort) SetWriteDeadline(time.Time) error { return nil }
+
+// hostRouteAddr names the tun's station in the switch's logs.
+type hostRouteAddr struct{ ip net.IP }
+
+func (hostRouteAddr) Network() string  { return "host_route" }
+func (a hostRouteAddr) String() string { return "host_route/" + a.ip.String() }
+
+// HostRouteStats is the "HostRoute" stats section.
+type HostRouteStats struct {
+	Address    string `json:"Address"`
+	ToGuest    uint64 `json:"ToGuest"`    // packets from the tun sent to the guest
+	FromGuest  uint64 `json:"FromGuest"`  // packets from the guest written to the tun
+	Unresolved uint64 `json:"Unresolved"` // packets dropped awaiting ARP
+}
+
+// hostRouteCollector publishes host route counters as the "HostRoute"
+// section.
+type hostRouteCollector struct{ p *hostRoutePort }
+
+func (hostRouteCollector) Name() string { return "HostRoute" }
+
+func (hostRouteCollector) Description() string {
+	return "Packets between the host route tun and the guest, and packets dropped while the guest's MAC was being resolved."
+}
+
+func (c hostRouteCollector) Collect() any {
+	return HostRouteStats{
+		Address:    c.p.ip.String(),
+		ToGuest:    c.p.toGuest.Load(),
+		FromGuest:  c.p.fromGuest.Load(),
+		Unresolved: c.p.unresolved.Load(),
+	}
+}
+
+// HostRoutePlan is the document returned by gvproxy_host_route_plan.
+type HostRoutePlan struct {
+	TunName  string   `json:"tun_name"`
+	Address  string   `json:"address"`
+	Subnet   string   `json:"subnet"`
+	Commands []string `json:"commands"` // to run with privileges, in order
+}
+
+// hostRoutePlan returns the setup a privileged component performs for
+// config's host_route.
+func hostRoutePlan(config GvproxyConfig) (HostRoutePlan, error) {
+	if config.HostRoute == nil {
+		return HostRoutePlan{}, errors.New("config has no host_route")
+	}
+	if err := checkHostRouteAddress(config); err != nil {
+		return HostRoutePlan{}, err
+	}
+	name := config.HostRoute.TunName
+	mtu := int(config.MTU)
+	if mtu == 0 {
+		mtu = 1500
+	}
+	name, commands, err := hostRouteCommands(name, config.HostRoute.Address, config.Subnet, mtu)
+	if err != nil {
+		return HostRoutePlan{}, err
+	}
+	return HostRoutePlan{TunName: name, Address: config.HostRoute.Address, Subnet: config.Subnet, Commands: commands}, nil
+}
+
+// gvproxy_host_route_plan returns the commands a privileged component runs
+// so an instance created from configJSON can use its host_route:
+// {"tun_name": "boxlite0", "address": ..., "subnet": ..., "commands":
+// ["ip tuntap add dev boxlite0 mode tun user 1000", ...]}.
+//
+// Returns NULL if the config has no valid host_route or the platform has no
+// host routes. The string must be freed with gvproxy_free_string.
+//
+//export gvproxy_host_route_plan
+func gvproxy_host_route_plan(configJSON *C.char) *C.char {
+	if configJSON == nil {
+		return nil
+	}
+	var config GvproxyConfig
+	if err := json.Unmarshal([]byte(C.GoString(configJSON)), &config); err != nil {
+		logrus.WithError(err).Warn("gvproxy_host_route_plan: invalid config")
+		return nil
+	}
+	plan, err := hostRoutePlan(config)
+	if err != nil {
+		logrus.WithError(err).Warn("gvproxy_host_route_plan: no plan")
+		return nil
+	}
+	out, err := json.Marshal(plan)
+	if err != nil {
+		return nil
+	}
+	return returnCString(string(out))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// checkHostTun validates how host_route names its tun. utun devices are
+// created by opening a control socket, which needs root, so only a handed
+// over fd works; tun_name then just names it.
+func checkHostTun(hr HostRoute) error {
+	if hr.TunFD == nil {
+		return errors.New("host_route on macOS needs tun_fd, an open utun device")
+	}
+	return nil
+}
+
+// openHostTun wraps the utun fd of hr. utun packets start with a 4-byte
+// address family.
+func openHostTun(hr HostRoute) (io.ReadWriteCloser, int, error) {
+	if err := setNonblock(*hr.TunFD); err != nil {
+		return nil, 0, fmt.Errorf("host_route tun_fd: %w", err)
+	}
+	return os.NewFile(uintptr(*hr.TunFD), "host-route-utun"), 4, nil
+}
+
+// hostRouteCommands returns the commands that address the utun name and
+// route the subnet through it. name must be the utun the privileged
+// component created.
+func hostRouteCommands(name, address, subnet string, mtu int) (string, []string, error) {
+	if name == "" {
+		return "", nil, errors.New("host_route tun_name must name the utun device on macOS")
+	}
+	return name, []string{
+		fmt.Sprintf("ifconfig %s inet %s %s mtu %d up", name, address, address, mtu),
+		fmt.Sprintf("route -n add -net %s -interface %s", subnet, name),
+	}, nil
+}
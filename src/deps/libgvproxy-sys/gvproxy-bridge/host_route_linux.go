@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// defaultHostRouteTun is the tun device gvproxy_host_route_plan sets up
+// when the config names none.
+const defaultHostRouteTun = "boxlite0"
+
+// checkHostTun validates how host_route names its tun.
+func checkHostTun(hr HostRoute) error {
+	if hr.TunFD != nil && hr.TunName != "" {
+		return errors.New("host_route takes tun_name or tun_fd, not both")
+	}
+	if len(hr.TunName) >= syscall.IFNAMSIZ {
+		return fmt.Errorf("host_route tun_name %q is longer than %d bytes", hr.TunName, syscall.IFNAMSIZ-1)
+	}
+	return nil
+}
+
+// openHostTun opens the tun of hr. Linux tun packets carry no header when
+// the device is set up without packet info (IFF_NO_PI).
+func openHostTun(hr HostRoute) (io.ReadWriteCloser, int, error) {
+	fd := -1
+	if hr.TunFD != nil {
+		fd = *hr.TunFD
+	} else {
+		var err error
+		if fd, err = syscall.Open("/dev/net/tun", syscall.O_RDWR|syscall.O_CLOEXEC, 0); err != nil {
+			return nil, 0, fmt.Errorf("open /dev/net/tun: %w", err)
+		}
+		var req struct {
+			name  [syscall.IFNAMSIZ]byte
+			flags uint16
+			_     [22]byte
+		}
+		copy(req.name[:], hr.TunName)
+		req.flags = syscall.IFF_TUN | syscall.IFF_NO_PI
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TUNSETIFF, uintptr(unsafe.Pointer(&req))); errno != 0 {
+			syscall.Close(fd)
+			return nil, 0, fmt.Errorf("attach to tun %q: %w (create it with gvproxy_host_route_plan's commands)", hr.TunName, errno)
+		}
+	}
+	if err := setNonblock(fd); err != nil {
+		if hr.TunFD == nil {
+			syscall.Close(fd)
+		}
+		return nil, 0, fmt.Errorf("host_route tun: %w", err)
+	}
+	return os.NewFile(uintptr(fd), "host-route-tun"), 0, nil
+}
+
+// hostRouteCommands returns the iproute2 commands that create the tun for
+// this process's user, address it and route the subnet through it.
+func hostRouteCommands(name, address, subnet string, mtu int) (string, []string, error) {
+	if name == "" {
+		name = defaultHostRouteTun
+	}
+	return name, []string{
+		fmt.Sprintf("ip tuntap add dev %s mode tun user %d", name, os.Getuid()),
+		fmt.Sprintf("ip link set dev %s mtu %d up", name, mtu),
+		fmt.Sprintf("ip addr add %s/32 dev %s", address, name),
+		fmt.Sprintf("ip route add %s dev %s src %s", subnet, name, address),
+	}, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestResolveHostRoute(t *testing.T) {
+	fd := 100
+	for _, hr := range []HostRoute{
+		{Address: "10.0.0.1", TunName: "boxlite0"},
+		{Address: "192.168.127.254", TunName: "boxlite0"}, // host_ip
+		{Address: "192.168.127.253"},
+		{Address: "192.168.127.253", TunName: "boxlite0", TunFD: &fd},
+	} {
+		config := testGvproxyConfig()
+		config.HostRoute = &hr
+		if err := resolveHostRoute(&config); err == nil {
+			t.Errorf("resolveHostRoute(%+v) accepted", hr)
+		}
+	}
+
+	config := testGvproxyConfig()
+	config.HostRoute = &HostRoute{Address: "192.168.127.253", TunName: "boxlite0"}
+	if err := resolveHostRoute(&config); err != nil || config.HostRoute.MAC == "" {
+		t.Fatalf("resolveHostRoute: mac %q, %v", config.HostRoute.MAC, err)
+	}
+	if got := buildTapConfig(config, "qemu").DHCPStaticLeases["192.168.127.253"]; got != config.HostRoute.MAC {
+		t.Fatalf("host_route address not reserved in the DHCP pool: %q", got)
+	}
+
+	plan, err := hostRoutePlan(config)
+	if err != nil {
+		t.Fatalf("hostRoutePlan: %v", err)
+	}
+	if last := plan.Commands[len(plan.Commands)-1]; last != "ip route add 192.168.127.0/24 dev boxlite0 src 192.168.127.253" {
+		t.Fatalf("route command = %q", last)
+	}
+}
+
+// readQemuFrame reads frames from the VM side of a qemu link until one
+// matches.
+func readQemuFrame(t *testing.T, conn net.Conn, match func([]byte) bool) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(conn, size); err != nil {
+			t.Fatalf("read frame: %v", err)
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(size))
+		if _, err := io.ReadFull(conn, frame); err != nil {
+			t.Fatalf("read frame: %v", err)
+		}
+		if match(frame) {
+			return frame
+		}
+	}
+}
+
+func writeQemuFrame(t *testing.T, conn net.Conn, frame []byte) {
+	t.Helper()
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(frame))), frame...)); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+}
+
+func TestStartInstance_HostRoute(t *testing.T) {
+	tun, err := socketpair(syscall.SOCK_DGRAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := vmSide(t, tun[1])
+	defer host.Close()
+
+	const address = "192.168.127.253"
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	config.Protocol = "qemu"
+	config.HostRoute = &HostRoute{Address: address, TunFD: &tun[0], MAC: "5a:94:ef:00:00:53"}
+	const id = 5924
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	vm, err := net.Dial("unix", config.SocketPath)
+	if err != nil {
+		t.Fatalf("dial VM socket: %v", err)
+	}
+	defer vm.Close()
+	resolveGatewayQemu(t, vm, config)
+	routeMAC, guestMAC := mustMAC(config.HostRoute.MAC), mustMAC(config.GuestMac)
+
+	// Host → guest: a packet written to the tun arrives framed for the guest.
+	toGuest := testIPv4Frame(header.UDPProtocolNumber, address, config.GuestIP, testUDPDatagram(40000, 8080, []byte("ping")))[header.EthernetMinimumSize:]
+	if _, err := host.Write(toGuest); err != nil {
+		t.Fatalf("write to tun: %v", err)
+	}
+	frame := readQemuFrame(t, vm, func(f []byte) bool {
+		return string(header.Ethernet(f).SourceAddress()) == string(routeMAC) && frameIPv4(f) != nil
+	})
+	if string(header.Ethernet(frame).DestinationAddress()) != string(guestMAC) || !bytes.Equal(frame[header.EthernetMinimumSize:], toGuest) {
+		t.Fatalf("guest got %x, want %x to %s", frame, toGuest, guestMAC)
+	}
+
+	// The guest resolves the host's address to the tun's station.
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	writeQemuFrame(t, vm, arpRequestFrame(guestMAC, broadcast, net.ParseIP(config.GuestIP).To4(), net.ParseIP(address).To4()))
+	reply := readQemuFrame(t, vm, func(f []byte) bool {
+		arp := frameARP(f)
+		return arp != nil && arp.Op() == header.ARPReply && net.IP(arp.ProtocolAddressSender()).Equal(net.ParseIP(address))
+	})
+	if got := net.HardwareAddr(frameARP(reply).HardwareAddressSender()); got.String() != routeMAC.String() {
+		t.Fatalf("ARP reply names %s, want %s", got, routeMAC)
+	}
+
+	// Guest → host: a frame to the station comes out of the tun unwrapped.
+	fromGuest := testIPv4Frame(header.UDPProtocolNumber, config.GuestIP, address, testUDPDatagram(8080, 40000, []byte("pong")))
+	header.Ethernet(fromGuest).Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress(guestMAC),
+		DstAddr: tcpip.LinkAddress(routeMAC),
+		Type:    header.IPv4ProtocolNumber,
+	})
+	writeQemuFrame(t, vm, fromGuest)
+	host.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	buf := make([]byte, 2048)
+	n, err := host.Read(buf)
+	if err != nil {
+		t.Fatalf("read from tun: %v", err)
+	}
+	if !bytes.Equal(buf[:n], fromGuest[header.EthernetMinimumSize:]) {
+		t.Fatalf("tun got %x, want %x", buf[:n], fromGuest[header.EthernetMinimumSize:])
+	}
+
+	instancesMu.RLock()
+	collectors := instances[id].stats.Collectors()
+	instancesMu.RUnlock()
+	for _, c := range collectors {
+		if stats, ok := c.Collect().(HostRouteStats); ok {
+			if stats.ToGuest != 1 || stats.FromGuest != 1 {
+				t.Fatalf("HostRoute stats = %+v", stats)
+			}
+			return
+		}
+	}
+	t.Fatal("no HostRoute stats section")
+}
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"io"
+	"runtime"
+)
+
+// errHostRouteUnsupported is returned where host routes are unavailable.
+var errHostRouteUnsupported = errors.New("host_route is not supported on " + runtime.GOOS)
+
+func checkHostTun(HostRoute) error {
+	return classify(createErrUnsupported, errHostRouteUnsupported)
+}
+
+func openHostTun(HostRoute) (io.ReadWriteCloser, int, error) {
+	return nil, 0, errHostRouteUnsupported
+}
+
+func hostRouteCommands(string, string, string, int) (string, []string, error) {
+	return "", nil, errHostRouteUnsupported
+}
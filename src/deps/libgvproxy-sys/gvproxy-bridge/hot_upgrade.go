@@ -0,0 +1,313 @@
+package main
+
+// hot_upgrade.go — Hand every instance over to a newly loaded bridge library.
+//
+// To upgrade the bridge in place, boxlite calls gvproxy_serialize_all on the
+// old library and passes the document to gvproxy_deserialize_all on the new
+// one. The document carries each instance's config plus dup'd FDs for its
+// sockets, so nothing the VM or host clients see is ever closed:
+//
+//   - the VM link: the accepted hypervisor connection (or, before the VM has
+//     connected, the listening socket), with any partially read stream frame;
+//   - the host forward listeners (as import_listeners, see listener_handoff.go).
+//
+// The VM keeps its MAC, IP and lease. What does not survive is netstack
+// state: TCP flows NATed through the old instance are reset and must be
+// re-established by the guest, and forwards added at runtime through the
+// services API are not carried over. A VM connecting in the instant the
+// handoff runs may have that first connection dropped.
+//
+// FDs in the document are close-on-exec; a caller handing them across exec
+// must clear the flag itself.
+
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// handoffVersion is bumped whenever the serialized document changes shape.
+const handoffVersion = 1
+
+// linkDetachTimeout bounds how long serialization waits for an instance's
+// link reader to finish the frame it is on.
+const linkDetachTimeout = 2 * time.Second
+
+// VMSocketFD is the hypervisor-side socket handed between libraries.
+type VMSocketFD struct {
+	FD int `json:"fd"`
+	// Connected is set when FD is the accepted connection (or a connected
+	// socket, see link_fd.go) rather than the listening socket (qemu, bess)
+	// or the bound datagram socket (vfkit).
+	Connected bool `json:"connected,omitempty"`
+	// Pending holds stream bytes the old instance read but did not deliver.
+	Pending []byte `json:"pending,omitempty"`
+}
+
+type handoffInstance struct {
+	ID     int64         `json:"id"`
+	Config GvproxyConfig `json:"config"`
+}
+
+type handoffState struct {
+	Version   int               `json:"version"`
+	Instances []handoffInstance `json:"instances"`
+}
+
+// handoffConfig strips the one-shot FD imports and trace ID from a config
+// before it is kept for a later handoff.
+func handoffConfig(config GvproxyConfig) GvproxyConfig {
+	config.ImportListeners = nil
+	config.ImportVMSocket = nil
+	config.LinkFD = nil
+	config.TraceID = ""
+	return config
+}
+
+// closeHandoffFDs closes every FD a config would hand over.
+func closeHandoffFDs(config GvproxyConfig) {
+	closeListenerFDs(config.ImportListeners)
+	closeVMSocketFD(config.ImportVMSocket)
+}
+
+func closeVMSocketFD(s *VMSocketFD) {
+	if s != nil {
+		closeFD(s.FD)
+	}
+}
+
+// setLink records the VM link once the hypervisor has connected.
+func (instance *GvproxyInstance) setLink(link *linkConn) {
+	instance.vnMu.Lock()
+	instance.link = link
+	instance.vnMu.Unlock()
+}
+
+// currentLink returns the VM link, or nil while the VM is not connected.
+func (instance *GvproxyInstance) currentLink() *linkConn {
+	instance.vnMu.RLock()
+	defer instance.vnMu.RUnlock()
+	return instance.link
+}
+
+// exportHandoff dups every socket of the instance into a config for its
+// replacement. The instance keeps running; the caller owns the FDs.
+func (instance *GvproxyInstance) exportHandoff() (GvproxyConfig, error) {
+	config := instance.config
+	if config.VsockListen != nil {
+		return config, fmt.Errorf("vsock links cannot be handed over")
+	}
+	if config.StdioFDs != nil {
+		return config, fmt.Errorf("stdio links cannot be handed over")
+	}
+
+	instance.vnMu.RLock()
+	link := instance.link
+	listener := instance.listener
+	instance.vnMu.RUnlock()
+
+	// vfkit serves an unconnected datagram socket; a connected one (see
+	// link_fd.go) is the link, like an accepted stream.
+	datagram := isDatagramProtocol(instance.Config.Protocol)
+	var vmSocket any = listener
+	connected := false
+	if datagram && instance.conn != nil {
+		vmSocket = instance.conn
+	} else if link != nil {
+		vmSocket, connected = link.Conn, true
+	}
+	fd, err := dupSocketFD(vmSocket)
+	if err != nil {
+		return config, fmt.Errorf("export VM socket: %w", err)
+	}
+	config.ImportVMSocket = &VMSocketFD{FD: fd, Connected: connected}
+
+	config.PortMappings = instance.portMappings()
+	if instance.dns != nil {
+		config.DNSZones = instance.dns.configZones()
+	}
+	if instance.nat != nil {
+		config.NATDisabledGuests = instance.nat.disabledGuests()
+	}
+	if instance.forwarder != nil {
+		fds, err := instance.forwarder.ExportListeners()
+		if err != nil {
+			closeVMSocketFD(config.ImportVMSocket)
+			return config, err
+		}
+		config.ImportListeners = fds
+	}
+	return config, nil
+}
+
+// detachForHandoff stops the instance without closing or unlinking its
+// sockets. It returns the stream bytes its link reader had not delivered.
+func (instance *GvproxyInstance) detachForHandoff() []byte {
+	instance.detached.Store(true)
+
+	instance.vnMu.RLock()
+	link := instance.link
+	instance.vnMu.RUnlock()
+
+	var pending []byte
+	if link != nil {
+		var err error
+		if pending, err = link.detach(linkDetachTimeout); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": instance.ID}).Warn("Handing over VM link mid-frame")
+		}
+	}
+	instance.Cancel()
+	return pending
+}
+
+// keepSocketPath stops a unix listener from unlinking its path on Close, for
+// listeners whose path a replacement instance now serves.
+func keepSocketPath(l net.Listener) {
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+}
+
+// importVMSocket adopts a VM socket FD exported by exportHandoff, taking
+// ownership of it. Exactly one of the returned values is set: the datagram
+// socket (vfkit), the listener (qemu or bess, VM not yet connected) or the
+// connected link (qemu or bess, or vfkit on a connected socket).
+func importVMSocket(s VMSocketFD, datagram bool) (conn net.Conn, listener net.Listener, link net.Conn, err error) {
+	f := os.NewFile(uintptr(s.FD), "imported-vm-socket")
+	if f == nil {
+		return nil, nil, nil, fmt.Errorf("invalid imported VM socket fd %d", s.FD)
+	}
+	defer f.Close() // FileConn/FileListener dup; drop the original
+
+	switch {
+	case s.Connected:
+		link, err = net.FileConn(f)
+	case datagram:
+		conn, err = net.FileConn(f)
+	default:
+		listener, err = net.FileListener(f)
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("import VM socket fd %d: %w", s.FD, err)
+	}
+	return conn, listener, link, nil
+}
+
+// serializeAll hands over every instance: it exports their sockets, stops
+// them (keeping the sockets open) and removes them from this library.
+func serializeAll() ([]byte, error) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+
+	ids := make([]int64, 0, len(instances))
+	for id := range instances {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// Export everything before stopping anything, so a failure leaves every
+	// instance running.
+	state := handoffState{Version: handoffVersion, Instances: []handoffInstance{}}
+	for _, id := range ids {
+		config, err := instances[id].exportHandoff()
+		if err != nil {
+			for _, exported := range state.Instances {
+				closeHandoffFDs(exported.Config)
+			}
+			return nil, fmt.Errorf("instance %d: %w", id, err)
+		}
+		state.Instances = append(state.Instances, handoffInstance{ID: id, Config: config})
+	}
+
+	for i, id := range ids {
+		pending := instances[id].detachForHandoff()
+		if vm := state.Instances[i].Config.ImportVMSocket; vm.Connected {
+			vm.Pending = pending
+		}
+		delete(instances, id)
+	}
+	return json.Marshal(state)
+}
+
+// deserializeAll recreates the instances of a serializeAll document under
+// their original ids and returns how many came up. Instances that fail are
+// logged and their FDs closed.
+func deserializeAll(doc []byte) (int, error) {
+	var state handoffState
+	if err := json.Unmarshal(doc, &state); err != nil {
+		return 0, err
+	}
+	if state.Version != handoffVersion {
+		for _, inst := range state.Instances {
+			closeHandoffFDs(inst.Config)
+		}
+		return 0, fmt.Errorf("unsupported handoff version %d (want %d)", state.Version, handoffVersion)
+	}
+
+	restored := 0
+	for _, inst := range state.Instances {
+		instancesMu.Lock()
+		_, taken := instances[inst.ID]
+		if !taken && inst.ID >= nextID {
+			nextID = inst.ID + 1
+		}
+		instancesMu.Unlock()
+
+		if taken {
+			logrus.WithField("id", inst.ID).Error("Cannot restore handed-over instance: id already in use")
+			closeHandoffFDs(inst.Config)
+			continue
+		}
+		if err := startInstance(inst.ID, inst.Config); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": inst.ID}).Error("Failed to restore handed-over instance")
+			continue
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// gvproxy_serialize_all hands every instance over for a library upgrade. It
+// returns a JSON document with each instance's id, config and dup'd socket
+// FDs, then stops the instances without closing or unlinking their sockets.
+// Pass the document to gvproxy_deserialize_all on the new library.
+//
+// Returns NULL (leaving every instance running) if a socket cannot be
+// exported. The string must be freed with gvproxy_free_string.
+//
+//export gvproxy_serialize_all
+func gvproxy_serialize_all() *C.char {
+	doc, err := serializeAll()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to serialize instances for handoff")
+		return nil
+	}
+	logrus.Info("Serialized instances for handoff")
+	return returnCString(string(doc))
+}
+
+// gvproxy_deserialize_all restores the instances in a gvproxy_serialize_all
+// document under their original ids, taking ownership of every FD in it.
+//
+// Returns the number of instances restored, or -1 if the document is invalid.
+// Instances that fail to restore are logged and skipped.
+//
+//export gvproxy_deserialize_all
+func gvproxy_deserialize_all(state *C.char) C.int {
+	if state == nil {
+		return -1
+	}
+	restored, err := deserializeAll([]byte(C.GoString(state)))
+	if err != nil {
+		logrus.WithError(err).Error("Failed to deserialize handed-over instances")
+		return -1
+	}
+	logrus.WithField("instances", restored).Info("Restored handed-over instances")
+	return C.int(restored)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+// TestLinkConn_DetachKeepsPartialFrameForNextOwner hands a qemu stream over
+// mid-frame: the first owner delivers the whole frame it has, the partial one
+// travels with the handoff, and the second owner completes it.
+func TestLinkConn_DetachKeepsPartialFrameForNextOwner(t *testing.T) {
+	vm, gw := net.Pipe()
+	defer vm.Close()
+	oldLink := newLinkConn(gw, types.QemuProtocol, nil)
+
+	second := qemuFrame("second")
+	go vm.Write(append(qemuFrame("first"), second[:6]...)) //nolint:errcheck
+
+	got := make([]byte, len(qemuFrame("first")))
+	if _, err := io.ReadFull(oldLink, got); err != nil {
+		t.Fatalf("read first frame: %v", err)
+	}
+	go io.Copy(io.Discard, oldLink) //nolint:errcheck // the switch keeps reading
+
+	pending, err := oldLink.detach(time.Second)
+	if err != nil {
+		t.Fatalf("detach: %v", err)
+	}
+	if string(pending) != string(second[:6]) {
+		t.Fatalf("expected the partial frame to be handed over, got %q", pending)
+	}
+	if _, err := oldLink.Write(qemuFrame("late")); !errors.Is(err, errLinkDetached) {
+		t.Fatalf("writes after detach must fail, got %v", err)
+	}
+
+	// The next owner gets the rest of the stream.
+	vm2, gw2 := net.Pipe()
+	obs := &recordingObserver{}
+	newLink := newLinkConn(gw2, types.QemuProtocol, nil, obs)
+	newLink.seed(pending)
+	go func() {
+		vm2.Write(second[6:]) //nolint:errcheck
+		vm2.Close()
+	}()
+	rest, err := io.ReadAll(newLink)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(rest) != string(second) || len(obs.ingress) != 1 || string(obs.ingress[0]) != "second" {
+		t.Fatalf("second frame not reassembled: bytes=%q frames=%q", rest, obs.ingress)
+	}
+}
+
+func TestHandoff_RestoresConnectedInstanceUnderSameID(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gvh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(dir, "vm.sock")
+	config.Protocol = "qemu" // the stream socket path
+	const id = 4242
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	vm, err := net.Dial("unix", config.SocketPath)
+	if err != nil {
+		t.Fatalf("dial VM socket: %v", err)
+	}
+	defer vm.Close()
+	waitForLink(t, id)
+
+	doc, err := serializeAll()
+	if err != nil {
+		t.Fatalf("serializeAll: %v", err)
+	}
+	instancesMu.RLock()
+	_, stillThere := instances[id]
+	instancesMu.RUnlock()
+	if stillThere {
+		t.Fatal("serialized instance must be removed")
+	}
+
+	restored, err := deserializeAll(doc)
+	if err != nil || restored != 1 {
+		t.Fatalf("deserializeAll = %d, %v", restored, err)
+	}
+	waitForLink(t, id)
+	// Writes would fail with EPIPE had every gateway-side FD been closed.
+	if _, err := vm.Write(qemuFrame(string(make([]byte, 14)))); err != nil {
+		t.Fatalf("VM connection must stay open: %v", err)
+	}
+}
+
+// TestHandoff_KeepsListeningSocketPath hands over an instance whose VM has
+// not connected yet: the VM must still be able to connect by path afterwards.
+func TestHandoff_KeepsListeningSocketPath(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gvh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(dir, "vm.sock")
+	config.Protocol = "qemu" // the stream socket path
+	const id = 4243
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	doc, err := serializeAll()
+	if err != nil {
+		t.Fatalf("serializeAll: %v", err)
+	}
+	if restored, err := deserializeAll(doc); err != nil || restored != 1 {
+		t.Fatalf("deserializeAll = %d, %v", restored, err)
+	}
+	time.Sleep(50 * time.Millisecond) // let the old instance finish shutting down
+
+	vm, err := net.Dial("unix", config.SocketPath)
+	if err != nil {
+		t.Fatalf("VM must connect to the handed-over socket: %v", err)
+	}
+	defer vm.Close()
+	waitForLink(t, id)
+}
+
+func TestDeserializeAll_RejectsUnknownVersion(t *testing.T) {
+	if _, err := deserializeAll([]byte(`{"version":99,"instances":[]}`)); err == nil {
+		t.Fatal("expected version mismatch error")
+	}
+}
+
+func stopTestInstance(id int64) {
+	instancesMu.Lock()
+	instance := instances[id]
+	delete(instances, id)
+	instancesMu.Unlock()
+	if instance != nil {
+		instance.Cancel()
+	}
+}
+
+func waitForLink(t *testing.T, id int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		instancesMu.RLock()
+		instance := instances[id]
+		instancesMu.RUnlock()
+		if instance != nil {
+			instance.vnMu.RLock()
+			link := instance.link
+			instance.vnMu.RUnlock()
+			if link != nil {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("instance %d never got its VM link", id)
+}
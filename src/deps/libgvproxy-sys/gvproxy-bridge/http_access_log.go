@@ -0,0 +1,232 @@
+package main
+
+// http_access_log.go — Access logs for port mappings that carry HTTP.
+//
+// A port mapping with "type": "http" is relayed like any other, byte for
+// byte, but the bridge also reads along: the requests the host client sends
+// and the responses the guest returns are parsed as HTTP/1.x, and each
+// answered request is reported as an http_access event (method, path,
+// status, duration). Guest web services become observable without touching
+// the guest.
+//
+// Parsing never affects the relay: the parsers work on copies and never
+// hold it up. If a connection stops looking like HTTP/1.x (TLS, HTTP/2,
+// garbage), switches protocols (101, CONNECT), or outpaces its parser,
+// logging ends for that connection and relaying carries on. Pipelined
+// requests are matched to responses in order. The path is logged without
+// its query string, which often carries credentials.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EventHTTPAccess fires for each request answered on an "http" port
+// mapping. Fields: peer, host_port, guest_port, method, path, status,
+// duration_ms (request headers received to response fully sent).
+const EventHTTPAccess = "http_access"
+
+// Port mapping types.
+const (
+	forwardTypeTCP  = "tcp"
+	forwardTypeHTTP = "http"
+)
+
+// httpAccessMaxPipelined bounds the requests awaiting a response on one
+// connection; beyond it logging stops for the connection.
+const httpAccessMaxPipelined = 64
+
+// checkForwardType validates a port mapping's type.
+func checkForwardType(pm PortMapping) error {
+	switch pm.Type {
+	case "", forwardTypeTCP, forwardTypeHTTP:
+		return nil
+	}
+	return fmt.Errorf("port mapping %d -> %d: unknown type %q (want tcp or http)", pm.HostPort, pm.GuestPort, pm.Type)
+}
+
+// checkForwardTypes validates the types of every port mapping.
+func checkForwardTypes(config GvproxyConfig) error {
+	for _, pm := range config.PortMappings {
+		if err := checkForwardType(pm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// httpAccessConn wraps the guest side of an "http" forward's relay: what is
+// written to it are requests, what is read from it responses.
+type httpAccessConn struct {
+	net.Conn
+	requests  *httpTap
+	responses *httpTap
+}
+
+// httpAccessRequest is a parsed request awaiting its response.
+type httpAccessRequest struct {
+	method string
+	path   string
+	start  time.Time
+}
+
+// newHTTPAccessConn starts logging the HTTP exchanged over guest, a
+// connection relayed from peer for pm, and returns the conn to relay over.
+func newHTTPAccessConn(guest net.Conn, instanceID int64, pm PortMapping, peer string) net.Conn {
+	c := &httpAccessConn{
+		Conn:      guest,
+		requests:  newHTTPTap(),
+		responses: newHTTPTap(),
+	}
+	pending := make(chan httpAccessRequest, httpAccessMaxPipelined)
+	go parseHTTPRequests(c.requests, pending)
+	go parseHTTPResponses(c.responses, pending, func(req httpAccessRequest, status int) {
+		emitEvent(instanceID, EventHTTPAccess, map[string]any{
+			"peer":        peer,
+			"host_port":   pm.HostPort,
+			"guest_port":  pm.GuestPort,
+			"method":      req.method,
+			"path":        req.path,
+			"status":      status,
+			"duration_ms": time.Since(req.start).Milliseconds(),
+		})
+	})
+	return c
+}
+
+func (c *httpAccessConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.requests.write(p[:n])
+	return n, err
+}
+
+func (c *httpAccessConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.responses.write(p[:n])
+	return n, err
+}
+
+func (c *httpAccessConn) Close() error {
+	c.requests.stop()
+	c.responses.stop()
+	return c.Conn.Close()
+}
+
+// httpTapChunks bounds the relayed reads and writes a tap buffers for its
+// parser.
+const httpTapChunks = 16
+
+// httpTap copies one direction of the relay to a parser without ever
+// blocking it: if the parser falls behind or gives up, the tap stops and
+// logging ends for the connection.
+type httpTap struct {
+	mu      sync.Mutex
+	stopped bool
+	chunks  chan []byte
+	buf     []byte // parser side
+}
+
+func newHTTPTap() *httpTap {
+	return &httpTap{chunks: make(chan []byte, httpTapChunks)}
+}
+
+func (t *httpTap) write(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	select {
+	case t.chunks <- bytes.Clone(p):
+	default:
+		t.stopped = true
+		close(t.chunks)
+	}
+}
+
+// stop ends the tap; the parser reads what is buffered, then EOF.
+func (t *httpTap) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.stopped {
+		t.stopped = true
+		close(t.chunks)
+	}
+}
+
+// Read is the parser's side of the tap.
+func (t *httpTap) Read(p []byte) (int, error) {
+	for len(t.buf) == 0 {
+		chunk, ok := <-t.chunks
+		if !ok {
+			return 0, io.EOF
+		}
+		t.buf = chunk
+	}
+	n := copy(p, t.buf)
+	t.buf = t.buf[n:]
+	return n, nil
+}
+
+// parseHTTPRequests queues each request read from the tap for its
+// response, until the requests stop parsing or switch protocols.
+func parseHTTPRequests(tap *httpTap, pending chan<- httpAccessRequest) {
+	defer close(pending)
+	defer tap.stop()
+	br := bufio.NewReader(tap)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		select {
+		case pending <- httpAccessRequest{method: req.Method, path: req.URL.Path, start: time.Now()}:
+		default:
+			return // more than httpAccessMaxPipelined outstanding
+		}
+		if req.Method == http.MethodConnect || req.Header.Get("Upgrade") != "" {
+			// Whatever follows is no longer HTTP if the upgrade succeeds.
+			return
+		}
+		if _, err := io.Copy(io.Discard, req.Body); err != nil {
+			return
+		}
+	}
+}
+
+// parseHTTPResponses matches each response read from the tap to the oldest
+// pending request and reports it.
+func parseHTTPResponses(tap *httpTap, pending <-chan httpAccessRequest, report func(httpAccessRequest, int)) {
+	defer tap.stop()
+	br := bufio.NewReader(tap)
+	for req := range pending {
+		resp, err := http.ReadResponse(br, &http.Request{Method: req.method})
+		// Interim responses (100 Continue, 103 Early Hints) precede the
+		// final one.
+		for err == nil && resp.StatusCode/100 == 1 && resp.StatusCode != http.StatusSwitchingProtocols {
+			resp, err = http.ReadResponse(br, &http.Request{Method: req.method})
+		}
+		if err != nil {
+			return
+		}
+		if resp.StatusCode == http.StatusSwitchingProtocols || req.method == http.MethodConnect && resp.StatusCode/100 == 2 {
+			report(req, resp.StatusCode)
+			return
+		}
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		report(req, resp.StatusCode)
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// httpAccessPair returns a logged guest conn and the guest server's end.
+func httpAccessPair(t *testing.T, id int64) (net.Conn, net.Conn) {
+	t.Helper()
+	relay, server := net.Pipe()
+	conn := newHTTPAccessConn(relay, id, PortMapping{HostPort: 8080, GuestPort: 80, Type: forwardTypeHTTP}, "10.0.0.9:4000")
+	t.Cleanup(func() {
+		conn.Close()
+		server.Close()
+	})
+	return conn, server
+}
+
+// httpAccessLog summarizes instance id's http_access events.
+func httpAccessLog(t *testing.T, id int64) []string {
+	t.Helper()
+	var log []string
+	for _, e := range recentEventsFor(id) {
+		if e.Type != EventHTTPAccess {
+			continue
+		}
+		if e.Fields["host_port"] != uint16(8080) || e.Fields["peer"] != "10.0.0.9:4000" {
+			t.Fatalf("unexpected event fields: %v", e.Fields)
+		}
+		log = append(log, e.Fields["method"].(string)+" "+e.Fields["path"].(string)+" "+http.StatusText(e.Fields["status"].(int)))
+	}
+	return log
+}
+
+func TestCheckForwardType(t *testing.T) {
+	for _, typ := range []string{"", "tcp", "http"} {
+		if err := checkForwardType(PortMapping{Type: typ}); err != nil {
+			t.Errorf("type %q: %v", typ, err)
+		}
+	}
+	if err := checkForwardType(PortMapping{HostPort: 80, GuestPort: 80, Type: "https"}); err == nil || !strings.Contains(err.Error(), "unknown type") {
+		t.Errorf("type https: error = %v", err)
+	}
+}
+
+func TestHTTPAccessConn_ReportsRequests(t *testing.T) {
+	conn, server := httpAccessPair(t, 5905)
+	go func() {
+		br := bufio.NewReader(server)
+		for _, status := range []string{"404 Not Found", "200 OK"} {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, req.Body)                                                //nolint:errcheck
+			io.WriteString(server, "HTTP/1.1 "+status+"\r\nContent-Length: 2\r\n\r\nok") //nolint:errcheck
+		}
+	}()
+
+	before := len(httpAccessLog(t, 5905))
+	// Two pipelined requests, the first with a query the log must drop.
+	if _, err := io.WriteString(conn, "GET /missing?token=secret HTTP/1.1\r\nHost: x\r\n\r\nPOST /submit HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabc"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body) //nolint:errcheck
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var got []string
+	for time.Now().Before(deadline) {
+		got = httpAccessLog(t, 5905)[before:]
+		if len(got) >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want := "GET /missing Not Found,POST /submit OK"; strings.Join(got, ",") != want {
+		t.Fatalf("access log = %v, want %s", got, want)
+	}
+}
+
+func TestHTTPAccessConn_NeverBlocksTheRelay(t *testing.T) {
+	conn, server := httpAccessPair(t, 5906)
+	// A server that speaks first, far more than the tap buffers: nothing
+	// parses it, and the relay must not wait for a parser.
+	const writes = 4 * httpTapChunks
+	go func() {
+		for i := 0; i < writes; i++ {
+			if _, err := io.WriteString(server, "not http\n"); err != nil {
+				return
+			}
+		}
+	}()
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 64)
+		for i := 0; i < writes; i++ {
+			if _, err := io.ReadFull(conn, buf[:len("not http\n")]); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay blocked on the access log")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+// instance_created.go — Announce each instance with the features it runs.
+//
+// Events and logs of an instance name it by id only. Reading them later
+// ("were forwards dropped because the allowlist was on?") meant joining
+// against the config the embedder passed, which is rarely kept next to the
+// logs. Every instance now starts its event stream with instance_created,
+// carrying the resolved feature set, so the stream explains itself.
+
+import (
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+// EventInstanceCreated fires once an instance is up. Fields: protocol
+// (qemu, bess, vfkit, stdio or vsock), capture, isolation ("allowlist"
+// when allow_net restricts the guest, else "open"), forwards, mitm_secrets,
+// upstream_proxies, control_socket, connect_proxy, socket_forwards,
+// gateway_dns (false with disable_gateway_dns), the optional limits that are set (egress_ttl, max_data_plane_flows) and the
+// config generation (config_generation.go).
+const EventInstanceCreated = "instance_created"
+
+// instanceFeatures is the field set of EventInstanceCreated for config
+// running over protocol.
+func instanceFeatures(config GvproxyConfig, protocol types.Protocol) map[string]any {
+	link := string(protocol)
+	if config.VsockListen != nil {
+		link = "vsock"
+	}
+	isolation := "open"
+	if len(config.AllowNet) > 0 {
+		isolation = "allowlist"
+	}
+	fields := map[string]any{
+		"protocol":         link,
+		"capture":          config.CaptureFile != nil && *config.CaptureFile != "",
+		"isolation":        isolation,
+		"forwards":         len(config.PortMappings),
+		"mitm_secrets":     len(config.Secrets),
+		"upstream_proxies": len(config.UpstreamProxies),
+		"control_socket":   config.ControlSocketPath != "",
+		"connect_proxy":    config.ConnectProxySocketPath != "",
+		"socket_forwards":  len(config.SocketForwards),
+		"gateway_dns":      !config.DisableGatewayDNS,
+	}
+	if config.EgressTTL != 0 {
+		fields["egress_ttl"] = config.EgressTTL
+	}
+	if config.MaxDataPlaneFlows > 0 {
+		fields["max_data_plane_flows"] = config.MaxDataPlaneFlows
+	}
+	return fields
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStartInstance_EmitsInstanceCreated(t *testing.T) {
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	config.Protocol = "qemu"
+	config.AllowNet = []string{"example.com"}
+	config.EgressTTL = 32
+	const id = 5920
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	e := waitForEvent(t, id, EventInstanceCreated)
+	for key, want := range map[string]any{
+		"protocol":    "qemu",
+		"capture":     false,
+		"isolation":   "allowlist",
+		"forwards":    0,
+		"gateway_dns": true,
+	} {
+		if got := e.Fields[key]; got != want {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+	if e.Fields["egress_ttl"] != uint8(32) {
+		t.Errorf("egress_ttl = %v, want 32", e.Fields["egress_ttl"])
+	}
+	if _, ok := e.Fields["max_data_plane_flows"]; ok {
+		t.Error("unset limits must be left out")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+// last_error.go — errno-style error reporting for the create exports.
+//
+// gvproxy_create, gvproxy_create_from_template and gvproxy_create_with_fd
+// return a negative GVPROXY_ERR_* code on failure and hand the reason out
+// through errOut, but callers that pass NULL there (or reach the bridge
+// through wrappers that drop it) only had the logs.
+// gvproxy_last_error returns the reason of the calling thread's last failed
+// create, like errno: each thread sees only its own errors, so concurrent
+// creates on different threads never report each other's failures. Reading
+// it clears it, and every create clears it when it starts, so a message
+// always belongs to the most recent failed create on that thread.
+
+/*
+#include <pthread.h>
+#include <stdint.h>
+
+// A Go export runs on the OS thread of the C code that called it.
+static uintptr_t gvproxy_caller_thread(void) {
+	return (uintptr_t)pthread_self();
+}
+*/
+import "C"
+import (
+	"sync"
+)
+
+// lastErrors holds the last create error of each calling thread.
+var (
+	lastErrorsMu sync.Mutex
+	lastErrors   = map[uintptr]string{}
+)
+
+// callerThread identifies the C thread of the export being run. Only
+// meaningful inside an export, where the goroutine is locked to it.
+func callerThread() uintptr {
+	return uintptr(C.gvproxy_caller_thread())
+}
+
+// setLastError records err as the calling thread's last error; nil clears
+// it.
+func setLastError(err error) {
+	thread := callerThread()
+	lastErrorsMu.Lock()
+	defer lastErrorsMu.Unlock()
+	if err == nil {
+		delete(lastErrors, thread)
+		return
+	}
+	lastErrors[thread] = err.Error()
+}
+
+// takeLastError returns and clears the calling thread's last error.
+func takeLastError() (string, bool) {
+	thread := callerThread()
+	lastErrorsMu.Lock()
+	defer lastErrorsMu.Unlock()
+	msg, ok := lastErrors[thread]
+	delete(lastErrors, thread)
+	return msg, ok
+}
+
+// reportCreateError hands a failed create's error to the caller through
+// errOut (if not NULL) and gvproxy_last_error.
+func reportCreateError(errOut **C.char, err error) {
+	if errOut != nil {
+		*errOut = returnCString(err.Error())
+	}
+	setLastError(err)
+}
+
+// gvproxy_last_error returns why the calling thread's last gvproxy_create,
+// gvproxy_create_from_template or gvproxy_create_with_fd returned a negative
+// GVPROXY_ERR_* code, and clears it. Returns NULL if that create succeeded
+// or the error was already read. The string must be freed with
+// gvproxy_free_string.
+//
+//export gvproxy_last_error
+func gvproxy_last_error() *C.char {
+	msg, ok := takeLastError()
+	if !ok {
+		return nil
+	}
+	return returnCString(msg)
+}
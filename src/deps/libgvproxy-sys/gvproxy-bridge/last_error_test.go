@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestLastError_ClearOnRead(t *testing.T) {
+	// Exports run locked to the caller's thread; so does this test.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	reportCreateError(nil, errors.New("bind: address already in use"))
+	if msg, ok := takeLastError(); !ok || msg != "bind: address already in use" {
+		t.Fatalf("takeLastError() = %q, %v", msg, ok)
+	}
+	if msg, ok := takeLastError(); ok {
+		t.Fatalf("error not cleared on read: %q", msg)
+	}
+
+	reportCreateError(nil, errors.New("stale"))
+	setLastError(nil) // a new create starts
+	if msg, ok := takeLastError(); ok {
+		t.Fatalf("error not cleared by a new create: %q", msg)
+	}
+}
+
+func TestLastError_PerThread(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer takeLastError()
+	reportCreateError(nil, errors.New("mine"))
+
+	other := make(chan bool)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		_, ok := takeLastError()
+		other <- ok
+	}()
+	if <-other {
+		t.Fatal("another thread read this thread's error")
+	}
+	if msg, ok := takeLastError(); !ok || msg != "mine" {
+		t.Fatalf("takeLastError() = %q, %v", msg, ok)
+	}
+}
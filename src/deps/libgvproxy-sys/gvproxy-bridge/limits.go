@@ -0,0 +1,239 @@
+package main
+
+// limits.go — Library-wide guardrails against runaway orchestration.
+//
+// On a shared host, a bug on the Rust side that loops on gvproxy_create can
+// bind thousands of sockets and ports, and a forgotten capture_file can fill
+// the disk. gvproxy_set_limits caps, across every instance in the library:
+//
+//   - max_instances: running instances (gvproxy_create fails past it);
+//   - max_total_forwards: port mappings, configured or published with
+//     gvproxy_expose (both fail past it);
+//   - max_capture_bytes: bytes written to capture files. A new capture is
+//     refused once running captures reach it, and a running capture that
+//     takes the total past it is stopped (its file is kept).
+//
+// Zero means unlimited, which is the default. Limits apply to instances
+// created after they are set; nothing already running is torn down.
+// Forwards added at runtime through the services API are not counted.
+
+import "C"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventCaptureStopped fires when a capture is stopped because the capture
+// files of all instances exceed max_capture_bytes. Fields: file, bytes,
+// max_capture_bytes.
+const EventCaptureStopped = "capture_stopped"
+
+// captureCheckInterval is how often a running capture checks the total.
+const captureCheckInterval = 5 * time.Second
+
+// Limits are the guardrails set with gvproxy_set_limits.
+type Limits struct {
+	MaxInstances     int   `json:"max_instances,omitempty"`
+	MaxTotalForwards int   `json:"max_total_forwards,omitempty"`
+	MaxCaptureBytes  int64 `json:"max_capture_bytes,omitempty"`
+}
+
+var (
+	limitsMu sync.Mutex
+	limits   Limits
+	// Instances admitted but not yet registered, so concurrent creates
+	// cannot overshoot the limits between the check and registration.
+	startingInstances int
+	startingForwards  int
+)
+
+// limitError reports that creating an instance would exceed a limit.
+type limitError struct {
+	Limit string // JSON name of the limit
+	Max   int64
+	Have  int64
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("%s limit reached (%d in use, max %d); raise it with gvproxy_set_limits", e.Limit, e.Have, e.Max)
+}
+
+func setLimits(l Limits) error {
+	if l.MaxInstances < 0 || l.MaxTotalForwards < 0 || l.MaxCaptureBytes < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	limitsMu.Lock()
+	limits = l
+	limitsMu.Unlock()
+	return nil
+}
+
+func currentLimits() Limits {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	return limits
+}
+
+// admitInstance checks config against the limits and holds its share until
+// release is called, which must happen once the instance is registered or
+// has failed to start. release is safe to call more than once.
+func admitInstance(config GvproxyConfig) (release func(), err error) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+
+	instancesMu.RLock()
+	running := len(instances)
+	instancesMu.RUnlock()
+	forwards := runningForwards()
+
+	if max := limits.MaxInstances; max > 0 && running+startingInstances+1 > max {
+		return nil, &limitError{Limit: "max_instances", Max: int64(max), Have: int64(running + startingInstances)}
+	}
+	if max := limits.MaxTotalForwards; max > 0 && forwards+startingForwards+len(config.PortMappings) > max {
+		return nil, &limitError{Limit: "max_total_forwards", Max: int64(max), Have: int64(forwards + startingForwards)}
+	}
+	if max := limits.MaxCaptureBytes; max > 0 && config.CaptureFile != nil && *config.CaptureFile != "" {
+		if total := captureBytesTotal(); total >= max {
+			return nil, &limitError{Limit: "max_capture_bytes", Max: max, Have: total}
+		}
+	}
+
+	startingInstances++
+	startingForwards += len(config.PortMappings)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			limitsMu.Lock()
+			startingInstances--
+			startingForwards -= len(config.PortMappings)
+			limitsMu.Unlock()
+		})
+	}, nil
+}
+
+// runningForwards counts the port forwards of running instances, including
+// ones published at run time.
+func runningForwards() int {
+	instancesMu.RLock()
+	defer instancesMu.RUnlock()
+	forwards := 0
+	for _, instance := range instances {
+		forwards += len(instance.portMappings())
+	}
+	return forwards
+}
+
+// admitForward checks one more port forward against max_total_forwards. It
+// is called with limitsMu held, so the forward must be added before
+// releasing it.
+func admitForward() error {
+	forwards := runningForwards()
+	if max := limits.MaxTotalForwards; max > 0 && forwards+startingForwards+1 > max {
+		return &limitError{Limit: "max_total_forwards", Max: int64(max), Have: int64(forwards + startingForwards)}
+	}
+	return nil
+}
+
+// captureBytesTotal sums the capture file sizes of running instances.
+func captureBytesTotal() int64 {
+	instancesMu.RLock()
+	defer instancesMu.RUnlock()
+
+	var total int64
+	for _, instance := range instances {
+		if path := instance.Config.CaptureFile; path != "" {
+			if info, err := os.Stat(path); err == nil {
+				total += info.Size()
+			}
+		}
+	}
+	return total
+}
+
+// watchCaptureLimit stops the instance's capture once the capture files of
+// all instances exceed max_capture_bytes.
+func watchCaptureLimit(ctx context.Context, id int64, capture *captureState) {
+	path := capture.path
+	ticker := time.NewTicker(captureCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		max := currentLimits().MaxCaptureBytes
+		if max <= 0 {
+			continue
+		}
+		total := captureBytesTotal()
+		if total <= max {
+			continue
+		}
+		if err := stopCapture(path); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": id, "file": path}).Error("Failed to stop capture over max_capture_bytes")
+			continue
+		}
+		capture.set(captureStatusStopped, nil)
+		logrus.WithFields(logrus.Fields{"id": id, "file": path, "bytes": total, "max_capture_bytes": max}).Warn("Capture stopped: max_capture_bytes reached")
+		emitEvent(id, EventCaptureStopped, map[string]any{
+			"file":              path,
+			"bytes":             total,
+			"max_capture_bytes": max,
+		})
+		return
+	}
+}
+
+// stopCapture points the descriptor gvisor-tap-vsock writes the capture
+// through at /dev/null. The sniffer owns that descriptor and offers no way
+// to stop it, so it is found by matching open FDs against the file.
+func stopCapture(path string) error {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+	return redirectCapture(path, devNull)
+}
+
+// captureMu serializes stopCapture and rotateCapture.
+var captureMu sync.Mutex
+
+// gvproxy_set_limits sets the library-wide limits: {"max_instances": ...,
+// "max_total_forwards": ..., "max_capture_bytes": ...}. Omitted or zero
+// fields are unlimited; NULL clears every limit. gvproxy_create fails with
+// an error naming the limit once one is reached.
+//
+// Returns 0 on success, -1 if the JSON is invalid or a limit is negative
+// (the previous limits are kept).
+//
+//export gvproxy_set_limits
+func gvproxy_set_limits(limitsJSON *C.char) C.int {
+	var l Limits
+	if limitsJSON != nil {
+		if err := json.Unmarshal([]byte(C.GoString(limitsJSON)), &l); err != nil {
+			logrus.WithError(err).Error("Failed to parse limits")
+			return -1
+		}
+	}
+	if err := setLimits(l); err != nil {
+		logrus.WithError(err).Error("Invalid limits")
+		return -1
+	}
+	logrus.WithFields(logrus.Fields{
+		"max_instances":      l.MaxInstances,
+		"max_total_forwards": l.MaxTotalForwards,
+		"max_capture_bytes":  l.MaxCaptureBytes,
+	}).Info("Limits set")
+	return 0
+}
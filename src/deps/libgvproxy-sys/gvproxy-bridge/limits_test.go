@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func withLimits(t *testing.T, l Limits) {
+	t.Helper()
+	if err := setLimits(l); err != nil {
+		t.Fatalf("setLimits: %v", err)
+	}
+	t.Cleanup(func() { setLimits(Limits{}) })
+}
+
+func addFakeInstance(t *testing.T, id int64, config GvproxyConfig) {
+	t.Helper()
+	instancesMu.Lock()
+	instances[id] = &GvproxyInstance{ID: id, config: config, generation: newConfigGeneration()}
+	instancesMu.Unlock()
+	t.Cleanup(func() {
+		instancesMu.Lock()
+		delete(instances, id)
+		instancesMu.Unlock()
+	})
+}
+
+func TestSetLimits_RejectsNegative(t *testing.T) {
+	withLimits(t, Limits{MaxInstances: 3})
+	if err := setLimits(Limits{MaxTotalForwards: -1}); err == nil {
+		t.Fatal("setLimits accepted a negative limit")
+	}
+	if got := currentLimits().MaxInstances; got != 3 {
+		t.Errorf("MaxInstances = %d after rejected update, want 3", got)
+	}
+}
+
+func TestAdmitInstance_MaxInstancesCountsStarting(t *testing.T) {
+	instancesMu.RLock()
+	running := len(instances)
+	instancesMu.RUnlock()
+	withLimits(t, Limits{MaxInstances: running + 2})
+
+	addFakeInstance(t, 5200, testGvproxyConfig())
+	release, err := admitInstance(testGvproxyConfig())
+	if err != nil {
+		t.Fatalf("admitInstance under the limit: %v", err)
+	}
+
+	var limitErr *limitError
+	if _, err := admitInstance(testGvproxyConfig()); !errors.As(err, &limitErr) || limitErr.Limit != "max_instances" {
+		t.Fatalf("err = %v, want max_instances limit while one is starting", err)
+	}
+
+	release()
+	release() // idempotent
+	release2, err := admitInstance(testGvproxyConfig())
+	if err != nil {
+		t.Fatalf("admitInstance after release: %v", err)
+	}
+	release2()
+}
+
+func TestAdmitInstance_MaxTotalForwards(t *testing.T) {
+	running := testGvproxyConfig()
+	running.PortMappings = []PortMapping{{HostPort: 18080, GuestPort: 80}, {HostPort: 18443, GuestPort: 443}}
+	addFakeInstance(t, 5201, running)
+
+	withLimits(t, Limits{MaxTotalForwards: runningForwards() + 1})
+
+	config := testGvproxyConfig()
+	config.PortMappings = []PortMapping{{HostPort: 18081, GuestPort: 81}, {HostPort: 18082, GuestPort: 82}}
+	var limitErr *limitError
+	if _, err := admitInstance(config); !errors.As(err, &limitErr) || limitErr.Limit != "max_total_forwards" {
+		t.Fatalf("err = %v, want max_total_forwards limit", err)
+	}
+
+	config.PortMappings = config.PortMappings[:1]
+	release, err := admitInstance(config)
+	if err != nil {
+		t.Fatalf("admitInstance within the limit: %v", err)
+	}
+	release()
+}
+
+func TestStopCapture_DiscardsFurtherWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stopCapture(path); err != nil {
+		t.Fatalf("stopCapture: %v", err)
+	}
+	if _, err := f.Write([]byte("after stop")); err != nil {
+		t.Fatalf("write after stop: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "before" {
+		t.Errorf("capture file = %q, want %q", data, "before")
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+// link_conn.go — Frame-level view of the hypervisor connection.
+//
+// tap.Switch moves the VM's NIC traffic over a plain net.Conn. Wrapping that
+// conn lets the bridge observe (and, for opt-in normalizations, rewrite) every
+// L2 frame in both directions without forking the switch. Stream protocols
+// (qemu, stdio) prefix each frame with its length and may arrive in arbitrary
+// chunks, so ingress is reassembled here and only handed to the switch once a
+// frame is complete; egress is always written one frame per Write call.
+// Reassembly and rewrite buffers come from framePool and go back to it once
+// the connection fails.
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+// maxStreamFrameSize bounds the reassembly buffer. A larger length prefix
+// means the stream is corrupt; the switch will fail on it anyway.
+const maxStreamFrameSize = 64 * 1024
+
+// frameObserver receives frames crossing the hypervisor connection. Frames
+// are only valid for the duration of the call and must not be modified.
+type frameObserver interface {
+	ingressFrame(frame []byte) // guest → gateway
+	egressFrame(frame []byte)  // gateway → guest
+}
+
+// frameRewriter edits frames in place before they are forwarded. It runs
+// before the observers of its data path stage (data_path.go).
+type frameRewriter interface {
+	rewriteFrame(frame []byte)
+}
+
+// linkConn wraps the hypervisor-side connection and runs frames through its
+// data path. Without rewriters it is transparent: bytes pass through
+// unchanged.
+type linkConn struct {
+	net.Conn
+	prefixLen int // length prefix size; 0 for datagram protocols
+	path      dataPath
+
+	rxStore   []byte // pooled backing buffer for stream reassembly
+	rxBuf     []byte // stream data read from the conn, not yet returned; a window into rxStore
+	rxReady   int    // leading bytes of rxBuf that are complete, reported frames
+	rxErr     error  // read error deferred until rxBuf is drained
+	rxCorrupt bool   // bad length prefix seen; pass the rest through unparsed
+	txScratch []byte // pooled copy of the frame being rewritten
+
+	rxLeftover  []byte        // stream bytes still buffered when Read failed
+	readStopped chan struct{} // closed once Read has returned an error
+	stopOnce    sync.Once
+
+	txMu     sync.Mutex
+	detached bool           // handed to another instance; refuse further writes
+	sends    *sendStalls    // times writes (slow_consumer.go); nil => untracked
+	queue    *priorityQueue // orders writes by class (link_priority.go); nil => direct
+}
+
+// errLinkDetached is returned by writes after the link was handed over.
+var errLinkDetached = errors.New("link handed over to another instance")
+
+// newLinkConn returns a linkConn whose data path is a single stage made of
+// rewriters and observers.
+func newLinkConn(conn net.Conn, protocol types.Protocol, rewriters []frameRewriter, observers ...frameObserver) *linkConn {
+	return newDataPathLinkConn(conn, protocol, dataPath{{rewriters: rewriters, observers: observers}})
+}
+
+// newDataPathLinkConn returns a linkConn running frames through path (see
+// data_path.go).
+func newDataPathLinkConn(conn net.Conn, protocol types.Protocol, path dataPath) *linkConn {
+	return &linkConn{
+		Conn:        conn,
+		prefixLen:   framePrefixLen(protocol),
+		path:        path,
+		readStopped: make(chan struct{}),
+	}
+}
+
+// framePrefixLen mirrors the upstream tap protocol framing.
+func framePrefixLen(protocol types.Protocol) int {
+	switch protocol {
+	case types.QemuProtocol:
+		return 4 // 32-bit big endian
+	case types.HyperKitProtocol, types.StdioProtocol:
+		return 2 // 16-bit little endian
+	default:
+		return 0 // vfkit, bess: one frame per datagram
+	}
+}
+
+func (c *linkConn) frameLen(prefix []byte) int {
+	if c.prefixLen == 4 {
+		return int(binary.BigEndian.Uint32(prefix))
+	}
+	return int(binary.LittleEndian.Uint16(prefix))
+}
+
+func (c *linkConn) Read(p []byte) (int, error) {
+	if c.prefixLen == 0 {
+		n, err := c.Conn.Read(p)
+		if n > 0 {
+			c.path.ingress(p[:n])
+		}
+		if err != nil {
+			c.stopReading()
+		}
+		return n, err
+	}
+
+	for c.rxReady == 0 {
+		if c.rxErr != nil {
+			c.rxLeftover = append([]byte(nil), c.rxBuf...)
+			c.releaseRx()
+			c.stopReading()
+			return 0, c.rxErr
+		}
+		c.readStream()
+	}
+	n := copy(p, c.rxBuf[:c.rxReady])
+	c.rxBuf = c.rxBuf[n:]
+	c.rxReady -= n
+	return n, nil
+}
+
+// readStream performs one read from the connection and releases every frame
+// it completes.
+func (c *linkConn) readStream() {
+	if c.rxStore == nil {
+		c.rxStore = framePool.get(c.prefixLen + maxStreamFrameSize)
+	}
+	// Only a partial frame is left in rxBuf here (rxReady == 0). Moving it to
+	// the front leaves room for the rest of it, so reads never reallocate.
+	kept := copy(c.rxStore, c.rxBuf)
+	n, err := c.Conn.Read(c.rxStore[kept:])
+	c.rxBuf = c.rxStore[:kept+n]
+	c.rxErr = err
+	c.scanStream()
+}
+
+func (c *linkConn) stopReading() {
+	c.stopOnce.Do(func() { close(c.readStopped) })
+}
+
+// seed queues stream bytes read by a previous owner of the connection (see
+// detach), ahead of anything read from the socket.
+func (c *linkConn) seed(pending []byte) {
+	if len(pending) == 0 || c.prefixLen == 0 {
+		return
+	}
+	c.rxStore = framePool.get(c.prefixLen + maxStreamFrameSize)
+	c.rxBuf = c.rxStore[:copy(c.rxStore, pending)]
+	c.scanStream()
+}
+
+// detach stops using the connection without closing the socket, so another
+// instance can take it over: writes are refused from now on, and the reader
+// is woken and allowed to finish the frames it already has. It returns the
+// stream bytes read but not delivered (a partial frame), which belong to the
+// next reader. Datagram links never have any.
+func (c *linkConn) detach(timeout time.Duration) ([]byte, error) {
+	c.txMu.Lock()
+	c.detached = true
+	c.txMu.Unlock()
+	if err := c.queue.flush(timeout); err != nil {
+		return nil, err
+	}
+
+	if err := c.Conn.SetReadDeadline(time.Now()); err != nil {
+		return nil, err
+	}
+	select {
+	case <-c.readStopped:
+		return c.rxLeftover, nil
+	case <-time.After(timeout):
+		return nil, errors.New("link reader did not stop")
+	}
+}
+
+// releaseRx returns the reassembly buffer once the stream has failed and
+// everything read before the failure has been returned.
+func (c *linkConn) releaseRx() {
+	framePool.put(c.rxStore)
+	c.rxStore, c.rxBuf = nil, nil
+}
+
+// scanStream reports every complete frame past rxReady and marks it ready,
+// leaving any trailing partial frame buffered for the next read.
+func (c *linkConn) scanStream() {
+	for !c.rxCorrupt {
+		rest := c.rxBuf[c.rxReady:]
+		if len(rest) < c.prefixLen {
+			return
+		}
+		size := c.frameLen(rest[:c.prefixLen])
+		if size > maxStreamFrameSize {
+			c.rxCorrupt = true
+			break
+		}
+		end := c.prefixLen + size
+		if len(rest) < end {
+			return
+		}
+		c.path.ingress(rest[c.prefixLen:end])
+		c.rxReady += end
+	}
+	c.rxReady = len(c.rxBuf)
+}
+
+func (c *linkConn) Write(p []byte) (int, error) {
+	c.txMu.Lock()
+	defer c.txMu.Unlock()
+	if c.detached {
+		return 0, errLinkDetached
+	}
+	if c.queue != nil {
+		if len(p) < c.prefixLen {
+			return c.queue.send(p)
+		}
+	} else if len(p) < c.prefixLen {
+		return c.Conn.Write(p)
+	}
+	out := p
+	if c.path.rewrites() {
+		// Writers must not modify p, so rewrite a private copy.
+		if cap(c.txScratch) < len(p) {
+			framePool.put(c.txScratch)
+			c.txScratch = framePool.get(len(p))
+		}
+		out = c.txScratch[:len(p)]
+		copy(out, p)
+	}
+	c.path.egress(out[c.prefixLen:])
+	var n int
+	var err error
+	if c.queue != nil {
+		n, err = c.queue.send(out)
+	} else {
+		c.sends.begin()
+		n, err = c.Conn.Write(out)
+		c.sends.end(err)
+	}
+	if err != nil {
+		framePool.put(c.txScratch)
+		c.txScratch = nil
+		return n, err
+	}
+	return n, nil
+}
+
+// Close stops the write queue, if any, and closes the connection.
+func (c *linkConn) Close() error {
+	c.queue.close()
+	return c.Conn.Close()
+}
+
+// writeFrame sends a frame originated by the bridge itself, adding the
+// protocol's length prefix.
+func (c *linkConn) writeFrame(frame []byte) error {
+	out := make([]byte, c.prefixLen+len(frame))
+	switch c.prefixLen {
+	case 4:
+		binary.BigEndian.PutUint32(out, uint32(len(frame)))
+	case 2:
+		binary.LittleEndian.PutUint16(out, uint16(len(frame)))
+	}
+	copy(out[c.prefixLen:], frame)
+	_, err := c.Write(out)
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+type recordingObserver struct {
+	ingress [][]byte
+	egress  [][]byte
+}
+
+func (o *recordingObserver) ingressFrame(frame []byte) {
+	o.ingress = append(o.ingress, append([]byte(nil), frame...))
+}
+
+func (o *recordingObserver) egressFrame(frame []byte) {
+	o.egress = append(o.egress, append([]byte(nil), frame...))
+}
+
+func qemuFrame(payload string) []byte {
+	buf := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[4:], payload)
+	return buf
+}
+
+// TestLinkConn_ReassemblesStreamFramesAcrossReads feeds two qemu frames in
+// awkward chunk sizes and checks both are reported intact exactly once.
+func TestLinkConn_ReassemblesStreamFramesAcrossReads(t *testing.T) {
+	vm, gw := net.Pipe()
+	obs := &recordingObserver{}
+	conn := newLinkConn(gw, types.QemuProtocol, nil, obs)
+
+	wire := append(qemuFrame("hello"), qemuFrame("world!")...)
+	go func() {
+		for _, chunk := range [][]byte{wire[:2], wire[2:7], wire[7:12], wire[12:]} {
+			vm.Write(chunk) //nolint:errcheck
+		}
+		vm.Close()
+	}()
+
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got) != string(wire) {
+		t.Fatalf("bytes must pass through unchanged")
+	}
+	if len(obs.ingress) != 2 || string(obs.ingress[0]) != "hello" || string(obs.ingress[1]) != "world!" {
+		t.Fatalf("unexpected ingress frames: %q", obs.ingress)
+	}
+}
+
+func TestLinkConn_EgressStripsLengthPrefix(t *testing.T) {
+	vm, gw := net.Pipe()
+	defer vm.Close()
+	obs := &recordingObserver{}
+	conn := newLinkConn(gw, types.QemuProtocol, nil, obs)
+
+	go io.Copy(io.Discard, vm) //nolint:errcheck
+	if _, err := conn.Write(qemuFrame("frame")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if len(obs.egress) != 1 || string(obs.egress[0]) != "frame" {
+		t.Fatalf("unexpected egress frames: %q", obs.egress)
+	}
+}
+
+func TestLinkConn_DatagramReadIsOneFrame(t *testing.T) {
+	vm, gw := net.Pipe()
+	obs := &recordingObserver{}
+	conn := newLinkConn(gw, types.VfkitProtocol, nil, obs)
+
+	go func() {
+		vm.Write([]byte("dgram")) //nolint:errcheck
+		vm.Close()
+	}()
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(obs.ingress) != 1 || string(obs.ingress[0]) != "dgram" {
+		t.Fatalf("unexpected ingress frames: %q", obs.ingress)
+	}
+}
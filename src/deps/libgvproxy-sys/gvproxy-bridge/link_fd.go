@@ -0,0 +1,105 @@
+package main
+
+// link_fd.go — Create an instance on a VM link socket the caller opened.
+//
+// gvproxy_create makes the link socket at socket_path, which is bounded by
+// the sun_path length and must be removed again. With gvproxy_create_with_fd
+// the caller passes a socket it made instead, typically one end of a
+// socketpair whose other end goes to the VMM, and socket_path may be left
+// out. The socket's type must match the protocol (SOCK_STREAM for qemu,
+// SOCK_SEQPACKET for bess, SOCK_DGRAM for vfkit):
+//
+//   - a connected socket is the link itself. There is nothing to accept
+//     again, so when the VM side closes it the link is gone for good,
+//     unless socket_path is also set: the instance then re-creates it for
+//     the VM to reconnect to (see vm_reconnect.go). vfkit's "VFKT" hello is
+//     not expected on a connected datagram socket.
+//   - a listening stream socket, or an unconnected datagram socket bound to
+//     an address, is served as if the instance had created it.
+//
+// The instance owns the FD from the call on: it is closed when the
+// instance is destroyed, and also when the create fails (dry_run included).
+// Instances created this way can be handed over by gvproxy_serialize_all.
+
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"syscall"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// linkSocketType is the socket type a link protocol runs over.
+func linkSocketType(protocol types.Protocol) (int, bool) {
+	switch protocol {
+	case types.QemuProtocol:
+		return syscall.SOCK_STREAM, true
+	case types.BessProtocol:
+		return syscall.SOCK_SEQPACKET, true
+	case types.VfkitProtocol:
+		return syscall.SOCK_DGRAM, true
+	}
+	return 0, false
+}
+
+// linkFDSocket checks the FD passed to gvproxy_create_with_fd against the
+// config and returns it as the VM socket to adopt.
+func linkFDSocket(config GvproxyConfig, protocol types.Protocol) (*VMSocketFD, error) {
+	fd := *config.LinkFD
+	switch {
+	case config.VsockListen != nil:
+		return nil, fmt.Errorf("a link fd cannot be combined with vsock_listen")
+	case config.ImportVMSocket != nil:
+		return nil, fmt.Errorf("a link fd cannot be combined with import_vm_socket")
+	case fd < 0:
+		return nil, fmt.Errorf("invalid link fd %d", fd)
+	}
+	want, ok := linkSocketType(protocol)
+	if !ok {
+		return nil, fmt.Errorf("protocol %s cannot run on a link fd", protocol)
+	}
+	sockType, listening, connected, err := socketInfo(fd)
+	if err != nil {
+		return nil, fmt.Errorf("link fd %d: %w", fd, err)
+	}
+	if sockType != want {
+		return nil, fmt.Errorf("link fd %d: socket type %d does not match protocol %s", fd, sockType, protocol)
+	}
+	if want != syscall.SOCK_DGRAM && !listening && !connected {
+		return nil, fmt.Errorf("link fd %d: socket is neither listening nor connected", fd)
+	}
+	return &VMSocketFD{FD: fd, Connected: connected}, nil
+}
+
+// gvproxy_create_with_fd is gvproxy_create on a VM link socket the caller
+// opened (see link_fd.go); socket_path is then optional. The instance owns
+// fd from this call on, whether or not the create succeeds.
+//
+// Returns the instance id, or a negative GVPROXY_ERR_* code with the error
+// written to *errOut and kept for gvproxy_last_error as in gvproxy_create.
+//
+//export gvproxy_create_with_fd
+func gvproxy_create_with_fd(configJSON *C.char, fd C.int, errOut **C.char) C.longlong {
+	setLastError(nil)
+	setErr := func(err error) { reportCreateError(errOut, err) }
+
+	var config GvproxyConfig
+	if err := json.Unmarshal([]byte(C.GoString(configJSON)), &config); err != nil {
+		logrus.WithError(err).Error("Failed to parse gvproxy config")
+		closeFD(int(fd))
+		err = classify(createErrConfig, err)
+		setErr(err)
+		return createErrorCode(err)
+	}
+	linkFD := int(fd)
+	config.LinkFD = &linkFD
+
+	id, err := createInstance(config)
+	if err != nil {
+		setErr(err)
+		return createErrorCode(err)
+	}
+	return C.longlong(id)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// vmSide wraps the VM's end of a socketpair.
+func vmSide(t *testing.T, fd int) net.Conn {
+	t.Helper()
+	f := os.NewFile(uintptr(fd), "vm-side")
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	if err != nil {
+		t.Fatalf("FileConn: %v", err)
+	}
+	return conn
+}
+
+func TestLinkFDSocket(t *testing.T) {
+	config := testGvproxyConfig()
+	check := func(fd int, protocol types.Protocol) (*VMSocketFD, error) {
+		config.LinkFD = &fd
+		return linkFDSocket(config, protocol)
+	}
+
+	stream, err := socketpair(syscall.SOCK_STREAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(stream[0])
+	defer syscall.Close(stream[1])
+	if s, err := check(stream[0], types.QemuProtocol); err != nil || !s.Connected {
+		t.Errorf("connected stream for qemu: %+v, %v", s, err)
+	}
+	if _, err := check(stream[0], types.VfkitProtocol); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("stream for vfkit: err = %v", err)
+	}
+	if _, err := check(stream[0], types.StdioProtocol); err == nil {
+		t.Error("stdio must not run on a link fd")
+	}
+
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "vm.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	lf, err := l.(*net.UnixListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	if s, err := check(int(lf.Fd()), types.QemuProtocol); err != nil || s.Connected {
+		t.Errorf("listening stream for qemu: %+v, %v", s, err)
+	}
+
+	unbound, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(unbound)
+	if _, err := check(unbound, types.QemuProtocol); err == nil || !strings.Contains(err.Error(), "neither listening nor connected") {
+		t.Errorf("unconnected stream: err = %v", err)
+	}
+}
+
+func TestStartInstance_LinkFDQemu(t *testing.T) {
+	fds, err := socketpair(syscall.SOCK_STREAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := vmSide(t, fds[1])
+	defer vm.Close()
+
+	config := testGvproxyConfig()
+	config.SocketPath = ""
+	config.Protocol = "qemu"
+	config.LinkFD = &fds[0]
+	const id = 5910
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	resolveGatewayQemu(t, vm, config)
+
+	// Destroying the instance closes its end.
+	stopTestInstance(id)
+	vm.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	if _, err := io.Copy(io.Discard, vm); err != nil {
+		t.Fatalf("VM side not closed on destroy: %v", err)
+	}
+}
+
+func TestStartInstance_LinkFDVfkitConnected(t *testing.T) {
+	fds, err := socketpair(syscall.SOCK_DGRAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := vmSide(t, fds[1])
+	defer vm.Close()
+
+	config := testGvproxyConfig()
+	config.SocketPath = ""
+	config.Protocol = "vfkit"
+	config.LinkFD = &fds[0]
+	const id = 5911
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	// No "VFKT" hello: the socket is connected already.
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if _, err := vm.Write(arpRequestFrame(mustMAC(config.GuestMac), broadcast, net.ParseIP(config.GuestIP).To4(), net.ParseIP(config.GatewayIP).To4())); err != nil {
+		t.Fatalf("write ARP request: %v", err)
+	}
+	vm.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	buf := make([]byte, 2048)
+	for {
+		n, err := vm.Read(buf)
+		if err != nil {
+			t.Fatalf("read reply: %v", err)
+		}
+		if arp := frameARP(buf[:n]); arp != nil && arp.Op() == header.ARPReply {
+			return
+		}
+	}
+}
+
+func TestStartInstance_LinkFDClosedOnFailure(t *testing.T) {
+	fds, err := socketpair(syscall.SOCK_STREAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := vmSide(t, fds[1])
+	defer vm.Close()
+
+	config := testGvproxyConfig()
+	config.SocketPath = ""
+	config.Protocol = "vfkit" // does not match the stream socket
+	config.LinkFD = &fds[0]
+	err = startInstance(5912, config)
+	if err == nil || createErrorClassOf(err) != createErrConfig {
+		t.Fatalf("startInstance = %v, want a config error", err)
+	}
+	vm.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	if _, err := io.Copy(io.Discard, vm); err != nil {
+		t.Fatalf("link fd not closed after a failed create: %v", err)
+	}
+}
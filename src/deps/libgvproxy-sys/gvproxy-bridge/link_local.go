@@ -0,0 +1,105 @@
+package main
+
+// link_local.go — What happens to guests on 169.254.0.0/16 addresses.
+//
+// A guest whose DHCP client gives up falls back to an APIPA address in
+// 169.254.0.0/16 and keeps talking from it. Nothing in the virtual network
+// was written with such a guest in mind: its flows happen to be NATed like
+// any other, so it half works, and telling that apart from a guest with a
+// proper lease means reading packet captures. link_local_policy makes the
+// behavior explicit for new TCP and UDP flows from a link-local source:
+//
+//   - "nat" (the default): forwarded like any other guest flow.
+//   - "drop": discarded; the guest times out.
+//   - "answer": the gateway answers itself, with a TCP RST or an ICMP port
+//     unreachable, so the guest fails fast.
+//
+// Every such flow is counted in the "LinkLocal" stats section, whatever
+// the policy. Link-local destinations (169.254.169.254 and the like) are
+// not affected: they are never forwarded.
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// link_local_policy values.
+const (
+	linkLocalNAT    = "nat"
+	linkLocalDrop   = "drop"
+	linkLocalAnswer = "answer"
+)
+
+// linkLocalPolicy applies link_local_policy to flows from link-local guest
+// addresses.
+type linkLocalPolicy struct {
+	policy string
+
+	nated    atomic.Uint64
+	dropped  atomic.Uint64
+	answered atomic.Uint64
+}
+
+// newLinkLocalPolicy validates link_local_policy; "" selects "nat".
+func newLinkLocalPolicy(policy string) (*linkLocalPolicy, error) {
+	switch policy {
+	case "":
+		policy = linkLocalNAT
+	case linkLocalNAT, linkLocalDrop, linkLocalAnswer:
+	default:
+		return nil, fmt.Errorf("invalid link_local_policy %q: want %q, %q or %q", policy, linkLocalNAT, linkLocalDrop, linkLocalAnswer)
+	}
+	return &linkLocalPolicy{policy: policy}, nil
+}
+
+// gate wraps a transport protocol handler so that new flows from link-local
+// sources follow the policy. Leaving a packet unhandled makes the stack
+// answer it: a RST for TCP, a port unreachable for UDP. A nil p gates
+// nothing.
+func (p *linkLocalPolicy) gate(handler func(stack.TransportEndpointID, *stack.PacketBuffer) bool) func(stack.TransportEndpointID, *stack.PacketBuffer) bool {
+	if p == nil {
+		return handler
+	}
+	return func(id stack.TransportEndpointID, pkt *stack.PacketBuffer) bool {
+		if !linkLocalSubnet.Contains(id.RemoteAddress) {
+			return handler(id, pkt)
+		}
+		switch p.policy {
+		case linkLocalDrop:
+			p.dropped.Add(1)
+			return true
+		case linkLocalAnswer:
+			p.answered.Add(1)
+			return false
+		}
+		p.nated.Add(1)
+		return handler(id, pkt)
+	}
+}
+
+// LinkLocalStats is the "LinkLocal" stats section.
+type LinkLocalStats struct {
+	Policy   string `json:"Policy"`
+	NATed    uint64 `json:"NATed"`    // flows forwarded
+	Dropped  uint64 `json:"Dropped"`  // flows discarded
+	Answered uint64 `json:"Answered"` // flows refused by the gateway
+}
+
+type linkLocalCollector struct{ p *linkLocalPolicy }
+
+func (linkLocalCollector) Name() string { return "LinkLocal" }
+
+func (linkLocalCollector) Description() string {
+	return "New TCP and UDP flows from link-local (169.254.0.0/16) guest addresses, by what link_local_policy did with them."
+}
+
+func (c linkLocalCollector) Collect() any {
+	return LinkLocalStats{
+		Policy:   c.p.policy,
+		NATed:    c.p.nated.Load(),
+		Dropped:  c.p.dropped.Load(),
+		Answered: c.p.answered.Load(),
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestNewLinkLocalPolicy(t *testing.T) {
+	p, err := newLinkLocalPolicy("")
+	if err != nil || p.policy != linkLocalNAT {
+		t.Fatalf("default policy = %v, %v; want nat", p, err)
+	}
+	if _, err := newLinkLocalPolicy("reject"); err == nil {
+		t.Fatal("expected an unknown policy to be rejected")
+	}
+}
+
+func TestLinkLocalPolicy_Gate(t *testing.T) {
+	apipa := stack.TransportEndpointID{RemoteAddress: tcpip.AddrFrom4Slice(net.ParseIP("169.254.10.20").To4())}
+	leased := stack.TransportEndpointID{RemoteAddress: tcpip.AddrFrom4Slice(net.ParseIP("192.168.127.2").To4())}
+
+	for _, tc := range []struct {
+		policy      string
+		handled     bool // what the gate returns for the APIPA flow
+		forwarded   int  // flows that reached the forwarder
+		wantCounted LinkLocalStats
+	}{
+		{linkLocalNAT, true, 2, LinkLocalStats{Policy: linkLocalNAT, NATed: 1}},
+		{linkLocalDrop, true, 1, LinkLocalStats{Policy: linkLocalDrop, Dropped: 1}},
+		{linkLocalAnswer, false, 1, LinkLocalStats{Policy: linkLocalAnswer, Answered: 1}},
+	} {
+		p, err := newLinkLocalPolicy(tc.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forwarded := 0
+		gate := p.gate(func(stack.TransportEndpointID, *stack.PacketBuffer) bool {
+			forwarded++
+			return true
+		})
+		if !gate(leased, nil) {
+			t.Errorf("%s: a leased guest's flow must be forwarded", tc.policy)
+		}
+		if got := gate(apipa, nil); got != tc.handled {
+			t.Errorf("%s: handled = %v, want %v", tc.policy, got, tc.handled)
+		}
+		if forwarded != tc.forwarded {
+			t.Errorf("%s: %d flows forwarded, want %d", tc.policy, forwarded, tc.forwarded)
+		}
+		if got := (linkLocalCollector{p}).Collect(); got != tc.wantCounted {
+			t.Errorf("%s: stats = %+v, want %+v", tc.policy, got, tc.wantCounted)
+		}
+	}
+}
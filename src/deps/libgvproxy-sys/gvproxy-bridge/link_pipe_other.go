@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// isNamedPipe reports whether path names a Windows named pipe: never here.
+func isNamedPipe(string) bool {
+	return false
+}
+
+func listenNamedPipe(string) (net.Listener, error) {
+	return nil, errors.New("named pipes are only supported on windows")
+}
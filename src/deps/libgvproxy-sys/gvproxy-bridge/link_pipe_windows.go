@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// isNamedPipe reports whether path names a Windows named pipe.
+func isNamedPipe(path string) bool {
+	return strings.HasPrefix(path, `\\.\pipe\`)
+}
+
+// listenNamedPipe creates the named pipe a VMM connects its stream link to.
+func listenNamedPipe(path string) (net.Listener, error) {
+	return winio.ListenPipe(path, nil)
+}
@@ -0,0 +1,396 @@
+package main
+
+// link_priority.go — Keep interactive traffic to the guest responsive under
+// bulk load.
+//
+// Frames to the guest are written to the hypervisor socket in the order the
+// netstack produces them. During a large transfer (an image pull) the socket
+// is kept full of bulk segments, and an SSH keystroke echo or a terminal
+// redraw waits behind all of them. With link_priority set, each frame is
+// classified on its way to the guest and held in one of two bounded queues;
+// a writer sends interactive frames first and bulk frames when no
+// interactive one is waiting. A full queue blocks the netstack as the
+// socket itself would.
+//
+// A frame is interactive when:
+//
+//   - its IP DSCP is one of dscp (e.g. 46 for EF, 48 for CS6);
+//   - its TCP or UDP source or destination port is one of ports (default
+//     [22]; [] for none);
+//   - it is at most small_frame_bytes (default 256) and is not a TCP segment
+//     carrying data, FIN or RST: ARP, ICMP, DNS and pure ACKs.
+//
+// Data, FIN and RST segments of a flow are always classified by the first
+// two rules, so they stay in one queue and in order; only pure ACKs and
+// small datagrams can overtake a bulk flow's data.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	// defaultSmallFrameBytes is small_frame_bytes when unset.
+	defaultSmallFrameBytes = 256
+	// defaultPriorityQueueFrames is queue_frames when unset.
+	defaultPriorityQueueFrames = 256
+)
+
+// defaultInteractivePorts is ports when unset: SSH.
+var defaultInteractivePorts = []int{22}
+
+// LinkPriority configures two-class queueing of frames to the guest.
+type LinkPriority struct {
+	// SmallFrameBytes is the size up to which a frame without TCP data is
+	// interactive. 0 => 256.
+	SmallFrameBytes int `json:"small_frame_bytes,omitempty"`
+	// Ports whose TCP and UDP traffic is interactive. Nil => [22].
+	Ports []int `json:"ports"`
+	// DSCP values whose traffic is interactive.
+	DSCP []int `json:"dscp,omitempty"`
+	// QueueFrames bounds each queue. 0 => 256.
+	QueueFrames int `json:"queue_frames,omitempty"`
+}
+
+// checkLinkPriority validates link_priority.
+func checkLinkPriority(p *LinkPriority) error {
+	if p == nil {
+		return nil
+	}
+	if p.SmallFrameBytes < 0 || p.QueueFrames < 0 {
+		return errors.New("small_frame_bytes and queue_frames must not be negative")
+	}
+	for _, port := range p.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("port %d out of range", port)
+		}
+	}
+	for _, dscp := range p.DSCP {
+		if dscp < 0 || dscp > 63 {
+			return fmt.Errorf("dscp %d out of range (0-63)", dscp)
+		}
+	}
+	return nil
+}
+
+// linkPriority classifies frames to the guest and counts what it sent. It
+// is shared by every link of an instance; attach gives each its queue. A
+// nil *linkPriority leaves links unqueued.
+type linkPriority struct {
+	smallFrame int
+	ports      map[uint16]bool
+	dscp       [64]bool
+	capacity   int
+
+	interactive atomic.Uint64
+	bulk        atomic.Uint64
+	overtaken   atomic.Uint64
+}
+
+func newLinkPriority(p *LinkPriority) *linkPriority {
+	if p == nil {
+		return nil
+	}
+	lp := &linkPriority{
+		smallFrame: p.SmallFrameBytes,
+		ports:      make(map[uint16]bool),
+		capacity:   p.QueueFrames,
+	}
+	if lp.smallFrame == 0 {
+		lp.smallFrame = defaultSmallFrameBytes
+	}
+	if lp.capacity == 0 {
+		lp.capacity = defaultPriorityQueueFrames
+	}
+	ports := p.Ports
+	if ports == nil {
+		ports = defaultInteractivePorts
+	}
+	for _, port := range ports {
+		lp.ports[uint16(port)] = true
+	}
+	for _, dscp := range p.DSCP {
+		lp.dscp[dscp] = true
+	}
+	return lp
+}
+
+// isInteractive classifies an Ethernet frame (see link_priority.go).
+func (lp *linkPriority) isInteractive(frame []byte) bool {
+	if len(frame) < header.EthernetMinimumSize {
+		return false
+	}
+	var tos uint8
+	var proto uint8
+	var transport []byte
+	switch header.Ethernet(frame).Type() {
+	case header.IPv4ProtocolNumber:
+		ip := frameIPv4(frame)
+		if ip == nil {
+			return false
+		}
+		tos, _ = ip.TOS()
+		proto = ip.Protocol()
+		if ip.FragmentOffset() == 0 {
+			transport = ip.Payload()
+		}
+	case header.IPv6ProtocolNumber:
+		ip := header.IPv6(frame[header.EthernetMinimumSize:])
+		if !ip.IsValid(len(ip)) {
+			return false
+		}
+		tos, _ = ip.TOS()
+		proto = ip.NextHeader()
+		transport = ip.Payload()
+	default:
+		return len(frame) <= lp.smallFrame
+	}
+	if lp.dscp[tos>>2] {
+		return true
+	}
+
+	// Data, FIN and RST must not overtake the flow's queued data.
+	ordered := false
+	switch proto {
+	case uint8(header.TCPProtocolNumber):
+		if len(transport) >= header.TCPMinimumSize {
+			tcp := header.TCP(transport)
+			if lp.ports[tcp.SourcePort()] || lp.ports[tcp.DestinationPort()] {
+				return true
+			}
+			ordered = len(transport) > int(tcp.DataOffset()) ||
+				tcp.Flags()&(header.TCPFlagFin|header.TCPFlagRst) != 0
+		} else {
+			ordered = true // a fragment or a truncated header
+		}
+	case uint8(header.UDPProtocolNumber):
+		if len(transport) >= header.UDPMinimumSize {
+			udp := header.UDP(transport)
+			if lp.ports[udp.SourcePort()] || lp.ports[udp.DestinationPort()] {
+				return true
+			}
+		}
+	}
+	return !ordered && len(frame) <= lp.smallFrame
+}
+
+// attach starts the queue of a link writing to conn, whose frames carry a
+// prefixLen length prefix. It returns nil if lp is nil.
+func (lp *linkPriority) attach(conn net.Conn, prefixLen int, sends *sendStalls) *priorityQueue {
+	if lp == nil {
+		return nil
+	}
+	q := &priorityQueue{
+		policy:    lp,
+		conn:      conn,
+		prefixLen: prefixLen,
+		sends:     sends,
+		done:      make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// priorityQueue holds the frames of one link until its writer sends them.
+type priorityQueue struct {
+	policy    *linkPriority
+	conn      net.Conn
+	prefixLen int
+	sends     *sendStalls
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	interactive [][]byte
+	bulk        [][]byte
+	err         error // first write error; every later send fails with it
+	draining    bool  // send nothing more, write what is queued, then stop
+	closed      bool  // stop now, dropping what is queued
+	done        chan struct{}
+}
+
+// errLinkQueueClosed is returned by sends after the queue was stopped.
+var errLinkQueueClosed = errors.New("link queue closed")
+
+// send queues p, a whole frame with its length prefix, blocking while its
+// class's queue is full. It fails with the error of an earlier write.
+func (q *priorityQueue) send(p []byte) (int, error) {
+	interactive := len(p) > q.prefixLen && q.policy.isInteractive(p[q.prefixLen:])
+	buf := framePool.get(len(p))
+	copy(buf, p)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queue := &q.bulk
+	if interactive {
+		queue = &q.interactive
+	}
+	for len(*queue) >= q.policy.capacity && q.err == nil && !q.draining && !q.closed {
+		q.cond.Wait()
+	}
+	if q.err != nil || q.draining || q.closed {
+		framePool.put(buf)
+		if q.err != nil {
+			return 0, q.err
+		}
+		return 0, errLinkQueueClosed
+	}
+	*queue = append(*queue, buf)
+	q.cond.Broadcast()
+	return len(p), nil
+}
+
+// next waits for a frame to write, interactive ones first. It returns nil
+// once the queue is stopped.
+func (q *priorityQueue) next() []byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.interactive) == 0 && len(q.bulk) == 0 && !q.draining && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil
+	}
+	var frame []byte
+	switch {
+	case len(q.interactive) > 0:
+		frame, q.interactive = q.interactive[0], q.interactive[1:]
+		q.policy.interactive.Add(1)
+		if len(q.bulk) > 0 {
+			q.policy.overtaken.Add(1)
+		}
+	case len(q.bulk) > 0:
+		frame, q.bulk = q.bulk[0], q.bulk[1:]
+		q.policy.bulk.Add(1)
+	default:
+		return nil // drained
+	}
+	q.cond.Broadcast()
+	return frame
+}
+
+// run writes queued frames until the queue is stopped or a write fails.
+func (q *priorityQueue) run() {
+	defer close(q.done)
+	for {
+		frame := q.next()
+		if frame == nil {
+			return
+		}
+		err := q.write(frame)
+		framePool.put(frame)
+		if err != nil {
+			q.mu.Lock()
+			q.err = err
+			q.discard()
+			q.cond.Broadcast()
+			q.mu.Unlock()
+			return
+		}
+	}
+}
+
+// write sends one frame, retrying while the socket buffer is full as the
+// switch does.
+func (q *priorityQueue) write(frame []byte) error {
+	for {
+		q.sends.begin()
+		_, err := q.conn.Write(frame)
+		q.sends.end(err)
+		if !errors.Is(err, syscall.ENOBUFS) {
+			return err
+		}
+		q.mu.Lock()
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return errLinkQueueClosed
+		}
+	}
+}
+
+// discard drops every queued frame. q.mu must be held.
+func (q *priorityQueue) discard() {
+	for _, frame := range q.interactive {
+		framePool.put(frame)
+	}
+	for _, frame := range q.bulk {
+		framePool.put(frame)
+	}
+	q.interactive, q.bulk = nil, nil
+}
+
+// depths returns the number of interactive and bulk frames queued. A nil
+// *priorityQueue queues nothing.
+func (q *priorityQueue) depths() (interactive, bulk int) {
+	if q == nil {
+		return 0, 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.interactive), len(q.bulk)
+}
+
+// flush stops taking frames and waits up to timeout for the queued ones to
+// be written. A nil *priorityQueue has nothing to flush.
+func (q *priorityQueue) flush(timeout time.Duration) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	q.draining = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+		return nil
+	case <-time.After(timeout):
+		q.close()
+		return errors.New("link queue did not drain")
+	}
+}
+
+// close stops the writer and drops what is still queued. A nil
+// *priorityQueue ignores it.
+func (q *priorityQueue) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.closed = true
+	q.discard()
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// LinkPriorityStats is the "LinkPriority" stats section.
+type LinkPriorityStats struct {
+	Interactive uint64 `json:"Interactive"` // frames sent from the interactive queue
+	Bulk        uint64 `json:"Bulk"`        // frames sent from the bulk queue
+	Overtaken   uint64 `json:"Overtaken"`   // interactive frames sent while bulk ones waited
+}
+
+// linkPriorityCollector publishes queueing counters as the "LinkPriority"
+// section.
+type linkPriorityCollector struct{ lp *linkPriority }
+
+func (linkPriorityCollector) Name() string { return "LinkPriority" }
+
+func (linkPriorityCollector) Description() string {
+	return "Frames to the guest sent from the interactive and bulk queues, and interactive frames sent ahead of waiting bulk ones."
+}
+
+func (c linkPriorityCollector) Collect() any {
+	return LinkPriorityStats{
+		Interactive: c.lp.interactive.Load(),
+		Bulk:        c.lp.bulk.Load(),
+		Overtaken:   c.lp.overtaken.Load(),
+	}
+}
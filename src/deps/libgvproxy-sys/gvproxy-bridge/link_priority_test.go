@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// testTCPDataFrame builds a TCP segment frame carrying n bytes of data.
+func testTCPDataFrame(srcPort, dstPort uint16, n int) []byte {
+	seg := append(testTCPSegment(srcPort, dstPort, header.TCPFlagAck), make([]byte, n)...)
+	return testIPv4Frame(header.TCPProtocolNumber, "192.168.127.1", "192.168.127.2", seg)
+}
+
+func TestLinkPriority_Classify(t *testing.T) {
+	lp := newLinkPriority(&LinkPriority{DSCP: []int{46}})
+	marked := testTCPDataFrame(80, 40000, 1400)
+	marked[header.EthernetMinimumSize+1] = 46 << 2
+
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		want  bool
+	}{
+		{"pure ACK", testIPv4Frame(header.TCPProtocolNumber, "192.168.127.1", "192.168.127.2", testTCPSegment(80, 40000, header.TCPFlagAck)), true},
+		{"FIN", testIPv4Frame(header.TCPProtocolNumber, "192.168.127.1", "192.168.127.2", testTCPSegment(80, 40000, header.TCPFlagFin|header.TCPFlagAck)), false},
+		{"RST", testIPv4Frame(header.TCPProtocolNumber, "192.168.127.1", "192.168.127.2", testTCPSegment(80, 40000, header.TCPFlagRst)), false},
+		{"bulk data", testTCPDataFrame(80, 40000, 1400), false},
+		{"small data", testTCPDataFrame(80, 40000, 10), false},
+		{"ssh data", testTCPDataFrame(40000, 22, 1400), true},
+		{"dns reply", testIPv4Frame(header.UDPProtocolNumber, "192.168.127.1", "192.168.127.2", testUDPDatagram(53, 40000, make([]byte, 60))), true},
+		{"large datagram", testIPv4Frame(header.UDPProtocolNumber, "192.168.127.1", "192.168.127.2", testUDPDatagram(443, 40000, make([]byte, 1200))), false},
+		{"dscp EF", marked, true},
+	} {
+		if got := lp.isInteractive(tc.frame); got != tc.want {
+			t.Errorf("%s: interactive = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if newLinkPriority(&LinkPriority{Ports: []int{}}).isInteractive(testTCPDataFrame(40000, 22, 1400)) {
+		t.Error("ports [] must not default to SSH")
+	}
+}
+
+func TestCheckLinkPriority(t *testing.T) {
+	for _, p := range []LinkPriority{{Ports: []int{0}}, {DSCP: []int{64}}, {QueueFrames: -1}} {
+		if err := checkLinkPriority(&p); err == nil {
+			t.Errorf("checkLinkPriority(%+v) accepted", p)
+		}
+	}
+}
+
+// testPrefixed adds the qemu length prefix to frame.
+func testPrefixed(frame []byte) []byte {
+	out := make([]byte, 4+len(frame))
+	binary.BigEndian.PutUint32(out, uint32(len(frame)))
+	copy(out[4:], frame)
+	return out
+}
+
+// readPrefixedFrame reads one length-prefixed frame from the VM end.
+func readPrefixedFrame(t *testing.T, vm net.Conn) []byte {
+	t.Helper()
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(vm, prefix); err != nil {
+		t.Fatalf("read prefix: %v", err)
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(prefix))
+	if _, err := io.ReadFull(vm, frame); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	return frame
+}
+
+// waitBulkTaken waits until the writer has taken every queued bulk frame
+// and blocks on the pipe.
+func waitBulkTaken(t *testing.T, q *priorityQueue) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, bulk := q.depths(); bulk == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("writer never took the first frame")
+		}
+	}
+}
+
+// TestLinkConn_PrioritySendsInteractiveFirst queues bulk frames behind one
+// the VM has not read yet, then an SSH frame, which must come out next.
+func TestLinkConn_PrioritySendsInteractiveFirst(t *testing.T) {
+	vm, gw := net.Pipe()
+	defer vm.Close()
+	lp := newLinkPriority(&LinkPriority{})
+	link := newLinkConn(gw, types.QemuProtocol, nil)
+	link.queue = lp.attach(gw, link.prefixLen, nil)
+	defer link.Close()
+
+	bulk := []byte(nil)
+	for i := 0; i < 3; i++ {
+		frame := testTCPDataFrame(80, 40000, 1000)
+		frame[len(frame)-1] = byte(i)
+		if i == 0 {
+			bulk = frame
+		}
+		if _, err := link.Write(testPrefixed(frame)); err != nil {
+			t.Fatalf("write bulk %d: %v", i, err)
+		}
+		if i == 0 {
+			waitBulkTaken(t, link.queue)
+		}
+	}
+	ssh := testTCPDataFrame(22, 40000, 100)
+	if _, err := link.Write(testPrefixed(ssh)); err != nil {
+		t.Fatalf("write ssh: %v", err)
+	}
+
+	if got := readPrefixedFrame(t, vm); !bytes.Equal(got, bulk) {
+		t.Fatal("first frame is not the one already being written")
+	}
+	if got := readPrefixedFrame(t, vm); !bytes.Equal(got, ssh) {
+		t.Fatal("SSH frame did not overtake the queued bulk frames")
+	}
+	for i := 1; i < 3; i++ {
+		if got := readPrefixedFrame(t, vm); got[len(got)-1] != byte(i) {
+			t.Fatalf("bulk frames out of order: got %d, want %d", got[len(got)-1], i)
+		}
+	}
+	stats := linkPriorityCollector{lp}.Collect().(LinkPriorityStats)
+	if stats.Interactive != 1 || stats.Bulk != 3 || stats.Overtaken != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+// TestLinkConn_PriorityKeepsFINBehindBulkData queues a flow's bulk data
+// behind a frame the VM has not read yet, then the flow's FIN, which must
+// not overtake the data.
+func TestLinkConn_PriorityKeepsFINBehindBulkData(t *testing.T) {
+	vm, gw := net.Pipe()
+	defer vm.Close()
+	lp := newLinkPriority(&LinkPriority{})
+	link := newLinkConn(gw, types.QemuProtocol, nil)
+	link.queue = lp.attach(gw, link.prefixLen, nil)
+	defer link.Close()
+
+	for i := 0; i < 3; i++ {
+		frame := testTCPDataFrame(80, 40000, 1000)
+		frame[len(frame)-1] = byte(i)
+		if _, err := link.Write(testPrefixed(frame)); err != nil {
+			t.Fatalf("write bulk %d: %v", i, err)
+		}
+		if i == 0 {
+			waitBulkTaken(t, link.queue)
+		}
+	}
+	fin := testIPv4Frame(header.TCPProtocolNumber, "192.168.127.1", "192.168.127.2", testTCPSegment(80, 40000, header.TCPFlagFin|header.TCPFlagAck))
+	if _, err := link.Write(testPrefixed(fin)); err != nil {
+		t.Fatalf("write FIN: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := readPrefixedFrame(t, vm); len(got) != len(fin)+1000 || got[len(got)-1] != byte(i) {
+			t.Fatalf("frame %d is not bulk data %d: the FIN overtook it", i, i)
+		}
+	}
+	if got := readPrefixedFrame(t, vm); !bytes.Equal(got, fin) {
+		t.Fatal("FIN was not sent after the flow's data")
+	}
+	stats := linkPriorityCollector{lp}.Collect().(LinkPriorityStats)
+	if stats.Interactive != 0 || stats.Bulk != 4 || stats.Overtaken != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+// link_protocol.go — Select the VM link protocol.
+//
+// The link protocol decides what the VM connects to (usually the socket at
+// socket_path) and how frames are delimited on it:
+//
+//   - "qemu": a Unix stream socket, each frame prefixed by its length (QEMU
+//     -netdev stream, libkrun on Linux);
+//   - "vfkit": a Unix datagram socket, one frame per datagram (vfkit,
+//     libkrun on macOS); the VM announces itself with a "VFKT" datagram;
+//   - "bess": a Unix seqpacket socket, one bare frame per packet (VMMs with
+//     a BESS-style vhost/packet backend). Like qemu, the VM connects to the
+//     socket; unlike qemu, the socket keeps frame boundaries. Linux only:
+//     macOS has no SOCK_SEQPACKET Unix sockets;
+//   - "stdio": inherited pipe FDs (stdio_fds), each frame prefixed by its
+//     length, for a supervisor that starts the VMM itself. See
+//     stdio_link.go.
+//
+// Unset, the platform's hypervisor decides: vfkit on macOS, qemu elsewhere.
+// qemu and vfkit work on macOS and Linux, so a caller can run QEMU on macOS
+// or a datagram transport on Linux by setting protocol explicitly. The other
+// gvisor-tap-vsock protocol, hyperkit, is rejected.
+//
+// On Windows only qemu is available: socket_path is an AF_UNIX stream
+// socket, or a named pipe when it starts with \\.\pipe\. Guests that connect
+// over Hyper-V sockets use vsock_listen instead.
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+// linkProtocol resolves the protocol config field.
+func linkProtocol(name string) (types.Protocol, error) {
+	switch types.Protocol(name) {
+	case "":
+		if runtime.GOOS == "darwin" {
+			return types.VfkitProtocol, nil
+		}
+		return types.QemuProtocol, nil
+	case types.QemuProtocol:
+		return types.QemuProtocol, nil
+	case types.VfkitProtocol, types.StdioProtocol:
+		// Windows has neither Unix datagram sockets nor pollable pipe FDs.
+		if runtime.GOOS != "windows" {
+			return types.Protocol(name), nil
+		}
+	case types.BessProtocol:
+		if runtime.GOOS == "linux" {
+			return types.BessProtocol, nil
+		}
+	case types.HyperKitProtocol:
+	default:
+		return "", classify(createErrConfig, fmt.Errorf("unknown protocol %q (want qemu, vfkit, bess or stdio)", name))
+	}
+	return "", classify(createErrUnsupported, fmt.Errorf("protocol %q is not supported on %s (want qemu, vfkit, bess or stdio)", name, runtime.GOOS))
+}
+
+// isDatagramProtocol reports whether the link uses a datagram socket.
+func isDatagramProtocol(protocol types.Protocol) bool {
+	return protocol == types.VfkitProtocol
+}
+
+// linkNetwork is the socket type of a link the VM connects to: seqpacket for
+// bess, stream for qemu.
+func linkNetwork(protocol types.Protocol) string {
+	if protocol == types.BessProtocol {
+		return "unixpacket"
+	}
+	return "unix"
+}
+
+// listenLink binds the socket at path a VM connects its qemu or bess link
+// to.
+func listenLink(protocol types.Protocol, path string) (net.Listener, error) {
+	if isNamedPipe(path) {
+		return listenNamedPipe(path)
+	}
+	return net.Listen(linkNetwork(protocol), path)
+}
+
+// removeLinkSocket unlinks the socket at path. Named pipes and abstract
+// sockets vanish with their last handle and have nothing to unlink.
+func removeLinkSocket(path string) error {
+	if path == "" || isNamedPipe(path) || isAbstractSocket(path) {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// listenVfkit binds the datagram socket of a vfkit link. It stands in for
+// transport.ListenUnixgram, which only exists on macOS.
+func listenVfkit(path string) (*net.UnixConn, error) {
+	return net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+}
+
+// acceptVfkit waits for the VM's "VFKT" datagram and returns the socket
+// connected to its address. It stands in for transport.AcceptVfkit, which
+// only exists on macOS.
+func acceptVfkit(conn *net.UnixConn) (*vfkitConn, error) {
+	magic := make([]byte, 4)
+	n, addr, err := conn.ReadFrom(magic)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(magic) || !bytes.Equal(magic, []byte("VFKT")) {
+		return nil, fmt.Errorf("invalid magic from the vfkit process: %s", hex.EncodeToString(magic[:n]))
+	}
+	remote, ok := addr.(*net.UnixAddr)
+	if !ok {
+		return nil, fmt.Errorf("unexpected vfkit peer address type %T", addr)
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		if sockErr = setsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 1<<20); sockErr != nil {
+			return
+		}
+		sockErr = setsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 4<<20)
+	}); err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+	c := &vfkitConn{UnixConn: conn}
+	c.remote.Store(remote)
+	return c, nil
+}
+
+// vfkitConn is the datagram socket, writing to the VM's address. A restarted
+// VMM says "VFKT" again from its new address; the conn then writes there
+// (see vm_reconnect.go).
+type vfkitConn struct {
+	*net.UnixConn
+	remote atomic.Pointer[net.UnixAddr]
+	// onHello, if set, is called with the new address when the VMM
+	// reconnects.
+	onHello func(remote *net.UnixAddr)
+}
+
+func (c *vfkitConn) RemoteAddr() net.Addr { return c.remote.Load() }
+
+func (c *vfkitConn) Read(b []byte) (int, error) {
+	for {
+		n, addr, err := c.ReadFrom(b)
+		if err != nil || n != 4 || !bytes.Equal(b[:n], []byte("VFKT")) {
+			return n, err
+		}
+		// Not a frame: the VMM announcing itself again, possibly from a
+		// new address.
+		remote, ok := addr.(*net.UnixAddr)
+		if !ok {
+			continue
+		}
+		c.remote.Store(remote)
+		if c.onHello != nil {
+			c.onHello(remote)
+		}
+	}
+}
+
+func (c *vfkitConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.remote.Load())
+}
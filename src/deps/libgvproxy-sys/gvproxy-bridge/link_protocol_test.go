@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestLinkProtocol(t *testing.T) {
+	platform := types.QemuProtocol
+	if runtime.GOOS == "darwin" {
+		platform = types.VfkitProtocol
+	}
+	supported := map[string]types.Protocol{"": platform, "qemu": types.QemuProtocol, "vfkit": types.VfkitProtocol, "stdio": types.StdioProtocol}
+	unsupported := map[string]string{"hyperkit": "not supported", "QEMU": "unknown protocol"}
+	if runtime.GOOS == "linux" {
+		supported["bess"] = types.BessProtocol
+	} else {
+		unsupported["bess"] = "not supported"
+	}
+	if runtime.GOOS == "windows" {
+		delete(supported, "vfkit")
+		delete(supported, "stdio")
+		unsupported["vfkit"] = "not supported"
+		unsupported["stdio"] = "not supported"
+	}
+	for name, want := range supported {
+		if got, err := linkProtocol(name); err != nil || got != want {
+			t.Errorf("linkProtocol(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	for name, want := range unsupported {
+		if _, err := linkProtocol(name); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("linkProtocol(%q) error = %v, want %q", name, err, want)
+		}
+	}
+}
+
+func TestRemoveLinkSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "link.sock")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeLinkSocket(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket not removed: %v", err)
+	}
+	// Nothing to unlink for an unset path, or a named pipe on Windows.
+	paths := []string{""}
+	if runtime.GOOS == "windows" {
+		paths = append(paths, `\\.\pipe\gvproxy-test`)
+	}
+	for _, p := range paths {
+		if err := removeLinkSocket(p); err != nil {
+			t.Errorf("removeLinkSocket(%q) = %v", p, err)
+		}
+	}
+}
+
+// TestStartInstance_VfkitProtocolOnAnyOS runs the datagram link on whatever
+// platform the tests run on, with the VM announcing itself like vfkit.
+func TestStartInstance_VfkitProtocolOnAnyOS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gvp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(dir, "vm.sock")
+	config.Protocol = "vfkit"
+	const id = 5700
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	vmAddr := &net.UnixAddr{Name: filepath.Join(dir, "vfkit.sock"), Net: "unixgram"}
+	vm, err := net.DialUnix("unixgram", vmAddr, &net.UnixAddr{Name: config.SocketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("dial VM socket: %v", err)
+	}
+	defer vm.Close()
+	if _, err := vm.Write([]byte("VFKT")); err != nil {
+		t.Fatalf("write magic: %v", err)
+	}
+	waitForLink(t, id)
+
+	instancesMu.RLock()
+	instance := instances[id]
+	instancesMu.RUnlock()
+	instance.vnMu.RLock()
+	remote := instance.link.RemoteAddr().String()
+	instance.vnMu.RUnlock()
+	if remote != vmAddr.Name {
+		t.Fatalf("link remote = %q, want the VM's address %q", remote, vmAddr.Name)
+	}
+}
+
+// TestStartInstance_BessProtocol has the VM connect over seqpacket and
+// resolve the gateway: frames cross the link without length prefixes.
+func TestStartInstance_BessProtocol(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("bess needs SOCK_SEQPACKET Unix sockets")
+	}
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	config.Protocol = "bess"
+	const id = 5702
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	vm, err := net.Dial("unixpacket", config.SocketPath)
+	if err != nil {
+		t.Fatalf("dial VM socket: %v", err)
+	}
+	defer vm.Close()
+	waitForLink(t, id)
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	request := arpRequestFrame(mustMAC(config.GuestMac), broadcast, net.ParseIP(config.GuestIP).To4(), net.ParseIP(config.GatewayIP).To4())
+	if _, err := vm.Write(request); err != nil {
+		t.Fatalf("write ARP request: %v", err)
+	}
+	vm.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	buf := make([]byte, 2048)
+	for {
+		n, err := vm.Read(buf)
+		if err != nil {
+			t.Fatalf("read reply: %v", err)
+		}
+		if arp := frameARP(buf[:n]); arp != nil && arp.Op() == header.ARPReply {
+			if !net.IP(arp.ProtocolAddressSender()).Equal(net.ParseIP(config.GatewayIP)) {
+				t.Fatalf("ARP reply from %v, want the gateway", net.IP(arp.ProtocolAddressSender()))
+			}
+			return
+		}
+	}
+}
+
+func TestStartInstance_RejectsUnsupportedProtocol(t *testing.T) {
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	config.Protocol = "hyperkit"
+	if err := startInstance(5701, config); err == nil || !strings.Contains(err.Error(), "not supported") {
+		stopTestInstance(5701)
+		t.Fatalf("startInstance error = %v, want an unsupported protocol error", err)
+	}
+	if _, err := os.Stat(config.SocketPath); !os.IsNotExist(err) {
+		t.Fatalf("socket created for a rejected config: %v", err)
+	}
+}
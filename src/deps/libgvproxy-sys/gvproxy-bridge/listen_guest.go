@@ -0,0 +1,116 @@
+package main
+
+// listen_guest.go — Host-implemented services addressed from the guest.
+//
+// gvproxy_listen_guest binds a virtual TCP port on the gateway IP, inside the
+// netstack, and returns an FD stream: a Unix stream socket over which every
+// connection the guest opens to that port is passed to the embedder as a
+// connected FD (SCM_RIGHTS, one byte of payload per FD). The embedder serves
+// API endpoints the guest reaches at gateway:port without a TCP listener on
+// the host. Closing the stream FD unbinds the port.
+
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// serveFDStream accepts connections on l and passes each one, bridged to a
+// socketpair, over a new FD stream whose embedder end it returns. l is
+// closed when the embedder closes its end, or on failure. Each bridge runs
+// until ctx is done, on goroutines (see bridgeToFD).
+func serveFDStream(ctx context.Context, l net.Listener, name string, goroutines *tracker) (int, error) {
+	fds, err := socketpair(syscall.SOCK_STREAM)
+	if err != nil {
+		l.Close()
+		return -1, err
+	}
+	f := os.NewFile(uintptr(fds[1]), name)
+	stream, err := net.FileConn(f)
+	f.Close() // FileConn dups; drop the original
+	if err != nil {
+		l.Close()
+		closeFD(fds[0])
+		return -1, err
+	}
+	uc := stream.(*net.UnixConn)
+
+	// The embedder never writes to the stream: a read returning means it
+	// closed its end.
+	go func() {
+		uc.Read(make([]byte, 1)) //nolint:errcheck
+		l.Close()
+	}()
+	go func() {
+		defer uc.Close()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					logrus.WithFields(logrus.Fields{"error": err, "listener": name}).Debug("Guest listener accept stopped")
+				}
+				return
+			}
+			fd, err := bridgeToFD(ctx, conn, syscall.SOCK_STREAM, name, goroutines)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"error": err, "listener": name}).Warn("Failed to bridge guest connection")
+				continue
+			}
+			err = sendFD(uc, fd)
+			closeFD(fd)
+			if err != nil {
+				l.Close()
+				return
+			}
+		}
+	}()
+	return fds[0], nil
+}
+
+// gvproxy_listen_guest binds port on the gateway IP inside the virtual
+// network and returns an FD stream for it: a Unix stream socket on which
+// each connection the guest opens to gateway:port arrives as a connected FD
+// (SCM_RIGHTS, with one byte of payload per FD), once the service auth
+// callback (if any) approves it. The caller owns the stream and every FD
+// received on it; closing the stream unbinds the port.
+//
+// Returns -1 if the instance does not exist, its network is not ready, or the
+// port is already bound in the virtual network.
+//
+//export gvproxy_listen_guest
+func gvproxy_listen_guest(id C.longlong, port C.int) C.int {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok || port <= 0 || port > 65535 {
+		return -1
+	}
+
+	instance.vnMu.RLock()
+	vn := instance.vn
+	instance.vnMu.RUnlock()
+	if vn == nil {
+		return -1
+	}
+
+	addr := net.JoinHostPort(instance.config.GatewayIP, fmt.Sprint(int(port)))
+	l, err := vn.Listen("tcp", addr)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id), "addr": addr}).Warn("Failed to bind guest-facing listener")
+		return -1
+	}
+	guard := newServiceGuard(int64(id), func() map[string]string { return vnLeases(vn) })
+	fd, err := serveFDStream(instance.ctx, guard.listener("listen_guest", l), "listen-guest-"+addr, &instance.goroutines)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id), "addr": addr}).Warn("Failed to create guest listener FD stream")
+		return -1
+	}
+	logrus.WithFields(logrus.Fields{"id": int64(id), "addr": addr, "fd": fd}).Info("Serving guest-facing listener to embedder")
+	return C.int(fd)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recvFD receives one FD passed over an FD stream.
+func recvFD(t *testing.T, stream *net.UnixConn) int {
+	t.Helper()
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := stream.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatalf("ReadMsgUnix: %v", err)
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("expected one control message, got %d (%v)", len(msgs), err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("expected one FD, got %v (%v)", fds, err)
+	}
+	return fds[0]
+}
+
+func TestServeFDStream(t *testing.T) {
+	// A host listener stands in for the netstack one.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	streamFD, err := serveFDStream(context.Background(), l, "test", nil)
+	if err != nil {
+		t.Fatalf("serveFDStream: %v", err)
+	}
+	stream := fdConn(t, streamFD).(*net.UnixConn)
+
+	guest, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer guest.Close()
+
+	conn := fdConn(t, recvFD(t, stream))
+	defer conn.Close()
+	guest.Write([]byte("hello")) //nolint:errcheck
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("expected the guest's bytes on the passed FD, got %q (%v)", buf, err)
+	}
+
+	// Closing the stream unbinds the port.
+	stream.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			break
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("expected the listener to close with the stream")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGvproxyListenGuest_UnknownInstance(t *testing.T) {
+	if fd := gvproxy_listen_guest(987654, 8080); fd != -1 {
+		t.Fatalf("expected -1 for unknown instance, got %d", fd)
+	}
+}
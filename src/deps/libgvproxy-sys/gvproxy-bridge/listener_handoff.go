@@ -0,0 +1,145 @@
+package main
+
+// listener_handoff.go — Pass bound forward listeners to a replacement instance.
+//
+// Re-creating a bridge instance (e.g. after a crash or config change) would
+// normally close the host forward ports and rebind them, so host clients see
+// "connection refused" in between. Instead the dying instance exports dup'd
+// listener FDs, the replacement adopts them via ImportListeners, and the
+// kernel socket (with its backlog) never goes away.
+
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// ListenerFD is a bound host forward listener handed between instances.
+type ListenerFD struct {
+	HostIP     string `json:"host_ip,omitempty"`     // the mapping's host_ip
+	HostSocket string `json:"host_socket,omitempty"` // the mapping's host_socket
+	HostPort   uint16 `json:"host_port"`
+	FD         int    `json:"fd"`
+}
+
+// ExportListeners returns a dup of every bound forward listener's FD. The
+// forwarder keeps its own listeners; the caller owns the returned FDs.
+func (f *PortForwarder) ExportListeners() ([]ListenerFD, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := make([]ListenerFD, 0, len(f.forwards))
+	for _, fwd := range f.forwards {
+		if fwd.mapping.GatewayPort != 0 {
+			continue // in the netstack; the replacement binds its own
+		}
+		fd, err := dupSocketFD(fwd.listener)
+		if err != nil {
+			closeListenerFDs(out)
+			return nil, fmt.Errorf("export listener %s: %w", fwd.hostAddr, err)
+		}
+		out = append(out, ListenerFD{HostIP: fwd.mapping.HostIP, HostSocket: fwd.mapping.HostSocket, HostPort: fwd.mapping.HostPort, FD: fd})
+	}
+	// The replacement owns the socket files now; closing these listeners
+	// must not remove them.
+	for _, fwd := range f.forwards {
+		if ul, ok := fwd.listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return out, nil
+}
+
+// dupSocketFD dups the FD of a listener or connection.
+func dupSocketFD(s any) (int, error) {
+	sc, ok := s.(syscall.Conn)
+	if !ok {
+		return -1, fmt.Errorf("socket %T does not expose its FD", s)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	var dupErr error
+	if err := raw.Control(func(s uintptr) {
+		fd, dupErr = dupFD(s)
+	}); err != nil {
+		return -1, err
+	}
+	return fd, dupErr
+}
+
+// importListener adopts an exported listener FD for pm's host port or
+// socket, taking ownership of fd. Port 0 mappings accept whatever port the
+// FD is bound to.
+func importListener(fd int, pm PortMapping) (net.Listener, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("imported-listener-%s", pm.hostAddr()))
+	if f == nil {
+		return nil, fmt.Errorf("invalid imported listener fd %d", fd)
+	}
+	defer f.Close() // FileListener dups; drop the original
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("import listener fd %d for %s: %w", fd, pm.hostAddr(), err)
+	}
+	if pm.HostSocket != "" {
+		if addr, ok := l.Addr().(*net.UnixAddr); !ok || addr.Name != pm.HostSocket {
+			l.Close()
+			return nil, fmt.Errorf("imported listener fd %d is bound to %s, not %s", fd, l.Addr(), pm.HostSocket)
+		}
+		return l, nil
+	}
+	addr, ok := l.Addr().(*net.TCPAddr)
+	if !ok || (pm.HostPort != 0 && addr.Port != int(pm.HostPort)) {
+		l.Close()
+		return nil, fmt.Errorf("imported listener fd %d is bound to %s, not port %d", fd, l.Addr(), pm.HostPort)
+	}
+	return l, nil
+}
+
+// closeListenerFDs closes imported FDs that were never adopted.
+func closeListenerFDs(fds []ListenerFD) {
+	for _, l := range fds {
+		closeFD(l.FD)
+	}
+}
+
+// gvproxy_export_listeners returns a JSON array of {host_ip, host_socket,
+// host_port, fd} with a dup'd FD for each of the instance's bound forward
+// listeners, to be passed as import_listeners when creating the replacement
+// instance. The caller owns
+// the FDs until they are imported (the importing gvproxy_create takes
+// ownership, also on failure).
+//
+// Returns NULL if the instance does not exist or a dup fails. The string must
+// be freed with gvproxy_free_string.
+//
+//export gvproxy_export_listeners
+func gvproxy_export_listeners(id C.longlong) *C.char {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok || instance.forwarder == nil {
+		return nil
+	}
+
+	fds, err := instance.forwarder.ExportListeners()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id)}).Error("Failed to export forward listeners")
+		return nil
+	}
+	out, err := json.Marshal(fds)
+	if err != nil {
+		closeListenerFDs(fds)
+		return nil
+	}
+	logrus.WithFields(logrus.Fields{"id": int64(id), "listeners": len(fds)}).Info("Exported forward listeners")
+	return returnCString(string(out))
+}
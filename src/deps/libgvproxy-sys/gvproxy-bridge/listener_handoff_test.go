@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+// TestListenerHandoff_ReplacementKeepsPort exports the old forwarder's
+// listener, closes the old forwarder, and checks the same address still
+// accepts and relays through the replacement.
+func TestListenerHandoff_ReplacementKeepsPort(t *testing.T) {
+	mappings := []PortMapping{{HostPort: 0, GuestPort: 80}}
+	old, err := NewPortForwarder(1, "192.168.127.2", mappings, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	addr := old.forwards[0].listener.Addr().String()
+
+	fds, err := old.ExportListeners()
+	if err != nil {
+		t.Fatalf("ExportListeners: %v", err)
+	}
+	if len(fds) != 1 {
+		t.Fatalf("expected 1 exported listener, got %d", len(fds))
+	}
+	old.Close()
+
+	replacement, err := NewPortForwarder(2, "192.168.127.2", mappings, fds)
+	if err != nil {
+		t.Fatalf("NewPortForwarder with imports: %v", err)
+	}
+	defer replacement.Close()
+	if got := replacement.forwards[0].listener.Addr().String(); got != addr {
+		t.Fatalf("expected adopted listener on %s, got %s", addr, got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replacement.Serve(ctx, &echoDialer{})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial handed-off port: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+}
+
+func TestImportListener_RejectsPortMismatch(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	fd, err := dupSocketFD(l)
+	if err != nil {
+		t.Fatalf("dup: %v", err)
+	}
+
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	if _, err := importListener(fd, PortMapping{HostPort: port + 1}); err == nil {
+		t.Fatal("expected an error for a listener bound to a different port")
+	}
+}
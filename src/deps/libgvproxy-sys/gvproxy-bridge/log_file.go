@@ -0,0 +1,212 @@
+package main
+
+// log_file.go — JSON log file sink, independent of the Rust callback.
+//
+// When the host's tracing is misconfigured, filtered, or the process crashed
+// mid-flight, the forwarded logs are gone. gvproxy_set_log_file adds a second
+// sink: every log entry at or above its own level is appended to a file as
+// one JSON object per line, and the file is rotated by size. The callback
+// keeps working unchanged alongside it. (Without a callback, stderr gets
+// the entries the file's level lets through as well.)
+
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// Log file defaults when the config leaves them at 0.
+const (
+	defaultLogFileMaxSizeMB  = 10
+	defaultLogFileMaxBackups = 3
+)
+
+// LogFileConfig is the JSON document passed to gvproxy_set_log_file.
+type LogFileConfig struct {
+	Path       string `json:"path"`
+	Level      string `json:"level,omitempty"`       // logrus level name; default "info"
+	MaxSizeMB  int    `json:"max_size_mb,omitempty"` // rotate past this size
+	MaxBackups int    `json:"max_backups,omitempty"` // rotated files kept (path.1 is newest)
+}
+
+var (
+	logFileMu    sync.Mutex
+	logFileOnce  sync.Once
+	logFile      *rotatingFile
+	logFileLevel = logrus.PanicLevel // nothing, while no sink is set
+
+	// baseLogLevel is the level the other sinks need (set_log_callback).
+	baseLogLevel = logrus.InfoLevel
+)
+
+// setBaseLogLevel sets the level the callback or stderr sink needs. The
+// logger runs at the most verbose of it and the file and stream sinks' levels.
+func setBaseLogLevel(level logrus.Level) {
+	logFileMu.Lock()
+	defer logFileMu.Unlock()
+	baseLogLevel = level
+	applyLogLevelLocked()
+}
+
+func applyLogLevelLocked() {
+	level := baseLogLevel
+	if logFile != nil && logFileLevel > level {
+		level = logFileLevel
+	}
+	if streamSinkLevel > level {
+		level = streamSinkLevel
+	}
+	logrus.SetLevel(level)
+}
+
+// setLogFile installs the file sink described by cfg, replacing any previous
+// one. An empty path removes the sink.
+func setLogFile(cfg LogFileConfig) error {
+	var f *rotatingFile
+	level := logrus.InfoLevel
+	if cfg.Path != "" {
+		if cfg.Level != "" {
+			parsed, err := logrus.ParseLevel(cfg.Level)
+			if err != nil {
+				return err
+			}
+			level = parsed
+		}
+		maxSize := cfg.MaxSizeMB
+		if maxSize <= 0 {
+			maxSize = defaultLogFileMaxSizeMB
+		}
+		backups := cfg.MaxBackups
+		if backups <= 0 {
+			backups = defaultLogFileMaxBackups
+		}
+		var err error
+		if f, err = openRotatingFile(cfg.Path, int64(maxSize)<<20, backups); err != nil {
+			return err
+		}
+	}
+
+	// Added on first use, after the hooks registered at init, so entries
+	// carry trace_id and log tags.
+	logFileOnce.Do(func() { logrus.AddHook(logFileHook{formatter: &logrus.JSONFormatter{}}) })
+
+	logFileMu.Lock()
+	prev := logFile
+	logFile = f
+	logFileLevel = level
+	applyLogLevelLocked()
+	logFileMu.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+	return nil
+}
+
+// logFileHook writes entries to the file sink as JSON lines.
+type logFileHook struct {
+	formatter logrus.Formatter
+}
+
+func (logFileHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h logFileHook) Fire(entry *logrus.Entry) error {
+	logFileMu.Lock()
+	defer logFileMu.Unlock()
+	if logFile == nil || entry.Level > logFileLevel {
+		return nil
+	}
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = logFile.Write(line)
+	return err
+}
+
+// rotatingFile appends to path and, before a write would take it past
+// maxSize, shifts path → path.1 → … → path.<backups> (dropping the oldest)
+// and starts a new file.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	for i := r.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1)) //nolint:errcheck // gaps are fine
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	return r.f.Close()
+}
+
+// gvproxy_set_log_file adds (or replaces) a JSON log file sink, independent
+// of the log callback: {"path": ..., "level": "debug", "max_size_mb": 10,
+// "max_backups": 3}. Entries are appended one JSON object per line; the file
+// is rotated by size. An empty path or NULL removes the sink.
+//
+// Returns 0 on success, -1 if the config is invalid or the file cannot be
+// opened (the previous sink, if any, is kept).
+//
+//export gvproxy_set_log_file
+func gvproxy_set_log_file(configJSON *C.char) C.int {
+	var cfg LogFileConfig
+	if configJSON != nil {
+		if err := json.Unmarshal([]byte(C.GoString(configJSON)), &cfg); err != nil {
+			logrus.WithError(err).Error("Failed to parse log file config")
+			return -1
+		}
+	}
+	if err := setLogFile(cfg); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "path": cfg.Path}).Error("Failed to set log file")
+		return -1
+	}
+	return 0
+}
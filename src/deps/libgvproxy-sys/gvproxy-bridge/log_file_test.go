@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+func readLogLines(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	var lines []map[string]any
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("not a JSON line: %q", sc.Text())
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestSetLogFile_JSONLinesAtOwnLevel(t *testing.T) {
+	defer setBaseLogLevel(logrus.GetLevel())
+	setBaseLogLevel(logrus.InfoLevel)
+	path := filepath.Join(t.TempDir(), "gvproxy.log")
+	if err := setLogFile(LogFileConfig{Path: path, Level: "debug"}); err != nil {
+		t.Fatalf("setLogFile: %v", err)
+	}
+	defer setLogFile(LogFileConfig{}) //nolint:errcheck
+
+	if logrus.GetLevel() != logrus.DebugLevel {
+		t.Fatalf("expected the logger to run at the file's level, got %v", logrus.GetLevel())
+	}
+	logrus.WithField("id", int64(5100)).Debug("to the file")
+	logrus.Trace("too verbose")
+
+	lines := readLogLines(t, path)
+	if len(lines) != 1 || lines[0]["msg"] != "to the file" || lines[0]["level"] != "debug" || lines[0]["id"] != float64(5100) {
+		t.Fatalf("unexpected log lines: %v", lines)
+	}
+
+	if err := setLogFile(LogFileConfig{}); err != nil {
+		t.Fatalf("remove sink: %v", err)
+	}
+	if logrus.GetLevel() != logrus.InfoLevel {
+		t.Fatalf("expected the base level back, got %v", logrus.GetLevel())
+	}
+}
+
+func TestSetLogFile_InvalidLevel(t *testing.T) {
+	if err := setLogFile(LogFileConfig{Path: filepath.Join(t.TempDir(), "x.log"), Level: "chatty"}); err == nil {
+		t.Fatal("expected an error for an unknown level")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gvproxy.log")
+	r, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("openRotatingFile: %v", err)
+	}
+	defer r.Close()
+	for _, line := range []string{"aaaaaaa\n", "bbbbbbb\n", "ccccccc\n", "ddddddd\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	for file, want := range map[string]string{path: "ddddddd\n", path + ".1": "ccccccc\n", path + ".2": "bbbbbbb\n"} {
+		got, err := os.ReadFile(file)
+		if err != nil || string(got) != want {
+			t.Fatalf("%s: got %q (%v), want %q", filepath.Base(file), got, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("expected only max_backups rotated files")
+	}
+}
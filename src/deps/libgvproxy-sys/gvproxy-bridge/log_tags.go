@@ -0,0 +1,80 @@
+package main
+
+// log_tags.go — Per-instance tags on every log entry.
+//
+// log_tags in the gvproxy_create config (e.g. {"vm_name": "web-1",
+// "tenant": "acme"}) is merged into every log entry carrying that instance's
+// "id" field, so logs forwarded to the Rust side are attributable without
+// post-processing. Fields an entry already has (id, trace_id, ...) win over
+// tags of the same name.
+
+import "C"
+import (
+	"sync"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+var (
+	instanceLogTags   = make(map[int64]map[string]string)
+	instanceLogTagsMu sync.RWMutex
+)
+
+func init() {
+	// Registered before any other hook so theirs see trace_id and tags too.
+	logrus.AddHook(instanceFieldsHook{})
+}
+
+// setLogTags installs the log tags of instance id. Empty tags clear them.
+func setLogTags(id int64, tags map[string]string) {
+	if len(tags) == 0 {
+		clearLogTags(id)
+		return
+	}
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	instanceLogTagsMu.Lock()
+	instanceLogTags[id] = copied
+	instanceLogTagsMu.Unlock()
+}
+
+func clearLogTags(id int64) {
+	instanceLogTagsMu.Lock()
+	delete(instanceLogTags, id)
+	instanceLogTagsMu.Unlock()
+}
+
+func logTagsFor(id int64) map[string]string {
+	instanceLogTagsMu.RLock()
+	defer instanceLogTagsMu.RUnlock()
+	return instanceLogTags[id]
+}
+
+// instanceFieldsHook adds the active trace_id and the log tags of the
+// instance named by an entry's "id" field.
+type instanceFieldsHook struct{}
+
+func (instanceFieldsHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (instanceFieldsHook) Fire(entry *logrus.Entry) error {
+	var id int64
+	switch v := entry.Data["id"].(type) {
+	case int64:
+		id = v
+	case C.longlong:
+		id = int64(v)
+	default:
+		return nil
+	}
+	if traceID := traceFor(id); traceID != "" {
+		entry.Data["trace_id"] = traceID
+	}
+	for k, v := range logTagsFor(id) {
+		if _, taken := entry.Data[k]; !taken {
+			entry.Data[k] = v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	logrus "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLogTags_MergedIntoInstanceEntries(t *testing.T) {
+	const id = 4600
+	logs := logtest.NewGlobal()
+
+	setLogTags(id, map[string]string{"vm_name": "web-1", "tenant": "acme", "host_port": "tag"})
+	defer clearLogTags(id)
+
+	logrus.WithFields(logrus.Fields{"id": int64(id), "host_port": 8080}).Info("tagged")
+	logrus.WithField("id", int64(id+1)).Info("other instance")
+
+	entries := logs.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	tagged := entries[0].Data
+	if tagged["vm_name"] != "web-1" || tagged["tenant"] != "acme" {
+		t.Fatalf("expected the instance's tags, got %v", tagged)
+	}
+	if tagged["host_port"] != 8080 {
+		t.Fatalf("a tag must not override an entry field, got %v", tagged["host_port"])
+	}
+	if _, ok := entries[1].Data["vm_name"]; ok {
+		t.Fatalf("tags leaked to another instance: %v", entries[1].Data)
+	}
+
+	clearLogTags(id)
+	logrus.WithField("id", int64(id)).Info("after")
+	if _, ok := logs.LastEntry().Data["tenant"]; ok {
+		t.Fatal("tags must not outlive the instance")
+	}
+}
+
+func TestStartInstance_FailureDropsLogTags(t *testing.T) {
+	const id = 4601
+	config := testGvproxyConfig()
+	config.SocketPath = ""
+	config.LogTags = map[string]string{"vm_name": "web-1"}
+	if err := startInstance(id, config); err == nil {
+		t.Fatal("expected startInstance to fail without a socket path")
+	}
+	if logTagsFor(id) != nil {
+		t.Fatal("a failed start must not leave log tags behind")
+	}
+}
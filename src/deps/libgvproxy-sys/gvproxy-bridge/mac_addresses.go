@@ -0,0 +1,126 @@
+package main
+
+// mac_addresses.go — Validate and generate the gateway and guest MACs.
+//
+// A multicast or broadcast MAC on either end of the link is accepted by the
+// netstack but breaks switching silently: frames to it are flooded or
+// dropped, ARP never resolves, and the guest just has no network. MACs that
+// are not locally administered may collide with real hardware on the host's
+// LAN segment once traffic is bridged. gvproxy_create therefore requires
+// both MACs to be locally administered unicast addresses.
+//
+// An empty gateway_mac or guest_mac is generated from mac_prefix (an OUI,
+// itself locally administered and unicast; defaultMACPrefix if unset) plus
+// three random bytes. The generated values are part of the instance config,
+// so they survive a hot upgrade, and gvproxy_get_macs reports them.
+
+import "C"
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// defaultMACPrefix is gvisor-tap-vsock's own locally administered OUI.
+const defaultMACPrefix = "5a:94:ef"
+
+// checkUnicastLocal rejects MACs that are multicast (which includes
+// broadcast) or universally administered.
+func checkUnicastLocal(mac net.HardwareAddr) error {
+	switch {
+	case mac[0]&0x01 != 0:
+		return fmt.Errorf("is multicast")
+	case mac[0]&0x02 == 0:
+		return fmt.Errorf("is not locally administered (set bit 0x02 of the first byte)")
+	}
+	return nil
+}
+
+// parseMACPrefix parses a three-byte OUI such as "5a:94:ef".
+func parseMACPrefix(prefix string) (net.HardwareAddr, error) {
+	if prefix == "" {
+		prefix = defaultMACPrefix
+	}
+	mac, err := net.ParseMAC(prefix + ":00:00:00")
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("invalid mac_prefix %q: want three bytes like %q", prefix, defaultMACPrefix)
+	}
+	if err := checkUnicastLocal(mac); err != nil {
+		return nil, fmt.Errorf("mac_prefix %q %v", prefix, err)
+	}
+	return mac[:3], nil
+}
+
+// generateMAC returns prefix followed by three random bytes.
+func generateMAC(prefix net.HardwareAddr) (net.HardwareAddr, error) {
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, prefix)
+	if _, err := rand.Read(mac[3:]); err != nil {
+		return nil, err
+	}
+	return mac, nil
+}
+
+// resolveMACs validates the configured MACs and fills in missing ones.
+func resolveMACs(config *GvproxyConfig) error {
+	prefix, err := parseMACPrefix(config.MACPrefix)
+	if err != nil {
+		return err
+	}
+	for _, m := range []struct {
+		field string
+		value *string
+	}{
+		{"gateway_mac", &config.GatewayMac},
+		{"guest_mac", &config.GuestMac},
+	} {
+		if *m.value == "" {
+			mac, err := generateMAC(prefix)
+			if err != nil {
+				return fmt.Errorf("generate %s: %w", m.field, err)
+			}
+			*m.value = mac.String()
+			continue
+		}
+		mac, err := net.ParseMAC(*m.value)
+		if err != nil || len(mac) != 6 {
+			return fmt.Errorf("invalid %s %q: want a 48-bit MAC", m.field, *m.value)
+		}
+		if err := checkUnicastLocal(mac); err != nil {
+			return fmt.Errorf("%s %q %v", m.field, *m.value, err)
+		}
+		*m.value = mac.String()
+	}
+	if config.GatewayMac == config.GuestMac {
+		return fmt.Errorf("gateway_mac and guest_mac must differ (both %s)", config.GuestMac)
+	}
+	return nil
+}
+
+// MACs is the document returned by gvproxy_get_macs.
+type MACs struct {
+	GatewayMac string `json:"gateway_mac"`
+	GuestMac   string `json:"guest_mac"`
+}
+
+// gvproxy_get_macs returns the gateway and guest MACs of an instance,
+// including generated ones: {"gateway_mac": ..., "guest_mac": ...}.
+//
+// Returns NULL if the instance does not exist. The string must be freed
+// with gvproxy_free_string.
+//
+//export gvproxy_get_macs
+func gvproxy_get_macs(id C.longlong) *C.char {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	out, err := json.Marshal(MACs{GatewayMac: instance.config.GatewayMac, GuestMac: instance.config.GuestMac})
+	if err != nil {
+		return nil
+	}
+	return returnCString(string(out))
+}
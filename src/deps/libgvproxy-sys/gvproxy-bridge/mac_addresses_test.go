@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestResolveMACs_RejectsUnusableMACs(t *testing.T) {
+	for _, tc := range []struct {
+		name, mac, want string
+	}{
+		{"broadcast", "ff:ff:ff:ff:ff:ff", "multicast"},
+		{"multicast", "01:00:5e:00:00:01", "multicast"},
+		{"universal", "00:1a:2b:3c:4d:5e", "locally administered"},
+		{"malformed", "5a:94:ef", "48-bit"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testGvproxyConfig()
+			config.GuestMac = tc.mac
+			err := resolveMACs(&config)
+			if err == nil || !strings.Contains(err.Error(), tc.want) || !strings.Contains(err.Error(), "guest_mac") {
+				t.Errorf("err = %v, want guest_mac error mentioning %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveMACs_RejectsSameMAC(t *testing.T) {
+	config := testGvproxyConfig()
+	config.GuestMac = strings.ToUpper(config.GatewayMac)
+	if err := resolveMACs(&config); err == nil {
+		t.Fatal("resolveMACs accepted identical gateway and guest MACs")
+	}
+}
+
+func TestResolveMACs_GeneratesFromPrefix(t *testing.T) {
+	config := testGvproxyConfig()
+	config.GatewayMac = ""
+	config.GuestMac = ""
+	config.MACPrefix = "02:42:ac"
+
+	if err := resolveMACs(&config); err != nil {
+		t.Fatalf("resolveMACs: %v", err)
+	}
+	for _, mac := range []string{config.GatewayMac, config.GuestMac} {
+		if !strings.HasPrefix(mac, "02:42:ac:") {
+			t.Errorf("generated MAC %q does not use the prefix", mac)
+		}
+		hw, err := net.ParseMAC(mac)
+		if err != nil || checkUnicastLocal(hw) != nil {
+			t.Errorf("generated MAC %q is not locally administered unicast", mac)
+		}
+	}
+	if config.GatewayMac == config.GuestMac {
+		t.Errorf("generated the same MAC twice: %s", config.GuestMac)
+	}
+}
+
+func TestParseMACPrefix(t *testing.T) {
+	if prefix, err := parseMACPrefix(""); err != nil || prefix.String() != defaultMACPrefix {
+		t.Errorf("default prefix = %v, %v; want %s", prefix, err, defaultMACPrefix)
+	}
+	for _, bad := range []string{"00:1a:2b", "03:00:00", "5a:94", "zz:00:00"} {
+		if _, err := parseMACPrefix(bad); err == nil {
+			t.Errorf("parseMACPrefix(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -3,6 +3,15 @@ package main
 /*
 #include <stdlib.h>
 
+// Failure codes of gvproxy_create and gvproxy_create_from_template (see
+// create_errors.go). The error message goes to errOut / gvproxy_last_error.
+#define GVPROXY_ERR_OTHER         -1 // any failure not listed below
+#define GVPROXY_ERR_CONFIG        -2 // the config does not parse or is invalid
+#define GVPROXY_ERR_SOCKET        -3 // the VM link socket cannot be created
+#define GVPROXY_ERR_PORT_CONFLICT -4 // a mapped host port is in use or reserved
+#define GVPROXY_ERR_UNSUPPORTED   -5 // a requested feature is unavailable on this platform
+#define GVPROXY_ERR_LIMIT         -6 // max_instances or max_total_forwards reached
+
 typedef void (*log_callback_fn)(int level, const char* message);
 
 static void call_rust_log_callback(void* callback, int level, const char* msg) {
@@ -24,10 +33,10 @@ import (
 	"runtime"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
-	"github.com/containers/gvisor-tap-vsock/pkg/transport"
 	"github.com/containers/gvisor-tap-vsock/pkg/types"
 	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
 	logrus "github.com/sirupsen/logrus"
@@ -136,7 +145,7 @@ func gvproxy_set_log_callback(callback unsafe.Pointer) {
 
 	if callback != nil {
 		// Forward all logrus logs to Rust tracing
-		logrus.SetLevel(logrus.TraceLevel) // Enable trace level to support RUST_LOG=gvproxy=trace
+		setBaseLogLevel(logrus.TraceLevel) // Enable trace level to support RUST_LOG=gvproxy=trace
 		logrus.SetFormatter(&logrus.TextFormatter{
 			DisableTimestamp: true, // Rust tracing adds its own timestamp
 			DisableColors:    true,
@@ -149,7 +158,7 @@ func gvproxy_set_log_callback(callback unsafe.Pointer) {
 		log.SetFlags(0) // Rust tracing adds its own timestamp and prefix
 	} else {
 		// Reset logrus to default
-		logrus.SetLevel(logrus.InfoLevel)
+		setBaseLogLevel(logrus.InfoLevel)
 		logrus.SetFormatter(&logrus.TextFormatter{})
 		logrus.SetOutput(os.Stderr)
 
@@ -163,12 +172,42 @@ func gvproxy_set_log_callback(callback unsafe.Pointer) {
 type PortMapping struct {
 	HostPort  uint16 `json:"host_port"`
 	GuestPort uint16 `json:"guest_port"`
+	// GuestIP targets one of guest_aliases or guests instead of guest_ip.
+	GuestIP string `json:"guest_ip,omitempty"`
+	// HostIP binds the host port on this address only, e.g. "127.0.0.1".
+	// Empty => 0.0.0.0. See forward_host_ip.go.
+	HostIP string `json:"host_ip,omitempty"`
+	// HostSocket publishes the guest port on this Unix socket path instead
+	// of host_port. See forward_unix_socket.go.
+	HostSocket string `json:"host_socket,omitempty"`
+	// HostVsock makes a host AF_VSOCK address the host end of the forward:
+	// listened on (with guest_port), or connected to (with gateway_port).
+	// See forward_vsock.go.
+	HostVsock *VsockAddr `json:"host_vsock,omitempty"`
+	// GatewayPort reverses a host_vsock forward: the guest connects to this
+	// port on the gateway. See forward_vsock.go.
+	GatewayPort uint16 `json:"gateway_port,omitempty"`
+	// Type is "tcp" (the default) or "http", which also reports each
+	// request as an http_access event. See http_access_log.go.
+	Type string `json:"type,omitempty"`
 }
 
-// DNSRecord represents an exact A record within a local DNS zone.
+// DNSRecord represents an exact record within a local DNS zone. Type selects
+// the record type: "A" (the default) uses IP, "AAAA" uses IP (IPv6), "SRV"
+// uses Target, Port, Priority and Weight, "TXT" uses Text, and "CNAME" uses
+// Target (local or not; chains are followed and loops rejected). An A record
+// may give Regexp instead of Name to answer every name within the zone it
+// matches, like upstream's types.Record.
 type DNSRecord struct {
-	Name string `json:"name"`
-	IP   string `json:"ip"`
+	Name     string   `json:"name"`
+	Regexp   string   `json:"regexp,omitempty"`
+	Type     string   `json:"type,omitempty"`
+	IP       string   `json:"ip,omitempty"`
+	Target   string   `json:"target,omitempty"`
+	Port     uint16   `json:"port,omitempty"`
+	Priority uint16   `json:"priority,omitempty"`
+	Weight   uint16   `json:"weight,omitempty"`
+	Text     []string `json:"text,omitempty"`
 }
 
 // DNSZone represents a local DNS zone configuration
@@ -176,7 +215,7 @@ type DNSRecord struct {
 // Queries not matching any zone are forwarded to the host's system DNS.
 type DNSZone struct {
 	Name      string      `json:"name"`              // Zone name (e.g., "myapp.local.", "." for root)
-	Records   []DNSRecord `json:"records,omitempty"` // Exact A records within the zone
+	Records   []DNSRecord `json:"records,omitempty"` // Exact A, SRV, TXT and CNAME records within the zone
 	DefaultIP string      `json:"default_ip"`        // Default IP for unmatched queries in this zone
 }
 
@@ -203,6 +242,180 @@ type GvproxyConfig struct {
 	// forwarding / DNS / DHCP leases / stats / cam) to a host unix socket the
 	// boxlite core dials. Empty => the services API is not exposed.
 	ControlSocketPath string `json:"control_socket_path,omitempty"`
+	// StripTCPTimestamps blanks the TCP timestamp option out of handshakes on
+	// the guest link so it is never negotiated. Use for guests restored from
+	// snapshots, whose skewed clocks otherwise trip PAWS and reset flows.
+	StripTCPTimestamps bool `json:"strip_tcp_timestamps,omitempty"`
+	// ImportListeners adopts host forward listeners exported from a previous
+	// instance (gvproxy_export_listeners) instead of rebinding those ports.
+	// gvproxy_create takes ownership of the FDs, also when it fails.
+	ImportListeners []ListenerFD `json:"import_listeners,omitempty"`
+	// ImportVMSocket adopts the hypervisor socket of an instance handed over
+	// by gvproxy_serialize_all instead of creating socket_path. Set by
+	// gvproxy_deserialize_all; gvproxy_create takes ownership of the FD.
+	ImportVMSocket *VMSocketFD `json:"import_vm_socket,omitempty"`
+	// ReservedHostPorts lists host ports that may never be forwarded, by
+	// PortMappings or the expose API. Unset => [22] (host SSH); an explicit
+	// empty list reserves nothing. Not omitempty, so a handoff keeps the
+	// difference.
+	ReservedHostPorts []uint16 `json:"reserved_host_ports"`
+	// NotifyPort is the guest UDP port gvproxy_notify_guest sends to.
+	// 0 => 9 (the wake-on-LAN discard port).
+	NotifyPort uint16 `json:"notify_port,omitempty"`
+	// TestServices enables echo (7), discard (9) and chargen (19) TCP services
+	// on the gateway IP for in-guest connectivity/throughput checks. Debug only.
+	TestServices bool `json:"test_services,omitempty"`
+	// FrameRingSize keeps the last N link frames in memory so support bundles
+	// (gvproxy_collect_diagnostics) include them as a pcap. 0 => off.
+	FrameRingSize int `json:"frame_ring_size,omitempty"`
+	// GuestAliases are secondary addresses the guest configures statically on
+	// its NIC. They are kept out of the DHCP pool and can be targeted by port
+	// mappings (guest_ip). See guest_aliases.go.
+	GuestAliases []string `json:"guest_aliases,omitempty"`
+	// Guests are further microVMs on the same network, each with a static
+	// DHCP lease and its own port mappings and NAT setting. See guests.go.
+	Guests []Guest `json:"guests,omitempty"`
+	// EgressTTL sets the IP TTL (and IPv6 hop limit) of the host sockets that
+	// carry guest TCP and UDP flows, e.g. 64, so upstream middleboxes do not
+	// fingerprint the traffic by TTL. 0 => OS default. See egress_ttl.go.
+	EgressTTL uint8 `json:"egress_ttl,omitempty"`
+	// TraceID correlates this gvproxy_create call with the logs and events it
+	// causes (see trace_ids.go). Not kept past creation.
+	TraceID string `json:"trace_id,omitempty"`
+	// LogTags are merged into every log entry of this instance, e.g.
+	// {"vm_name": "web-1", "tenant": "acme"}. See log_tags.go.
+	LogTags map[string]string `json:"log_tags,omitempty"`
+	// MaxDataPlaneFlows bounds the TCP flows (guest outbound and published
+	// ports) relayed at once, so control exports stay responsive under
+	// heavy forwarding load. Further published-port flows wait for a slot;
+	// further guest flows are refused. 0 => unbounded. See planes.go.
+	MaxDataPlaneFlows int `json:"max_data_plane_flows,omitempty"`
+	// ForwardLeaseMAC makes port mappings without guest_ip follow the DHCP
+	// lease of this MAC (usually guest_mac) instead of staying on guest_ip.
+	// Empty => off. See forward_retarget.go.
+	ForwardLeaseMAC string `json:"forward_lease_mac,omitempty"`
+	// ConnectProxySocketPath serves an HTTP/CONNECT proxy on this Unix
+	// socket that dials into the guest network, for debugging from the host
+	// without a forward. Empty => off. See connect_proxy.go.
+	ConnectProxySocketPath string `json:"connect_proxy_socket_path,omitempty"`
+	// AllowSandboxedSocketPaths skips the check that socket paths are not
+	// private to this process's sandbox (systemd PrivateTmp, macOS App
+	// Sandbox), for hypervisors that share it. See socket_paths.go.
+	AllowSandboxedSocketPaths bool `json:"allow_sandboxed_socket_paths,omitempty"`
+	// MACPrefix is the OUI ("5a:94:ef") for generated gateway and guest
+	// MACs, used when gateway_mac or guest_mac is empty. See
+	// mac_addresses.go.
+	MACPrefix string `json:"mac_prefix,omitempty"`
+	// ForwardHistogramBuckets sets the bucket bounds of the per-forward
+	// dial latency and throughput histograms. Nil => defaults. See
+	// forward_histograms.go.
+	ForwardHistogramBuckets *ForwardHistogramBuckets `json:"forward_histogram_buckets,omitempty"`
+	// LinkStallThresholdMs is how long a write to the hypervisor socket may
+	// block before the VMM counts as not reading it. 0 => 2000. See
+	// slow_consumer.go.
+	LinkStallThresholdMs int `json:"link_stall_threshold_ms,omitempty"`
+	// DNSFaults delay, fail or drop the gateway DNS server's answers for
+	// chosen names. For testing guests only. See dns_faults.go.
+	DNSFaults []DNSFault `json:"dns_faults,omitempty"`
+	// Protocol selects the VM link protocol: "qemu" (Unix stream socket),
+	// "vfkit" (Unix datagram socket), "bess" (Unix seqpacket socket, Linux
+	// only) or "stdio" (stdio_fds). Empty => vfkit on macOS, qemu elsewhere.
+	// See link_protocol.go.
+	Protocol string `json:"protocol,omitempty"`
+	// GuestProbeIntervalMs sends the guest an ARP probe every interval and
+	// reports whether it answers at L2 (stats, guest_unresponsive events).
+	// 0 => off. See guest_liveness.go.
+	GuestProbeIntervalMs int `json:"guest_probe_interval_ms,omitempty"`
+	// VsockListen makes the guest connect over virtio-vsock instead of
+	// socket_path, which is then not created (Linux only). See
+	// vsock_listen.go.
+	VsockListen *VsockListen `json:"vsock_listen,omitempty"`
+	// DryRun checks the config, including whether the port mappings can be
+	// bound, without creating anything; gvproxy_create then returns 0. See
+	// dry_run.go.
+	DryRun bool `json:"dry_run,omitempty"`
+	// StdioFDs are the inherited pipe FDs of a "stdio" link, owned by the
+	// instance from creation on. See stdio_link.go.
+	StdioFDs *StdioFDs `json:"stdio_fds,omitempty"`
+	// DataPathPriority lowers the OS priority of the thread reading the VM
+	// link (nice on Linux, QoS class on macOS). See thread_priority.go.
+	DataPathPriority *ThreadPriority `json:"data_path_priority,omitempty"`
+	// CaptureRetention compresses the captures gvproxy_capture_rotate
+	// finishes and deletes them by age or total size. Needs capture_file.
+	// See capture_retention.go.
+	CaptureRetention *CaptureRetention `json:"capture_retention,omitempty"`
+	// UpstreamProxies sends guest TCP flows to matching destinations through
+	// an HTTP CONNECT or SOCKS5 proxy; the rest go direct. First match wins.
+	// See upstream_proxy.go.
+	UpstreamProxies []UpstreamProxyRule `json:"upstream_proxies,omitempty"`
+	// LinkFD is the VM link socket passed to gvproxy_create_with_fd, owned
+	// by the instance from then on. See link_fd.go.
+	LinkFD *int `json:"-"`
+	// RuntimeDir is where socket_path defaults to gvproxy-<pid>-<id>.sock
+	// and relative socket paths are resolved; "auto" picks one like
+	// gvproxy_suggest_socket_dir. See runtime_dir.go.
+	RuntimeDir string `json:"runtime_dir,omitempty"`
+	// SocketPermissions sets the mode and owner of the VM socket, for a
+	// VMM running as another user. See socket_permissions.go.
+	SocketPermissions *SocketPermissions `json:"socket_permissions,omitempty"`
+	// LinkPriority queues frames to the guest in two classes, sending
+	// interactive ones (SSH, small packets, chosen DSCP) ahead of bulk ones.
+	// See link_priority.go.
+	LinkPriority *LinkPriority `json:"link_priority,omitempty"`
+	// HostRoute plugs a host tun device, routed to the subnet by a
+	// privileged component, into the virtual network so the host reaches
+	// the guest by IP. See host_route.go.
+	HostRoute *HostRoute `json:"host_route,omitempty"`
+	// CapturePolicy is what an unwritable capture_file does: "fail-create"
+	// (default), "warn" or "retry". See capture_policy.go.
+	CapturePolicy string `json:"capture_policy,omitempty"`
+	// SocketForwards publishes Unix sockets in the guest on host Unix
+	// sockets, tunnelled over SSH to the guest. See socket_forward.go.
+	SocketForwards []SocketForward `json:"socket_forwards,omitempty"`
+	// GuestDNSServers are the IPv4 nameservers DHCP advertises to the guest
+	// instead of the gateway. See forked_dhcp.go.
+	GuestDNSServers []string `json:"guest_dns_servers,omitempty"`
+	// DisableGatewayDNS stops the gateway answering DNS on port 53, so no
+	// guest query is intercepted. Needs guest_dns_servers. See
+	// forked_dhcp.go.
+	DisableGatewayDNS bool `json:"disable_gateway_dns,omitempty"`
+	// DNSUpstreamServers replaces the host resolver for names outside the
+	// local zones: "10.0.0.53", "10.0.0.53:5353", "tls://dns.corp" (DoT) or
+	// "https://dns.corp/dns-query" (DoH), tried in order. See
+	// dns_servers.go and dns_encrypted.go.
+	DNSUpstreamServers []string `json:"dns_upstream_servers,omitempty"`
+	// NATDisabledGuests are guest addresses created without NAT: their
+	// flows beyond the gateway are dropped until gvproxy_set_nat turns it
+	// on. See guest_nat.go.
+	NATDisabledGuests []string `json:"nat_disabled_guests,omitempty"`
+	// DNSQueryLog reports each DNS query the gateway answers as a
+	// dns_query event. See dns_query_log.go.
+	DNSQueryLog bool `json:"dns_query_log,omitempty"`
+	// ExtraHosts maps host names to an IP, "gateway" or "guest", answered
+	// by the gateway DNS ahead of dns_zones, like Docker's --add-host. See
+	// dns_extra_hosts.go.
+	ExtraHosts map[string]string `json:"extra_hosts,omitempty"`
+	// SSHJump serves an SSH jump host on a host address that only allows
+	// TCP forwarding into the guest network. See ssh_jump.go.
+	SSHJump *SSHJump `json:"ssh_jump,omitempty"`
+	// LinkLocalPolicy decides what happens to flows from link-local
+	// (APIPA) guest addresses: "nat" (the default), "drop" or "answer".
+	// See link_local.go.
+	LinkLocalPolicy string `json:"link_local_policy,omitempty"`
+	// DataPathOrder orders the stages link frames go through: "policy",
+	// "capture" and "accounting". Unset => that order. See data_path.go.
+	DataPathOrder []string `json:"data_path_order,omitempty"`
+	// DHCPRange confines the leases DHCP gives clients without a static
+	// lease to this range. Unset => anywhere in the subnet. See
+	// dhcp_range.go.
+	DHCPRange *DHCPRange `json:"dhcp_range,omitempty"`
+	// DHCPOptions adds NTP servers, an interface MTU, a domain name and
+	// vendor-specific information to DHCP replies. See dhcp_options.go.
+	DHCPOptions *DHCPOptions `json:"dhcp_options,omitempty"`
+	// DataPathStallMs is how long the guest's frames may go unanswered
+	// while the link is connected before the data path counts as stalled.
+	// 0 => 30000, negative => no watchdog. See stall_watchdog.go.
+	DataPathStallMs int `json:"data_path_stall_ms,omitempty"`
 }
 
 // GvproxyInstance tracks a running gvisor-tap-vsock instance
@@ -211,12 +424,32 @@ type GvproxyInstance struct {
 	SocketPath    string
 	Config        *types.Configuration
 	Cancel        context.CancelFunc
-	conn          net.Conn                       // For macOS UnixDgram (VFKit)
-	listener      net.Listener                   // For Linux UnixStream (Qemu)
+	ctx           context.Context                // Done once the instance is destroyed
+	conn          net.Conn                       // For UnixDgram (VFKit)
+	listener      net.Listener                   // For UnixStream (Qemu)
 	vn            *virtualnetwork.VirtualNetwork // Virtual network for stats collection
-	vnMu          sync.RWMutex                   // Protects vn field
+	vnMu          sync.RWMutex                   // Protects vn and link
+	link          *linkConn                      // VM link once connected
+	config        GvproxyConfig                  // Creation config, for gvproxy_serialize_all
+	detached      atomic.Bool                    // Handed over: keep socket paths on shutdown
 	ca            *BoxCA                         // Ephemeral MITM CA (nil if no secrets)
 	secretMatcher *SecretHostMatcher             // Hostname→secrets lookup (nil if no secrets)
+	forwarder     *PortForwarder                 // Host listeners for PortMappings
+	dns           *gatewayDNS                    // Gateway DNS server (forked_dns.go)
+	nat           *guestNAT                      // Guests without NAT (guest_nat.go)
+	milestones    *instanceMilestones            // Boot-time networking timeline
+	warmHosts     []string                       // Upstream hostnames for gvproxy_prewarm
+	stats         *statsRegistry                 // Bridge sections of gvproxy_get_stats
+	reserved      reservedPorts                  // Host ports forwards may not use
+	notifier      *guestNotifier                 // Host→guest datagrams (gvproxy_notify_guest)
+	tracer        *frameTracer                   // Per-frame trace mode (gvproxy_set_frame_trace)
+	generation    *configGeneration              // Runtime config changes (config_generation.go)
+	frames        *frameRing                     // Recent link frames for support bundles (nil if disabled)
+	vzPeerPath    string                         // Socket bound by gvproxy_get_fd (vz_fd.go)
+	goroutines    tracker                        // Goroutines started for the instance (teardown.go)
+	control       *controlPool                   // Control-plane workers (planes.go)
+	syncTeardown  atomic.Bool                    // Destroyed with gvproxy_destroy_sync
+	done          chan struct{}                  // Closed once torn down
 }
 
 func buildDNSZones(config GvproxyConfig) []types.Zone {
@@ -227,10 +460,10 @@ func buildDNSZones(config GvproxyConfig) []types.Zone {
 			DefaultIP: net.ParseIP(zone.DefaultIP),
 		}
 		for _, record := range zone.Records {
-			dnsZone.Records = append(dnsZone.Records, types.Record{
-				Name: record.Name,
-				IP:   net.ParseIP(record.IP),
-			})
+			if !isARecord(record) {
+				continue // served by gatewayDNS from its extra records
+			}
+			dnsZone.Records = append(dnsZone.Records, upstreamARecord(record))
 		}
 		dnsZones = append(dnsZones, dnsZone)
 	}
@@ -244,14 +477,34 @@ func buildDNSZones(config GvproxyConfig) []types.Zone {
 	return dnsZones
 }
 
-func buildTapConfig(config GvproxyConfig, protocol types.Protocol) *types.Configuration {
+// buildNATTable returns the upstream NAT table: subnet addresses that reach
+// the host instead of a guest.
+func buildNATTable(config GvproxyConfig) map[string]string {
 	nat := make(map[string]string)
-	gatewayVirtualIPs := []string{config.GatewayIP}
 	if config.HostIP != "" {
 		nat[config.HostIP] = "127.0.0.1"
-		if config.HostIP != config.GatewayIP {
-			gatewayVirtualIPs = append(gatewayVirtualIPs, config.HostIP)
-		}
+	}
+	return nat
+}
+
+func buildTapConfig(config GvproxyConfig, protocol types.Protocol) *types.Configuration {
+	nat := buildNATTable(config)
+	gatewayVirtualIPs := []string{config.GatewayIP}
+	if config.HostIP != "" && config.HostIP != config.GatewayIP {
+		gatewayVirtualIPs = append(gatewayVirtualIPs, config.HostIP)
+	}
+
+	leases := map[string]string{
+		config.GuestIP: config.GuestMac,
+	}
+	for _, alias := range config.GuestAliases {
+		leases[alias] = aliasReservationMAC
+	}
+	for _, g := range config.Guests {
+		leases[g.IP] = g.MAC
+	}
+	if config.HostRoute != nil {
+		leases[config.HostRoute.Address] = config.HostRoute.MAC
 	}
 
 	return &types.Configuration{
@@ -260,9 +513,7 @@ func buildTapConfig(config GvproxyConfig, protocol types.Protocol) *types.Config
 		Subnet:            config.Subnet,
 		GatewayIP:         config.GatewayIP,
 		GatewayMacAddress: config.GatewayMac,
-		DHCPStaticLeases: map[string]string{
-			config.GuestIP: config.GuestMac,
-		},
+		DHCPStaticLeases:  leases,
 		Forwards:          make(map[string]string),
 		NAT:               nat,
 		GatewayVirtualIPs: gatewayVirtualIPs,
@@ -281,27 +532,63 @@ var (
 
 //export gvproxy_create
 //
-// On failure (return -1), the underlying error message is written to `*errOut`
-// as a heap-allocated C string. Caller must free it via gvproxy_free_string.
-// `errOut` may be nil if the caller doesn't want the message.
+// With dry_run set, nothing is created and 0 is returned if a real create
+// would have succeeded.
+//
+// On failure it returns a negative GVPROXY_ERR_* code (see create_errors.go)
+// and writes the underlying error message to `*errOut` as a heap-allocated
+// C string. Caller must free it via gvproxy_free_string.
+// `errOut` may be nil if the caller doesn't want the message. The message is
+// also kept for gvproxy_last_error on the calling thread.
 func gvproxy_create(configJSON *C.char, errOut **C.char) C.longlong {
 	// setErr surfaces the underlying error back to the FFI caller so the
 	// Rust runtime can include it in the user-visible BoxliteError message
 	// (e.g. "listen tcp 0.0.0.0:27380: bind: address already in use" instead
-	// of an opaque "gvproxy_create failed").
-	setErr := func(err error) {
-		if errOut != nil {
-			*errOut = C.CString(err.Error())
-		}
-	}
-
-	goJSON := C.GoString(configJSON)
+	// of an opaque "gvproxy_create failed"). See last_error.go.
+	setLastError(nil)
+	setErr := func(err error) { reportCreateError(errOut, err) }
 
 	var config GvproxyConfig
-	if err := json.Unmarshal([]byte(goJSON), &config); err != nil {
+	if err := json.Unmarshal([]byte(C.GoString(configJSON)), &config); err != nil {
 		logrus.WithError(err).Error("Failed to parse gvproxy config")
+		err = classify(createErrConfig, err)
 		setErr(err)
-		return -1
+		return createErrorCode(err)
+	}
+
+	id, err := createInstance(config)
+	if err != nil {
+		setErr(err)
+		return createErrorCode(err)
+	}
+	return C.longlong(id)
+}
+
+// createErrorCode maps a failed create's error to its GVPROXY_ERR_* code.
+func createErrorCode(err error) C.longlong {
+	switch createErrorClassOf(err) {
+	case createErrConfig:
+		return C.GVPROXY_ERR_CONFIG
+	case createErrSocket:
+		return C.GVPROXY_ERR_SOCKET
+	case createErrPortConflict:
+		return C.GVPROXY_ERR_PORT_CONFLICT
+	case createErrUnsupported:
+		return C.GVPROXY_ERR_UNSUPPORTED
+	case createErrLimit:
+		return C.GVPROXY_ERR_LIMIT
+	}
+	return C.GVPROXY_ERR_OTHER
+}
+
+// createInstance allocates an id and starts an instance from config. A dry
+// run allocates nothing and returns 0.
+func createInstance(config GvproxyConfig) (int64, error) {
+	emitStartupBanner()
+	if config.DryRun {
+		var err error
+		withTrace(0, config.TraceID, func() { err = startInstance(0, config) })
+		return 0, err
 	}
 
 	instancesMu.Lock()
@@ -309,73 +596,375 @@ func gvproxy_create(configJSON *C.char, errOut **C.char) C.longlong {
 	nextID++
 	instancesMu.Unlock()
 
+	var err error
+	withTrace(id, config.TraceID, func() { err = startInstance(id, config) })
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// startInstance brings up instance id from config and registers it. On
+// failure nothing is left running, and FDs the config hands over
+// (import_listeners) are closed.
+func startInstance(id int64, config GvproxyConfig) error {
+	milestones := newInstanceMilestones(id, config.GatewayIP)
+	guest := newGuestInfo(id)
+
+	// Imported listener FDs are ours now; close them if we fail before the
+	// forwarder takes them over.
+	pendingImports := config.ImportListeners
+	pendingVMSocket := config.ImportVMSocket
+	pendingStdio := config.StdioFDs
+	pendingLinkFD := config.LinkFD
+	var pendingTunFD *int
+	if config.HostRoute != nil {
+		pendingTunFD = config.HostRoute.TunFD
+	}
+	defer func() {
+		closeListenerFDs(pendingImports)
+		closeVMSocketFD(pendingVMSocket)
+		closeStdioFDs(pendingStdio)
+		if pendingLinkFD != nil {
+			closeFD(*pendingLinkFD)
+		}
+		if pendingTunFD != nil {
+			closeFD(*pendingTunFD)
+		}
+	}()
+
+	// Tag the instance's logs from here on; the tags go with the instance.
+	setLogTags(id, config.LogTags)
+	started := false
+	defer func() {
+		if !started {
+			clearLogTags(id)
+		}
+	}()
+
+	if err := resolveGuests(&config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid guests")
+		return classify(createErrConfig, err)
+	}
+
+	release, err := admitInstance(config)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Instance limit reached")
+		return err
+	}
+	defer release()
+
+	reserved := newReservedPorts(config.ReservedHostPorts)
+	if err := reserved.checkMappings(config.PortMappings); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Port mapping targets a reserved host port")
+		return classify(createErrPortConflict, err)
+	}
+
+	if err := resolveRuntimeDir(&config, id); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid runtime_dir")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkSocketPaths(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Unusable socket path")
+		return classify(createErrConfig, err)
+	}
+	if err := checkAbstractSocket(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid abstract socket_path")
+		return classify(createErrConfig, err)
+	}
+
+	if err := resolveMACs(&config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid MAC configuration")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkGuestAliases(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid guest alias configuration")
+		return classify(createErrConfig, err)
+	}
+	if err := resolveHostRoute(&config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid host_route")
+		return classify(createErrConfig, err)
+	}
+	if err := checkForwardTypes(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid port mapping type")
+		return classify(createErrConfig, err)
+	}
+	if err := checkForwardHostIPs(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid port mapping host_ip")
+		return classify(createErrConfig, err)
+	}
+	if err := checkForwardHostSockets(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid port mapping host_socket")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkForwardHostVsocks(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid port mapping host_vsock")
+		return classify(createErrConfig, err)
+	}
+
+	latencyBuckets, throughputBuckets, err := config.ForwardHistogramBuckets.resolve()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid forward histogram buckets")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkThreadPriority(config.DataPathPriority); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid data_path_priority")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkCaptureRetention(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid capture_retention")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkCapturePolicy(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid capture_policy")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkSocketForwards(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid socket_forwards")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkUpstreamProxies(config.UpstreamProxies); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid upstream_proxies")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkSocketPermissions(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid socket_permissions")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkLinkPriority(config.LinkPriority); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid link_priority")
+		return classify(createErrConfig, err)
+	}
+
+	protocol, err := linkProtocol(config.Protocol)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid link protocol")
+		return err
+	}
+	if err := checkStdioFDs(config, protocol); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid stdio_fds")
+		return classify(createErrConfig, err)
+	}
+	stdio := protocol == types.StdioProtocol
+	vsock := config.VsockListen != nil
+	if vsock {
+		if err := checkVsockListen(config); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid vsock_listen")
+			return classify(createErrConfig, err)
+		}
+		// The guest forwarder's framing: a 16-bit little-endian length.
+		protocol = types.StdioProtocol
+	}
+	datagram := isDatagramProtocol(protocol)
+
+	if err := checkNATDisabledGuests(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid nat_disabled_guests")
+		return classify(createErrConfig, err)
+	}
+	linkLocal, err := newLinkLocalPolicy(config.LinkLocalPolicy)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid link_local_policy")
+		return classify(createErrConfig, err)
+	}
+	if err := checkDataPathOrder(config.DataPathOrder); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid data_path_order")
+		return classify(createErrConfig, err)
+	}
+
+	if err := checkGuestDNSServers(config); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid guest DNS servers")
+		return classify(createErrConfig, err)
+	}
+	dynamic, err := newDHCPRange(config)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid dhcp_range")
+		return classify(createErrConfig, err)
+	}
+	dhcpOptions, err := buildDHCPOptions(config)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid dhcp_options")
+		return classify(createErrConfig, err)
+	}
+
+	gwDNS, err := newGatewayDNS(config)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid DNS zone record")
+		return classify(createErrConfig, err)
+	}
+	gwDNS.queryLog = newDNSQueryLog(id, config.DNSQueryLog)
+
+	jump, err := newSSHJump(id, config)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid ssh_jump")
+		return classify(createErrConfig, err)
+	}
+
 	// Use caller-provided socket path (unique per box)
 	socketPath := config.SocketPath
-	if socketPath == "" {
+	if socketPath == "" && !vsock && !stdio && config.LinkFD == nil {
 		logrus.Error("socket_path is required in GvproxyConfig")
-		setErr(fmt.Errorf("socket_path is required in GvproxyConfig"))
-		return -1
+		return classify(createErrConfig, fmt.Errorf("socket_path is required in GvproxyConfig"))
+	}
+
+	if config.DryRun {
+		if err := dryRunCreate(config); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Dry run failed")
+			return err
+		}
+		return nil
 	}
 
-	// Remove stale socket from a previous crash (safe: path is unique per box)
-	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
-		logrus.WithFields(logrus.Fields{"error": err, "path": socketPath}).Warn("Failed to remove existing socket")
+	// A socket the caller opened is adopted like a handed-over one.
+	if config.LinkFD != nil {
+		vmSocket, err := linkFDSocket(config, protocol)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Invalid link fd")
+			return classify(createErrConfig, err)
+		}
+		config.ImportVMSocket = vmSocket
+		pendingVMSocket, pendingLinkFD = vmSocket, nil
 	}
 
-	// Platform-specific protocol selection
-	var protocol types.Protocol
-	if runtime.GOOS == "darwin" {
-		protocol = types.VfkitProtocol
-	} else {
-		protocol = types.QemuProtocol
+	hostRoute, err := openHostRoute(config)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to open host_route tun")
+		return err
+	}
+	pendingTunFD = nil
+	defer func() {
+		if !started && hostRoute != nil {
+			hostRoute.Close()
+		}
+	}()
+
+	// Remove stale socket from a previous crash (safe: path is unique per box).
+	// A handed-over socket is live and keeps its path.
+	if config.ImportVMSocket == nil && !vsock && !stdio {
+		if err := removeLinkSocket(socketPath); err != nil && !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{"error": err, "path": socketPath}).Warn("Failed to remove existing socket")
+		}
 	}
 
 	// Create gvisor-tap-vsock configuration from provided config
 	tapConfig := buildTapConfig(config, protocol)
 
-	// Set CaptureFile if provided
-	if config.CaptureFile != nil && *config.CaptureFile != "" {
-		tapConfig.CaptureFile = *config.CaptureFile
-		logrus.WithField("capture_file", *config.CaptureFile).Info("Packet capture enabled")
+	// Set CaptureFile if provided, as capture_policy allows
+	capture, err := resolveCapture(config)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Cannot write capture_file")
+		return classify(createErrConfig, err)
 	}
-
-	// Add port forwards from config
-	// Format: "0.0.0.0:PORT" for TCP (default), or "udp:0.0.0.0:PORT" for UDP
-	// Do NOT use "tcp://" prefix - it causes "too many colons in address" error
-	// Forward to guest's DHCP IP, not localhost
-	// Containers bind to 0.0.0.0 inside the guest, accessible via guest IP
-	for _, pm := range config.PortMappings {
-		forwardKey := fmt.Sprintf("0.0.0.0:%d", pm.HostPort)
-		forwardVal := fmt.Sprintf("%s:%d", config.GuestIP, pm.GuestPort)
-		tapConfig.Forwards[forwardKey] = forwardVal
-		logrus.WithFields(logrus.Fields{"host": forwardKey, "guest": forwardVal}).Info("Added TCP port forward")
+	defer func() {
+		if !started && capture != nil && capture.scratch != "" {
+			os.Remove(capture.scratch)
+		}
+	}()
+	if capture != nil {
+		tapConfig.CaptureFile = capture.sniffPath()
+		switch status, err := capture.get(); status {
+		case captureStatusActive:
+			logrus.WithField("capture_file", capture.path).Info("Packet capture enabled")
+		case captureStatusFailed:
+			logrus.WithFields(logrus.Fields{"error": err, "id": id, "capture_file": capture.path}).Warn("capture_file not writable; running without capture")
+		case captureStatusRetrying:
+			logrus.WithFields(logrus.Fields{"error": err, "id": id, "capture_file": capture.path}).Warn("capture_file not writable; retrying")
+		}
 	}
 
-	// Platform-specific socket creation
+	// Protocol-specific socket creation
 	var conn net.Conn
 	var listener net.Listener
-	var err error
+	var vmConn net.Conn // Qemu, Bess: VM connection adopted from a handed-over instance; Stdio: the pipes
 
-	if runtime.GOOS == "darwin" {
-		// macOS: Use UnixDgram with VFKit protocol (SOCK_DGRAM)
-		socketURI := fmt.Sprintf("unixgram://%s", socketPath)
-		conn, err = transport.ListenUnixgram(socketURI)
+	if err := failFFIFault(ffiFaultSocketBind); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "path": socketPath}).Error("Failed to create VM link socket")
+		return classify(createErrSocket, err)
+	}
+	if config.ImportVMSocket != nil {
+		conn, listener, vmConn, err = importVMSocket(*config.ImportVMSocket, datagram)
+		pendingVMSocket = nil
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "path": socketPath}).Error("Failed to adopt VM socket")
+			return classify(createErrSocket, err)
+		}
+		logrus.WithFields(logrus.Fields{"path": socketPath, "connected": vmConn != nil, "caller_fd": config.LinkFD != nil}).Info("Adopted VM socket")
+	} else if stdio {
+		vmConn, err = openStdioLink(*config.StdioFDs)
+		pendingStdio = nil
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to open stdio_fds")
+			return classify(createErrSocket, err)
+		}
+		logrus.WithFields(logrus.Fields{"read_fd": config.StdioFDs.ReadFD, "write_fd": config.StdioFDs.WriteFD}).Info("Using inherited FDs for the stdio link")
+	} else if vsock {
+		listener, err = listenVsock(*config.VsockListen)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "vsock": config.VsockListen.url()}).Error("Failed to create vsock listener")
+			return classify(createErrSocket, fmt.Errorf("failed to listen on %s: %w", config.VsockListen.url(), err))
+		}
+		logrus.WithField("vsock", config.VsockListen.url()).Info("Listening on vsock for the guest forwarder")
+	} else if datagram {
+		// VFKit protocol: Use UnixDgram (SOCK_DGRAM)
+		conn, err = listenVfkit(socketPath)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{"error": err, "path": socketPath}).Error("Failed to create Unix datagram socket")
-			setErr(fmt.Errorf("failed to create Unix datagram socket %q: %w", socketPath, err))
-			return -1
+			return classify(createErrSocket, fmt.Errorf("failed to create Unix datagram socket %q: %w", socketPath, err))
 		}
 		logrus.WithField("path", socketPath).Info("Created UnixDgram socket for VFKit protocol")
 	} else {
-		// Linux: Use UnixStream with Qemu protocol (SOCK_STREAM)
-		listener, err = net.Listen("unix", socketPath)
+		// Qemu protocol: Use UnixStream (SOCK_STREAM), or a named pipe on
+		// Windows; Bess: UnixPacket (SOCK_SEQPACKET)
+		listener, err = listenLink(protocol, socketPath)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{"error": err, "path": socketPath}).Error("Failed to create Unix stream socket")
-			setErr(fmt.Errorf("failed to create Unix stream socket %q: %w", socketPath, err))
-			return -1
+			logrus.WithFields(logrus.Fields{"error": err, "path": socketPath, "protocol": protocol}).Error("Failed to create VM link socket")
+			return classify(createErrSocket, fmt.Errorf("failed to create %s socket %q: %w", protocol, socketPath, err))
 		}
-		logrus.WithField("path", socketPath).Info("Created UnixStream socket for Qemu protocol")
+		logrus.WithFields(logrus.Fields{"path": socketPath, "protocol": protocol}).Info("Created Unix socket for VM link")
 	}
+	if config.ImportVMSocket == nil && (conn != nil || listener != nil && !vsock) {
+		if err := applySocketPermissions(socketPath, config.SocketPermissions); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "path": socketPath}).Error("Failed to set VM socket permissions")
+			if conn != nil {
+				conn.Close()
+			} else {
+				listener.Close()
+			}
+			removeLinkSocket(socketPath)
+			return classify(createErrSocket, fmt.Errorf("failed to set permissions of %q: %w", socketPath, err))
+		}
+	}
+	milestones.mark(MilestoneSocketCreated)
+
+	// Bind published ports. The bridge owns these listeners (instead of
+	// gvproxy's Forwards) so it can report inbound connections; they dial the
+	// guest's DHCP IP, where containers bind 0.0.0.0.
+	forwarder, err := NewPortForwarder(id, config.GuestIP, config.PortMappings, config.ImportListeners)
+	pendingImports = nil
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to bind port forwards")
+		if datagram && conn != nil {
+			conn.Close()
+		} else if listener != nil {
+			listener.Close()
+		}
+		if vmConn != nil {
+			vmConn.Close()
+		}
+		removeLinkSocket(socketPath)
+		return portBindError(err)
+	}
+	forwarder.setHistogramBuckets(latencyBuckets, throughputBuckets)
+	forwarder.gatewayIP = config.GatewayIP
 
 	// Start gvisor-tap-vsock in background
 	ctx, cancel := context.WithCancel(context.Background())
@@ -385,8 +974,103 @@ func gvproxy_create(configJSON *C.char, errOut **C.char) C.longlong {
 		SocketPath: socketPath,
 		Config:     tapConfig,
 		Cancel:     cancel,
+		ctx:        ctx,
 		conn:       conn,
 		listener:   listener,
+		forwarder:  forwarder,
+		milestones: milestones,
+		warmHosts:  warmHosts(config),
+		config:     handoffConfig(config),
+		stats:      &statsRegistry{},
+		reserved:   reserved,
+		notifier:   newGuestNotifier(config),
+		tracer:     newFrameTracer(id),
+		generation: newConfigGeneration(),
+		dns:        gwDNS,
+		nat:        newGuestNAT(config.NATDisabledGuests),
+		control:    newControlPool(controlPlaneWorkers),
+		done:       make(chan struct{}),
+	}
+	forwarder.goroutines = &instance.goroutines
+	instance.stats.Register(configCollector{instance.generation})
+	instance.stats.Register(forwardsCollector{forwarder})
+	instance.stats.Register(milestonesCollector{milestones})
+	instance.stats.Register(guestInfoCollector{guest})
+	restarts := newGuestRestarts(id, config.GuestMac, guest.reset)
+	instance.stats.Register(guestRestartsCollector{restarts})
+	instance.stats.Register(dnsCollector{gwDNS})
+	instance.stats.Register(guestNATCollector{instance.nat})
+	instance.stats.Register(runtimeCollector{})
+	instance.stats.Register(bufferPoolCollector{framePool})
+	if capture != nil {
+		instance.stats.Register(captureCollector{capture})
+	}
+
+	sends := newSendStalls(id, config.LinkStallThresholdMs)
+	instance.stats.Register(linkSendCollector{sends})
+
+	if hostRoute != nil {
+		instance.stats.Register(hostRouteCollector{hostRoute})
+	}
+	if jump != nil {
+		instance.stats.Register(sshJumpCollector{jump})
+	}
+
+	linkPrio := newLinkPriority(config.LinkPriority)
+	if linkPrio != nil {
+		instance.stats.Register(linkPriorityCollector{linkPrio})
+	}
+
+	mtu := newMTUAdvisor(id, config.MTU)
+	instance.stats.Register(mtuCollector{mtu})
+
+	// The link's data path (data_path.go).
+	policyStage := dataPathStage{}
+	captureStage := dataPathStage{observers: []frameObserver{instance.tracer}}
+	accountingStage := dataPathStage{observers: []frameObserver{milestones, restarts, guest, newDHCPLeases(id), sends, mtu}}
+	if config.FrameRingSize > 0 {
+		instance.frames = newFrameRing(config.FrameRingSize)
+		captureStage.observers = append(captureStage.observers, instance.frames)
+	}
+	prober := newGuestProber(id, config)
+	if prober != nil {
+		instance.stats.Register(guestProbeCollector{prober})
+		accountingStage.observers = append(accountingStage.observers, prober)
+	}
+	watchdog := newStallWatchdog(id, config.DataPathStallMs)
+	if watchdog != nil {
+		instance.stats.Register(stallWatchdogCollector{watchdog})
+		accountingStage.observers = append(accountingStage.observers, watchdog)
+	}
+	if config.StripTCPTimestamps {
+		tsStripper := &tcpTimestampStripper{}
+		policyStage.rewriters = append(policyStage.rewriters, tsStripper)
+		instance.stats.Register(tcpTimestampsCollector{tsStripper})
+		logrus.WithField("id", id).Info("TCP timestamp stripping enabled on guest link")
+	}
+	linkPath := buildDataPath(config.DataPathOrder, map[string]dataPathStage{
+		dataPathPolicy:     policyStage,
+		dataPathCapture:    captureStage,
+		dataPathAccounting: accountingStage,
+	})
+	if len(config.DataPathOrder) > 0 {
+		logrus.WithFields(logrus.Fields{"id": id, "stages": linkPath.names()}).Info("Data path order set for guest link")
+	}
+	egress := newEgressDialer(config.EgressTTL)
+	if egress != nil {
+		instance.stats.Register(egressTTLCollector{egress})
+		logrus.WithFields(logrus.Fields{"id": id, "ttl": config.EgressTTL}).Info("Egress TTL set for guest flows")
+	}
+	flows := newFlowLimiter(config.MaxDataPlaneFlows)
+	if flows != nil {
+		forwarder.flows = flows
+		instance.stats.Register(dataPlaneCollector{flows})
+	}
+	instance.stats.Register(linkLocalCollector{linkLocal})
+	proxies := newUpstreamProxies(config.UpstreamProxies)
+	if proxies != nil {
+		instance.stats.Register(upstreamProxiesCollector{proxies})
+		logrus.WithFields(logrus.Fields{"id": id, "rules": len(proxies.rules)}).Info("Upstream proxy rules set for guest TCP flows")
 	}
 
 	// Parse MITM CA from config (generated by Rust) when secrets are configured
@@ -394,28 +1078,41 @@ func gvproxy_create(configJSON *C.char, errOut **C.char) C.longlong {
 		ca, err := NewBoxCAFromPEM([]byte(config.CACertPEM), []byte(config.CAKeyPEM))
 		if err != nil {
 			logrus.WithError(err).Error("MITM: failed to parse CA from config")
-			setErr(fmt.Errorf("MITM: failed to parse CA from config: %w", err))
 			cancel()
-			return -1
+			forwarder.Close()
+			return classify(createErrConfig, fmt.Errorf("MITM: failed to parse CA from config: %w", err))
 		}
 		instance.ca = ca
 		instance.secretMatcher = NewSecretHostMatcher(config.Secrets)
 		logrus.WithField("num_secrets", len(config.Secrets)).Info("MITM: loaded CA from Rust config")
 	}
 
+	openCallbacks(id)
 	instancesMu.Lock()
 	instances[id] = instance
 	instancesMu.Unlock()
+	release()
+
+	if capture != nil {
+		instance.goroutines.spawn(func() { watchCaptureLimit(ctx, id, capture) })
+		if config.CaptureRetention != nil {
+			instance.goroutines.spawn(func() { watchCaptureRetention(ctx, id, capture.path, *config.CaptureRetention) })
+		}
+	}
+	instance.goroutines.spawn(func() { sends.watch(ctx) })
+	if prober != nil {
+		instance.goroutines.spawn(func() { prober.watch(ctx, instance.currentLink) })
+	}
+	if watchdog != nil {
+		instance.goroutines.spawn(func() { watchdog.watch(ctx, linkStallSnapshot(instance.currentLink, flows)) })
+	}
 
-	// initErr surfaces synchronous failures from virtualnetwork.New (e.g.
-	// host-port EADDRINUSE) back to the FFI caller. Pre-fix, the bind error
-	// died in a logrus line inside the gvproxy goroutine and gvproxy_create
-	// returned a valid id; the failure only surfaced ~20s later as guest
-	// "DNS lookup … i/o timeout" from a broken netstack.
+	// initErr carries virtualnetwork.New's error (nil on success) back to
+	// gvproxy_create, which fails with it instead of returning an id.
 	initErr := make(chan error, 1)
 
 	// Start runtime metrics monitoring goroutine
-	go func() {
+	instance.goroutines.spawn(func() {
 		ticker := time.NewTicker(30 * time.Second)
 		defer ticker.Stop()
 
@@ -438,7 +1135,7 @@ func gvproxy_create(configJSON *C.char, errOut **C.char) C.longlong {
 				}).Info("gvproxy runtime metrics")
 			}
 		}
-	}()
+	})
 
 	// Start virtual network in goroutine
 	go func() {
@@ -450,21 +1147,80 @@ func gvproxy_create(configJSON *C.char, errOut **C.char) C.longlong {
 		}
 		initErr <- nil
 
-		// Override TCP handler with AllowNet filter and/or MITM secret substitution
-		if len(config.AllowNet) > 0 || instance.secretMatcher != nil {
-			var tcpFilter *TCPFilter
-			if len(config.AllowNet) > 0 {
-				tcpFilter = NewTCPFilter(config.AllowNet, config.GatewayIP, config.GuestIP, config.HostIP)
-			}
-			if err := OverrideTCPHandler(vn, tapConfig, tapConfig.Ec2MetadataAccess, tcpFilter, instance.ca, instance.secretMatcher); err != nil {
-				logrus.WithError(err).Error("TCP: failed to override handler")
-			}
+		// Override the TCP handler with AllowNet filter, MITM secret
+		// substitution, egress TTL, a data-plane flow bound and upstream
+		// proxies, and the UDP one with egress TTL; both with the
+		// link-local and guest NAT gates
+		var tcpFilter *TCPFilter
+		if len(config.AllowNet) > 0 {
+			internalIPs := append([]string{config.GatewayIP, config.GuestIP, config.HostIP}, config.GuestAliases...)
+			internalIPs = append(internalIPs, guestIPs(config)...)
+			tcpFilter = NewTCPFilter(config.AllowNet, internalIPs...)
+		}
+		if err := OverrideTCPHandler(vn, tapConfig, tapConfig.Ec2MetadataAccess, tcpFilter, instance.ca, instance.secretMatcher, egress, flows, proxies, instance.nat, linkLocal); err != nil {
+			logrus.WithError(err).Error("TCP: failed to override handler")
+		}
+		if err := OverrideUDPHandler(vn, tapConfig, egress, instance.nat, linkLocal); err != nil {
+			logrus.WithError(err).Error("UDP: failed to override handler")
 		}
 
 		// Store VirtualNetwork reference for stats collection
 		instance.vnMu.Lock()
 		instance.vn = vn
 		instance.vnMu.Unlock()
+		instance.stats.Register(dhcpCollector{vn})
+
+		if err := gwDNS.takeOver(ctx, vn, config.GatewayIP); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to start gateway DNS server")
+		}
+		if len(config.GuestDNSServers) > 0 || dynamic != nil || len(dhcpOptions) > 0 {
+			if err := takeOverDHCP(ctx, vn, tapConfig, dynamic, dhcpNameservers(config), dhcpOptions); err != nil {
+				logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to start gateway DHCP server")
+			} else {
+				logrus.WithFields(logrus.Fields{"id": id, "servers": config.GuestDNSServers, "gateway_dns": !config.DisableGatewayDNS, "dhcp_range": config.DHCPRange, "dhcp_options": len(dhcpOptions)}).Info("Bridge DHCP server started")
+			}
+		}
+
+		forwarder.Serve(ctx, vn)
+		if config.ForwardLeaseMAC != "" {
+			instance.goroutines.spawn(func() {
+				forwarder.followLease(ctx, config.ForwardLeaseMAC, config.GuestIP, func() map[string]string { return vnLeases(vn) })
+			})
+		}
+
+		for _, sf := range config.SocketForwards {
+			instance.goroutines.spawn(func() { serveSocketForward(ctx, id, vn, config.GuestIP, sf) })
+		}
+
+		if config.TestServices {
+			if err := startTestServices(ctx, vn, config.GatewayIP, newServiceGuard(id, func() map[string]string { return vnLeases(vn) })); err != nil {
+				logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to start gateway test services")
+			}
+		}
+
+		if config.ConnectProxySocketPath != "" {
+			if err := startConnectProxy(ctx, id, vn, config.ConnectProxySocketPath, instance.detached.Load, &instance.goroutines); err != nil {
+				logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to start guest network CONNECT proxy")
+			}
+		}
+
+		if jump != nil {
+			if err := jump.start(ctx, vn, func() map[string]string { return vnLeases(vn) }, &instance.goroutines); err != nil {
+				logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to start SSH jump host")
+			}
+		}
+
+		if hostRoute != nil {
+			// The tun is one more station on the switch; one frame per
+			// Read and Write, like bess.
+			instance.goroutines.spawn(hostRoute.serve)
+			instance.goroutines.spawn(func() {
+				if err := vn.AcceptBess(ctx, hostRoute); err != nil && ctx.Err() == nil {
+					logrus.WithFields(logrus.Fields{"error": err, "id": id}).Warn("Host route closed")
+				}
+			})
+			logrus.WithFields(logrus.Fields{"id": id, "address": config.HostRoute.Address}).Info("Host route attached")
+		}
 
 		// Bind gvproxy's ServicesMux to a host unix socket so the boxlite core
 		// can drive dynamic port forwarding / DNS / leases on the running box.
@@ -482,83 +1238,204 @@ func gvproxy_create(configJSON *C.char, errOut **C.char) C.longlong {
 			} else {
 				controlListener = l
 				logrus.WithField("path", config.ControlSocketPath).Info("Serving gvproxy ServicesMux")
-				go func() {
-					if sErr := http.Serve(l, vn.ServicesMux()); sErr != nil && ctx.Err() == nil {
+				instance.goroutines.spawn(func() {
+					if sErr := http.Serve(l, instance.control.handler(traceRequests(id, reserved.guardExpose(servicesMux(vn, gwDNS))))); sErr != nil && ctx.Err() == nil {
 						logrus.WithError(sErr).Error("gvproxy services HTTP server exited")
 					}
-				}()
+				})
 			}
 		}
 
-		// Platform-specific packet handling
-		if runtime.GOOS == "darwin" {
-			// macOS: Handle VFKit datagram packets
+		// Protocol-specific packet handling
+		if vsock {
+			// vsock: Handle guest forwarder connections, each becoming the
+			// link in turn
+			instance.goroutines.spawn(func() {
+				for {
+					rawConn, err := listener.Accept()
+					if err != nil {
+						if ctx.Err() == nil {
+							logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to accept vsock connection")
+						}
+						return
+					}
+					acceptedConn, err := acceptVsockConnect(rawConn)
+					if err != nil {
+						logrus.WithFields(logrus.Fields{"error": err, "id": id, "remote": rawConn.RemoteAddr().String()}).Warn("Rejected vsock connection")
+						rawConn.Close()
+						continue
+					}
+					logrus.WithFields(logrus.Fields{"id": id, "remote": rawConn.RemoteAddr().String()}).Info("Vsock connection accepted")
+					milestones.mark(MilestoneVMConnected)
+
+					link := newDataPathLinkConn(acceptedConn, types.StdioProtocol, linkPath)
+					link.sends = sends
+					link.queue = linkPrio.attach(link.Conn, link.prefixLen, sends)
+					instance.setLink(link)
+					instance.goroutines.spawn(func() {
+						defer link.Close()
+						lockDataPathThread(id, config.DataPathPriority)
+						if err := vn.AcceptStdio(ctx, link); err != nil && ctx.Err() == nil {
+							logrus.WithFields(logrus.Fields{"error": err, "id": id}).Warn("Vsock link closed")
+						}
+					})
+				}
+			})
+		} else if datagram {
+			// VFKit: Handle datagram packets
 			// VFKit requires a two-step process:
-			// 1. transport.AcceptVfkit() - Waits for incoming data and wraps listener with remote address
+			// 1. acceptVfkit() - Waits for incoming data and wraps listener with remote address
 			// 2. vn.AcceptVfkit() - Handles the VFKit protocol
-			go func() {
+			instance.goroutines.spawn(func() {
+				lockDataPathThread(id, config.DataPathPriority)
 				logrus.WithField("id", id).Trace("Waiting for VFKit connection on UnixDgram socket")
 
-				// Wait for incoming connection and get wrapped connection with remote address
-				// AcceptVfkit peeks at the first packet to get the remote address
-				wrappedConn, err := transport.AcceptVfkit(conn.(*net.UnixConn))
-				if err != nil {
-					logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to accept VFKit connection")
-					return
-				}
+				// A connected socket (link_fd.go) is the link already, with
+				// no hello to wait for.
+				vfkitLink := vmConn
+				if vfkitLink == nil {
+					// Wait for incoming connection and get wrapped connection with remote address
+					// AcceptVfkit peeks at the first packet to get the remote address
+					wrappedConn, err := acceptVfkit(conn.(*net.UnixConn))
+					if err != nil {
+						logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to accept VFKit connection")
+						return
+					}
+
+					logrus.WithFields(logrus.Fields{"id": id, "remote": wrappedConn.RemoteAddr().String()}).Info("VFKit connection accepted")
 
-				logrus.WithFields(logrus.Fields{"id": id, "remote": wrappedConn.RemoteAddr().String()}).Info("VFKit connection accepted")
+					// A restarted VMM re-announces itself on the same socket;
+					// the link then follows it (vm_reconnect.go).
+					connections := 1
+					wrappedConn.onHello = func(remote *net.UnixAddr) {
+						connections++
+						vmReconnected(id, types.VfkitProtocol, remote.String(), connections)
+					}
+					vfkitLink = wrappedConn
+				}
+				milestones.mark(MilestoneVMConnected)
 
 				// Handle the VFKit protocol with the wrapped connection
-				if err := vn.AcceptVfkit(ctx, wrappedConn); err != nil {
-					if ctx.Err() == nil {
+				link := newDataPathLinkConn(vfkitLink, types.VfkitProtocol, linkPath)
+				link.sends = sends
+				link.queue = linkPrio.attach(link.Conn, link.prefixLen, sends)
+				instance.setLink(link)
+				if err := vn.AcceptVfkit(ctx, link); err != nil {
+					if ctx.Err() == nil && !instance.detached.Load() {
 						logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("AcceptVfkit error")
 					}
 				}
-			}()
+			})
 		} else {
-			// Linux: Handle Qemu stream connections
-			go func() {
-				logrus.WithField("id", id).Trace("Waiting for Qemu connection on UnixStream socket")
-
-				// Accept incoming connection (blocks until VM connects)
-				acceptedConn, err := listener.Accept()
-				if err != nil {
-					if ctx.Err() == nil {
-						logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to accept connection")
+			// Qemu, Bess, Stdio: Handle the VM's connection
+			acceptLink := vn.AcceptQemu
+			switch protocol {
+			case types.BessProtocol:
+				acceptLink = vn.AcceptBess
+			case types.StdioProtocol:
+				acceptLink = vn.AcceptStdio
+			}
+			instance.goroutines.spawn(func() {
+				lockDataPathThread(id, config.DataPathPriority)
+				// A handed-over instance may already have its VM connection.
+				acceptedConn := vmConn
+				// One VM at a time: the listener stays open, and a VM that
+				// reconnects is accepted once the previous link has ended
+				// (vm_reconnect.go).
+				for connections := 1; ; connections++ {
+					var err error
+					if acceptedConn == nil {
+						logrus.WithFields(logrus.Fields{"id": id, "protocol": protocol}).Trace("Waiting for VM connection")
+
+						// Accept incoming connection (blocks until VM connects)
+						acceptedConn, err = instance.acceptVM(ctx, protocol)
+						if err != nil {
+							if ctx.Err() == nil && !instance.detached.Load() {
+								logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("Failed to accept connection")
+							}
+							return
+						}
+
+						logrus.WithFields(logrus.Fields{"id": id, "protocol": protocol, "remote": acceptedConn.RemoteAddr().String()}).Info("VM connection accepted")
+					}
+					if connections == 1 {
+						milestones.mark(MilestoneVMConnected)
+					} else {
+						vmReconnected(id, protocol, acceptedConn.RemoteAddr().String(), connections)
 					}
-					return
-				}
-
-				logrus.WithFields(logrus.Fields{"id": id, "remote": acceptedConn.RemoteAddr().String()}).Info("Qemu connection accepted")
-
-				// Close listener after first connection (one VM per gvproxy instance)
-				listener.Close()
 
-				// Handle the Qemu protocol
-				if err := vn.AcceptQemu(ctx, acceptedConn); err != nil {
-					if ctx.Err() == nil {
-						logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("AcceptQemu error")
+					// Handle the link protocol, resuming any frame the previous
+					// owner of a handed-over connection had partially read.
+					link := newDataPathLinkConn(acceptedConn, protocol, linkPath)
+					if connections == 1 && config.ImportVMSocket != nil {
+						link.seed(config.ImportVMSocket.Pending)
+					}
+					link.sends = sends
+					link.queue = linkPrio.attach(link.Conn, link.prefixLen, sends)
+					instance.setLink(link)
+					err = acceptLink(ctx, link)
+					if ctx.Err() != nil || instance.detached.Load() {
+						return
+					}
+					if stdio || instance.vmListener() == nil && instance.SocketPath == "" {
+						// The pipes, or a connected socket the caller passed
+						// without a socket_path (link_fd.go), cannot be reopened.
+						logrus.WithFields(logrus.Fields{"error": err, "id": id, "protocol": protocol}).Error("VM link error")
+						return
 					}
+					instance.setLink(nil)
+					link.Close()
+					vmDisconnected(id, protocol, err)
+					acceptedConn = nil
 				}
-			}()
+			})
 		}
 
 		// Wait for context cancellation
 		<-ctx.Done()
 
-		// Cleanup
+		// Cleanup. A handed-over instance's socket paths now belong to its
+		// replacement: close our FDs but leave the paths in place.
+		detached := instance.detached.Load()
+		// The link socket may have been re-created (vm_reconnect.go).
+		listener := instance.vmListener()
+		if detached {
+			keepSocketPath(controlListener)
+			keepSocketPath(listener)
+		}
+		forwarder.Close()
 		if controlListener != nil {
 			// Closing the listener unblocks the http.Serve goroutine.
 			controlListener.Close()
-			os.Remove(config.ControlSocketPath)
+			if !detached {
+				os.Remove(config.ControlSocketPath)
+			}
 		}
-		if runtime.GOOS == "darwin" && conn != nil {
+		instance.control.close()
+		if datagram && conn != nil {
 			conn.Close()
 		} else if listener != nil {
 			listener.Close()
 		}
-		os.Remove(socketPath)
+		if link := instance.currentLink(); link != nil {
+			// The link goroutine is blocked reading from the VM.
+			link.Close()
+		}
+		if vmConn != nil {
+			// The pipes, or a connected socket handed to the instance, are
+			// the instance's; closing them ends the link.
+			vmConn.Close()
+		}
+		if hostRoute != nil {
+			hostRoute.Close()
+		}
+		if !detached {
+			removeLinkSocket(socketPath)
+			instance.removeVZPeer()
+		}
+		instance.finishTeardown()
+		clearLogTags(id)
+		close(instance.done)
 	}()
 
 	// Wait for virtualnetwork.New to complete before returning a valid id.
@@ -567,58 +1444,167 @@ func gvproxy_create(configJSON *C.char, errOut **C.char) C.longlong {
 	// shipping a broken socket downstream.
 	if err := <-initErr; err != nil {
 		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Error("gvproxy init failed; tearing down instance")
-		setErr(err)
 		cancel()
 		instancesMu.Lock()
 		delete(instances, id)
 		instancesMu.Unlock()
-		if runtime.GOOS == "darwin" && conn != nil {
+		dropCallbacks(id)
+		forwarder.Close()
+		if datagram && conn != nil {
 			conn.Close()
 		} else if listener != nil {
 			listener.Close()
 		}
-		os.Remove(socketPath)
-		return -1
+		if vmConn != nil {
+			vmConn.Close()
+		}
+		removeLinkSocket(socketPath)
+		return err
+	}
+	if capture != nil && capture.scratch != "" {
+		instance.goroutines.spawn(func() { capture.retry(ctx, id) })
 	}
 
-	logrus.Info("Created gvproxy instance", "id", id, "socket", socketPath, "protocol", protocol)
-	return C.longlong(id)
-}
-
-//export gvproxy_free_string
-func gvproxy_free_string(str *C.char) {
-	C.free(unsafe.Pointer(str))
+	logrus.WithFields(logrus.Fields{"id": id, "socket": socketPath, "protocol": protocol}).Info("Created gvproxy instance")
+	created := instanceFeatures(config, protocol)
+	created["generation"] = instance.generation.current()
+	emitEvent(id, EventInstanceCreated, created)
+	started = true
+	return nil
 }
 
 //export gvproxy_destroy
 func gvproxy_destroy(id C.longlong) C.int {
+	return destroyInstance(int64(id))
+}
+
+// gvproxy_destroy_traced is gvproxy_destroy with a trace ID (see
+// trace_ids.go) attached to the logs and events of the teardown. traceID
+// may be NULL.
+//
+//export gvproxy_destroy_traced
+func gvproxy_destroy_traced(id C.longlong, traceID *C.char) C.int {
+	var rc C.int
+	withTrace(int64(id), C.GoString(traceID), func() { rc = destroyInstance(int64(id)) })
+	return rc
+}
+
+func destroyInstance(id int64) C.int {
+	instance := removeInstance(id)
+	if instance == nil {
+		return -1
+	}
+
+	// Cancel context to stop goroutines
+	instance.Cancel()
+	return 0
+}
+
+// removeInstance unregisters instance id for its teardown, or returns nil if
+// it does not exist.
+func removeInstance(id int64) *GvproxyInstance {
 	instancesMu.Lock()
-	instance, ok := instances[int64(id)]
+	instance, ok := instances[id]
 	if ok {
-		delete(instances, int64(id))
+		delete(instances, id)
 	}
 	instancesMu.Unlock()
 
 	if !ok {
-		return -1
+		return nil
 	}
 
-	// Cancel context to stop goroutines
-	instance.Cancel()
-
-	logrus.Info("Destroyed gvproxy instance", "id", id)
-	return 0
+	// Log before cancelling: teardown drops the instance's log tags.
+	logrus.WithField("id", id).Info("Destroyed gvproxy instance")
+	return instance
 }
 
 //export gvproxy_get_stats
 func gvproxy_get_stats(id C.longlong) *C.char {
+	return getStats(id, StatsFormatJSON)
+}
+
+// gvproxy_get_stats_format is gvproxy_get_stats with an explicit payload
+// format: "json" (the gvproxy_get_stats document) or "openmetrics"
+// (Prometheus/OpenMetrics text exposition, ready to serve from a scrape
+// endpoint). Returns NULL for an unknown format.
+//
+//export gvproxy_get_stats_format
+func gvproxy_get_stats_format(id C.longlong, format *C.char) *C.char {
+	if format == nil {
+		return getStats(id, StatsFormatJSON)
+	}
+	return getStats(id, C.GoString(format))
+}
+
+func getStats(id C.longlong, format string) *C.char {
+	stats := instanceStats(id, format)
+	if stats == "" {
+		return nil
+	}
+
+	// Explicit: CString allocates memory, caller must free it
+	return returnCString(stats)
+}
+
+// gvproxy_get_stats_buf writes the gvproxy_get_stats document into a
+// caller-owned buffer, for callers polling often enough that a malloc/free
+// pair per call matters. The document is written NUL-terminated only if it
+// fits in len bytes; otherwise buf is left untouched.
+//
+// Returns the buffer size the document needs (including the terminating NUL),
+// so a return value > len means "retry with a larger buffer". Returns -1 if
+// the instance does not exist or its network is not ready yet.
+//
+//export gvproxy_get_stats_buf
+func gvproxy_get_stats_buf(id C.longlong, buf *C.char, length C.int) C.int {
+	stats := instanceStats(id, StatsFormatJSON)
+	if stats == "" {
+		return -1
+	}
+	var dst []byte
+	if buf != nil && length > 0 {
+		dst = unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(length))
+	}
+	return C.int(fillStatsBuffer(dst, stats))
+}
+
+// fillStatsBuffer copies s and a NUL terminator into dst if both fit, and
+// returns the size needed either way.
+func fillStatsBuffer(dst []byte, s string) int {
+	need := len(s) + 1
+	if len(dst) >= need {
+		copy(dst, s)
+		dst[len(s)] = 0
+	}
+	return need
+}
+
+// instanceStats renders an instance's stats in format on its control plane
+// (planes.go), or returns "" if the instance does not exist or is not ready.
+func instanceStats(id C.longlong, format string) string {
+	if failFFIFault(ffiFaultStatsUnavailable) != nil {
+		return ""
+	}
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok {
+		return ""
+	}
+	var out string
+	instance.control.run(func() { out = renderInstanceStats(id, format) })
+	return out
+}
+
+func renderInstanceStats(id C.longlong, format string) string {
 	// Validate Early: Check instance exists
 	instancesMu.RLock()
 	instance, ok := instances[int64(id)]
 	instancesMu.RUnlock()
 
 	if !ok {
-		return nil
+		return ""
 	}
 
 	// Validate Early: Check vn initialized
@@ -628,37 +1614,62 @@ func gvproxy_get_stats(id C.longlong) *C.char {
 	instance.vnMu.RUnlock()
 
 	if vn == nil {
-		return nil
+		return ""
 	}
 
 	// Single Responsibility: Delegate to stats.go for collection
-	stats := collectNetworkStats(vn)
-	if stats == "" {
+	return collectInstanceStats(vn, instance, format)
+}
+
+// gvproxy_get_stats_schema returns a JSON array of {name, description}, one
+// per bridge section an instance adds to gvproxy_get_stats. The upstream
+// sections (BytesSent, BytesReceived and the netstack counter groups) are
+// always present and not listed.
+//
+// Returns NULL if the instance does not exist. The string must be freed with
+// gvproxy_free_string.
+//
+//export gvproxy_get_stats_schema
+func gvproxy_get_stats_schema(id C.longlong) *C.char {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok {
 		return nil
 	}
 
-	// Explicit: CString allocates memory, caller must free it
-	return C.CString(stats)
+	out, err := json.Marshal(instance.stats.Schema())
+	if err != nil {
+		return nil
+	}
+	return returnCString(string(out))
 }
 
 //export gvproxy_get_version
 func gvproxy_get_version() *C.char {
+	return returnCString(gvisorTapVsockVersion())
+}
+
+// gvisorTapVsockVersion reports the linked gvisor-tap-vsock module version.
+func gvisorTapVsockVersion() string {
 	// Get gvisor-tap-vsock version from build info
 	buildInfo, ok := debug.ReadBuildInfo()
 	if !ok {
-		return C.CString("unknown")
+		return "unknown"
 	}
 
 	// Find gvisor-tap-vsock dependency
 	for _, dep := range buildInfo.Deps {
 		if dep.Path == "github.com/containers/gvisor-tap-vsock" {
-			return C.CString(dep.Version)
+			return dep.Version
 		}
 	}
 
-	return C.CString("unknown")
+	return "unknown"
 }
 
 func main() {
-	// CGO library, no main needed
+	// Never runs in the c-archive. Built as an executable, the bridge runs
+	// as a helper process (helper.go).
+	os.Exit(runHelper(os.Args[1:], os.Stdin, os.Stdout))
 }
@@ -0,0 +1,129 @@
+package main
+
+// milestones.go — Boot-time networking timeline per instance.
+//
+// Records how long after gvproxy_create each step of guest network bring-up
+// happened (socket → VM connected → first frame → DHCP → first NAT flow), so
+// boot regressions show up as numbers in stats and events instead of "the
+// box felt slow".
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// Milestone names (stable wire values, match Rust).
+const (
+	MilestoneSocketCreated = "socket_created"
+	MilestoneVMConnected   = "vm_connected"
+	MilestoneFirstFrame    = "first_frame"
+	MilestoneDHCPComplete  = "dhcp_complete"
+	MilestoneFirstNATFlow  = "first_nat_flow"
+)
+
+// EventMilestone fires the first time an instance reaches a milestone.
+// Fields: milestone, elapsed_ms.
+const EventMilestone = "milestone"
+
+// instanceMilestones records the first time each milestone is reached,
+// relative to instance creation. It observes link frames to detect the
+// frame-derived milestones; the atomic flags keep the per-frame cost to a
+// load once a milestone has been seen.
+type instanceMilestones struct {
+	instanceID int64
+	start      time.Time
+	gateway    tcpip.Address
+
+	firstFrame atomic.Bool
+	dhcpDone   atomic.Bool
+	natFlow    atomic.Bool
+
+	mu      sync.Mutex
+	reached map[string]time.Duration
+}
+
+func newInstanceMilestones(instanceID int64, gatewayIP string) *instanceMilestones {
+	m := &instanceMilestones{
+		instanceID: instanceID,
+		start:      time.Now(),
+		reached:    make(map[string]time.Duration),
+	}
+	if ip := net.ParseIP(gatewayIP).To4(); ip != nil {
+		m.gateway = tcpip.AddrFrom4Slice(ip)
+	}
+	return m
+}
+
+// mark records name the first time it is reached; later calls are no-ops.
+func (m *instanceMilestones) mark(name string) {
+	m.mu.Lock()
+	if _, ok := m.reached[name]; ok {
+		m.mu.Unlock()
+		return
+	}
+	elapsed := time.Since(m.start)
+	m.reached[name] = elapsed
+	m.mu.Unlock()
+
+	elapsedMs := durationMs(elapsed)
+	logrus.WithFields(logrus.Fields{"id": m.instanceID, "milestone": name, "elapsed_ms": elapsedMs}).Info("gvproxy milestone reached")
+	emitEvent(m.instanceID, EventMilestone, map[string]any{
+		"milestone":  name,
+		"elapsed_ms": elapsedMs,
+	})
+}
+
+func (m *instanceMilestones) ingressFrame(frame []byte) {
+	if !m.firstFrame.Load() && m.firstFrame.CompareAndSwap(false, true) {
+		m.mark(MilestoneFirstFrame)
+	}
+	if !m.natFlow.Load() && isOutboundFlowStart(frame, m.gateway) && m.natFlow.CompareAndSwap(false, true) {
+		m.mark(MilestoneFirstNATFlow)
+	}
+}
+
+func (m *instanceMilestones) egressFrame(frame []byte) {
+	if !m.dhcpDone.Load() && isDHCPAck(frame) && m.dhcpDone.CompareAndSwap(false, true) {
+		m.mark(MilestoneDHCPComplete)
+	}
+}
+
+// Stats returns milestone offsets in milliseconds since creation.
+func (m *instanceMilestones) Stats() map[string]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]float64, len(m.reached))
+	for name, elapsed := range m.reached {
+		out[name] = durationMs(elapsed)
+	}
+	return out
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// milestonesCollector publishes the boot timeline as the "Milestones" section.
+type milestonesCollector struct{ m *instanceMilestones }
+
+func (milestonesCollector) Name() string { return "Milestones" }
+
+func (milestonesCollector) Description() string {
+	return "Milliseconds from instance creation to each networking milestone reached."
+}
+
+func (c milestonesCollector) Collect() any { return c.m.Stats() }
+
+func (c milestonesCollector) collectOpenMetrics(emit metricEmitter) {
+	for name, ms := range c.m.Stats() {
+		emit(openMetricsPrefix+"milestone_seconds", "gauge",
+			"Time from instance creation to a networking milestone.",
+			fmt.Sprintf(`milestone="%s"`, name), formatFloat(ms/1000))
+	}
+}
@@ -15,8 +15,9 @@ import (
 const upstreamDialTimeout = 30 * time.Second
 
 // mitmAndForward handles a MITM'd connection: TLS termination, reverse proxy, secret substitution.
-// upstreamTLSConfig overrides the TLS config for upstream connections (nil = system defaults).
-func mitmAndForward(guestConn net.Conn, hostname string, destAddr string, ca *BoxCA, secrets []SecretConfig, upstreamTLSConfig ...*tls.Config) {
+// dialer opens the upstream connection (nil = direct, OS defaults). upstreamTLSConfig
+// overrides the TLS config for upstream connections (nil = system defaults).
+func mitmAndForward(guestConn net.Conn, hostname string, destAddr string, dialer *upstreamDial, ca *BoxCA, secrets []SecretConfig, upstreamTLSConfig ...*tls.Config) {
 	cert, err := ca.GenerateHostCert(hostname)
 	if err != nil {
 		logrus.WithError(err).WithField("hostname", hostname).Error("MITM: cert generation failed")
@@ -35,7 +36,9 @@ func mitmAndForward(guestConn net.Conn, hostname string, destAddr string, ca *Bo
 		ForceAttemptHTTP2: true,
 		TLSClientConfig:  resolveUpstreamTLS(hostname, upstreamTLSConfig...),
 		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
-			return (&net.Dialer{Timeout: upstreamDialTimeout}).DialContext(ctx, network, destAddr)
+			ctx, cancel := context.WithTimeout(ctx, upstreamDialTimeout)
+			defer cancel()
+			return dialer.DialContext(ctx, network, destAddr)
 		},
 	}
 
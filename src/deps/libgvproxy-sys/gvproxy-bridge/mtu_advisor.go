@@ -0,0 +1,194 @@
+package main
+
+// mtu_advisor.go — Turn MTU mismatches into an actionable event.
+//
+// A guest interface MTU larger than the gateway's (mtu in the config) is one
+// of the most common misconfigurations, and it fails quietly: small requests
+// work, bulk transfers and TLS handshakes hang. Two symptoms are visible on
+// the hypervisor link:
+//
+//   - oversized guest frames: IP packets from the guest larger than the
+//     configured MTU, which the gateway's netstack drops;
+//   - fragmentation-needed storms: bursts of ICMP "fragmentation needed"
+//     in either direction, meaning a path MTU is being rediscovered over
+//     and over.
+//
+// Either raises EventMTUAdvisory with a recommended MTU, at most once per
+// reason every mtuAdvisoryInterval.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// EventMTUAdvisory fires when frames on the link suggest an MTU mismatch.
+// Fields: reason ("oversized_guest_frames" or "frag_needed_storm"), mtu (the
+// configured MTU), observed (largest guest packet, or smallest MTU reported
+// by fragmentation-needed messages), recommended_mtu, advice (a sentence for
+// the operator).
+const EventMTUAdvisory = "mtu_advisory"
+
+const (
+	mtuReasonOversized = "oversized_guest_frames"
+	mtuReasonFragStorm = "frag_needed_storm"
+
+	// fragNeededStormCount fragmentation-needed messages within
+	// fragNeededStormWindow make a storm.
+	fragNeededStormCount  = 10
+	fragNeededStormWindow = 10 * time.Second
+)
+
+// mtuAdvisoryInterval rate-limits EventMTUAdvisory per reason. A var so
+// tests can shorten it.
+var mtuAdvisoryInterval = time.Minute
+
+// mtuAdvisor is a frameObserver watching for MTU mismatch symptoms.
+type mtuAdvisor struct {
+	instanceID int64
+	mtu        int
+
+	mu              sync.Mutex
+	oversized       uint64
+	largest         int // largest oversized guest packet
+	fragNeeded      uint64
+	smallestFragMTU int // smallest MTU in a fragmentation-needed message
+	stormStart      time.Time
+	stormCount      int
+	advisories      uint64
+	lastAdvisory    map[string]time.Time
+}
+
+func newMTUAdvisor(instanceID int64, mtu uint16) *mtuAdvisor {
+	return &mtuAdvisor{instanceID: instanceID, mtu: int(mtu), lastAdvisory: make(map[string]time.Time)}
+}
+
+func (m *mtuAdvisor) ingressFrame(frame []byte) {
+	ip := frameIPv4(frame)
+	if ip == nil {
+		return
+	}
+	if size := int(ip.TotalLength()); m.mtu > 0 && size > m.mtu {
+		m.oversizedPacket(size)
+		return
+	}
+	m.checkFragNeeded(ip)
+}
+
+func (m *mtuAdvisor) egressFrame(frame []byte) {
+	if ip := frameIPv4(frame); ip != nil {
+		m.checkFragNeeded(ip)
+	}
+}
+
+func (m *mtuAdvisor) oversizedPacket(size int) {
+	m.mu.Lock()
+	m.oversized++
+	m.largest = max(m.largest, size)
+	fields := m.advise(mtuReasonOversized, size, m.mtu,
+		fmt.Sprintf("The guest sends %d-byte packets but the gateway MTU is %d: set the guest interface MTU to %d, or raise mtu to at least %d.", size, m.mtu, m.mtu, size))
+	m.mu.Unlock()
+	if fields != nil {
+		emitEvent(m.instanceID, EventMTUAdvisory, fields)
+	}
+}
+
+// checkFragNeeded counts ICMP fragmentation-needed messages and reports a
+// storm of them.
+func (m *mtuAdvisor) checkFragNeeded(ip header.IPv4) {
+	if ip.TransportProtocol() != header.ICMPv4ProtocolNumber {
+		return
+	}
+	icmp := header.ICMPv4(ip.Payload())
+	if len(icmp) < header.ICMPv4MinimumSize ||
+		icmp.Type() != header.ICMPv4DstUnreachable || icmp.Code() != header.ICMPv4FragmentationNeeded {
+		return
+	}
+	mtu := int(icmp.MTU())
+
+	m.mu.Lock()
+	m.fragNeeded++
+	if mtu > 0 && (m.smallestFragMTU == 0 || mtu < m.smallestFragMTU) {
+		m.smallestFragMTU = mtu
+	}
+	now := time.Now()
+	if now.Sub(m.stormStart) > fragNeededStormWindow {
+		m.stormStart, m.stormCount = now, 0
+	}
+	m.stormCount++
+	var fields map[string]any
+	if m.stormCount >= fragNeededStormCount {
+		recommended := m.mtu
+		if m.smallestFragMTU > 0 {
+			recommended = m.smallestFragMTU
+		}
+		fields = m.advise(mtuReasonFragStorm, m.smallestFragMTU, recommended,
+			fmt.Sprintf("%d ICMP fragmentation-needed messages in %s: a path MTU is smaller than the configured %d; set mtu (and the guest interface MTU) to %d.", m.stormCount, fragNeededStormWindow, m.mtu, recommended))
+	}
+	m.mu.Unlock()
+	if fields != nil {
+		emitEvent(m.instanceID, EventMTUAdvisory, fields)
+	}
+}
+
+// advise returns the fields of an advisory for reason, or nil if one was
+// raised within mtuAdvisoryInterval. Called with mu held.
+func (m *mtuAdvisor) advise(reason string, observed, recommended int, advice string) map[string]any {
+	now := time.Now()
+	if last, ok := m.lastAdvisory[reason]; ok && now.Sub(last) < mtuAdvisoryInterval {
+		return nil
+	}
+	m.lastAdvisory[reason] = now
+	m.advisories++
+	return map[string]any{
+		"reason":          reason,
+		"mtu":             m.mtu,
+		"observed":        observed,
+		"recommended_mtu": recommended,
+		"advice":          advice,
+	}
+}
+
+// MTUStats is the "MTU" stats section.
+type MTUStats struct {
+	MTU                int    `json:"MTU"`
+	OversizedFrames    uint64 `json:"OversizedFrames"`
+	LargestOversized   int    `json:"LargestOversized,omitempty"`
+	FragNeeded         uint64 `json:"FragNeeded"`
+	SmallestFragNeeded int    `json:"SmallestFragNeeded,omitempty"`
+	Advisories         uint64 `json:"Advisories"`
+}
+
+func (m *mtuAdvisor) Stats() MTUStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return MTUStats{
+		MTU:                m.mtu,
+		OversizedFrames:    m.oversized,
+		LargestOversized:   m.largest,
+		FragNeeded:         m.fragNeeded,
+		SmallestFragNeeded: m.smallestFragMTU,
+		Advisories:         m.advisories,
+	}
+}
+
+// mtuCollector publishes MTU mismatch counters as the "MTU" section.
+type mtuCollector struct{ m *mtuAdvisor }
+
+func (mtuCollector) Name() string { return "MTU" }
+
+func (mtuCollector) Description() string {
+	return "MTU mismatch symptoms on the guest link: guest packets over the configured MTU and ICMP fragmentation-needed messages."
+}
+
+func (c mtuCollector) Collect() any { return c.m.Stats() }
+
+func (c mtuCollector) collectOpenMetrics(emit metricEmitter) {
+	stats := c.m.Stats()
+	emit(openMetricsPrefix+"mtu", "gauge", "Configured gateway MTU.", "", fmt.Sprint(stats.MTU))
+	emit(openMetricsPrefix+"mtu_oversized_frames", "counter", "Guest packets larger than the configured MTU.", "", fmt.Sprint(stats.OversizedFrames))
+	emit(openMetricsPrefix+"mtu_frag_needed", "counter", "ICMP fragmentation-needed messages on the guest link.", "", fmt.Sprint(stats.FragNeeded))
+	emit(openMetricsPrefix+"mtu_advisories", "counter", "MTU advisories raised.", "", fmt.Sprint(stats.Advisories))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func testFragNeeded(mtu uint16) []byte {
+	icmp := header.ICMPv4(make([]byte, header.ICMPv4MinimumSize))
+	icmp.SetType(header.ICMPv4DstUnreachable)
+	icmp.SetCode(header.ICMPv4FragmentationNeeded)
+	icmp.SetMTU(mtu)
+	return testIPv4Frame(header.ICMPv4ProtocolNumber, "192.168.127.1", "192.168.127.2", icmp)
+}
+
+func TestMTUAdvisor_OversizedGuestFrames(t *testing.T) {
+	const id = 5400
+	m := newMTUAdvisor(id, 1500)
+
+	m.ingressFrame(testIPv4Frame(header.UDPProtocolNumber, "192.168.127.2", "1.1.1.1", make([]byte, 1400)))
+	if stats := m.Stats(); stats.OversizedFrames != 0 {
+		t.Fatalf("stats = %+v, want no oversized frames", stats)
+	}
+
+	oversized := testIPv4Frame(header.UDPProtocolNumber, "192.168.127.2", "1.1.1.1", make([]byte, 8980))
+	m.ingressFrame(oversized)
+	m.ingressFrame(oversized)
+
+	e := waitForEvent(t, id, EventMTUAdvisory)
+	if e.Fields["reason"] != mtuReasonOversized || e.Fields["observed"] != 9000 || e.Fields["recommended_mtu"] != 1500 {
+		t.Fatalf("advisory fields = %v", e.Fields)
+	}
+	if stats := m.Stats(); stats.OversizedFrames != 2 || stats.LargestOversized != 9000 || stats.Advisories != 1 {
+		t.Fatalf("stats = %+v, want two oversized frames and one advisory", stats)
+	}
+}
+
+func TestMTUAdvisor_FragNeededStorm(t *testing.T) {
+	defer func(interval time.Duration) { mtuAdvisoryInterval = interval }(mtuAdvisoryInterval)
+	mtuAdvisoryInterval = time.Hour
+
+	const id = 5401
+	m := newMTUAdvisor(id, 1500)
+	for i := range fragNeededStormCount - 1 {
+		m.egressFrame(testFragNeeded(uint16(1450 - i)))
+	}
+	if stats := m.Stats(); stats.Advisories != 0 {
+		t.Fatalf("stats = %+v, want no advisory below the storm count", stats)
+	}
+
+	m.ingressFrame(testFragNeeded(1480))
+	e := waitForEvent(t, id, EventMTUAdvisory)
+	want := 1450 - (fragNeededStormCount - 2)
+	if e.Fields["reason"] != mtuReasonFragStorm || e.Fields["recommended_mtu"] != want {
+		t.Fatalf("advisory fields = %v, want frag_needed_storm recommending %d", e.Fields, want)
+	}
+
+	m.egressFrame(testFragNeeded(1400))
+	if stats := m.Stats(); stats.FragNeeded != fragNeededStormCount+1 || stats.Advisories != 1 {
+		t.Fatalf("stats = %+v, want further messages counted but not re-advised", stats)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+// notify.go — Minimal host→guest signaling over UDP.
+//
+// gvproxy_notify_guest sends one UDP datagram from the gateway IP to a
+// well-known port on the guest, wake-on-LAN style. An agent in the guest only
+// needs a UDP socket to receive host signals; no vsock device or extra
+// listener on the host is involved.
+
+import "C"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"unsafe"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+)
+
+// defaultNotifyPort is the guest port notifications go to when the config
+// does not set notify_port (9, the discard port wake-on-LAN uses).
+const defaultNotifyPort = 9
+
+// defaultNotifyMTU sizes the payload limit when the config has no MTU.
+const defaultNotifyMTU = 1500
+
+// guestNotifier sends notification datagrams from the gateway to the guest.
+type guestNotifier struct {
+	gateway    tcpip.Address
+	guest      tcpip.Address
+	port       uint16
+	maxPayload int
+}
+
+func newGuestNotifier(config GvproxyConfig) *guestNotifier {
+	port := config.NotifyPort
+	if port == 0 {
+		port = defaultNotifyPort
+	}
+	mtu := int(config.MTU)
+	if mtu == 0 {
+		mtu = defaultNotifyMTU
+	}
+	n := &guestNotifier{
+		port:       port,
+		maxPayload: mtu - header.IPv4MinimumSize - header.UDPMinimumSize,
+	}
+	if ip := net.ParseIP(config.GatewayIP).To4(); ip != nil {
+		n.gateway = tcpip.AddrFrom4Slice(ip)
+	}
+	if ip := net.ParseIP(config.GuestIP).To4(); ip != nil {
+		n.guest = tcpip.AddrFrom4Slice(ip)
+	}
+	return n
+}
+
+// send writes payload as a single unfragmented datagram to the guest.
+func (n *guestNotifier) send(vn *virtualnetwork.VirtualNetwork, payload []byte) error {
+	if vn == nil {
+		return errors.New("virtual network not ready")
+	}
+	if len(payload) > n.maxPayload {
+		return fmt.Errorf("notification payload is %d bytes, max %d", len(payload), n.maxPayload)
+	}
+	s, err := vnStack(vn)
+	if err != nil {
+		return err
+	}
+
+	conn, err := gonet.DialUDP(s,
+		&tcpip.FullAddress{NIC: 1, Addr: n.gateway},
+		&tcpip.FullAddress{NIC: 1, Addr: n.guest, Port: n.port},
+		ipv4.ProtocolNumber)
+	if err != nil {
+		return fmt.Errorf("open notify socket: %v", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write(payload)
+	return err
+}
+
+// gvproxy_notify_guest sends payload as one UDP datagram from the gateway to
+// the guest's notify port (config notify_port, default 9). Delivery is best
+// effort, like any UDP datagram; payloads must fit in one frame.
+//
+// Returns 0 on success, -1 if the instance does not exist, its network is not
+// ready yet, or the payload is too large.
+//
+//export gvproxy_notify_guest
+func gvproxy_notify_guest(id C.longlong, payload *C.char, length C.int) C.int {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok || length < 0 || (payload == nil && length > 0) {
+		return -1
+	}
+
+	instance.vnMu.RLock()
+	vn := instance.vn
+	instance.vnMu.RUnlock()
+
+	data := C.GoBytes(unsafe.Pointer(payload), length)
+	if err := instance.notifier.send(vn, data); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id)}).Warn("Failed to notify guest")
+		return -1
+	}
+	logrus.WithFields(logrus.Fields{"id": int64(id), "port": instance.notifier.port, "bytes": len(data)}).Debug("Sent guest notification")
+	return 0
+}
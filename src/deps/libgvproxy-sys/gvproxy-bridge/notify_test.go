@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+)
+
+func TestNewGuestNotifier_Defaults(t *testing.T) {
+	n := newGuestNotifier(GvproxyConfig{GatewayIP: "192.168.127.1", GuestIP: "192.168.127.2"})
+	if n.port != defaultNotifyPort {
+		t.Fatalf("expected default port %d, got %d", defaultNotifyPort, n.port)
+	}
+	if n.maxPayload != 1472 {
+		t.Fatalf("expected 1472-byte payload limit for a 1500 MTU, got %d", n.maxPayload)
+	}
+
+	n = newGuestNotifier(GvproxyConfig{MTU: 9000, NotifyPort: 7777})
+	if n.port != 7777 || n.maxPayload != 8972 {
+		t.Fatalf("unexpected notifier settings: port=%d max=%d", n.port, n.maxPayload)
+	}
+}
+
+func TestGuestNotifier_Send(t *testing.T) {
+	config := testGvproxyConfig()
+	vn, err := virtualnetwork.New(buildTapConfig(config, types.VfkitProtocol))
+	if err != nil {
+		t.Fatalf("virtualnetwork.New() failed: %v", err)
+	}
+	n := newGuestNotifier(config)
+
+	if err := n.send(nil, []byte("x")); err == nil {
+		t.Fatal("expected an error before the network is ready")
+	}
+	if err := n.send(vn, make([]byte, n.maxPayload+1)); err == nil {
+		t.Fatal("expected an error for an oversized payload")
+	}
+	if err := n.send(vn, []byte("wake")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
+
+func TestGvproxyNotifyGuest_UnknownInstance(t *testing.T) {
+	if rc := gvproxy_notify_guest(987654, nil, 0); rc != -1 {
+		t.Fatalf("expected -1 for unknown instance, got %d", rc)
+	}
+}
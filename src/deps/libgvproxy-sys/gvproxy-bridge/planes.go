@@ -0,0 +1,199 @@
+package main
+
+// planes.go — Keep management responsive under heavy forwarding load.
+//
+// Every goroutine in the bridge shares one Go scheduler, and Go has no
+// goroutine priorities. Under a flood of forwarded flows a stats export or
+// an expose request queued behind thousands of relays, so the control plane
+// slowed down exactly when it was needed. Two measures separate the planes:
+//
+//   - The data plane is bounded: with max_data_plane_flows set, at most that
+//     many TCP flows (guest outbound and published ports) are relayed at
+//     once per instance. A published-port connection waits for a slot, so
+//     its peer sees a slow handshake (listen backlog) rather than a reset.
+//     A guest SYN is refused (RST) instead: the netstack's forwarder holds
+//     only a few pending handshakes, and parking them would drop every
+//     later SYN, allowed destinations included.
+//   - Each instance's control plane has its own small worker set, each
+//     worker locked to a dedicated OS thread. Its stats exports and control
+//     socket requests run on it, so management work is capped, never shares
+//     a pool with relays, and a slow request on one instance does not hold
+//     up another's.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// controlPlaneWorkers is the size of an instance's control-plane worker
+// set.
+const controlPlaneWorkers = 2
+
+// controlPool is a fixed set of worker goroutines, each locked to its own OS
+// thread, started on first use. A nil *controlPool runs tasks on the
+// caller's goroutine.
+type controlPool struct {
+	size   int
+	once   sync.Once
+	tasks  chan func()
+	closed chan struct{}
+}
+
+func newControlPool(size int) *controlPool {
+	return &controlPool{size: size, tasks: make(chan func()), closed: make(chan struct{})}
+}
+
+func (p *controlPool) start() {
+	for i := 0; i < p.size; i++ {
+		go func() {
+			runtime.LockOSThread()
+			for {
+				select {
+				case task := <-p.tasks:
+					task()
+				case <-p.closed:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// run executes fn on a control-plane worker and waits for it. It returns
+// false without running fn once the pool is closed.
+func (p *controlPool) run(fn func()) bool {
+	if p == nil {
+		fn()
+		return true
+	}
+	select {
+	case <-p.closed:
+		return false
+	default:
+	}
+	p.once.Do(p.start)
+	done := make(chan struct{})
+	task := func() {
+		defer close(done)
+		fn()
+	}
+	select {
+	case p.tasks <- task:
+	case <-p.closed:
+		return false
+	}
+	<-done
+	return true
+}
+
+// close stops the workers once their current tasks are done.
+func (p *controlPool) close() {
+	if p != nil {
+		close(p.closed)
+	}
+}
+
+// handler serves every request of next on a control-plane worker, or
+// answers 503 once the pool is closed.
+func (p *controlPool) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !p.run(func() { next.ServeHTTP(w, req) }) {
+			http.Error(w, "instance is shutting down", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// flowLimiter bounds the data-plane flows of an instance. A nil
+// *flowLimiter admits every flow.
+type flowLimiter struct {
+	slots   chan struct{}
+	waited  atomic.Uint64
+	refused atomic.Uint64
+}
+
+// newFlowLimiter returns nil when max is 0 (unbounded).
+func newFlowLimiter(max int) *flowLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &flowLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire takes a flow slot, waiting for one if all are in use. It returns
+// false if ctx is done first.
+func (l *flowLimiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	l.waited.Add(1)
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// tryAcquire takes a flow slot if one is free, without waiting.
+func (l *flowLimiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		l.refused.Add(1)
+		return false
+	}
+}
+
+// release returns a slot taken by acquire or tryAcquire.
+func (l *flowLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// DataPlaneStats is the "DataPlane" section of gvproxy_get_stats.
+type DataPlaneStats struct {
+	MaxFlows     int    `json:"MaxFlows"`
+	ActiveFlows  int    `json:"ActiveFlows"`
+	WaitedFlows  uint64 `json:"WaitedFlows"`
+	RefusedFlows uint64 `json:"RefusedFlows"` // guest SYNs refused with every slot in use
+}
+
+// dataPlaneCollector publishes the flow limiter as the "DataPlane" section.
+type dataPlaneCollector struct{ l *flowLimiter }
+
+func (dataPlaneCollector) Name() string { return "DataPlane" }
+
+func (dataPlaneCollector) Description() string {
+	return "Data-plane flow bound (max_data_plane_flows): slots in use, flows that had to wait for one and guest flows refused for want of one."
+}
+
+func (c dataPlaneCollector) Collect() any {
+	return DataPlaneStats{
+		MaxFlows:     cap(c.l.slots),
+		ActiveFlows:  len(c.l.slots),
+		WaitedFlows:  c.l.waited.Load(),
+		RefusedFlows: c.l.refused.Load(),
+	}
+}
+
+func (c dataPlaneCollector) collectOpenMetrics(emit metricEmitter) {
+	stats := c.Collect().(DataPlaneStats)
+	emit(openMetricsPrefix+"data_plane_max_flows", "gauge", "Data-plane flow bound (max_data_plane_flows).", "", fmt.Sprint(stats.MaxFlows))
+	emit(openMetricsPrefix+"data_plane_active_flows", "gauge", "Data-plane flows holding a slot.", "", fmt.Sprint(stats.ActiveFlows))
+	emit(openMetricsPrefix+"data_plane_waited_flows", "counter", "Data-plane flows that waited for a slot.", "", fmt.Sprint(stats.WaitedFlows))
+	emit(openMetricsPrefix+"data_plane_refused_flows", "counter", "Guest flows refused with every data-plane slot in use.", "", fmt.Sprint(stats.RefusedFlows))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFlowLimiter_WaitsForSlot(t *testing.T) {
+	l := newFlowLimiter(1)
+	if !l.acquire(context.Background()) {
+		t.Fatal("expected a free slot")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if l.acquire(ctx) {
+		t.Fatal("expected the second flow to wait until ctx is done")
+	}
+
+	got := make(chan bool)
+	go func() { got <- l.acquire(context.Background()) }()
+	l.release()
+	if !<-got {
+		t.Fatal("expected the waiting flow to get the released slot")
+	}
+	if stats := (dataPlaneCollector{l}).Collect().(DataPlaneStats); stats.MaxFlows != 1 || stats.ActiveFlows != 1 || stats.WaitedFlows == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestFlowLimiter_TryAcquireRefuses(t *testing.T) {
+	l := newFlowLimiter(1)
+	if !l.tryAcquire() {
+		t.Fatal("expected a free slot")
+	}
+	if l.tryAcquire() {
+		t.Fatal("expected the second flow to be refused, not to wait")
+	}
+	l.release()
+	if !l.tryAcquire() {
+		t.Fatal("expected the released slot")
+	}
+	if stats := (dataPlaneCollector{l}).Collect().(DataPlaneStats); stats.RefusedFlows != 1 || stats.WaitedFlows != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestFlowLimiter_NilIsUnbounded(t *testing.T) {
+	var l *flowLimiter
+	if newFlowLimiter(0) != nil {
+		t.Fatal("expected max 0 to mean unbounded")
+	}
+	for i := 0; i < 3; i++ {
+		if !l.acquire(context.Background()) {
+			t.Fatal("a nil limiter must admit every flow")
+		}
+	}
+	l.release()
+}
+
+func TestPortForwarder_FlowBound(t *testing.T) {
+	f, err := NewPortForwarder(4700, "192.168.127.2", []PortMapping{{HostPort: 0, GuestPort: 80}}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	f.flows = newFlowLimiter(1)
+	dialer := &echoDialer{dialed: make(chan string, 2)}
+	f.Serve(context.Background(), dialer)
+	addr := f.forwards[0].listener.Addr().String()
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	<-dialer.dialed
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer second.Close()
+	select {
+	case <-dialer.dialed:
+		t.Fatal("the second flow must wait while the first holds the only slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.Close()
+	select {
+	case <-dialer.dialed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second flow to be relayed once the first ended")
+	}
+}
+
+func TestControlPool_Run(t *testing.T) {
+	p := newControlPool(1)
+	var ran bool
+	if !p.run(func() { ran = true }) || !ran {
+		t.Fatal("expected run to wait for the task")
+	}
+
+	// A pool busy with a hung request does not hold up another instance's.
+	release := make(chan struct{})
+	go p.run(func() { <-release })
+	other := newControlPool(1)
+	done := make(chan struct{})
+	go func() {
+		other.run(func() {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("another instance's pool waited for a busy one")
+	}
+	close(release)
+	other.close()
+
+	p.close()
+	if p.run(func() { t.Error("ran a task on a closed pool") }) {
+		t.Fatal("expected run to fail once the pool is closed")
+	}
+	rec := httptest.NewRecorder()
+	p.handler(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/stats", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503 from a closed pool", rec.Code)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+// port_conflict.go — Notice when a published port is lost to another process.
+//
+// A forward's host listener can stop unexpectedly (the accept loop fails, or
+// the socket is torn down underneath the bridge). Until now the forward just
+// went dark. Instead, the bridge closes the broken listener and keeps trying
+// to rebind the port: while another process holds it, a port_conflict event
+// names the port and, where the platform allows, the holder's PID; once the
+// port is free again the forward resumes and port_reclaimed fires.
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventPortConflict fires when a published port the bridge lost is held by
+// another process. Fields: host_port, guest_port, error, and pid and process
+// when the holder could be identified.
+const EventPortConflict = "port_conflict"
+
+// EventPortReclaimed fires when a lost published port is bound again and
+// forwarding resumes. Fields: host_port, guest_port.
+const EventPortReclaimed = "port_reclaimed"
+
+// portRebindInterval is how often a lost forward retries its bind.
+var portRebindInterval = 5 * time.Second
+
+// reclaimPort closes fwd's broken listener and rebinds the port until it
+// succeeds, reporting who holds it meanwhile. It returns false if it gave up
+// because ctx is done, the forwarder was closed or the port unpublished.
+func (f *PortForwarder) reclaimPort(ctx context.Context, fwd *portForward) bool {
+	f.mu.Lock()
+	fwd.listener.Close()
+	f.mu.Unlock()
+
+	ticker := time.NewTicker(portRebindInterval)
+	defer ticker.Stop()
+	conflicted := false
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		f.mu.Lock()
+		closed := f.closed || fwd.removed
+		f.mu.Unlock()
+		if closed {
+			return false
+		}
+
+		l, err := listenForward(fwd.mapping)
+		if err != nil {
+			if !conflicted && errors.Is(err, syscall.EADDRINUSE) {
+				conflicted = true
+				f.reportConflict(fwd, err)
+			}
+			continue
+		}
+
+		f.mu.Lock()
+		if f.closed || fwd.removed || ctx.Err() != nil {
+			f.mu.Unlock()
+			l.Close()
+			return false
+		}
+		fwd.listener = l
+		f.mu.Unlock()
+
+		logrus.WithFields(logrus.Fields{"id": f.instanceID, "host": fwd.hostAddr}).Info("Published port reclaimed; forwarding resumed")
+		emitEvent(f.instanceID, EventPortReclaimed, withVsock(withHostSocket(map[string]any{
+			"host_port":  fwd.mapping.HostPort,
+			"guest_port": fwd.mapping.GuestPort,
+		}, fwd.mapping), fwd.mapping))
+		return true
+	}
+}
+
+func (f *PortForwarder) reportConflict(fwd *portForward, err error) {
+	fields := withVsock(withHostSocket(map[string]any{
+		"host_port":  fwd.mapping.HostPort,
+		"guest_port": fwd.mapping.GuestPort,
+		"error":      err.Error(),
+	}, fwd.mapping), fwd.mapping)
+	logFields := logrus.Fields{"id": f.instanceID, "host": fwd.hostAddr}
+	// Only the holder of a TCP port can be looked up.
+	if fwd.mapping.HostSocket == "" {
+		if pid, process := portOwner(fwd.mapping.HostPort); pid > 0 {
+			fields["pid"] = pid
+			fields["process"] = process
+			logFields["pid"] = pid
+			logFields["process"] = process
+		}
+	}
+	logrus.WithFields(logFields).Warn("Published port is held by another process; stop it or change the port mapping")
+	emitEvent(f.instanceID, EventPortConflict, fields)
+}
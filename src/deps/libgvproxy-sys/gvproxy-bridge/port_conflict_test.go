@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func waitForEvent(t *testing.T, id int64, eventType string) Event {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, e := range recentEventsFor(id) {
+			if e.Type == eventType {
+				return e
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no %s event for instance %d", eventType, id)
+	return Event{}
+}
+
+func TestPortForwarder_ReclaimsLostPort(t *testing.T) {
+	defer func(interval time.Duration) { portRebindInterval = interval }(portRebindInterval)
+	portRebindInterval = 20 * time.Millisecond
+
+	probe, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := uint16(probe.Addr().(*net.TCPAddr).Port)
+	probe.Close()
+
+	id := int64(400000 + int(port)) // unique per run
+	f, err := NewPortForwarder(id, "192.168.127.2", []PortMapping{{HostPort: port, GuestPort: 80}}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Serve(ctx, &echoDialer{})
+
+	// The listener dies underneath the bridge and another process takes the port.
+	f.mu.Lock()
+	f.forwards[0].listener.Close()
+	f.mu.Unlock()
+	thief, err := net.Listen("tcp", f.forwards[0].hostAddr)
+	if err != nil {
+		t.Fatalf("take over port: %v", err)
+	}
+
+	conflict := waitForEvent(t, id, EventPortConflict)
+	if conflict.Fields["host_port"] != port {
+		t.Fatalf("unexpected conflict event: %+v", conflict)
+	}
+	if runtime.GOOS == "linux" && conflict.Fields["pid"] != os.Getpid() {
+		t.Fatalf("expected the holder's pid %d, got %+v", os.Getpid(), conflict.Fields)
+	}
+
+	thief.Close()
+	waitForEvent(t, id, EventPortReclaimed)
+
+	conn, err := net.Dial("tcp", probe.Addr().String())
+	if err != nil {
+		t.Fatalf("dial reclaimed forward: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping")) //nolint:errcheck
+	buf := make([]byte, 4)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("expected the forward to relay again, got %q (%v)", buf, err)
+	}
+}
+
+func TestPortForwarder_CloseDoesNotReclaim(t *testing.T) {
+	const id = 4401
+	f, err := NewPortForwarder(id, "192.168.127.2", []PortMapping{{HostPort: 0, GuestPort: 80}}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	f.Serve(context.Background(), &echoDialer{})
+	f.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	for _, e := range recentEventsFor(id) {
+		if e.Type == EventPortConflict || e.Type == EventPortReclaimed {
+			t.Fatalf("closing the forwarder must not trigger reclaiming, got %+v", e)
+		}
+	}
+}
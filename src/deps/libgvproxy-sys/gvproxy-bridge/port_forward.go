@@ -0,0 +1,469 @@
+package main
+
+// port_forward.go — Host listeners for published ports (PortMappings).
+//
+// gvproxy's built-in Forwards hide the accept loop inside tcpproxy, so the
+// bridge never learns who connected. We bind the host listeners ourselves and
+// dial the guest through the netstack, which lets us report each inbound
+// connection (peer address + target forward) as an event and a counter.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/tcpproxy"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// guestDialer opens TCP connections into the virtual network.
+// *virtualnetwork.VirtualNetwork satisfies it.
+type guestDialer interface {
+	DialContextTCP(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// portForward is a single bound host port relayed to a guest address.
+type portForward struct {
+	mapping   PortMapping
+	hostAddr  string
+	guestAddr string // retargeted under PortForwarder.mu (forward_retarget.go)
+	listener  net.Listener
+	removed   bool // unpublished under PortForwarder.mu (forward_expose.go)
+
+	inboundConnections atomic.Uint64
+	errors             forwardErrors
+	histograms         forwardHistograms // forward_histograms.go
+}
+
+// Reasons a forward's guest dial fails, as reported in ForwardErrorStats.
+const (
+	forwardErrGuestRefused     = "guest_refused"
+	forwardErrGuestUnreachable = "guest_unreachable"
+	forwardErrTimeout          = "timeout"
+	forwardErrOther            = "other"
+)
+
+// forwardErrors counts a forward's failed guest dials by reason.
+type forwardErrors struct {
+	guestRefused     atomic.Uint64
+	guestUnreachable atomic.Uint64
+	timeout          atomic.Uint64
+	other            atomic.Uint64
+}
+
+func (e *forwardErrors) count(reason string) {
+	switch reason {
+	case forwardErrGuestRefused:
+		e.guestRefused.Add(1)
+	case forwardErrGuestUnreachable:
+		e.guestUnreachable.Add(1)
+	case forwardErrTimeout:
+		e.timeout.Add(1)
+	default:
+		e.other.Add(1)
+	}
+}
+
+// guestDialErrorReason classifies a netstack dial error. gonet flattens
+// tcpip errors into their message, so the match is on the tcpip.Err
+// strings.
+func guestDialErrorReason(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return forwardErrTimeout
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "connection was refused"), strings.Contains(msg, "connection refused"):
+		return forwardErrGuestRefused
+	case strings.Contains(msg, "no route to host"), strings.Contains(msg, "network is unreachable"):
+		return forwardErrGuestUnreachable
+	case strings.Contains(msg, "operation timed out"):
+		return forwardErrTimeout
+	}
+	return forwardErrOther
+}
+
+// PortForwarder owns the host listeners for an instance's published ports.
+type PortForwarder struct {
+	instanceID int64
+
+	mu       sync.Mutex
+	forwards []*portForward // listeners are swapped under mu (port_conflict.go)
+	closed   bool
+
+	// flows bounds concurrent relays (planes.go); nil means unbounded.
+	flows *flowLimiter
+
+	// State for forwards added at run time (forward_expose.go), under mu.
+	guestIP           string // default target, retargeted with the lease
+	latencyBuckets    []float64
+	throughputBuckets []float64
+	serveCtx          context.Context // set by Serve
+	dialer            guestDialer
+
+	// gateway_port forwards (forward_vsock.go) listen on gatewayIP in the
+	// netstack and dial host vsock through vsockDialer.
+	gatewayIP   string
+	vsockDialer guestDialer
+
+	// goroutines runs accept loops and relays (the instance's, see
+	// teardown.go); relays is the connection table by host side, under mu
+	// (forward_connections.go).
+	goroutines  *tracker
+	relays      map[net.Conn]*forwardRelay
+	nextRelayID uint64
+	aborted     bool
+}
+
+// NewPortForwarder binds a host listener for every mapping, adopting an
+// imported listener (see listener_handoff.go) instead when one is given for
+// the mapping's host port. Binding happens synchronously so conflicts
+// (EADDRINUSE) surface from gvproxy_create instead of failing later inside a
+// goroutine. The forwarder takes ownership of every imported FD: unmatched
+// ones are closed, and on error already-bound listeners are closed too.
+func NewPortForwarder(instanceID int64, guestIP string, mappings []PortMapping, imported []ListenerFD) (*PortForwarder, error) {
+	importedByAddr := make(map[string]ListenerFD, len(imported))
+	for _, l := range imported {
+		addr := PortMapping{HostIP: l.HostIP, HostPort: l.HostPort, HostSocket: l.HostSocket}.hostAddr()
+		if prev, dup := importedByAddr[addr]; dup {
+			closeListenerFDs([]ListenerFD{prev})
+		}
+		importedByAddr[addr] = l
+	}
+	defer func() {
+		for addr, l := range importedByAddr {
+			logrus.WithFields(logrus.Fields{"host": addr, "fd": l.FD}).Warn("Imported listener has no matching port mapping; closing")
+			closeListenerFDs([]ListenerFD{l})
+		}
+	}()
+
+	f := &PortForwarder{
+		instanceID:        instanceID,
+		guestIP:           guestIP,
+		latencyBuckets:    defaultLatencyBucketsMs,
+		throughputBuckets: defaultThroughputBuckets,
+		vsockDialer:       hostVsockDialer{},
+	}
+	for _, pm := range mappings {
+		var l net.Listener
+		var err error
+		if imp, ok := importedByAddr[pm.hostAddr()]; ok {
+			delete(importedByAddr, pm.hostAddr())
+			l, err = importListener(imp.FD, pm)
+		} else {
+			l, err = listenForward(pm)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.forwards = append(f.forwards, f.newForward(pm, l))
+	}
+	return f, nil
+}
+
+// newForward builds the forward relaying l to pm's guest address. Callers
+// hold mu or own f exclusively.
+func (f *PortForwarder) newForward(pm PortMapping, l net.Listener) *portForward {
+	hostAddr := pm.hostAddr()
+	target := f.guestIP
+	if pm.GuestIP != "" {
+		target = pm.GuestIP
+	}
+	guestAddr := fmt.Sprintf("%s:%d", target, pm.GuestPort)
+	if pm.GatewayPort != 0 {
+		guestAddr = pm.HostVsock.String()
+	}
+	logrus.WithFields(logrus.Fields{"host": hostAddr, "guest": guestAddr}).Info("Added TCP port forward")
+	return &portForward{
+		mapping:    pm,
+		hostAddr:   hostAddr,
+		guestAddr:  guestAddr,
+		listener:   l,
+		histograms: newForwardHistograms(f.latencyBuckets, f.throughputBuckets),
+	}
+}
+
+// Serve starts an accept loop per forward. Connections accepted before Serve
+// is called wait in the listen backlog.
+func (f *PortForwarder) Serve(ctx context.Context, dialer guestDialer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.serveCtx, f.dialer = ctx, dialer
+	for _, fwd := range f.forwards {
+		if err := f.bindGateway(fwd, dialer); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": f.instanceID, "host": fwd.hostAddr}).Error("Failed to bind gateway port forward")
+		}
+		f.goroutines.spawn(func() { f.acceptLoop(ctx, fwd, dialer) })
+	}
+}
+
+// acceptLoop serves fwd until the forwarder is closed or ctx is done. If the
+// listener fails before that, the port is reclaimed (port_conflict.go) and
+// serving resumes on the new listener.
+func (f *PortForwarder) acceptLoop(ctx context.Context, fwd *portForward, dialer guestDialer) {
+	for {
+		f.mu.Lock()
+		l := fwd.listener
+		f.mu.Unlock()
+
+		err := f.acceptConns(ctx, l, fwd, dialer)
+
+		f.mu.Lock()
+		closed := f.closed || fwd.removed
+		f.mu.Unlock()
+		if closed || ctx.Err() != nil {
+			return
+		}
+		if fwd.mapping.GatewayPort != 0 {
+			// Nothing else can take a netstack port.
+			logrus.WithFields(logrus.Fields{"error": err, "host": fwd.hostAddr}).Error("Gateway port forward accept failed")
+			return
+		}
+		logrus.WithFields(logrus.Fields{"error": err, "host": fwd.hostAddr}).Error("Port forward accept failed; reclaiming the port")
+		if !f.reclaimPort(ctx, fwd) {
+			return
+		}
+	}
+}
+
+// acceptConns accepts and relays connections on l until Accept fails. With a
+// flow bound, it only accepts once a slot is free, leaving connections in the
+// listen backlog meanwhile.
+func (f *PortForwarder) acceptConns(ctx context.Context, l net.Listener, fwd *portForward, dialer guestDialer) error {
+	for {
+		if !f.flows.acquire(ctx) {
+			return ctx.Err()
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			f.flows.release()
+			return err
+		}
+
+		f.mu.Lock()
+		guestAddr := fwd.guestAddr
+		aborted := f.aborted
+		var r *forwardRelay
+		if !aborted {
+			if f.relays == nil {
+				f.relays = make(map[net.Conn]*forwardRelay)
+			}
+			f.nextRelayID++
+			r = newForwardRelay(f.nextRelayID, fwd, conn, guestAddr)
+			f.relays[conn] = r
+		}
+		f.mu.Unlock()
+		if aborted {
+			conn.Close()
+			f.flows.release()
+			continue
+		}
+
+		fwd.inboundConnections.Add(1)
+		emitEvent(f.instanceID, EventInboundConnection, withVsock(withHostSocket(map[string]any{
+			"peer":       conn.RemoteAddr().String(),
+			"host_port":  fwd.mapping.HostPort,
+			"guest_port": fwd.mapping.GuestPort,
+			"guest":      guestAddr,
+		}, fwd.mapping), fwd.mapping))
+
+		dialer := dialer
+		if fwd.mapping.GatewayPort != 0 {
+			dialer = f.vsockDialer
+		}
+		f.goroutines.spawn(func() {
+			defer f.flows.release()
+			defer f.forgetRelay(conn)
+			f.relay(r, dialer)
+		})
+	}
+}
+
+func (f *PortForwarder) relay(r *forwardRelay, dialer guestDialer) {
+	fwd, conn, guestAddr := r.fwd, r.host, r.guest
+	defer r.end()
+	var connected time.Time
+	remote := tcpproxy.DialProxy{
+		DialContext: func(dialCtx context.Context, _, _ string) (net.Conn, error) {
+			start := time.Now()
+			guest, err := dialer.DialContextTCP(dialCtx, guestAddr)
+			if err != nil {
+				return nil, err
+			}
+			connected = time.Now()
+			fwd.histograms.latencyMs.observe(float64(connected.Sub(start)) / float64(time.Millisecond))
+			// Only the guest side is wrapped: the host conn stays a
+			// *net.TCPConn for keepalives and splice.
+			counted := countingConn{Conn: pausableConn{Conn: guest, relay: r}, bytes: &r.bytes}
+			if fwd.mapping.Type == forwardTypeHTTP {
+				return newHTTPAccessConn(counted, f.instanceID, fwd.mapping, conn.RemoteAddr().String()), nil
+			}
+			return counted, nil
+		},
+		OnDialError: func(src net.Conn, dstDialErr error) {
+			reason := guestDialErrorReason(dstDialErr)
+			fwd.errors.count(reason)
+			logrus.WithFields(logrus.Fields{
+				"id":        f.instanceID,
+				"host_port": fwd.mapping.HostPort,
+				"guest":     guestAddr,
+				"reason":    reason,
+				"error":     dstDialErr,
+			}).Debug("Port forward guest dial failed")
+			src.Close()
+		},
+	}
+	remote.HandleConn(conn)
+	if !connected.IsZero() {
+		fwd.histograms.observeThroughput(r.bytes.Load(), connected)
+	}
+}
+
+// Close closes every host listener. In-flight relays finish on their own.
+func (f *PortForwarder) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for _, fwd := range f.forwards {
+		fwd.listener.Close()
+	}
+}
+
+// Abort closes every host listener and the host side of in-flight relays,
+// which then end.
+func (f *PortForwarder) Abort() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed, f.aborted = true, true
+	for _, fwd := range f.forwards {
+		fwd.listener.Close()
+	}
+	for conn := range f.relays {
+		conn.Close()
+	}
+}
+
+func (f *PortForwarder) forgetRelay(conn net.Conn) {
+	f.mu.Lock()
+	delete(f.relays, conn)
+	f.mu.Unlock()
+}
+
+// ForwardStats is the per-mapping section of gvproxy_get_stats.
+type ForwardStats struct {
+	HostIP             string            `json:"HostIP,omitempty"`     // set when bound to one address
+	HostSocket         string            `json:"HostSocket,omitempty"` // set when published on a Unix socket
+	HostVsock          string            `json:"HostVsock,omitempty"`  // set for vsock forwards
+	GatewayPort        uint16            `json:"GatewayPort,omitempty"`
+	HostPort           uint16            `json:"HostPort"`
+	GuestPort          uint16            `json:"GuestPort"`
+	InboundConnections uint64            `json:"InboundConnections"`
+	Errors             ForwardErrorStats `json:"Errors"`
+	// LatencyMs is the guest dial latency in milliseconds and
+	// ThroughputBytesPerSec the per-connection relay throughput; see
+	// forward_histograms.go.
+	LatencyMs             HistogramStats `json:"LatencyMs"`
+	ThroughputBytesPerSec HistogramStats `json:"ThroughputBytesPerSec"`
+}
+
+// ForwardErrorStats counts a mapping's connections that failed to reach the
+// guest, by reason.
+type ForwardErrorStats struct {
+	GuestRefused     uint64 `json:"GuestRefused"`
+	GuestUnreachable uint64 `json:"GuestUnreachable"`
+	Timeout          uint64 `json:"Timeout"`
+	Other            uint64 `json:"Other"`
+}
+
+// Stats returns per-forward counters sorted by host port, address and
+// socket.
+func (f *PortForwarder) Stats() []ForwardStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]ForwardStats, 0, len(f.forwards))
+	for _, fwd := range f.forwards {
+		var hostVsock string
+		if fwd.mapping.HostVsock != nil {
+			hostVsock = fwd.mapping.HostVsock.String()
+		}
+		out = append(out, ForwardStats{
+			HostIP:             fwd.mapping.HostIP,
+			HostSocket:         fwd.mapping.HostSocket,
+			HostVsock:          hostVsock,
+			GatewayPort:        fwd.mapping.GatewayPort,
+			HostPort:           fwd.mapping.HostPort,
+			GuestPort:          fwd.mapping.GuestPort,
+			InboundConnections: fwd.inboundConnections.Load(),
+			Errors: ForwardErrorStats{
+				GuestRefused:     fwd.errors.guestRefused.Load(),
+				GuestUnreachable: fwd.errors.guestUnreachable.Load(),
+				Timeout:          fwd.errors.timeout.Load(),
+				Other:            fwd.errors.other.Load(),
+			},
+			LatencyMs:             fwd.histograms.latencyMs.snapshot(),
+			ThroughputBytesPerSec: fwd.histograms.throughput.snapshot(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].HostPort != out[j].HostPort {
+			return out[i].HostPort < out[j].HostPort
+		}
+		if out[i].HostIP != out[j].HostIP {
+			return out[i].HostIP < out[j].HostIP
+		}
+		if out[i].HostSocket != out[j].HostSocket {
+			return out[i].HostSocket < out[j].HostSocket
+		}
+		if out[i].HostVsock != out[j].HostVsock {
+			return out[i].HostVsock < out[j].HostVsock
+		}
+		return out[i].GatewayPort < out[j].GatewayPort
+	})
+	return out
+}
+
+// forwardsCollector publishes per-forward counters as the "Forwards" section.
+type forwardsCollector struct{ f *PortForwarder }
+
+func (forwardsCollector) Name() string { return "Forwards" }
+
+func (forwardsCollector) Description() string {
+	return "Published ports with their inbound connection counts, guest dial failures by reason, and dial latency and throughput histograms."
+}
+
+func (c forwardsCollector) Collect() any { return c.f.Stats() }
+
+func (c forwardsCollector) collectOpenMetrics(emit metricEmitter) {
+	for _, fwd := range c.f.Stats() {
+		emit(openMetricsPrefix+"forward_inbound_connections", "counter",
+			"Connections accepted on a published host port.",
+			forwardLabels(fwd),
+			fmt.Sprint(fwd.InboundConnections))
+		for reason, n := range map[string]uint64{
+			forwardErrGuestRefused:     fwd.Errors.GuestRefused,
+			forwardErrGuestUnreachable: fwd.Errors.GuestUnreachable,
+			forwardErrTimeout:          fwd.Errors.Timeout,
+			forwardErrOther:            fwd.Errors.Other,
+		} {
+			emit(openMetricsPrefix+"forward_errors", "counter",
+				"Connections on a published host port that failed to reach the guest.",
+				fmt.Sprintf(`%s,reason="%s"`, forwardLabels(fwd), reason),
+				fmt.Sprint(n))
+		}
+		labels := forwardLabels(fwd)
+		collectHistogramOpenMetrics(emit, openMetricsPrefix+"forward_dial_latency_seconds",
+			"Time to reach the guest for a connection on a published host port.",
+			labels, fwd.LatencyMs, 1e-3)
+		collectHistogramOpenMetrics(emit, openMetricsPrefix+"forward_throughput_bytes_per_second",
+			"Bytes relayed per second over a connection on a published host port.",
+			labels, fwd.ThroughputBytesPerSec, 1)
+	}
+}
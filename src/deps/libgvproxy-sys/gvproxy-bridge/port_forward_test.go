@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// echoDialer stands in for the netstack: every dial returns a pipe whose far
+// end echoes what it reads.
+type echoDialer struct {
+	dialed chan string
+}
+
+func (d *echoDialer) DialContextTCP(_ context.Context, addr string) (net.Conn, error) {
+	if d.dialed != nil {
+		d.dialed <- addr
+	}
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		io.Copy(server, server) //nolint:errcheck
+	}()
+	return client, nil
+}
+
+func TestPortForwarder_RelaysAndCountsInbound(t *testing.T) {
+	f, err := NewPortForwarder(1, "192.168.127.2", []PortMapping{{HostPort: 0, GuestPort: 80}}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dialer := &echoDialer{dialed: make(chan string, 1)}
+	f.Serve(ctx, dialer)
+
+	conn, err := net.Dial("tcp", f.forwards[0].listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial forward: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("expected echo, got %q", buf)
+	}
+
+	if got := <-dialer.dialed; got != "192.168.127.2:80" {
+		t.Fatalf("expected guest dial to 192.168.127.2:80, got %q", got)
+	}
+	stats := f.Stats()
+	if len(stats) != 1 || stats[0].InboundConnections != 1 {
+		t.Fatalf("expected one inbound connection, got %+v", stats)
+	}
+}
+
+func TestPortForwarder_BindConflictFails(t *testing.T) {
+	busy, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer busy.Close()
+	port := uint16(busy.Addr().(*net.TCPAddr).Port)
+
+	_, err = NewPortForwarder(1, "192.168.127.2", []PortMapping{{HostPort: port, GuestPort: 80}}, nil)
+	if err == nil {
+		t.Fatal("expected bind conflict error")
+	}
+}
+
+// failingDialer fails every guest dial with err.
+type failingDialer struct{ err error }
+
+func (d failingDialer) DialContextTCP(context.Context, string) (net.Conn, error) {
+	return nil, d.err
+}
+
+func TestPortForwarder_CountsGuestDialErrors(t *testing.T) {
+	f, err := NewPortForwarder(1, "192.168.127.2", []PortMapping{{HostPort: 0, GuestPort: 80}}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Serve(ctx, failingDialer{&net.OpError{Op: "connect", Net: "tcp", Err: errors.New("connection was refused")}})
+
+	conn, err := net.Dial("tcp", f.forwards[0].listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial forward: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the host client to be disconnected")
+	}
+
+	stats := f.Stats()
+	if stats[0].Errors != (ForwardErrorStats{GuestRefused: 1}) {
+		t.Fatalf("expected one guest_refused error, got %+v", stats[0].Errors)
+	}
+}
+
+func TestGuestDialErrorReason(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{errors.New("connection was refused"), forwardErrGuestRefused},
+		{&net.OpError{Op: "connect", Err: errors.New("no route to host")}, forwardErrGuestUnreachable},
+		{&net.OpError{Op: "connect", Err: errors.New("network is unreachable")}, forwardErrGuestUnreachable},
+		{&net.OpError{Op: "connect", Err: errors.New("operation timed out")}, forwardErrTimeout},
+		{context.DeadlineExceeded, forwardErrTimeout},
+		{errors.New("port is in use"), forwardErrOther},
+	} {
+		if got := guestDialErrorReason(tc.err); got != tc.want {
+			t.Errorf("guestDialErrorReason(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
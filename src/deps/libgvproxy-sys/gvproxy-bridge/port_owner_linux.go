@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tcpStateListen is the LISTEN state in /proc/net/tcp.
+const tcpStateListen = "0A"
+
+// portOwner returns the PID and command name of a process with a TCP socket
+// listening on port, or 0 if none is visible (other users' processes are
+// not, without privileges).
+func portOwner(port uint16) (int, string) {
+	inodes := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		listeningInodes(table, port, inodes)
+	}
+	if len(inodes) == 0 {
+		return 0, ""
+	}
+
+	fdDirs, _ := filepath.Glob("/proc/[0-9]*/fd")
+	for _, dir := range fdDirs {
+		fds, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(dir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			if inodes[strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]")] {
+				pidDir := filepath.Dir(dir)
+				pid, _ := strconv.Atoi(filepath.Base(pidDir))
+				comm, _ := os.ReadFile(filepath.Join(pidDir, "comm"))
+				return pid, strings.TrimSpace(string(comm))
+			}
+		}
+	}
+	return 0, ""
+}
+
+// listeningInodes adds the inodes of sockets in a /proc/net/tcp{,6} table
+// that listen on port.
+func listeningInodes(table string, port uint16, inodes map[string]bool) {
+	f, err := os.Open(table)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	suffix := fmt.Sprintf(":%04X", port)
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != tcpStateListen || !strings.HasSuffix(fields[1], suffix) {
+			continue
+		}
+		inodes[fields[9]] = true
+	}
+}
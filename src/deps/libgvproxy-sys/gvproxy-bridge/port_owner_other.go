@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// portOwner cannot identify socket owners on this platform without
+// privileged APIs; conflicts are reported without a PID.
+func portOwner(uint16) (int, string) {
+	return 0, ""
+}
@@ -0,0 +1,120 @@
+package main
+
+// prewarm.go — Restore-time warm-up.
+//
+// When a snapshot-restored VM resumes, its first connections race the
+// bridge's cold paths: unbound host forwards and upstream names the host
+// resolver has never seen. gvproxy_prewarm runs those paths before the VM is
+// resumed so the guest's first packets hit warm state.
+
+import "C"
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// prewarmResolveTimeout bounds the whole DNS warm-up; a slow upstream must not
+// hold up the restore it is meant to speed up.
+const prewarmResolveTimeout = 5 * time.Second
+
+// warmHosts returns the upstream hostnames an instance is configured to reach:
+// allow_net hostnames (wildcards reduced to their base domain) and secret
+// hosts. IPs, CIDRs and duplicates are dropped.
+func warmHosts(config GvproxyConfig) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	add := func(rule string) {
+		host := strings.TrimSpace(rule)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimPrefix(host, "*.")
+		if host == "" || net.ParseIP(host) != nil || seen[host] {
+			return
+		}
+		if _, _, err := net.ParseCIDR(host); err == nil {
+			return
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	for _, rule := range config.AllowNet {
+		add(rule)
+	}
+	for _, secret := range config.Secrets {
+		for _, host := range secret.Hosts {
+			add(host)
+		}
+	}
+	return hosts
+}
+
+// resolveWarmHosts looks every host up through the system resolver in
+// parallel, priming its cache for the guest's DNS queries (which gvproxy
+// forwards to the same resolver). Returns how many resolved.
+func resolveWarmHosts(ctx context.Context, hosts []string) int {
+	resolver := &net.Resolver{PreferGo: false}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		resolved int
+	)
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			if _, err := resolver.LookupIPAddr(ctx, host); err != nil {
+				logrus.WithFields(logrus.Fields{"hostname": host, "error": err}).Debug("prewarm: DNS resolution failed")
+				return
+			}
+			mu.Lock()
+			resolved++
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+	return resolved
+}
+
+// gvproxy_prewarm readies an instance for a resuming guest. Host forward
+// listeners are already bound by gvproxy_create (inbound clients queue in the
+// listen backlog until the guest answers), so the remaining cold path is
+// upstream DNS: prewarm pre-resolves the instance's upstream hostnames. Call
+// it after gvproxy_create and before resuming the VM; it blocks for at most
+// a few seconds.
+//
+// Returns 0 on success, -1 if the instance does not exist.
+//
+//export gvproxy_prewarm
+func gvproxy_prewarm(id C.longlong) C.int {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok {
+		return -1
+	}
+
+	start := time.Now()
+	forwards := 0
+	if instance.forwarder != nil {
+		forwards = len(instance.forwarder.Stats())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), prewarmResolveTimeout)
+	defer cancel()
+	resolved := resolveWarmHosts(ctx, instance.warmHosts)
+
+	logrus.WithFields(logrus.Fields{
+		"id":         int64(id),
+		"forwards":   forwards,
+		"hosts":      len(instance.warmHosts),
+		"resolved":   resolved,
+		"elapsed_ms": durationMs(time.Since(start)),
+	}).Info("gvproxy instance prewarmed")
+	return 0
+}
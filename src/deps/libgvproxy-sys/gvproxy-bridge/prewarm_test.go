@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestWarmHosts_CollectsAllowNetAndSecretHosts(t *testing.T) {
+	config := GvproxyConfig{
+		AllowNet: []string{
+			"api.openai.com",
+			"*.anthropic.com",
+			"registry.npmjs.org:443",
+			"10.0.0.0/8",
+			"1.2.3.4",
+			" ",
+		},
+		Secrets: []SecretConfig{
+			{Name: "openai", Hosts: []string{"api.openai.com", "files.openai.com"}},
+		},
+	}
+
+	got := warmHosts(config)
+	want := []string{"api.openai.com", "anthropic.com", "registry.npmjs.org", "files.openai.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("warmHosts = %v, want %v", got, want)
+	}
+}
+
+func TestResolveWarmHosts_CountsResolvedHosts(t *testing.T) {
+	got := resolveWarmHosts(context.Background(), []string{"localhost", "does-not-exist.invalid"})
+	if got != 1 {
+		t.Fatalf("expected 1 resolved host, got %d", got)
+	}
+}
+
+func TestGvproxyPrewarm_UnknownInstance(t *testing.T) {
+	if rc := gvproxy_prewarm(987654); rc != -1 {
+		t.Fatalf("expected -1 for unknown instance, got %d", rc)
+	}
+}
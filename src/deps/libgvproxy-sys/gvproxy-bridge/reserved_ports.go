@@ -0,0 +1,96 @@
+package main
+
+// reserved_ports.go — Host ports that guest forwards may never shadow.
+//
+// A forward binds 0.0.0.0:<host_port>; pointing one at a port a critical host
+// service uses (sshd, most importantly) either fails at bind time or, if the
+// service happens to be down, takes its place until the box goes away.
+// Reserved ports are refused up front by gvproxy_create and by the control
+// socket's expose API.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// defaultReservedHostPorts applies when the config does not set
+// reserved_host_ports. 22 keeps the host's SSH daemon reachable.
+var defaultReservedHostPorts = []uint16{22}
+
+// ErrReservedHostPort is returned when a forward targets a reserved host port.
+var ErrReservedHostPort = errors.New("host port is reserved")
+
+// reservedPorts is the set of host ports an instance refuses to forward.
+type reservedPorts map[uint16]bool
+
+// newReservedPorts builds the reserved set. A nil list selects the defaults;
+// an explicit empty list reserves nothing.
+func newReservedPorts(configured []uint16) reservedPorts {
+	if configured == nil {
+		configured = defaultReservedHostPorts
+	}
+	r := make(reservedPorts, len(configured))
+	for _, port := range configured {
+		r[port] = true
+	}
+	return r
+}
+
+// check returns ErrReservedHostPort (wrapped with the port) if port is reserved.
+func (r reservedPorts) check(port uint16) error {
+	if r[port] {
+		return fmt.Errorf("cannot forward host port %d: %w (see reserved_host_ports)", port, ErrReservedHostPort)
+	}
+	return nil
+}
+
+// checkMappings rejects the first mapping that targets a reserved port.
+func (r reservedPorts) checkMappings(mappings []PortMapping) error {
+	for _, pm := range mappings {
+		if err := r.check(pm.HostPort); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// guardExpose wraps a ServicesMux so /services/forwarder/expose requests for
+// reserved TCP/UDP host ports are refused before reaching upstream.
+func (r reservedPorts) guardExpose(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/services/forwarder/expose" || req.Method != http.MethodPost {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		var expose types.ExposeRequest
+		if json.Unmarshal(body, &expose) == nil && expose.Protocol != types.UNIX && expose.Protocol != types.NPIPE {
+			if _, portStr, err := net.SplitHostPort(expose.Local); err == nil {
+				if port, err := strconv.ParseUint(portStr, 10, 16); err == nil {
+					if err := r.check(uint16(port)); err != nil {
+						logrus.WithFields(logrus.Fields{"local": expose.Local}).Warn("Refused expose of reserved host port")
+						http.Error(w, err.Error(), http.StatusForbidden)
+						return
+					}
+				}
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
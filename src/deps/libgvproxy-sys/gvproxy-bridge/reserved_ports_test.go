@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewReservedPorts_DefaultsAndExplicitEmpty(t *testing.T) {
+	assertTrue(t, errors.Is(newReservedPorts(nil).check(22), ErrReservedHostPort), "SSH is reserved by default")
+	assertTrue(t, newReservedPorts([]uint16{}).check(22) == nil, "explicit empty list reserves nothing")
+
+	custom := newReservedPorts([]uint16{5432})
+	assertTrue(t, custom.check(22) == nil, "custom list replaces the defaults")
+	assertTrue(t, errors.Is(custom.check(5432), ErrReservedHostPort), "custom port is reserved")
+}
+
+func TestReservedHostPorts_SurviveHandoff(t *testing.T) {
+	roundTrip := func(ports []uint16) reservedPorts {
+		t.Helper()
+		config := testGvproxyConfig()
+		config.ReservedHostPorts = ports
+		data, err := json.Marshal(handoffInstance{ID: 1, Config: handoffConfig(config)})
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		var inst handoffInstance
+		if err := json.Unmarshal(data, &inst); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		return newReservedPorts(inst.Config.ReservedHostPorts)
+	}
+
+	assertTrue(t, errors.Is(roundTrip(nil).check(22), ErrReservedHostPort), "unset keeps the SSH default")
+	assertTrue(t, roundTrip([]uint16{}).check(22) == nil, "explicit empty list still reserves nothing")
+
+	custom := roundTrip([]uint16{5432})
+	assertTrue(t, custom.check(22) == nil, "custom list still replaces the defaults")
+	assertTrue(t, errors.Is(custom.check(5432), ErrReservedHostPort), "custom port is still reserved")
+}
+
+func TestReservedPorts_CheckMappings(t *testing.T) {
+	r := newReservedPorts(nil)
+	assertTrue(t, r.checkMappings([]PortMapping{{HostPort: 8080, GuestPort: 80}}) == nil, "unreserved mapping allowed")
+	err := r.checkMappings([]PortMapping{{HostPort: 8080, GuestPort: 80}, {HostPort: 22, GuestPort: 22}})
+	assertTrue(t, errors.Is(err, ErrReservedHostPort), "reserved mapping refused")
+}
+
+func TestReservedPorts_GuardExpose(t *testing.T) {
+	var reached []string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reached = append(reached, req.URL.Path)
+	})
+	h := newReservedPorts(nil).guardExpose(upstream)
+
+	serve := func(path, body string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := serve("/services/forwarder/expose", `{"local":":22","remote":"192.168.127.2:22"}`); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for reserved port, got %d", code)
+	}
+	if code := serve("/services/forwarder/expose", `{"local":"127.0.0.1:2222","remote":"192.168.127.2:22"}`); code != http.StatusOK {
+		t.Fatalf("expected unreserved expose to pass through, got %d", code)
+	}
+	if code := serve("/services/forwarder/expose", `{"local":"/tmp/ssh.sock","remote":"192.168.127.2:22","protocol":"unix"}`); code != http.StatusOK {
+		t.Fatalf("expected unix expose to pass through, got %d", code)
+	}
+	if len(reached) != 2 {
+		t.Fatalf("expected 2 requests to reach upstream, got %v", reached)
+	}
+}
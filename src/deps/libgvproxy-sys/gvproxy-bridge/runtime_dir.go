@@ -0,0 +1,66 @@
+package main
+
+// runtime_dir.go — Keep an instance's sockets in a directory of its own.
+//
+// Every socket path used to be spelled out in full by the caller. With
+// runtime_dir set, the bridge places them itself:
+//
+//   - socket_path may be left out; the VM socket is then created as
+//     gvproxy-<pid>-<id>.sock in runtime_dir. The process ID keeps
+//     concurrent boxlite processes, whose instance ids both start at 1,
+//     from colliding in a shared directory.
+//   - relative socket_path, control_socket_path and
+//     connect_proxy_socket_path are taken relative to runtime_dir;
+//     abstract ones ("@name", abstract_socket.go) are left alone.
+//
+// runtime_dir is created (mode 0700) if missing, so a multi-tenant host can
+// give each box a private directory. "auto" picks the first usable
+// directory the way gvproxy_suggest_socket_dir does: $XDG_RUNTIME_DIR,
+// /run/user/<uid>, then the temp dir.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+// runtimeDirAuto selects the runtime directory from socketDirCandidates.
+const runtimeDirAuto = "auto"
+
+// resolveRuntimeDir rewrites config's socket paths under its runtime_dir
+// (see runtime_dir.go), creating the directory unless this is a dry run.
+func resolveRuntimeDir(config *GvproxyConfig, id int64) error {
+	dir := config.RuntimeDir
+	if dir == "" {
+		return nil
+	}
+	if dir == runtimeDirAuto {
+		if dir = suggestSocketDir(); dir == "" {
+			return errors.New("runtime_dir \"auto\": no usable directory found")
+		}
+	}
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("runtime_dir %q must be an absolute path", dir)
+	}
+
+	needsLinkSocket := config.VsockListen == nil && config.LinkFD == nil && config.Protocol != string(types.StdioProtocol)
+	if config.SocketPath == "" && needsLinkSocket {
+		config.SocketPath = fmt.Sprintf("gvproxy-%d-%d.sock", os.Getpid(), id)
+	}
+	for _, path := range []*string{&config.SocketPath, &config.ControlSocketPath, &config.ConnectProxySocketPath} {
+		if *path != "" && !isNamedPipe(*path) && !isAbstractSocket(*path) && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+
+	if config.DryRun {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("runtime_dir: %w", err)
+	}
+	return nil
+}
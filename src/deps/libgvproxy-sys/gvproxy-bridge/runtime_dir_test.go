@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveRuntimeDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "box-1")
+	config := testGvproxyConfig()
+	config.RuntimeDir = dir
+	config.SocketPath = ""
+	config.ControlSocketPath = "control.sock"
+	config.ConnectProxySocketPath = "/elsewhere/connect.sock"
+	if err := resolveRuntimeDir(&config, 7); err != nil {
+		t.Fatalf("resolveRuntimeDir: %v", err)
+	}
+	if want := filepath.Join(dir, fmt.Sprintf("gvproxy-%d-7.sock", os.Getpid())); config.SocketPath != want {
+		t.Errorf("socket_path = %q, want %q", config.SocketPath, want)
+	}
+	if want := filepath.Join(dir, "control.sock"); config.ControlSocketPath != want {
+		t.Errorf("control_socket_path = %q, want %q", config.ControlSocketPath, want)
+	}
+	if config.ConnectProxySocketPath != "/elsewhere/connect.sock" {
+		t.Errorf("absolute path rewritten to %q", config.ConnectProxySocketPath)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("runtime_dir not created private: %v, %v", info, err)
+	}
+
+	config = testGvproxyConfig()
+	config.RuntimeDir = "relative/dir"
+	if err := resolveRuntimeDir(&config, 7); err == nil {
+		t.Fatal("a relative runtime_dir must be rejected")
+	}
+
+	config = testGvproxyConfig()
+	config.RuntimeDir = dir
+	config.SocketPath = ""
+	config.Protocol = "stdio"
+	if err := resolveRuntimeDir(&config, 7); err != nil || config.SocketPath != "" {
+		t.Fatalf("stdio link got socket_path %q, %v", config.SocketPath, err)
+	}
+}
+
+func TestStartInstance_RuntimeDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run")
+	config := testGvproxyConfig()
+	config.SocketPath = ""
+	config.RuntimeDir = dir
+	config.Protocol = "qemu"
+	const id = 5918
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	path := filepath.Join(dir, fmt.Sprintf("gvproxy-%d-%d.sock", os.Getpid(), id))
+	vm, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial VM socket in runtime_dir: %v", err)
+	}
+	defer vm.Close()
+	resolveGatewayQemu(t, vm, config)
+}
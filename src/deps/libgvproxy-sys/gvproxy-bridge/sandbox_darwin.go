@@ -0,0 +1,21 @@
+package main
+
+import "os"
+
+// maxSocketPathLen is the longest Unix socket path bind accepts
+// (sizeof(sun_path) - 1).
+const maxSocketPathLen = 103
+
+// sandboxPrivateDirs detects the macOS App Sandbox. HOME and the temp dir
+// then point into the app's container, which processes outside the sandbox
+// (a hypervisor that is not a child of this app) cannot reach.
+func sandboxPrivateDirs() (string, []string) {
+	if os.Getenv("APP_SANDBOX_CONTAINER_ID") == "" {
+		return "", nil
+	}
+	var dirs []string
+	if home := os.Getenv("HOME"); home != "" {
+		dirs = append(dirs, home)
+	}
+	return "macOS App Sandbox", append(dirs, os.TempDir())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxSocketPathLen is the longest Unix socket path bind accepts
+// (sizeof(sun_path) - 1).
+const maxSocketPathLen = 107
+
+// sandboxPrivateDirs detects systemd PrivateTmp: /tmp and /var/tmp are then
+// bind mounts of a per-service directory, visible in mountinfo as a root
+// under systemd-private-*. Processes outside the service (a hypervisor
+// started by another unit) see different directories at those paths.
+func sandboxPrivateDirs() (string, []string) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", nil
+	}
+	defer f.Close()
+
+	dirs := privateTmpMounts(f)
+	if len(dirs) == 0 {
+		return "", nil
+	}
+	return "systemd PrivateTmp", dirs
+}
+
+// privateTmpMounts returns the temp dirs a mountinfo table shows as
+// PrivateTmp bind mounts.
+func privateTmpMounts(r io.Reader) []string {
+	var dirs []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		// 36 35 98:0 /systemd-private-…-XYZ/tmp /tmp rw,relatime - ext4 …
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		root, mountPoint := fields[3], fields[4]
+		if (mountPoint == "/tmp" || mountPoint == "/var/tmp") && strings.Contains(root, "systemd-private-") {
+			dirs = append(dirs, mountPoint)
+		}
+	}
+	return dirs
+}
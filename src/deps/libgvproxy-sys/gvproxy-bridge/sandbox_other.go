@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+// maxSocketPathLen is the longest Unix socket path bind accepts on most
+// other Unixes (sizeof(sun_path) - 1).
+const maxSocketPathLen = 103
+
+// sandboxPrivateDirs detects no sandbox on this platform.
+func sandboxPrivateDirs() (string, []string) {
+	return "", nil
+}
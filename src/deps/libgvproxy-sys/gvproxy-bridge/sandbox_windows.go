@@ -0,0 +1,10 @@
+package main
+
+// maxSocketPathLen is the longest AF_UNIX socket path bind accepts
+// (sizeof(sun_path) - 1). Named pipe paths are not bound by it.
+const maxSocketPathLen = 107
+
+// sandboxPrivateDirs detects no sandbox on this platform.
+func sandboxPrivateDirs() (string, []string) {
+	return "", nil
+}
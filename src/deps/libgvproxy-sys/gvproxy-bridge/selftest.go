@@ -0,0 +1,335 @@
+package main
+
+// selftest.go — Check the environment before launching VMs.
+//
+// gvproxy_selftest runs the bridge's moving parts in-process, without a VM,
+// and reports each step: a Unix socket in the temp dir, the netstack with a
+// loopback peer attached over the QEMU protocol (an ARP exchange with the
+// gateway), a DNS query to a local zone over that link, and a published port
+// relaying a round trip. Sandboxing, seccomp filters or temp dir permissions
+// that would break a real instance fail here with a named step instead of a
+// VM that never gets network.
+
+import "C"
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	"github.com/miekg/dns"
+	logrus "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// selftestTimeout bounds each self-test step.
+const selftestTimeout = 3 * time.Second
+
+// selftestConfig is the throwaway network the self-test brings up.
+var selftestConfig = GvproxyConfig{
+	Subnet:     "192.168.127.0/24",
+	GatewayIP:  "192.168.127.1",
+	GatewayMac: "5a:94:ef:e4:0c:dd",
+	GuestIP:    "192.168.127.2",
+	GuestMac:   "5a:94:ef:e4:0c:ee",
+	MTU:        1500,
+	DNSZones: []DNSZone{{
+		Name:    "selftest.internal.",
+		Records: []DNSRecord{{Name: "probe", IP: "192.168.127.254"}},
+	}},
+}
+
+// SelftestReport is the document returned by gvproxy_selftest.
+type SelftestReport struct {
+	OK     bool            `json:"ok"`
+	Checks []SelftestCheck `json:"checks"`
+}
+
+// SelftestCheck is one self-test step.
+type SelftestCheck struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// runSelftest runs every step, continuing past failures where the next step
+// does not depend on them.
+func runSelftest() SelftestReport {
+	report := SelftestReport{OK: true}
+	run := func(name string, step func() error) bool {
+		start := time.Now()
+		err := step()
+		check := SelftestCheck{Name: name, OK: err == nil, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			check.Error = err.Error()
+			report.OK = false
+		}
+		report.Checks = append(report.Checks, check)
+		return err == nil
+	}
+
+	run("unix_socket", selftestUnixSocket)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var vn *virtualnetwork.VirtualNetwork
+	var peer *selftestPeer
+	if run("netstack", func() error {
+		var err error
+		vn, peer, err = selftestNetstack(ctx)
+		return err
+	}) {
+		defer peer.Close()
+		run("dns", func() error { return selftestDNS(ctx, vn, peer) })
+	}
+
+	run("forward", selftestForward)
+	return report
+}
+
+func selftestUnixSocket() error {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("gvproxy-selftest-%d.sock", os.Getpid()))
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.DialTimeout("unix", path, selftestTimeout)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// selftestPeer is the VM side of a QEMU-protocol link.
+type selftestPeer struct {
+	net.Conn
+	mac net.HardwareAddr
+	ip  net.IP
+}
+
+func (p *selftestPeer) writeFrame(frame []byte) error {
+	buf := make([]byte, 4+len(frame))
+	binary.BigEndian.PutUint32(buf, uint32(len(frame)))
+	copy(buf[4:], frame)
+	_, err := p.Write(buf)
+	return err
+}
+
+// readFrame returns the next frame accepted by match, skipping others.
+func (p *selftestPeer) readFrame(match func([]byte) bool) ([]byte, error) {
+	p.SetReadDeadline(time.Now().Add(selftestTimeout)) //nolint:errcheck
+	prefix := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(p, prefix); err != nil {
+			return nil, err
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(prefix))
+		if _, err := io.ReadFull(p, frame); err != nil {
+			return nil, err
+		}
+		if match(frame) {
+			return frame, nil
+		}
+	}
+}
+
+// selftestNetstack brings up a netstack, attaches a peer, and resolves the
+// gateway's MAC over ARP.
+func selftestNetstack(ctx context.Context) (*virtualnetwork.VirtualNetwork, *selftestPeer, error) {
+	config := selftestConfig
+	vn, err := virtualnetwork.New(buildTapConfig(config, types.QemuProtocol))
+	if err != nil {
+		return nil, nil, err
+	}
+	vmSide, hostSide := net.Pipe()
+	go vn.AcceptQemu(ctx, hostSide) //nolint:errcheck
+	mac, _ := net.ParseMAC(config.GuestMac)
+	peer := &selftestPeer{Conn: vmSide, mac: mac, ip: net.ParseIP(config.GuestIP).To4()}
+
+	frame := make([]byte, header.EthernetMinimumSize+header.ARPSize)
+	header.Ethernet(frame).Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress(peer.mac),
+		DstAddr: header.EthernetBroadcastAddress,
+		Type:    header.ARPProtocolNumber,
+	})
+	arp := header.ARP(frame[header.EthernetMinimumSize:])
+	arp.SetIPv4OverEthernet()
+	arp.SetOp(header.ARPRequest)
+	copy(arp.HardwareAddressSender(), peer.mac)
+	copy(arp.ProtocolAddressSender(), peer.ip)
+	copy(arp.ProtocolAddressTarget(), net.ParseIP(config.GatewayIP).To4())
+	if err := peer.writeFrame(frame); err != nil {
+		peer.Close()
+		return nil, nil, fmt.Errorf("send ARP request: %w", err)
+	}
+
+	gatewayMAC, _ := net.ParseMAC(config.GatewayMac)
+	_, err = peer.readFrame(func(f []byte) bool {
+		if len(f) < header.EthernetMinimumSize+header.ARPSize || header.Ethernet(f).Type() != header.ARPProtocolNumber {
+			return false
+		}
+		reply := header.ARP(f[header.EthernetMinimumSize:])
+		return reply.Op() == header.ARPReply && bytes.Equal(reply.HardwareAddressSender(), gatewayMAC)
+	})
+	if err != nil {
+		peer.Close()
+		return nil, nil, fmt.Errorf("no ARP reply from the gateway: %w", err)
+	}
+	return vn, peer, nil
+}
+
+// selftestDNS queries a local zone record from the peer through the
+// gateway's DNS server.
+func selftestDNS(ctx context.Context, vn *virtualnetwork.VirtualNetwork, peer *selftestPeer) error {
+	config := selftestConfig
+	gwDNS, err := newGatewayDNS(config)
+	if err != nil {
+		return err
+	}
+	if err := gwDNS.takeOver(ctx, vn, config.GatewayIP); err != nil {
+		return err
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("probe.selftest.internal.", dns.TypeA)
+	payload, err := q.Pack()
+	if err != nil {
+		return err
+	}
+	gatewayMAC, _ := net.ParseMAC(config.GatewayMac)
+	gatewayIP := net.ParseIP(config.GatewayIP).To4()
+	const srcPort = 40053
+
+	frame := make([]byte, header.EthernetMinimumSize+header.IPv4MinimumSize+header.UDPMinimumSize+len(payload))
+	header.Ethernet(frame).Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress(peer.mac),
+		DstAddr: tcpip.LinkAddress(gatewayMAC),
+		Type:    header.IPv4ProtocolNumber,
+	})
+	ip := header.IPv4(frame[header.EthernetMinimumSize:])
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(ip)),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4Slice(peer.ip),
+		DstAddr:     tcpip.AddrFrom4Slice(gatewayIP),
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	udp := header.UDP(ip.Payload())
+	udp.Encode(&header.UDPFields{
+		SrcPort: srcPort,
+		DstPort: dnsPort,
+		Length:  uint16(len(udp)),
+		// Checksum 0: none, valid for UDP over IPv4.
+	})
+	copy(udp.Payload(), payload)
+	if err := peer.writeFrame(frame); err != nil {
+		return fmt.Errorf("send DNS query: %w", err)
+	}
+
+	reply, err := peer.readFrame(func(f []byte) bool {
+		u := frameUDP(f)
+		return u != nil && u.SourcePort() == dnsPort && u.DestinationPort() == srcPort
+	})
+	if err != nil {
+		return fmt.Errorf("no DNS reply from the gateway: %w", err)
+	}
+	var m dns.Msg
+	if err := m.Unpack(frameUDP(reply).Payload()); err != nil {
+		return fmt.Errorf("malformed DNS reply: %w", err)
+	}
+	if len(m.Answer) != 1 {
+		return fmt.Errorf("expected one answer for the local zone record, got rcode %d with %d answers", m.Rcode, len(m.Answer))
+	}
+	if a, ok := m.Answer[0].(*dns.A); !ok || !a.A.Equal(net.ParseIP("192.168.127.254")) {
+		return fmt.Errorf("unexpected answer %v", m.Answer[0])
+	}
+	return nil
+}
+
+// selftestEchoDialer answers every guest dial with an in-process echo.
+type selftestEchoDialer struct{}
+
+func (selftestEchoDialer) DialContextTCP(context.Context, string) (net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		io.Copy(server, server) //nolint:errcheck
+	}()
+	return client, nil
+}
+
+// selftestForward publishes an ephemeral host port and relays a round trip
+// through it.
+func selftestForward() error {
+	f, err := NewPortForwarder(0, selftestConfig.GuestIP, []PortMapping{{HostPort: 0, GuestPort: 7}}, nil)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Serve(ctx, selftestEchoDialer{})
+
+	port := f.forwards[0].listener.Addr().(*net.TCPAddr).Port
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), selftestTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(selftestTimeout)) //nolint:errcheck
+	if _, err := conn.Write([]byte("selftest")); err != nil {
+		return err
+	}
+	buf := make([]byte, len("selftest"))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if string(buf) != "selftest" {
+		return errors.New("round trip returned different bytes")
+	}
+	return nil
+}
+
+// gvproxy_selftest exercises the bridge in-process, without a VM, and returns
+// a JSON report: {"ok": bool, "checks": [{"name", "ok", "error",
+// "duration_ms"}]} with the steps unix_socket, netstack, dns and forward. Run
+// it before launching VMs to surface sandboxing, seccomp or temp dir
+// permission problems. Takes a few seconds at most.
+//
+// The string must be freed with gvproxy_free_string.
+//
+//export gvproxy_selftest
+func gvproxy_selftest() *C.char {
+	report := runSelftest()
+	out, err := json.Marshal(report)
+	if err != nil {
+		return nil
+	}
+	fields := logrus.Fields{"ok": report.OK}
+	for _, check := range report.Checks {
+		if !check.OK {
+			fields[check.Name] = check.Error
+		}
+	}
+	logrus.WithFields(fields).Info("Self-test finished")
+	return returnCString(string(out))
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestRunSelftest(t *testing.T) {
+	report := runSelftest()
+	names := make([]string, 0, len(report.Checks))
+	for _, check := range report.Checks {
+		names = append(names, check.Name)
+		if !check.OK {
+			t.Errorf("self-test step %s failed: %s", check.Name, check.Error)
+		}
+	}
+	if !report.OK || len(names) != 4 {
+		t.Fatalf("expected four passing steps, got %v (ok=%v)", names, report.OK)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+// service_auth.go — Embedder approval of guest connections to gateway services.
+//
+// TCP services the gateway hosts for the guest (the test services and the
+// embedder's own services from gvproxy_listen_guest) used to accept every
+// connection reaching them. With a callback registered through
+// gvproxy_set_service_auth_callback, each connection is first described to
+// the embedder (service, port, and the source guest's IP, port and leased
+// MAC) and only served if the callback approves it, so access policy lives
+// in boxlite instead of in bridge config. Without a callback every
+// connection is served, as before.
+
+/*
+#include <stdlib.h>
+
+typedef int (*service_auth_callback_fn)(long long id, const char* request_json);
+
+static int call_rust_service_auth_callback(void* callback, long long id, const char* request_json) {
+	return ((service_auth_callback_fn)callback)(id, request_json);
+}
+*/
+import "C"
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"unsafe"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventServiceDenied fires when the embedder denies a guest connection to a
+// gateway service. Fields: service, port, source_ip, source_mac.
+const EventServiceDenied = "service_denied"
+
+// ServiceAuthRequest is the JSON document passed to the service auth
+// callback.
+type ServiceAuthRequest struct {
+	Service    string `json:"service"`
+	Port       uint16 `json:"port"`
+	SourceIP   string `json:"source_ip"`
+	SourcePort uint16 `json:"source_port"`
+	SourceMAC  string `json:"source_mac,omitempty"` // from the DHCP lease table
+}
+
+var (
+	rustServiceAuthCallback unsafe.Pointer
+	serviceAuthCallbackMu   sync.RWMutex
+)
+
+// gvproxy_set_service_auth_callback registers the process-wide service auth
+// callback. It receives the instance id and a JSON-encoded
+// ServiceAuthRequest (only valid for the duration of the call) and returns
+// non-zero to serve the connection, 0 to close it. It is called before each
+// connection is served, so it should decide quickly. Pass NULL to serve every
+// connection.
+//
+//export gvproxy_set_service_auth_callback
+func gvproxy_set_service_auth_callback(callback unsafe.Pointer) {
+	serviceAuthCallbackMu.Lock()
+	rustServiceAuthCallback = callback
+	serviceAuthCallbackMu.Unlock()
+}
+
+// authorizeService asks the embedder whether to serve req. Swapped out in
+// tests.
+var authorizeService = func(instanceID int64, req ServiceAuthRequest) bool {
+	serviceAuthCallbackMu.RLock()
+	callback := rustServiceAuthCallback
+	serviceAuthCallbackMu.RUnlock()
+	if callback == nil {
+		return true
+	}
+	// After gvproxy_destroy_sync (teardown.go) the embedder is not asked.
+	leave, ok := enterCallback(instanceID)
+	if !ok {
+		return false
+	}
+	defer leave()
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return false
+	}
+	cPayload := C.CString(string(payload))
+	defer C.free(unsafe.Pointer(cPayload))
+	return C.call_rust_service_auth_callback(callback, C.longlong(instanceID), cPayload) != 0
+}
+
+// serviceGuard vets guest connections to an instance's gateway services. A
+// nil *serviceGuard serves every connection.
+type serviceGuard struct {
+	instanceID int64
+	leases     func() map[string]string // IP → MAC, for the source guest's identity
+}
+
+func newServiceGuard(instanceID int64, leases func() map[string]string) *serviceGuard {
+	return &serviceGuard{instanceID: instanceID, leases: leases}
+}
+
+// listener returns l with every accepted connection vetted for service.
+func (g *serviceGuard) listener(service string, l net.Listener) net.Listener {
+	if g == nil {
+		return l
+	}
+	return &guardedListener{Listener: l, guard: g, service: service}
+}
+
+// allow reports whether conn may be served, closing it if not.
+func (g *serviceGuard) allow(service string, conn net.Conn) bool {
+	req := ServiceAuthRequest{Service: service}
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		req.Port = uint16(addr.Port)
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		req.SourceIP = addr.IP.String()
+		req.SourcePort = uint16(addr.Port)
+		if g.leases != nil {
+			req.SourceMAC = g.leases()[req.SourceIP]
+		}
+	}
+	if authorizeService(g.instanceID, req) {
+		return true
+	}
+	conn.Close()
+	logrus.WithFields(logrus.Fields{
+		"id":         g.instanceID,
+		"service":    service,
+		"source_ip":  req.SourceIP,
+		"source_mac": req.SourceMAC,
+	}).Info("Gateway service connection denied by embedder")
+	emitEvent(g.instanceID, EventServiceDenied, map[string]any{
+		"service":    service,
+		"port":       req.Port,
+		"source_ip":  req.SourceIP,
+		"source_mac": req.SourceMAC,
+	})
+	return false
+}
+
+// guardedListener only returns connections its guard allows.
+type guardedListener struct {
+	net.Listener
+	guard   *serviceGuard
+	service string
+}
+
+func (l *guardedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.guard.allow(l.service, conn) {
+			return conn, nil
+		}
+	}
+}
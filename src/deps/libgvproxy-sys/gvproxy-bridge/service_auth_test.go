@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServiceGuard_VetsConnections(t *testing.T) {
+	defer func(orig func(int64, ServiceAuthRequest) bool) { authorizeService = orig }(authorizeService)
+	requests := make(chan ServiceAuthRequest, 2)
+	var allow atomic.Bool
+	authorizeService = func(id int64, req ServiceAuthRequest) bool {
+		requests <- req
+		return allow.Load()
+	}
+
+	const id = 5000
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer inner.Close()
+	guard := newServiceGuard(id, func() map[string]string {
+		return map[string]string{"127.0.0.1": "5a:94:ef:e4:0c:ee"}
+	})
+	l := guard.listener("echo", inner)
+	go acceptTestService(l, "echo", serveEcho)
+
+	// Denied: closed without being served.
+	denied, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer denied.Close()
+	req := <-requests
+	if req.Service != "echo" || req.SourceIP != "127.0.0.1" || req.SourceMAC != "5a:94:ef:e4:0c:ee" ||
+		req.Port != uint16(inner.Addr().(*net.TCPAddr).Port) {
+		t.Fatalf("unexpected auth request: %+v", req)
+	}
+	denied.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	if _, err := denied.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the denied connection to be closed, got %v", err)
+	}
+	if e := waitForEvent(t, id, EventServiceDenied); e.Fields["service"] != "echo" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+
+	// Allowed: served.
+	allow.Store(true)
+	conn, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	<-requests
+	conn.Write([]byte("ping")) //nolint:errcheck
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("expected the allowed connection to be served, got %q (%v)", buf, err)
+	}
+}
+
+func TestServiceGuard_NilServesEverything(t *testing.T) {
+	var guard *serviceGuard
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer inner.Close()
+	if guard.listener("echo", inner) != inner {
+		t.Fatal("a nil guard must return the listener unchanged")
+	}
+}
+
+func TestAuthorizeService_NoCallbackAllows(t *testing.T) {
+	if !authorizeService(1, ServiceAuthRequest{Service: "echo"}) {
+		t.Fatal("expected connections to be served without a callback")
+	}
+}
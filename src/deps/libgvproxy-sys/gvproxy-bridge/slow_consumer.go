@@ -0,0 +1,223 @@
+package main
+
+// slow_consumer.go — Notice the VMM not reading the hypervisor socket.
+//
+// Frames to the guest are written to the hypervisor socket; when the VMM
+// stops reading it, the send queue fills. A stream write then blocks and a
+// datagram write fails with ENOBUFS/EAGAIN, and the switch behind it stalls
+// or drops. Without this, that looks exactly like a broken upstream network:
+// packets silently vanish.
+//
+// Each write is timed. One blocked for link_stall_threshold_ms, or failing
+// because the queue is full, starts a stall: EventLinkSendStalled fires once,
+// and EventLinkSendResumed once a write goes through again. The stalled
+// event says whether the guest was still sending frames meanwhile: if it
+// was, the guest is alive and only its receive path (the VMM's reader) is
+// stuck; if not, the guest or VMM as a whole is hung.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// EventLinkSendStalled fires when the VMM stops draining the hypervisor
+// socket. Fields: reason ("blocked" or "queue_full"), guest_sending (whether
+// frames arrived from the guest within the stall threshold), stalls (count
+// for this instance).
+const EventLinkSendStalled = "link_send_stalled"
+
+// EventLinkSendResumed fires when a write to the hypervisor socket goes
+// through after a stall. Fields: stalled_ms.
+const EventLinkSendResumed = "link_send_resumed"
+
+// defaultLinkStallThreshold is how long a write may block before it counts
+// as a stall, unless link_stall_threshold_ms says otherwise.
+const defaultLinkStallThreshold = 2 * time.Second
+
+// sendStalls tracks writes to the hypervisor socket. It is a frameObserver
+// for the guest's frames; linkConn reports its writes through begin and end.
+// A nil *sendStalls ignores everything.
+type sendStalls struct {
+	instanceID int64
+	threshold  time.Duration
+
+	lastIngress atomic.Int64 // unix nanos of the last frame from the guest
+
+	mu           sync.Mutex
+	writing      time.Time // start of the write in progress; zero if none
+	stalledSince time.Time // zero unless stalled
+	stalls       uint64
+	stalledTotal time.Duration
+	queueFull    uint64
+}
+
+func newSendStalls(instanceID int64, thresholdMs int) *sendStalls {
+	threshold := defaultLinkStallThreshold
+	if thresholdMs > 0 {
+		threshold = time.Duration(thresholdMs) * time.Millisecond
+	}
+	return &sendStalls{instanceID: instanceID, threshold: threshold}
+}
+
+func (s *sendStalls) ingressFrame([]byte) {
+	s.lastIngress.Store(time.Now().UnixNano())
+}
+
+func (s *sendStalls) egressFrame([]byte) {}
+
+// begin records the start of a write.
+func (s *sendStalls) begin() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.writing = time.Now()
+	s.mu.Unlock()
+}
+
+// blockedFor returns how long the write in progress has been blocked; 0 if
+// none. A nil *sendStalls has no writes.
+func (s *sendStalls) blockedFor(now time.Time) time.Duration {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writing.IsZero() {
+		return 0
+	}
+	return now.Sub(s.writing)
+}
+
+// end records the result of the write started by begin.
+func (s *sendStalls) end(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	since := s.writing
+	s.writing = time.Time{}
+	if err != nil {
+		var stalled map[string]any
+		if isSendQueueFull(err) {
+			s.queueFull++
+			stalled = s.stall("queue_full", since)
+		}
+		s.mu.Unlock()
+		if stalled != nil {
+			emitEvent(s.instanceID, EventLinkSendStalled, stalled)
+		}
+		return
+	}
+	if s.stalledSince.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	stalled := time.Since(s.stalledSince)
+	s.stalledTotal += stalled
+	s.stalledSince = time.Time{}
+	s.mu.Unlock()
+	emitEvent(s.instanceID, EventLinkSendResumed, map[string]any{
+		"stalled_ms": stalled.Milliseconds(),
+	})
+}
+
+// stall starts a stall unless one is in progress, returning the fields of
+// its EventLinkSendStalled (nil if none). Called with mu held.
+func (s *sendStalls) stall(reason string, writeStart time.Time) map[string]any {
+	if !s.stalledSince.IsZero() {
+		return nil
+	}
+	s.stalledSince = writeStart
+	s.stalls++
+	return map[string]any{
+		"reason":        reason,
+		"guest_sending": s.lastIngress.Load() > time.Now().Add(-s.threshold).UnixNano(),
+		"stalls":        s.stalls,
+	}
+}
+
+// check starts a stall if the write in progress has been blocked for the
+// threshold.
+func (s *sendStalls) check(now time.Time) {
+	s.mu.Lock()
+	var stalled map[string]any
+	if !s.writing.IsZero() && now.Sub(s.writing) >= s.threshold {
+		stalled = s.stall("blocked", s.writing)
+	}
+	s.mu.Unlock()
+	if stalled != nil {
+		emitEvent(s.instanceID, EventLinkSendStalled, stalled)
+	}
+}
+
+// watch checks for blocked writes until ctx is done.
+func (s *sendStalls) watch(ctx context.Context) {
+	ticker := time.NewTicker(s.threshold / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.check(now)
+		}
+	}
+}
+
+// isSendQueueFull reports whether a write failed because the peer's receive
+// queue is full (datagram sockets).
+func isSendQueueFull(err error) bool {
+	return errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN)
+}
+
+// LinkSendStats is the "LinkSend" stats section.
+type LinkSendStats struct {
+	Stalled        bool   `json:"Stalled"`
+	Stalls         uint64 `json:"Stalls"`
+	StalledMs      int64  `json:"StalledMs"` // total, including a stall in progress
+	QueueFullDrops uint64 `json:"QueueFullDrops"`
+}
+
+func (s *sendStalls) Stats() LinkSendStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := s.stalledTotal
+	if !s.stalledSince.IsZero() {
+		total += time.Since(s.stalledSince)
+	}
+	return LinkSendStats{
+		Stalled:        !s.stalledSince.IsZero(),
+		Stalls:         s.stalls,
+		StalledMs:      total.Milliseconds(),
+		QueueFullDrops: s.queueFull,
+	}
+}
+
+// linkSendCollector publishes stall counters as the "LinkSend" section.
+type linkSendCollector struct{ s *sendStalls }
+
+func (linkSendCollector) Name() string { return "LinkSend" }
+
+func (linkSendCollector) Description() string {
+	return "Writes to the hypervisor socket the VMM did not drain: stalls, time stalled and frames dropped on a full send queue."
+}
+
+func (c linkSendCollector) Collect() any { return c.s.Stats() }
+
+func (c linkSendCollector) collectOpenMetrics(emit metricEmitter) {
+	stats := c.s.Stats()
+	stalled := 0
+	if stats.Stalled {
+		stalled = 1
+	}
+	emit(openMetricsPrefix+"link_send_stalled", "gauge", "Whether the VMM is currently not draining the hypervisor socket.", "", fmt.Sprint(stalled))
+	emit(openMetricsPrefix+"link_send_stalls", "counter", "Times the VMM stopped draining the hypervisor socket.", "", fmt.Sprint(stats.Stalls))
+	emit(openMetricsPrefix+"link_send_stalled_milliseconds", "counter", "Time the hypervisor socket spent stalled.", "", fmt.Sprint(stats.StalledMs))
+	emit(openMetricsPrefix+"link_send_queue_full_drops", "counter", "Frames to the guest dropped on a full hypervisor socket send queue.", "", fmt.Sprint(stats.QueueFullDrops))
+}
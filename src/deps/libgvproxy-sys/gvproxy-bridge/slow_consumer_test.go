@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+func TestSendStalls_BlockedWriteStallsAndResumes(t *testing.T) {
+	const id = 5300
+	sends := newSendStalls(id, 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sends.watch(ctx)
+
+	// net.Pipe is unbuffered: a write blocks until the VMM side reads it.
+	gw, vmm := net.Pipe()
+	defer gw.Close()
+	defer vmm.Close()
+	link := newLinkConn(gw, types.QemuProtocol, nil, sends)
+	link.sends = sends
+
+	frame := make([]byte, 4+14)
+	binary.BigEndian.PutUint32(frame, 14)
+	written := make(chan error, 1)
+	go func() {
+		_, err := link.Write(frame)
+		written <- err
+	}()
+
+	stalled := waitForEvent(t, id, EventLinkSendStalled)
+	if stalled.Fields["reason"] != "blocked" || stalled.Fields["guest_sending"] != false {
+		t.Fatalf("stalled fields = %v, want blocked with a silent guest", stalled.Fields)
+	}
+	if stats := sends.Stats(); !stats.Stalled || stats.Stalls != 1 {
+		t.Fatalf("stats = %+v, want one stall in progress", stats)
+	}
+
+	if _, err := io.ReadFull(vmm, make([]byte, len(frame))); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	waitForEvent(t, id, EventLinkSendResumed)
+	if stats := sends.Stats(); stats.Stalled || stats.Stalls != 1 {
+		t.Fatalf("stats = %+v, want the stall over", stats)
+	}
+}
+
+// fullQueueConn fails every write as a datagram socket with a full peer
+// queue does.
+type fullQueueConn struct{ net.Conn }
+
+func (fullQueueConn) Write([]byte) (int, error) {
+	return 0, &net.OpError{Op: "write", Net: "unixgram", Err: syscall.ENOBUFS}
+}
+
+func TestSendStalls_QueueFullCountsDropsOnce(t *testing.T) {
+	const id = 5301
+	sends := newSendStalls(id, 0)
+	gw, vmm := net.Pipe()
+	defer gw.Close()
+	defer vmm.Close()
+	link := newLinkConn(fullQueueConn{gw}, types.VfkitProtocol, nil, sends)
+	link.sends = sends
+
+	sends.ingressFrame(nil) // the guest is still sending
+	for range 3 {
+		if _, err := link.Write(make([]byte, 14)); err == nil {
+			t.Fatal("expected write error")
+		}
+	}
+
+	stalled := waitForEvent(t, id, EventLinkSendStalled)
+	if stalled.Fields["reason"] != "queue_full" || stalled.Fields["guest_sending"] != true {
+		t.Fatalf("stalled fields = %v, want queue_full with the guest sending", stalled.Fields)
+	}
+	if stats := sends.Stats(); stats.Stalls != 1 || stats.QueueFullDrops != 3 {
+		t.Fatalf("stats = %+v, want one stall and three drops", stats)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+// socket_forward.go — Reach Unix sockets inside the guest over SSH.
+//
+// Some guest services only listen on a Unix socket, like podman.sock. Each
+// socket_forwards entry publishes one on a host Unix socket, as upstream
+// gvproxy's --forward-sock does: every connection to host_socket is
+// tunnelled over an SSH session to the guest's sshd, reached on the guest
+// IP through the netstack so no port is published, and connected to
+// guest_socket there. The session logs in as user (default root) with the
+// private key in identity.
+//
+// The session is set up in the background once the instance is up, waiting
+// for sshd to answer for about a minute; socket_forward_ready fires when
+// host_socket accepts, socket_forward_failed if it never will. A session
+// lost later (guest reboot) is re-established on the next connection.
+// host_socket is removed when the instance is destroyed.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/sshclient"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// defaultSocketForwardUser is the SSH user of a forward without one, as in
+// upstream gvproxy.
+const defaultSocketForwardUser = "root"
+
+// EventSocketForwardReady fires when a socket forward's host_socket starts
+// accepting. Fields: host_socket, guest_socket.
+const EventSocketForwardReady = "socket_forward_ready"
+
+// EventSocketForwardFailed fires when a socket forward cannot be set up.
+// Fields: host_socket, guest_socket, error.
+const EventSocketForwardFailed = "socket_forward_failed"
+
+// SocketForward publishes a Unix socket in the guest on a host Unix socket,
+// tunnelled over SSH.
+type SocketForward struct {
+	// HostSocket is the host Unix socket to listen on. Required.
+	HostSocket string `json:"host_socket"`
+	// GuestSocket is the absolute path of the socket in the guest. Required.
+	GuestSocket string `json:"guest_socket"`
+	// User is the SSH user. Empty => root.
+	User string `json:"user,omitempty"`
+	// Identity is the SSH private key file (unencrypted). Required.
+	Identity string `json:"identity"`
+	// SSHPort is the guest sshd's port. Zero => 22.
+	SSHPort uint16 `json:"ssh_port,omitempty"`
+}
+
+// checkSocketForwards validates socket_forwards.
+func checkSocketForwards(config GvproxyConfig) error {
+	hostSockets := make(map[string]bool)
+	for _, sf := range config.SocketForwards {
+		if sf.HostSocket == "" || sf.GuestSocket == "" || sf.Identity == "" {
+			return errors.New("socket forward: host_socket, guest_socket and identity are required")
+		}
+		if len(sf.HostSocket) > maxSocketPathLen {
+			return fmt.Errorf("socket forward %s: host_socket is %d bytes, longer than the %d a Unix socket path allows", sf.HostSocket, len(sf.HostSocket), maxSocketPathLen)
+		}
+		if hostSockets[sf.HostSocket] {
+			return fmt.Errorf("socket forward %s: host_socket is used twice", sf.HostSocket)
+		}
+		hostSockets[sf.HostSocket] = true
+		if !path.IsAbs(sf.GuestSocket) {
+			return fmt.Errorf("socket forward %s: guest_socket %q is not an absolute path", sf.HostSocket, sf.GuestSocket)
+		}
+		if _, err := os.Stat(sf.Identity); err != nil {
+			return fmt.Errorf("socket forward %s: identity: %w", sf.HostSocket, err)
+		}
+	}
+	return nil
+}
+
+// sshURL is the SSH destination of sf for a guest at guestIP.
+func (sf SocketForward) sshURL(guestIP string) *url.URL {
+	user, port := sf.User, sf.SSHPort
+	if user == "" {
+		user = defaultSocketForwardUser
+	}
+	if port == 0 {
+		port = 22
+	}
+	return &url.URL{
+		Scheme: "ssh",
+		User:   url.User(user),
+		Host:   net.JoinHostPort(guestIP, strconv.Itoa(int(port))),
+		Path:   sf.GuestSocket,
+	}
+}
+
+// serveSocketForward sets up sf for instance id, reaching sshd on guestIP
+// through dialer, and relays connections to host_socket until ctx is done.
+func serveSocketForward(ctx context.Context, id int64, dialer sshclient.SSHDialer, guestIP string, sf SocketForward) {
+	fields := func() map[string]any {
+		return map[string]any{"host_socket": sf.HostSocket, "guest_socket": sf.GuestSocket}
+	}
+	src := &url.URL{Scheme: "unix", Path: sf.HostSocket}
+	forward, err := sshclient.CreateSSHForward(ctx, src, sf.sshURL(guestIP), sf.Identity, dialer)
+	if err != nil {
+		// The listener of a failed setup is not handed back; drop its
+		// socket file at least.
+		os.Remove(sf.HostSocket)
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.WithFields(logrus.Fields{"error": err, "id": id, "host_socket": sf.HostSocket, "guest_socket": sf.GuestSocket}).Error("Failed to set up socket forward")
+		failed := fields()
+		failed["error"] = err.Error()
+		emitEvent(id, EventSocketForwardFailed, failed)
+		return
+	}
+	stop := context.AfterFunc(ctx, forward.Close)
+	defer stop()
+
+	logrus.WithFields(logrus.Fields{"id": id, "host_socket": sf.HostSocket, "guest_socket": sf.GuestSocket}).Info("Socket forward ready")
+	emitEvent(id, EventSocketForwardReady, fields())
+	for ctx.Err() == nil {
+		if err := forward.AcceptAndTunnel(ctx); err != nil && ctx.Err() == nil {
+			logrus.WithFields(logrus.Fields{"error": err, "id": id, "host_socket": sf.HostSocket}).Debug("Socket forward connection failed")
+		}
+	}
+}
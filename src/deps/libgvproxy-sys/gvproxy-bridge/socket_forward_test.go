@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshGuestDialer stands in for the netstack and the guest's sshd: every dial
+// returns a loopback connection served by an SSH server that accepts key and echoes on
+// every Unix socket channel, reporting the socket path on paths.
+type sshGuestDialer struct {
+	t      *testing.T
+	key    ssh.PublicKey
+	dialed chan string
+	paths  chan string
+}
+
+func (d *sshGuestDialer) DialContextTCP(_ context.Context, addr string) (net.Conn, error) {
+	d.dialed <- addr
+	// Not net.Pipe: both ends of an SSH handshake write first.
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	go d.serve(server)
+	return client, nil
+}
+
+func (d *sshGuestDialer) serve(conn net.Conn) {
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		d.t.Errorf("host key: %v", err)
+		return
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() != "core" || string(key.Marshal()) != string(d.key.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "direct-streamlocal@openssh.com" {
+			nc.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		var target struct {
+			Path      string
+			Reserved0 string
+			Reserved1 uint32
+		}
+		ssh.Unmarshal(nc.ExtraData(), &target) //nolint:errcheck
+		d.paths <- target.Path
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() {
+			defer ch.Close()
+			io.Copy(ch, ch) //nolint:errcheck
+			ch.CloseWrite()
+		}()
+	}
+}
+
+// writeSSHIdentity writes a new private key file and returns its path and
+// public key.
+func writeSSHIdentity(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("public key: %v", err)
+	}
+	return path, key
+}
+
+func TestServeSocketForward_TunnelsToGuestSocket(t *testing.T) {
+	identity, key := writeSSHIdentity(t)
+	dir, err := os.MkdirTemp("", "gvp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sf := SocketForward{
+		HostSocket:  filepath.Join(dir, "podman.sock"),
+		GuestSocket: "/run/podman/podman.sock",
+		User:        "core",
+		Identity:    identity,
+	}
+	dialer := &sshGuestDialer{t: t, key: key, dialed: make(chan string, 4), paths: make(chan string, 4)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveSocketForward(ctx, 5932, dialer, "192.168.127.2", sf)
+	}()
+	ready := waitForEvent(t, 5932, EventSocketForwardReady)
+	if ready.Fields["guest_socket"] != sf.GuestSocket {
+		t.Fatalf("socket_forward_ready = %v", ready.Fields)
+	}
+	if addr := <-dialer.dialed; addr != "192.168.127.2:22" {
+		t.Fatalf("dialed %s, want the guest's sshd at 192.168.127.2:22", addr)
+	}
+
+	conn, err := net.Dial("unix", sf.HostSocket)
+	if err != nil {
+		t.Fatalf("dial host_socket: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	expectEcho(t, conn)
+	if path := <-dialer.paths; path != sf.GuestSocket {
+		t.Fatalf("tunnelled to %s, want %s", path, sf.GuestSocket)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveSocketForward did not return after cancel")
+	}
+	if _, err := os.Stat(sf.HostSocket); !os.IsNotExist(err) {
+		t.Fatalf("host_socket left behind: %v", err)
+	}
+}
+
+func TestCheckSocketForwards(t *testing.T) {
+	identity, _ := writeSSHIdentity(t)
+	valid := SocketForward{HostSocket: "/tmp/podman.sock", GuestSocket: "/run/podman/podman.sock", Identity: identity}
+	config := testGvproxyConfig()
+	config.SocketForwards = []SocketForward{valid}
+	if err := checkSocketForwards(config); err != nil {
+		t.Fatalf("valid forward rejected: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		forwards []SocketForward
+		want     string
+	}{
+		"missing identity": {[]SocketForward{{HostSocket: valid.HostSocket, GuestSocket: valid.GuestSocket}}, "required"},
+		"relative guest":   {[]SocketForward{{HostSocket: valid.HostSocket, GuestSocket: "podman.sock", Identity: identity}}, "absolute"},
+		"unreadable key":   {[]SocketForward{{HostSocket: valid.HostSocket, GuestSocket: valid.GuestSocket, Identity: identity + ".missing"}}, "identity"},
+		"duplicate host":   {[]SocketForward{valid, valid}, "used twice"},
+		"long host socket": {[]SocketForward{{HostSocket: "/" + strings.Repeat("s", maxSocketPathLen), GuestSocket: valid.GuestSocket, Identity: identity}}, "longer"},
+	} {
+		config.SocketForwards = tc.forwards
+		if err := checkSocketForwards(config); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", name, err, tc.want)
+		}
+	}
+}
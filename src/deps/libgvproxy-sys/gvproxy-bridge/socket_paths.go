@@ -0,0 +1,153 @@
+package main
+
+// socket_paths.go — Catch socket paths the hypervisor cannot reach.
+//
+// Inside a sandbox (systemd PrivateTmp, the macOS App Sandbox) the temp dir
+// this process sees is private to it. A socket created there binds fine, but
+// a hypervisor started outside the sandbox looks at a different directory
+// and the VM never gets network, with nothing pointing at the cause.
+// gvproxy_create now checks every socket path up front and fails with an
+// error naming the path, the sandbox and a directory to use instead; paths
+// too long for a Unix socket fail the same way. When the hypervisor shares
+// the sandbox (e.g. it is a child of this process), allow_sandboxed_socket_paths
+// skips the sandbox check. gvproxy_suggest_socket_dir reports the sandbox and
+// a usable directory before any instance is created.
+
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// socketPathError explains why a configured socket path cannot work.
+type socketPathError struct {
+	Field      string // config field, e.g. "socket_path"
+	Path       string
+	Problem    string
+	Suggestion string // directory to use instead, if one was found
+}
+
+func (e *socketPathError) Error() string {
+	msg := fmt.Sprintf("%s %q %s", e.Field, e.Path, e.Problem)
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf("; use a path under %s", e.Suggestion)
+	}
+	return msg
+}
+
+// checkSocketPaths validates every socket path in config.
+func checkSocketPaths(config GvproxyConfig) error {
+	sandbox, private := sandboxPrivateDirs()
+	return checkSocketPathsIn(config, sandbox, private)
+}
+
+// checkSocketPathsIn validates config's socket paths against the directories
+// a sandbox makes private.
+func checkSocketPathsIn(config GvproxyConfig, sandbox string, private []string) error {
+	for _, p := range []struct{ field, path string }{
+		{"socket_path", config.SocketPath},
+		{"control_socket_path", config.ControlSocketPath},
+		{"connect_proxy_socket_path", config.ConnectProxySocketPath},
+	} {
+		if p.path == "" || isNamedPipe(p.path) {
+			continue
+		}
+		if len(p.path) > maxSocketPathLen {
+			return &socketPathError{
+				Field:      p.field,
+				Path:       p.path,
+				Problem:    fmt.Sprintf("is %d bytes, longer than the %d a Unix socket path allows", len(p.path), maxSocketPathLen),
+				Suggestion: suggestSocketDir(),
+			}
+		}
+		// The abstract namespace belongs to the network namespace, not to
+		// any directory a sandbox makes private.
+		if config.AllowSandboxedSocketPaths || isAbstractSocket(p.path) {
+			continue
+		}
+		if dir := underAny(p.path, private); dir != "" {
+			return &socketPathError{
+				Field:      p.field,
+				Path:       p.path,
+				Problem:    fmt.Sprintf("is under %s, which %s makes private to this process; a hypervisor outside the sandbox cannot reach it (set allow_sandboxed_socket_paths if it shares the sandbox)", dir, sandbox),
+				Suggestion: suggestSocketDir(),
+			}
+		}
+	}
+	return nil
+}
+
+// underAny returns the first of dirs that contains path, or "".
+func underAny(path string, dirs []string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(filepath.Clean(dir), abs); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return dir
+		}
+	}
+	return ""
+}
+
+// socketDirCandidates lists directories a socket could live in, most
+// preferred first.
+func socketDirCandidates() []string {
+	var dirs []string
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, fmt.Sprintf("/run/user/%d", os.Getuid()), os.TempDir(), "/tmp")
+	return dirs
+}
+
+// suggestSocketDir returns the first candidate directory that exists, is
+// writable, leaves room for a socket name, and is not sandbox-private. It
+// returns "" if there is none.
+func suggestSocketDir() string {
+	_, private := sandboxPrivateDirs()
+	for _, dir := range socketDirCandidates() {
+		if len(dir)+32 > maxSocketPathLen || underAny(dir, private) != "" {
+			continue
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		probe, err := os.CreateTemp(dir, ".gvproxy-probe-*")
+		if err != nil {
+			continue
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+		return dir
+	}
+	return ""
+}
+
+// SocketDirReport is the document returned by gvproxy_suggest_socket_dir.
+type SocketDirReport struct {
+	Sandbox     string   `json:"sandbox,omitempty"`      // detected sandbox, if any
+	PrivateDirs []string `json:"private_dirs,omitempty"` // directories private to it
+	Suggested   string   `json:"suggested_dir"`          // "" if none was usable
+}
+
+// gvproxy_suggest_socket_dir reports the sandbox this process runs in (if
+// any), the directories it makes private, and a directory where sockets the
+// hypervisor must reach can be created: {"sandbox": ..., "private_dirs":
+// [...], "suggested_dir": ...}.
+//
+// The string must be freed with gvproxy_free_string.
+//
+//export gvproxy_suggest_socket_dir
+func gvproxy_suggest_socket_dir() *C.char {
+	sandbox, private := sandboxPrivateDirs()
+	out, err := json.Marshal(SocketDirReport{Sandbox: sandbox, PrivateDirs: private, Suggested: suggestSocketDir()})
+	if err != nil {
+		return nil
+	}
+	return returnCString(string(out))
+}
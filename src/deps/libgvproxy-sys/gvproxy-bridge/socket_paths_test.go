@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckSocketPaths_RejectsSandboxPrivatePath(t *testing.T) {
+	config := testGvproxyConfig()
+	config.SocketPath = "/tmp/gvproxy.sock"
+	config.ControlSocketPath = "/run/user/1000/control.sock"
+
+	err := checkSocketPathsIn(config, "systemd PrivateTmp", []string{"/tmp", "/var/tmp"})
+	var spErr *socketPathError
+	if !errors.As(err, &spErr) {
+		t.Fatalf("err = %v, want *socketPathError", err)
+	}
+	if spErr.Field != "socket_path" || spErr.Path != "/tmp/gvproxy.sock" {
+		t.Errorf("error = %+v, want socket_path /tmp/gvproxy.sock", spErr)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "systemd PrivateTmp") || !strings.Contains(msg, "allow_sandboxed_socket_paths") {
+		t.Errorf("error %q does not name the sandbox and the opt-out", msg)
+	}
+
+	config.AllowSandboxedSocketPaths = true
+	if err := checkSocketPathsIn(config, "systemd PrivateTmp", []string{"/tmp"}); err != nil {
+		t.Errorf("with allow_sandboxed_socket_paths: %v", err)
+	}
+}
+
+func TestCheckSocketPaths_AcceptsPathsOutsideSandbox(t *testing.T) {
+	config := testGvproxyConfig()
+	config.SocketPath = "/tmpfoo/gvproxy.sock" // prefix of /tmp, not under it
+	config.ConnectProxySocketPath = "/run/user/1000/proxy.sock"
+
+	if err := checkSocketPathsIn(config, "systemd PrivateTmp", []string{"/tmp"}); err != nil {
+		t.Fatalf("checkSocketPathsIn: %v", err)
+	}
+}
+
+func TestCheckSocketPaths_RejectsOverlongPath(t *testing.T) {
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join("/", strings.Repeat("d", maxSocketPathLen), "s.sock")
+
+	err := checkSocketPathsIn(config, "", nil)
+	var spErr *socketPathError
+	if !errors.As(err, &spErr) || !strings.Contains(spErr.Problem, "longer than") {
+		t.Fatalf("err = %v, want overlong socket path error", err)
+	}
+}
+
+func TestPrivateTmpMounts(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mountinfo is Linux-only")
+	}
+	mountinfo := strings.Join([]string{
+		"22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw",
+		"36 22 8:1 /tmp/systemd-private-abc-boxlite.service-XYZ/tmp /tmp rw,relatime shared:1 - ext4 /dev/sda1 rw",
+		"37 22 8:1 /var/tmp/systemd-private-abc-boxlite.service-QRS/tmp /var/tmp rw,relatime shared:1 - ext4 /dev/sda1 rw",
+		"38 22 0:30 / /run/user/1000 rw,nosuid - tmpfs tmpfs rw",
+	}, "\n")
+
+	got := privateTmpMounts(strings.NewReader(mountinfo))
+	if len(got) != 2 || got[0] != "/tmp" || got[1] != "/var/tmp" {
+		t.Errorf("privateTmpMounts = %v, want [/tmp /var/tmp]", got)
+	}
+}
+
+func TestSuggestSocketDir_PrefersRuntimeDir(t *testing.T) {
+	dir := t.TempDir()
+	if len(dir)+32 > maxSocketPathLen {
+		t.Skip("temp dir too long for a socket path")
+	}
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+
+	if _, private := sandboxPrivateDirs(); underAny(dir, private) != "" {
+		t.Skip("running inside a sandbox that hides the temp dir")
+	}
+	if got := suggestSocketDir(); got != dir {
+		t.Errorf("suggestSocketDir = %q, want %q", got, dir)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+// socket_permissions.go — Let a VMM running as another user reach the VM
+// socket.
+//
+// The VM socket used to be created with the process umask and owned by the
+// boxlite daemon, so the VMM had to run as the same user (or the embedder
+// had to chmod the path itself, racing the VMM's connect). With
+// socket_permissions the bridge sets the socket's mode, and optionally its
+// owner and group, right after creating it and each time it re-creates it
+// (vm_reconnect.go). Changing the owner needs the privilege to chown, like
+// chown(2); changing only the group needs membership of it. Handed-over
+// sockets keep what they had. Named pipes (Windows) have no Unix mode.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+)
+
+// SocketPermissions sets the mode and ownership of the VM socket.
+type SocketPermissions struct {
+	// Mode is the octal file mode, e.g. "0660". Empty => left as created.
+	Mode string `json:"mode,omitempty"`
+	// UID and GID own the socket. Nil => left as created.
+	UID *int `json:"uid,omitempty"`
+	GID *int `json:"gid,omitempty"`
+}
+
+// fileMode parses Mode.
+func (p *SocketPermissions) fileMode() (os.FileMode, bool, error) {
+	if p.Mode == "" {
+		return 0, false, nil
+	}
+	mode, err := strconv.ParseUint(p.Mode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, false, fmt.Errorf("mode %q is not an octal permission mode like \"0660\"", p.Mode)
+	}
+	return os.FileMode(mode), true, nil
+}
+
+// checkSocketPermissions validates socket_permissions against config.
+func checkSocketPermissions(config GvproxyConfig) error {
+	p := config.SocketPermissions
+	if p == nil {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		return classify(createErrUnsupported, errors.New("socket_permissions is not supported on windows"))
+	}
+	if _, _, err := p.fileMode(); err != nil {
+		return err
+	}
+	if p.UID != nil && *p.UID < 0 || p.GID != nil && *p.GID < 0 {
+		return errors.New("uid and gid must not be negative")
+	}
+	if config.SocketPath == "" || isNamedPipe(config.SocketPath) {
+		return errors.New("socket_permissions needs a Unix socket at socket_path")
+	}
+	return nil
+}
+
+// applySocketPermissions sets p on the socket at path. A nil p changes
+// nothing.
+func applySocketPermissions(path string, p *SocketPermissions) error {
+	if p == nil {
+		return nil
+	}
+	if p.UID != nil || p.GID != nil {
+		uid, gid := -1, -1
+		if p.UID != nil {
+			uid = *p.UID
+		}
+		if p.GID != nil {
+			gid = *p.GID
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	mode, ok, err := p.fileMode()
+	if err != nil || !ok {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCheckSocketPermissions(t *testing.T) {
+	neg := -1
+	for _, tc := range []struct {
+		perms SocketPermissions
+		ok    bool
+	}{
+		{SocketPermissions{Mode: "0660"}, true},
+		{SocketPermissions{Mode: "660"}, true},
+		{SocketPermissions{Mode: "0999"}, false},
+		{SocketPermissions{Mode: "01777"}, false},
+		{SocketPermissions{UID: &neg}, false},
+	} {
+		config := testGvproxyConfig()
+		config.SocketPermissions = &tc.perms
+		if err := checkSocketPermissions(config); (err == nil) != tc.ok {
+			t.Errorf("checkSocketPermissions(%+v) = %v, want ok=%v", tc.perms, err, tc.ok)
+		}
+	}
+
+	config := testGvproxyConfig()
+	config.SocketPath = ""
+	config.SocketPermissions = &SocketPermissions{Mode: "0660"}
+	if err := checkSocketPermissions(config); err == nil {
+		t.Error("permissions without a socket_path must be rejected")
+	}
+}
+
+func TestStartInstance_SocketPermissions(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+	for i, protocol := range []string{"qemu", "vfkit"} {
+		config := testGvproxyConfig()
+		config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+		config.Protocol = protocol
+		config.SocketPermissions = &SocketPermissions{Mode: "0600", UID: &uid, GID: &gid}
+		id := int64(5921 + i)
+		if err := startInstance(id, config); err != nil {
+			t.Fatalf("%s: startInstance: %v", protocol, err)
+		}
+		info, err := os.Stat(config.SocketPath)
+		stopTestInstance(id)
+		if err != nil {
+			t.Fatalf("%s: stat socket: %v", protocol, err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("%s: socket mode = %v, want 0600", protocol, info.Mode().Perm())
+		}
+		if st := info.Sys().(*syscall.Stat_t); int(st.Uid) != uid || int(st.Gid) != gid {
+			t.Errorf("%s: socket owned by %d:%d, want %d:%d", protocol, st.Uid, st.Gid, uid, gid)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+// ssh_jump.go — An SSH jump host into the guest network.
+//
+// Developers reach guest services with ssh -J out of habit, and every extra
+// port forward is config to write. With ssh_jump set, the bridge serves SSH
+// on a host TCP address; clients authenticate with one of authorized_keys
+// (any user name) and may then only open TCP forwards ("direct-tcpip"
+// channels) to guest addresses, dialed from the netstack:
+//
+//	ssh -J jump@127.0.0.1:2222 root@192.168.127.2
+//	ssh -p 2222 -N -L 5432:192.168.127.2:5432 jump@127.0.0.1
+//
+// A guest address is one inside the subnet holding a DHCP lease or static
+// entry. The gateway, host_ip and the other NAT table addresses lead to the
+// host rather than a guest and are refused whatever the lease table says,
+// as is host_route's address. Shells, exec, subsystems and remote forwards
+// are refused. The host key is host_key, or an ed25519 key generated for
+// the instance; its fingerprint is logged and reported in the SSHJump stats
+// section so clients can pin it.
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// sshJumpHandshakeTimeout bounds an SSH handshake, authentication
+	// included.
+	sshJumpHandshakeTimeout = 30 * time.Second
+	// sshJumpDialTimeout bounds a forward's dial into the guest network.
+	sshJumpDialTimeout = 10 * time.Second
+)
+
+// SSHJump configures the SSH jump host.
+type SSHJump struct {
+	// Listen is the host TCP address to serve on, e.g. "127.0.0.1:2222".
+	// Required.
+	Listen string `json:"listen"`
+	// AuthorizedKeys are the public keys allowed in, one authorized_keys
+	// line each. Required.
+	AuthorizedKeys []string `json:"authorized_keys"`
+	// HostKey is the server's private key file (unencrypted). Empty => an
+	// ed25519 key generated for the instance.
+	HostKey string `json:"host_key,omitempty"`
+}
+
+// sshJump is the jump host of one instance.
+type sshJump struct {
+	instanceID  int64
+	listen      string
+	subnet      netip.Prefix
+	hostAddrs   map[netip.Addr]bool      // gateway, NAT table and host_route addresses
+	leases      func() map[string]string // IP → MAC of leased and static guests
+	config      *ssh.ServerConfig
+	fingerprint string
+
+	mu      sync.Mutex
+	address string // bound address, once listening
+
+	connections  atomic.Uint64 // authenticated SSH connections
+	authFailures atomic.Uint64
+	forwards     atomic.Uint64 // forwards opened into the guest network
+	rejected     atomic.Uint64 // channels refused: not a forward, target not a guest, or dial failed
+}
+
+// newSSHJump validates ssh_jump and prepares the server; it returns nil when
+// ssh_jump is not set.
+func newSSHJump(instanceID int64, config GvproxyConfig) (*sshJump, error) {
+	jc := config.SSHJump
+	if jc == nil {
+		return nil, nil
+	}
+	if _, port, err := net.SplitHostPort(jc.Listen); err != nil {
+		return nil, fmt.Errorf("ssh_jump: listen %q: %w", jc.Listen, err)
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("ssh_jump: listen %q: invalid port", jc.Listen)
+	}
+	if len(jc.AuthorizedKeys) == 0 {
+		return nil, errors.New("ssh_jump: authorized_keys is required")
+	}
+	authorized := make(map[string]bool)
+	for _, line := range jc.AuthorizedKeys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("ssh_jump: authorized key %q: %w", line, err)
+		}
+		authorized[string(key.Marshal())] = true
+	}
+	subnet, err := netip.ParsePrefix(config.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("ssh_jump: subnet: %w", err)
+	}
+	hostKey, err := sshJumpHostKey(jc.HostKey)
+	if err != nil {
+		return nil, fmt.Errorf("ssh_jump: host_key: %w", err)
+	}
+
+	hostAddrs := make(map[netip.Addr]bool)
+	hosts := []string{config.GatewayIP}
+	for ip := range buildNATTable(config) {
+		hosts = append(hosts, ip)
+	}
+	if config.HostRoute != nil {
+		hosts = append(hosts, config.HostRoute.Address)
+	}
+	for _, host := range hosts {
+		if ip, err := netip.ParseAddr(host); err == nil {
+			hostAddrs[ip.Unmap()] = true
+		}
+	}
+
+	j := &sshJump{instanceID: instanceID, listen: jc.Listen, subnet: subnet.Masked(), hostAddrs: hostAddrs, fingerprint: ssh.FingerprintSHA256(hostKey.PublicKey())}
+	j.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if authorized[string(key.Marshal())] {
+				return nil, nil
+			}
+			j.authFailures.Add(1)
+			return nil, fmt.Errorf("unknown public key for %s", meta.User())
+		},
+		ServerVersion: "SSH-2.0-gvproxy-jump",
+	}
+	j.config.AddHostKey(hostKey)
+	return j, nil
+}
+
+// sshJumpHostKey loads the host key at path, or generates an ed25519 key
+// when path is empty.
+func sshJumpHostKey(path string) (ssh.Signer, error) {
+	if path == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.NewSignerFromKey(key)
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(pem)
+}
+
+// start listens on the configured address and serves until ctx is done,
+// forwarding to the guests in leases through dialer.
+func (j *sshJump) start(ctx context.Context, dialer guestDialer, leases func() map[string]string, goroutines *tracker) error {
+	l, err := net.Listen("tcp", j.listen)
+	if err != nil {
+		return fmt.Errorf("SSH jump host: %w", err)
+	}
+	j.leases = leases
+	j.mu.Lock()
+	j.address = l.Addr().String()
+	j.mu.Unlock()
+	goroutines.spawn(func() {
+		<-ctx.Done()
+		l.Close()
+	})
+	goroutines.spawn(func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					logrus.WithFields(logrus.Fields{"error": err, "id": j.instanceID}).Error("SSH jump host exited")
+				}
+				return
+			}
+			goroutines.spawn(func() { j.serveConn(ctx, conn, dialer) })
+		}
+	})
+	logrus.WithFields(logrus.Fields{"id": j.instanceID, "address": l.Addr().String(), "host_key": j.fingerprint}).Info("Serving SSH jump host into the guest network")
+	return nil
+}
+
+// serveConn runs one SSH connection until the client or ctx ends it.
+func (j *sshJump) serveConn(ctx context.Context, conn net.Conn, dialer guestDialer) {
+	conn.SetDeadline(time.Now().Add(sshJumpHandshakeTimeout)) //nolint:errcheck
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, j.config)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": j.instanceID, "peer": conn.RemoteAddr().String()}).Debug("SSH jump host handshake failed")
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+	j.connections.Add(1)
+	stop := context.AfterFunc(ctx, func() { sconn.Close() })
+	defer stop()
+	defer sconn.Close()
+
+	// Global requests (tcpip-forward, keepalives) are all refused.
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		go j.serveChannel(ctx, newCh, dialer)
+	}
+}
+
+// directTCPIP is the payload of a "direct-tcpip" channel request (RFC 4254
+// 7.2).
+type directTCPIP struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// serveChannel forwards a direct-tcpip channel to its target in the guest
+// network, refusing anything else.
+func (j *sshJump) serveChannel(ctx context.Context, newCh ssh.NewChannel, dialer guestDialer) {
+	if newCh.ChannelType() != "direct-tcpip" {
+		j.rejected.Add(1)
+		newCh.Reject(ssh.Prohibited, "only TCP forwarding into the guest network is allowed") //nolint:errcheck
+		return
+	}
+	var req directTCPIP
+	if err := ssh.Unmarshal(newCh.ExtraData(), &req); err != nil {
+		j.rejected.Add(1)
+		newCh.Reject(ssh.ConnectionFailed, "malformed direct-tcpip request") //nolint:errcheck
+		return
+	}
+	target, err := j.target(req.Addr, req.Port)
+	if err != nil {
+		j.rejected.Add(1)
+		newCh.Reject(ssh.Prohibited, err.Error()) //nolint:errcheck
+		return
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, sshJumpDialTimeout)
+	guest, err := dialer.DialContextTCP(dialCtx, target)
+	cancel()
+	if err != nil {
+		j.rejected.Add(1)
+		logrus.WithFields(logrus.Fields{"error": err, "id": j.instanceID, "target": target}).Debug("SSH jump host dial failed")
+		newCh.Reject(ssh.ConnectionFailed, err.Error()) //nolint:errcheck
+		return
+	}
+	ch, chReqs, err := newCh.Accept()
+	if err != nil {
+		guest.Close()
+		return
+	}
+	j.forwards.Add(1)
+	go ssh.DiscardRequests(chReqs)
+	logrus.WithFields(logrus.Fields{"id": j.instanceID, "target": target}).Debug("SSH jump host forward opened")
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(guest, ch) //nolint:errcheck
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(ch, guest) //nolint:errcheck
+		ch.CloseWrite()    //nolint:errcheck
+		done <- struct{}{}
+	}()
+	<-done
+	ch.Close()
+	guest.Close()
+	<-done
+}
+
+// target returns host:port for a forward to addr:port, which must be a
+// guest address (see ssh_jump.go).
+func (j *sshJump) target(addr string, port uint32) (string, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return "", fmt.Errorf("target %q is not an IP address", addr)
+	}
+	ip = ip.Unmap()
+	if !j.subnet.Contains(ip) {
+		return "", fmt.Errorf("target %s is outside the guest network %s", addr, j.subnet)
+	}
+	if j.hostAddrs[ip] {
+		return "", fmt.Errorf("target %s is the gateway or the host, not a guest", addr)
+	}
+	if _, ok := j.leases()[ip.String()]; !ok {
+		return "", fmt.Errorf("target %s has no DHCP lease or static entry", addr)
+	}
+	if port == 0 || port > 65535 {
+		return "", fmt.Errorf("invalid target port %d", port)
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), nil
+}
+
+// SSHJumpStats is the "SSHJump" stats section.
+type SSHJumpStats struct {
+	Address            string `json:"Address"`            // bound host address; empty until listening
+	HostKeyFingerprint string `json:"HostKeyFingerprint"` // SHA256:...
+	Connections        uint64 `json:"Connections"`        // authenticated connections
+	AuthFailures       uint64 `json:"AuthFailures"`
+	Forwards           uint64 `json:"Forwards"` // forwards opened into the guest network
+	Rejected           uint64 `json:"Rejected"` // channels refused or whose target did not answer
+}
+
+type sshJumpCollector struct{ j *sshJump }
+
+func (sshJumpCollector) Name() string { return "SSHJump" }
+
+func (sshJumpCollector) Description() string {
+	return "SSH jump host into the guest network (ssh_jump): address, host key fingerprint, connections and forwards."
+}
+
+func (c sshJumpCollector) Collect() any {
+	c.j.mu.Lock()
+	address := c.j.address
+	c.j.mu.Unlock()
+	return SSHJumpStats{
+		Address:            address,
+		HostKeyFingerprint: c.j.fingerprint,
+		Connections:        c.j.connections.Load(),
+		AuthFailures:       c.j.authFailures.Load(),
+		Forwards:           c.j.forwards.Load(),
+		Rejected:           c.j.rejected.Load(),
+	}
+}
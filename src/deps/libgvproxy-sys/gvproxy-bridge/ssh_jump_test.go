@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHKey returns a fresh client key and its authorized_keys line.
+func testSSHKey(t *testing.T) (ssh.Signer, string) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+func TestNewSSHJump_Validates(t *testing.T) {
+	_, authorized := testSSHKey(t)
+	for want, jc := range map[string]SSHJump{
+		"listen":          {Listen: "127.0.0.1", AuthorizedKeys: []string{authorized}},
+		"authorized_keys": {Listen: "127.0.0.1:0"},
+		"authorized key":  {Listen: "127.0.0.1:0", AuthorizedKeys: []string{"ssh-ed25519 garbage"}},
+		"host_key":        {Listen: "127.0.0.1:0", AuthorizedKeys: []string{authorized}, HostKey: "/nonexistent/key"},
+	} {
+		config := testGvproxyConfig()
+		config.SSHJump = &jc
+		if _, err := newSSHJump(5938, config); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%+v: err = %v, want %q", jc, err, want)
+		}
+	}
+	if j, err := newSSHJump(5938, testGvproxyConfig()); j != nil || err != nil {
+		t.Errorf("without ssh_jump: %v, %v", j, err)
+	}
+}
+
+func TestSSHJump_ForwardsIntoGuestNetworkOnly(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn) //nolint:errcheck
+			}()
+		}
+	}()
+
+	signer, authorized := testSSHKey(t)
+	config := testGvproxyConfig()
+	config.SSHJump = &SSHJump{Listen: "127.0.0.1:0", AuthorizedKeys: []string{authorized}}
+	j, err := newSSHJump(5938, config)
+	if err != nil {
+		t.Fatalf("newSSHJump: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dialer := &loopbackDialer{addr: echo.Addr().String(), dialed: make(chan string, 1)}
+	// The gateway holds a lease too; it must still be refused.
+	leases := func() map[string]string {
+		return map[string]string{config.GatewayIP: config.GatewayMac, config.GuestIP: config.GuestMac}
+	}
+	if err := j.start(ctx, dialer, leases, nil); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	stats := sshJumpCollector{j}.Collect().(SSHJumpStats)
+
+	// An unknown key is refused.
+	other, _ := testSSHKey(t)
+	if _, err := ssh.Dial("tcp", stats.Address, &ssh.ClientConfig{
+		User:            "jump",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(other)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}); err == nil {
+		t.Fatal("expected an unknown key to be refused")
+	}
+
+	var hostKey string
+	client, err := ssh.Dial("tcp", stats.Address, &ssh.ClientConfig{
+		User: "jump",
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = ssh.FingerprintSHA256(key)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("ssh dial: %v", err)
+	}
+	defer client.Close()
+	if hostKey != stats.HostKeyFingerprint {
+		t.Errorf("host key %s, stats report %s", hostKey, stats.HostKeyFingerprint)
+	}
+
+	conn, err := client.Dial("tcp", "192.168.127.2:80")
+	if err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+	if got := <-dialer.dialed; got != "192.168.127.2:80" {
+		t.Fatalf("dialed %q, want the guest address", got)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+	conn.Close()
+
+	for target, why := range map[string]string{
+		"10.0.0.1:80":        "outside the subnet",
+		"192.168.127.1:80":   "the gateway",
+		"192.168.127.254:22": "host_ip, NATed to the host's loopback",
+		"192.168.127.50:80":  "without a lease",
+	} {
+		if _, err := client.Dial("tcp", target); err == nil {
+			t.Errorf("expected a target %s to be refused", why)
+		}
+	}
+	if _, err := client.NewSession(); err == nil {
+		t.Error("expected a session to be refused")
+	}
+
+	stats = sshJumpCollector{j}.Collect().(SSHJumpStats)
+	if stats.Connections != 1 || stats.AuthFailures != 1 || stats.Forwards != 1 || stats.Rejected != 5 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
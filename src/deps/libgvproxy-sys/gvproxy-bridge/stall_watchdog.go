@@ -0,0 +1,208 @@
+package main
+
+// stall_watchdog.go — Turn a hung data path into an incident report.
+//
+// A deadlock or a wedged goroutine in the switch or the netstack does not
+// crash anything: the guest just loses its network, the socket stays
+// connected, and nothing says why. The watchdog counts the frames crossing
+// the link (it is an accounting stage of the data path) and compares the
+// counters every data_path_stall_ms. Frames keep coming from the guest
+// (ARP, retransmitted SYNs, DNS retries) when it has no network, and a
+// working gateway always answers some of them. So a window in which the
+// guest sent at least minStallIngressFrames frames and not a single one went
+// back, while the link was connected, is a stall: EventDataPathStalled fires
+// once, with the internal queue depths and the path of a goroutine dump
+// written at that moment, and EventDataPathRecovered once frames flow to the
+// guest again. An idle guest sends nothing and is never reported.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventDataPathStalled fires when the guest's frames stop being answered
+// while the link is connected. Fields: severity ("critical"), window_ms,
+// ingress_frames (from the guest in the window), link_queue_interactive and
+// link_queue_bulk (frames queued for the guest, link_priority),
+// link_write_blocked_ms (age of the write to the hypervisor socket in
+// progress, 0 if none), active_flows (max_data_plane_flows slots in use),
+// goroutines, goroutine_dump (path of the dump file, "" if it could not be
+// written), stalls (count for this instance).
+const EventDataPathStalled = "data_path_stalled"
+
+// EventDataPathRecovered fires when frames reach the guest again after a
+// stall. Fields: stalled_ms.
+const EventDataPathRecovered = "data_path_recovered"
+
+// defaultDataPathStallWindow applies when data_path_stall_ms is 0.
+const defaultDataPathStallWindow = 30 * time.Second
+
+// minStallIngressFrames keeps a guest sending the odd datagram to a dead
+// host from looking like a stall.
+const minStallIngressFrames = 8
+
+// stallDumpDir is where goroutine dumps are written; "" => os.TempDir().
+var stallDumpDir = ""
+
+// stallSnapshot is the internal state a stall report includes.
+type stallSnapshot struct {
+	connected        bool
+	queueInteractive int
+	queueBulk        int
+	writeBlocked     time.Duration
+	activeFlows      int
+}
+
+// stallWatchdog is a frameObserver counting link frames. A nil
+// *stallWatchdog is disabled.
+type stallWatchdog struct {
+	instanceID int64
+	window     time.Duration
+
+	ingress atomic.Uint64
+	egress  atomic.Uint64
+	stalls  atomic.Uint64
+	stalled atomic.Bool
+
+	// Owned by the goroutine calling check.
+	lastIngress, lastEgress uint64
+	stalledSince            time.Time
+}
+
+// newStallWatchdog returns nil when windowMs is negative (disabled).
+func newStallWatchdog(instanceID int64, windowMs int) *stallWatchdog {
+	if windowMs < 0 {
+		return nil
+	}
+	window := defaultDataPathStallWindow
+	if windowMs > 0 {
+		window = time.Duration(windowMs) * time.Millisecond
+	}
+	return &stallWatchdog{instanceID: instanceID, window: window}
+}
+
+func (w *stallWatchdog) ingressFrame([]byte) { w.ingress.Add(1) }
+func (w *stallWatchdog) egressFrame([]byte)  { w.egress.Add(1) }
+
+// watch checks the counters every window until ctx is done.
+func (w *stallWatchdog) watch(ctx context.Context, snapshot func() stallSnapshot) {
+	ticker := time.NewTicker(w.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.check(now, snapshot)
+		}
+	}
+}
+
+// check compares the counters with the previous check.
+func (w *stallWatchdog) check(now time.Time, snapshot func() stallSnapshot) {
+	ingress, egress := w.ingress.Load(), w.egress.Load()
+	sent, answered := ingress-w.lastIngress, egress-w.lastEgress
+	w.lastIngress, w.lastEgress = ingress, egress
+
+	if !w.stalledSince.IsZero() {
+		if answered > 0 {
+			stalled := now.Sub(w.stalledSince)
+			w.stalledSince = time.Time{}
+			w.stalled.Store(false)
+			logrus.WithFields(logrus.Fields{"id": w.instanceID, "stalled_ms": stalled.Milliseconds()}).Warn("Data path recovered")
+			emitEvent(w.instanceID, EventDataPathRecovered, map[string]any{"stalled_ms": stalled.Milliseconds()})
+		}
+		return
+	}
+	if answered > 0 || sent < minStallIngressFrames {
+		return
+	}
+	s := snapshot()
+	if !s.connected {
+		return
+	}
+
+	w.stalledSince = now.Add(-w.window)
+	w.stalled.Store(true)
+	stalls := w.stalls.Add(1)
+	dump, err := writeGoroutineDump(w.instanceID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"id": w.instanceID, "error": err}).Warn("Failed to write goroutine dump")
+	}
+	fields := map[string]any{
+		"severity":               "critical",
+		"window_ms":              w.window.Milliseconds(),
+		"ingress_frames":         sent,
+		"link_queue_interactive": s.queueInteractive,
+		"link_queue_bulk":        s.queueBulk,
+		"link_write_blocked_ms":  s.writeBlocked.Milliseconds(),
+		"active_flows":           s.activeFlows,
+		"goroutines":             runtime.NumGoroutine(),
+		"goroutine_dump":         dump,
+		"stalls":                 stalls,
+	}
+	logrus.WithFields(logrus.Fields(fields)).WithField("id", w.instanceID).Error("Data path stalled: guest frames are not being answered")
+	emitEvent(w.instanceID, EventDataPathStalled, fields)
+}
+
+// writeGoroutineDump writes a full goroutine dump to a new file and
+// returns its path.
+func writeGoroutineDump(instanceID int64) (string, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(stallDumpDir, fmt.Sprintf("gvproxy-%d-%d-stall-*.txt", os.Getpid(), instanceID))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// linkStallSnapshot reads the state of the current link and of the data
+// plane; not connected when there is no link.
+func linkStallSnapshot(currentLink func() *linkConn, flows *flowLimiter) func() stallSnapshot {
+	return func() stallSnapshot {
+		link := currentLink()
+		if link == nil {
+			return stallSnapshot{}
+		}
+		s := stallSnapshot{connected: true, writeBlocked: link.sends.blockedFor(time.Now())}
+		s.queueInteractive, s.queueBulk = link.queue.depths()
+		if flows != nil {
+			s.activeFlows = len(flows.slots)
+		}
+		return s
+	}
+}
+
+// StallWatchdogStats is the "StallWatchdog" stats section.
+type StallWatchdogStats struct {
+	WindowMs int64  `json:"WindowMs"`
+	Stalled  bool   `json:"Stalled"` // a stall is in progress
+	Stalls   uint64 `json:"Stalls"`
+}
+
+type stallWatchdogCollector struct{ w *stallWatchdog }
+
+func (stallWatchdogCollector) Name() string { return "StallWatchdog" }
+
+func (stallWatchdogCollector) Description() string {
+	return "Data path stalls: windows (data_path_stall_ms) in which guest frames went unanswered while the link was connected."
+}
+
+func (c stallWatchdogCollector) Collect() any {
+	return StallWatchdogStats{WindowMs: c.w.window.Milliseconds(), Stalled: c.w.stalled.Load(), Stalls: c.w.stalls.Load()}
+}
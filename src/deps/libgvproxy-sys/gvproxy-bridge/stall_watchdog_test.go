@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stallEventsFor returns the watchdog's events for id.
+func stallEventsFor(id int64) []Event {
+	var out []Event
+	for _, e := range recentEventsFor(id) {
+		if e.Type == EventDataPathStalled || e.Type == EventDataPathRecovered {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func TestStallWatchdog_ReportsUnansweredFrames(t *testing.T) {
+	const id = 5940
+	stallDumpDir = t.TempDir()
+	defer func() { stallDumpDir = "" }()
+
+	w := newStallWatchdog(id, 1000)
+	snapshot := stallSnapshot{connected: true, queueBulk: 3, writeBlocked: 1500 * time.Millisecond, activeFlows: 2}
+	check := func(now time.Time, ingress, egress int) {
+		for i := 0; i < ingress; i++ {
+			w.ingressFrame(nil)
+		}
+		for i := 0; i < egress; i++ {
+			w.egressFrame(nil)
+		}
+		w.check(now, func() stallSnapshot { return snapshot })
+	}
+	start := time.Now()
+
+	check(start, 20, 5)                   // answered
+	check(start.Add(1*time.Second), 2, 0) // too few frames to tell
+	snapshot.connected = false
+	check(start.Add(2*time.Second), 20, 0) // no link to stall
+	snapshot.connected = true
+	check(start.Add(3*time.Second), 0, 0) // idle
+	if events := stallEventsFor(id); len(events) != 0 {
+		t.Fatalf("events = %+v, want none before a stall", events)
+	}
+
+	check(start.Add(4*time.Second), 20, 0)
+	check(start.Add(5*time.Second), 20, 0) // still stalled: reported once
+	events := stallEventsFor(id)
+	if len(events) != 1 || events[0].Type != EventDataPathStalled {
+		t.Fatalf("events = %+v, want one data_path_stalled", events)
+	}
+	f := events[0].Fields
+	if f["severity"] != "critical" || f["ingress_frames"] != uint64(20) || f["link_queue_bulk"] != 3 ||
+		f["link_write_blocked_ms"] != int64(1500) || f["active_flows"] != 2 || f["stalls"] != uint64(1) {
+		t.Fatalf("fields = %v", f)
+	}
+	dump, _ := f["goroutine_dump"].(string)
+	if data, err := os.ReadFile(dump); err != nil || !strings.Contains(string(data), "goroutine") {
+		t.Fatalf("goroutine dump %q: %v", dump, err)
+	}
+	if stats := (stallWatchdogCollector{w}).Collect().(StallWatchdogStats); !stats.Stalled || stats.Stalls != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	check(start.Add(6*time.Second), 5, 5)
+	events = stallEventsFor(id)
+	if len(events) != 2 || events[1].Type != EventDataPathRecovered || events[1].Fields["stalled_ms"] != int64(3000) {
+		t.Fatalf("events = %+v, want data_path_recovered after 3s", events)
+	}
+}
+
+func TestNewStallWatchdog(t *testing.T) {
+	if w := newStallWatchdog(1, -1); w != nil {
+		t.Fatal("negative data_path_stall_ms should disable the watchdog")
+	}
+	if w := newStallWatchdog(1, 0); w.window != defaultDataPathStallWindow {
+		t.Fatalf("window = %v, want the default", w.window)
+	}
+}
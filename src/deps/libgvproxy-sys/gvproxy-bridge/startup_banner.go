@@ -0,0 +1,123 @@
+package main
+
+// startup_banner.go — One event describing the host, on the first create.
+//
+// Most networking bug reports end in the same round of questions: which OS
+// and architecture, where temporary files go, how many FDs the process may
+// open, whether the host has IPv6, and whether a VPN is rewriting routes.
+// The first gvproxy_create of the process answers them up front with one
+// startup_banner event (process-wide, instance_id 0) and an info log line,
+// so they are already in the logs the user attaches. Probing is cheap and
+// best-effort: a fact that cannot be determined is left out.
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventStartupBanner fires once per process, on the first gvproxy_create.
+// Fields: goos, goarch, go_version, gvisor_tap_vsock_version, tmpdir,
+// tmpdir_writable, nofile_soft and nofile_hard (not on Windows),
+// ipv6_loopback (an IPv6 loopback socket can be bound), ipv6_global (an
+// interface has a global IPv6 address), vpn_interfaces (names of up
+// interfaces that look like VPN tunnels).
+const EventStartupBanner = "startup_banner"
+
+// vpnInterfacePrefixes are interface name prefixes of common VPN clients
+// (WireGuard, OpenVPN, macOS utun, Tailscale, ZeroTier, PPP, IPsec, Cisco
+// AnyConnect, GlobalProtect).
+var vpnInterfacePrefixes = []string{"wg", "tun", "tap", "utun", "tailscale", "zt", "ppp", "ipsec", "cscotun", "gpd"}
+
+var startupBannerOnce sync.Once
+
+// emitStartupBanner emits the startup banner unless it was already emitted.
+func emitStartupBanner() {
+	startupBannerOnce.Do(func() {
+		fields := startupBanner()
+		logrus.WithFields(logrus.Fields(fields)).Info("gvproxy bridge environment")
+		emitEvent(0, EventStartupBanner, fields)
+	})
+}
+
+// startupBanner probes the environment.
+func startupBanner() map[string]any {
+	fields := map[string]any{
+		"goos":                     runtime.GOOS,
+		"goarch":                   runtime.GOARCH,
+		"go_version":               runtime.Version(),
+		"gvisor_tap_vsock_version": gvisorTapVsockVersion(),
+		"tmpdir":                   os.TempDir(),
+		"tmpdir_writable":          tmpdirWritable(),
+		"ipv6_loopback":            ipv6LoopbackAvailable(),
+	}
+	if soft, hard, ok := nofileLimit(); ok {
+		fields["nofile_soft"] = soft
+		fields["nofile_hard"] = hard
+	}
+	if ifaces, err := net.Interfaces(); err == nil {
+		fields["ipv6_global"] = hasGlobalIPv6(ifaces)
+		fields["vpn_interfaces"] = vpnInterfaces(ifaces)
+	}
+	return fields
+}
+
+func tmpdirWritable() bool {
+	f, err := os.CreateTemp("", "gvproxy-probe-")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
+
+func ipv6LoopbackAvailable() bool {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+func hasGlobalIPv6(ifaces []net.Interface) bool {
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if ok && ipnet.IP.To4() == nil && ipnet.IP.IsGlobalUnicast() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// vpnInterfaces returns the sorted names of up interfaces that look like
+// VPN tunnels.
+func vpnInterfaces(ifaces []net.Interface) []string {
+	names := []string{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		name := strings.ToLower(iface.Name)
+		for _, prefix := range vpnInterfacePrefixes {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, iface.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestStartupBanner_Fields(t *testing.T) {
+	fields := startupBanner()
+	for _, key := range []string{"goos", "goarch", "go_version", "tmpdir", "tmpdir_writable", "ipv6_loopback", "ipv6_global", "vpn_interfaces"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("missing %s in %v", key, fields)
+		}
+	}
+	if fields["goos"] != runtime.GOOS || fields["tmpdir_writable"] != true {
+		t.Errorf("fields = %v", fields)
+	}
+	if runtime.GOOS != "windows" {
+		if soft, _ := fields["nofile_soft"].(uint64); soft == 0 {
+			t.Errorf("nofile_soft = %v", fields["nofile_soft"])
+		}
+	}
+}
+
+func TestVPNInterfaces(t *testing.T) {
+	ifaces := []net.Interface{
+		{Name: "eth0", Flags: net.FlagUp},
+		{Name: "wg0", Flags: net.FlagUp},
+		{Name: "utun3", Flags: net.FlagUp},
+		{Name: "tailscale0", Flags: net.FlagUp},
+		{Name: "tun1"}, // down
+		{Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+	}
+	if got := vpnInterfaces(ifaces); !slices.Equal(got, []string{"tailscale0", "utun3", "wg0"}) {
+		t.Fatalf("vpnInterfaces = %v", got)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// nofileLimit returns the process's RLIMIT_NOFILE.
+func nofileLimit() (soft, hard uint64, ok bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, false
+	}
+	return uint64(rl.Cur), uint64(rl.Max), true
+}
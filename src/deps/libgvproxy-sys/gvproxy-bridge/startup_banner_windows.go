@@ -0,0 +1,6 @@
+package main
+
+// nofileLimit is not meaningful on Windows, which has no RLIMIT_NOFILE.
+func nofileLimit() (soft, hard uint64, ok bool) {
+	return 0, 0, false
+}
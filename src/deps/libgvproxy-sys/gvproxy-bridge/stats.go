@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http/httptest"
+	"strings"
+	"sync"
 
 	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
 )
@@ -38,3 +41,117 @@ func collectNetworkStats(vn *virtualnetwork.VirtualNetwork) string {
 	// Return the JSON response body
 	return rec.Body.String()
 }
+
+// StatsCollector contributes one top-level section to an instance's stats
+// document. Each subsystem defines its collector next to its own code and
+// registers it at instance creation, so adding a metric never means growing
+// one function.
+type StatsCollector interface {
+	// Name is the section's top-level key, PascalCase like upstream keys.
+	Name() string
+	// Description is a one-line summary published by gvproxy_get_stats_schema.
+	Description() string
+	// Collect returns the section's current value. It must be JSON-encodable.
+	Collect() any
+}
+
+// openMetricsCollector is implemented by collectors whose section does not
+// map to plain counters by JSON path (labelled samples, gauges).
+type openMetricsCollector interface {
+	collectOpenMetrics(emit metricEmitter)
+}
+
+// metricEmitter adds one sample to an OpenMetrics family. labels are rendered
+// label pairs without braces (the instance label is added by the renderer).
+type metricEmitter func(family, typ, help, labels, value string)
+
+// statsRegistry holds an instance's collectors in registration order.
+type statsRegistry struct {
+	mu         sync.RWMutex
+	collectors []StatsCollector
+}
+
+// Register adds c, replacing any collector already registered under its name.
+func (r *statsRegistry) Register(c StatsCollector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.collectors {
+		if existing.Name() == c.Name() {
+			r.collectors[i] = c
+			return
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// Collectors returns a snapshot of the registered collectors.
+func (r *statsRegistry) Collectors() []StatsCollector {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]StatsCollector(nil), r.collectors...)
+}
+
+// StatsSchemaEntry describes one registered collector.
+type StatsSchemaEntry struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Schema lists the registered collectors.
+func (r *statsRegistry) Schema() []StatsSchemaEntry {
+	collectors := r.Collectors()
+	out := make([]StatsSchemaEntry, 0, len(collectors))
+	for _, c := range collectors {
+		out = append(out, StatsSchemaEntry{Name: c.Name(), Description: c.Description()})
+	}
+	return out
+}
+
+// collectStatsDoc decodes the upstream /stats document and adds one section
+// per registered collector. Upstream keys are left untouched so existing
+// consumers (Rust NetworkStats) keep parsing it unchanged. raw is the
+// undecoded upstream document; doc is nil if it could not be decoded.
+func collectStatsDoc(vn *virtualnetwork.VirtualNetwork, instance *GvproxyInstance) (doc map[string]any, raw string) {
+	raw = collectNetworkStats(vn)
+	if raw == "" {
+		return nil, raw
+	}
+
+	// UseNumber keeps uint64 counters exact across the decode/encode round trip.
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, raw
+	}
+
+	for _, c := range instance.stats.Collectors() {
+		doc[c.Name()] = c.Collect()
+	}
+	return doc, raw
+}
+
+// collectInstanceStats renders an instance's stats in the requested format
+// (StatsFormatJSON or StatsFormatOpenMetrics). Returns "" when stats are
+// unavailable or the format is unknown.
+func collectInstanceStats(vn *virtualnetwork.VirtualNetwork, instance *GvproxyInstance, format string) string {
+	doc, raw := collectStatsDoc(vn, instance)
+
+	switch format {
+	case StatsFormatJSON:
+		if doc == nil {
+			return raw
+		}
+		out, err := json.Marshal(doc)
+		if err != nil {
+			return raw
+		}
+		return string(out)
+	case StatsFormatOpenMetrics:
+		if doc == nil {
+			return ""
+		}
+		return renderOpenMetrics(instance.ID, doc, instance.stats.Collectors())
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+// stats_all.go — One stats document for every instance.
+//
+// A dashboard watching many VMs would otherwise call gvproxy_get_stats once
+// per instance, each a CGO round trip and a separate control-plane task.
+// gvproxy_get_all_stats collects every instance in one call. The instances
+// are collected concurrently, each on its own control-plane workers
+// (planes.go), so one slow instance does not hold up the rest.
+
+import "C"
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// gvproxy_get_all_stats returns a JSON object mapping each instance ID (as a
+// string) to its gvproxy_get_stats document. Instances whose network is not
+// ready yet are left out, so an empty object means none is. The string must
+// be freed with gvproxy_free_string.
+//
+//export gvproxy_get_all_stats
+func gvproxy_get_all_stats() *C.char {
+	out, err := json.Marshal(allInstanceStats())
+	if err != nil {
+		return nil
+	}
+	return returnCString(string(out))
+}
+
+// allInstanceStats collects the JSON stats document of every ready instance,
+// keyed by instance ID.
+func allInstanceStats() map[string]json.RawMessage {
+	instancesMu.RLock()
+	ids := make([]int64, 0, len(instances))
+	for id := range instances {
+		ids = append(ids, id)
+	}
+	instancesMu.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	docs := make([]string, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each instance is collected on its own control plane, so a
+			// slow one does not hold up the others.
+			docs[i] = instanceStats(C.longlong(id), StatsFormatJSON)
+		}()
+	}
+	wg.Wait()
+
+	all := make(map[string]json.RawMessage, len(ids))
+	for i, id := range ids {
+		if docs[i] != "" {
+			all[strconv.FormatInt(id, 10)] = json.RawMessage(docs[i])
+		}
+	}
+	return all
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllInstanceStats_KeyedByReadyInstance(t *testing.T) {
+	dir := t.TempDir()
+	ready := []int64{5900, 5901}
+	for i, id := range ready {
+		config := testGvproxyConfig()
+		config.SocketPath = filepath.Join(dir, []string{"a.sock", "b.sock"}[i])
+		config.Protocol = "qemu"
+		if err := startInstance(id, config); err != nil {
+			t.Fatalf("startInstance(%d): %v", id, err)
+		}
+		defer stopTestInstance(id)
+	}
+	// Registered but without a network yet: left out.
+	addFakeInstance(t, 5902, testGvproxyConfig())
+
+	all := allInstanceStats()
+	for _, key := range []string{"5900", "5901"} {
+		var doc map[string]any
+		if err := json.Unmarshal(all[key], &doc); err != nil {
+			t.Fatalf("instance %s: %v (%s)", key, err, all[key])
+		}
+		if _, ok := doc["BytesSent"]; !ok {
+			t.Fatalf("instance %s: not a stats document: %s", key, all[key])
+		}
+	}
+	if _, ok := all["5902"]; ok {
+		t.Fatal("instance without a network included")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+// stats_collectors.go — Collectors for subsystems owned by upstream or the
+// Go runtime (DNS, DHCP, packet capture, bridge runtime). Bridge subsystems
+// define their collectors next to their own code.
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+)
+
+// runtimeCollector publishes bridge process metrics as the "Runtime" section.
+// They are process-wide: every instance reports the same values.
+type runtimeCollector struct{}
+
+// RuntimeStats is the "Runtime" stats section.
+type RuntimeStats struct {
+	Goroutines     int    `json:"Goroutines"`
+	HeapAllocBytes uint64 `json:"HeapAllocBytes"`
+	SysBytes       uint64 `json:"SysBytes"`
+	NumGC          uint32 `json:"NumGC"`
+	CgoCalls       int64  `json:"CgoCalls"`
+	// OutstandingCStrings counts strings returned to the caller and not yet
+	// passed to gvproxy_free_string. Debug builds only.
+	OutstandingCStrings int `json:"OutstandingCStrings,omitempty"`
+}
+
+func (runtimeCollector) Name() string { return "Runtime" }
+
+func (runtimeCollector) Description() string {
+	return "Bridge process runtime: goroutines, heap, GC and cgo call counts (process-wide)."
+}
+
+func (runtimeCollector) Collect() any {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	return RuntimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: memStats.HeapAlloc,
+		SysBytes:       memStats.Sys,
+		NumGC:          memStats.NumGC,
+		CgoCalls:       runtime.NumCgoCall(),
+
+		OutstandingCStrings: cstrings.outstanding(),
+	}
+}
+
+func (c runtimeCollector) collectOpenMetrics(emit metricEmitter) {
+	stats := c.Collect().(RuntimeStats)
+	emit(openMetricsPrefix+"runtime_goroutines", "gauge", "Goroutines in the bridge process.", "", fmt.Sprint(stats.Goroutines))
+	emit(openMetricsPrefix+"runtime_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", "", fmt.Sprint(stats.HeapAllocBytes))
+	emit(openMetricsPrefix+"runtime_sys_bytes", "gauge", "Bytes of memory obtained from the OS.", "", fmt.Sprint(stats.SysBytes))
+	emit(openMetricsPrefix+"runtime_gc", "counter", "Completed GC cycles.", "", fmt.Sprint(stats.NumGC))
+	emit(openMetricsPrefix+"runtime_cgo_calls", "counter", "Cgo calls made by the bridge process.", "", fmt.Sprint(stats.CgoCalls))
+	if cstringTracking {
+		emit(openMetricsPrefix+"runtime_outstanding_cstrings", "gauge", "Strings returned to the caller and not yet freed.", "", fmt.Sprint(stats.OutstandingCStrings))
+	}
+}
+
+// dnsCollector publishes the size of the gateway's local DNS configuration,
+// the test faults it injected and its forwarding upstreams as the "DNS"
+// section.
+type dnsCollector struct{ dns *gatewayDNS }
+
+// DNSStats is the "DNS" stats section.
+type DNSStats struct {
+	Zones          int    `json:"Zones"`
+	Records        int    `json:"Records"`
+	FaultsInjected uint64 `json:"FaultsInjected"`
+
+	Upstreams []DNSUpstreamStats `json:"Upstreams"` // dns_upstreams.go
+}
+
+func (dnsCollector) Name() string { return "DNS" }
+
+func (dnsCollector) Description() string {
+	return "Local DNS zones and records served by the gateway (including allow_net sinkhole zones), test faults injected into its answers, and forwarded queries, errors and latency per upstream nameserver."
+}
+
+func (c dnsCollector) Collect() any {
+	zones, records := c.dns.counts()
+	return DNSStats{
+		Zones:          zones,
+		Records:        records,
+		FaultsInjected: c.dns.faults.injected.Load(),
+		Upstreams:      c.dns.upstreams.Stats(),
+	}
+}
+
+func (c dnsCollector) collectOpenMetrics(emit metricEmitter) {
+	stats := c.Collect().(DNSStats)
+	emit(openMetricsPrefix+"dns_zones", "gauge", "Local DNS zones served by the gateway.", "", fmt.Sprint(stats.Zones))
+	emit(openMetricsPrefix+"dns_records", "gauge", "Records in the gateway's local DNS zones.", "", fmt.Sprint(stats.Records))
+	emit(openMetricsPrefix+"dns_faults_injected", "counter", "Gateway DNS queries a test fault was injected into.", "", fmt.Sprint(stats.FaultsInjected))
+	collectDNSUpstreamsOpenMetrics(emit, stats.Upstreams)
+}
+
+// dhcpCollector publishes the DHCP lease table size as the "DHCP" section.
+type dhcpCollector struct {
+	vn *virtualnetwork.VirtualNetwork
+}
+
+// DHCPStats is the "DHCP" stats section.
+type DHCPStats struct {
+	Leases int `json:"Leases"`
+}
+
+func (dhcpCollector) Name() string { return "DHCP" }
+
+func (dhcpCollector) Description() string {
+	return "Addresses currently leased by the gateway's DHCP server."
+}
+
+func (c dhcpCollector) Collect() any {
+	return DHCPStats{Leases: len(vnLeases(c.vn))}
+}
+
+func (c dhcpCollector) collectOpenMetrics(emit metricEmitter) {
+	stats := c.Collect().(DHCPStats)
+	emit(openMetricsPrefix+"dhcp_leases", "gauge", "Addresses leased by the gateway's DHCP server.", "", fmt.Sprint(stats.Leases))
+}
+
+// captureCollector publishes packet capture state as the "Capture" section.
+// Registered only when a capture file is configured.
+type captureCollector struct{ capture *captureState }
+
+// CaptureStats is the "Capture" stats section.
+type CaptureStats struct {
+	File      string `json:"File"`
+	SizeBytes int64  `json:"SizeBytes"`
+	Status    string `json:"Status"`          // active, failed, retrying or stopped (capture_policy.go)
+	Error     string `json:"Error,omitempty"` // why capture_file could not be written
+}
+
+func (captureCollector) Name() string { return "Capture" }
+
+func (captureCollector) Description() string {
+	return "Packet capture file path, its current size, and whether it is being written."
+}
+
+func (c captureCollector) Collect() any {
+	status, err := c.capture.get()
+	stats := CaptureStats{File: c.capture.path, Status: status}
+	if err != nil {
+		stats.Error = err.Error()
+	}
+	if info, err := os.Stat(c.capture.path); err == nil {
+		stats.SizeBytes = info.Size()
+	}
+	return stats
+}
+
+func (c captureCollector) collectOpenMetrics(emit metricEmitter) {
+	stats := c.Collect().(CaptureStats)
+	emit(openMetricsPrefix+"capture_file_bytes", "gauge", "Size of the packet capture file.", "", fmt.Sprint(stats.SizeBytes))
+	active := 0
+	if stats.Status == captureStatusActive {
+		active = 1
+	}
+	emit(openMetricsPrefix+"capture_active", "gauge", "1 if the packet capture file is being written.", "", fmt.Sprint(active))
+}
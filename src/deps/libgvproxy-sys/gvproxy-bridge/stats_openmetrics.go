@@ -0,0 +1,199 @@
+package main
+
+// stats_openmetrics.go — OpenMetrics text rendering of the stats document.
+//
+// Callers with their own Prometheus exporter can serve this payload as-is
+// instead of walking the JSON. Upstream tcpip stats are all cumulative
+// StatCounters, so every plain numeric leaf becomes a counter named after its
+// JSON path (TCP.ResetsSent → gvproxy_tcp_resets_sent_total); collectors with
+// labelled, gauge- or histogram-valued sections render their own families.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Stats export formats accepted by gvproxy_get_stats_format.
+const (
+	StatsFormatJSON        = "json"
+	StatsFormatOpenMetrics = "openmetrics"
+)
+
+const openMetricsPrefix = "gvproxy_"
+
+// metricFamily is one OpenMetrics family with its samples.
+type metricFamily struct {
+	name    string // without the _total (or histogram sample) suffix
+	typ     string // "counter", "gauge" or "histogram"
+	help    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	suffix string // histogram samples: _bucket, _count or _sum
+	labels string // rendered label pairs, without braces
+	value  string
+}
+
+// histogramSuffixes are the sample suffixes of a histogram family. Collectors
+// emit histogram samples under their full name, e.g. x_bucket.
+var histogramSuffixes = []string{"_bucket", "_count", "_sum"}
+
+// histogramSeries returns a histogram sample's labels without its trailing
+// le label, so a series' buckets, count and sum sort together.
+func histogramSeries(labels string) string {
+	if i := strings.LastIndex(labels, `,le="`); i >= 0 {
+		return labels[:i]
+	}
+	return labels
+}
+
+// renderOpenMetrics renders a stats document (as built by collectStatsDoc)
+// for one instance. Collectors implementing openMetricsCollector render their
+// own section; every other section is walked generically.
+func renderOpenMetrics(instanceID int64, doc map[string]any, collectors []StatsCollector) string {
+	instanceLabel := fmt.Sprintf(`instance="%d"`, instanceID)
+	families := make(map[string]*metricFamily)
+	emit := func(name, typ, help, labels, value string) {
+		suffix := ""
+		if typ == "histogram" {
+			for _, sfx := range histogramSuffixes {
+				if strings.HasSuffix(name, sfx) {
+					name, suffix = strings.TrimSuffix(name, sfx), sfx
+					break
+				}
+			}
+		}
+		fam, ok := families[name]
+		if !ok {
+			fam = &metricFamily{name: name, typ: typ, help: help}
+			families[name] = fam
+		}
+		if labels != "" {
+			labels = instanceLabel + "," + labels
+		} else {
+			labels = instanceLabel
+		}
+		fam.samples = append(fam.samples, metricSample{suffix: suffix, labels: labels, value: value})
+	}
+
+	custom := make(map[string]bool)
+	for _, c := range collectors {
+		if oc, ok := c.(openMetricsCollector); ok {
+			oc.collectOpenMetrics(emit)
+			custom[c.Name()] = true
+		}
+	}
+
+	var walk func(path []string, v any)
+	walk = func(path []string, v any) {
+		switch val := v.(type) {
+		case map[string]any:
+			for k, child := range val {
+				walk(append(path[:len(path):len(path)], k), child)
+			}
+		case json.Number:
+			emit(counterName(path), "counter", "", "", val.String())
+		}
+	}
+	for key, v := range doc {
+		if custom[key] {
+			continue
+		}
+		walk([]string{key}, normalizeJSON(v))
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fam := families[name]
+		fmt.Fprintf(&b, "# TYPE %s %s\n", fam.name, fam.typ)
+		if fam.help != "" {
+			fmt.Fprintf(&b, "# HELP %s %s\n", fam.name, fam.help)
+		}
+		suffix := ""
+		if fam.typ == "counter" {
+			suffix = "_total"
+		}
+		if fam.typ == "histogram" {
+			// Keep each series' buckets in emission (ascending le) order.
+			sort.SliceStable(fam.samples, func(i, j int) bool {
+				return histogramSeries(fam.samples[i].labels) < histogramSeries(fam.samples[j].labels)
+			})
+		} else {
+			sort.Slice(fam.samples, func(i, j int) bool { return fam.samples[i].labels < fam.samples[j].labels })
+		}
+		for _, s := range fam.samples {
+			fmt.Fprintf(&b, "%s%s{%s} %s\n", fam.name, suffix+s.suffix, s.labels, s.value)
+		}
+	}
+	b.WriteString("# EOF\n")
+	return b.String()
+}
+
+// normalizeJSON converts a collector's Go value into the decoded-JSON shape
+// (maps, json.Number) the generic walk understands.
+func normalizeJSON(v any) any {
+	switch v.(type) {
+	case map[string]any, json.Number:
+		return v
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&out); err != nil {
+		return nil
+	}
+	return out
+}
+
+// counterName maps a JSON path to a metric family name.
+func counterName(path []string) string {
+	parts := make([]string, len(path))
+	for i, p := range path {
+		parts[i] = snakeCase(p)
+	}
+	return openMetricsPrefix + strings.Join(parts, "_")
+}
+
+// snakeCase converts upstream PascalCase keys (including acronyms such as
+// "TCP", "ICMPv4PacketsSent") to snake_case.
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// "v4" style version suffixes belong to the acronym before them.
+			versionSuffix := i+2 < len(runes) && runes[i+1] == 'v' && unicode.IsDigit(runes[i+2])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower && !versionSuffix) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
+
+// escapeLabelValue escapes a string for use inside a quoted label value.
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
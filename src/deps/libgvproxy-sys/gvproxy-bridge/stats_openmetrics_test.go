@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnakeCase(t *testing.T) {
+	cases := map[string]string{
+		"BytesSent":             "bytes_sent",
+		"TCP":                   "tcp",
+		"ICMPv4PacketsSent":     "icmpv4_packets_sent",
+		"TCPTimestampsStripped": "tcp_timestamps_stripped",
+		"MalformedRcvdPackets":  "malformed_rcvd_packets",
+	}
+	for in, want := range cases {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRenderOpenMetrics(t *testing.T) {
+	var doc map[string]any
+	dec := json.NewDecoder(strings.NewReader(`{"BytesSent": 18446744073709551615, "TCP": {"ResetsSent": 3}}`))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	stripper := &tcpTimestampStripper{}
+	stripper.stripped.Store(4)
+	milestones := newInstanceMilestones(7, "192.168.127.1")
+	milestones.reached[MilestoneDHCPComplete] = 1500 * time.Millisecond
+	collectors := []StatsCollector{tcpTimestampsCollector{stripper}, milestonesCollector{milestones}}
+	for _, c := range collectors {
+		doc[c.Name()] = c.Collect()
+	}
+
+	want := `# TYPE gvproxy_bytes_sent counter
+gvproxy_bytes_sent_total{instance="7"} 18446744073709551615
+# TYPE gvproxy_milestone_seconds gauge
+# HELP gvproxy_milestone_seconds Time from instance creation to a networking milestone.
+gvproxy_milestone_seconds{instance="7",milestone="dhcp_complete"} 1.5
+# TYPE gvproxy_tcp_resets_sent counter
+gvproxy_tcp_resets_sent_total{instance="7"} 3
+# TYPE gvproxy_tcp_timestamps_stripped counter
+gvproxy_tcp_timestamps_stripped_total{instance="7"} 4
+# EOF
+`
+	if got := renderOpenMetrics(7, doc, collectors); got != want {
+		t.Fatalf("unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+// stats_page.go — Bounded stats documents for large instances.
+//
+// gvproxy_get_stats decodes the upstream document, adds every section and
+// encodes the lot in one piece, so its cost and size grow with the longest
+// list in it (one entry per published port, per upstream proxy rule, ...).
+// On an instance with thousands of entries that stalls the control plane
+// and hands the embedder a document it then has to parse whole.
+// gvproxy_get_stats_page bounds both:
+//
+//   - sections picks the top-level sections to return, upstream or bridge;
+//     bridge sections left out are not collected at all;
+//   - list sections are paged: entries from offset on, at most limit of
+//     them. "Pagination" reports each list's total, how many entries were
+//     returned and, while entries remain, the offset to ask for next. The
+//     pages also carry "Connections", the relay connection table of
+//     gvproxy_list_connections (forward_connections.go), which
+//     gvproxy_get_stats leaves out: it has an entry per open connection;
+//   - the document is streamed into a buffer of max_bytes, section by
+//     section and list entry by list entry. A list that runs out of room
+//     ends early (the next page picks up where it stopped); any other
+//     section that does not fit is left out and named in "Omitted". The
+//     Pagination and Omitted trailer comes on top of max_bytes.
+
+import "C"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultStatsPageLimit is the page size when the query sets none.
+	defaultStatsPageLimit = 1000
+	// defaultStatsPageBytes bounds the document when the query sets none.
+	defaultStatsPageBytes = 4 << 20
+)
+
+// StatsQuery is the query document of gvproxy_get_stats_page.
+type StatsQuery struct {
+	// Sections to include by top-level key; empty => all.
+	Sections []string `json:"sections,omitempty"`
+	// Offset is the first list entry returned, in every list section.
+	Offset int `json:"offset,omitempty"`
+	// Limit caps the entries returned per list section. 0 => 1000.
+	Limit int `json:"limit,omitempty"`
+	// MaxBytes caps the encoded sections. 0 => 4 MiB.
+	MaxBytes int `json:"max_bytes,omitempty"`
+}
+
+// StatsPage describes the page of one list section.
+type StatsPage struct {
+	Total      int  `json:"Total"`
+	Offset     int  `json:"Offset"`
+	Count      int  `json:"Count"`
+	NextOffset *int `json:"NextOffset,omitempty"` // set while entries remain
+}
+
+// parseStatsQuery decodes a query and fills in its defaults. NULL and ""
+// are the default query.
+func parseStatsQuery(s string) (StatsQuery, error) {
+	var q StatsQuery
+	if strings.TrimSpace(s) != "" {
+		if err := json.Unmarshal([]byte(s), &q); err != nil {
+			return q, err
+		}
+	}
+	if q.Offset < 0 || q.Limit < 0 || q.MaxBytes < 0 {
+		return q, fmt.Errorf("offset, limit and max_bytes must not be negative")
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultStatsPageLimit
+	}
+	if q.MaxBytes == 0 {
+		q.MaxBytes = defaultStatsPageBytes
+	}
+	return q, nil
+}
+
+// statsPageWriter streams a stats document into a buffer of bounded size.
+type statsPageWriter struct {
+	buf      bytes.Buffer
+	max      int
+	sections int
+}
+
+// fits reports whether n more bytes, and the closing brace, stay within
+// the bound.
+func (w *statsPageWriter) fits(n int) bool {
+	return w.buf.Len()+n+1 <= w.max
+}
+
+// key returns the encoded `"name":` prefix of a section, with its comma.
+func (w *statsPageWriter) key(name string) []byte {
+	k, _ := json.Marshal(name)
+	if w.sections > 0 {
+		k = append([]byte{','}, k...)
+	}
+	return append(k, ':')
+}
+
+// section writes name: value if it fits, and reports whether it did.
+func (w *statsPageWriter) section(name string, value []byte) bool {
+	if !w.fits(len(w.key(name)) + len(value)) {
+		return false
+	}
+	w.put(name, value)
+	return true
+}
+
+// put writes name: value regardless of the bound.
+func (w *statsPageWriter) put(name string, value []byte) {
+	w.buf.Write(w.key(name))
+	w.buf.Write(value)
+	w.sections++
+}
+
+// list writes name: the page of list selected by q, entry by entry until
+// the bound is reached. It reports the page, or false if not even the
+// empty list fits.
+func (w *statsPageWriter) list(name string, list reflect.Value, q StatsQuery) (StatsPage, bool) {
+	page := StatsPage{Total: list.Len(), Offset: min(q.Offset, list.Len())}
+	k := w.key(name)
+	if !w.fits(len(k) + 2) {
+		return page, false
+	}
+	w.buf.Write(k)
+	w.buf.WriteByte('[')
+	w.sections++
+	for i := page.Offset; i < page.Total && page.Count < q.Limit; i++ {
+		entry, err := json.Marshal(list.Index(i).Interface())
+		if err != nil {
+			break
+		}
+		sep := 0
+		if page.Count > 0 {
+			sep = 1
+		}
+		// Leave room for the closing bracket.
+		if !w.fits(sep + len(entry) + 1) {
+			break
+		}
+		if sep > 0 {
+			w.buf.WriteByte(',')
+		}
+		w.buf.Write(entry)
+		page.Count++
+	}
+	w.buf.WriteByte(']')
+	if next := page.Offset + page.Count; next < page.Total {
+		page.NextOffset = &next
+	}
+	return page, true
+}
+
+// renderStatsPage streams the sections selected by q from the undecoded
+// upstream document and the instance's collectors (see stats_page.go).
+func renderStatsPage(upstream string, collectors []StatsCollector, q StatsQuery) (string, error) {
+	var upstreamSections map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(upstream), &upstreamSections); err != nil {
+		return "", fmt.Errorf("decode upstream stats: %w", err)
+	}
+	wanted := func(string) bool { return true }
+	if len(q.Sections) > 0 {
+		set := make(map[string]bool, len(q.Sections))
+		for _, s := range q.Sections {
+			set[s] = true
+		}
+		wanted = func(name string) bool { return set[name] }
+	}
+
+	w := &statsPageWriter{max: q.MaxBytes}
+	w.buf.WriteByte('{')
+	pages := make(map[string]StatsPage)
+	var omitted []string
+
+	names := make([]string, 0, len(upstreamSections))
+	for name := range upstreamSections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if wanted(name) && !w.section(name, upstreamSections[name]) {
+			omitted = append(omitted, name)
+		}
+	}
+
+	for _, c := range collectors {
+		name := c.Name()
+		if _, dup := upstreamSections[name]; dup || !wanted(name) {
+			continue
+		}
+		v := c.Collect()
+		if value := reflect.ValueOf(v); value.Kind() == reflect.Slice {
+			if page, ok := w.list(name, value, q); ok {
+				pages[name] = page
+			} else {
+				omitted = append(omitted, name)
+			}
+			continue
+		}
+		encoded, err := json.Marshal(v)
+		if err != nil || !w.section(name, encoded) {
+			omitted = append(omitted, name)
+		}
+	}
+
+	// The trailer is not bounded by max_bytes: without it a page cut short
+	// could not be told from a complete one.
+	if len(pages) > 0 {
+		encoded, _ := json.Marshal(pages)
+		w.put("Pagination", encoded)
+	}
+	if len(omitted) > 0 {
+		encoded, _ := json.Marshal(omitted)
+		w.put("Omitted", encoded)
+	}
+	w.buf.WriteByte('}')
+	return w.buf.String(), nil
+}
+
+// gvproxy_get_stats_page returns the gvproxy_get_stats document of an
+// instance bounded by a query (see stats_page.go):
+//
+//	{"sections": ["Forwards"], "offset": 0, "limit": 100, "max_bytes": 65536}
+//
+// All fields are optional; NULL is the default query. List sections,
+// including the "Connections" table that gvproxy_get_stats leaves out, carry
+// their page in "Pagination", e.g. {"Forwards": {"Total": 2500, "Offset": 0,
+// "Count": 100, "NextOffset": 100}}; sections that did not fit are listed
+// in "Omitted".
+//
+// Returns NULL if the instance does not exist or is not ready, or the query
+// is invalid. The string must be freed with gvproxy_free_string.
+//
+//export gvproxy_get_stats_page
+func gvproxy_get_stats_page(id C.longlong, queryJSON *C.char) *C.char {
+	var query string
+	if queryJSON != nil {
+		query = C.GoString(queryJSON)
+	}
+	out, err := instanceStatsPage(int64(id), query)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id)}).Warn("Failed to collect stats page")
+		return nil
+	}
+	if out == "" {
+		return nil
+	}
+	return returnCString(out)
+}
+
+// instanceStatsPage renders a stats page of instance id on the control plane
+// (planes.go), or returns "" if the instance does not exist or is not ready.
+func instanceStatsPage(id int64, query string) (string, error) {
+	q, err := parseStatsQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid stats query: %w", err)
+	}
+
+	instancesMu.RLock()
+	instance, ok := instances[id]
+	instancesMu.RUnlock()
+	if !ok {
+		return "", nil
+	}
+	instance.vnMu.RLock()
+	vn := instance.vn
+	instance.vnMu.RUnlock()
+	if vn == nil {
+		return "", nil
+	}
+
+	collectors := instance.stats.Collectors()
+	if instance.forwarder != nil {
+		collectors = append(collectors, connectionsCollector{instance.forwarder})
+	}
+	var out string
+	instance.control.run(func() {
+		out, err = renderStatsPage(collectNetworkStats(vn), collectors, q)
+	})
+	return out, err
+}
+
+// connectionsCollector pages the relay connection table as the
+// "Connections" list section. Only stats pages include it.
+type connectionsCollector struct{ f *PortForwarder }
+
+func (connectionsCollector) Name() string { return "Connections" }
+
+func (connectionsCollector) Description() string {
+	return "Connections the published ports are relaying, as listed by gvproxy_list_connections."
+}
+
+func (c connectionsCollector) Collect() any { return c.f.Connections() }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testUpstreamStats = `{"BytesSent":10,"TCP":{"ActiveConnectionOpenings":2}}`
+
+func statsPage(t *testing.T, query string, collectors ...StatsCollector) map[string]json.RawMessage {
+	t.Helper()
+	q, err := parseStatsQuery(query)
+	if err != nil {
+		t.Fatalf("parseStatsQuery(%s): %v", query, err)
+	}
+	out, err := renderStatsPage(testUpstreamStats, collectors, q)
+	if err != nil {
+		t.Fatalf("renderStatsPage: %v", err)
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("page is not JSON: %v\n%s", err, out)
+	}
+	return doc
+}
+
+func TestStatsPage_PagesLists(t *testing.T) {
+	items := fakeCollector{name: "Items", value: []int{0, 1, 2, 3, 4}}
+	obj := fakeCollector{name: "Obj", value: map[string]int{"a": 1}}
+
+	doc := statsPage(t, "", items, obj)
+	for _, key := range []string{"BytesSent", "TCP", "Items", "Obj", "Pagination"} {
+		if _, ok := doc[key]; !ok {
+			t.Errorf("default page lacks %s", key)
+		}
+	}
+
+	doc = statsPage(t, `{"sections":["Items"],"offset":1,"limit":2}`, items, obj)
+	if string(doc["Items"]) != "[1,2]" {
+		t.Fatalf("Items = %s, want [1,2]", doc["Items"])
+	}
+	if _, ok := doc["BytesSent"]; ok || doc["Obj"] != nil {
+		t.Fatal("sections not asked for were returned")
+	}
+	var pages map[string]StatsPage
+	json.Unmarshal(doc["Pagination"], &pages) //nolint:errcheck
+	next := 3
+	if want := (StatsPage{Total: 5, Offset: 1, Count: 2, NextOffset: &next}); !reflect.DeepEqual(pages["Items"], want) {
+		t.Fatalf("page = %+v, want %+v", pages["Items"], want)
+	}
+
+	doc = statsPage(t, `{"offset":4}`, items)
+	json.Unmarshal(doc["Pagination"], &pages) //nolint:errcheck
+	if string(doc["Items"]) != "[4]" || pages["Items"].NextOffset != nil {
+		t.Fatalf("last page: %s %+v", doc["Items"], pages["Items"])
+	}
+}
+
+func TestStatsPage_MaxBytes(t *testing.T) {
+	items := fakeCollector{name: "Items", value: []string{"aaaaaaaa", "bbbbbbbb", "cccccccc"}}
+	big := fakeCollector{name: "Big", value: strings.Repeat("x", 100)}
+
+	doc := statsPage(t, `{"sections":["Items","Big"],"max_bytes":40}`, items, big)
+	var pages map[string]StatsPage
+	json.Unmarshal(doc["Pagination"], &pages) //nolint:errcheck
+	if p := pages["Items"]; p.Count == 0 || p.Count == 3 || p.NextOffset == nil || *p.NextOffset != p.Count {
+		t.Fatalf("Items page under 40 bytes = %+v, want a partial page", p)
+	}
+	var omitted []string
+	json.Unmarshal(doc["Omitted"], &omitted) //nolint:errcheck
+	if !reflect.DeepEqual(omitted, []string{"Big"}) {
+		t.Fatalf("Omitted = %v, want [Big]", omitted)
+	}
+}
+
+func TestParseStatsQuery(t *testing.T) {
+	q, err := parseStatsQuery("")
+	if err != nil || q.Limit != defaultStatsPageLimit || q.MaxBytes != defaultStatsPageBytes {
+		t.Fatalf("default query = %+v, %v", q, err)
+	}
+	if _, err := parseStatsQuery(`{"offset":-1}`); err == nil {
+		t.Fatal("negative offset must be rejected")
+	}
+}
+
+func TestGetStatsPage(t *testing.T) {
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	const id = 5919
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	// The network comes up just after startInstance returns.
+	var out string
+	for deadline := time.Now().Add(5 * time.Second); out == "" && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var err error
+		if out, err = instanceStatsPage(id, `{"sections":["Forwards","BytesSent"]}`); err != nil {
+			t.Fatalf("instanceStatsPage: %v", err)
+		}
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("page is not JSON: %v\n%s", err, out)
+	}
+	if len(doc) != 3 || doc["Forwards"] == nil || doc["BytesSent"] == nil || doc["Pagination"] == nil {
+		t.Fatalf("page = %s", out)
+	}
+	if strings.Contains(instanceStats(id, StatsFormatJSON), `"Connections":`) {
+		t.Fatal("gvproxy_get_stats must not carry the connection table")
+	}
+	out, err := instanceStatsPage(id, `{"sections":["Connections"],"limit":10}`)
+	if err != nil {
+		t.Fatalf("instanceStatsPage: %v", err)
+	}
+	doc = nil
+	json.Unmarshal([]byte(out), &doc) //nolint:errcheck
+	var pages map[string]StatsPage
+	json.Unmarshal(doc["Pagination"], &pages) //nolint:errcheck
+	if string(doc["Connections"]) != "[]" || pages["Connections"].Total != 0 {
+		t.Fatalf("connections page = %s", out)
+	}
+	if _, err := instanceStatsPage(id, `{"limit":-1}`); err == nil {
+		t.Fatal("invalid query must fail")
+	}
+}
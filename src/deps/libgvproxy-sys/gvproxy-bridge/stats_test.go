@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type fakeCollector struct {
+	name  string
+	value any
+}
+
+func (c fakeCollector) Name() string        { return c.name }
+func (c fakeCollector) Description() string { return "fake " + c.name }
+func (c fakeCollector) Collect() any        { return c.value }
+
+func TestStatsRegistry_RegisterReplacesByName(t *testing.T) {
+	r := &statsRegistry{}
+	r.Register(fakeCollector{name: "A", value: 1})
+	r.Register(fakeCollector{name: "B", value: 2})
+	r.Register(fakeCollector{name: "A", value: 3})
+
+	collectors := r.Collectors()
+	if len(collectors) != 2 {
+		t.Fatalf("expected 2 collectors, got %d", len(collectors))
+	}
+	if collectors[0].Name() != "A" || collectors[0].Collect() != 3 {
+		t.Fatalf("re-registering A must replace it in place, got %v", collectors[0])
+	}
+
+	want := []StatsSchemaEntry{{Name: "A", Description: "fake A"}, {Name: "B", Description: "fake B"}}
+	if got := r.Schema(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Schema() = %v, want %v", got, want)
+	}
+}
+
+func TestDNSCollector_CountsZonesAndRecords(t *testing.T) {
+	gwDNS, err := newGatewayDNS(GvproxyConfig{
+		DNSZones: []DNSZone{{Name: "local.", Records: []DNSRecord{
+			{Name: "a", IP: "10.0.0.1"},
+			{Name: "b", IP: "10.0.0.2"},
+			{Name: "_svc._tcp", Type: "SRV", Target: "a.local.", Port: 80},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := dnsCollector{gwDNS}.Collect()
+	if want := (DNSStats{Zones: 1, Records: 3, Upstreams: []DNSUpstreamStats{}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected DNS stats: %+v", got)
+	}
+}
+
+func TestFillStatsBuffer(t *testing.T) {
+	const doc = `{"BytesSent":1}`
+
+	if need := fillStatsBuffer(nil, doc); need != len(doc)+1 {
+		t.Fatalf("size query: got %d, want %d", need, len(doc)+1)
+	}
+
+	short := []byte("untouched")
+	if need := fillStatsBuffer(short, doc); need != len(doc)+1 || string(short) != "untouched" {
+		t.Fatalf("too-small buffer must be left as is: need=%d buf=%q", need, short)
+	}
+
+	buf := make([]byte, len(doc)+1)
+	if need := fillStatsBuffer(buf, doc); need != len(buf) {
+		t.Fatalf("exact fit: got %d", need)
+	}
+	if string(buf[:len(doc)]) != doc || buf[len(doc)] != 0 {
+		t.Fatalf("expected NUL-terminated document, got %q", buf)
+	}
+}
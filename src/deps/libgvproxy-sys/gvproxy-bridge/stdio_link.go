@@ -0,0 +1,120 @@
+package main
+
+// stdio_link.go — Carry the VM link over inherited pipes.
+//
+// A supervisor that embeds the bridge and also starts the VMM can connect
+// the two with a pipe pair (or a socketpair) instead of a filesystem socket.
+// With protocol "stdio", stdio_fds names the FD the VM's frames are read
+// from and the FD frames to the VM are written to; both may be the same FD.
+// Frames carry a 16-bit little-endian length prefix, like upstream gvproxy's
+// stdio mode.
+//
+// The instance owns the FDs from gvproxy_create on: they are closed when it
+// is destroyed, and also when the create fails. socket_path is neither
+// needed nor created. There is nothing to accept again, so once the VM side
+// closes its ends the link is gone for good; stdio links cannot be handed
+// over by gvproxy_serialize_all.
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+// StdioFDs are the inherited FDs of a stdio link.
+type StdioFDs struct {
+	ReadFD  int `json:"read_fd"`  // frames from the VM
+	WriteFD int `json:"write_fd"` // frames to the VM
+}
+
+// checkStdioFDs validates stdio_fds against the link protocol.
+func checkStdioFDs(config GvproxyConfig, protocol types.Protocol) error {
+	switch {
+	case protocol == types.StdioProtocol && config.StdioFDs == nil:
+		return fmt.Errorf("protocol stdio needs stdio_fds")
+	case protocol != types.StdioProtocol && config.StdioFDs != nil:
+		return fmt.Errorf("stdio_fds needs protocol stdio")
+	case config.StdioFDs != nil && (config.StdioFDs.ReadFD < 0 || config.StdioFDs.WriteFD < 0):
+		return fmt.Errorf("invalid stdio_fds %d/%d", config.StdioFDs.ReadFD, config.StdioFDs.WriteFD)
+	}
+	return nil
+}
+
+// closeStdioFDs closes FDs the instance took over but never wrapped.
+func closeStdioFDs(fds *StdioFDs) {
+	if fds == nil {
+		return
+	}
+	closeFD(fds.ReadFD)
+	if fds.WriteFD != fds.ReadFD {
+		closeFD(fds.WriteFD)
+	}
+}
+
+// openStdioLink wraps the FDs as the VM connection. They are switched to
+// non-blocking mode so deadlines work and Close interrupts a pending read.
+func openStdioLink(fds StdioFDs) (net.Conn, error) {
+	open := func(fd int, name string) (*os.File, error) {
+		if err := setNonblock(fd); err != nil {
+			return nil, fmt.Errorf("stdio_fds %s %d: %w", name, fd, err)
+		}
+		return os.NewFile(uintptr(fd), fmt.Sprintf("stdio-%s-%d", name, fd)), nil
+	}
+	r, err := open(fds.ReadFD, "read")
+	if err != nil {
+		return nil, err
+	}
+	w := r
+	if fds.WriteFD != fds.ReadFD {
+		if w, err = open(fds.WriteFD, "write"); err != nil {
+			return nil, err
+		}
+	}
+	return &stdioConn{r: r, w: w, fds: fds}, nil
+}
+
+// stdioConn is a net.Conn over a read FD and a write FD.
+type stdioConn struct {
+	r, w      *os.File
+	fds       StdioFDs
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *stdioConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *stdioConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+func (c *stdioConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.r.Close()
+		if c.w != c.r {
+			if err := c.w.Close(); c.closeErr == nil {
+				c.closeErr = err
+			}
+		}
+	})
+	return c.closeErr
+}
+
+func (c *stdioConn) LocalAddr() net.Addr  { return stdioAddr(fmt.Sprintf("fd:%d", c.fds.WriteFD)) }
+func (c *stdioConn) RemoteAddr() net.Addr { return stdioAddr(fmt.Sprintf("fd:%d", c.fds.ReadFD)) }
+
+func (c *stdioConn) SetDeadline(t time.Time) error {
+	if err := c.r.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.w.SetWriteDeadline(t)
+}
+
+func (c *stdioConn) SetReadDeadline(t time.Time) error  { return c.r.SetReadDeadline(t) }
+func (c *stdioConn) SetWriteDeadline(t time.Time) error { return c.w.SetWriteDeadline(t) }
+
+// stdioAddr names one end of a stdio link.
+type stdioAddr string
+
+func (stdioAddr) Network() string  { return "stdio" }
+func (a stdioAddr) String() string { return string(a) }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestCheckStdioFDs(t *testing.T) {
+	config := testGvproxyConfig()
+	if err := checkStdioFDs(config, types.StdioProtocol); err == nil || !strings.Contains(err.Error(), "needs stdio_fds") {
+		t.Errorf("stdio without FDs: err = %v", err)
+	}
+	config.StdioFDs = &StdioFDs{ReadFD: 3, WriteFD: 4}
+	if err := checkStdioFDs(config, types.QemuProtocol); err == nil || !strings.Contains(err.Error(), "needs protocol stdio") {
+		t.Errorf("FDs without stdio: err = %v", err)
+	}
+	if err := checkStdioFDs(config, types.StdioProtocol); err != nil {
+		t.Errorf("valid config: %v", err)
+	}
+	config.StdioFDs.WriteFD = -1
+	if err := checkStdioFDs(config, types.StdioProtocol); err == nil {
+		t.Error("expected an error for a negative FD")
+	}
+}
+
+// pipe returns the read and write FDs of a new pipe.
+func pipe(t *testing.T) (r, w int) {
+	t.Helper()
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	return fds[0], fds[1]
+}
+
+func TestStartInstance_StdioLink(t *testing.T) {
+	fromVMRead, fromVMWrite := pipe(t)
+	toVMRead, toVMWrite := pipe(t)
+	vmOut := os.NewFile(uintptr(fromVMWrite), "vm-out")
+	vmIn := os.NewFile(uintptr(toVMRead), "vm-in")
+	defer vmOut.Close()
+	defer vmIn.Close()
+
+	config := testGvproxyConfig()
+	config.Protocol = "stdio"
+	config.StdioFDs = &StdioFDs{ReadFD: fromVMRead, WriteFD: toVMWrite}
+	const id = 5703
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	waitForLink(t, id)
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	request := arpRequestFrame(mustMAC(config.GuestMac), broadcast, net.ParseIP(config.GuestIP).To4(), net.ParseIP(config.GatewayIP).To4())
+	framed := binary.LittleEndian.AppendUint16(nil, uint16(len(request)))
+	if _, err := vmOut.Write(append(framed, request...)); err != nil {
+		t.Fatalf("write ARP request: %v", err)
+	}
+
+	vmIn.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	for {
+		prefix := make([]byte, 2)
+		if _, err := io.ReadFull(vmIn, prefix); err != nil {
+			t.Fatalf("read prefix: %v", err)
+		}
+		frame := make([]byte, binary.LittleEndian.Uint16(prefix))
+		if _, err := io.ReadFull(vmIn, frame); err != nil {
+			t.Fatalf("read frame: %v", err)
+		}
+		if arp := frameARP(frame); arp != nil && arp.Op() == header.ARPReply {
+			break
+		}
+	}
+
+	// Destroying the instance closes its ends of the pipes.
+	stopTestInstance(id)
+	vmIn.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	if _, err := io.Copy(io.Discard, vmIn); err != nil {
+		t.Fatalf("VM side not closed on destroy: %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+// stream_sink.go — Deliver logs and events over a socket or named pipe.
+//
+// The log and event callbacks are function pointers into the embedder, which
+// only exist when the bridge is linked into it. When the bridge runs as a
+// helper process, or the embedder cannot hand out a callback, the same
+// documents can go to a stream instead: gvproxy_set_stream_sink connects to a
+// Unix socket (any OS) or a named pipe (Windows) the embedder listens on and
+// writes one JSON object per line:
+//
+//	{"log": {"level": "info", "msg": "...", "time": "...", ...}}
+//	{"event": {...}}     (the gvproxy_set_event_callback document)
+//	{"dropped": 12}      (lines lost since the last line written)
+//
+// Writes happen on a sink goroutine behind a bounded queue, so a slow or
+// absent reader never blocks the network; when the queue is full, or the
+// stream is down, lines are dropped and counted. A broken stream is redialed
+// on the next line, at most once per streamSinkRedialInterval. The sink runs
+// alongside the callbacks and the log file sink.
+
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+const (
+	// streamSinkQueueSize bounds the lines waiting for the stream.
+	streamSinkQueueSize = 1024
+	// streamSinkDialTimeout bounds connecting to the stream.
+	streamSinkDialTimeout = 2 * time.Second
+)
+
+// streamSinkRedialInterval spaces attempts to reconnect a broken stream.
+var streamSinkRedialInterval = time.Second
+
+// StreamSinkConfig is the JSON document passed to gvproxy_set_stream_sink.
+type StreamSinkConfig struct {
+	// Address is "unix:<path>" or, on Windows, "npipe:<pipe path>" (e.g.
+	// "npipe:\\.\pipe\boxlite-gvproxy"). Empty removes the sink.
+	Address string `json:"address"`
+	Logs    bool   `json:"logs,omitempty"`   // deliver log entries
+	Events  bool   `json:"events,omitempty"` // deliver events
+	Level   string `json:"level,omitempty"`  // logrus level name for logs; default "info"
+}
+
+// streamSinkLine is one line written to the stream.
+type streamSinkLine struct {
+	Log     json.RawMessage `json:"log,omitempty"`
+	Event   *Event          `json:"event,omitempty"`
+	Dropped uint64          `json:"dropped,omitempty"`
+}
+
+// streamSink writes lines to one stream address.
+type streamSink struct {
+	network, path string
+	logs, events  bool
+	level         logrus.Level
+
+	queue   chan []byte
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+var (
+	currentStreamSink atomic.Pointer[streamSink]
+	streamSinkMu      sync.Mutex // serializes setStreamSink
+	streamSinkOnce    sync.Once
+
+	// streamSinkLevel is the level the stream sink needs for logs, guarded
+	// by logFileMu with the other sink levels (log_file.go).
+	streamSinkLevel = logrus.PanicLevel
+)
+
+// parseStreamSinkAddress splits address into a network and a path.
+func parseStreamSinkAddress(address string) (network, path string, err error) {
+	network, path, ok := strings.Cut(address, ":")
+	if !ok || path == "" {
+		return "", "", fmt.Errorf("stream sink address %q: want unix:<path> or npipe:<path>", address)
+	}
+	switch network {
+	case "unix":
+	case "npipe":
+		if !namedPipesSupported {
+			return "", "", fmt.Errorf("stream sink address %q: named pipes are only supported on Windows", address)
+		}
+	default:
+		return "", "", fmt.Errorf("stream sink address %q: unknown scheme %q", address, network)
+	}
+	return network, path, nil
+}
+
+// setStreamSink installs the sink described by cfg, replacing any previous
+// one. The stream is dialed once up front so a bad address fails here. An
+// empty address removes the sink.
+func setStreamSink(cfg StreamSinkConfig) error {
+	streamSinkMu.Lock()
+	defer streamSinkMu.Unlock()
+
+	var s *streamSink
+	if cfg.Address != "" {
+		network, path, err := parseStreamSinkAddress(cfg.Address)
+		if err != nil {
+			return err
+		}
+		level := logrus.InfoLevel
+		if cfg.Level != "" {
+			if level, err = logrus.ParseLevel(cfg.Level); err != nil {
+				return err
+			}
+		}
+		conn, err := dialStreamSink(network, path)
+		if err != nil {
+			return err
+		}
+		s = &streamSink{
+			network: network,
+			path:    path,
+			logs:    cfg.Logs,
+			events:  cfg.Events,
+			level:   level,
+			queue:   make(chan []byte, streamSinkQueueSize),
+			done:    make(chan struct{}),
+		}
+		go s.run(conn)
+	}
+
+	streamSinkOnce.Do(func() { logrus.AddHook(streamSinkHook{formatter: &logrus.JSONFormatter{}}) })
+
+	logFileMu.Lock()
+	streamSinkLevel = logrus.PanicLevel
+	if s != nil && s.logs {
+		streamSinkLevel = s.level
+	}
+	applyLogLevelLocked()
+	logFileMu.Unlock()
+
+	if prev := currentStreamSink.Swap(s); prev != nil {
+		close(prev.done)
+	}
+	return nil
+}
+
+// send queues a line, dropping it if the queue is full.
+func (s *streamSink) send(line streamSinkLine) {
+	data, err := json.Marshal(line)
+	if err != nil {
+		s.dropped.Add(1)
+		return
+	}
+	select {
+	case s.queue <- append(data, '\n'):
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// run writes queued lines to conn until the sink is replaced, redialing
+// after a failed write. It never logs: its own entries would loop back into
+// the queue.
+func (s *streamSink) run(conn net.Conn) {
+	var lastDial time.Time
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		var data []byte
+		select {
+		case <-s.done:
+			return
+		case data = <-s.queue:
+		}
+		if conn == nil {
+			if time.Since(lastDial) < streamSinkRedialInterval {
+				s.dropped.Add(1)
+				continue
+			}
+			lastDial = time.Now()
+			var err error
+			if conn, err = dialStreamSink(s.network, s.path); err != nil {
+				conn = nil
+				s.dropped.Add(1)
+				continue
+			}
+		}
+		if n := s.dropped.Swap(0); n > 0 {
+			report, _ := json.Marshal(streamSinkLine{Dropped: n})
+			data = append(append(report, '\n'), data...)
+		}
+		if _, err := conn.Write(data); err != nil {
+			conn.Close()
+			conn = nil
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// streamSinkEvent delivers event to the stream sink, if it takes events.
+func streamSinkEvent(event Event) {
+	if s := currentStreamSink.Load(); s != nil && s.events {
+		s.send(streamSinkLine{Event: &event})
+	}
+}
+
+// streamSinkHook delivers log entries to the stream sink.
+type streamSinkHook struct {
+	formatter logrus.Formatter
+}
+
+func (streamSinkHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h streamSinkHook) Fire(entry *logrus.Entry) error {
+	s := currentStreamSink.Load()
+	if s == nil || !s.logs || entry.Level > s.level {
+		return nil
+	}
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	s.send(streamSinkLine{Log: json.RawMessage(strings.TrimSuffix(string(line), "\n"))})
+	return nil
+}
+
+// gvproxy_set_stream_sink connects (or replaces) a stream sink for logs and
+// events, for embedders that cannot take callbacks: {"address":
+// "unix:/run/boxlite/gvproxy.sock", "logs": true, "events": true, "level":
+// "debug"}. On Windows the address may be a named pipe ("npipe:\\.\pipe\x").
+// The embedder must be listening; lines are JSON objects as described in
+// stream_sink.go. An empty address or NULL removes the sink.
+//
+// Returns 0 on success, -1 if the config is invalid or the stream cannot be
+// connected (the previous sink, if any, is kept).
+//
+//export gvproxy_set_stream_sink
+func gvproxy_set_stream_sink(configJSON *C.char) C.int {
+	var cfg StreamSinkConfig
+	if configJSON != nil {
+		if err := json.Unmarshal([]byte(C.GoString(configJSON)), &cfg); err != nil {
+			logrus.WithError(err).Error("Failed to parse stream sink config")
+			return -1
+		}
+	}
+	if err := setStreamSink(cfg); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "address": cfg.Address}).Error("Failed to set stream sink")
+		return -1
+	}
+	return 0
+}
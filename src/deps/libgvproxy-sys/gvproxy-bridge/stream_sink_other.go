@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import "net"
+
+// namedPipesSupported reports whether stream sinks may use npipe addresses.
+const namedPipesSupported = false
+
+// dialStreamSink connects to a stream sink address.
+func dialStreamSink(network, path string) (net.Conn, error) {
+	return net.DialTimeout(network, path, streamSinkDialTimeout)
+}
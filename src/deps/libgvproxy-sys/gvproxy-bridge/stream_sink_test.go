@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// listenStreamSink listens on a Unix socket and returns its address and the
+// lines written by the first connection.
+func listenStreamSink(t *testing.T) (string, <-chan streamSinkLine) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sink.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	lines := make(chan streamSinkLine, 64)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			var line streamSinkLine
+			if json.Unmarshal(sc.Bytes(), &line) == nil {
+				lines <- line
+			}
+		}
+	}()
+	return "unix:" + path, lines
+}
+
+func TestStreamSink_DeliversLogsAndEvents(t *testing.T) {
+	defer setBaseLogLevel(logrus.GetLevel())
+	setBaseLogLevel(logrus.InfoLevel)
+	address, lines := listenStreamSink(t)
+	if err := setStreamSink(StreamSinkConfig{Address: address, Logs: true, Events: true, Level: "debug"}); err != nil {
+		t.Fatalf("setStreamSink: %v", err)
+	}
+	defer setStreamSink(StreamSinkConfig{}) //nolint:errcheck
+
+	if logrus.GetLevel() != logrus.DebugLevel {
+		t.Fatalf("expected the logger to run at the sink's level, got %v", logrus.GetLevel())
+	}
+	logrus.WithField("id", int64(5600)).Debug("to the stream")
+	emitEvent(5600, "stream_sink_test", map[string]any{"n": 1})
+
+	var gotLog, gotEvent bool
+	deadline := time.After(5 * time.Second)
+	for !gotLog || !gotEvent {
+		select {
+		case line := <-lines:
+			if line.Log != nil {
+				var entry map[string]any
+				if err := json.Unmarshal(line.Log, &entry); err != nil {
+					t.Fatalf("log is not a JSON object: %s", line.Log)
+				}
+				gotLog = gotLog || entry["msg"] == "to the stream" && entry["id"] == float64(5600)
+			}
+			if line.Event != nil {
+				gotEvent = gotEvent || line.Event.Type == "stream_sink_test" && line.Event.InstanceID == 5600
+			}
+		case <-deadline:
+			t.Fatalf("timed out: log %v, event %v", gotLog, gotEvent)
+		}
+	}
+
+	if err := setStreamSink(StreamSinkConfig{}); err != nil {
+		t.Fatalf("remove sink: %v", err)
+	}
+	if logrus.GetLevel() != logrus.InfoLevel {
+		t.Fatalf("expected the base level back, got %v", logrus.GetLevel())
+	}
+}
+
+func TestSetStreamSink_RejectsInvalidConfig(t *testing.T) {
+	for _, cfg := range []StreamSinkConfig{
+		{Address: "/run/sink.sock"},
+		{Address: "tcp:127.0.0.1:1"},
+		{Address: "unix:"},
+		{Address: "npipe:\\\\.\\pipe\\boxlite"},
+		{Address: "unix:" + filepath.Join(t.TempDir(), "sink.sock"), Level: "loud"},
+		{Address: "unix:" + filepath.Join(t.TempDir(), "nobody-listens.sock")},
+	} {
+		if err := setStreamSink(cfg); err == nil {
+			setStreamSink(StreamSinkConfig{}) //nolint:errcheck
+			t.Errorf("%+v: expected error", cfg)
+		}
+	}
+	if currentStreamSink.Load() != nil {
+		t.Fatal("a rejected config installed a sink")
+	}
+}
+
+func TestStreamSink_DropsWhileDownAndReports(t *testing.T) {
+	defer func(d time.Duration) { streamSinkRedialInterval = d }(streamSinkRedialInterval)
+	streamSinkRedialInterval = 0
+
+	s := &streamSink{network: "unix", path: filepath.Join(t.TempDir(), "gone.sock"), events: true,
+		queue: make(chan []byte, streamSinkQueueSize), done: make(chan struct{})}
+	defer close(s.done)
+	go s.run(nil)
+	s.send(streamSinkLine{Event: &Event{Type: "lost"}})
+	deadline := time.Now().Add(5 * time.Second)
+	for s.dropped.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("line was not dropped while the stream is down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	l, err := net.Listen("unix", s.path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	s.send(streamSinkLine{Event: &Event{Type: "delivered"}})
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	sc := bufio.NewScanner(conn)
+	var first, second streamSinkLine
+	for _, line := range []*streamSinkLine{&first, &second} {
+		if !sc.Scan() {
+			t.Fatalf("stream closed: %v", sc.Err())
+		}
+		json.Unmarshal(sc.Bytes(), line) //nolint:errcheck
+	}
+	if first.Dropped != 1 || second.Event == nil || second.Event.Type != "delivered" {
+		t.Fatalf("got %+v then %+v, want the drop report then the event", first, second)
+	}
+}
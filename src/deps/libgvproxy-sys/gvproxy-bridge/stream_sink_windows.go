@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// namedPipesSupported reports whether stream sinks may use npipe addresses.
+const namedPipesSupported = true
+
+// dialStreamSink connects to a stream sink address.
+func dialStreamSink(network, path string) (net.Conn, error) {
+	if network == "npipe" {
+		timeout := streamSinkDialTimeout
+		return winio.DialPipe(path, &timeout)
+	}
+	return net.DialTimeout(network, path, streamSinkDialTimeout)
+}
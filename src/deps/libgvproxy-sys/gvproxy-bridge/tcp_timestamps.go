@@ -0,0 +1,91 @@
+package main
+
+// tcp_timestamps.go — Keep TCP timestamps off the guest link.
+//
+// A guest restored from a snapshot resumes with whatever clock the snapshot
+// had, while the gateway's netstack keeps real time. If the two negotiated
+// RFC 7323 timestamps, the guest's PAWS check sees the gateway's TSval jump
+// and drops segments until the connection resets. Blanking the timestamp
+// option out of SYN and SYN-ACK segments means it is never negotiated, so
+// neither side sends or checks timestamps for the life of the flow.
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// tcpTimestampStripper replaces the timestamp option in handshake segments
+// with NOPs, keeping the header length (and so every offset) unchanged.
+type tcpTimestampStripper struct {
+	stripped atomic.Uint64
+}
+
+func (s *tcpTimestampStripper) rewriteFrame(frame []byte) {
+	ip := frameIPv4(frame)
+	if ip == nil || ip.TransportProtocol() != header.TCPProtocolNumber || ip.FragmentOffset() != 0 {
+		return
+	}
+	tcp := header.TCP(ip.Payload())
+	if len(tcp) < header.TCPMinimumSize || !tcp.Flags().Contains(header.TCPFlagSyn) {
+		return
+	}
+	dataOffset := int(tcp.DataOffset())
+	if dataOffset <= header.TCPMinimumSize || dataOffset > len(tcp) {
+		return
+	}
+	if !blankTCPTimestampOption(tcp[header.TCPMinimumSize:dataOffset]) {
+		return
+	}
+
+	xsum := header.PseudoHeaderChecksum(header.TCPProtocolNumber, ip.SourceAddress(), ip.DestinationAddress(), uint16(len(tcp)))
+	tcp.SetChecksum(0)
+	tcp.SetChecksum(^checksum.Checksum(tcp, xsum))
+	s.stripped.Add(1)
+}
+
+// blankTCPTimestampOption overwrites the timestamp option in opts with NOPs
+// and reports whether one was found.
+func blankTCPTimestampOption(opts []byte) bool {
+	for i := 0; i < len(opts); {
+		switch opts[i] {
+		case header.TCPOptionEOL:
+			return false
+		case header.TCPOptionNOP:
+			i++
+			continue
+		}
+		if i+1 >= len(opts) {
+			return false
+		}
+		optLen := int(opts[i+1])
+		if optLen < 2 || i+optLen > len(opts) {
+			return false
+		}
+		if opts[i] == header.TCPOptionTS && optLen == header.TCPOptionTSLength {
+			for j := i; j < i+optLen; j++ {
+				opts[j] = header.TCPOptionNOP
+			}
+			return true
+		}
+		i += optLen
+	}
+	return false
+}
+
+// Stripped returns how many handshake segments had their timestamp removed.
+func (s *tcpTimestampStripper) Stripped() uint64 {
+	return s.stripped.Load()
+}
+
+// tcpTimestampsCollector publishes the strip count as "TCPTimestampsStripped".
+type tcpTimestampsCollector struct{ s *tcpTimestampStripper }
+
+func (tcpTimestampsCollector) Name() string { return "TCPTimestampsStripped" }
+
+func (tcpTimestampsCollector) Description() string {
+	return "Handshake segments whose TCP timestamp option was stripped."
+}
+
+func (c tcpTimestampsCollector) Collect() any { return c.s.Stripped() }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// linuxSYNOptions is the option block a Linux client sends in its SYN:
+// MSS, SACK-permitted, timestamps, NOP, window scale.
+var linuxSYNOptions = []byte{
+	2, 4, 0x05, 0xb4,
+	4, 2,
+	8, 10, 0, 0, 0x12, 0x34, 0, 0, 0, 0,
+	1,
+	3, 3, 7,
+}
+
+// testTCPFrameWithOptions builds a checksummed guest→internet TCP frame.
+func testTCPFrameWithOptions(flags header.TCPFlags, opts []byte) []byte {
+	seg := make([]byte, header.TCPMinimumSize+len(opts))
+	header.TCP(seg).Encode(&header.TCPFields{
+		SrcPort:    40000,
+		DstPort:    443,
+		DataOffset: uint8(len(seg)),
+		Flags:      flags,
+	})
+	copy(seg[header.TCPMinimumSize:], opts)
+
+	frame := testIPv4Frame(header.TCPProtocolNumber, "192.168.127.2", "93.184.216.34", seg)
+	ip := header.IPv4(frame[header.EthernetMinimumSize:])
+	tcp := header.TCP(ip.Payload())
+	xsum := header.PseudoHeaderChecksum(header.TCPProtocolNumber, ip.SourceAddress(), ip.DestinationAddress(), uint16(len(tcp)))
+	tcp.SetChecksum(^checksum.Checksum(tcp, xsum))
+	return frame
+}
+
+func tcpChecksumValid(frame []byte) bool {
+	ip := header.IPv4(frame[header.EthernetMinimumSize:])
+	return header.TCP(ip.Payload()).IsChecksumValid(ip.SourceAddress(), ip.DestinationAddress(), 0, 0)
+}
+
+func TestTCPTimestampStripper_BlanksSYNTimestamp(t *testing.T) {
+	frame := testTCPFrameWithOptions(header.TCPFlagSyn, linuxSYNOptions)
+	assertTrue(t, tcpChecksumValid(frame), "test frame starts with a valid checksum")
+
+	s := &tcpTimestampStripper{}
+	s.rewriteFrame(frame)
+
+	tcp := header.TCP(header.IPv4(frame[header.EthernetMinimumSize:]).Payload())
+	parsed := header.ParseSynOptions(tcp.Options(), false)
+	assertFalse(t, parsed.TS, "timestamp option removed")
+	if parsed.MSS != 1460 || parsed.WS != 7 || !parsed.SACKPermitted {
+		t.Fatalf("other SYN options must survive: %+v", parsed)
+	}
+	assertTrue(t, tcpChecksumValid(frame), "checksum recomputed")
+	if s.Stripped() != 1 {
+		t.Fatalf("expected 1 stripped segment, got %d", s.Stripped())
+	}
+}
+
+func TestTCPTimestampStripper_LeavesDataSegmentsAlone(t *testing.T) {
+	frame := testTCPFrameWithOptions(header.TCPFlagAck, []byte{1, 1, 8, 10, 0, 0, 0, 1, 0, 0, 0, 2})
+	orig := append([]byte(nil), frame...)
+
+	s := &tcpTimestampStripper{}
+	s.rewriteFrame(frame)
+
+	assertTrue(t, bytes.Equal(frame, orig), "non-SYN segments are untouched")
+	if s.Stripped() != 0 {
+		t.Fatalf("expected no stripped segments, got %d", s.Stripped())
+	}
+}
+
+func TestTCPTimestampStripper_IgnoresMalformedOptions(t *testing.T) {
+	// Length byte runs past the option block.
+	frame := testTCPFrameWithOptions(header.TCPFlagSyn, []byte{2, 40, 0, 0})
+	orig := append([]byte(nil), frame...)
+
+	(&tcpTimestampStripper{}).rewriteFrame(frame)
+	assertTrue(t, bytes.Equal(frame, orig), "malformed options are left as-is")
+}
+
+// TestLinkConn_RewritesStreamFrameSplitAcrossReads checks a rewrite lands
+// even when the frame arrives in pieces, since nothing is released to the
+// switch before the frame is complete.
+func TestLinkConn_RewritesStreamFrameSplitAcrossReads(t *testing.T) {
+	vm, gw := net.Pipe()
+	conn := newLinkConn(gw, types.QemuProtocol, []frameRewriter{&tcpTimestampStripper{}})
+
+	frame := testTCPFrameWithOptions(header.TCPFlagSyn, linuxSYNOptions)
+	wire := qemuFrame(string(frame))
+	go func() {
+		for i := 0; i < len(wire); i += 7 {
+			vm.Write(wire[i:min(i+7, len(wire))]) //nolint:errcheck
+		}
+		vm.Close()
+	}()
+
+	var got []byte
+	buf := make([]byte, 5)
+	for {
+		n, err := conn.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if len(got) != len(wire) {
+		t.Fatalf("expected %d bytes, got %d", len(wire), len(got))
+	}
+	out := got[4:]
+	assertFalse(t, header.TCP(header.IPv4(out[header.EthernetMinimumSize:]).Payload()).ParsedOptions().TS, "timestamp stripped")
+	assertTrue(t, tcpChecksumValid(out), "checksum valid after rewrite")
+}
@@ -0,0 +1,226 @@
+package main
+
+// teardown.go — Destroy an instance and wait until it is gone.
+//
+// gvproxy_destroy only cancels the instance: its sockets are closed, socket
+// files removed and goroutines ended shortly after the call returns, and
+// events for it may still arrive on the callbacks meanwhile. An embedder that
+// reuses a socket path, frees what its callbacks capture or unloads the
+// library needs to know when that is over. gvproxy_destroy_sync returns only
+// once
+//
+//   - the VM link, the VM socket, the control and CONNECT proxy sockets and
+//     the port forward listeners are closed, as are connections relayed
+//     through port forwards and those gvproxy_dial_guest and
+//     gvproxy_listen_guest bridged to FDs, and the socket files the instance
+//     created are removed (a handed-over instance keeps them for its
+//     replacement, as with gvproxy_destroy);
+//   - the goroutines the bridge started for the instance have returned;
+//   - callbacks already running for the instance (events, service auth)
+//     have returned. None are made for it afterwards: later events are
+//     dropped and later service connections denied.
+//
+// Guest TCP and UDP flows, and requests on the control and CONNECT proxy
+// sockets, run on goroutines gvisor and net/http start per connection.
+// Those are not waited for: the instance's netstack is closed, so they fail
+// and end on their own, and the callbacks above keep them from reaching the
+// embedder.
+
+import "C"
+import (
+	"sync"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// tracker counts goroutines so that teardown can wait for them. A nil
+// *tracker runs them untracked.
+type tracker struct {
+	mu      sync.Mutex
+	waiting bool // wait was called: trySpawn refuses
+	wg      sync.WaitGroup
+}
+
+// spawn runs f on a goroutine of t. Only call it while another goroutine of
+// t is running, or before t is waited for.
+func (t *tracker) spawn(f func()) {
+	if t == nil {
+		go f()
+		return
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		f()
+	}()
+}
+
+// trySpawn is spawn for callers not running on a goroutine of t, such as
+// FFI calls racing with teardown: it runs f and returns true, unless t is
+// already waited for.
+func (t *tracker) trySpawn(f func()) bool {
+	if t == nil {
+		go f()
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.waiting {
+		return false
+	}
+	t.spawn(f)
+	return true
+}
+
+// wait blocks until every goroutine of t has returned.
+func (t *tracker) wait() {
+	if t != nil {
+		t.mu.Lock()
+		t.waiting = true
+		t.mu.Unlock()
+		t.wg.Wait()
+	}
+}
+
+// callbackGate counts the callbacks running for an instance so that a
+// synchronous destroy can wait for them, and refuses new ones once closed.
+type callbackGate struct {
+	mu      sync.Mutex
+	idle    sync.Cond
+	running int
+	closed  bool
+}
+
+var (
+	// Gates of running instances, and of instances destroyed with
+	// gvproxy_destroy_sync (closed). Instances without a gate, and
+	// process-wide events (id 0), are not gated.
+	callbackGates   = make(map[int64]*callbackGate)
+	callbackGatesMu sync.RWMutex
+)
+
+// openCallbacks starts gating the callbacks of instance id.
+func openCallbacks(id int64) {
+	g := &callbackGate{}
+	g.idle.L = &g.mu
+	callbackGatesMu.Lock()
+	callbackGates[id] = g
+	callbackGatesMu.Unlock()
+}
+
+// dropCallbacks stops gating the callbacks of instance id, as after
+// gvproxy_destroy.
+func dropCallbacks(id int64) {
+	callbackGatesMu.Lock()
+	delete(callbackGates, id)
+	callbackGatesMu.Unlock()
+}
+
+// closeCallbacks refuses further callbacks for instance id and waits until
+// the running ones have returned. The closed gate is kept: instance ids are
+// not reused.
+func closeCallbacks(id int64) {
+	callbackGatesMu.RLock()
+	g := callbackGates[id]
+	callbackGatesMu.RUnlock()
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	for g.running > 0 {
+		g.idle.Wait()
+	}
+}
+
+// enterCallback reports whether a callback for instance id may be made. If
+// so, leave must be called once it has returned.
+func enterCallback(id int64) (leave func(), ok bool) {
+	callbackGatesMu.RLock()
+	g := callbackGates[id]
+	callbackGatesMu.RUnlock()
+	if g == nil {
+		return func() {}, true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return nil, false
+	}
+	g.running++
+	return func() {
+		g.mu.Lock()
+		g.running--
+		if g.running == 0 {
+			g.idle.Broadcast()
+		}
+		g.mu.Unlock()
+	}, true
+}
+
+// finishTeardown ends the cleanup of a cancelled instance: after a
+// synchronous destroy it also aborts port forward relays and closes the
+// netstack, then waits for the instance's goroutines and callbacks.
+func (instance *GvproxyInstance) finishTeardown() {
+	synchronous := instance.syncTeardown.Load()
+	if synchronous {
+		instance.forwarder.Abort()
+		instance.vnMu.RLock()
+		vn := instance.vn
+		instance.vnMu.RUnlock()
+		if vn != nil {
+			if s, err := vnStack(vn); err == nil {
+				s.Close()
+			}
+		}
+	}
+	instance.goroutines.wait()
+	if synchronous {
+		closeCallbacks(instance.ID)
+	} else {
+		dropCallbacks(instance.ID)
+	}
+}
+
+// destroyInstanceSync destroys instance id and waits up to timeout for its
+// teardown (see teardown.go). Returns 0 once done, -1 if the instance does
+// not exist, -2 on timeout.
+func destroyInstanceSync(id int64, timeout time.Duration) C.int {
+	instance := removeInstance(id)
+	if instance == nil {
+		return -1
+	}
+	instance.syncTeardown.Store(true)
+	instance.Cancel()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-instance.done:
+		return 0
+	case <-expired:
+		logrus.WithFields(logrus.Fields{"id": id, "timeout": timeout}).Warn("gvproxy instance teardown still running")
+		return -2
+	}
+}
+
+// gvproxy_destroy_sync is gvproxy_destroy that returns only once the
+// instance is fully torn down: sockets closed, socket files removed, its
+// goroutines returned and its callbacks delivered (see teardown.go).
+//
+// timeoutMs bounds the wait; 0 or less waits indefinitely. Returns 0 once
+// torn down, -1 if the instance does not exist, or -2 if the timeout expired
+// first (teardown then completes in the background). It cannot complete
+// before the instance's running callbacks return, so a callback of the same
+// instance must not call it without a timeout.
+//
+//export gvproxy_destroy_sync
+func gvproxy_destroy_sync(id C.longlong, timeoutMs C.int) C.int {
+	return destroyInstanceSync(int64(id), time.Duration(timeoutMs)*time.Millisecond)
+}
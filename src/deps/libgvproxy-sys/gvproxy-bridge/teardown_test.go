@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDestroySync(t *testing.T) {
+	dir := t.TempDir()
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(dir, "vm.sock")
+	config.ControlSocketPath = filepath.Join(dir, "control.sock")
+	config.Protocol = "qemu"
+	const id = 5914
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	vm, err := net.Dial("unix", config.SocketPath)
+	if err != nil {
+		t.Fatalf("dial VM socket: %v", err)
+	}
+	defer vm.Close()
+	resolveGatewayQemu(t, vm, config)
+
+	if rc := gvproxy_destroy_sync(id, 5000); rc != 0 {
+		t.Fatalf("gvproxy_destroy_sync = %d, want 0", rc)
+	}
+
+	// Everything is gone by the time it returns, with no waiting.
+	for _, path := range []string{config.SocketPath, config.ControlSocketPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists: %v", filepath.Base(path), err)
+		}
+	}
+	vm.SetReadDeadline(time.Now().Add(100 * time.Millisecond)) //nolint:errcheck
+	if _, err := io.Copy(io.Discard, vm); err != nil {
+		t.Fatalf("VM link not closed: %v", err)
+	}
+	emitEvent(id, "late", nil)
+	for _, e := range recentEventsFor(id) {
+		if e.Type == "late" {
+			t.Fatal("event delivered after gvproxy_destroy_sync returned")
+		}
+	}
+
+	if rc := gvproxy_destroy_sync(id, 5000); rc != -1 {
+		t.Fatalf("second gvproxy_destroy_sync = %d, want -1", rc)
+	}
+}
+
+func TestCallbackGate_WaitsForRunningCallbacks(t *testing.T) {
+	const id = 5915
+	openCallbacks(id)
+	leave, ok := enterCallback(id)
+	if !ok {
+		t.Fatal("open gate refused a callback")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		closeCallbacks(id)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("closeCallbacks returned while a callback was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	leave()
+	<-closed
+
+	if _, ok := enterCallback(id); ok {
+		t.Fatal("closed gate allowed a callback")
+	}
+}
+
+func TestPortForwarder_AbortEndsRelays(t *testing.T) {
+	f, err := NewPortForwarder(1, "192.168.127.2", []PortMapping{{HostPort: 0, GuestPort: 80}}, nil)
+	if err != nil {
+		t.Fatalf("NewPortForwarder: %v", err)
+	}
+	f.goroutines = &tracker{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Serve(ctx, &echoDialer{})
+
+	conn, err := net.Dial("tcp", f.forwards[0].listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial forward: %v", err)
+	}
+	defer conn.Close()
+	expectEcho(t, conn)
+
+	conn, err = net.Dial("tcp", f.forwards[0].listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial forward: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	f.Abort()
+	f.goroutines.wait()
+	conn.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Fatalf("relay not closed by Abort: %v", err)
+	}
+}
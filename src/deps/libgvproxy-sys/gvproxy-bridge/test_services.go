@@ -0,0 +1,121 @@
+package main
+
+// test_services.go — Classic diagnostic TCP services on the gateway IP.
+//
+// With test_services enabled, the gateway answers on the RFC 862/863/864
+// ports so connectivity and throughput can be checked from inside the guest
+// with plain nc, before suspecting the user's workload:
+//
+//	nc 192.168.127.1 7              # echo: prints back what you type
+//	nc 192.168.127.1 9 < big.file   # discard: guest→gateway throughput
+//	nc 192.168.127.1 19 > /dev/null # chargen: gateway→guest throughput
+//
+// These listen inside the netstack only; nothing is bound on the host.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+const (
+	echoPort    = 7
+	discardPort = 9
+	chargenPort = 19
+)
+
+// testServiceListener opens a listener inside the virtual network.
+// *virtualnetwork.VirtualNetwork satisfies it.
+type testServiceListener interface {
+	Listen(network, addr string) (net.Listener, error)
+}
+
+// startTestServices binds echo, discard and chargen on the gateway IP, serving
+// the connections guard allows (service_auth.go). The listeners close when
+// ctx is done.
+func startTestServices(ctx context.Context, vn testServiceListener, gatewayIP string, guard *serviceGuard) error {
+	services := []struct {
+		name   string
+		port   int
+		handle func(net.Conn)
+	}{
+		{"echo", echoPort, serveEcho},
+		{"discard", discardPort, serveDiscard},
+		{"chargen", chargenPort, serveChargen},
+	}
+
+	var listeners []net.Listener
+	for _, svc := range services {
+		l, err := vn.Listen("tcp", net.JoinHostPort(gatewayIP, fmt.Sprint(svc.port)))
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return fmt.Errorf("test service %s: %w", svc.name, err)
+		}
+		listeners = append(listeners, l)
+		go acceptTestService(guard.listener(svc.name, l), svc.name, svc.handle)
+	}
+
+	go func() {
+		<-ctx.Done()
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	logrus.WithField("gateway", gatewayIP).Info("Gateway test services enabled (echo:7 discard:9 chargen:19)")
+	return nil
+}
+
+func acceptTestService(l net.Listener, name string, handle func(net.Conn)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logrus.WithFields(logrus.Fields{"error": err, "service": name}).Debug("Test service accept stopped")
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+// serveEcho implements RFC 862.
+func serveEcho(conn net.Conn) {
+	io.Copy(conn, conn) //nolint:errcheck
+}
+
+// serveDiscard implements RFC 863.
+func serveDiscard(conn net.Conn) {
+	io.Copy(io.Discard, conn) //nolint:errcheck
+}
+
+// serveChargen implements RFC 864: 72-character lines of printable ASCII,
+// each rotated one character from the previous, until the peer goes away.
+func serveChargen(conn net.Conn) {
+	const (
+		first   = ' '
+		count   = 95 // printable ASCII, ' ' through '~'
+		lineLen = 72
+	)
+	// One full rotation of lines, written repeatedly.
+	block := make([]byte, 0, count*(lineLen+2))
+	for start := 0; start < count; start++ {
+		for i := 0; i < lineLen; i++ {
+			block = append(block, byte(first+(start+i)%count))
+		}
+		block = append(block, '\r', '\n')
+	}
+	for {
+		if _, err := conn.Write(block); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+// loopbackListener stands in for the virtual network.
+type loopbackListener struct{ addrs []string }
+
+func (l *loopbackListener) Listen(network, _ string) (net.Listener, error) {
+	ln, err := net.Listen(network, "127.0.0.1:0")
+	if err == nil {
+		l.addrs = append(l.addrs, ln.Addr().String())
+	}
+	return ln, err
+}
+
+func TestTestServices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	vn := &loopbackListener{}
+	if err := startTestServices(ctx, vn, "192.168.127.1", nil); err != nil {
+		t.Fatalf("startTestServices: %v", err)
+	}
+	echoAddr, discardAddr, chargenAddr := vn.addrs[0], vn.addrs[1], vn.addrs[2]
+
+	echo, err := net.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("dial echo: %v", err)
+	}
+	defer echo.Close()
+	echo.Write([]byte("hello")) //nolint:errcheck
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(echo, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("echo returned %q, %v", buf, err)
+	}
+
+	discard, err := net.Dial("tcp", discardAddr)
+	if err != nil {
+		t.Fatalf("dial discard: %v", err)
+	}
+	if _, err := discard.Write(make([]byte, 64*1024)); err != nil {
+		t.Fatalf("write discard: %v", err)
+	}
+	discard.Close()
+
+	chargen, err := net.Dial("tcp", chargenAddr)
+	if err != nil {
+		t.Fatalf("dial chargen: %v", err)
+	}
+	defer chargen.Close()
+	line := make([]byte, 74)
+	if _, err := io.ReadFull(chargen, line); err != nil {
+		t.Fatalf("read chargen: %v", err)
+	}
+	if line[0] != ' ' || line[71] != 'g' || string(line[72:]) != "\r\n" {
+		t.Fatalf("unexpected first chargen line %q", line)
+	}
+	if _, err := io.ReadFull(chargen, line); err != nil || line[0] != '!' {
+		t.Fatalf("second line must rotate by one, got %q (%v)", line, err)
+	}
+}
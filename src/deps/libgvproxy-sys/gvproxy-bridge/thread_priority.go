@@ -0,0 +1,71 @@
+package main
+
+// thread_priority.go — Keep VM traffic out of the embedder's way.
+//
+// Boxes often run in the background of an interactive application, and a
+// busy guest should not take CPU from the embedder's UI or audio threads.
+// With data_path_priority set, the thread reading the VM link, which parses
+// every frame from the guest and delivers it into the netstack, runs at a
+// lower OS priority: "nice" sets its nice value on Linux and "qos_class" its
+// QoS class on macOS. Each setting is ignored on the other platform, so one
+// config serves both.
+//
+// Go schedules goroutines on any OS thread, so the reader is locked to a
+// thread of its own for its lifetime; when it exits the thread goes away
+// instead of returning to the pool with its priority. Other goroutines of
+// the data path (forward relays, netstack workers) cannot be pinned this
+// way without a thread each and keep the process's priority.
+
+import (
+	"fmt"
+	"runtime"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// ThreadPriority is an OS scheduling hint for a data-path thread.
+type ThreadPriority struct {
+	// Nice is the thread's nice value on Linux, -20 (highest) to 19
+	// (lowest); 0 leaves it unchanged. Values below the process's need
+	// CAP_SYS_NICE.
+	Nice int `json:"nice,omitempty"`
+	// QoSClass is the thread's QoS class on macOS: "user_interactive",
+	// "user_initiated", "default", "utility" or "background".
+	QoSClass string `json:"qos_class,omitempty"`
+}
+
+// qosClasses are the accepted qos_class values.
+var qosClasses = []string{"user_interactive", "user_initiated", "default", "utility", "background"}
+
+// checkThreadPriority validates data_path_priority on every platform.
+func checkThreadPriority(p *ThreadPriority) error {
+	if p == nil {
+		return nil
+	}
+	if p.Nice < -20 || p.Nice > 19 {
+		return fmt.Errorf("data_path_priority: nice %d is outside -20..19", p.Nice)
+	}
+	if p.QoSClass != "" {
+		for _, class := range qosClasses {
+			if p.QoSClass == class {
+				return nil
+			}
+		}
+		return fmt.Errorf("data_path_priority: unknown qos_class %q", p.QoSClass)
+	}
+	return nil
+}
+
+// lockDataPathThread locks the calling goroutine to its thread and applies
+// p to it. The goroutine must not unlock: exiting while locked retires the
+// thread. A nil p does nothing. Failures are logged; the data path runs on
+// regardless.
+func lockDataPathThread(id int64, p *ThreadPriority) {
+	if p == nil {
+		return
+	}
+	runtime.LockOSThread()
+	if err := setThreadPriority(p); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": id}).Warn("Failed to set data path thread priority")
+	}
+}
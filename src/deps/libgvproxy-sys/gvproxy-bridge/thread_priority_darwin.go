@@ -0,0 +1,31 @@
+package main
+
+/*
+#include <pthread.h>
+#include <pthread/qos.h>
+*/
+import "C"
+import "fmt"
+
+// setThreadPriority applies p.QoSClass to the calling thread.
+func setThreadPriority(p *ThreadPriority) error {
+	var class C.qos_class_t
+	switch p.QoSClass {
+	case "":
+		return nil
+	case "user_interactive":
+		class = C.QOS_CLASS_USER_INTERACTIVE
+	case "user_initiated":
+		class = C.QOS_CLASS_USER_INITIATED
+	case "default":
+		class = C.QOS_CLASS_DEFAULT
+	case "utility":
+		class = C.QOS_CLASS_UTILITY
+	case "background":
+		class = C.QOS_CLASS_BACKGROUND
+	}
+	if rc := C.pthread_set_qos_class_self_np(class, 0); rc != 0 {
+		return fmt.Errorf("pthread_set_qos_class_self_np: error %d", int(rc))
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import "syscall"
+
+// setThreadPriority applies p.Nice to the calling thread; 0 leaves it
+// alone. On Linux a nice value is per thread, so PRIO_PROCESS with the
+// thread ID affects it alone.
+func setThreadPriority(p *ThreadPriority) error {
+	if p.Nice == 0 {
+		return nil
+	}
+	return syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Gettid(), p.Nice)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestLockDataPathThread_SetsNiceOfItsThreadOnly(t *testing.T) {
+	// getpriority(2) returns 20 - nice.
+	processPrio, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan int, 1)
+	go func() {
+		lockDataPathThread(1, &ThreadPriority{Nice: 7})
+		prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, syscall.Gettid())
+		if err != nil {
+			t.Error(err)
+		}
+		got <- prio
+	}()
+	if prio := <-got; 20-prio != 7 {
+		t.Fatalf("thread nice = %d, want 7", 20-prio)
+	}
+	if prio, _ := syscall.Getpriority(syscall.PRIO_PROCESS, 0); prio != processPrio {
+		t.Fatalf("process priority changed from %d to %d", processPrio, prio)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// setThreadPriority does nothing: neither setting applies here.
+func setThreadPriority(*ThreadPriority) error {
+	return nil
+}
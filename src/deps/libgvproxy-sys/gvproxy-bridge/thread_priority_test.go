@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestCheckThreadPriority(t *testing.T) {
+	for _, p := range []*ThreadPriority{nil, {}, {Nice: 19}, {Nice: -20, QoSClass: "background"}} {
+		if err := checkThreadPriority(p); err != nil {
+			t.Errorf("checkThreadPriority(%+v): %v", p, err)
+		}
+	}
+	for _, p := range []*ThreadPriority{{Nice: 20}, {Nice: -21}, {QoSClass: "low"}} {
+		if err := checkThreadPriority(p); err == nil {
+			t.Errorf("checkThreadPriority(%+v): expected an error", p)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+// trace_ids.go — Correlate a boxlite action with what it caused here.
+//
+// Control operations accept an optional trace ID: trace_id in the
+// gvproxy_create config, the traceID argument of gvproxy_destroy_traced, and
+// the X-Trace-Id header on control socket requests (expose/unexpose and the
+// rest of the services API). While the operation runs, its trace ID is
+// active for the instance: every log entry carrying that instance's "id"
+// field gains a trace_id field, and every event of the instance carries
+// trace_id.
+
+import (
+	"net/http"
+	"sync"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+// TraceIDHeader carries the trace ID of a control socket request.
+const TraceIDHeader = "X-Trace-Id"
+
+var (
+	activeTraces   = make(map[int64]string)
+	activeTracesMu sync.RWMutex
+)
+
+// withTrace runs fn with traceID active for instance id. An empty traceID
+// runs fn untraced.
+func withTrace(id int64, traceID string, fn func()) {
+	if traceID == "" {
+		fn()
+		return
+	}
+	activeTracesMu.Lock()
+	prev, hadPrev := activeTraces[id]
+	activeTraces[id] = traceID
+	activeTracesMu.Unlock()
+
+	defer func() {
+		activeTracesMu.Lock()
+		if hadPrev {
+			activeTraces[id] = prev
+		} else {
+			delete(activeTraces, id)
+		}
+		activeTracesMu.Unlock()
+	}()
+	fn()
+}
+
+// traceFor returns the trace ID active for instance id, if any.
+func traceFor(id int64) string {
+	activeTracesMu.RLock()
+	defer activeTracesMu.RUnlock()
+	return activeTraces[id]
+}
+
+// statusRecorder remembers the status code a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// traceRequests activates the X-Trace-Id of each control socket request for
+// instance id while it is served, and logs traced requests with their
+// outcome.
+func traceRequests(id int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		traceID := req.Header.Get(TraceIDHeader)
+		if traceID == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		withTrace(id, traceID, func() {
+			next.ServeHTTP(rec, req)
+			logrus.WithFields(logrus.Fields{
+				"id":     id,
+				"method": req.Method,
+				"path":   req.URL.Path,
+				"status": rec.status,
+			}).Info("Served control request")
+		})
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	logrus "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestWithTrace_TagsLogsAndEvents(t *testing.T) {
+	const id = 4500
+	logs := logtest.NewGlobal()
+
+	withTrace(id, "trace-1", func() {
+		withTrace(id, "trace-2", func() {
+			logrus.WithField("id", int64(id)).Info("inner")
+		})
+		logrus.WithField("id", int64(id)).Info("outer")
+		logrus.WithField("id", int64(id+1)).Info("other instance")
+		emitEvent(id, "test_event", nil)
+	})
+	logrus.WithField("id", int64(id)).Info("after")
+
+	want := []any{"trace-2", "trace-1", nil, nil}
+	entries := logs.AllEntries()
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(entries))
+	}
+	for i, entry := range entries {
+		if got := entry.Data["trace_id"]; got != want[i] {
+			t.Errorf("%q: trace_id = %v, want %v", entry.Message, got, want[i])
+		}
+	}
+
+	events := recentEventsFor(id)
+	if last := events[len(events)-1]; last.Type != "test_event" || last.TraceID != "trace-1" {
+		t.Fatalf("expected the event to carry the trace, got %+v", last)
+	}
+	if traceFor(id) != "" {
+		t.Fatal("trace must not outlive the operation")
+	}
+}
+
+func TestTraceRequests(t *testing.T) {
+	const id = 4501
+	logs := logtest.NewGlobal()
+
+	var seen string
+	handler := traceRequests(id, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		seen = traceFor(id)
+		http.Error(w, "reserved", http.StatusForbidden)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/services/forwarder/expose", nil)
+	req.Header.Set(TraceIDHeader, "expose-7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if seen != "expose-7" {
+		t.Fatalf("expected the trace to be active in the handler, got %q", seen)
+	}
+	entry := logs.LastEntry()
+	if entry == nil || entry.Data["trace_id"] != "expose-7" || entry.Data["status"] != http.StatusForbidden {
+		t.Fatalf("expected a traced request log with its status, got %+v", entry)
+	}
+}
+
+func TestGvproxyDestroyTraced_UnknownInstance(t *testing.T) {
+	if rc := gvproxy_destroy_traced(987654, nil); rc != -1 {
+		t.Fatalf("expected -1 for unknown instance, got %d", rc)
+	}
+}
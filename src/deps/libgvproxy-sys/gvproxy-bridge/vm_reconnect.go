@@ -0,0 +1,106 @@
+package main
+
+// vm_reconnect.go — Survive the hypervisor restarting.
+//
+// A VMM restarted under the same box (QEMU after a crash or a reboot with a
+// new process) connects to socket_path again. The instance keeps serving it
+// instead of having to be recreated:
+//
+//   - qemu, bess: the listening socket stays open after the VM connects;
+//     when the link drops, the next connection is accepted and becomes the
+//     link. An instance adopted with a connected link from
+//     gvproxy_serialize_all has no listener and re-creates socket_path
+//     first.
+//   - vfkit: the datagram socket is never closed; a "VFKT" hello from a new
+//     address re-points the link at the restarted VMM.
+//   - stdio: the pipes cannot be reopened; the link ends with them.
+//
+// vsock_listen links accept forwarder reconnects the same way (see
+// vsock_listen.go). The guest's netstack state is the instance's and
+// survives; flows the guest had open are reset as usual when it reboots.
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// EventVMDisconnected fires when the VM link drops while the instance keeps
+// running. Fields: protocol, error.
+const EventVMDisconnected = "vm_disconnected"
+
+// EventVMReconnected fires when a VM connects again after a disconnect.
+// Fields: protocol, remote, connections (connections so far, this one
+// included).
+const EventVMReconnected = "vm_reconnected"
+
+// vmListener returns the socket the VM connects to, if the instance has one.
+func (instance *GvproxyInstance) vmListener() net.Listener {
+	instance.vnMu.RLock()
+	defer instance.vnMu.RUnlock()
+	return instance.listener
+}
+
+// acceptVM waits for the VM to connect to the link socket, re-creating the
+// socket first if the instance has none (a handed-over connection dropped).
+func (instance *GvproxyInstance) acceptVM(ctx context.Context, protocol types.Protocol) (net.Conn, error) {
+	instance.vnMu.Lock()
+	l := instance.listener
+	if l == nil {
+		// Teardown closes the listener once ctx is done; do not create one
+		// after that.
+		if err := ctx.Err(); err != nil {
+			instance.vnMu.Unlock()
+			return nil, err
+		}
+		if instance.SocketPath == "" {
+			instance.vnMu.Unlock()
+			return nil, fmt.Errorf("no socket_path to accept the VM on")
+		}
+		removeLinkSocket(instance.SocketPath)
+		var err error
+		if l, err = listenLink(protocol, instance.SocketPath); err != nil {
+			instance.vnMu.Unlock()
+			return nil, err
+		}
+		if err = applySocketPermissions(instance.SocketPath, instance.config.SocketPermissions); err != nil {
+			instance.vnMu.Unlock()
+			l.Close()
+			return nil, err
+		}
+		instance.listener = l
+		logrus.WithFields(logrus.Fields{"id": instance.ID, "path": instance.SocketPath}).Info("Re-created VM link socket")
+	}
+	instance.vnMu.Unlock()
+	if err := failFFIFault(ffiFaultAccept); err != nil {
+		return nil, err
+	}
+	return l.Accept()
+}
+
+// vmDisconnected reports a VM link that dropped while the instance runs on.
+func vmDisconnected(id int64, protocol types.Protocol, err error) {
+	reason := "closed"
+	if err != nil {
+		reason = err.Error()
+	}
+	logrus.WithFields(logrus.Fields{"id": id, "protocol": protocol, "error": reason}).Warn("VM link closed; waiting for the VM to reconnect")
+	emitEvent(id, EventVMDisconnected, map[string]any{
+		"protocol": string(protocol),
+		"error":    reason,
+	})
+}
+
+// vmReconnected reports the connections-th VM connection, one after a
+// disconnect.
+func vmReconnected(id int64, protocol types.Protocol, remote string, connections int) {
+	logrus.WithFields(logrus.Fields{"id": id, "protocol": protocol, "remote": remote, "connections": connections}).Info("VM reconnected")
+	emitEvent(id, EventVMReconnected, map[string]any{
+		"protocol":    string(protocol),
+		"remote":      remote,
+		"connections": connections,
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// resolveGatewayQemu has the VM on conn ARP for the gateway over the qemu
+// framing and waits for the reply.
+func resolveGatewayQemu(t *testing.T, conn net.Conn, config GvproxyConfig) {
+	t.Helper()
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	request := arpRequestFrame(mustMAC(config.GuestMac), broadcast, net.ParseIP(config.GuestIP).To4(), net.ParseIP(config.GatewayIP).To4())
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(request)))
+	if _, err := conn.Write(append(frame, request...)); err != nil {
+		t.Fatalf("write ARP request: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(conn, size); err != nil {
+			t.Fatalf("read reply: %v", err)
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(size))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("read reply: %v", err)
+		}
+		if arp := frameARP(buf); arp != nil && arp.Op() == header.ARPReply {
+			return
+		}
+	}
+}
+
+func TestStartInstance_QemuReconnects(t *testing.T) {
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	config.Protocol = "qemu"
+	const id = 5908
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	first, err := net.Dial("unix", config.SocketPath)
+	if err != nil {
+		t.Fatalf("dial VM socket: %v", err)
+	}
+	resolveGatewayQemu(t, first, config)
+
+	// QEMU goes away and comes back.
+	first.Close()
+	if e := waitForEvent(t, id, EventVMDisconnected); e.Fields["protocol"] != "qemu" {
+		t.Fatalf("unexpected disconnect event: %v", e.Fields)
+	}
+	second, err := net.Dial("unix", config.SocketPath)
+	if err != nil {
+		t.Fatalf("redial VM socket: %v", err)
+	}
+	defer second.Close()
+	resolveGatewayQemu(t, second, config)
+	if e := waitForEvent(t, id, EventVMReconnected); e.Fields["connections"] != 2 {
+		t.Fatalf("unexpected reconnect event: %v", e.Fields)
+	}
+}
+
+func TestStartInstance_VfkitReconnects(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gvp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(dir, "vm.sock")
+	config.Protocol = "vfkit"
+	const id = 5909
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	dial := func(name string) *net.UnixConn {
+		t.Helper()
+		vm, err := net.DialUnix("unixgram", &net.UnixAddr{Name: filepath.Join(dir, name), Net: "unixgram"}, &net.UnixAddr{Name: config.SocketPath, Net: "unixgram"})
+		if err != nil {
+			t.Fatalf("dial VM socket: %v", err)
+		}
+		if _, err := vm.Write([]byte("VFKT")); err != nil {
+			t.Fatalf("write magic: %v", err)
+		}
+		return vm
+	}
+	first := dial("vfkit-1.sock")
+	waitForLink(t, id)
+	first.Close()
+
+	second := dial("vfkit-2.sock")
+	defer second.Close()
+	if e := waitForEvent(t, id, EventVMReconnected); e.Fields["remote"] != filepath.Join(dir, "vfkit-2.sock") {
+		t.Fatalf("unexpected reconnect event: %v", e.Fields)
+	}
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if _, err := second.Write(arpRequestFrame(mustMAC(config.GuestMac), broadcast, net.ParseIP(config.GuestIP).To4(), net.ParseIP(config.GatewayIP).To4())); err != nil {
+		t.Fatalf("write ARP request: %v", err)
+	}
+	second.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	buf := make([]byte, 2048)
+	for {
+		n, err := second.Read(buf)
+		if err != nil {
+			t.Fatalf("read reply on the new address: %v", err)
+		}
+		if arp := frameARP(buf[:n]); arp != nil && arp.Op() == header.ARPReply {
+			return
+		}
+	}
+}
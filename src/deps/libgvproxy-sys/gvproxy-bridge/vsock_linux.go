@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/transport"
+)
+
+// vsockSupported reports whether vsock_listen can be used.
+const vsockSupported = true
+
+// listenVsock binds the AF_VSOCK listener of vsock_listen.
+func listenVsock(v VsockListen) (net.Listener, error) {
+	return transport.Listen(v.url())
+}
+
+// dialVsock connects to the host vsock address addr ("vsock://cid:port").
+func dialVsock(_ context.Context, addr string) (net.Conn, error) {
+	conn, _, err := transport.Dial(addr)
+	return conn, err
+}
@@ -0,0 +1,76 @@
+package main
+
+// vsock_listen.go — Let the guest connect over virtio-vsock.
+//
+// Some VMMs cannot attach the NIC backend to a Unix socket path (KVM setups
+// without a socket netdev, Hyper-V). With vsock_listen set, the instance
+// listens on AF_VSOCK instead of creating socket_path, and the guest runs a
+// forwarder (upstream's gvforwarder, or anything speaking its protocol)
+// that connects, sends "POST /connect" as an HTTP/1.1 request, and then
+// exchanges frames with a 16-bit little-endian length prefix — the same as
+// upstream gvproxy -listen vsock://. The forwarder may reconnect, e.g.
+// after it restarts; each connection becomes the VM link.
+//
+// On Linux the bridge binds AF_VSOCK. On Windows it binds a Hyper-V socket
+// (AF_HYPERV) for the vsock port's service GUID, which Hyper-V maps to the
+// guest's virtio-vsock port; only cid 0 (any VM) is accepted there. vsock
+// links cannot be handed over by gvproxy_serialize_all.
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+)
+
+// vsockConnectTimeout bounds reading the forwarder's connect request.
+const vsockConnectTimeout = 10 * time.Second
+
+// VsockListen is the vsock address the guest's forwarder connects to.
+type VsockListen struct {
+	CID  uint32 `json:"cid,omitempty"` // local context ID to bind; 0 => the host's
+	Port uint32 `json:"port"`
+}
+
+// url returns the address in upstream's -listen syntax.
+func (v VsockListen) url() string {
+	if v.CID == 0 {
+		return fmt.Sprintf("vsock://:%d", v.Port)
+	}
+	return fmt.Sprintf("vsock://%d:%d", v.CID, v.Port)
+}
+
+// checkVsockListen validates vsock_listen against the rest of the config.
+func checkVsockListen(config GvproxyConfig) error {
+	if !vsockSupported {
+		return classify(createErrUnsupported, fmt.Errorf("vsock_listen is not supported on %s", runtime.GOOS))
+	}
+	if config.VsockListen.Port == 0 {
+		return fmt.Errorf("vsock_listen needs a port")
+	}
+	if config.Protocol != "" {
+		return fmt.Errorf("protocol %q cannot be combined with vsock_listen, whose framing is fixed", config.Protocol)
+	}
+	return nil
+}
+
+// acceptVsockConnect reads the forwarder's connect request from conn and
+// returns the connection carrying the frames after it.
+func acceptVsockConnect(conn net.Conn) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(vsockConnectTimeout)) //nolint:errcheck
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return nil, fmt.Errorf("read connect request: %w", err)
+	}
+	req.Body.Close()
+	if req.URL.Path != types.ConnectPath {
+		return nil, fmt.Errorf("unexpected request %s %s, want %s", req.Method, req.URL.Path, types.ConnectPath)
+	}
+	conn.SetReadDeadline(time.Time{}) //nolint:errcheck
+	return &bufferedConn{Conn: conn, reader: r}, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestVsockListen_URL(t *testing.T) {
+	if got := (VsockListen{Port: 1024}).url(); got != "vsock://:1024" {
+		t.Errorf("got %q", got)
+	}
+	if got := (VsockListen{CID: 3, Port: 1024}).url(); got != "vsock://3:1024" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestCheckVsockListen(t *testing.T) {
+	config := testGvproxyConfig()
+	config.VsockListen = &VsockListen{}
+	if err := checkVsockListen(config); err == nil {
+		t.Error("expected an error without a port")
+	}
+	config.VsockListen.Port = 1024
+	config.Protocol = "qemu"
+	if err := checkVsockListen(config); err == nil {
+		t.Error("expected an error combining vsock_listen with a protocol")
+	}
+	config.Protocol = ""
+	if err := checkVsockListen(config); (err == nil) != vsockSupported {
+		t.Errorf("checkVsockListen = %v, vsock supported: %v", err, vsockSupported)
+	}
+}
+
+// writeConnect sends what the guest forwarder sends: the connect request
+// followed directly by frames.
+func writeConnect(t *testing.T, conn net.Conn, path string, frames []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	req.Write(&buf) //nolint:errcheck
+	buf.Write(frames)
+	go conn.Write(buf.Bytes()) //nolint:errcheck
+}
+
+func TestAcceptVsockConnect_KeepsFramesAfterRequest(t *testing.T) {
+	guest, host := net.Pipe()
+	defer guest.Close()
+	frames := []byte{0x04, 0x00, 'p', 'i', 'n', 'g'}
+	writeConnect(t, guest, "/connect", frames)
+
+	conn, err := acceptVsockConnect(host)
+	if err != nil {
+		t.Fatalf("acceptVsockConnect: %v", err)
+	}
+	got := make([]byte, len(frames))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read frames: %v", err)
+	}
+	if !bytes.Equal(got, frames) {
+		t.Fatalf("got %x, want %x", got, frames)
+	}
+}
+
+func TestAcceptVsockConnect_RejectsOtherRequests(t *testing.T) {
+	guest, host := net.Pipe()
+	defer guest.Close()
+	writeConnect(t, guest, "/services/forwarder/all", nil)
+	if _, err := acceptVsockConnect(host); err == nil {
+		t.Fatal("expected an error for a request other than /connect")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// vsockSupported reports whether vsock_listen can be used.
+const vsockSupported = false
+
+// listenVsock binds the AF_VSOCK listener of vsock_listen.
+func listenVsock(VsockListen) (net.Listener, error) {
+	return nil, errors.New("vsock is not supported on this platform")
+}
+
+// dialVsock connects to the host vsock address addr ("vsock://cid:port").
+func dialVsock(context.Context, string) (net.Conn, error) {
+	return nil, errors.New("vsock is not supported on this platform")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/transport"
+)
+
+// vsockSupported reports whether vsock_listen can be used.
+const vsockSupported = true
+
+// listenVsock binds the Hyper-V socket (AF_HYPERV) a guest's AF_VSOCK
+// connections to v.Port arrive on: Hyper-V maps vsock port N to the service
+// ID N-FACB-11E6-BD58-64006A7986D3, accepted from any VM.
+func listenVsock(v VsockListen) (net.Listener, error) {
+	if v.CID != 0 {
+		return nil, classify(createErrUnsupported, errors.New("vsock_listen cid is not supported on windows (connections from every VM are accepted)"))
+	}
+	return transport.Listen(fmt.Sprintf("vsock://%08X-FACB-11E6-BD58-64006A7986D3", v.Port))
+}
+
+// dialVsock connects to the host vsock address addr ("vsock://cid:port").
+// A Windows host has no AF_VSOCK services to connect to.
+func dialVsock(context.Context, string) (net.Conn, error) {
+	return nil, errors.New("host vsock is not supported on windows")
+}
@@ -0,0 +1,118 @@
+package main
+
+// vz_fd.go — Hand a vfkit instance's link to Virtualization.framework as an
+// FD.
+//
+// VZFileHandleNetworkDeviceAttachment takes a connected datagram socket. For
+// a vfkit instance the embedder used to build it out-of-band: bind a socket
+// of its own, connect it to socket_path and send the "VFKT" hello.
+// gvproxy_get_fd does that in the bridge and returns the socket, so the
+// embedder only passes the FD on. The socket is bound at socket_path with a
+// "-vz" suffix, since the instance replies to its address; the file is
+// removed when the instance is destroyed, or when gvproxy_get_fd is called
+// again. A later call hands out a new socket the link then follows, as it
+// follows a restarted VMM (see vm_reconnect.go).
+
+import "C"
+import (
+	"errors"
+	"net"
+	"os"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// vzPeerSuffix names the socket gvproxy_get_fd binds next to socket_path.
+const vzPeerSuffix = "-vz"
+
+// dialVfkitLink connects a new datagram socket bound at peerPath to the
+// instance's socket at socketPath, announces it as the VM, and returns it
+// as an FD in blocking mode, owned by the caller.
+func dialVfkitLink(socketPath, peerPath string) (int, error) {
+	if err := os.Remove(peerPath); err != nil && !os.IsNotExist(err) {
+		return -1, err
+	}
+	conn, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Name: peerPath, Net: "unixgram"},
+		&net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return -1, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("VFKT")); err != nil {
+		os.Remove(peerPath)
+		return -1, err
+	}
+	// File puts the socket in blocking mode, as a plain FD's user expects.
+	f, err := conn.File()
+	if err != nil {
+		os.Remove(peerPath)
+		return -1, err
+	}
+	defer f.Close()
+	fd, err := dupFD(f.Fd())
+	if err != nil {
+		os.Remove(peerPath)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// vfkitLinkFD returns a socket connected to the instance's vfkit link
+// socket (see vz_fd.go).
+func (instance *GvproxyInstance) vfkitLinkFD() (int, error) {
+	if instance.Config.Protocol != types.VfkitProtocol {
+		return -1, errors.New("not a vfkit instance")
+	}
+	if instance.conn == nil || instance.SocketPath == "" {
+		return -1, errors.New("instance has no socket_path to connect to")
+	}
+	peerPath := instance.SocketPath + vzPeerSuffix
+	fd, err := dialVfkitLink(instance.SocketPath, peerPath)
+	if err != nil {
+		return -1, err
+	}
+	instance.vnMu.Lock()
+	instance.vzPeerPath = peerPath
+	instance.vnMu.Unlock()
+	return fd, nil
+}
+
+// removeVZPeer removes the socket file gvproxy_get_fd bound, if any.
+func (instance *GvproxyInstance) removeVZPeer() {
+	instance.vnMu.RLock()
+	peerPath := instance.vzPeerPath
+	instance.vnMu.RUnlock()
+	if peerPath != "" {
+		os.Remove(peerPath)
+	}
+}
+
+// gvproxy_get_fd returns a datagram socket connected to the VM link of a
+// vfkit instance, ready for Virtualization.framework's
+// VZFileHandleNetworkDeviceAttachment: the instance treats it as the VM (see
+// vz_fd.go). The caller owns the FD. Calling it again hands out a new
+// socket and the link moves to it.
+//
+// Returns -1 if the instance does not exist, does not use the vfkit
+// protocol, was created without a socket_path, or the socket could not be
+// made.
+//
+//export gvproxy_get_fd
+func gvproxy_get_fd(id C.longlong) C.int {
+	instancesMu.RLock()
+	instance, ok := instances[int64(id)]
+	instancesMu.RUnlock()
+	if !ok {
+		return -1
+	}
+
+	fd, err := instance.vfkitLinkFD()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "id": int64(id)}).Warn("Failed to create VM link FD")
+		return -1
+	}
+	logrus.WithFields(logrus.Fields{"id": int64(id), "fd": fd}).Info("Handed VM link FD to embedder")
+	return C.int(fd)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestGetFD_Vfkit(t *testing.T) {
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	config.Protocol = "vfkit"
+	const id = 5916
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+
+	fd := gvproxy_get_fd(id)
+	if fd < 0 {
+		t.Fatal("gvproxy_get_fd failed")
+	}
+	vm := vmSide(t, int(fd))
+	defer vm.Close()
+
+	// The hello was sent for us: frames flow right away.
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if _, err := vm.Write(arpRequestFrame(mustMAC(config.GuestMac), broadcast, net.ParseIP(config.GuestIP).To4(), net.ParseIP(config.GatewayIP).To4())); err != nil {
+		t.Fatalf("write ARP request: %v", err)
+	}
+	vm.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	buf := make([]byte, 2048)
+	for {
+		n, err := vm.Read(buf)
+		if err != nil {
+			t.Fatalf("read reply: %v", err)
+		}
+		if arp := frameARP(buf[:n]); arp != nil && arp.Op() == header.ARPReply {
+			break
+		}
+	}
+
+	if rc := gvproxy_destroy_sync(id, 5000); rc != 0 {
+		t.Fatalf("gvproxy_destroy_sync = %d", rc)
+	}
+	if _, err := os.Stat(config.SocketPath + vzPeerSuffix); !os.IsNotExist(err) {
+		t.Fatalf("peer socket not removed on destroy: %v", err)
+	}
+}
+
+func TestGetFD_RequiresVfkit(t *testing.T) {
+	if gvproxy_get_fd(987654) != -1 {
+		t.Fatal("unknown instance must fail")
+	}
+
+	config := testGvproxyConfig()
+	config.SocketPath = filepath.Join(t.TempDir(), "vm.sock")
+	config.Protocol = "qemu"
+	const id = 5917
+	if err := startInstance(id, config); err != nil {
+		t.Fatalf("startInstance: %v", err)
+	}
+	defer stopTestInstance(id)
+	if gvproxy_get_fd(id) != -1 {
+		t.Fatal("qemu instance must fail")
+	}
+}
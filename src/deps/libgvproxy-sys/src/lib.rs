@@ -5,6 +5,19 @@
 
 use std::os::raw::{c_char, c_int, c_longlong, c_void};
 
+/// `gvproxy_create` failure: any failure not listed below
+pub const GVPROXY_ERR_OTHER: c_longlong = -1;
+/// `gvproxy_create` failure: the config does not parse or is invalid
+pub const GVPROXY_ERR_CONFIG: c_longlong = -2;
+/// `gvproxy_create` failure: the VM link socket cannot be created
+pub const GVPROXY_ERR_SOCKET: c_longlong = -3;
+/// `gvproxy_create` failure: a mapped host port is in use or reserved
+pub const GVPROXY_ERR_PORT_CONFLICT: c_longlong = -4;
+/// `gvproxy_create` failure: a requested feature is unavailable on this platform
+pub const GVPROXY_ERR_UNSUPPORTED: c_longlong = -5;
+/// `gvproxy_create` failure: `max_instances` or `max_total_forwards` reached
+pub const GVPROXY_ERR_LIMIT: c_longlong = -6;
+
 /// Logging callback function type
 ///
 /// Called by Go's slog handler to forward log messages to Rust.
@@ -14,6 +27,25 @@ use std::os::raw::{c_char, c_int, c_longlong, c_void};
 /// * `message` - Log message (null-terminated C string)
 pub type LogCallbackFn = extern "C" fn(level: c_int, message: *const c_char);
 
+/// Event callback function type
+///
+/// # Arguments
+/// * `id` - Instance ID the event belongs to
+/// * `event_json` - JSON-encoded event (null-terminated C string)
+pub type EventCallbackFn = extern "C" fn(id: c_longlong, event_json: *const c_char);
+
+/// Service auth callback function type
+///
+/// # Arguments
+/// * `id` - Instance ID the connection belongs to
+/// * `request_json` - JSON-encoded request (`{"service": ..., "port": ...,
+///   "source_ip": ..., "source_port": ..., "source_mac": ...}`)
+///
+/// # Returns
+/// Non-zero to serve the connection, 0 to close it
+pub type ServiceAuthCallbackFn =
+    extern "C" fn(id: c_longlong, request_json: *const c_char) -> c_int;
+
 extern "C" {
     /// Create a new gvproxy instance with port mappings
     ///
@@ -24,13 +56,19 @@ extern "C" {
     ///   Pass null to discard the message.
     ///
     /// # Returns
-    /// Instance ID (handle) or -1 on error
+    /// Instance ID (handle), or a negative `GVPROXY_ERR_*` code naming the
+    /// failure class. With `dry_run` set in the config, nothing is created
+    /// and 0 means a real create would have succeeded.
     pub fn gvproxy_create(portMappingsJSON: *const c_char, errOut: *mut *mut c_char) -> c_longlong;
 
     /// Free a string allocated by libgvproxy
     ///
+    /// In debug builds the library tracks every string it returns; freeing one
+    /// twice, or freeing a pointer it never returned, is not forwarded to
+    /// `free` but logged and reported as an `ffi_misuse` event.
+    ///
     /// # Arguments
-    /// * `str` - Pointer to string returned by gvproxy functions
+    /// * `str` - Pointer to string returned by gvproxy functions (NULL is a no-op)
     pub fn gvproxy_free_string(str: *mut c_char);
 
     /// Destroy a gvproxy instance and free resources
@@ -67,6 +105,55 @@ extern "C" {
     /// - Do not use pointer after calling gvproxy_free_string
     pub fn gvproxy_get_stats(id: c_longlong) -> *mut c_char;
 
+    /// Get network statistics in a specific payload format
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `format` - `"json"` (same document as gvproxy_get_stats) or `"openmetrics"`
+    ///   (OpenMetrics text exposition, servable as-is from a Prometheus scrape
+    ///   endpoint). NULL means `"json"`.
+    ///
+    /// # Returns
+    /// Pointer to the payload (must be freed with gvproxy_free_string), or NULL if
+    /// the instance does not exist, stats are unavailable, or the format is unknown
+    ///
+    /// # Safety
+    /// - `format` must be NULL or a valid null-terminated C string
+    pub fn gvproxy_get_stats_format(id: c_longlong, format: *const c_char) -> *mut c_char;
+
+    /// Get network statistics (JSON) into a caller-provided buffer
+    ///
+    /// Avoids a malloc/free pair per call for high-frequency polling. The
+    /// document is written NUL-terminated only if it fits; otherwise `buf` is
+    /// left untouched and the caller should retry with the returned size.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `buf` - Destination buffer (may be NULL when `len` is 0)
+    /// * `len` - Size of `buf` in bytes
+    ///
+    /// # Returns
+    /// Required buffer size including the terminating NUL, or -1 if the
+    /// instance does not exist or stats are unavailable
+    ///
+    /// # Safety
+    /// - `buf` must be NULL or valid for writes of `len` bytes
+    pub fn gvproxy_get_stats_buf(id: c_longlong, buf: *mut c_char, len: c_int) -> c_int;
+
+    /// List the bridge sections an instance adds to its stats document
+    ///
+    /// Returns a JSON array of `{"name": ..., "description": ...}`, one entry per
+    /// registered stats collector (e.g. `Forwards`, `Milestones`, `DNS`, `DHCP`,
+    /// `Runtime`). Upstream sections are always present and not listed.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    ///
+    /// # Returns
+    /// Pointer to JSON string (must be freed with gvproxy_free_string), or NULL if
+    /// the instance does not exist
+    pub fn gvproxy_get_stats_schema(id: c_longlong) -> *mut c_char;
+
     /// Get the libgvproxy version string
     ///
     /// # Returns
@@ -86,6 +173,619 @@ extern "C" {
     /// Pass NULL to restore default stderr logging.
     pub fn gvproxy_set_log_callback(callback: *const c_void);
 
+    /// Set the event callback for structured gvproxy events
+    ///
+    /// Events are JSON documents (`{"type": ..., "instance_id": ..., "timestamp": ...,
+    /// "fields": {...}}`) such as `inbound_connection` for published ports.
+    ///
+    /// # Arguments
+    /// * `callback` - Function pointer to Rust event callback, or NULL to disable
+    ///
+    /// # Safety
+    /// The callback must be thread-safe and must not panic. The JSON pointer is
+    /// only valid for the duration of the call.
+    pub fn gvproxy_set_event_callback(callback: *const c_void);
+
+    /// Warm an instance up before a snapshot-restored guest resumes
+    ///
+    /// Pre-resolves the instance's upstream hostnames (allow_net, secret hosts)
+    /// so the guest's first connections don't wait on cold DNS. Host forward
+    /// listeners are already bound by gvproxy_create. Blocks for at most a few
+    /// seconds.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    ///
+    /// # Returns
+    /// 0 on success, -1 if the instance does not exist
+    pub fn gvproxy_prewarm(id: c_longlong) -> c_int;
+
+    /// Export an instance's bound host forward listeners
+    ///
+    /// Returns a JSON array of `{"host_port": u16, "fd": i32}` (plus `host_ip`
+    /// or `host_socket` when the mapping has one) with a dup'd FD per
+    /// listener. Pass it as `import_listeners` when creating the replacement
+    /// instance so host clients never see the ports closed.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    ///
+    /// # Returns
+    /// Pointer to JSON string (must be freed with gvproxy_free_string), or NULL if
+    /// the instance does not exist or an FD could not be duplicated
+    ///
+    /// # Safety
+    /// The caller owns the returned FDs until they are passed to gvproxy_create,
+    /// which takes ownership of them even when it fails.
+    pub fn gvproxy_export_listeners(id: c_longlong) -> *mut c_char;
+
+    /// Send a notification datagram to the guest
+    ///
+    /// Sends `payload` as one UDP datagram from the gateway IP to the guest's
+    /// notify port (`notify_port`, default 9). Delivery is best effort.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `payload` - Pointer to the payload bytes (may be NULL if `len` is 0)
+    /// * `len` - Payload length; must fit in one frame (MTU - 28)
+    ///
+    /// # Returns
+    /// 0 on success, -1 if the instance does not exist, its network is not ready,
+    /// or the payload is too large
+    pub fn gvproxy_notify_guest(id: c_longlong, payload: *const c_char, len: c_int) -> c_int;
+
+    /// Turn per-frame tracing on or off for an instance
+    ///
+    /// Logs one debug line per frame on the VM link (direction, sequence id,
+    /// length, protocol summary), at most `rate` lines per second.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `enabled` - Non-zero to enable, 0 to disable
+    /// * `rate` - Max lines per second; <= 0 selects the default (100)
+    ///
+    /// # Returns
+    /// 0 on success, -1 if the instance does not exist
+    pub fn gvproxy_set_frame_trace(id: c_longlong, enabled: c_int, rate: c_int) -> c_int;
+
+    /// Hand every instance over for an in-place library upgrade
+    ///
+    /// Dups each instance's VM socket and host forward listeners, then stops
+    /// the instances without closing or unlinking those sockets. TCP flows
+    /// NATed through the old instances are reset; the VM link stays up.
+    ///
+    /// # Returns
+    /// JSON document to pass to `gvproxy_deserialize_all` on the new library
+    /// (must be freed with gvproxy_free_string), or NULL if a socket could not
+    /// be exported, in which case every instance keeps running
+    pub fn gvproxy_serialize_all() -> *mut c_char;
+
+    /// Restore instances handed over by `gvproxy_serialize_all`
+    ///
+    /// Instances keep their original ids. Takes ownership of every FD in the
+    /// document.
+    ///
+    /// # Arguments
+    /// * `state` - Document returned by `gvproxy_serialize_all`
+    ///
+    /// # Returns
+    /// Number of instances restored, or -1 if the document is invalid
+    ///
+    /// # Safety
+    /// - `state` must be a valid null-terminated C string
+    pub fn gvproxy_deserialize_all(state: *const c_char) -> c_int;
+
+    /// Write a support bundle for an instance
+    ///
+    /// Creates `dir` if needed and writes bundle.json (manifest), config.json
+    /// (secrets redacted), tap_config.json, stats.json, connections.json,
+    /// events.json, goroutines.txt and, with `frame_ring_size` set,
+    /// frames.pcap.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `dir` - Output directory path
+    ///
+    /// # Returns
+    /// 0 on success, -1 if the instance does not exist or `dir` is not writable
+    ///
+    /// # Safety
+    /// - `dir` must be a valid null-terminated C string
+    pub fn gvproxy_collect_diagnostics(id: c_longlong, dir: *const c_char) -> c_int;
+
+    /// Destroy a gvproxy instance, tagging the teardown's logs and events
+    ///
+    /// Same as `gvproxy_destroy`, but log entries and events for the instance
+    /// carry `trace_id` while it runs. `gvproxy_create` takes a trace ID as
+    /// `trace_id` in its config, and control socket requests as the
+    /// `X-Trace-Id` header.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID to destroy
+    /// * `trace_id` - Correlation ID, or NULL for none
+    ///
+    /// # Returns
+    /// 0 on success, -1 if the instance does not exist
+    ///
+    /// # Safety
+    /// - `trace_id` must be NULL or a valid null-terminated C string
+    pub fn gvproxy_destroy_traced(id: c_longlong, trace_id: *const c_char) -> c_int;
+
+    /// Open a connection to a guest port from inside the virtual network
+    ///
+    /// Connects from the gateway to `guest_ip:port` and returns one end of a
+    /// socketpair bridged to the connection: a stream socket for TCP (EOF is
+    /// passed on as a half-close), a datagram socket for UDP. No host port or
+    /// forward is involved. The bridge is cut when the instance is destroyed.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `proto` - `"tcp"` or `"udp"`; NULL means `"tcp"`
+    /// * `port` - Guest port
+    ///
+    /// # Returns
+    /// Connected socket FD owned by the caller, or -1 if the instance does not
+    /// exist, its network is not ready, the protocol is unknown, or the guest
+    /// refused the connection
+    ///
+    /// # Safety
+    /// - `proto` must be NULL or a valid null-terminated C string
+    pub fn gvproxy_dial_guest(id: c_longlong, proto: *const c_char, port: c_int) -> c_int;
+
+    /// Receive guest connections to a virtual gateway port
+    ///
+    /// Binds `gateway_ip:port` inside the virtual network and returns an FD
+    /// stream: a Unix stream socket on which every connection the guest opens
+    /// to that port arrives as a connected FD (`SCM_RIGHTS`, one byte of
+    /// payload per FD). No TCP listener is bound on the host.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `port` - Gateway port to serve
+    ///
+    /// # Returns
+    /// Stream FD owned by the caller (closing it unbinds the port), or -1 if
+    /// the instance does not exist, its network is not ready, or the port is
+    /// already bound in the virtual network
+    ///
+    /// # Safety
+    /// The caller owns every FD received on the stream.
+    pub fn gvproxy_listen_guest(id: c_longlong, port: c_int) -> c_int;
+
+    /// Set the callback approving guest connections to gateway services
+    ///
+    /// Called before each connection the guest opens to a TCP service hosted
+    /// on the gateway (test services, `gvproxy_listen_guest` ports) is served.
+    /// Denied connections are closed and reported as `service_denied` events.
+    /// Without a callback every connection is served.
+    ///
+    /// # Arguments
+    /// * `callback` - Function pointer to Rust auth callback, or NULL to disable
+    ///
+    /// # Safety
+    /// The callback must be thread-safe, must not panic, and should return
+    /// quickly. The JSON pointer is only valid for the duration of the call.
+    pub fn gvproxy_set_service_auth_callback(callback: *const c_void);
+
+    /// Add a JSON log file sink, independent of the log callback
+    ///
+    /// Entries at or above the sink's level are appended to `path` as one
+    /// JSON object per line; the file is rotated by size (`path.1` is the
+    /// newest backup). Replaces any previous sink.
+    ///
+    /// # Arguments
+    /// * `config_json` - `{"path": ..., "level": "debug", "max_size_mb": 10,
+    ///   "max_backups": 3}`; an empty path or NULL removes the sink
+    ///
+    /// # Returns
+    /// 0 on success, -1 if the config is invalid or the file cannot be opened
+    ///
+    /// # Safety
+    /// - `config_json` must be NULL or a valid null-terminated C string
+    pub fn gvproxy_set_log_file(config_json: *const c_char) -> c_int;
+
+    /// Check the environment before launching VMs
+    ///
+    /// Runs the bridge in-process without a VM: a Unix socket in the temp dir
+    /// (`unix_socket`), the netstack with a loopback peer answering ARP
+    /// (`netstack`), a local DNS zone query over that link (`dns`), and a
+    /// published port round trip (`forward`). Takes a few seconds at most.
+    ///
+    /// # Returns
+    /// JSON report `{"ok": bool, "checks": [{"name", "ok", "error",
+    /// "duration_ms"}]}` (must be freed with gvproxy_free_string), or NULL if
+    /// it could not be encoded
+    pub fn gvproxy_selftest() -> *mut c_char;
+
+    /// Report the sandbox this process runs in and where to put sockets
+    ///
+    /// Detects systemd PrivateTmp and the macOS App Sandbox, whose private
+    /// temp dirs a hypervisor outside the sandbox cannot reach.
+    /// gvproxy_create rejects socket paths under them unless
+    /// `allow_sandboxed_socket_paths` is set.
+    ///
+    /// # Returns
+    /// JSON `{"sandbox": ..., "private_dirs": [...], "suggested_dir": ...}`
+    /// (must be freed with gvproxy_free_string), or NULL if it could not be
+    /// encoded
+    pub fn gvproxy_suggest_socket_dir() -> *mut c_char;
+
+    /// Set library-wide limits guarding against runaway instance creation
+    ///
+    /// Limits cover every instance in the library and apply to instances
+    /// created afterwards; gvproxy_create fails with an error naming the
+    /// limit once one is reached. A capture that takes the total capture
+    /// size past `max_capture_bytes` is stopped (a `capture_stopped` event).
+    ///
+    /// # Arguments
+    /// * `limits_json` - `{"max_instances": ..., "max_total_forwards": ...,
+    ///   "max_capture_bytes": ...}`; omitted or zero fields are unlimited,
+    ///   NULL clears every limit
+    ///
+    /// # Returns
+    /// 0 on success, -1 if the JSON is invalid or a limit is negative
+    ///
+    /// # Safety
+    /// - `limits_json` must be NULL or a valid null-terminated C string
+    pub fn gvproxy_set_limits(limits_json: *const c_char) -> c_int;
+
+    /// Create a gvproxy instance from a template config plus overrides
+    ///
+    /// The overrides are merged into the template as a JSON Merge Patch
+    /// (RFC 7386): objects merge key by key, other values (arrays included)
+    /// replace, and null removes a field. Unknown keys are rejected.
+    ///
+    /// # Arguments
+    /// * `template_json` - Config shared by a fleet of VMs
+    /// * `overrides_json` - Per-instance fields, or NULL for none
+    /// * `errOut` - As in `gvproxy_create`
+    ///
+    /// # Returns
+    /// Instance ID (handle), or a negative `GVPROXY_ERR_*` code as in
+    /// `gvproxy_create`
+    ///
+    /// # Safety
+    /// - `template_json` must be a valid null-terminated C string
+    /// - `overrides_json` must be NULL or a valid null-terminated C string
+    pub fn gvproxy_create_from_template(
+        template_json: *const c_char,
+        overrides_json: *const c_char,
+        errOut: *mut *mut c_char,
+    ) -> c_longlong;
+
+    /// Get the gateway and guest MACs of an instance
+    ///
+    /// Includes MACs generated from `mac_prefix` when the config left
+    /// `gateway_mac` or `guest_mac` empty.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    ///
+    /// # Returns
+    /// JSON `{"gateway_mac": ..., "guest_mac": ...}` (must be freed with
+    /// gvproxy_free_string), or NULL if the instance does not exist
+    pub fn gvproxy_get_macs(id: c_longlong) -> *mut c_char;
+
+    /// Rotate an instance's packet capture
+    ///
+    /// Finalizes the current capture under `capture_file` with a UTC
+    /// timestamp before the extension, and starts a new one at
+    /// `capture_file` without stopping capture. With `capture_retention`
+    /// the finished capture is then compressed, and older ones are deleted
+    /// by age or total size.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    ///
+    /// # Returns
+    /// Path of the finished capture (must be freed with gvproxy_free_string),
+    /// or NULL if the instance does not exist, has no capture, or rotation
+    /// failed
+    pub fn gvproxy_capture_rotate(id: c_longlong) -> *mut c_char;
+
+    /// Publish a port on a running instance
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `mapping_json` - Port mapping as in `port_mappings`; `host_port`
+    ///   must be explicit, or `host_socket` or `host_vsock` given instead.
+    ///   With `"dry_run": true` the mapping is only checked and nothing is
+    ///   published.
+    ///
+    /// # Returns
+    /// 0 on success, -1 on failure (unknown instance, invalid JSON, reserved
+    /// host port, port already published or in use, or `max_total_forwards`
+    /// reached)
+    pub fn gvproxy_expose(id: c_longlong, mapping_json: *const c_char) -> c_int;
+
+    /// Unpublish a port of a running instance
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `mapping_json` - JSON naming the port: `{"host_port": 8080}`, with
+    ///   `host_ip` if it was published on one address,
+    ///   `{"host_socket": "/run/app.sock"}`, `{"host_vsock": {"port": 1024}}`
+    ///   or `{"gateway_port": 2375}`
+    ///
+    /// # Returns
+    /// 0 on success, -1 on failure (unknown instance, invalid JSON, or the
+    /// port is not published)
+    pub fn gvproxy_unexpose(id: c_longlong, mapping_json: *const c_char) -> c_int;
+
+    /// Deliver logs and events over a stream instead of callbacks
+    ///
+    /// Connects to a Unix socket (or, on Windows, a named pipe) the caller
+    /// listens on and writes one JSON object per line: `{"log": ...}`,
+    /// `{"event": ...}` or `{"dropped": n}`. Replaces any previous sink.
+    ///
+    /// # Arguments
+    /// * `config_json` - `{"address": "unix:<path>" | "npipe:<path>", "logs":
+    ///   true, "events": true, "level": "debug"}`; an empty address or NULL
+    ///   removes the sink
+    ///
+    /// # Returns
+    /// 0 on success, -1 if the config is invalid or the stream cannot be
+    /// connected
+    ///
+    /// # Safety
+    /// - `config_json` must be NULL or a valid null-terminated C string
+    pub fn gvproxy_set_stream_sink(config_json: *const c_char) -> c_int;
+
+    /// Get the stats of every instance in one call
+    ///
+    /// Returns a JSON object mapping each instance ID (as a string) to the
+    /// same document as gvproxy_get_stats. Instances are collected
+    /// concurrently, each on its own bounded control-plane workers; those
+    /// whose network is not ready yet are left out.
+    ///
+    /// # Returns
+    /// Pointer to JSON string (must be freed with gvproxy_free_string), or NULL
+    /// if serialization failed
+    pub fn gvproxy_get_all_stats() -> *mut c_char;
+
+    /// Get why the calling thread's last create failed
+    ///
+    /// Like errno, each thread sees only the errors of its own
+    /// `gvproxy_create` / `gvproxy_create_from_template` /
+    /// `gvproxy_create_with_fd` calls. Reading it clears it, and every create
+    /// clears it when it starts.
+    ///
+    /// # Returns
+    /// Why the last create returned a negative `GVPROXY_ERR_*` code (must be
+    /// freed with gvproxy_free_string), or NULL if the last create succeeded
+    /// or its error was already read
+    pub fn gvproxy_last_error() -> *mut c_char;
+
+    /// Create a gvproxy instance on a VM link socket the caller opened
+    ///
+    /// Like `gvproxy_create`, but the link is `fd` (typically one end of a
+    /// socketpair) instead of a socket created at `socket_path`, which may
+    /// then be omitted. The socket type must match the protocol:
+    /// SOCK_STREAM (qemu), SOCK_SEQPACKET (bess) or SOCK_DGRAM (vfkit),
+    /// connected or listening.
+    ///
+    /// # Arguments
+    /// * `config_json` - JSON-encoded config, as in `gvproxy_create`
+    /// * `fd` - The link socket; owned by the library from this call on,
+    ///   closed when the instance is destroyed or the create fails
+    /// * `errOut` - As in `gvproxy_create`
+    ///
+    /// # Returns
+    /// Instance ID (handle), or a negative `GVPROXY_ERR_*` code as in
+    /// `gvproxy_create`
+    ///
+    /// # Safety
+    /// - `config_json` must be a valid null-terminated C string
+    pub fn gvproxy_create_with_fd(
+        config_json: *const c_char,
+        fd: c_int,
+        errOut: *mut *mut c_char,
+    ) -> c_longlong;
+
+    /// Forget the MACs an instance's switch has learned and the neighbors
+    /// its netstack has resolved
+    ///
+    /// For use after the guest's network was reconfigured inside the VM,
+    /// when stale entries misdeliver frames. Both tables are learned again
+    /// from the guest's next frames.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID
+    ///
+    /// # Returns
+    /// `{"mac_entries": N, "neighbor_entries": N}` with the entries flushed
+    /// (must be freed with gvproxy_free_string), or NULL if the instance
+    /// does not exist or its network is not up yet
+    pub fn gvproxy_flush_switch(id: c_longlong) -> *mut c_char;
+
+    /// Destroy a gvproxy instance and wait until it is fully torn down
+    ///
+    /// Unlike `gvproxy_destroy`, returns only once the instance's sockets are
+    /// closed, its socket files removed, its goroutines returned and its
+    /// running callbacks delivered; no callback is made for it afterwards.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID to destroy
+    /// * `timeoutMs` - Longest wait in milliseconds; 0 or less waits indefinitely
+    ///
+    /// # Returns
+    /// 0 once torn down, -1 if the instance does not exist, -2 if the timeout
+    /// expired first (teardown then completes in the background)
+    ///
+    /// # Safety
+    /// Must not be called without a timeout from a callback of the same
+    /// instance: teardown waits for that callback to return.
+    pub fn gvproxy_destroy_sync(id: c_longlong, timeoutMs: c_int) -> c_int;
+
+    /// Get a datagram socket connected to a vfkit instance's VM link
+    ///
+    /// The socket is ready for Virtualization.framework's
+    /// `VZFileHandleNetworkDeviceAttachment`; the instance treats it as the
+    /// VM. Calling it again hands out a new socket and the link moves to it.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID
+    ///
+    /// # Returns
+    /// A file descriptor owned by the caller, or -1 if the instance does not
+    /// exist, does not use the vfkit protocol or has no `socket_path`
+    pub fn gvproxy_get_fd(id: c_longlong) -> c_int;
+
+    /// Get a bounded, paged statistics document for an instance
+    ///
+    /// Like `gvproxy_get_stats`, restricted to the requested sections, with
+    /// list sections paged by `offset`/`limit` and the document capped at
+    /// `max_bytes`. List pages are reported under `"Pagination"`, sections
+    /// that did not fit under `"Omitted"`. The pages also carry the relay
+    /// connection table of `gvproxy_list_connections` as the `"Connections"`
+    /// list section.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID
+    /// * `queryJSON` - `{"sections": [...], "offset": N, "limit": N,
+    ///   "max_bytes": N}`, all optional; null for the defaults
+    ///
+    /// # Returns
+    /// JSON string (must be freed with `gvproxy_free_string`), or NULL if the
+    /// instance does not exist or is not ready, or the query is invalid
+    ///
+    /// # Safety
+    /// `queryJSON` must be null or a valid NUL-terminated string
+    pub fn gvproxy_get_stats_page(id: c_longlong, queryJSON: *const c_char) -> *mut c_char;
+
+    /// Get the privileged setup a config's `host_route` needs
+    ///
+    /// The tun device and the route to the subnet through it must be created
+    /// by a privileged component before gvproxy_create attaches to them.
+    ///
+    /// # Arguments
+    /// * `configJSON` - The gvproxy_create config, with `host_route` set
+    ///
+    /// # Returns
+    /// JSON `{"tun_name": ..., "address": ..., "subnet": ..., "commands":
+    /// [...]}` (must be freed with `gvproxy_free_string`), or NULL if the
+    /// config has no valid `host_route` or the platform has no host routes
+    ///
+    /// # Safety
+    /// `configJSON` must be a valid NUL-terminated string
+    pub fn gvproxy_host_route_plan(configJSON: *const c_char) -> *mut c_char;
+
+    /// Get an instance's config generation
+    ///
+    /// The generation is 1 at creation and bumped by every runtime change to
+    /// the instance's configuration (`gvproxy_expose`, `gvproxy_unexpose`,
+    /// the DNS record calls, `gvproxy_set_nat`), each announced by a
+    /// `config_changed` event.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned by gvproxy_create
+    ///
+    /// # Returns
+    /// The generation, or -1 if the instance does not exist
+    pub fn gvproxy_get_generation(id: c_longlong) -> c_longlong;
+
+    /// Add a DNS record to a running instance
+    ///
+    /// The zone is created if needed. An A record replaces the name's A
+    /// record; other types are added next to the name's records.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `record_json` - A record as in `dns_zones[].records` plus its zone:
+    ///   `{"zone": "myapp.local.", "name": "web", "ip": "192.168.127.2"}`
+    ///
+    /// # Returns
+    /// 0 on success, -1 on failure (unknown instance, invalid JSON or
+    /// record, or a CNAME conflict or loop)
+    pub fn gvproxy_dns_add_record(id: c_longlong, record_json: *const c_char) -> c_int;
+
+    /// Remove DNS records from a running instance
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `record_json` - `{"zone": "myapp.local.", "name": "web"}` removes
+    ///   every record of the name; with `"type"` only those of that type
+    ///
+    /// # Returns
+    /// 0 on success, -1 on failure (unknown instance, invalid JSON, or no
+    /// matching record)
+    pub fn gvproxy_dns_remove_record(id: c_longlong, record_json: *const c_char) -> c_int;
+
+    /// Turn NAT on or off for one guest address of a running instance
+    ///
+    /// Off drops the guest's new flows beyond the gateway and aborts its
+    /// established ones; published ports and the gateway's own services
+    /// keep working.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `guest_ip` - Guest IPv4 address in the instance's subnet
+    /// * `enabled` - Non-zero turns NAT on, 0 turns it off
+    ///
+    /// # Returns
+    /// 0 on success (also when nothing changed), -1 on failure (unknown
+    /// instance, or not a guest address of the subnet)
+    pub fn gvproxy_set_nat(id: c_longlong, guest_ip: *const c_char, enabled: c_int) -> c_int;
+
+    /// Arm a forced failure, for testing error handling across the FFI
+    ///
+    /// Debug builds only; release builds refuse every fault. Kinds:
+    /// `socket_bind` (gvproxy_create fails with `GVPROXY_ERR_SOCKET`),
+    /// `accept` (accepting the VM's connection fails), `stats_unavailable`
+    /// (stats calls return NULL or -1) and `slow_callback` (the event
+    /// callback is invoked `delay_ms` late).
+    ///
+    /// # Arguments
+    /// * `fault_json` - `{"kind": "socket_bind", "count": 1, "delay_ms": 0}`;
+    ///   the fault fires `count` times (0: until cleared). NULL disarms every
+    ///   fault.
+    ///
+    /// # Returns
+    /// 0 on success, -1 on failure (release build, invalid JSON, unknown
+    /// kind, or `slow_callback` without a positive `delay_ms`)
+    pub fn gvproxy_inject_fault(fault_json: *const c_char) -> c_int;
+
+    /// List the connections the instance's published ports are relaying
+    ///
+    /// The table is returned whole; `gvproxy_get_stats_page` pages it.
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    ///
+    /// # Returns
+    /// JSON array (`[{"id": 1, "peer": ..., "host_port": 8080, "guest_port":
+    /// 80, "guest": ..., "started_at": ..., "bytes": ..., "paused": "host"}]`)
+    /// that must be freed with gvproxy_free_string, or NULL if the instance
+    /// does not exist
+    pub fn gvproxy_list_connections(id: c_longlong) -> *mut c_char;
+
+    /// Stop reading one side of a relayed connection
+    ///
+    /// # Arguments
+    /// * `id` - Instance ID returned from gvproxy_create
+    /// * `conn_id` - Connection ID from gvproxy_list_connections
+    /// * `direction` - `"host"` (the guest receives nothing more), `"guest"`
+    ///   (the host client receives nothing more) or `"both"` (also NULL)
+    ///
+    /// # Returns
+    /// 0 on success, -1 on failure (unknown instance or connection, or
+    /// invalid direction)
+    pub fn gvproxy_pause_connection(
+        id: c_longlong,
+        conn_id: c_longlong,
+        direction: *const c_char,
+    ) -> c_int;
+
+    /// Resume a direction paused with gvproxy_pause_connection
+    ///
+    /// # Returns
+    /// 0 on success, -1 on failure (unknown instance or connection, or
+    /// invalid direction)
+    pub fn gvproxy_resume_connection(
+        id: c_longlong,
+        conn_id: c_longlong,
+        direction: *const c_char,
+    ) -> c_int;
 }
 
 #[cfg(test)]